@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Ledger accounts the accounting export posts to. Payments are posted to a ledger named after their method.
+const (
+	AccountSales   = "Sales"
+	AccountCGST    = "Output CGST"
+	AccountSGST    = "Output SGST"
+	AccountDebtors = "Sundry Debtors"
+)
+
+// Accounting export formats
+const (
+	ExportTally      = "tally"
+	ExportQuickBooks = "quickbooks"
+)
+
+// JournalEntry is one balanced voucher: a day's sales, or a day's payments received
+type JournalEntry struct {
+	Date      time.Time     `json:"date"`
+	Voucher   string        `json:"voucher"` // Sales or Receipt
+	Number    string        `json:"number"`
+	Narration string        `json:"narration"`
+	Lines     []JournalLine `json:"lines"`
+}
+
+// JournalLine posts an amount to one side of a ledger account
+type JournalLine struct {
+	Account string  `json:"account"`
+	Debit   float64 `json:"debit,omitempty"`
+	Credit  float64 `json:"credit,omitempty"`
+}
+
+// AccountingJournal builds the journal for [from, to), one sales and one receipt voucher per day. Sales are
+// booked against Sundry Debtors with GST split out as on the order's invoice, and taxes outside GST posted to an
+// output ledger of their own, e.g. Output VAT. Payments received clear the debtors by payment method. Tips and
+// refunds are not recorded by the till, so they do not appear.
+func AccountingJournal(ctx context.Context, from, to time.Time) ([]JournalEntry, error) {
+	orders, err := LoadOrdersBetween(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	payments, err := storeFor(ctx).Payments().ListBetween(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	menu := LoadMenu(ctx)
+	entries := []JournalEntry{}
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+		var sales, cgst, sgst float64
+		others := map[string]float64{}
+		var count int
+		for _, order := range orders {
+			if order.CreatedAt.Before(day) || !order.CreatedAt.Before(next) {
+				continue
+			}
+			invoice, err := storeFor(ctx).Invoices().FindByOrder(ctx, order.ID)
+			if errors.Is(err, ErrNotFound) {
+				invoice, err = buildInvoice(order, menu), nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			sales += invoice.TaxableValue
+			cgst += invoice.CGST
+			sgst += invoice.SGST
+			for _, tax := range invoice.Taxes {
+				if tax.Tax != "CGST" && tax.Tax != "SGST" {
+					others[tax.Tax] += tax.Amount
+				}
+			}
+			count++
+		}
+		if count > 0 {
+			sales, cgst, sgst = roundPaise(sales), roundPaise(cgst), roundPaise(sgst)
+			sale := JournalEntry{
+				Date: day, Voucher: "Sales", Number: "S-" + day.Format("20060102"),
+				Narration: fmt.Sprintf("Sales for %s (%d orders)", day.Format("02 Jan 2006"), count),
+				Lines: []JournalLine{
+					{Account: AccountDebtors},
+					{Account: AccountSales, Credit: sales},
+					{Account: AccountCGST, Credit: cgst},
+					{Account: AccountSGST, Credit: sgst},
+				},
+			}
+			debit := sales + cgst + sgst
+			for _, tax := range slices.Sorted(maps.Keys(others)) {
+				sale.Lines = append(sale.Lines, JournalLine{Account: "Output " + tax, Credit: roundPaise(others[tax])})
+				debit += roundPaise(others[tax])
+			}
+			sale.Lines[0].Debit = roundPaise(debit)
+			entries = append(entries, sale)
+		}
+
+		received := map[string]float64{}
+		var total float64
+		for _, payment := range payments {
+			// An order charged to a customer's account stays with the debtors until the account is settled
+			if payment.CreatedAt.Before(day) || !payment.CreatedAt.Before(next) || payment.Method == PaymentOnAccount {
+				continue
+			}
+			received[payment.Method] += payment.Amount
+			total += payment.Amount
+		}
+		if total > 0 {
+			receipt := JournalEntry{
+				Date: day, Voucher: "Receipt", Number: "R-" + day.Format("20060102"),
+				Narration: "Payments received on " + day.Format("02 Jan 2006"),
+			}
+			for _, method := range paymentMethods {
+				if amount := received[method]; amount > 0 {
+					receipt.Lines = append(receipt.Lines, JournalLine{Account: paymentLedger(method), Debit: roundPaise(amount)})
+				}
+			}
+			receipt.Lines = append(receipt.Lines, JournalLine{Account: AccountDebtors, Credit: roundPaise(total)})
+			entries = append(entries, receipt)
+		}
+	}
+	return entries, nil
+}
+
+// paymentLedger names the ledger a payment method is posted to, e.g. "Card" or "UPI"
+func paymentLedger(method string) string {
+	if method == PaymentUPI {
+		return "UPI"
+	}
+	return strings.ToUpper(method[:1]) + method[1:]
+}
+
+// tallyEnvelope is the import request Tally accepts for vouchers
+type tallyEnvelope struct {
+	XMLName  xml.Name       `xml:"ENVELOPE"`
+	Request  string         `xml:"HEADER>TALLYREQUEST"`
+	Report   string         `xml:"BODY>IMPORTDATA>REQUESTDESC>REPORTNAME"`
+	Messages []tallyVoucher `xml:"BODY>IMPORTDATA>REQUESTDATA>TALLYMESSAGE>VOUCHER"`
+}
+
+type tallyVoucher struct {
+	Type      string             `xml:"VCHTYPE,attr"`
+	Action    string             `xml:"ACTION,attr"`
+	Date      string             `xml:"DATE"`
+	TypeName  string             `xml:"VOUCHERTYPENAME"`
+	Number    string             `xml:"VOUCHERNUMBER"`
+	Narration string             `xml:"NARRATION"`
+	Entries   []tallyLedgerEntry `xml:"ALLLEDGERENTRIES.LIST"`
+}
+
+// tallyLedgerEntry posts to a ledger; Tally writes debits as negative, "deemed positive" amounts
+type tallyLedgerEntry struct {
+	Ledger         string `xml:"LEDGERNAME"`
+	DeemedPositive string `xml:"ISDEEMEDPOSITIVE"`
+	Amount         string `xml:"AMOUNT"`
+}
+
+// WriteTallyXML writes the journal as a Tally voucher import
+func WriteTallyXML(w io.Writer, entries []JournalEntry) error {
+	envelope := tallyEnvelope{Request: "Import Data", Report: "Vouchers"}
+	for _, entry := range entries {
+		voucher := tallyVoucher{
+			Type: entry.Voucher, Action: "Create", Date: entry.Date.Format("20060102"),
+			TypeName: entry.Voucher, Number: entry.Number, Narration: entry.Narration,
+		}
+		for _, line := range entry.Lines {
+			ledger := tallyLedgerEntry{Ledger: line.Account, DeemedPositive: "No", Amount: fmt.Sprintf("%.2f", line.Credit)}
+			if line.Debit > 0 {
+				ledger.DeemedPositive, ledger.Amount = "Yes", fmt.Sprintf("%.2f", -line.Debit)
+			}
+			voucher.Entries = append(voucher.Entries, ledger)
+		}
+		envelope.Messages = append(envelope.Messages, voucher)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(envelope); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteQuickBooksCSV writes the journal in QuickBooks' journal entry import layout, dated DD/MM/YYYY
+func WriteQuickBooksCSV(w io.Writer, entries []JournalEntry) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"JournalNo", "JournalDate", "AccountName", "Debits", "Credits", "Description"})
+	for _, entry := range entries {
+		for _, line := range entry.Lines {
+			debit, credit := "", ""
+			if line.Debit > 0 {
+				debit = fmt.Sprintf("%.2f", line.Debit)
+			}
+			if line.Credit > 0 {
+				credit = fmt.Sprintf("%.2f", line.Credit)
+			}
+			out.Write([]string{entry.Number, entry.Date.Format("02/01/2006"), line.Account, debit, credit, entry.Narration})
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// WriteAccountingExport writes the journal for [from, to) in the given format
+func WriteAccountingExport(ctx context.Context, w io.Writer, format string, from, to time.Time) error {
+	if format != ExportTally && format != ExportQuickBooks {
+		return fmt.Errorf("export format must be %s or %s", ExportTally, ExportQuickBooks)
+	}
+	entries, err := AccountingJournal(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	if format == ExportTally {
+		return WriteTallyXML(w, entries)
+	}
+	return WriteQuickBooksCSV(w, entries)
+}
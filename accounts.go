@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PaymentOnAccount is the method of a payment charging an order to a customer's credit account. It is not
+// taken at the till: no money changes hands until the account is settled.
+const PaymentOnAccount = "account"
+
+// Account entry types
+const (
+	EntryCharge     = "charge"     // An order run up on the account
+	EntrySettlement = "settlement" // Money paid towards the balance
+	EntryReversal   = "reversal"   // A charge taken back because the order could not be marked paid
+)
+
+// ErrCreditLimit is returned when a charge would take an account over its credit limit
+var ErrCreditLimit = errors.New("over the credit limit")
+
+// CreditAccount is a trusted customer's tab (khata): orders are charged to it across visits, up to the
+// credit limit, and the customer pays off the balance when it suits them
+type CreditAccount struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name        string             `bson:"name" json:"name"` // The customer's name, as on their orders
+	Phone       string             `bson:"phone,omitempty" json:"phone,omitempty"`
+	CreditLimit float64            `bson:"creditLimit" json:"creditLimit"`
+	Balance     float64            `bson:"balance" json:"balance"`                           // What the customer owes
+	ApprovedBy  string             `bson:"approvedBy,omitempty" json:"approvedBy,omitempty"` // Manager who let a flagged customer open it
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// AccountEntry is one line of an account's ledger. Charges are positive and settlements negative.
+type AccountEntry struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	AccountID  primitive.ObjectID `bson:"accountId" json:"accountId"`
+	Type       string             `bson:"type" json:"type"`
+	OrderID    primitive.ObjectID `bson:"orderId,omitempty" json:"orderId,omitzero"`
+	PaymentID  primitive.ObjectID `bson:"paymentId" json:"paymentId"`
+	Method     string             `bson:"method,omitempty" json:"method,omitempty"` // How a settlement was paid
+	Amount     float64            `bson:"amount" json:"amount"`
+	Balance    float64            `bson:"balance" json:"balance"`                           // Owed once the entry was posted
+	ApprovedBy string             `bson:"approvedBy,omitempty" json:"approvedBy,omitempty"` // Manager who let a flagged customer charge it
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// AccountStatement is an account with every entry posted to it
+type AccountStatement struct {
+	Account CreditAccount  `json:"account"`
+	Entries []AccountEntry `json:"entries"`
+}
+
+// OpenCreditAccount gives a customer a tab with the credit limit
+func OpenCreditAccount(ctx context.Context, account CreditAccount) (CreditAccount, error) {
+	account.Name = strings.TrimSpace(account.Name)
+	if account.Name == "" {
+		return CreditAccount{}, fmt.Errorf("customer name is required")
+	}
+	if account.CreditLimit <= 0 {
+		return CreditAccount{}, fmt.Errorf("credit limit must be positive")
+	}
+	account.ApprovedBy = strings.TrimSpace(account.ApprovedBy)
+	if err := checkFlag(ctx, account.Name, account.ApprovedBy); err != nil {
+		return CreditAccount{}, err
+	}
+	account.ID, account.Balance, account.CreatedAt = primitive.NewObjectID(), 0, clock.Now()
+	if account.Phone == "" {
+		account.Phone = customerPhone(ctx, account.Name)
+	}
+	err := storeFor(ctx).CreditAccounts().Insert(ctx, account)
+	if errors.Is(err, ErrDuplicate) {
+		return CreditAccount{}, fmt.Errorf("%s already has an account: %w", account.Name, ErrDuplicate)
+	}
+	return account, err
+}
+
+// FindCreditAccount loads an account by ID
+func FindCreditAccount(ctx context.Context, id primitive.ObjectID) (CreditAccount, error) {
+	return storeFor(ctx).CreditAccounts().Find(ctx, id)
+}
+
+// ListCreditAccounts returns every account with its balance, by customer name
+func ListCreditAccounts(ctx context.Context) ([]CreditAccount, error) {
+	accounts, err := storeFor(ctx).CreditAccounts().List(ctx)
+	if accounts == nil {
+		accounts = []CreditAccount{}
+	}
+	return accounts, err
+}
+
+// UpdateCreditAccount changes an account's phone number and credit limit. Lowering the limit below the
+// balance stops further charges until enough is settled.
+func UpdateCreditAccount(ctx context.Context, id primitive.ObjectID, change CreditAccount) (CreditAccount, error) {
+	if change.CreditLimit <= 0 {
+		return CreditAccount{}, fmt.Errorf("credit limit must be positive")
+	}
+	account, err := FindCreditAccount(ctx, id)
+	if err != nil {
+		return CreditAccount{}, err
+	}
+	account.Phone, account.CreditLimit = change.Phone, change.CreditLimit
+	return account, storeFor(ctx).CreditAccounts().Update(ctx, account)
+}
+
+// ChargeToAccount runs up what is left to pay on an order on the customer's account, settling the order.
+// The charge is refused if it would take the balance over the credit limit, and with a *FlaggedError if
+// the customer has been flagged since the account was opened, unless approvedBy names a manager.
+func ChargeToAccount(ctx context.Context, id, orderID primitive.ObjectID, approvedBy string) (CreditAccount, error) {
+	if IsOffline() {
+		return CreditAccount{}, fmt.Errorf("orders can only be charged to an account while the database is reachable")
+	}
+	order, err := FindOrder(ctx, orderID)
+	if err != nil {
+		return CreditAccount{}, err
+	}
+	due := roundPaise(order.Total - order.AmountPaid)
+	if due <= 0 {
+		return CreditAccount{}, fmt.Errorf("the order is already paid")
+	}
+	account, err := FindCreditAccount(ctx, id)
+	if err != nil {
+		return CreditAccount{}, err
+	}
+	approvedBy = strings.TrimSpace(approvedBy)
+	if err := checkFlag(ctx, account.Name, approvedBy); err != nil {
+		return CreditAccount{}, err
+	}
+	payment := Payment{ID: primitive.NewObjectID(), OrderID: orderID, AccountID: id, Method: PaymentOnAccount, Amount: due, CreatedAt: clock.Now()}
+	entry := AccountEntry{
+		ID: primitive.NewObjectID(), AccountID: id, Type: EntryCharge, OrderID: orderID,
+		PaymentID: payment.ID, Amount: due, ApprovedBy: approvedBy, CreatedAt: payment.CreatedAt,
+	}
+	account, err = storeFor(ctx).CreditAccounts().Post(ctx, entry)
+	if errors.Is(err, ErrCreditLimit) {
+		account, _ = FindCreditAccount(ctx, id)
+		return CreditAccount{}, fmt.Errorf("Rs %.2f would take the account %w of Rs %.2f (Rs %.2f owed)", due, ErrCreditLimit, account.CreditLimit, account.Balance)
+	}
+	if err != nil {
+		return CreditAccount{}, err
+	}
+	if err := insertPayment(ctx, payment); err != nil {
+		// The order was not charged after all, so give the credit back
+		entry.ID, entry.Type, entry.Amount, entry.CreatedAt = primitive.NewObjectID(), EntryReversal, -due, clock.Now()
+		if _, undo := storeFor(ctx).CreditAccounts().Post(ctx, entry); undo != nil {
+			log.Printf("Could not reverse the charge of Rs %.2f to %s: %v", due, account.Name, undo)
+		}
+		return CreditAccount{}, err
+	}
+	return account, nil
+}
+
+// SettleAccount records money paid towards an account's balance, in part or in full
+func SettleAccount(ctx context.Context, id primitive.ObjectID, payment Payment) (CreditAccount, error) {
+	if IsOffline() {
+		return CreditAccount{}, fmt.Errorf("accounts can only be settled while the database is reachable")
+	}
+	account, err := FindCreditAccount(ctx, id)
+	if err != nil {
+		return CreditAccount{}, err
+	}
+	payment.ID, payment.OrderID, payment.AccountID, payment.CreatedAt = primitive.NewObjectID(), primitive.NilObjectID, id, clock.Now()
+	if err := ValidatePayment(payment); err != nil {
+		return CreditAccount{}, err
+	}
+	if payment.Amount > account.Balance {
+		return CreditAccount{}, fmt.Errorf("only Rs %.2f is owed", account.Balance)
+	}
+	if payment.Method == PaymentCash {
+		if payment.DrawerID, err = openDrawerID(ctx); err != nil {
+			return CreditAccount{}, err
+		}
+	}
+	if err := assignShift(ctx, &payment); err != nil {
+		return CreditAccount{}, err
+	}
+	paymentDevice(ctx, &payment)
+	if err := storeFor(ctx).Payments().Insert(ctx, payment); err != nil {
+		return CreditAccount{}, err
+	}
+	return storeFor(ctx).CreditAccounts().Post(ctx, AccountEntry{
+		ID: primitive.NewObjectID(), AccountID: id, Type: EntrySettlement, PaymentID: payment.ID,
+		Method: payment.Method, Amount: -payment.Amount, CreatedAt: payment.CreatedAt,
+	})
+}
+
+// StatementFor returns the account with its whole ledger
+func StatementFor(ctx context.Context, id primitive.ObjectID) (AccountStatement, error) {
+	account, err := FindCreditAccount(ctx, id)
+	if err != nil {
+		return AccountStatement{}, err
+	}
+	entries, err := storeFor(ctx).CreditAccounts().ListEntries(ctx, id)
+	if entries == nil {
+		entries = []AccountEntry{}
+	}
+	return AccountStatement{Account: account, Entries: entries}, err
+}
+
+// WriteAccountStatement prints the statement for handing to the customer: each charge and settlement
+// with the running balance, and what is outstanding
+func WriteAccountStatement(w io.Writer, statement AccountStatement) error {
+	account := statement.Account
+	fmt.Fprintf(w, "Statement of account for %s", account.Name)
+	if account.Phone != "" {
+		fmt.Fprintf(w, " (%s)", account.Phone)
+	}
+	fmt.Fprintf(w, ", %s\n", clock.Now().Format("02 Jan 2006"))
+	fmt.Fprintf(w, "Credit limit: Rs %.2f\n\n", account.CreditLimit)
+	fmt.Fprintf(w, "%-17s  %-32s %10s %10s\n", "Date", "Details", "Amount", "Balance")
+	for _, entry := range statement.Entries {
+		var details string
+		switch entry.Type {
+		case EntryCharge:
+			details = "Order " + entry.OrderID.Hex()
+		case EntrySettlement:
+			details = "Paid by " + paymentLedger(entry.Method)
+		default:
+			details = "Reversal of order " + entry.OrderID.Hex()
+		}
+		fmt.Fprintf(w, "%-17s  %-32s %10.2f %10.2f\n", entry.CreatedAt.Format("02 Jan 2006 15:04"), details, entry.Amount, entry.Balance)
+	}
+	_, err := fmt.Fprintf(w, "\nOutstanding: Rs %.2f\n", account.Balance)
+	return err
+}
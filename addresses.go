@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxAddressLabel caps a saved address's label, e.g. "home" or "office"
+	maxAddressLabel = 30
+	// maxAddresses caps how many addresses a customer can save
+	maxAddresses = 10
+)
+
+// ErrAddressNotFound is returned by a geocoder that cannot find an address on the map
+var ErrAddressNotFound = errors.New("address not found")
+
+// SavedAddress is one of a customer's addresses, under a label they pick it by
+type SavedAddress struct {
+	Label   string `bson:"label" json:"label"`
+	Address `bson:",inline"`
+}
+
+// Geocoder finds addresses on the map, returning the address tidied up (e.g. its pin code filled in) with its
+// location. It returns ErrAddressNotFound when there is no such place.
+type Geocoder interface {
+	Geocode(ctx context.Context, address Address) (Address, error)
+}
+
+// WebhookGeocoder posts addresses as JSON to an external geocoding service, which answers with the address and its
+// location, or 404 when it cannot find it
+type WebhookGeocoder struct {
+	URL    string
+	Client *http.Client
+}
+
+func (w WebhookGeocoder) Geocode(ctx context.Context, address Address) (Address, error) {
+	body, err := json.Marshal(address)
+	if err != nil {
+		return Address{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return Address{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return Address{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return Address{}, ErrAddressNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return Address{}, fmt.Errorf("geocoding webhook returned %s", resp.Status)
+	}
+	var found Address
+	if err := json.NewDecoder(resp.Body).Decode(&found); err != nil {
+		return Address{}, fmt.Errorf("reading the geocoded address: %w", err)
+	}
+	if found.Location == nil {
+		return Address{}, ErrAddressNotFound
+	}
+	return found, nil
+}
+
+// geocoder places addresses on the map; nil when none is configured. Setting GEOCODE_WEBHOOK_URL sends them to a
+// webhook.
+var geocoder = defaultGeocoder()
+
+func defaultGeocoder() Geocoder {
+	if url := os.Getenv("GEOCODE_WEBHOOK_URL"); url != "" {
+		return WebhookGeocoder{URL: url, Client: &http.Client{Timeout: 5 * time.Second}}
+	}
+	return nil
+}
+
+// cleanAddress trims the address and, with a geocoder, finds it on the map. An address the geocoder cannot find
+// is refused; when the geocoder itself fails the address is kept as given, so a broken service never stops orders.
+func cleanAddress(ctx context.Context, address Address) (Address, error) {
+	address.Line = strings.TrimSpace(address.Line)
+	address.PinCode = strings.ReplaceAll(address.PinCode, " ", "")
+	if address.Line == "" {
+		return Address{}, fmt.Errorf("an address needs a street line")
+	}
+	if address.Location != nil {
+		if err := address.Location.Validate(); err != nil {
+			return Address{}, err
+		}
+		return address, nil
+	}
+	if geocoder == nil {
+		return address, nil
+	}
+	found, err := geocoder.Geocode(ctx, address)
+	if errors.Is(err, ErrAddressNotFound) {
+		return Address{}, fmt.Errorf("%w: %s", ErrAddressNotFound, address)
+	}
+	if err != nil {
+		log.Println("Error geocoding an address:", err)
+		return address, nil
+	}
+	if found.Line = strings.TrimSpace(found.Line); found.Line == "" {
+		found.Line = address.Line
+	}
+	if found.PinCode = strings.ReplaceAll(found.PinCode, " ", ""); found.PinCode == "" {
+		found.PinCode = address.PinCode
+	}
+	return found, nil
+}
+
+// SaveCustomerAddress adds an address to the customer's book under the label, or replaces the one with that label,
+// creating the customer if needed. With a version other than 0 the customer must exist and still be at that version.
+func SaveCustomerAddress(ctx context.Context, name string, version int, label string, address Address) (Customer, error) {
+	label = strings.ToLower(strings.TrimSpace(label))
+	if label == "" || utf8.RuneCountInString(label) > maxAddressLabel {
+		return Customer{}, fmt.Errorf("an address needs a label of at most %d characters, e.g. home", maxAddressLabel)
+	}
+	address, err := cleanAddress(ctx, address)
+	if err != nil {
+		return Customer{}, err
+	}
+	return changeCustomerAddresses(ctx, name, version, func(addresses []SavedAddress) ([]SavedAddress, error) {
+		saved := SavedAddress{Label: label, Address: address}
+		if i := slices.IndexFunc(addresses, func(a SavedAddress) bool { return a.Label == label }); i >= 0 {
+			addresses[i] = saved
+			return addresses, nil
+		}
+		if len(addresses) >= maxAddresses {
+			return nil, fmt.Errorf("a customer can save at most %d addresses", maxAddresses)
+		}
+		return append(addresses, saved), nil
+	})
+}
+
+// DeleteCustomerAddress takes the address with the label out of the customer's book
+func DeleteCustomerAddress(ctx context.Context, name string, version int, label string) (Customer, error) {
+	label = strings.ToLower(strings.TrimSpace(label))
+	return changeCustomerAddresses(ctx, name, version, func(addresses []SavedAddress) ([]SavedAddress, error) {
+		i := slices.IndexFunc(addresses, func(a SavedAddress) bool { return a.Label == label })
+		if i < 0 {
+			return nil, fmt.Errorf("no address labeled %s: %w", label, ErrNotFound)
+		}
+		return slices.Delete(addresses, i, i+1), nil
+	})
+}
+
+// changeCustomerAddresses applies change to the customer's addresses and stores them. Without a version the
+// change is retried on a conflicting edit, against the addresses it brought.
+func changeCustomerAddresses(ctx context.Context, name string, version int,
+	change func([]SavedAddress) ([]SavedAddress, error)) (Customer, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Customer{}, fmt.Errorf("customer name is required")
+	}
+	for {
+		customer, err := storeFor(ctx).Customers().FindByName(ctx, name)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return Customer{}, err
+		}
+		if version != 0 && (errors.Is(err, ErrNotFound) || customer.Version != version) {
+			if err == nil {
+				err = ErrVersionConflict
+			}
+			return Customer{}, fmt.Errorf("%s %w", name, err)
+		}
+		addresses, err := change(append([]SavedAddress(nil), customer.Addresses...))
+		if err != nil {
+			return Customer{}, err
+		}
+		err = storeFor(ctx).Customers().SetAddresses(ctx, name, customer.Version, addresses)
+		if errors.Is(err, ErrVersionConflict) && version == 0 {
+			continue
+		}
+		if errors.Is(err, ErrVersionConflict) {
+			return Customer{}, fmt.Errorf("%s %w", name, ErrVersionConflict)
+		}
+		if err != nil {
+			return Customer{}, err
+		}
+		return storeFor(ctx).Customers().FindByName(ctx, name)
+	}
+}
+
+// CustomerAddress returns the customer's address saved under the label
+func CustomerAddress(ctx context.Context, name, label string) (Address, error) {
+	customer, err := storeFor(ctx).Customers().FindByName(ctx, strings.TrimSpace(name))
+	if err != nil {
+		return Address{}, err
+	}
+	label = strings.ToLower(strings.TrimSpace(label))
+	for _, saved := range customer.Addresses {
+		if saved.Label == label {
+			return saved.Address, nil
+		}
+	}
+	return Address{}, fmt.Errorf("%s has no address labeled %s: %w", customer.Name, label, ErrNotFound)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// IDCheck records that staff checked a guest's ID before serving them age-restricted items
+type IDCheck struct {
+	By string    `bson:"by" json:"by"`
+	At time.Time `bson:"at" json:"at"`
+}
+
+// AgeCheckError stops an order for age-restricted items until staff confirm they checked the guest's ID
+type AgeCheckError struct {
+	Items []string
+}
+
+func (e *AgeCheckError) Error() string {
+	return "ID check needed for: " + strings.Join(e.Items, ", ")
+}
+
+// dryPeriod is a day, or a span of it, when age-restricted items cannot be sold. Day is a weekday such as "fri",
+// a date (YYYY-MM-DD) or "*" for every day; a nil Window is the whole day.
+type dryPeriod struct {
+	Day    string
+	Window *TimeWindow
+}
+
+// dryPeriods are when local law bans selling alcohol, from RMS_DRY_HOURS
+var dryPeriods []dryPeriod
+
+var dryDate = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// SetDryHours sets when age-restricted items are not sold from a spec like "*=23:00-11:00,tue,2026-10-02", where
+// each entry is a weekday, a date or * for every day, optionally with the hours of it that are dry. A span past
+// midnight runs on into the next morning.
+func SetDryHours(spec string) error {
+	var periods []dryPeriod
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		day, span, hasSpan := strings.Cut(entry, "=")
+		period := dryPeriod{Day: strings.ToLower(strings.TrimSpace(day))}
+		_, weekday := dayNames[period.Day]
+		if !weekday && period.Day != "*" && !dryDate.MatchString(period.Day) {
+			return fmt.Errorf("invalid dry day %q (want a weekday like fri, a date like 2026-10-02 or *)", entry)
+		}
+		if hasSpan {
+			from, until, ok := strings.Cut(span, "-")
+			window := TimeWindow{From: strings.TrimSpace(from), Until: strings.TrimSpace(until)}
+			if !ok {
+				return fmt.Errorf("invalid dry hours %q (want day=HH:MM-HH:MM)", entry)
+			}
+			if err := window.Validate(); err != nil {
+				return fmt.Errorf("dry hours for %s: %w", period.Day, err)
+			}
+			period.Window = &window
+		}
+		periods = append(periods, period)
+	}
+	dryPeriods = periods
+	return nil
+}
+
+// onDay reports whether the period's day is the day of t
+func (p dryPeriod) onDay(t time.Time) bool {
+	weekday, ok := dayNames[p.Day]
+	return p.Day == "*" || p.Day == t.Format("2006-01-02") || ok && weekday == t.Weekday()
+}
+
+// covers reports whether t falls in the period. A window past midnight that starts on the period's day runs on into
+// the small hours of the next.
+func (p dryPeriod) covers(t time.Time) bool {
+	if p.Window == nil {
+		return p.onDay(t)
+	}
+	if !p.Window.Contains(t) {
+		return false
+	}
+	from, _ := parseClock(p.Window.From)
+	if t.Hour()*60+t.Minute() >= from {
+		return p.onDay(t)
+	}
+	return p.onDay(t.AddDate(0, 0, -1))
+}
+
+// DryAt reports whether age-restricted items cannot be sold at t
+func DryAt(t time.Time) bool {
+	for _, period := range dryPeriods {
+		if period.covers(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// dryFor reports whether the item cannot be sold at t because it is age-restricted and t is in dry hours
+func dryFor(item MenuItem, t time.Time) bool {
+	return item.AgeRestricted && DryAt(t)
+}
+
+// ageRestrictedLines names the menu items among the lines that are age-restricted
+func ageRestrictedLines(menu []MenuItem, lines []OrderLine) []string {
+	var restricted []string
+	for _, line := range lines {
+		if item, found := FindMenuItem(menu, line.Name); found && item.AgeRestricted && !line.Open {
+			restricted = append(restricted, item.Name)
+		}
+	}
+	return restricted
+}
+
+// checkIDVerified returns an *AgeCheckError naming the age-restricted items on the order unless the guest's ID was
+// checked, and records who confirmed it when it was
+func checkIDVerified(ctx context.Context, menu []MenuItem, order *Order) error {
+	if order.IDVerified != nil {
+		stampIDCheck(ctx, order.IDVerified, order.CreatedAt)
+		return nil
+	}
+	if restricted := ageRestrictedLines(menu, order.Items); len(restricted) > 0 {
+		return &AgeCheckError{Items: restricted}
+	}
+	return nil
+}
+
+// stampIDCheck fills in who confirmed the ID check and when, unless it already says
+func stampIDCheck(ctx context.Context, check *IDCheck, at time.Time) {
+	if check.By = strings.TrimSpace(check.By); check.By == "" {
+		check.By = changedBy(ctx)
+	}
+	if check.At.IsZero() {
+		check.At = at
+	}
+}
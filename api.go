@@ -0,0 +1,4345 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewAPIHandler returns the JSON HTTP API used by the admin dashboard and integrations
+func NewAPIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/menu", handleListMenu)
+	mux.HandleFunc("POST /api/menu", handleAddMenuItem)
+	mux.HandleFunc("PUT /api/menu/{name}", handleUpdateMenuItem)
+	mux.HandleFunc("DELETE /api/menu/{name}", handleDeleteMenuItem)
+	mux.HandleFunc("GET /api/menu/{name}/price", handlePriceAt)
+	mux.HandleFunc("GET /api/menu-barcodes/{code}", handleFindBarcode)
+	mux.HandleFunc("POST /api/menu/{name}/sold-out", handleSetSoldOut(true))
+	mux.HandleFunc("DELETE /api/menu/{name}/sold-out", handleSetSoldOut(false))
+	mux.HandleFunc("GET /api/stock", handleListStock)
+	mux.HandleFunc("GET /api/stock/alerts", handleStockAlerts)
+	mux.HandleFunc("GET /api/stock/expiring", handleExpiringStock)
+	mux.HandleFunc("PUT /api/stock/{name}", handleSetStock)
+	mux.HandleFunc("POST /api/stock/{name}/batches", handleReceiveStock)
+	mux.HandleFunc("GET /api/stock/transfers", handleListTransfers)
+	mux.HandleFunc("POST /api/stock/transfers", handleRequestTransfer)
+	mux.HandleFunc("POST /api/stock/transfers/{id}/dispatch", handleDispatchTransfer)
+	mux.HandleFunc("POST /api/stock/transfers/{id}/receive", handleReceiveTransfer)
+	mux.HandleFunc("POST /api/stock/transfers/{id}/cancel", handleCancelTransfer)
+	mux.HandleFunc("GET /api/menu-draft", handleGetMenuDraft)
+	mux.HandleFunc("PUT /api/menu-draft", handleSaveMenuDraft)
+	mux.HandleFunc("DELETE /api/menu-draft", handleDiscardMenuDraft)
+	mux.HandleFunc("POST /api/menu-draft/publish", handlePublishMenuDraft)
+	mux.HandleFunc("GET /api/menu-versions", handleListMenuVersions)
+	mux.HandleFunc("GET /api/menu-versions/{version}", handleGetMenuVersion)
+	mux.HandleFunc("POST /api/menu-versions/{version}/rollback", handleRollbackMenu)
+	mux.HandleFunc("POST /api/menu-price-updates", handleUpdatePrices)
+	mux.HandleFunc("POST /api/menu-price-updates/{version}/rollback", handleRollbackPrices)
+	mux.HandleFunc("GET /api/menu-branches/{branch}", handleGetBranchMenu)
+	mux.HandleFunc("PUT /api/menu-branches/{branch}/overrides/{item}", handleSetMenuOverride)
+	mux.HandleFunc("DELETE /api/menu-branches/{branch}/overrides/{item}", handleClearMenuOverride)
+	mux.HandleFunc("GET /api/menu-branches/{branch}/sync", handlePlanMenuSync)
+	mux.HandleFunc("POST /api/menu-branches/{branch}/sync", handleSyncBranchMenu)
+	mux.HandleFunc("GET /api/orders", handleListOrders)
+	mux.HandleFunc("GET /api/bills", handleUnsettledBills)
+	mux.HandleFunc("GET /api/tables", handleListTables)
+	mux.HandleFunc("POST /api/orders", idempotent(handleCreateOrder))
+	mux.HandleFunc("GET /api/orders/scheduled", handleListPreOrders)
+	mux.HandleFunc("GET /api/now-serving", handleNowServing)
+	mux.HandleFunc("POST /api/orders/{id}/advance", handleAdvanceOrder)
+	mux.HandleFunc("POST /api/orders/{id}/items", handleAddOrderItem)
+	mux.HandleFunc("POST /api/orders/{id}/items/move", handleMoveOrderItem)
+	mux.HandleFunc("POST /api/orders/{id}/items/void", handleVoidOrderItem)
+	mux.HandleFunc("POST /api/orders/{id}/items/discount", handleDiscountOrderItem)
+	mux.HandleFunc("POST /api/orders/{id}/discount", handleDiscountOrder)
+	mux.HandleFunc("POST /api/orders/{id}/reopen", handleReopenOrder)
+	mux.HandleFunc("POST /api/orders/{id}/service-charge/waive", handleWaiveServiceCharge)
+	mux.HandleFunc("POST /api/orders/{id}/receipt/reprint", handleReprintReceipt)
+	mux.HandleFunc("POST /api/orders/{id}/courses/{course}/fire", handleFireCourse)
+	mux.HandleFunc("GET /api/orders/{id}/seats", handleSeatBills)
+	mux.HandleFunc("GET /api/orders/{id}/quote", handleCurrencyQuote)
+	mux.HandleFunc("GET /api/orders/{id}/ticket", handleKitchenTicket)
+	mux.HandleFunc("POST /api/orders/{id}/ticket/print", handlePrintTickets)
+	mux.HandleFunc("GET /api/print-jobs", handleListPrintJobs)
+	mux.HandleFunc("POST /api/print-jobs/{id}/retry", handleRetryPrintJob)
+	mux.HandleFunc("GET /api/orders/{id}/events", handleOrderEvents)
+	mux.HandleFunc("POST /api/orders/{id}/payments", idempotent(handleCreatePayment))
+	mux.HandleFunc("POST /api/orders/{id}/refunds", idempotent(handleRefundOrder))
+	mux.HandleFunc("POST /api/orders/{id}/containers/return", idempotent(handleReturnContainers))
+	mux.HandleFunc("GET /api/containers", handleListContainers)
+	mux.HandleFunc("POST /api/orders/{id}/complaints", handleRaiseComplaint)
+	mux.HandleFunc("GET /api/complaints", handleListComplaints)
+	mux.HandleFunc("POST /api/complaints/{id}/resolve", idempotent(handleResolveComplaint))
+	mux.HandleFunc("GET /api/logbook", handleListLogBook)
+	mux.HandleFunc("POST /api/logbook", handleAddLogEntry)
+	mux.HandleFunc("POST /api/logbook/{id}/done", handleCompleteFollowUp)
+	mux.HandleFunc("GET /api/delivery-zones", handleListDeliveryZones)
+	mux.HandleFunc("PUT /api/delivery-zones/{name}", handleSaveDeliveryZone)
+	mux.HandleFunc("DELETE /api/delivery-zones/{name}", handleDeleteDeliveryZone)
+	mux.HandleFunc("POST /api/orders/{id}/invoice", handleIssueInvoice)
+	mux.HandleFunc("GET /api/carts", handleListParkedCarts)
+	mux.HandleFunc("POST /api/carts", handleParkCart)
+	mux.HandleFunc("POST /api/carts/recall", handleRecallCart)
+	mux.HandleFunc("DELETE /api/carts/{id}", handleDiscardParkedCart)
+	mux.HandleFunc("GET /api/standing-orders", handleListStandingOrders)
+	mux.HandleFunc("POST /api/standing-orders", handleCreateStandingOrder)
+	mux.HandleFunc("GET /api/standing-orders/{id}", handleGetStandingOrder)
+	mux.HandleFunc("POST /api/standing-orders/{id}/skip", handleSkipStandingOrder)
+	mux.HandleFunc("POST /api/standing-orders/{id}/pause", handlePauseStandingOrder)
+	mux.HandleFunc("POST /api/standing-orders/{id}/resume", handleResumeStandingOrder)
+	mux.HandleFunc("GET /api/invoices", handleListInvoices)
+	mux.HandleFunc("GET /api/invoices/{number...}", handleGetInvoice)
+	mux.HandleFunc("GET /api/drawer", handleCurrentDrawer)
+	mux.HandleFunc("POST /api/drawer/open", handleOpenDrawer)
+	mux.HandleFunc("POST /api/drawer/movements", handleCashMovement)
+	mux.HandleFunc("POST /api/drawer/close", handleCloseDrawer)
+	mux.HandleFunc("POST /api/drawer/exchanges", handleFXExchange)
+	mux.HandleFunc("GET /api/shifts", handleListShifts)
+	mux.HandleFunc("POST /api/shifts", handleOpenShift)
+	mux.HandleFunc("GET /api/shifts/{id}", handleGetShift)
+	mux.HandleFunc("POST /api/shifts/{id}/close", handleCloseShift)
+	mux.HandleFunc("GET /api/drivers/cod", handleOutstandingCOD)
+	mux.HandleFunc("POST /api/drivers/{driver}/settle", idempotent(handleSettleDriver))
+	mux.HandleFunc("GET /api/customers", handleSearchCustomers)
+	mux.HandleFunc("PUT /api/customers/{name}/dietary", handleSetCustomerDietary)
+	mux.HandleFunc("PUT /api/customers/{name}/occasions", handleSetCustomerOccasions)
+	mux.HandleFunc("PUT /api/customers/{name}/flag", handleFlagCustomer)
+	mux.HandleFunc("DELETE /api/customers/{name}/flag", handleClearCustomerFlag)
+	mux.HandleFunc("PUT /api/customers/{name}/addresses/{label}", handleSaveCustomerAddress)
+	mux.HandleFunc("DELETE /api/customers/{name}/addresses/{label}", handleDeleteCustomerAddress)
+	mux.HandleFunc("PUT /api/customers/{name}/communication", handleSetCommunicationPrefs)
+	mux.HandleFunc("POST /api/customers/{name}/unsubscribe", handleUnsubscribe)
+	mux.HandleFunc("POST /api/inbound-messages", handleInboundMessage)
+	mux.HandleFunc("POST /api/check-ins", handleCheckIn)
+	mux.HandleFunc("GET /api/coupons/{code}", handleGetCoupon)
+	mux.HandleFunc("GET /api/reports/daily-sales", handleDailySales)
+	mux.HandleFunc("GET /api/reports/dashboard", handleDashboardSummary)
+	mux.HandleFunc("GET /api/reports/sold-out", handleSoldOutReport)
+	mux.HandleFunc("GET /api/reports/price-history", handlePriceHistory)
+	mux.HandleFunc("GET /api/reports/accounting-export", handleAccountingExport)
+	mux.HandleFunc("GET /api/export/orders", handleExport(ExportOrders))
+	mux.HandleFunc("GET /api/export/order-lines", handleExport(ExportOrderLines))
+	mux.HandleFunc("GET /api/export/payments", handleExport(ExportPayments))
+	mux.HandleFunc("GET /api/feed", handleFeed)
+	mux.HandleFunc("GET /api/reports/drawer-sessions", handleDrawerSessions)
+	mux.HandleFunc("GET /api/reports/driver-settlements", handleDriverSettlements)
+	mux.HandleFunc("GET /api/reports/waiters", handleWaiterPerformance)
+	mux.HandleFunc("GET /api/reports/prep-times", handlePrepTimes)
+	mux.HandleFunc("GET /api/reports/tables", handleTableTurnover)
+	mux.HandleFunc("GET /api/reports/demand", handleDemand)
+	mux.HandleFunc("GET /api/reports/forecast", handleSalesForecast)
+	mux.HandleFunc("GET /api/reports/wastage", handleWastage)
+	mux.HandleFunc("GET /api/reports/royalties", handleRoyalties)
+	mux.HandleFunc("GET /api/reports/complaints", handleComplaintReport)
+	mux.HandleFunc("GET /api/reports/nps", handleNPSTrend)
+	mux.HandleFunc("GET /api/reports/payroll", handlePayroll)
+	mux.HandleFunc("GET /api/reports/open-items", handleOpenItems)
+	mux.HandleFunc("GET /api/reports/discounts", handleDiscountReport)
+	mux.HandleFunc("GET /api/reports/service-charge", handleServiceChargeReport)
+	mux.HandleFunc("GET /api/reports/day-summary", handleDaySummary)
+	mux.HandleFunc("GET /api/reports/staff-meals", handleStaffMealReport)
+	mux.HandleFunc("GET /api/reports/voids", handleVoidReport)
+	mux.HandleFunc("GET /api/reports/order-audit", handleOrderAudit)
+	mux.HandleFunc("GET /api/reports/fx", handleFXReport)
+	mux.HandleFunc("GET /api/reports/budget", handleBudgetReport)
+	mux.HandleFunc("GET /api/budgets", handleListBudgets)
+	mux.HandleFunc("PUT /api/budgets/{month}", handleSaveBudget)
+	mux.HandleFunc("GET /api/reservations", handleListReservations)
+	mux.HandleFunc("POST /api/reservations", handleBookReservation)
+	mux.HandleFunc("GET /api/reservations/{id}", handleGetReservation)
+	mux.HandleFunc("POST /api/reservations/{id}/deposit", idempotent(handleTakeDeposit))
+	mux.HandleFunc("POST /api/reservations/{id}/seat", handleSeatReservation)
+	mux.HandleFunc("POST /api/reservations/{id}/no-show", handleReservationNoShow)
+	mux.HandleFunc("POST /api/reservations/{id}/cancel", handleCancelReservation)
+	mux.HandleFunc("GET /api/banquets", handleListBanquets)
+	mux.HandleFunc("POST /api/banquets", handleBookBanquet)
+	mux.HandleFunc("GET /api/banquets/{id}", handleGetBanquet)
+	mux.HandleFunc("PUT /api/banquets/{id}", handleUpdateBanquet)
+	mux.HandleFunc("POST /api/banquets/{id}/advances", idempotent(handleBanquetAdvance))
+	mux.HandleFunc("POST /api/banquets/{id}/cancel", handleCancelBanquet)
+	mux.HandleFunc("GET /api/quotes", handleListQuotes)
+	mux.HandleFunc("POST /api/quotes", handleCreateQuote)
+	mux.HandleFunc("GET /api/quotes/{id}", handleGetQuote)
+	mux.HandleFunc("POST /api/quotes/{id}/send", handleSendQuote)
+	mux.HandleFunc("POST /api/quotes/{id}/convert", handleConvertQuote)
+	mux.HandleFunc("GET /api/accounts", handleListCreditAccounts)
+	mux.HandleFunc("POST /api/accounts", handleOpenCreditAccount)
+	mux.HandleFunc("GET /api/accounts/{id}", handleGetCreditAccount)
+	mux.HandleFunc("PUT /api/accounts/{id}", handleUpdateCreditAccount)
+	mux.HandleFunc("GET /api/accounts/{id}/statement", handleAccountStatement)
+	mux.HandleFunc("POST /api/accounts/{id}/charges", handleChargeToAccount)
+	mux.HandleFunc("POST /api/accounts/{id}/settlements", idempotent(handleSettleAccount))
+	mux.HandleFunc("GET /api/companies", handleListCompanies)
+	mux.HandleFunc("POST /api/companies", handleAddCompany)
+	mux.HandleFunc("GET /api/companies/{id}", handleGetCompany)
+	mux.HandleFunc("PUT /api/companies/{id}", handleUpdateCompany)
+	mux.HandleFunc("POST /api/companies/{id}/charges", handleBillToCompany)
+	mux.HandleFunc("GET /api/companies/{id}/invoices", handleListCompanyInvoices)
+	mux.HandleFunc("POST /api/companies/{id}/invoices", handleIssueCompanyInvoice)
+	mux.HandleFunc("GET /api/companies/{id}/invoices/{month}", handleGetCompanyInvoice)
+	mux.HandleFunc("GET /api/timeclock", handleListTimeEntries)
+	mux.HandleFunc("POST /api/timeclock/in", handleClockIn)
+	mux.HandleFunc("POST /api/timeclock/out", handleClockOut)
+	mux.HandleFunc("GET /api/templates", handleListTemplates)
+	mux.HandleFunc("GET /api/templates/{name}", handleGetTemplate)
+	mux.HandleFunc("PUT /api/templates/{name}", handleSaveTemplate)
+	mux.HandleFunc("GET /api/templates/{name}/versions", handleListTemplateVersions)
+	mux.HandleFunc("POST /api/templates/{name}/versions/{version}/rollback", handleRollbackTemplate)
+	mux.HandleFunc("GET /api/keys", handleListAPIKeys)
+	mux.HandleFunc("POST /api/keys", handleCreateAPIKey)
+	mux.HandleFunc("POST /api/keys/{id}/rotate", handleRotateAPIKey)
+	mux.HandleFunc("DELETE /api/keys/{id}", handleRevokeAPIKey)
+	mux.HandleFunc("GET /api/keys/{id}/usage", handleAPIKeyUsage)
+	mux.HandleFunc("POST /api/2fa/enroll", handleEnrollTwoFactor)
+	mux.HandleFunc("POST /api/2fa/confirm", handleConfirmTwoFactor)
+	mux.HandleFunc("POST /api/2fa/verify", handleVerifyTwoFactor)
+	mux.HandleFunc("POST /api/2fa/disable", handleDisableTwoFactor)
+	mux.HandleFunc("POST /api/sessions", handleStartSession)
+	mux.HandleFunc("GET /api/sessions", handleListSessions)
+	mux.HandleFunc("DELETE /api/sessions/{id}", handleRevokeSession)
+	mux.HandleFunc("GET /api/devices", handleListDevices)
+	mux.HandleFunc("POST /api/devices", handleRegisterDevice)
+	mux.HandleFunc("POST /api/devices/{id}/disable", handleDisableDevice)
+	mux.HandleFunc("POST /api/devices/{id}/enable", handleEnableDevice)
+	return mux
+}
+
+// writeJSON encodes v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
+
+// authenticateAPI checks the API key sent as "Authorization: Bearer <key>" or X-API-Key: it must be
+// live, within its rate limit and scoped for the endpoint. In SaaS mode it also points the request's
+// context at the key's tenant. Without requireKey, requests with no key at all are let through.
+func authenticateAPI(next http.Handler, requireKey bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		source := requestSource(r)
+		r = r.WithContext(context.WithValue(r.Context(), requestSourceContextKey{}, source))
+		key := r.Header.Get("X-API-Key")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			key = bearer
+		}
+		if key == "" {
+			if requireKey {
+				writeError(w, http.StatusUnauthorized, "an API key is required")
+				return
+			}
+			if ctx, ok := deviceContext(r.Context(), w, r, ""); ok {
+				next.ServeHTTP(w, r.WithContext(ctx))
+			}
+			return
+		}
+
+		record, err := AuthenticateAPIKey(r.Context(), strings.TrimSpace(key))
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, http.StatusUnauthorized, "invalid API key")
+			return
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			recordAPIKeyUsage(context.WithoutCancel(r.Context()), APIKeyUsage{
+				KeyID: record.ID, Method: r.Method, Path: r.URL.Path, Status: recorder.status, At: time.Now(),
+			})
+		}()
+
+		if !allowAPIKeyRequest(record) {
+			recorder.Header().Set("Retry-After", "60")
+			writeError(recorder, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		if scope := requiredScope(r); scope != "" && !record.Allows(scope) {
+			writeError(recorder, http.StatusForbidden, "this key lacks the "+scope+" scope")
+			return
+		}
+		ctx, ok := deviceContext(r.Context(), recorder, r, record.TenantID)
+		if !ok {
+			return
+		}
+		if _, fromDevice := DeviceFrom(ctx); requireDevice && record.Role != "" && !fromDevice {
+			writeError(recorder, http.StatusForbidden, "staff keys only work from a registered device; send its credential in the "+deviceHeader+" header")
+			return
+		}
+		// Sessions start at /api/sessions, or for admin keys with a second factor at /api/2fa, so those paths
+		// need only the key
+		if !strings.HasPrefix(r.URL.Path, "/api/2fa/") && (r.URL.Path != "/api/sessions" || r.Method != http.MethodPost) {
+			session, err := checkSession(ctx, record, strings.TrimSpace(r.Header.Get(sessionHeader)))
+			if session != nil {
+				source.Terminal = session.Terminal
+				ctx = context.WithValue(ctx, requestSourceContextKey{}, source)
+			}
+			switch {
+			case errors.Is(err, ErrTwoFactorRequired):
+				writeJSON(recorder, http.StatusUnauthorized, map[string]any{"error": err.Error(), "twoFactor": true})
+				return
+			case errors.Is(err, ErrSessionRequired):
+				writeJSON(recorder, http.StatusUnauthorized, map[string]any{"error": err.Error(), "session": true})
+				return
+			case err != nil:
+				writeError(recorder, http.StatusForbidden, err.Error())
+				return
+			}
+		}
+
+		ctx = context.WithValue(ctx, apiKeyContextKey{}, record)
+		if tenants != nil {
+			tenant, err := store.Tenants().Find(ctx, record.TenantID)
+			if err == nil {
+				ctx, err = WithTenant(ctx, tenant)
+			}
+			if err != nil {
+				writeStoreError(recorder, err)
+				return
+			}
+		}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+	})
+}
+
+// deviceContext adds the device the request was made from to ctx, when it sends a device's credential. It answers
+// the request itself and returns false if the credential is not the tenant's or the device was disabled.
+func deviceContext(ctx context.Context, w http.ResponseWriter, r *http.Request, tenantID string) (context.Context, bool) {
+	credential := strings.TrimSpace(r.Header.Get(deviceHeader))
+	if credential == "" {
+		return ctx, true
+	}
+	device, err := AuthenticateDevice(ctx, tenantID, credential)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusUnauthorized, "unknown device")
+		return nil, false
+	case errors.Is(err, ErrDeviceDisabled):
+		writeError(w, http.StatusForbidden, err.Error())
+		return nil, false
+	case err != nil:
+		writeStoreError(w, err)
+		return nil, false
+	}
+	return context.WithValue(ctx, deviceContextKey{}, device), true
+}
+
+// requiredScope is the scope a key needs for the request, "" when any key will do. Endpoints not listed need full
+// access.
+func requiredScope(r *http.Request) string {
+	read := r.Method == http.MethodGet
+	switch path := r.URL.Path; {
+	case strings.HasPrefix(path, "/api/menu"), strings.HasPrefix(path, "/api/stock"):
+		if read {
+			return ScopeMenuRead
+		}
+		return ScopeMenuWrite
+	case strings.HasPrefix(path, "/api/orders/") && (strings.HasSuffix(path, "/payments") || strings.HasSuffix(path, "/refunds") ||
+		strings.HasSuffix(path, "/receipt/reprint") || strings.HasSuffix(path, "/containers/return")),
+		strings.HasPrefix(path, "/api/reservations/") && strings.HasSuffix(path, "/deposit"),
+		strings.HasPrefix(path, "/api/complaints/") && strings.HasSuffix(path, "/resolve"),
+		strings.HasPrefix(path, "/api/banquets/") && strings.HasSuffix(path, "/advances"),
+		strings.HasPrefix(path, "/api/accounts/") && (strings.HasSuffix(path, "/charges") || strings.HasSuffix(path, "/settlements")),
+		strings.HasPrefix(path, "/api/companies/") && strings.HasSuffix(path, "/charges"):
+		return ScopePaymentsWrite
+	case strings.HasPrefix(path, "/api/drawer"), strings.HasPrefix(path, "/api/shifts"), strings.HasPrefix(path, "/api/drivers"):
+		if read {
+			return ScopeReportsRead
+		}
+		return ScopePaymentsWrite
+	case strings.HasPrefix(path, "/api/orders"), strings.HasPrefix(path, "/api/reservations"), strings.HasPrefix(path, "/api/banquets"),
+		strings.HasPrefix(path, "/api/quotes"), strings.HasPrefix(path, "/api/carts"), strings.HasPrefix(path, "/api/standing-orders"),
+		strings.HasPrefix(path, "/api/containers"), strings.HasPrefix(path, "/api/complaints"), strings.HasPrefix(path, "/api/print-jobs"):
+		if read {
+			return ScopeOrdersRead
+		}
+		return ScopeOrdersWrite
+	case path == "/api/now-serving", path == "/api/bills", path == "/api/tables", path == "/api/feed":
+		return ScopeOrdersRead
+	case strings.HasPrefix(path, "/api/delivery-zones"):
+		// Staff taking orders look the zones up; drawing them is part of setting up the menu and its prices
+		if read {
+			return ScopeOrdersRead
+		}
+		return ScopeMenuWrite
+	case strings.HasPrefix(path, "/api/customers"), strings.HasPrefix(path, "/api/accounts"), strings.HasPrefix(path, "/api/companies"),
+		path == "/api/check-ins", strings.HasPrefix(path, "/api/coupons"), path == "/api/inbound-messages":
+		if read {
+			return ScopeCustomersRead
+		}
+		return ScopeCustomersWrite
+	case strings.HasPrefix(path, "/api/reports/"), strings.HasPrefix(path, "/api/invoices"), strings.HasPrefix(path, "/api/export/"),
+		strings.HasPrefix(path, "/api/logbook") && read, strings.HasPrefix(path, "/api/budgets") && read:
+		// Writing in the log book and setting budgets are left to managers, whose keys have full access
+		return ScopeReportsRead
+	case strings.HasPrefix(path, "/api/keys"), strings.HasPrefix(path, "/api/2fa/"), strings.HasPrefix(path, "/api/devices"):
+		return ScopeKeysManage
+	case strings.HasPrefix(path, "/api/sessions"):
+		// Every key can sign in and out; listing or revoking other keys' sessions is checked by the handlers
+		return ""
+	}
+	return ScopeAll
+}
+
+// statusRecorder remembers the status code written, for the usage log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController flush the live feed through the recorder
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// handleSignup creates a tenant and returns its first API key, which is not shown again
+func handleSignup(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	tenant, key, err := SignupTenant(r.Context(), req.ID, req.Name)
+	if errors.Is(err, ErrDuplicate) {
+		writeError(w, http.StatusConflict, "tenant id is taken")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"tenant": tenant, "apiKey": key})
+}
+
+// writeError sends a JSON body of the form {"error": message}
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeStoreError maps a database error to a not found, conflict or internal error response
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if errors.Is(err, ErrVersionConflict) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	log.Println("API error:", err)
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+// ifMatchVersion reads the version a client last saw of what it is changing from the If-Match header,
+// e.g. If-Match: "3". It returns 0, which skips the check, when the header is absent.
+func ifMatchVersion(r *http.Request) (int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(header, "W/"), `"`))
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("If-Match must be a version number")
+	}
+	return version, nil
+}
+
+// handleListMenu returns the items served now, or with ?all=true the whole menu
+func handleListMenu(w http.ResponseWriter, r *http.Request) {
+	menu := LoadMenu(r.Context())
+	if r.URL.Query().Get("all") != "true" {
+		menu = AvailableMenu(menu, clock.Now())
+	}
+	if menu == nil {
+		menu = []MenuItem{}
+	}
+	writeJSON(w, http.StatusOK, menu)
+}
+
+func handleAddMenuItem(w http.ResponseWriter, r *http.Request) {
+	var item MenuItem
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if err := AddMenuItem(r.Context(), item); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, item)
+}
+
+func handleUpdateMenuItem(w http.ResponseWriter, r *http.Request) {
+	// Fields left out of the body are left unchanged
+	var body struct {
+		Price       *float64            `json:"price"`
+		Allergens   *[]string           `json:"allergens"`
+		Diets       *[]string           `json:"diets"`
+		Nutrition   *Nutrition          `json:"nutrition"`
+		Category    *string             `json:"category"`
+		Hours       *TimeWindow         `json:"hours"` // Empty from and until clear the item's own hours
+		HSN         *string             `json:"hsn"`
+		GSTRate     *float64            `json:"gstRate"`     // Negative clears the item's own rate
+		TaxClass    *string             `json:"taxClass"`    // Empty puts the item back in its category's class
+		PrepMinutes *int                `json:"prepMinutes"` // 0 clears the target prep time
+		Recipe      *[]RecipeIngredient `json:"recipe"`      // Empty clears the recipe
+		Packaging   *float64            `json:"packaging"`
+		Barcodes    *[]string           `json:"barcodes"` // Empty clears the barcodes
+		// SoldByWeight makes the price a rate per kg
+		SoldByWeight *bool `json:"soldByWeight"`
+		// ContainerDeposit of 0 stops offering the item in a reusable container
+		ContainerDeposit *float64 `json:"containerDeposit"`
+		// AgeRestricted needs the guest's ID checked to order the item, and keeps it off sale in dry hours
+		AgeRestricted *bool `json:"ageRestricted"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if err := validateNutrition(body.Nutrition); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	item, err := UpdateMenuItem(r.Context(), r.PathValue("name"), version, func(item *MenuItem) {
+		if body.Price != nil {
+			item.Price = *body.Price
+		}
+		if body.Allergens != nil {
+			item.Allergens = *body.Allergens
+		}
+		if body.Diets != nil {
+			item.Diets = *body.Diets
+		}
+		if body.Nutrition != nil {
+			item.Nutrition = body.Nutrition
+		}
+		if body.Category != nil {
+			item.Category = *body.Category
+		}
+		if body.Hours != nil {
+			item.Hours = body.Hours
+			if *body.Hours == (TimeWindow{}) {
+				item.Hours = nil
+			}
+		}
+		if body.HSN != nil {
+			item.HSN = *body.HSN
+		}
+		if body.GSTRate != nil {
+			item.GSTRate = body.GSTRate
+			if *body.GSTRate < 0 {
+				item.GSTRate = nil
+			}
+		}
+		if body.TaxClass != nil {
+			item.TaxClass = *body.TaxClass
+		}
+		if body.PrepMinutes != nil {
+			item.PrepMinutes = *body.PrepMinutes
+		}
+		if body.Recipe != nil {
+			item.Recipe = *body.Recipe
+		}
+		if body.Packaging != nil {
+			item.Packaging = *body.Packaging
+		}
+		if body.Barcodes != nil {
+			item.Barcodes = *body.Barcodes
+		}
+		if body.SoldByWeight != nil {
+			item.SoldByWeight = *body.SoldByWeight
+		}
+		if body.ContainerDeposit != nil {
+			item.ContainerDeposit = *body.ContainerDeposit
+		}
+		if body.AgeRestricted != nil {
+			item.AgeRestricted = *body.AgeRestricted
+		}
+	})
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, item)
+}
+
+// handleFindBarcode returns the menu item sold under a scanned barcode or PLU code
+func handleFindBarcode(w http.ResponseWriter, r *http.Request) {
+	item, found := FindMenuItemByBarcode(LoadMenu(r.Context()), r.PathValue("code"))
+	if !found {
+		writeError(w, http.StatusNotFound, "no menu item has barcode "+r.PathValue("code"))
+		return
+	}
+	writeJSON(w, http.StatusOK, item)
+}
+
+func handleDeleteMenuItem(w http.ResponseWriter, r *http.Request) {
+	if err := DeleteMenuItem(r.Context(), r.PathValue("name")); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListStock returns what is in stock and when it runs out
+func handleListStock(w http.ResponseWriter, r *http.Request) {
+	statuses, err := StockStatuses(r.Context(), clock.Now())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// handleStockAlerts returns the stock at or below its reorder level, whatever runs out first at the top
+func handleStockAlerts(w http.ResponseWriter, r *http.Request) {
+	alerts, err := StockAlerts(r.Context(), clock.Now())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, alerts)
+}
+
+// handleSetStock records a stock count or reorder level; fields left out of the body are left unchanged
+func handleSetStock(w http.ResponseWriter, r *http.Request) {
+	var change StockChange
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	level, err := SetStock(r.Context(), r.PathValue("name"), change)
+	if errors.Is(err, ErrVersionConflict) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, level)
+}
+
+// handleReceiveStock adds a delivery to an item already stocked
+func handleReceiveStock(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Quantity  float64  `json:"quantity"`
+		UnitCost  *float64 `json:"unitCost"` // The last cost paid when left out
+		ExpiresOn string   `json:"expiresOn"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	level, err := ReceiveStock(r.Context(), r.PathValue("name"), body.Quantity, body.UnitCost, body.ExpiresOn)
+	if errors.Is(err, ErrVersionConflict) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, level)
+}
+
+// handleExpiringStock lists the stock expiring within ?days (by default the warning days), and what has expired
+func handleExpiringStock(w http.ResponseWriter, r *http.Request) {
+	days := expiryWarningDays
+	if raw := r.URL.Query().Get("days"); raw != "" {
+		var err error
+		if days, err = strconv.Atoi(raw); err != nil || days < 0 {
+			writeError(w, http.StatusBadRequest, "days must be a whole number")
+			return
+		}
+	}
+	expiring, err := ExpiringStock(r.Context(), clock.Now(), days)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, expiring)
+}
+
+// handleWastage reports the stock written off, as expired or for voided lines, between ?from and ?to by month, by
+// default over the last 180 days
+func handleWastage(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 180)
+	if !ok {
+		return
+	}
+	months, err := WastageReport(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, months)
+}
+
+// handleListTransfers returns the stock transfers to and from this branch, newest first
+func handleListTransfers(w http.ResponseWriter, r *http.Request) {
+	transfers, err := storeFor(r.Context()).Transfers().ListForBranch(r.Context(), currentBranch)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, transfers)
+}
+
+// handleRequestTransfer raises a transfer of stock from this branch to another
+func handleRequestTransfer(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		To    string             `json:"to"`    // "main" for the main branch
+		Items map[string]float64 `json:"items"` // Quantity by stock name
+		By    string             `json:"by"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	transfer, err := RequestTransfer(r.Context(), body.To, body.Items, body.By)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, transfer)
+}
+
+// transferStep reads the transfer id and the body of a dispatch, receipt or cancellation
+func transferStep(w http.ResponseWriter, r *http.Request, body any) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid transfer id")
+		return id, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return id, false
+	}
+	return id, true
+}
+
+// writeTransfer writes the transfer after a step, or why the step could not be taken
+func writeTransfer(w http.ResponseWriter, transfer StockTransfer, err error) {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, transfer)
+}
+
+// handleDispatchTransfer confirms a transfer left this branch, taking it out of stock
+func handleDispatchTransfer(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		By string `json:"by"`
+	}
+	if id, ok := transferStep(w, r, &body); ok {
+		transfer, err := DispatchTransfer(r.Context(), id, body.By)
+		writeTransfer(w, transfer, err)
+	}
+}
+
+// handleReceiveTransfer confirms a transfer arrived at this branch, adding it to stock. Items gives what arrived
+// of those that fell short of what was dispatched.
+func handleReceiveTransfer(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		By    string             `json:"by"`
+		Items map[string]float64 `json:"items"`
+		Note  string             `json:"note"`
+	}
+	if id, ok := transferStep(w, r, &body); ok {
+		transfer, err := ReceiveTransfer(r.Context(), id, body.By, body.Items, body.Note)
+		writeTransfer(w, transfer, err)
+	}
+}
+
+// handleCancelTransfer calls off a transfer that has not been dispatched
+func handleCancelTransfer(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		By string `json:"by"`
+	}
+	if id, ok := transferStep(w, r, &body); ok {
+		transfer, err := CancelTransfer(r.Context(), id, body.By)
+		writeTransfer(w, transfer, err)
+	}
+}
+
+// handleRoyalties reports the royalty each franchised branch owes for ?month (YYYY-MM), by default last month
+func handleRoyalties(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = clock.Now().AddDate(0, -1, 0).Format("2006-01")
+	}
+	report, err := RoyaltyReport(r.Context(), month)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleComplaintReport sums up the complaints of ?month (YYYY-MM) by cause and dish, by default last month
+func handleComplaintReport(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = clock.Now().AddDate(0, -1, 0).Format("2006-01")
+	}
+	report, err := BuildComplaintReport(r.Context(), month)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleNPSTrend reports the net promoter score by month and branch from ?from to ?to (YYYY-MM), by default the
+// last six months
+func handleNPSTrend(w http.ResponseWriter, r *http.Request) {
+	from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if to == "" {
+		to = clock.Now().Format("2006-01")
+	}
+	if from == "" {
+		from = clock.Now().AddDate(0, -5, 0).Format("2006-01")
+	}
+	trend, err := NPSTrend(r.Context(), from, to)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, trend)
+}
+
+// handleRefundOrder records money handed back for an order, e.g. {"amount": 250, "reason": "cold soup", "by": "Asha"}
+func handleRefundOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	var body struct {
+		Amount float64 `json:"amount"`
+		Reason string  `json:"reason"`
+		By     string  `json:"by"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	refund, err := RefundOrder(r.Context(), id, body.Amount, body.Reason, body.By)
+	if errors.Is(err, ErrNotFound) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, refund)
+}
+
+// handleReturnContainers takes back some of an order's reusable containers and refunds their deposit
+func handleReturnContainers(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	var body struct {
+		Count int `json:"count"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	loan, refund, err := ReturnContainers(r.Context(), id, body.Count)
+	if errors.Is(err, ErrNotFound) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"loan": loan, "refund": refund})
+}
+
+// handleListContainers lists the orders whose reusable containers are not all back yet
+func handleListContainers(w http.ResponseWriter, r *http.Request) {
+	loans, err := ListOutstandingContainers(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, loans)
+}
+
+// handleRaiseComplaint records a customer's complaint about an order, e.g.
+// {"cause": "quality", "item": "Dal Makhani", "description": "cold"}
+func handleRaiseComplaint(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	var complaint Complaint
+	if err := json.NewDecoder(r.Body).Decode(&complaint); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	complaint.OrderID = id
+	complaint, err = RaiseComplaint(r.Context(), complaint)
+	if errors.Is(err, ErrNotFound) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, complaint)
+}
+
+// handleListComplaints lists the complaints raised between ?from and ?to, by default the last 30 days; ?open=true
+// leaves out those already resolved
+func handleListComplaints(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 30)
+	if !ok {
+		return
+	}
+	complaints, err := ListComplaints(r.Context(), from, to, r.URL.Query().Get("open") == "true")
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, complaints)
+}
+
+// handleListLogBook lists the log book for the business dates between ?from and ?to, by default the last 7 days,
+// in the order it was written; ?open=true lists the follow-ups still open from any date instead
+func handleListLogBook(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("open") == "true" {
+		entries, err := OpenFollowUps(r.Context())
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+		return
+	}
+	from, to, ok := dateRange(w, r, 7)
+	if !ok {
+		return
+	}
+	entries, err := ListLogBook(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// handleAddLogEntry writes an entry in the log book, e.g. {"kind": "incident", "text": "Power cut 21:10-21:30"}
+func handleAddLogEntry(w http.ResponseWriter, r *http.Request) {
+	var entry LogEntry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	entry, err := AddLogEntry(r.Context(), entry)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, entry)
+}
+
+// handleCompleteFollowUp marks a follow-up in the log book done
+func handleCompleteFollowUp(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid log entry id")
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	entry, err := CompleteFollowUp(r.Context(), id, version)
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrVersionConflict):
+		writeStoreError(w, err)
+	case err != nil:
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, entry)
+	}
+}
+
+// handleResolveComplaint puts a complaint right, e.g. {"action": "refund", "amount": 250}; 409 with the action to
+// override when the compensation is above the caller's limits
+func handleResolveComplaint(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid complaint id")
+		return
+	}
+	var body struct {
+		ComplaintResolution
+		Override Override `json:"override"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	complaint, err := ResolveComplaint(r.Context(), id, version, body.ComplaintResolution, body.Override)
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrVersionConflict):
+		writeStoreError(w, err)
+	case writeOverrideError(w, err):
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, complaint)
+	}
+}
+
+func handleListDeliveryZones(w http.ResponseWriter, r *http.Request) {
+	zones, err := ListDeliveryZones(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, zones)
+}
+
+// handleSaveDeliveryZone adds the zone named in the path or replaces it
+func handleSaveDeliveryZone(w http.ResponseWriter, r *http.Request) {
+	var zone DeliveryZone
+	if err := json.NewDecoder(r.Body).Decode(&zone); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	zone.Name = r.PathValue("name")
+	zone, err := SaveDeliveryZone(r.Context(), zone)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, zone)
+}
+
+func handleDeleteDeliveryZone(w http.ResponseWriter, r *http.Request) {
+	if err := DeleteDeliveryZone(r.Context(), r.PathValue("name")); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePriceAt returns the price the item had at ?at (RFC 3339), e.g. when an old order was placed
+func handlePriceAt(w http.ResponseWriter, r *http.Request) {
+	at := clock.Now()
+	if value := r.URL.Query().Get("at"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "at must be an RFC 3339 time, e.g. 2024-05-01T19:30:00+05:30")
+			return
+		}
+		at = parsed
+	}
+	price, err := PriceAt(r.Context(), r.PathValue("name"), at)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"item": r.PathValue("name"), "at": at, "price": price})
+}
+
+// handleSetSoldOut 86's an item for the rest of the day, or brings it back
+func handleSetSoldOut(soldOut bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		item, err := SetSoldOut(r.Context(), r.PathValue("name"), soldOut)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, item)
+	}
+}
+
+func handleGetMenuDraft(w http.ResponseWriter, r *http.Request) {
+	draft, err := LoadMenuDraft(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if draft == nil {
+		draft = []MenuItem{}
+	}
+	writeJSON(w, http.StatusOK, draft)
+}
+
+// handleSaveMenuDraft replaces the draft with the menu in the body, a list of items
+func handleSaveMenuDraft(w http.ResponseWriter, r *http.Request) {
+	var items []MenuItem
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if err := prepareMenu(items); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	items, err := SaveMenuDraft(r.Context(), items)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func handleDiscardMenuDraft(w http.ResponseWriter, r *http.Request) {
+	if err := DiscardMenuDraft(r.Context()); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handlePublishMenuDraft(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Note string `json:"note"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+	version, err := PublishMenuDraft(r.Context(), req.Note)
+	if errors.Is(err, ErrNoMenuDraft) || errors.Is(err, ErrMenuPublishedRace) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, version)
+}
+
+func handleListMenuVersions(w http.ResponseWriter, r *http.Request) {
+	versions, err := ListMenuVersions(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, versions)
+}
+
+func handleGetMenuVersion(w http.ResponseWriter, r *http.Request) {
+	number, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid menu version")
+		return
+	}
+	version, err := FindMenuVersion(r.Context(), number)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, version)
+}
+
+func handleRollbackMenu(w http.ResponseWriter, r *http.Request) {
+	number, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid menu version")
+		return
+	}
+	version, err := RollbackMenu(r.Context(), number)
+	if errors.Is(err, ErrMenuPublishedRace) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, version)
+}
+
+func handleListTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := ListTemplates(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, templates)
+}
+
+func handleGetTemplate(w http.ResponseWriter, r *http.Request) {
+	template, err := FindTemplate(r.Context(), r.PathValue("name"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, template)
+}
+
+// handleSaveTemplate rewords a template as a new version; If-Match names the version it was edited from
+func handleSaveTemplate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Subject string `json:"subject"`
+		Body    string `json:"body"`
+		Note    string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	template, err := SaveTemplate(r.Context(), r.PathValue("name"), version, req.Subject, req.Body, req.Note)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, template)
+}
+
+func handleListTemplateVersions(w http.ResponseWriter, r *http.Request) {
+	versions, err := ListTemplateVersions(r.Context(), r.PathValue("name"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, versions)
+}
+
+func handleRollbackTemplate(w http.ResponseWriter, r *http.Request) {
+	number, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid template version")
+		return
+	}
+	template, err := RollbackTemplate(r.Context(), r.PathValue("name"), number)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, template)
+}
+
+// handleGetBranchMenu returns a branch's overrides and the menu last synced to it
+func handleGetBranchMenu(w http.ResponseWriter, r *http.Request) {
+	menu, err := LoadBranchMenu(r.Context(), r.PathValue("branch"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, menu)
+}
+
+// writeBranchMenu writes the branch's menu after an override changed, or why it could not be changed
+func writeBranchMenu(w http.ResponseWriter, menu BranchMenu, err error) {
+	if errors.Is(err, ErrVersionConflict) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, menu)
+}
+
+// handleSetMenuOverride sets a branch's price for an item or takes it off the branch's menu, e.g.
+// {"price": 450} or {"unavailable": true}; it reaches the branch at the next sync
+func handleSetMenuOverride(w http.ResponseWriter, r *http.Request) {
+	var override MenuOverride
+	if err := json.NewDecoder(r.Body).Decode(&override); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	override.Item = r.PathValue("item")
+	menu, err := SetMenuOverride(r.Context(), r.PathValue("branch"), override)
+	writeBranchMenu(w, menu, err)
+}
+
+// handleClearMenuOverride has the branch serve the item as head office does again, from the next sync
+func handleClearMenuOverride(w http.ResponseWriter, r *http.Request) {
+	menu, err := SetMenuOverride(r.Context(), r.PathValue("branch"), MenuOverride{Item: r.PathValue("item")})
+	writeBranchMenu(w, menu, err)
+}
+
+// handlePlanMenuSync returns what syncing head office's menu to the branch would change
+func handlePlanMenuSync(w http.ResponseWriter, r *http.Request) {
+	changes, err := PlanMenuSync(r.Context(), r.PathValue("branch"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// handleSyncBranchMenu copies head office's menu to the branch and returns what changed
+func handleSyncBranchMenu(w http.ResponseWriter, r *http.Request) {
+	menu, changes, err := SyncBranchMenu(r.Context(), r.PathValue("branch"))
+	if errors.Is(err, ErrVersionConflict) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		BranchMenu
+		Changes []MenuChange `json:"changes"`
+	}{menu, changes})
+}
+
+// handleUpdatePrices changes many prices at once, e.g. {"category": "beverages", "percent": 5, "roundTo": 5}.
+// With "dryRun": true it only shows the old and new prices.
+func handleUpdatePrices(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PriceAdjustment
+		DryRun bool `json:"dryRun"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.DryRun {
+		result, err := PreviewPriceAdjustment(r.Context(), req.PriceAdjustment)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+	result, err := ApplyPriceAdjustment(r.Context(), req.PriceAdjustment)
+	if result.Version == 0 {
+		writePriceUpdateError(w, err)
+		return
+	}
+	if err != nil {
+		log.Println("API error:", err)
+	}
+	writeJSON(w, http.StatusCreated, result)
+}
+
+func handleRollbackPrices(w http.ResponseWriter, r *http.Request) {
+	number, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid menu version")
+		return
+	}
+	result, err := RollbackPriceAdjustment(r.Context(), number)
+	if err != nil {
+		writePriceUpdateError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, result)
+}
+
+// writePriceUpdateError answers a bulk price change that was refused or could not be published
+func writePriceUpdateError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrMenuPublishedRace), errors.Is(err, ErrNotPriceUpdate):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrNotFound):
+		writeStoreError(w, err)
+	default:
+		writeError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+func handleListOrders(w http.ResponseWriter, r *http.Request) {
+	orders, err := LoadKitchenQueue(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if orders == nil {
+		orders = []Order{}
+	}
+	writeJSON(w, http.StatusOK, orders)
+}
+
+// handleListPreOrders returns the scheduled orders not yet due in the kitchen, soonest first
+func handleListPreOrders(w http.ResponseWriter, r *http.Request) {
+	orders, err := LoadPreOrders(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, orders)
+}
+
+// handleUnsettledBills returns the bills of the last two days still to be paid, as far as the key's role may see them
+func handleUnsettledBills(w http.ResponseWriter, r *http.Request) {
+	orders, err := LoadUnsettledBills(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, orders)
+}
+
+// handleListTables returns the tables, or for a waiter's key only the tables they are serving
+func handleListTables(w http.ResponseWriter, r *http.Request) {
+	tables, err := LoadMyTables(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tables)
+}
+
+// orderRequest is the body accepted when creating an order; prices always come from the menu
+type orderRequest struct {
+	CustomerName string             `json:"customerName"`
+	Table        int                `json:"table"`
+	Type         string             `json:"type"`
+	Waiter       string             `json:"waiter"`
+	Covers       int                `json:"covers"`
+	Notes        string             `json:"notes"`
+	Items        []orderItemRequest `json:"items"`
+	// PickupAt makes a takeaway pre-order, which reaches the kitchen in time to be ready then
+	PickupAt *time.Time `json:"pickupAt"`
+	// ReusableContainers packs a takeaway or delivery order in the restaurant's containers, for a refundable deposit
+	ReusableContainers bool `json:"reusableContainers"`
+	// DeliveryAddress is where a delivery order goes; it must fall in one of the delivery zones, if there are any
+	DeliveryAddress *Address `json:"deliveryAddress"`
+	// AddressLabel picks the delivery address from the customer's address book instead
+	AddressLabel string `json:"addressLabel"`
+	// AllowAllergens sends the order even if the customer is allergic to an item, once staff have checked with them
+	AllowAllergens bool `json:"allowAllergens"`
+	// IgnoreMenuHours allows items outside their serving hours; it needs the menu:write scope
+	IgnoreMenuHours bool `json:"ignoreMenuHours"`
+	// IDVerified confirms staff checked the guest's ID, needed to order age-restricted items
+	IDVerified bool `json:"idVerified"`
+}
+
+// orderResponse is a created order with any dietary warnings that did not stop it
+type orderResponse struct {
+	Order
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// canIgnoreMenuHours reports whether the caller may override serving hours: only keys that can change the menu may
+func canIgnoreMenuHours(r *http.Request) bool {
+	record, ok := APIKeyFrom(r.Context())
+	return !ok || record.Allows(ScopeMenuWrite)
+}
+
+// approvalAllowed reports whether the caller may name a manager approving a request: keys need the
+// manager:approve scope
+func approvalAllowed(w http.ResponseWriter, r *http.Request, approvedBy string) bool {
+	if strings.TrimSpace(approvedBy) == "" {
+		return true
+	}
+	if record, ok := APIKeyFrom(r.Context()); ok && !record.Allows(ScopeManagerApprove) {
+		writeError(w, http.StatusForbidden, "approvedBy needs a key with the "+ScopeManagerApprove+" scope")
+		return false
+	}
+	return true
+}
+
+// writeFlaggedError answers 409 with the customer's flag, so the client can get a manager to approve and retry
+func writeFlaggedError(w http.ResponseWriter, err *FlaggedError) {
+	writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error(), "flag": err.Flag})
+}
+
+// writeUnavailableError answers 409 with the items that cannot be ordered now
+func writeUnavailableError(w http.ResponseWriter, err *UnavailableError) {
+	writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error(), "items": err.Items})
+}
+
+// writeAllergyError answers 409 with the conflicting items, so the client can ask and retry with allowAllergens
+func writeAllergyError(w http.ResponseWriter, err *AllergyError) {
+	writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error(), "allergies": err.Conflicts})
+}
+
+// writeAgeCheckError answers 409 with the age-restricted items, so the client can check the guest's ID and retry
+// with idVerified
+func writeAgeCheckError(w http.ResponseWriter, err *AgeCheckError) {
+	writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error(), "ageRestricted": err.Items})
+}
+
+// orderItemRequest is one line of an order, naming a menu item or giving its scanned barcode
+type orderItemRequest struct {
+	Name     string   `json:"name"`
+	Barcode  string   `json:"barcode,omitempty"` // Barcode or PLU code, instead of the name
+	Quantity int      `json:"quantity"`
+	Course   int      `json:"course,omitempty"` // 2 or more holds the line until its course is fired
+	Seat     int      `json:"seat,omitempty"`   // Guest seat, for splitting the bill
+	Note     string   `json:"note,omitempty"`   // Special instructions for the kitchen
+	Flags    []string `json:"flags,omitempty"`  // ALLERGY or RUSH
+	Weight   float64  `json:"weight,omitempty"` // Kilograms of each, for an item sold by weight
+	// Open makes the line an open item, not on the menu, described by Name and charged at Price
+	Open  bool    `json:"open,omitempty"`
+	Price float64 `json:"price,omitempty"`
+	// AllowAllergens, IgnoreMenuHours and IDVerified are only read when adding to an existing order
+	AllowAllergens  bool `json:"allowAllergens,omitempty"`
+	IgnoreMenuHours bool `json:"ignoreMenuHours,omitempty"`
+	IDVerified      bool `json:"idVerified,omitempty"`
+}
+
+// requestLine builds the order line a request asks for: an open item with the description and price given, or a menu
+// item, weighed if it is sold by weight
+func requestLine(ctx context.Context, menu []MenuItem, line orderItemRequest) (OrderLine, error) {
+	var added OrderLine
+	if line.Open {
+		if line.Barcode != "" || line.Weight != 0 {
+			return OrderLine{}, fmt.Errorf("an open item has no barcode or weight")
+		}
+		open, err := OpenItemLine(ctx, menu, line.Name, line.Price, line.Quantity)
+		if err != nil {
+			return OrderLine{}, err
+		}
+		added = open
+	} else {
+		if line.Price != 0 {
+			return OrderLine{}, fmt.Errorf("only open items are given a price")
+		}
+		item, err := findOrderItem(menu, line)
+		if err != nil {
+			return OrderLine{}, err
+		}
+		added = AddToCart(nil, item, line.Quantity)[0]
+		if err := weighLine(&added, item, line.Weight); err != nil {
+			return OrderLine{}, err
+		}
+	}
+	added.Course, added.Seat, added.Note, added.Flags = line.Course, line.Seat, line.Note, line.Flags
+	return added, nil
+}
+
+// findOrderItem looks up the menu item a line names, or whose barcode it gives
+func findOrderItem(menu []MenuItem, line orderItemRequest) (MenuItem, error) {
+	if line.Barcode != "" {
+		item, found := FindMenuItemByBarcode(menu, line.Barcode)
+		if !found {
+			return MenuItem{}, fmt.Errorf("no menu item has barcode %s", strings.TrimSpace(line.Barcode))
+		}
+		return item, nil
+	}
+	item, found := FindMenuItem(menu, line.Name)
+	if !found {
+		return MenuItem{}, fmt.Errorf("item %s not found in menu", line.Name)
+	}
+	return item, nil
+}
+
+func handleCreateOrder(w http.ResponseWriter, r *http.Request) {
+	var req orderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if strings.TrimSpace(req.CustomerName) == "" {
+		writeError(w, http.StatusBadRequest, "customerName is required")
+		return
+	}
+	if req.Type != "" && req.Type != OrderDineIn && req.Type != OrderTakeaway && req.Type != OrderDelivery && req.Type != OrderStaffMeal {
+		writeError(w, http.StatusBadRequest, "type must be dine-in, takeaway, delivery or staff-meal")
+		return
+	}
+	if req.Covers < 0 {
+		writeError(w, http.StatusBadRequest, "covers must not be negative")
+		return
+	}
+
+	menu := LoadMenu(r.Context())
+	order := Order{
+		CustomerName: strings.TrimSpace(req.CustomerName), Table: req.Table, Type: req.Type,
+		Waiter: strings.TrimSpace(req.Waiter), Covers: req.Covers, Notes: req.Notes, ScheduledFor: req.PickupAt,
+		ReusableContainers: req.ReusableContainers, DeliveryAddress: req.DeliveryAddress,
+	}
+	if order.ScheduledFor != nil && order.Type == "" {
+		order.Type = OrderTakeaway
+	}
+	if req.AddressLabel != "" && order.DeliveryAddress == nil {
+		address, err := CustomerAddress(r.Context(), order.CustomerName, req.AddressLabel)
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, http.StatusBadRequest, "no address labeled "+req.AddressLabel+" for "+order.CustomerName)
+			return
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		order.DeliveryAddress = &address
+	}
+	for _, line := range req.Items {
+		if line.Quantity < 1 {
+			writeError(w, http.StatusBadRequest, "quantity must be at least 1")
+			return
+		}
+		if line.Course < 0 || line.Seat < 0 {
+			writeError(w, http.StatusBadRequest, "course and seat must not be negative")
+			return
+		}
+		added, err := requestLine(r.Context(), menu, line)
+		if errors.Is(err, ErrOpenItemNotAllowed) {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		order.Items = addLine(order.Items, added)
+	}
+	if len(order.Items) == 0 {
+		writeError(w, http.StatusBadRequest, "an order needs at least one item")
+		return
+	}
+	if err := cleanOrderInstructions(&order); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.IgnoreMenuHours && !canIgnoreMenuHours(r) {
+		writeError(w, http.StatusForbidden, "ignoring menu hours needs the "+ScopeMenuWrite+" scope")
+		return
+	}
+	order.AllergyOverride = req.AllowAllergens
+	order.IgnoreMenuHours = req.IgnoreMenuHours
+	if req.IDVerified {
+		order.IDVerified = &IDCheck{}
+	}
+	var warnings []string
+	if !IsOffline() {
+		check, err := CheckDietary(r.Context(), order.CustomerName, order.Items)
+		if err != nil && !storeFor(r.Context()).Unavailable(err) {
+			writeStoreError(w, err)
+			return
+		}
+		warnings = check.Warnings
+	}
+
+	order, err := SubmitOrder(r.Context(), order)
+	if errors.Is(err, ErrPickupTime) || errors.Is(err, ErrDeliveryAddress) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var allergyErr *AllergyError
+	if errors.As(err, &allergyErr) {
+		writeAllergyError(w, allergyErr)
+		return
+	}
+	var ageErr *AgeCheckError
+	if errors.As(err, &ageErr) {
+		writeAgeCheckError(w, ageErr)
+		return
+	}
+	var unavailableErr *UnavailableError
+	if errors.As(err, &unavailableErr) {
+		writeUnavailableError(w, unavailableErr)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, orderResponse{Order: order, Warnings: warnings})
+}
+
+func handleNowServing(w http.ResponseWriter, r *http.Request) {
+	serving, err := LoadNowServing(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, serving)
+}
+
+func handleAdvanceOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	order, err := FindOrder(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	next, err := AdvanceOrderStatus(r.Context(), order, version)
+	if errors.Is(err, ErrVersionConflict) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	// Reload for the new version, which the client needs for its next change
+	if updated, err := FindOrder(r.Context(), id); err == nil {
+		order = updated
+	}
+	order.Status = next
+	writeJSON(w, http.StatusOK, order)
+}
+
+func handleAddOrderItem(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	var req orderItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Quantity < 1 {
+		writeError(w, http.StatusBadRequest, "quantity must be at least 1")
+		return
+	}
+	menu := LoadMenu(r.Context())
+	want, err := requestLine(r.Context(), menu, req)
+	if errors.Is(err, ErrOpenItemNotAllowed) {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	item := MenuItem{Name: want.Name, Price: want.Price}
+	if !want.Open {
+		item, _ = FindMenuItem(menu, want.Name)
+	}
+	if err := cleanLine(&want); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.IgnoreMenuHours && !canIgnoreMenuHours(r) {
+		writeError(w, http.StatusForbidden, "ignoring menu hours needs the "+ScopeMenuWrite+" scope")
+		return
+	}
+	var unavailableErr *UnavailableError
+	if errors.As(checkAvailability([]MenuItem{item}, []OrderLine{{Name: item.Name}}, clock.Now(), req.IgnoreMenuHours || ignoreMenuHours), &unavailableErr) {
+		writeUnavailableError(w, unavailableErr)
+		return
+	}
+
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	order, err := AddOrderItem(r.Context(), id, version, item, want, req.AllowAllergens, req.IDVerified)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+		writeStoreError(w, err)
+		return
+	}
+	var allergyErr *AllergyError
+	if errors.As(err, &allergyErr) {
+		writeAllergyError(w, allergyErr)
+		return
+	}
+	var ageErr *AgeCheckError
+	if errors.As(err, &ageErr) {
+		writeAgeCheckError(w, ageErr)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
+
+func handleMoveOrderItem(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	var move ItemMove
+	if err := json.NewDecoder(r.Body).Decode(&move); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	order, err := MoveOrderItem(r.Context(), id, version, move)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
+
+// overrideRequest is the body of a void, discount or reopening, with the manager's override when one is needed
+type overrideRequest struct {
+	ItemVoid
+	Percent  float64  `json:"percent"` // Discounts only
+	Code     string   `json:"code"`    // Discounts only: staff-meal, manager-comp, promo or complaint
+	Reason   string   `json:"reason"`
+	Override Override `json:"override"`
+}
+
+// overrideStep reads the order id, body and If-Match version of a void, discount, reopening or service charge waiver
+func overrideStep(w http.ResponseWriter, r *http.Request, act func(id primitive.ObjectID, version int, req overrideRequest) (Order, error)) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	var req overrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	order, err := act(id, version, req)
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrVersionConflict):
+		writeStoreError(w, err)
+	case writeOverrideError(w, err):
+	case err != nil:
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, order)
+	}
+}
+
+// writeOverrideError answers an action that needs a manager's override, or whose override was refused, reporting
+// whether err was one
+func writeOverrideError(w http.ResponseWriter, err error) bool {
+	var overrideErr *OverrideError
+	switch {
+	case errors.As(err, &overrideErr):
+		writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error(), "override": overrideErr.Action})
+	case errors.Is(err, ErrWrongPIN):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, ErrTooManyAttempts):
+		writeError(w, http.StatusTooManyRequests, err.Error())
+	default:
+		return false
+	}
+	return true
+}
+
+// handleVoidOrderItem takes an item off an order; 409 with the action to override when it was already sent
+func handleVoidOrderItem(w http.ResponseWriter, r *http.Request) {
+	overrideStep(w, r, func(id primitive.ObjectID, version int, req overrideRequest) (Order, error) {
+		return VoidOrderItem(r.Context(), id, version, req.ItemVoid, req.Reason, req.Override)
+	})
+}
+
+// handleDiscountOrder takes a percentage off an order's bill
+func handleDiscountOrder(w http.ResponseWriter, r *http.Request) {
+	overrideStep(w, r, func(id primitive.ObjectID, version int, req overrideRequest) (Order, error) {
+		return DiscountOrder(r.Context(), id, version, req.Percent, req.Code, req.Reason, req.Override)
+	})
+}
+
+// handleDiscountOrderItem takes a percentage off quantity of a line, or comps it at 100
+func handleDiscountOrderItem(w http.ResponseWriter, r *http.Request) {
+	overrideStep(w, r, func(id primitive.ObjectID, version int, req overrideRequest) (Order, error) {
+		discount := ItemDiscount{Name: req.Name, Course: req.Course, Seat: req.Seat, Quantity: req.Quantity, Percent: req.Percent}
+		return DiscountOrderItem(r.Context(), id, version, discount, req.Code, req.Reason, req.Override)
+	})
+}
+
+// handleReopenOrder reopens a served order with a manager's override
+func handleReopenOrder(w http.ResponseWriter, r *http.Request) {
+	overrideStep(w, r, func(id primitive.ObjectID, _ int, req overrideRequest) (Order, error) {
+		return ReopenOrder(r.Context(), id, req.Reason, req.Override)
+	})
+}
+
+// handleWaiveServiceCharge takes the service charge off an order's bill at the customer's request, with the reason
+func handleWaiveServiceCharge(w http.ResponseWriter, r *http.Request) {
+	overrideStep(w, r, func(id primitive.ObjectID, version int, req overrideRequest) (Order, error) {
+		return WaiveServiceCharge(r.Context(), id, version, req.Reason)
+	})
+}
+
+func handleSeatBills(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	bills, err := LoadSeatBills(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, bills)
+}
+
+// handleCurrencyQuote quotes what is still due on an order in the foreign currency ?currency at the till's rate, or in
+// every currency taken without one
+func handleCurrencyQuote(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	currency := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("currency")))
+	if currency != "" {
+		if _, err := exchangeRate(currency); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	quotes, err := QuoteInCurrency(r.Context(), id, currency)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, quotes)
+}
+
+// handleReprintReceipt returns a settled order's receipt again as plain text for a receipt printer, marked as a
+// duplicate, with an optional reason in the body
+func handleReprintReceipt(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+	receipt, _, err := ReprintReceipt(r.Context(), id, req.Reason)
+	switch {
+	case errors.Is(err, ErrReprintNotAllowed):
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	case errors.Is(err, ErrNotFound):
+		writeStoreError(w, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, receipt)
+}
+
+// handleKitchenTicket returns the order as plain text for a kitchen printer
+func handleKitchenTicket(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	order, err := FindOrder(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, FormatKitchenTicket(order))
+}
+
+// handlePrintTickets queues the order's kitchen tickets for the station printers
+func handlePrintTickets(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	if len(printers) == 0 {
+		writeError(w, http.StatusConflict, "no printers are set up in RMS_PRINTERS")
+		return
+	}
+	order, err := PrintOrderTickets(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, order)
+}
+
+// handleListPrintJobs lists the print jobs of the last day, optionally only those with ?status= at ?station=
+func handleListPrintJobs(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != PrintQueued && status != PrintPrinted && status != PrintFailed {
+		writeError(w, http.StatusBadRequest, "status must be queued, printed or failed")
+		return
+	}
+	jobs, err := ListPrintJobs(r.Context(), status, r.URL.Query().Get("station"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func handleRetryPrintJob(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid print job id")
+		return
+	}
+	job, err := RetryPrintJob(r.Context(), id)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func handleFireCourse(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	course, err := strconv.Atoi(r.PathValue("course"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid course")
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	order, err := FireCourse(r.Context(), id, version, course)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
+
+func handleOrderEvents(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	events, err := LoadOrderHistory(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+func handleCreatePayment(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	var payment Payment
+	if err := json.NewDecoder(r.Body).Decode(&payment); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	payment.ID, payment.OrderID, payment.CreatedAt = primitive.NilObjectID, id, time.Time{}
+	payment.ReservationID, payment.BanquetID, payment.AccountID = primitive.NilObjectID, primitive.NilObjectID, primitive.NilObjectID
+	payment.CompanyID, payment.Employee = primitive.NilObjectID, ""
+	payment.DrawerID, payment.ShiftID = primitive.NilObjectID, primitive.NilObjectID
+	payment.DeviceID, payment.Device, payment.Rate = primitive.NilObjectID, "", 0
+	if err := convertPayment(&payment); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := ValidatePayment(payment); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	payment, err = RecordPayment(r.Context(), payment)
+	if errors.Is(err, ErrNoShift) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	status := http.StatusCreated
+	if IsOffline() {
+		// Queued locally; it is applied to the order once the database is reachable again
+		status = http.StatusAccepted
+	}
+	writeJSON(w, status, payment)
+}
+
+// handleOutstandingCOD returns the cash on delivery each driver holds, by the day it was collected
+func handleOutstandingCOD(w http.ResponseWriter, r *http.Request) {
+	outstanding, err := OutstandingCOD(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, outstanding)
+}
+
+// handleSettleDriver takes in the cash on delivery a driver hands over when they are back
+func handleSettleDriver(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		HandedIn float64 `json:"handedIn"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	settlement, err := SettleDriver(r.Context(), r.PathValue("driver"), req.HandedIn)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, settlement)
+}
+
+// handleCurrentDrawer returns the open drawer session with the cash expected in it, or 404 when the drawer is closed
+func handleCurrentDrawer(w http.ResponseWriter, r *http.Request) {
+	session, err := CurrentDrawer(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+func handleOpenDrawer(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Cashier      string  `json:"cashier"`
+		OpeningFloat float64 `json:"openingFloat"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	session, err := OpenDrawer(r.Context(), req.Cashier, req.OpeningFloat)
+	if errors.Is(err, ErrDrawerOpen) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, session)
+}
+
+func handleCashMovement(w http.ResponseWriter, r *http.Request) {
+	var req CashMovement
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	session, err := RecordCashMovement(r.Context(), req.Type, req.Amount, req.Reason)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusConflict, "the cash drawer is not open")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+// handleFXExchange records foreign cash taken at the till being changed into rupees
+func handleFXExchange(w http.ResponseWriter, r *http.Request) {
+	var exchange FXExchange
+	if err := json.NewDecoder(r.Body).Decode(&exchange); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	exchange.By = ""
+	exchange, err := RecordFXExchange(r.Context(), exchange)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, exchange)
+}
+
+func handleCloseDrawer(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Counted *float64 `json:"counted"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Counted == nil || *req.Counted < 0 {
+		writeError(w, http.StatusBadRequest, "counted cash is required and must not be negative")
+		return
+	}
+	session, err := CloseDrawer(r.Context(), *req.Counted)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusConflict, "the cash drawer is not open")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+func handleSearchCustomers(w http.ResponseWriter, r *http.Request) {
+	customers, err := SearchCustomers(r.Context(), r.URL.Query().Get("q"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, customers)
+}
+
+func handleSetCustomerDietary(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Allergies []string `json:"allergies"`
+		Diets     []string `json:"diets"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	customer, err := SetCustomerDietary(r.Context(), r.PathValue("name"), version, req.Allergies, req.Diets)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, customer)
+}
+
+func handleSetCustomerOccasions(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Birthday    string `json:"birthday"`
+		Anniversary string `json:"anniversary"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	customer, err := SetCustomerOccasions(r.Context(), r.PathValue("name"), version, req.Birthday, req.Anniversary)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, customer)
+}
+
+// handleSaveCustomerAddress saves an address to the customer's book under the label in the path
+func handleSaveCustomerAddress(w http.ResponseWriter, r *http.Request) {
+	var address Address
+	if err := json.NewDecoder(r.Body).Decode(&address); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	customer, err := SaveCustomerAddress(r.Context(), r.PathValue("name"), version, r.PathValue("label"), address)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, customer)
+}
+
+func handleDeleteCustomerAddress(w http.ResponseWriter, r *http.Request) {
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	customer, err := DeleteCustomerAddress(r.Context(), r.PathValue("name"), version, r.PathValue("label"))
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, customer)
+}
+
+func handleFlagCustomer(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Reason    string `json:"reason"`
+		FlaggedBy string `json:"flaggedBy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	customer, err := FlagCustomer(r.Context(), r.PathValue("name"), version, req.Reason, req.FlaggedBy)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, customer)
+}
+
+// handleSetCommunicationPrefs records the channels a customer agreed to be sent messages on, e.g.
+// {"transactional": ["sms", "email"], "marketing": ["email"]}
+func handleSetCommunicationPrefs(w http.ResponseWriter, r *http.Request) {
+	var prefs CommunicationPrefs
+	if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	customer, err := SetCommunicationPrefs(r.Context(), r.PathValue("name"), version, prefs)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, customer)
+}
+
+// handleUnsubscribe stops offers and surveys to a customer on {"channel": "sms"}, or every channel without a body
+func handleUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Channel string `json:"channel"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+	customer, err := Unsubscribe(r.Context(), r.PathValue("name"), req.Channel)
+	if errors.Is(err, ErrNotFound) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, customer)
+}
+
+// handleInboundMessage takes a customer's reply passed on by the messaging gateway, e.g.
+// {"from": "9876543210", "channel": "whatsapp", "text": "STOP"}, acting on STOP and START
+func handleInboundMessage(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		From    string `json:"from"`
+		Channel string `json:"channel"`
+		Text    string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	reply, err := HandleInboundMessage(r.Context(), req.From, req.Channel, req.Text)
+	if errors.Is(err, ErrNotFound) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, reply)
+}
+
+func handleClearCustomerFlag(w http.ResponseWriter, r *http.Request) {
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	customer, err := ClearCustomerFlag(r.Context(), r.PathValue("name"), version)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, customer)
+}
+
+// handleGetCoupon looks up a birthday or anniversary coupon a customer shows at the till
+func handleGetCoupon(w http.ResponseWriter, r *http.Request) {
+	coupon, err := FindCoupon(r.Context(), r.PathValue("code"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, coupon)
+}
+
+// handleCheckIn finds a customer at the counter by phone number, with their favorite items. An unknown
+// number is a 404 unless a name is sent too, which adds the customer.
+func handleCheckIn(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Phone string `json:"phone"`
+		Name  string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if normalizePhone(req.Phone) == "" {
+		writeError(w, http.StatusBadRequest, "phone is required")
+		return
+	}
+	checkIn, err := CheckInCustomer(r.Context(), req.Phone, req.Name)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error()+"; send their name as well to add them")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if checkIn.New {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, checkIn)
+}
+
+func handleDailySales(w http.ResponseWriter, r *http.Request) {
+	day := clock.Now()
+	if date := r.URL.Query().Get("date"); date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "date must be formatted as YYYY-MM-DD")
+			return
+		}
+		day = parsed
+	}
+	sales, err := DailySales(r.Context(), day)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sales)
+}
+
+// handleDashboardSummary reports today's orders, revenue, open orders and best sellers from the cached model
+func handleDashboardSummary(w http.ResponseWriter, r *http.Request) {
+	summary, err := LoadDashboardSummary(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// handlePriceHistory reports how prices evolved for every item, or only for ?item
+func handlePriceHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := LoadPriceHistory(r.Context(), r.URL.Query().Get("item"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+// dateRange reads ?from and ?to (YYYY-MM-DD, inclusive) as [from, to), by default the last days days
+// up to today. It answers 400 itself when a date is malformed.
+func dateRange(w http.ResponseWriter, r *http.Request, days int) (time.Time, time.Time, bool) {
+	today := clock.Now()
+	to := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.Local)
+	from := to.AddDate(0, 0, 1-days)
+	for param, day := range map[string]*time.Time{"from": &from, "to": &to} {
+		if date := r.URL.Query().Get(param); date != "" {
+			parsed, err := time.ParseInLocation("2006-01-02", date, time.Local)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, param+" must be formatted as YYYY-MM-DD")
+				return time.Time{}, time.Time{}, false
+			}
+			*day = parsed
+		}
+	}
+	return from, to.AddDate(0, 0, 1), true
+}
+
+// handleSoldOutReport counts how often items were 86'd between ?from and ?to, by default over the last 30 days
+func handleSoldOutReport(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 30)
+	if !ok {
+		return
+	}
+	report, err := SoldOutReport(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleAccountingExport downloads the journal between ?from and ?to (by default the last 30 days)
+// for ?format=tally (XML) or ?format=quickbooks (CSV)
+func handleAccountingExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = ExportTally
+	}
+	from, to, ok := dateRange(w, r, 30)
+	if !ok {
+		return
+	}
+	var buf bytes.Buffer
+	if err := WriteAccountingExport(r.Context(), &buf, format, from, to); err != nil {
+		if format != ExportTally && format != ExportQuickBooks {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeStoreError(w, err)
+		return
+	}
+	contentType, extension := "application/xml", "xml"
+	if format == ExportQuickBooks {
+		contentType, extension = "text/csv", "csv"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="journal-%s-%s.%s"`,
+		from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"), extension))
+	w.Write(buf.Bytes())
+}
+
+// handleExport serves a page of a data export as NDJSON, one record per line, for a data warehouse to sync from.
+// It starts after ?cursor, from the beginning when that is not given, and sends up to ?limit records. The
+// X-Next-Cursor header is the cursor to ask for next, and X-More is true while another page is ready; once it
+// is false the next sync starts from the last cursor.
+func handleExport[T any](export func(ctx context.Context, cursor ExportCursor, limit int) (ExportPage[T], error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cursor, err := ParseExportCursor(r.URL.Query().Get("cursor"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		limit := defaultExportLimit
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > maxExportLimit {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be a whole number from 1 to %d", maxExportLimit))
+				return
+			}
+		}
+		page, err := export(r.Context(), cursor, limit)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("X-Next-Cursor", page.Next.String())
+		w.Header().Set("X-More", strconv.FormatBool(page.More))
+		encoder := json.NewEncoder(w)
+		for _, record := range page.Records {
+			if err := encoder.Encode(record); err != nil {
+				log.Println("Error writing export:", err)
+				return
+			}
+		}
+	}
+}
+
+// handleListShifts lists the shifts opened between ?from and ?to, by default the last 30 days
+func handleListShifts(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 30)
+	if !ok {
+		return
+	}
+	shifts, err := ListShifts(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, shifts)
+}
+
+func handleOpenShift(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Cashier string `json:"cashier"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	shift, err := OpenShift(r.Context(), req.Cashier)
+	if errors.Is(err, ErrShiftOpen) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, shift)
+}
+
+// handleGetShift returns a shift with its reconciliation, as it stands so far if the shift is still open
+func handleGetShift(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid shift id")
+		return
+	}
+	shift, err := ShiftSoFar(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, shift)
+}
+
+// handleCloseShift closes a shift and returns it with its reconciliation report
+func handleCloseShift(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid shift id")
+		return
+	}
+	shift, err := CloseShift(r.Context(), id)
+	if errors.Is(err, ErrNotFound) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, shift)
+}
+
+// handleWaiterPerformance reports each waiter's served orders between ?from and ?to (by default the last
+// 30 days), as JSON or, with ?format=csv, as a CSV download
+func handleWaiterPerformance(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+	from, to, ok := dateRange(w, r, 30)
+	if !ok {
+		return
+	}
+	report, err := WaiterPerformanceReport(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if format != "csv" {
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="waiters-%s-%s.csv"`,
+		from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102")))
+	WriteWaiterPerformanceCSV(w, report)
+}
+
+// handlePrepTimes reports how long the kitchen took to prepare each item for orders placed between ?from and ?to
+// (by default the last 30 days), as JSON or, with ?format=csv, as a CSV download
+func handlePrepTimes(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+	from, to, ok := dateRange(w, r, 30)
+	if !ok {
+		return
+	}
+	report, err := PrepTimeReport(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if format != "csv" {
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="prep-times-%s-%s.csv"`,
+		from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102")))
+	WritePrepTimesCSV(w, report)
+}
+
+// handleDiscountReport lists the comps and discounts given between ?from and ?to, by default over the last 30 days,
+// with their totals by reason and approver
+func handleDiscountReport(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 30)
+	if !ok {
+		return
+	}
+	report, err := BuildDiscountReport(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleDaySummary returns the end-of-day summary of ?date (YYYY-MM-DD), by default today: its sales by channel and
+// payment method, best sellers, discounts, voids, hours worked, complaints, its log book and the follow-ups still open
+func handleDaySummary(w http.ResponseWriter, r *http.Request) {
+	day := clock.Now()
+	if date := r.URL.Query().Get("date"); date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "date must be formatted as YYYY-MM-DD")
+			return
+		}
+		day = parsed
+	}
+	summary, err := BuildDaySummary(r.Context(), day)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// handleServiceChargeReport reports the service charge collected and waived between ?from and ?to, by default over
+// the last 30 days, with each employee's share of it
+func handleServiceChargeReport(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 30)
+	if !ok {
+		return
+	}
+	report, err := BuildServiceChargeReport(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleFXReport totals the foreign currency taken and exchanged between ?from and ?to, by default over the last 30
+// days, with the gain or loss on what was exchanged against the till's rates
+func handleFXReport(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 30)
+	if !ok {
+		return
+	}
+	report, err := BuildFXReport(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleOrderAudit lists the orders placed between ?from and ?to, by default over the last 30 days, with the device,
+// terminal, IP address and user agent they came from, narrowed by ?device, ?terminal, ?ip and ?userAgent
+func handleOrderAudit(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 30)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	filter := OrderAuditFilter{
+		Device: strings.TrimSpace(query.Get("device")), Terminal: strings.TrimSpace(query.Get("terminal")),
+		IP: strings.TrimSpace(query.Get("ip")), UserAgent: strings.TrimSpace(query.Get("userAgent")),
+	}
+	entries, err := OrderAudit(r.Context(), from, to, filter)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// handleVoidReport lists the lines voided between ?from and ?to, by default over the last 30 days, with those voided
+// after they were sent to the kitchen apart from those removed before
+func handleVoidReport(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 30)
+	if !ok {
+		return
+	}
+	report, err := BuildVoidReport(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleBudgetReport sets the revenue and food cost of ?month (YYYY-MM), by default this month, against its targets
+func handleBudgetReport(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = clock.Now().Format("2006-01")
+	}
+	if _, err := time.Parse("2006-01", month); err != nil {
+		writeError(w, http.StatusBadRequest, "month must be formatted as YYYY-MM")
+		return
+	}
+	report, err := BuildBudgetReport(r.Context(), month)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func handleListBudgets(w http.ResponseWriter, r *http.Request) {
+	budgets, err := ListBudgets(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, budgets)
+}
+
+// handleSaveBudget sets the targets of the month (YYYY-MM) in the path, replacing any set before
+func handleSaveBudget(w http.ResponseWriter, r *http.Request) {
+	var budget Budget
+	if err := json.NewDecoder(r.Body).Decode(&budget); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	budget.Month = r.PathValue("month")
+	budget, err := SaveBudget(r.Context(), budget)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, budget)
+}
+
+// handleStaffMealReport totals the staff meals of ?month (YYYY-MM) by employee, by default last month
+func handleStaffMealReport(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = clock.Now().AddDate(0, -1, 0).Format("2006-01")
+	}
+	report, err := BuildStaffMealReport(r.Context(), month)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleOpenItems lists the open items rung up between ?from and ?to, by default over the last 30 days
+func handleOpenItems(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 30)
+	if !ok {
+		return
+	}
+	sales, err := OpenItemSales(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sales)
+}
+
+// handleTableTurnover reports table turnover, occupancy and RevPASH between ?from and ?to, by default over the
+// last 30 days
+func handleTableTurnover(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 30)
+	if !ok {
+		return
+	}
+	report, err := TableTurnoverReport(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleDemand reports the average demand by day of the week and hour between ?from and ?to, by default over the
+// last 8 weeks, with the staff each hour needs
+func handleDemand(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 56)
+	if !ok {
+		return
+	}
+	heatmap, err := DemandReport(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, heatmap)
+}
+
+// handleSalesForecast forecasts each item's sales for the week starting on ?from (by default tomorrow) from ?weeks of
+// history (by default 4), and the ingredients to buy for them with ?buffer percent on top (by default 10)
+func handleSalesForecast(w http.ResponseWriter, r *http.Request) {
+	today := clock.Now()
+	from := time.Date(today.Year(), today.Month(), today.Day()+1, 0, 0, 0, 0, time.Local)
+	if date := r.URL.Query().Get("from"); date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "from must be formatted as YYYY-MM-DD")
+			return
+		}
+		from = parsed
+	}
+	weeks, buffer := 4, 10.0
+	if raw := r.URL.Query().Get("weeks"); raw != "" {
+		var err error
+		if weeks, err = strconv.Atoi(raw); err != nil || weeks < 1 {
+			writeError(w, http.StatusBadRequest, "weeks must be a whole number of at least 1")
+			return
+		}
+	}
+	if raw := r.URL.Query().Get("buffer"); raw != "" {
+		var err error
+		if buffer, err = strconv.ParseFloat(raw, 64); err != nil || buffer < 0 {
+			writeError(w, http.StatusBadRequest, "buffer must be a non-negative percentage")
+			return
+		}
+	}
+	forecast, err := ForecastSales(r.Context(), from, weeks, buffer)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, forecast)
+}
+
+// handlePayroll reports each employee's pay for the time clocked in between ?from and ?to (by default the
+// last 14 days), sharing out the tip pool given in ?tips, as JSON or, with ?format=csv, as a CSV download
+func handlePayroll(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+	var tips float64
+	if raw := r.URL.Query().Get("tips"); raw != "" {
+		var err error
+		if tips, err = strconv.ParseFloat(raw, 64); err != nil || tips < 0 {
+			writeError(w, http.StatusBadRequest, "tips must be a non-negative amount")
+			return
+		}
+	}
+	from, to, ok := dateRange(w, r, 14)
+	if !ok {
+		return
+	}
+	report, err := PayrollReport(r.Context(), from, to, tips)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if format != "csv" {
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="payroll-%s-%s.csv"`,
+		from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102")))
+	WritePayrollCSV(w, report)
+}
+
+// handleListReservations lists the reservations between ?from and ?to, by default today's
+func handleListReservations(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 1)
+	if !ok {
+		return
+	}
+	reservations, err := ListReservations(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reservations)
+}
+
+// reservationRequest books a table; preOrder lists dishes to send to the kitchen when the party is seated
+type reservationRequest struct {
+	CustomerName string             `json:"customerName"`
+	Phone        string             `json:"phone"`
+	Table        int                `json:"table"`
+	PartySize    int                `json:"partySize"`
+	At           time.Time          `json:"at"`
+	PreOrder     []orderItemRequest `json:"preOrder"`
+	ApprovedBy   string             `json:"approvedBy"` // Manager letting a flagged customer book
+}
+
+func handleBookReservation(w http.ResponseWriter, r *http.Request) {
+	var req reservationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if !approvalAllowed(w, r, req.ApprovedBy) {
+		return
+	}
+	reservation := Reservation{
+		CustomerName: req.CustomerName, Phone: req.Phone, Table: req.Table, PartySize: req.PartySize, At: req.At, ApprovedBy: req.ApprovedBy,
+	}
+	menu := LoadMenu(r.Context())
+	for _, line := range req.PreOrder {
+		if line.Quantity < 1 || line.Course < 0 || line.Seat < 0 {
+			writeError(w, http.StatusBadRequest, "quantity must be at least 1 and course and seat must not be negative")
+			return
+		}
+		added, err := requestLine(r.Context(), menu, line)
+		if errors.Is(err, ErrOpenItemNotAllowed) {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		reservation.PreOrder = addLine(reservation.PreOrder, added)
+	}
+	reservation, err := BookReservation(r.Context(), reservation)
+	if errors.Is(err, ErrBooked) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	var flagged *FlaggedError
+	if errors.As(err, &flagged) {
+		writeFlaggedError(w, flagged)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, reservation)
+}
+
+func handleGetReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid reservation id")
+		return
+	}
+	reservation, err := FindReservation(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reservation)
+}
+
+// handleTakeDeposit records a deposit for the reservation from a payment body like the order payments endpoint's
+func handleTakeDeposit(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid reservation id")
+		return
+	}
+	var payment Payment
+	if err := json.NewDecoder(r.Body).Decode(&payment); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	payment.Seat, payment.BanquetID, payment.AccountID = 0, primitive.NilObjectID, primitive.NilObjectID
+	payment.CompanyID, payment.Employee = primitive.NilObjectID, ""
+	payment.DrawerID, payment.ShiftID = primitive.NilObjectID, primitive.NilObjectID
+	reservation, err := TakeDeposit(r.Context(), id, payment)
+	writeReservationChange(w, reservation, err)
+}
+
+// handleSeatReservation seats the party, applying the deposit to the order given as {"orderId": ...}
+// or to the order made from the pre-order
+func handleSeatReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid reservation id")
+		return
+	}
+	var req struct {
+		OrderID primitive.ObjectID `json:"orderId"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+	reservation, err := SeatReservation(r.Context(), id, req.OrderID)
+	writeReservationChange(w, reservation, err)
+}
+
+func handleReservationNoShow(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid reservation id")
+		return
+	}
+	reservation, err := MarkNoShow(r.Context(), id)
+	writeReservationChange(w, reservation, err)
+}
+
+func handleCancelReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid reservation id")
+		return
+	}
+	reservation, err := CancelReservation(r.Context(), id)
+	writeReservationChange(w, reservation, err)
+}
+
+// writeReservationChange answers a change to a reservation: 404 if it does not exist, 409 if the change
+// is not allowed, else the updated reservation
+func writeReservationChange(w http.ResponseWriter, reservation Reservation, err error) {
+	if errors.Is(err, ErrNotFound) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, reservation)
+}
+
+// handleListBanquets lists the banquets taking place between ?from and ?to, by default today's
+func handleListBanquets(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 1)
+	if !ok {
+		return
+	}
+	banquets, err := ListBanquets(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, banquets)
+}
+
+func handleBookBanquet(w http.ResponseWriter, r *http.Request) {
+	var req Banquet
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	banquet, err := BookBanquet(r.Context(), req)
+	writeBanquetChange(w, http.StatusCreated, banquet, err)
+}
+
+func handleGetBanquet(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid banquet id")
+		return
+	}
+	banquet, err := FindBanquet(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, banquet)
+}
+
+// handleUpdateBanquet replaces the period, hall, tables, guest count, plate price and function sheet of a booking
+func handleUpdateBanquet(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid banquet id")
+		return
+	}
+	var req Banquet
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	banquet, err := UpdateBanquet(r.Context(), id, req)
+	writeBanquetChange(w, http.StatusOK, banquet, err)
+}
+
+// handleBanquetAdvance records an advance towards a banquet from a payment body like the order payments endpoint's
+func handleBanquetAdvance(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid banquet id")
+		return
+	}
+	var payment Payment
+	if err := json.NewDecoder(r.Body).Decode(&payment); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	payment.Seat, payment.ReservationID, payment.AccountID = 0, primitive.NilObjectID, primitive.NilObjectID
+	payment.CompanyID, payment.Employee = primitive.NilObjectID, ""
+	payment.DrawerID, payment.ShiftID = primitive.NilObjectID, primitive.NilObjectID
+	banquet, err := PayBanquetAdvance(r.Context(), id, payment)
+	writeBanquetChange(w, http.StatusOK, banquet, err)
+}
+
+func handleCancelBanquet(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid banquet id")
+		return
+	}
+	banquet, err := CancelBanquet(r.Context(), id)
+	writeBanquetChange(w, http.StatusOK, banquet, err)
+}
+
+// writeBanquetChange answers a change to a banquet: 404 if it does not exist, 409 if its hall or tables
+// are taken or it is cancelled, 400 for invalid details, else the booking with the given status
+func writeBanquetChange(w http.ResponseWriter, status int, banquet Banquet, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeStoreError(w, err)
+	case errors.Is(err, ErrBooked), errors.Is(err, ErrBanquetCancelled), errors.Is(err, ErrNoShift):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSON(w, status, banquet)
+	}
+}
+
+// handleListQuotes lists the catering quotes created between ?from and ?to, by default the last 30 days
+func handleListQuotes(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 30)
+	if !ok {
+		return
+	}
+	quotes, err := ListQuotes(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, quotes)
+}
+
+func handleCreateQuote(w http.ResponseWriter, r *http.Request) {
+	var req Quote
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	quote, err := CreateQuote(r.Context(), req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, quote)
+}
+
+func handleGetQuote(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid quote id")
+		return
+	}
+	quote, err := FindQuote(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, quote)
+}
+
+// handleSendQuote sends the customer the link to approve the quote
+func handleSendQuote(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid quote id")
+		return
+	}
+	quote, err := SendQuote(r.Context(), id)
+	writeQuoteChange(w, quote, err)
+}
+
+// handleConvertQuote turns an approved quote into an order scheduled for its fulfillment date
+func handleConvertQuote(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid quote id")
+		return
+	}
+	quote, err := ConvertQuote(r.Context(), id)
+	writeQuoteChange(w, quote, err)
+}
+
+// writeQuoteChange answers a change to a quote: 404 if it does not exist, 409 if the quote is not in
+// a state that allows it, else the updated quote
+func writeQuoteChange(w http.ResponseWriter, quote Quote, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeStoreError(w, err)
+	case errors.Is(err, ErrQuoteStatus):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, quote)
+	}
+}
+
+// handleListCreditAccounts lists every customer's credit account with what they owe
+func handleListCreditAccounts(w http.ResponseWriter, r *http.Request) {
+	accounts, err := ListCreditAccounts(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, accounts)
+}
+
+func handleOpenCreditAccount(w http.ResponseWriter, r *http.Request) {
+	var req CreditAccount
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if !approvalAllowed(w, r, req.ApprovedBy) {
+		return
+	}
+	account, err := OpenCreditAccount(r.Context(), req)
+	writeAccountChange(w, http.StatusCreated, account, err)
+}
+
+func handleGetCreditAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid account id")
+		return
+	}
+	account, err := FindCreditAccount(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, account)
+}
+
+// handleUpdateCreditAccount changes an account's phone number and credit limit
+func handleUpdateCreditAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid account id")
+		return
+	}
+	var req CreditAccount
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	account, err := UpdateCreditAccount(r.Context(), id, req)
+	writeAccountChange(w, http.StatusOK, account, err)
+}
+
+// handleAccountStatement returns the account's ledger as JSON or, with ?format=text, as a statement to print
+func handleAccountStatement(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "text" {
+		writeError(w, http.StatusBadRequest, "format must be json or text")
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid account id")
+		return
+	}
+	statement, err := StatementFor(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if format != "text" {
+		writeJSON(w, http.StatusOK, statement)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	WriteAccountStatement(w, statement)
+}
+
+// handleChargeToAccount runs up what is left to pay on the order in the body on the account
+func handleChargeToAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid account id")
+		return
+	}
+	var req struct {
+		OrderID    primitive.ObjectID `json:"orderId"`
+		ApprovedBy string             `json:"approvedBy"` // Manager letting a flagged customer charge the account
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.OrderID.IsZero() {
+		writeError(w, http.StatusBadRequest, "orderId is required")
+		return
+	}
+	if !approvalAllowed(w, r, req.ApprovedBy) {
+		return
+	}
+	account, err := ChargeToAccount(r.Context(), id, req.OrderID, req.ApprovedBy)
+	writeAccountChange(w, http.StatusOK, account, err)
+}
+
+// handleSettleAccount records a payment towards the account's balance
+func handleSettleAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid account id")
+		return
+	}
+	var payment Payment
+	if err := json.NewDecoder(r.Body).Decode(&payment); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	payment.Seat, payment.ReservationID, payment.BanquetID = 0, primitive.NilObjectID, primitive.NilObjectID
+	payment.CompanyID, payment.Employee = primitive.NilObjectID, ""
+	payment.DrawerID, payment.ShiftID = primitive.NilObjectID, primitive.NilObjectID
+	account, err := SettleAccount(r.Context(), id, payment)
+	writeAccountChange(w, http.StatusOK, account, err)
+}
+
+// writeAccountChange answers a change to a credit account: 404 if it or the order does not exist, 409 if the
+// customer already has an account or a charge is over the credit limit, 400 for invalid details, else the account
+func writeAccountChange(w http.ResponseWriter, status int, account CreditAccount, err error) {
+	var flagged *FlaggedError
+	switch {
+	case errors.As(err, &flagged):
+		writeFlaggedError(w, flagged)
+	case errors.Is(err, ErrNotFound):
+		writeStoreError(w, err)
+	case errors.Is(err, ErrDuplicate), errors.Is(err, ErrCreditLimit), errors.Is(err, ErrNoShift):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSON(w, status, account)
+	}
+}
+
+func handleListCompanies(w http.ResponseWriter, r *http.Request) {
+	companies, err := ListCompanies(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, companies)
+}
+
+func handleAddCompany(w http.ResponseWriter, r *http.Request) {
+	var req Company
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	company, err := AddCompany(r.Context(), req)
+	writeCompanyResult(w, http.StatusCreated, company, err)
+}
+
+func handleGetCompany(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid company id")
+		return
+	}
+	company, err := FindCompany(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, company)
+}
+
+// handleUpdateCompany replaces a company's details, employees and standing purchase order
+func handleUpdateCompany(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid company id")
+		return
+	}
+	var req Company
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	company, err := UpdateCompany(r.Context(), id, req)
+	writeCompanyResult(w, http.StatusOK, company, err)
+}
+
+// handleBillToCompany bills what is left to pay on the order in the body to the company, for the employee
+// named in the body or else on the order
+func handleBillToCompany(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid company id")
+		return
+	}
+	var req struct {
+		OrderID  primitive.ObjectID `json:"orderId"`
+		Employee string             `json:"employee"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.OrderID.IsZero() {
+		writeError(w, http.StatusBadRequest, "orderId is required")
+		return
+	}
+	payment, err := BillToCompany(r.Context(), id, req.OrderID, req.Employee)
+	writeCompanyResult(w, http.StatusCreated, payment, err)
+}
+
+func handleListCompanyInvoices(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid company id")
+		return
+	}
+	invoices, err := ListCompanyInvoices(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, invoices)
+}
+
+// handleIssueCompanyInvoice returns the company's invoice for the month in the body, issuing it if it has
+// none yet, with the purchase order in the body or else the company's standing one
+func handleIssueCompanyInvoice(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid company id")
+		return
+	}
+	var req struct {
+		Month    string `json:"month"`
+		PONumber string `json:"poNumber"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	invoice, err := IssueCompanyInvoice(r.Context(), id, req.Month, req.PONumber)
+	writeCompanyResult(w, http.StatusOK, invoice, err)
+}
+
+// handleGetCompanyInvoice returns the company's invoice for a month as JSON or, with ?format=text, to print
+func handleGetCompanyInvoice(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "text" {
+		writeError(w, http.StatusBadRequest, "format must be json or text")
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid company id")
+		return
+	}
+	invoice, err := FindCompanyInvoice(r.Context(), id, r.PathValue("month"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if format != "text" {
+		writeJSON(w, http.StatusOK, invoice)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	WriteCompanyInvoice(w, invoice)
+}
+
+// writeCompanyResult answers a request about a company: 404 if it or the order does not exist, 409 if
+// another company has the name, 400 for invalid details, else the result
+func writeCompanyResult(w http.ResponseWriter, status int, result any, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeStoreError(w, err)
+	case errors.Is(err, ErrDuplicate):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSON(w, status, result)
+	}
+}
+
+// handleListTimeEntries lists the time entries clocked in between ?from and ?to, by default the last 14 days
+func handleListTimeEntries(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 14)
+	if !ok {
+		return
+	}
+	entries, err := ListTimeEntries(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// timeClockRequest names the employee clocking in or out
+type timeClockRequest struct {
+	Employee string `json:"employee"`
+}
+
+func handleClockIn(w http.ResponseWriter, r *http.Request) {
+	var req timeClockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	entry, err := ClockIn(r.Context(), req.Employee)
+	if errors.Is(err, ErrClockedIn) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, entry)
+}
+
+func handleClockOut(w http.ResponseWriter, r *http.Request) {
+	var req timeClockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	entry, err := ClockOut(r.Context(), req.Employee)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusConflict, req.Employee+" is not clocked in")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// handleDrawerSessions lists the drawer sessions opened between ?from and ?to, by default the last 30 days
+func handleDrawerSessions(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 30)
+	if !ok {
+		return
+	}
+	sessions, err := ListDrawerSessions(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+func handleDriverSettlements(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 30)
+	if !ok {
+		return
+	}
+	settlements, err := ListDriverSettlements(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, settlements)
+}
+
+// handleIssueInvoice returns the order's tax invoice, issuing the next number if it has none yet
+func handleIssueInvoice(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	invoice, err := IssueInvoice(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, invoice)
+}
+
+// handleListInvoices lists the invoices issued between ?from and ?to, by default today's
+func handleListInvoices(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 1)
+	if !ok {
+		return
+	}
+	invoices, err := ListInvoices(r.Context(), from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, invoices)
+}
+
+func handleGetInvoice(w http.ResponseWriter, r *http.Request) {
+	invoice, err := FindInvoice(r.Context(), r.PathValue("number"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, invoice)
+}
+
+// callerTenantID is the tenant whose API keys a request may manage; "" outside SaaS mode
+func callerTenantID(r *http.Request) string {
+	tenant, _ := TenantFrom(r.Context())
+	return tenant.ID
+}
+
+func handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := ListAPIKeys(r.Context(), callerTenantID(r))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		RateLimit int      `json:"rateLimit"`
+		Role      string   `json:"role"`
+		Staff     string   `json:"staff"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if err := checkKeyWithinCaller(r, "issue", APIKey{Scopes: req.Scopes, Role: req.Role, Staff: req.Staff}); err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	key, record, err := IssueAPIKey(r.Context(), APIKey{
+		TenantID: callerTenantID(r), Name: req.Name, Scopes: req.Scopes, RateLimit: req.RateLimit, Role: req.Role, Staff: req.Staff,
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"key": record, "apiKey": key})
+}
+
+// checkKeyWithinCaller refuses the calling key to issue, rotate or revoke a key with more access than it has
+// itself: a scope it lacks, or, for a key bound to a role, another role or member of staff. Rotating hands back the
+// new secret, so without it a key could take over one with more access.
+func checkKeyWithinCaller(r *http.Request, action string, target APIKey) error {
+	caller, ok := APIKeyFrom(r.Context())
+	if !ok {
+		return nil
+	}
+	for _, scope := range target.Scopes {
+		if !caller.Allows(scope) {
+			return fmt.Errorf("cannot %s a key with the %s scope", action, scope)
+		}
+	}
+	if caller.Role != "" && (!strings.EqualFold(target.Role, caller.Role) || !strings.EqualFold(target.Staff, caller.Staff)) {
+		return fmt.Errorf("a %s's key can only %s keys for the same %s", caller.Role, action, caller.Role)
+	}
+	return nil
+}
+
+// checkAPIKeyTarget loads the key in the path and writes an error unless the caller may act on it
+func checkAPIKeyTarget(w http.ResponseWriter, r *http.Request, action string) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid key id")
+		return id, false
+	}
+	target, err := findTenantAPIKey(r.Context(), callerTenantID(r), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return id, false
+	}
+	if err := checkKeyWithinCaller(r, action, target); err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return id, false
+	}
+	return id, true
+}
+
+func handleRotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, ok := checkAPIKeyTarget(w, r, "rotate")
+	if !ok {
+		return
+	}
+	key, record, err := RotateAPIKey(r.Context(), callerTenantID(r), id)
+	if errors.Is(err, ErrNotFound) {
+		writeStoreError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"key": record, "apiKey": key})
+}
+
+func handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, ok := checkAPIKeyTarget(w, r, "revoke")
+	if !ok {
+		return
+	}
+	record, err := RevokeAPIKey(r.Context(), callerTenantID(r), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, record)
+}
+
+func handleAPIKeyUsage(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid key id")
+		return
+	}
+	usage, err := LoadAPIKeyUsage(r.Context(), callerTenantID(r), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, usage)
+}
+
+// twoFactorRequest is the body of a two-factor request
+type twoFactorRequest struct {
+	Code     string `json:"code"`
+	Terminal string `json:"terminal"` // Where the session verified starts, for /api/2fa/verify
+}
+
+// twoFactorCaller returns the key a two-factor request is made with and its body
+func twoFactorCaller(w http.ResponseWriter, r *http.Request) (APIKey, twoFactorRequest, bool) {
+	record, ok := APIKeyFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusBadRequest, "two-factor authentication is for API keys; send one")
+		return APIKey{}, twoFactorRequest{}, false
+	}
+	var body twoFactorRequest
+	if r.URL.Path != "/api/2fa/enroll" {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return APIKey{}, twoFactorRequest{}, false
+		}
+	}
+	return record, body, true
+}
+
+// writeTwoFactorError answers 403 for a wrong code, 429 while locked out after too many, else 400 for a request
+// the key's enrollment does not allow
+func writeTwoFactorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrWrongCode):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, ErrTooManyAttempts):
+		writeError(w, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, ErrVersionConflict):
+		writeStoreError(w, err)
+	default:
+		writeError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+// handleEnrollTwoFactor starts two-factor authentication for the calling admin key, returning the secret and
+// backup codes once
+func handleEnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	record, _, ok := twoFactorCaller(w, r)
+	if !ok {
+		return
+	}
+	enrollment, err := EnrollTwoFactor(r.Context(), record)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, enrollment)
+}
+
+// handleConfirmTwoFactor turns two-factor authentication on with a first code from the authenticator app
+func handleConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	record, body, ok := twoFactorCaller(w, r)
+	if !ok {
+		return
+	}
+	twoFactor, err := ConfirmTwoFactor(r.Context(), record, body.Code)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"enabledAt": twoFactor.EnabledAt, "backupCodesLeft": len(twoFactor.BackupCodes)})
+}
+
+// handleVerifyTwoFactor starts a session for the calling admin key with a code or backup code
+func handleVerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	record, body, ok := twoFactorCaller(w, r)
+	if !ok {
+		return
+	}
+	token, session, err := VerifyTwoFactor(r.Context(), record, body.Code, body.Terminal)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, sessionStarted(token, session))
+}
+
+// handleDisableTwoFactor turns two-factor authentication off for the calling admin key
+func handleDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	record, body, ok := twoFactorCaller(w, r)
+	if !ok {
+		return
+	}
+	if err := DisableTwoFactor(r.Context(), record, body.Code); err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sessionStarted is the answer to signing in: the token, shown once, and the header to send it in
+func sessionStarted(token string, session Session) map[string]any {
+	return map[string]any{"token": token, "header": sessionHeader, "session": session}
+}
+
+// handleStartSession signs the calling key in at the terminal named in the body
+func handleStartSession(w http.ResponseWriter, r *http.Request) {
+	record, ok := APIKeyFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusBadRequest, "sessions are for API keys; send one")
+		return
+	}
+	var req struct {
+		Terminal string `json:"terminal"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	token, session, err := StartSession(r.Context(), record, req.Terminal)
+	var signedIn *SignedInError
+	switch {
+	case errors.As(err, &signedIn):
+		writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error(), "terminal": signedIn.Session.Terminal})
+		return
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, sessionStarted(token, session))
+}
+
+// handleListSessions lists the calling key's live sessions, or with the keys:manage scope every key's, or one
+// key's with ?key
+func handleListSessions(w http.ResponseWriter, r *http.Request) {
+	var keyID primitive.ObjectID
+	if raw := r.URL.Query().Get("key"); raw != "" {
+		id, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid key id")
+			return
+		}
+		keyID = id
+	}
+	if record, ok := APIKeyFrom(r.Context()); ok && !record.Allows(ScopeKeysManage) {
+		if !keyID.IsZero() && keyID != record.ID {
+			writeError(w, http.StatusForbidden, "this key lacks the "+ScopeKeysManage+" scope")
+			return
+		}
+		keyID = record.ID
+	}
+	sessions, err := ListSessions(r.Context(), callerTenantID(r), keyID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+// handleRevokeSession signs a session out. Keys without the keys:manage scope can only sign out their own.
+func handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid session id")
+		return
+	}
+	var owner primitive.ObjectID
+	if record, ok := APIKeyFrom(r.Context()); ok && !record.Allows(ScopeKeysManage) {
+		owner = record.ID
+	}
+	session, err := RevokeSession(r.Context(), callerTenantID(r), id, owner)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+func handleListDevices(w http.ResponseWriter, r *http.Request) {
+	devices, err := ListDevices(r.Context(), callerTenantID(r))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, devices)
+}
+
+// handleRegisterDevice registers a POS terminal or kiosk and returns its credential, which is not shown again
+func handleRegisterDevice(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name     string `json:"name"`
+		Kind     string `json:"kind"`
+		Location string `json:"location"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	credential, device, err := RegisterDevice(r.Context(), Device{TenantID: callerTenantID(r), Name: req.Name, Kind: req.Kind, Location: req.Location})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"device": device, "credential": credential, "header": deviceHeader})
+}
+
+// handleDisableDevice stops a device working, e.g. when it is stolen, with an optional reason in the body
+func handleDisableDevice(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid device id")
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+	device, err := DisableDevice(r.Context(), callerTenantID(r), id, req.Reason)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, device)
+}
+
+func handleEnableDevice(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid device id")
+		return
+	}
+	device, err := EnableDevice(r.Context(), callerTenantID(r), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, device)
+}
+
+func handleListParkedCarts(w http.ResponseWriter, r *http.Request) {
+	carts, err := ListParkedCarts(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, carts)
+}
+
+// handleParkCart puts an order still being taken aside, taking the same body as creating an order
+func handleParkCart(w http.ResponseWriter, r *http.Request) {
+	var req orderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Type != "" && req.Type != OrderDineIn && req.Type != OrderTakeaway && req.Type != OrderDelivery {
+		writeError(w, http.StatusBadRequest, "type must be dine-in, takeaway or delivery")
+		return
+	}
+	if req.Table < 0 {
+		writeError(w, http.StatusBadRequest, "table must not be negative")
+		return
+	}
+	order := Order{CustomerName: req.CustomerName, Table: req.Table, Type: req.Type, Waiter: req.Waiter, Notes: req.Notes}
+	menu := LoadMenu(r.Context())
+	for _, line := range req.Items {
+		if line.Quantity < 1 || line.Course < 0 || line.Seat < 0 {
+			writeError(w, http.StatusBadRequest, "quantity must be at least 1 and course and seat must not be negative")
+			return
+		}
+		added, err := requestLine(r.Context(), menu, line)
+		if errors.Is(err, ErrOpenItemNotAllowed) {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		order.Items = addLine(order.Items, added)
+	}
+	cart, err := ParkCart(r.Context(), order)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, cart)
+}
+
+// handleRecallCart takes back the cart parked for a table or, without one, for a customer's name
+func handleRecallCart(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Table        int    `json:"table"`
+		CustomerName string `json:"customerName"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Table == 0 && strings.TrimSpace(req.CustomerName) == "" {
+		writeError(w, http.StatusBadRequest, "table or customerName is required")
+		return
+	}
+	cart, err := RecallCart(r.Context(), req.Table, req.CustomerName)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cart)
+}
+
+func handleDiscardParkedCart(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid cart id")
+		return
+	}
+	if _, err := TakeParkedCart(r.Context(), id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleListStandingOrders(w http.ResponseWriter, r *http.Request) {
+	standing, err := ListStandingOrders(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, standing)
+}
+
+// handleCreateStandingOrder sets up a takeaway order placed on the given days, e.g. {"days":["weekdays"],"at":"13:00"}
+func handleCreateStandingOrder(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CustomerName string             `json:"customerName"`
+		Items        []orderItemRequest `json:"items"`
+		Notes        string             `json:"notes"`
+		Days         []string           `json:"days"`
+		At           string             `json:"at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	standing := StandingOrder{CustomerName: req.CustomerName, Notes: req.Notes, Days: req.Days, At: req.At}
+	menu := LoadMenu(r.Context())
+	for _, line := range req.Items {
+		if line.Quantity < 1 || line.Course < 0 || line.Seat < 0 {
+			writeError(w, http.StatusBadRequest, "quantity must be at least 1 and course and seat must not be negative")
+			return
+		}
+		added, err := requestLine(r.Context(), menu, line)
+		if errors.Is(err, ErrOpenItemNotAllowed) {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		standing.Items = addLine(standing.Items, added)
+	}
+	standing, err := CreateStandingOrder(r.Context(), standing)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, standing)
+}
+
+func handleGetStandingOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid standing order id")
+		return
+	}
+	standing, err := FindStandingOrder(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, standing)
+}
+
+// handleSkipStandingOrder leaves out one coming run, given as {"date":"2026-01-26"}
+func handleSkipStandingOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid standing order id")
+		return
+	}
+	var req struct {
+		Date string `json:"date"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	standing, err := SkipStandingOrder(r.Context(), id, req.Date)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeStoreError(w, err)
+	case errors.Is(err, ErrStandingOrderPaused):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, standing)
+	}
+}
+
+func handlePauseStandingOrder(w http.ResponseWriter, r *http.Request) {
+	changeStandingOrderStatus(w, r, PauseStandingOrder)
+}
+
+func handleResumeStandingOrder(w http.ResponseWriter, r *http.Request) {
+	changeStandingOrderStatus(w, r, ResumeStandingOrder)
+}
+
+// changeStandingOrderStatus pauses or resumes the standing order in the path
+func changeStandingOrderStatus(w http.ResponseWriter, r *http.Request, change func(context.Context, primitive.ObjectID) (StandingOrder, error)) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid standing order id")
+		return
+	}
+	standing, err := change(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, standing)
+}
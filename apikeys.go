@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/time/rate"
+)
+
+// API key scopes. A key can only call the endpoints its scopes cover.
+const (
+	ScopeAll            = "*"
+	ScopeMenuRead       = "menu:read"
+	ScopeMenuWrite      = "menu:write"
+	ScopeOrdersRead     = "orders:read"
+	ScopeOrdersWrite    = "orders:write"
+	ScopePaymentsWrite  = "payments:write"
+	ScopeCustomersRead  = "customers:read"
+	ScopeCustomersWrite = "customers:write"
+	ScopeReportsRead    = "reports:read"
+	ScopeKeysManage     = "keys:manage"
+	ScopeManagerApprove = "manager:approve" // Lets a request name a manager approving something staff may not do alone
+)
+
+// defaultAPIRateLimit is the requests per minute allowed to keys without a limit of their own
+const defaultAPIRateLimit = 120
+
+// apiScopes lists the scopes a key can be given
+var apiScopes = []string{ScopeAll, ScopeMenuRead, ScopeMenuWrite, ScopeOrdersRead, ScopeOrdersWrite,
+	ScopePaymentsWrite, ScopeCustomersRead, ScopeCustomersWrite, ScopeReportsRead, ScopeKeysManage, ScopeManagerApprove}
+
+// apiKeyPrefix starts every issued key, so leaked keys are easy to search for
+const apiKeyPrefix = "rms_"
+
+// maxUsageResults caps how many usage records one request returns
+const maxUsageResults = 200
+
+// APIKey lets a program such as a kiosk or delivery aggregator call the API. Only a hash of
+// the key is stored; the key itself is shown once, when it is issued or rotated.
+type APIKey struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TenantID  string             `bson:"tenantId,omitempty" json:"tenantId,omitempty"` // Set in SaaS mode
+	Name      string             `bson:"name" json:"name"`
+	Prefix    string             `bson:"prefix" json:"prefix"` // First characters of the key, to tell keys apart
+	Hash      string             `bson:"hash" json:"-"`
+	Scopes    []string           `bson:"scopes" json:"scopes"`
+	RateLimit int                `bson:"rateLimit,omitempty" json:"rateLimit,omitempty"` // Requests per minute; 0 means the default
+	Role      string             `bson:"role,omitempty" json:"role,omitempty"`           // Staff role whose orders alone the key sees; all orders when empty
+	Staff     string             `bson:"staff,omitempty" json:"staff,omitempty"`         // The waiter, manager or chef's station the key is for
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	RotatedAt *time.Time         `bson:"rotatedAt,omitempty" json:"rotatedAt,omitempty"`
+	RevokedAt *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+// APIKeyUsage records one request made with a key
+type APIKeyUsage struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	KeyID  primitive.ObjectID `bson:"keyId" json:"keyId"`
+	Method string             `bson:"method" json:"method"`
+	Path   string             `bson:"path" json:"path"`
+	Status int                `bson:"status" json:"status"`
+	At     time.Time          `bson:"at" json:"at"`
+}
+
+// Allows reports whether the key's scopes cover scope
+func (k APIKey) Allows(scope string) bool {
+	return slices.Contains(k.Scopes, ScopeAll) || slices.Contains(k.Scopes, scope)
+}
+
+// validateScopes rejects scopes that do not exist, so a typo does not silently grant nothing
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("a key needs at least one scope")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(apiScopes, scope) {
+			return fmt.Errorf("unknown scope %q (want one of %s)", scope, strings.Join(apiScopes, ", "))
+		}
+	}
+	return nil
+}
+
+// newAPIKeySecret returns a random key and the prefix shown to tell it apart
+func newAPIKeySecret() (string, string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(secret)
+	return key, key[:len(apiKeyPrefix)+6], nil
+}
+
+// hashAPIKey is how keys are stored and looked up. Keys are long and random, so a plain hash is enough.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueAPIKey creates a key with the name, scopes and rate limit of record and returns it;
+// the key cannot be retrieved later
+func IssueAPIKey(ctx context.Context, record APIKey) (string, APIKey, error) {
+	record.Name = strings.TrimSpace(record.Name)
+	if record.Name == "" {
+		return "", APIKey{}, fmt.Errorf("key name is required")
+	}
+	if err := validateScopes(record.Scopes); err != nil {
+		return "", APIKey{}, err
+	}
+	if record.RateLimit < 0 {
+		return "", APIKey{}, fmt.Errorf("rate limit cannot be negative")
+	}
+	if err := validateStaffRole(&record); err != nil {
+		return "", APIKey{}, err
+	}
+
+	key, prefix, err := newAPIKeySecret()
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	record.ID = primitive.NewObjectID()
+	record.Prefix = prefix
+	record.Hash = hashAPIKey(key)
+	record.CreatedAt = time.Now()
+	record.RotatedAt, record.RevokedAt = nil, nil
+	if err := store.APIKeys().Create(ctx, record); err != nil {
+		return "", APIKey{}, err
+	}
+	log.Printf("API key %s (%s) issued", record.Prefix, record.Name)
+	return key, record, nil
+}
+
+// RotateAPIKey replaces a key's secret, keeping its name, scopes and limit. The old key stops working at once.
+func RotateAPIKey(ctx context.Context, tenantID string, id primitive.ObjectID) (string, APIKey, error) {
+	record, err := findTenantAPIKey(ctx, tenantID, id)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	if record.RevokedAt != nil {
+		return "", APIKey{}, fmt.Errorf("key has been revoked")
+	}
+	key, prefix, err := newAPIKeySecret()
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	now := time.Now()
+	record.Prefix = prefix
+	record.Hash = hashAPIKey(key)
+	record.RotatedAt = &now
+	if err := store.APIKeys().Update(ctx, record); err != nil {
+		return "", APIKey{}, err
+	}
+	log.Printf("API key %s (%s) rotated", record.Prefix, record.Name)
+	return key, record, nil
+}
+
+// RevokeAPIKey stops a key from working. The record is kept so its usage history stays readable.
+func RevokeAPIKey(ctx context.Context, tenantID string, id primitive.ObjectID) (APIKey, error) {
+	record, err := findTenantAPIKey(ctx, tenantID, id)
+	if err != nil {
+		return APIKey{}, err
+	}
+	if record.RevokedAt == nil {
+		now := time.Now()
+		record.RevokedAt = &now
+		if err := store.APIKeys().Update(ctx, record); err != nil {
+			return APIKey{}, err
+		}
+		log.Printf("API key %s (%s) revoked", record.Prefix, record.Name)
+	}
+	return record, nil
+}
+
+// ListAPIKeys returns the keys belonging to the tenant ("" outside SaaS mode)
+func ListAPIKeys(ctx context.Context, tenantID string) ([]APIKey, error) {
+	keys, err := store.APIKeys().ListByTenant(ctx, tenantID)
+	if keys == nil {
+		keys = []APIKey{}
+	}
+	return keys, err
+}
+
+// LoadAPIKeyUsage returns the most recent requests made with a key, newest first
+func LoadAPIKeyUsage(ctx context.Context, tenantID string, id primitive.ObjectID) ([]APIKeyUsage, error) {
+	if _, err := findTenantAPIKey(ctx, tenantID, id); err != nil {
+		return nil, err
+	}
+	usage, err := store.APIKeys().ListUsage(ctx, id, maxUsageResults)
+	if usage == nil {
+		usage = []APIKeyUsage{}
+	}
+	return usage, err
+}
+
+// findTenantAPIKey loads a key, treating another tenant's key as not found
+func findTenantAPIKey(ctx context.Context, tenantID string, id primitive.ObjectID) (APIKey, error) {
+	record, err := store.APIKeys().Find(ctx, id)
+	if err != nil {
+		return APIKey{}, err
+	}
+	if record.TenantID != tenantID {
+		return APIKey{}, ErrNotFound
+	}
+	return record, nil
+}
+
+// AuthenticateAPIKey returns the live key matching the secret
+func AuthenticateAPIKey(ctx context.Context, key string) (APIKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return APIKey{}, ErrNotFound
+	}
+	record, err := store.APIKeys().FindByHash(ctx, hashAPIKey(key))
+	if err != nil {
+		return APIKey{}, err
+	}
+	if record.RevokedAt != nil {
+		return APIKey{}, ErrNotFound
+	}
+	return record, nil
+}
+
+// recordAPIKeyUsage logs a request made with a key; a failure to log never fails the request
+func recordAPIKeyUsage(ctx context.Context, usage APIKeyUsage) {
+	usage.ID = primitive.NewObjectID()
+	if err := store.APIKeys().LogUsage(ctx, usage); err != nil {
+		log.Println("Error recording API key usage:", err)
+	}
+}
+
+// apiKeyLimiters holds a token bucket per key, refilled at the key's requests per minute
+var apiKeyLimiters = struct {
+	mu       sync.Mutex
+	limiters map[primitive.ObjectID]*rate.Limiter
+}{limiters: make(map[primitive.ObjectID]*rate.Limiter)}
+
+// allowAPIKeyRequest reports whether the key is within its rate limit
+func allowAPIKeyRequest(record APIKey) bool {
+	perMinute := record.RateLimit
+	if perMinute == 0 {
+		perMinute = defaultAPIRateLimit
+	}
+	limit := rate.Limit(float64(perMinute) / 60)
+
+	apiKeyLimiters.mu.Lock()
+	limiter, ok := apiKeyLimiters.limiters[record.ID]
+	if !ok {
+		limiter = rate.NewLimiter(limit, perMinute)
+		apiKeyLimiters.limiters[record.ID] = limiter
+	} else if limiter.Limit() != limit {
+		// The limit was changed since the bucket was made
+		limiter.SetLimit(limit)
+		limiter.SetBurst(perMinute)
+	}
+	apiKeyLimiters.mu.Unlock()
+	return limiter.Allow()
+}
+
+type apiKeyContextKey struct{}
+
+// APIKeyFrom returns the key a request was authenticated with, if any
+func APIKeyFrom(ctx context.Context) (APIKey, bool) {
+	record, ok := ctx.Value(apiKeyContextKey{}).(APIKey)
+	return record, ok
+}
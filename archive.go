@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// archiveInterval is how often the background job archives orders
+const archiveInterval = 24 * time.Hour
+
+// archiveBatch is the most orders moved in one go, so a first archive of years of orders does not hold the
+// database for long
+const archiveBatch = 500
+
+// archiveAfterMonths is how old orders get before the background job archives them, from RMS_ARCHIVE_AFTER;
+// 0 leaves them all where they are
+var archiveAfterMonths int
+
+// SetArchiveAfter sets after how many months orders are archived from a whole number, or turns archiving off
+// when it is empty
+func SetArchiveAfter(months string) error {
+	archiveAfterMonths = 0
+	if strings.TrimSpace(months) == "" {
+		return nil
+	}
+	parsed, err := strconv.Atoi(strings.TrimSpace(months))
+	if err != nil || parsed < 1 {
+		return fmt.Errorf("invalid archive age %q (want a whole number of months, e.g. 18)", months)
+	}
+	archiveAfterMonths = parsed
+	return nil
+}
+
+// ArchiveOrders moves the orders placed more than months ago that were served and paid, with their events, out of
+// the live orders into the archive. Reports, invoices and order history still find them there, while the
+// orders the restaurant is working on stay in a small collection. It returns how many it moved.
+func ArchiveOrders(ctx context.Context, months int) (int, error) {
+	if months < 1 {
+		return 0, fmt.Errorf("orders must be at least a month old to be archived")
+	}
+	before := clock.Now().AddDate(0, -months, 0)
+	archived := 0
+	for {
+		moved, err := storeFor(ctx).Orders().Archive(ctx, before, archiveBatch)
+		archived += moved
+		if err != nil || moved == 0 {
+			return archived, err
+		}
+	}
+}
+
+// StartOrderArchive archives old orders in the background every day when RMS_ARCHIVE_AFTER is set
+func StartOrderArchive() {
+	if archiveAfterMonths == 0 {
+		return
+	}
+	go func() {
+		for {
+			err := forEachTenant(context.TODO(), func(ctx context.Context) error {
+				if IsOffline() {
+					return nil
+				}
+				archived, err := ArchiveOrders(ctx, archiveAfterMonths)
+				if archived > 0 {
+					debugf("Archived %d orders", archived)
+				}
+				return err
+			})
+			if err != nil {
+				log.Println("Order archive:", err)
+			}
+			time.Sleep(archiveInterval)
+		}
+	}()
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Banquet statuses
+const (
+	BanquetBooked    = "booked"
+	BanquetCancelled = "cancelled"
+)
+
+var (
+	// ErrBooked is returned when a booking would use a table or hall that is already taken at that time
+	ErrBooked = errors.New("already booked")
+	// ErrBanquetCancelled is returned when changing or paying for a cancelled banquet
+	ErrBanquetCancelled = errors.New("banquet is cancelled")
+)
+
+// Banquet is a function booked at a fixed price per plate, holding the hall and tables it uses
+// for its whole period. Advances paid towards it are recorded as payments.
+type Banquet struct {
+	ID            primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	CustomerName  string               `bson:"customerName" json:"customerName"`
+	Phone         string               `bson:"phone,omitempty" json:"phone,omitempty"`
+	Occasion      string               `bson:"occasion,omitempty" json:"occasion,omitempty"` // e.g. Wedding reception
+	Start         time.Time            `bson:"start" json:"start"`
+	End           time.Time            `bson:"end" json:"end"`
+	Hall          string               `bson:"hall,omitempty" json:"hall,omitempty"`
+	Tables        []int                `bson:"tables,omitempty" json:"tables,omitempty"`
+	Guests        int                  `bson:"guests" json:"guests"`
+	PlatePrice    float64              `bson:"platePrice" json:"platePrice"`
+	FunctionSheet []FunctionItem       `bson:"functionSheet" json:"functionSheet"` // Dishes the kitchen prepares for every plate
+	Total         float64              `bson:"total" json:"total"`                 // Guests times the plate price
+	AmountPaid    float64              `bson:"amountPaid" json:"amountPaid"`
+	Advances      []primitive.ObjectID `bson:"advances,omitempty" json:"advances,omitempty"` // Payments made towards the booking
+	Status        string               `bson:"status" json:"status"`
+	CreatedAt     time.Time            `bson:"createdAt" json:"createdAt"`
+}
+
+// FunctionItem is one dish on a banquet's function sheet
+type FunctionItem struct {
+	Name  string `bson:"name" json:"name"`
+	Notes string `bson:"notes,omitempty" json:"notes,omitempty"` // e.g. less spicy, served at 9 pm
+}
+
+// Balance is what is left to pay for the banquet
+func (b Banquet) Balance() float64 {
+	return roundPaise(b.Total - b.AmountPaid)
+}
+
+// prepareBanquet checks a booking's details against the menu and works out its total
+func prepareBanquet(banquet *Banquet, menu []MenuItem) error {
+	banquet.CustomerName = strings.TrimSpace(banquet.CustomerName)
+	banquet.Hall = strings.TrimSpace(banquet.Hall)
+	if banquet.CustomerName == "" {
+		return fmt.Errorf("customer name is required")
+	}
+	if banquet.Start.IsZero() || !banquet.End.After(banquet.Start) {
+		return fmt.Errorf("the banquet must end after it starts")
+	}
+	if banquet.Hall == "" && len(banquet.Tables) == 0 {
+		return fmt.Errorf("a hall or tables must be booked")
+	}
+	if banquet.Guests < 1 {
+		return fmt.Errorf("guest count must be at least 1")
+	}
+	if banquet.PlatePrice <= 0 {
+		return fmt.Errorf("plate price must be positive")
+	}
+	for i, dish := range banquet.FunctionSheet {
+		item, found := FindMenuItem(menu, dish.Name)
+		if !found {
+			return fmt.Errorf("item %s not found in menu", dish.Name)
+		}
+		banquet.FunctionSheet[i].Name = item.Name
+	}
+	if banquet.FunctionSheet == nil {
+		banquet.FunctionSheet = []FunctionItem{}
+	}
+	if slices.ContainsFunc(banquet.Tables, func(table int) bool { return table < 1 }) {
+		return fmt.Errorf("table numbers start at 1")
+	}
+	slices.Sort(banquet.Tables)
+	banquet.Tables = slices.Compact(banquet.Tables)
+	banquet.Total = roundPaise(float64(banquet.Guests) * banquet.PlatePrice)
+	return nil
+}
+
+// checkBanquetFree refuses a booking whose hall or tables are taken, by another banquet or by a table
+// reservation, at some point in its period
+func checkBanquetFree(ctx context.Context, banquet Banquet) error {
+	others, err := storeFor(ctx).Banquets().ListOverlapping(ctx, banquet.Start, banquet.End)
+	if err != nil {
+		return err
+	}
+	for _, other := range others {
+		if other.ID == banquet.ID || other.Status == BanquetCancelled {
+			continue
+		}
+		if banquet.Hall != "" && strings.EqualFold(other.Hall, banquet.Hall) {
+			return fmt.Errorf("%s is %w for %s's banquet", other.Hall, ErrBooked, other.CustomerName)
+		}
+		for _, table := range banquet.Tables {
+			if slices.Contains(other.Tables, table) {
+				return fmt.Errorf("table %d is %w for %s's banquet", table, ErrBooked, other.CustomerName)
+			}
+		}
+	}
+	reservations, err := storeFor(ctx).Reservations().ListBetween(ctx, banquet.Start.Add(-reservationLength+1), banquet.End)
+	if err != nil {
+		return err
+	}
+	for _, reservation := range reservations {
+		if reservation.Status == ReservationBooked && slices.Contains(banquet.Tables, reservation.Table) {
+			return fmt.Errorf("table %d is %w for %s at %s", reservation.Table, ErrBooked, reservation.CustomerName, reservation.At.Format("15:04"))
+		}
+	}
+	return nil
+}
+
+// BookBanquet stores a new banquet booking once its hall and tables are known to be free
+func BookBanquet(ctx context.Context, banquet Banquet) (Banquet, error) {
+	if err := prepareBanquet(&banquet, LoadMenu(ctx)); err != nil {
+		return Banquet{}, err
+	}
+	if err := checkBanquetFree(ctx, banquet); err != nil {
+		return Banquet{}, err
+	}
+	banquet.ID = primitive.NewObjectID()
+	banquet.Status = BanquetBooked
+	banquet.AmountPaid, banquet.Advances = 0, nil
+	banquet.CreatedAt = clock.Now()
+	return banquet, storeFor(ctx).Banquets().Insert(ctx, banquet)
+}
+
+// UpdateBanquet changes the period, hall, tables, guest count, plate price or function sheet of a booking
+func UpdateBanquet(ctx context.Context, id primitive.ObjectID, change Banquet) (Banquet, error) {
+	banquet, err := FindBanquet(ctx, id)
+	if err != nil {
+		return Banquet{}, err
+	}
+	if banquet.Status != BanquetBooked {
+		return Banquet{}, ErrBanquetCancelled
+	}
+	banquet.Occasion, banquet.Start, banquet.End = change.Occasion, change.Start, change.End
+	banquet.Hall, banquet.Tables, banquet.Guests = change.Hall, change.Tables, change.Guests
+	banquet.PlatePrice, banquet.FunctionSheet = change.PlatePrice, change.FunctionSheet
+	if err := prepareBanquet(&banquet, LoadMenu(ctx)); err != nil {
+		return Banquet{}, err
+	}
+	if err := checkBanquetFree(ctx, banquet); err != nil {
+		return Banquet{}, err
+	}
+	return banquet, storeFor(ctx).Banquets().Update(ctx, banquet)
+}
+
+// FindBanquet loads a banquet booking by ID
+func FindBanquet(ctx context.Context, id primitive.ObjectID) (Banquet, error) {
+	return storeFor(ctx).Banquets().Find(ctx, id)
+}
+
+// ListBanquets returns the banquets taking place at some point in [from, to), earliest first
+func ListBanquets(ctx context.Context, from, to time.Time) ([]Banquet, error) {
+	banquets, err := storeFor(ctx).Banquets().ListOverlapping(ctx, from, to)
+	if banquets == nil {
+		banquets = []Banquet{}
+	}
+	return banquets, err
+}
+
+// PayBanquetAdvance records an advance towards a banquet as a payment
+func PayBanquetAdvance(ctx context.Context, id primitive.ObjectID, payment Payment) (Banquet, error) {
+	if IsOffline() {
+		return Banquet{}, fmt.Errorf("advances can only be taken while the database is reachable")
+	}
+	banquet, err := FindBanquet(ctx, id)
+	if err != nil {
+		return Banquet{}, err
+	}
+	if banquet.Status != BanquetBooked {
+		return Banquet{}, ErrBanquetCancelled
+	}
+	payment.ID, payment.OrderID, payment.BanquetID, payment.CreatedAt = primitive.NewObjectID(), primitive.NilObjectID, id, clock.Now()
+	if err := ValidatePayment(payment); err != nil {
+		return Banquet{}, err
+	}
+	if payment.Amount > banquet.Balance() {
+		return Banquet{}, fmt.Errorf("only Rs %.2f is left to pay", banquet.Balance())
+	}
+	if payment.Method == PaymentCash {
+		if payment.DrawerID, err = openDrawerID(ctx); err != nil {
+			return Banquet{}, err
+		}
+	}
+	if err := assignShift(ctx, &payment); err != nil {
+		return Banquet{}, err
+	}
+	paymentDevice(ctx, &payment)
+	if err := storeFor(ctx).Payments().Insert(ctx, payment); err != nil {
+		return Banquet{}, err
+	}
+	banquet.AmountPaid = roundPaise(banquet.AmountPaid + payment.Amount)
+	banquet.Advances = append(banquet.Advances, payment.ID)
+	return banquet, storeFor(ctx).Banquets().Update(ctx, banquet)
+}
+
+// CancelBanquet cancels a booking, freeing its hall and tables
+func CancelBanquet(ctx context.Context, id primitive.ObjectID) (Banquet, error) {
+	banquet, err := FindBanquet(ctx, id)
+	if err != nil {
+		return Banquet{}, err
+	}
+	if banquet.Status != BanquetBooked {
+		return Banquet{}, ErrBanquetCancelled
+	}
+	banquet.Status = BanquetCancelled
+	return banquet, storeFor(ctx).Banquets().Update(ctx, banquet)
+}
+
+// checkTableFree refuses a reservation of a table a banquet holds at that time
+func checkTableFree(ctx context.Context, table int, at time.Time) error {
+	banquets, err := storeFor(ctx).Banquets().ListOverlapping(ctx, at, at.Add(reservationLength))
+	if err != nil {
+		return err
+	}
+	for _, banquet := range banquets {
+		if banquet.Status == BanquetBooked && slices.Contains(banquet.Tables, table) {
+			return fmt.Errorf("table %d is %w for %s's banquet", table, ErrBooked, banquet.CustomerName)
+		}
+	}
+	return nil
+}
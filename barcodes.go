@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// barcodePattern is what a barcode or PLU code may look like: the digits of an EAN or UPC, a short PLU, or the
+// letters and digits of a Code 128 label
+var barcodePattern = regexp.MustCompile(`^[0-9A-Za-z-]{3,32}$`)
+
+// cleanBarcodes trims the barcodes, checks them and drops repeats
+func cleanBarcodes(codes []string) ([]string, error) {
+	var cleaned []string
+	for _, code := range codes {
+		code = strings.TrimSpace(code)
+		if code == "" {
+			continue
+		}
+		if !barcodePattern.MatchString(code) {
+			return nil, fmt.Errorf("invalid barcode %q (want 3 to 32 letters or digits)", code)
+		}
+		if !slices.Contains(cleaned, code) {
+			cleaned = append(cleaned, code)
+		}
+	}
+	return cleaned, nil
+}
+
+// FindMenuItemByBarcode looks up the item sold under a scanned barcode or typed PLU code
+func FindMenuItemByBarcode(menu []MenuItem, code string) (MenuItem, bool) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return MenuItem{}, false
+	}
+	for _, item := range menu {
+		if slices.Contains(item.Barcodes, code) {
+			return item, true
+		}
+	}
+	return MenuItem{}, false
+}
+
+// checkBarcodesFree returns an error if another item of the menu is already sold under one of the item's barcodes,
+// as a scan must always find the same item
+func checkBarcodesFree(menu []MenuItem, item MenuItem) error {
+	for _, code := range item.Barcodes {
+		if other, found := FindMenuItemByBarcode(menu, code); found && !strings.EqualFold(other.Name, item.Name) {
+			return fmt.Errorf("barcode %s is already %s's", code, other.Name)
+		}
+	}
+	return nil
+}
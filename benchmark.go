@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// BenchmarkOptions shape the load the benchmark command puts on ordering
+type BenchmarkOptions struct {
+	Orders      int
+	Concurrency int // Orders placed at the same time
+	Customers   int // Fewer customers means more orders updating the same customer at once
+	Takeaway    int // Percentage of orders that are takeaway and so need a token
+}
+
+// Stages of placing an order the benchmark times separately, so a slow one stands out
+const (
+	StageToken    = "token"    // Taking the next takeaway token from the day's counter
+	StageOrder    = "order"    // Recording the OrderCreated event and the order
+	StageCustomer = "customer" // Adding the items to the customer and updating their total
+	StageKitchen  = "kitchen"  // The kitchen starting the order, through the event stream
+)
+
+var benchmarkStages = []string{StageToken, StageOrder, StageCustomer, StageKitchen}
+
+// StageStats are the latencies of one stage across every order that reached it
+type StageStats struct {
+	Stage  string  `json:"stage"`
+	Count  int     `json:"count"`
+	Errors int     `json:"errors"`
+	P50    float64 `json:"p50Ms"`
+	P90    float64 `json:"p90Ms"`
+	P99    float64 `json:"p99Ms"`
+	Max    float64 `json:"maxMs"`
+}
+
+// BenchmarkReport is how ordering held up under the load
+type BenchmarkReport struct {
+	Orders      int          `json:"orders"`
+	Failed      int          `json:"failed"`
+	Concurrency int          `json:"concurrency"`
+	Seconds     float64      `json:"seconds"`
+	Throughput  float64      `json:"ordersPerSecond"`
+	Order       StageStats   `json:"order"` // Each order end to end
+	Stages      []StageStats `json:"stages"`
+	Slowest     string       `json:"slowestStage"` // The stage with the worst p99, where orders contend the most
+	Errors      []string     `json:"errors,omitempty"`
+}
+
+// benchmarkTimings collects latencies from every worker
+type benchmarkTimings struct {
+	mu      sync.Mutex
+	stages  map[string][]time.Duration
+	failed  map[string]int
+	errors  []string // The first few distinct errors, to show what contention looks like
+	wholes  []time.Duration
+	failure int
+}
+
+// measure runs one stage of an order, recording how long it took and whether it failed
+func (b *benchmarkTimings) measure(stage string, run func() error) error {
+	start := time.Now()
+	err := run()
+	elapsed := time.Since(start)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stages[stage] = append(b.stages[stage], elapsed)
+	if err != nil {
+		b.failed[stage]++
+		message := stage + ": " + err.Error()
+		if len(b.errors) < 5 && !slices.Contains(b.errors, message) {
+			b.errors = append(b.errors, message)
+		}
+	}
+	return err
+}
+
+// RunBenchmark places simulated orders from many goroutines at once through the same steps as SubmitOrder,
+// timing each step, to show how many orders a second the store sustains and where orders wait on each other.
+// The orders and customers it creates are real, so it belongs on a dev or staging database.
+func RunBenchmark(ctx context.Context, opts BenchmarkOptions) (BenchmarkReport, error) {
+	if opts.Orders < 1 || opts.Concurrency < 1 || opts.Customers < 1 {
+		return BenchmarkReport{}, fmt.Errorf("orders, concurrency and customers must be at least 1")
+	}
+	if opts.Takeaway < 0 || opts.Takeaway > 100 {
+		return BenchmarkReport{}, fmt.Errorf("takeaway must be a percentage from 0 to 100")
+	}
+	AddMenuItems(ctx)
+	menu := LoadMenu(ctx)
+	if len(menu) == 0 {
+		return BenchmarkReport{}, fmt.Errorf("the menu is empty")
+	}
+
+	timings := &benchmarkTimings{stages: make(map[string][]time.Duration), failed: make(map[string]int)}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range min(opts.Concurrency, opts.Orders) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				began := time.Now()
+				err := benchmarkOrder(ctx, timings, opts, menu, i)
+				timings.mu.Lock()
+				timings.wholes = append(timings.wholes, time.Since(began))
+				if err != nil {
+					timings.failure++
+				}
+				timings.mu.Unlock()
+			}
+		}()
+	}
+	for i := range opts.Orders {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := BenchmarkReport{
+		Orders: opts.Orders, Failed: timings.failure, Concurrency: opts.Concurrency, Seconds: elapsed.Seconds(),
+		Throughput: float64(opts.Orders-timings.failure) / elapsed.Seconds(),
+		Order:      stageStats("total", timings.wholes, timings.failure), Errors: timings.errors,
+	}
+	slowest := -1.0
+	for _, stage := range benchmarkStages {
+		if len(timings.stages[stage]) == 0 {
+			continue
+		}
+		stats := stageStats(stage, timings.stages[stage], timings.failed[stage])
+		report.Stages = append(report.Stages, stats)
+		if stats.P99 > slowest {
+			report.Slowest, slowest = stage, stats.P99
+		}
+	}
+	return report, nil
+}
+
+// benchmarkOrder places the i'th simulated order, stopping at the first stage that fails
+func benchmarkOrder(ctx context.Context, timings *benchmarkTimings, opts BenchmarkOptions, menu []MenuItem, i int) error {
+	order := Order{
+		CustomerName: fmt.Sprintf("Benchmark Customer %d", i%opts.Customers+1),
+		Items:        AddToCart(AddToCart(nil, menu[i%len(menu)], 1), menu[(i*7+3)%len(menu)], 1+i%2),
+	}
+	if i%100 < opts.Takeaway {
+		order.Type = OrderTakeaway
+	}
+	prepareOrder(&order)
+
+	if order.Type == OrderTakeaway {
+		err := timings.measure(StageToken, func() (err error) {
+			order.Token, err = NextToken(ctx, order.CreatedAt)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err := timings.measure(StageOrder, func() (err error) {
+		order, err = startOrder(ctx, order)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := timings.measure(StageCustomer, func() error { return addCustomerItems(ctx, order.CustomerName, order.Items) }); err != nil {
+		return err
+	}
+	return timings.measure(StageKitchen, func() error {
+		_, err := changeOrder(ctx, order.ID, func(Order) (OrderEvent, error) {
+			return OrderEvent{Type: EventStatusChanged, Status: StatusPreparing}, nil
+		})
+		return err
+	})
+}
+
+// stageStats works out the latency percentiles of a stage in milliseconds
+func stageStats(stage string, latencies []time.Duration, errors int) StageStats {
+	slices.Sort(latencies)
+	percentile := func(p int) float64 {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return float64(latencies[(len(latencies)-1)*p/100].Microseconds()) / 1000
+	}
+	return StageStats{
+		Stage: stage, Count: len(latencies), Errors: errors,
+		P50: percentile(50), P90: percentile(90), P99: percentile(99), Max: percentile(100),
+	}
+}
+
+// printBenchmark writes the report as a summary and a table of stage latencies
+func printBenchmark(w io.Writer, report BenchmarkReport) error {
+	fmt.Fprintf(w, "%d orders (%d failed) from %d at a time in %.2fs: %.1f orders/s\n",
+		report.Orders, report.Failed, report.Concurrency, report.Seconds, report.Throughput)
+	var rows [][]string
+	for _, stats := range append(report.Stages, report.Order) {
+		rows = append(rows, []string{
+			stats.Stage, fmt.Sprint(stats.Count), fmt.Sprint(stats.Errors),
+			fmt.Sprintf("%.2f", stats.P50), fmt.Sprintf("%.2f", stats.P90), fmt.Sprintf("%.2f", stats.P99), fmt.Sprintf("%.2f", stats.Max),
+		})
+	}
+	if err := writeTable(w, []string{"Stage", "Count", "Errors", "p50 ms", "p90 ms", "p99 ms", "Max ms"}, rows); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "Most contended stage:", report.Slowest)
+	for _, err := range report.Errors {
+		fmt.Fprintln(w, "Error:", err)
+	}
+	return nil
+}
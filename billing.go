@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// How amounts are written on bills and receipts, from RMS_NUMBER_FORMAT
+const (
+	NumberFormatPlain         = "plain"         // 123456.00
+	NumberFormatIndian        = "indian"        // 1,23,456.00, grouped in lakhs and crores
+	NumberFormatInternational = "international" // 123,456.00
+)
+
+// roundOffLabel is the line a rounded bill shows its adjustment on
+const roundOffLabel = "Round off"
+
+// numberFormat is how amounts are written on bills and receipts
+var numberFormat = NumberFormatPlain
+
+// billRounding is the step in rupees bills are rounded to, from RMS_BILL_ROUNDING, e.g. 1 for the nearest rupee;
+// 0 leaves them to the paisa
+var billRounding float64
+
+// SetNumberFormat sets how amounts are written on bills: plain, indian or international; empty keeps plain
+func SetNumberFormat(format string) error {
+	format = strings.ToLower(strings.TrimSpace(format))
+	switch format {
+	case "":
+		numberFormat = NumberFormatPlain
+	case NumberFormatPlain, NumberFormatIndian, NumberFormatInternational:
+		numberFormat = format
+	default:
+		return fmt.Errorf("invalid number format %q (want plain, indian or international)", format)
+	}
+	return nil
+}
+
+// SetBillRounding sets the step in rupees bills are rounded to, e.g. "1" for the nearest rupee or "0.5" for the
+// nearest 50 paise; empty or 0 leaves them unrounded
+func SetBillRounding(step string) error {
+	billRounding = 0
+	if strings.TrimSpace(step) == "" {
+		return nil
+	}
+	parsed, err := strconv.ParseFloat(strings.TrimSpace(step), 64)
+	if err != nil || parsed < 0 || parsed > 100 {
+		return fmt.Errorf("invalid bill rounding %q (want rupees to round to, e.g. 1)", step)
+	}
+	billRounding = parsed
+	return nil
+}
+
+// formatAmount writes a rupee amount to the paisa in the number format, e.g. 1,23,456.00
+func formatAmount(value float64) string {
+	text := strconv.FormatFloat(math.Abs(value), 'f', 2, 64)
+	if numberFormat == NumberFormatPlain {
+		return fmt.Sprintf("%.2f", value)
+	}
+	whole, paise, _ := strings.Cut(text, ".")
+	// Indian grouping takes the last three digits, then pairs: 1,23,45,678
+	size, groups := 3, []string{}
+	for len(whole) > size {
+		groups = append([]string{whole[len(whole)-size:]}, groups...)
+		whole = whole[:len(whole)-size]
+		if numberFormat == NumberFormatIndian {
+			size = 2
+		}
+	}
+	text = strings.Join(append([]string{whole}, groups...), ",") + "." + paise
+	if value < 0 && text != "0.00" {
+		text = "-" + text
+	}
+	return text
+}
+
+// roundBill rounds the total to the nearest step in rupees, halves up; a step of 0 leaves it as it is
+func roundBill(total, step float64) float64 {
+	if step <= 0 {
+		return total
+	}
+	return roundPaise(math.Round(total/step) * step)
+}
+
+// roundOff is what rounding the order's bill added to it, negative when it came off
+func roundOff(order Order) float64 {
+	return roundPaise(order.Total - exactTotal(order))
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MenuOverride is how a branch's menu differs from head office's for one item
+type MenuOverride struct {
+	Item        string   `bson:"item" json:"item"`
+	Price       *float64 `bson:"price,omitempty" json:"price,omitempty"`             // The branch's price; head office's when nil
+	Unavailable bool     `bson:"unavailable,omitempty" json:"unavailable,omitempty"` // Not served at the branch
+}
+
+// BranchMenu is what a branch serves. Head office defines the menu; a sync copies it to the branch with the
+// branch's overrides applied, so head office's changes reach the branch only when it is synced.
+type BranchMenu struct {
+	Branch    string         `bson:"_id" json:"branch"`
+	Overrides []MenuOverride `bson:"overrides" json:"overrides"`
+	Items     []MenuItem     `bson:"items" json:"items"` // As of the last sync; head office's menu is served until the first
+	SyncedAt  *time.Time     `bson:"syncedAt,omitempty" json:"syncedAt,omitempty"`
+	SyncedBy  string         `bson:"syncedBy,omitempty" json:"syncedBy,omitempty"`
+	Version   int            `bson:"version" json:"version"` // Bumped by every change, to catch conflicting edits
+}
+
+// MenuChange is what a sync changes about one item of a branch's menu
+type MenuChange struct {
+	Item   string        `json:"item"`
+	Change string        `json:"change"` // added, removed or changed
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange is one field of an item a sync changes, with the values as JSON
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// checkMenuBranch trims a branch name, refusing the main branch, whose menu is head office's own
+func checkMenuBranch(branch string) (string, error) {
+	branch = strings.TrimSpace(branch)
+	if branch == "" || strings.EqualFold(branch, mainBranch) {
+		return "", fmt.Errorf("the main branch serves head office's menu; name another branch")
+	}
+	return branch, nil
+}
+
+// LoadBranchMenu returns a branch's overrides and synced menu; a branch never synced has neither
+func LoadBranchMenu(ctx context.Context, branch string) (BranchMenu, error) {
+	branch, err := checkMenuBranch(branch)
+	if err != nil {
+		return BranchMenu{}, err
+	}
+	menu, err := storeFor(ctx).BranchMenus().Find(ctx, branch)
+	if errors.Is(err, ErrNotFound) {
+		return BranchMenu{Branch: branch, Overrides: []MenuOverride{}, Items: []MenuItem{}}, nil
+	}
+	return menu, err
+}
+
+// SetMenuOverride sets a branch's price for an item, or takes it off the branch's menu. An override with neither
+// a price nor unavailable clears the item's override. Nothing changes at the branch until its menu is synced.
+func SetMenuOverride(ctx context.Context, branch string, override MenuOverride) (BranchMenu, error) {
+	menu, err := LoadBranchMenu(ctx, branch)
+	if err != nil {
+		return BranchMenu{}, err
+	}
+	item, found := FindMenuItem(loadHeadOfficeMenu(ctx), override.Item)
+	if !found {
+		return BranchMenu{}, fmt.Errorf("%s is not on head office's menu", override.Item)
+	}
+	if override.Price != nil && *override.Price <= 0 {
+		return BranchMenu{}, fmt.Errorf("%s: the price must be positive", item.Name)
+	}
+	override.Item = item.Name
+	menu.Overrides = slices.DeleteFunc(menu.Overrides, func(o MenuOverride) bool { return o.Item == item.Name })
+	if override.Price != nil || override.Unavailable {
+		menu.Overrides = append(menu.Overrides, override)
+	}
+	if err := storeFor(ctx).BranchMenus().Save(ctx, menu); err != nil {
+		return BranchMenu{}, err
+	}
+	menu.Version++
+	return menu, nil
+}
+
+// applyOverrides returns head office's menu as the branch is to serve it
+func applyOverrides(headOffice []MenuItem, overrides []MenuOverride) []MenuItem {
+	items := []MenuItem{}
+	for _, item := range headOffice {
+		i := slices.IndexFunc(overrides, func(o MenuOverride) bool { return o.Item == item.Name })
+		if i >= 0 && overrides[i].Unavailable {
+			continue
+		}
+		if i >= 0 && overrides[i].Price != nil {
+			item.Price = *overrides[i].Price
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// PlanMenuSync shows what syncing head office's menu to the branch would change, without changing it
+func PlanMenuSync(ctx context.Context, branch string) ([]MenuChange, error) {
+	menu, err := LoadBranchMenu(ctx, branch)
+	if err != nil {
+		return nil, err
+	}
+	current := menu.Items
+	if menu.SyncedAt == nil {
+		current = loadHeadOfficeMenu(ctx)
+	}
+	return diffMenus(current, applyOverrides(loadHeadOfficeMenu(ctx), menu.Overrides)), nil
+}
+
+// SyncBranchMenu copies head office's menu to the branch with the branch's overrides applied, returning what
+// changed
+func SyncBranchMenu(ctx context.Context, branch string) (BranchMenu, []MenuChange, error) {
+	menu, err := LoadBranchMenu(ctx, branch)
+	if err != nil {
+		return BranchMenu{}, nil, err
+	}
+	headOffice := loadHeadOfficeMenu(ctx)
+	current := menu.Items
+	if menu.SyncedAt == nil {
+		current = headOffice
+	}
+	now := clock.Now()
+	menu.Items = applyOverrides(headOffice, menu.Overrides)
+	menu.SyncedAt, menu.SyncedBy = &now, changedBy(ctx)
+	if err := storeFor(ctx).BranchMenus().Save(ctx, menu); err != nil {
+		return BranchMenu{}, nil, err
+	}
+	menu.Version++
+	return menu, diffMenus(current, menu.Items), nil
+}
+
+// branchMenuItems returns what the branch serves: its synced menu, or head office's until it is first synced.
+// Whether an item is 86'd and its version come from head office's copy, which is the one edited.
+func branchMenuItems(ctx context.Context, branch string, headOffice []MenuItem) ([]MenuItem, error) {
+	menu, err := storeFor(ctx).BranchMenus().Find(ctx, branch)
+	if errors.Is(err, ErrNotFound) || (err == nil && menu.SyncedAt == nil) {
+		return headOffice, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	items := make([]MenuItem, len(menu.Items))
+	copy(items, menu.Items)
+	for i := range items {
+		if live, found := FindMenuItem(headOffice, items[i].Name); found {
+			items[i].SoldOutOn, items[i].Version = live.SoldOutOn, live.Version
+		}
+	}
+	return items, nil
+}
+
+// diffMenus lists how target differs from current by item, in target's order with removed items last. Whether an
+// item is 86'd and its version are not part of the menu being synced.
+func diffMenus(current, target []MenuItem) []MenuChange {
+	changes := []MenuChange{}
+	for _, item := range target {
+		before, found := FindMenuItem(current, item.Name)
+		if !found {
+			changes = append(changes, MenuChange{Item: item.Name, Change: "added"})
+			continue
+		}
+		if fields := diffMenuItems(before, item); len(fields) > 0 {
+			changes = append(changes, MenuChange{Item: item.Name, Change: "changed", Fields: fields})
+		}
+	}
+	for _, item := range current {
+		if _, found := FindMenuItem(target, item.Name); !found {
+			changes = append(changes, MenuChange{Item: item.Name, Change: "removed"})
+		}
+	}
+	return changes
+}
+
+// diffMenuItems compares two copies of an item field by field, as they are written in JSON
+func diffMenuItems(before, after MenuItem) []FieldChange {
+	from, to := menuItemFields(before), menuItemFields(after)
+	var keys []string
+	for key := range from {
+		keys = append(keys, key)
+	}
+	for key := range to {
+		if _, ok := from[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	var fields []FieldChange
+	for _, key := range keys {
+		if from[key] != to[key] {
+			fields = append(fields, FieldChange{Field: key, From: from[key], To: to[key]})
+		}
+	}
+	return fields
+}
+
+// menuItemFields returns the synced fields of an item as JSON, by JSON name
+func menuItemFields(item MenuItem) map[string]string {
+	data, _ := json.Marshal(item)
+	var raw map[string]json.RawMessage
+	json.Unmarshal(data, &raw)
+	fields := map[string]string{}
+	for key, value := range raw {
+		if key != "name" && key != "soldOutOn" && key != "version" {
+			fields[key] = string(value)
+		}
+	}
+	return fields
+}
+
+// ShowMenuChanges prints what a menu sync changes
+func ShowMenuChanges(branch string, changes []MenuChange) error {
+	changeListing := listing{
+		title:   fmt.Sprintf("Menu changes at %s:", branch),
+		header:  []string{"Item", "Change", "Field", "From", "To"},
+		records: changes,
+	}
+	for _, change := range changes {
+		if len(change.Fields) == 0 {
+			changeListing.rows = append(changeListing.rows, []string{change.Item, change.Change, "", "", ""})
+			changeListing.compact = append(changeListing.compact, change.Item+" "+change.Change)
+		}
+		for _, field := range change.Fields {
+			changeListing.rows = append(changeListing.rows, []string{change.Item, change.Change, field.Field, field.From, field.To})
+			changeListing.compact = append(changeListing.compact, fmt.Sprintf("%s %s: %s -> %s", change.Item, field.Field, field.From, field.To))
+		}
+	}
+	if len(changes) == 0 && listFormat != FormatJSON {
+		fmt.Printf("The menu at %s is up to date\n", branch)
+		return nil
+	}
+	return printListing(os.Stdout, changeListing)
+}
+
+// ShowBranchMenu prints a branch's overrides and when its menu was last synced
+func ShowBranchMenu(ctx context.Context, branch string) error {
+	menu, err := LoadBranchMenu(ctx, branch)
+	if err != nil {
+		return err
+	}
+	synced := "never synced, so it serves head office's menu"
+	if menu.SyncedAt != nil {
+		synced = fmt.Sprintf("synced %s by %s", menu.SyncedAt.Format("02 Jan 2006 15:04"), menu.SyncedBy)
+	}
+	overrideListing := listing{
+		title:   fmt.Sprintf("Menu at %s, %s:", menu.Branch, synced),
+		header:  []string{"Item", "Price", "Served"},
+		records: menu,
+	}
+	for _, override := range menu.Overrides {
+		price, served := "", "yes"
+		if override.Price != nil {
+			price = fmt.Sprintf("%.2f", *override.Price)
+		}
+		if override.Unavailable {
+			served = "no"
+		}
+		overrideListing.rows = append(overrideListing.rows, []string{override.Item, price, served})
+		overrideListing.compact = append(overrideListing.compact, strings.TrimSpace(fmt.Sprintf("%s %s served: %s", override.Item, price, served)))
+	}
+	return printListing(os.Stdout, overrideListing)
+}
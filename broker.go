@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+// Message brokers the outbox can publish to
+const (
+	BrokerNATS  = "nats"
+	BrokerKafka = "kafka"
+)
+
+// outboxInterval is how often the relay looks for events that have not been published yet
+const outboxInterval = 2 * time.Second
+
+// outboxBatch is the most events published in one pass
+const outboxBatch = 100
+
+// BrokerMessage is an event as it is published. Key is the order ID, so brokers that
+// partition (Kafka) keep each order's events in sequence.
+type BrokerMessage struct {
+	Subject string
+	Key     string
+	Data    []byte
+}
+
+// Publisher sends messages to a broker, returning only once the broker has accepted them
+type Publisher interface {
+	Publish(ctx context.Context, msg BrokerMessage) error
+	Close() error
+}
+
+// NATSPublisher publishes each event on its own subject, e.g. rms.orders.OrderCreated
+type NATSPublisher struct {
+	conn *nats.Conn
+}
+
+// NewNATSPublisher connects to the NATS server at url
+func NewNATSPublisher(url string) (*NATSPublisher, error) {
+	conn, err := nats.Connect(url, nats.Name("rms"), nats.MaxReconnects(-1), nats.RetryOnFailedConnect(true))
+	if err != nil {
+		return nil, err
+	}
+	return &NATSPublisher{conn: conn}, nil
+}
+
+func (p *NATSPublisher) Publish(ctx context.Context, msg BrokerMessage) error {
+	if err := p.conn.Publish(msg.Subject, msg.Data); err != nil {
+		return err
+	}
+	// Flushing waits for the server to acknowledge, so a dropped connection is noticed
+	return p.conn.FlushWithContext(ctx)
+}
+
+func (p *NATSPublisher) Close() error {
+	return p.conn.Drain()
+}
+
+// KafkaPublisher writes every event to one topic, with the subject in a header
+type KafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaPublisher writes to topic on the given brokers
+func NewKafkaPublisher(brokers []string, topic string) *KafkaPublisher {
+	return &KafkaPublisher{writer: &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Topic:                  topic,
+		Balancer:               &kafka.Hash{},
+		RequiredAcks:           kafka.RequireAll,
+		AllowAutoTopicCreation: true,
+	}}
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, msg BrokerMessage) error {
+	return p.writer.WriteMessages(ctx, kafka.Message{
+		Key:     []byte(msg.Key),
+		Value:   msg.Data,
+		Headers: []kafka.Header{{Key: "subject", Value: []byte(msg.Subject)}},
+	})
+}
+
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
+
+// OpenPublisher connects to the broker selected in the config, or returns nil when none is configured
+func OpenPublisher(cfg Config) (Publisher, error) {
+	switch cfg.Broker {
+	case "":
+		return nil, nil
+	case BrokerNATS:
+		return NewNATSPublisher(cfg.NATSURL)
+	case BrokerKafka:
+		return NewKafkaPublisher(strings.Split(cfg.KafkaBrokers, ","), cfg.KafkaTopic), nil
+	}
+	return nil, fmt.Errorf("unknown message broker %q (want %s or %s)", cfg.Broker, BrokerNATS, BrokerKafka)
+}
+
+// eventSubject names the subject an event is published on; payments get their own so
+// accounting can subscribe to them alone. In SaaS mode the tenant comes first, e.g. rms.acme.orders.OrderCreated.
+func eventSubject(ctx context.Context, event OrderEvent) string {
+	prefix := "rms."
+	if tenant, ok := TenantFrom(ctx); ok {
+		prefix += tenant.ID + "."
+	}
+	if event.Type == EventPaid {
+		return prefix + "payments." + event.Type
+	}
+	return prefix + "orders." + event.Type
+}
+
+// StartOutboxRelay publishes order events in the background. The event log is the outbox: an
+// event is marked published only after the broker accepts it, so nothing is lost while the
+// broker is down. Delivery is at least once; consumers should ignore event IDs they have seen.
+func StartOutboxRelay(publisher Publisher) {
+	go func() {
+		for {
+			err := forEachTenant(context.TODO(), func(ctx context.Context) error {
+				return RelayOutbox(ctx, publisher)
+			})
+			if err != nil {
+				log.Println("Outbox relay:", err)
+			}
+			time.Sleep(outboxInterval)
+		}
+	}()
+}
+
+// RelayOutbox publishes the events that have not been published yet, oldest first,
+// stopping at the first failure so they stay in order
+func RelayOutbox(ctx context.Context, publisher Publisher) error {
+	if IsOffline() {
+		return nil
+	}
+	events, err := storeFor(ctx).Events().ListUnpublished(ctx, outboxBatch)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		publishCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err = publisher.Publish(publishCtx, BrokerMessage{Subject: eventSubject(ctx, event), Key: event.OrderID.Hex(), Data: data})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("publishing event %s: %w", event.ID.Hex(), err)
+		}
+		if err := storeFor(ctx).Events().MarkPublished(ctx, event.ID, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Budget is a month's targets for revenue and food cost, set by the managers
+type Budget struct {
+	Month     string    `bson:"month" json:"month"`       // YYYY-MM
+	Revenue   float64   `bson:"revenue" json:"revenue"`   // In rupees; 0 for no target
+	FoodCost  float64   `bson:"foodCost" json:"foodCost"` // In rupees; 0 for no target
+	By        string    `bson:"by" json:"by"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// SaveBudget sets a month's targets, replacing any set before
+func SaveBudget(ctx context.Context, budget Budget) (Budget, error) {
+	budget.Month = strings.TrimSpace(budget.Month)
+	if _, err := time.Parse("2006-01", budget.Month); err != nil {
+		return Budget{}, fmt.Errorf("the month must be formatted as YYYY-MM")
+	}
+	switch {
+	case budget.Revenue < 0 || budget.FoodCost < 0:
+		return Budget{}, fmt.Errorf("targets must not be negative")
+	case budget.Revenue == 0 && budget.FoodCost == 0:
+		return Budget{}, fmt.Errorf("set a revenue target, a food cost target or both")
+	}
+	budget.By, budget.UpdatedAt = changedBy(ctx), clock.Now()
+	if err := storeFor(ctx).Budgets().Save(ctx, budget); err != nil {
+		return Budget{}, err
+	}
+	return budget, nil
+}
+
+// ListBudgets returns every month's targets, latest first
+func ListBudgets(ctx context.Context) ([]Budget, error) {
+	budgets, err := storeFor(ctx).Budgets().List(ctx)
+	if budgets == nil {
+		budgets = []Budget{}
+	}
+	return budgets, err
+}
+
+// BudgetLine is one target against what was done so far
+type BudgetLine struct {
+	Target          float64 `json:"target"`
+	Actual          float64 `json:"actual"`
+	Variance        float64 `json:"variance"`        // Actual less the target
+	VariancePercent float64 `json:"variancePercent"` // Of the target
+	Projected       float64 `json:"projected"`       // The whole month at the pace so far
+	PacePercent     float64 `json:"pacePercent"`     // Projected less the target, as a percentage of it
+	Pace            string  `json:"pace"`            // e.g. "on track to miss by 4%"
+}
+
+// BudgetReport is a month's revenue and food cost against their targets
+type BudgetReport struct {
+	Month           string     `json:"month"`
+	From            time.Time  `json:"from"`
+	To              time.Time  `json:"to"`
+	Elapsed         float64    `json:"elapsed"` // Share of the month gone, from 0 to 1
+	Revenue         BudgetLine `json:"revenue"`
+	FoodCost        BudgetLine `json:"foodCost"`
+	FoodCostPercent float64    `json:"foodCostPercent"` // Of the revenue
+}
+
+// budgetPercent is part as a percentage of whole, to one decimal place
+func budgetPercent(part, whole float64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return math.Round(part/whole*1000) / 10
+}
+
+// budgetLine sets actual against target with the month elapsed gone. Going over is good for revenue and bad for
+// a cost, which the pace is worded for.
+func budgetLine(target, actual, elapsed float64, cost bool) BudgetLine {
+	line := BudgetLine{Target: target, Actual: actual, Variance: roundPaise(actual - target), Pace: "no target"}
+	if target == 0 {
+		return line
+	}
+	line.VariancePercent = budgetPercent(line.Variance, target)
+	if elapsed == 0 {
+		line.Pace = "not started"
+		return line
+	}
+	line.Projected = roundPaise(actual / elapsed)
+	line.PacePercent = budgetPercent(line.Projected-target, target)
+	by := strconv.FormatFloat(math.Abs(line.PacePercent), 'f', -1, 64) + "%"
+	over := line.PacePercent > 0
+	switch {
+	case elapsed == 1 && line.PacePercent == 0:
+		line.Pace = "hit it"
+	case elapsed == 1 && over == cost:
+		line.Pace = "missed by " + by
+	case elapsed == 1:
+		line.Pace = "beat by " + by
+	case line.PacePercent == 0:
+		line.Pace = "on track to hit it"
+	case over == cost:
+		line.Pace = "on track to miss by " + by
+	default:
+		line.Pace = "on track to beat by " + by
+	}
+	return line
+}
+
+// BuildBudgetReport sets the month's revenue and food cost so far, as of now, against its targets
+func BuildBudgetReport(ctx context.Context, month string) (BudgetReport, error) {
+	if _, err := time.Parse("2006-01", month); err != nil {
+		return BudgetReport{}, fmt.Errorf("the month must be formatted as YYYY-MM")
+	}
+	budget, err := storeFor(ctx).Budgets().Find(ctx, month)
+	if err != nil {
+		return BudgetReport{}, fmt.Errorf("targets for %s: %w", month, err)
+	}
+	return budgetReport(ctx, budget, clock.Now())
+}
+
+// budgetReport works out the budget's month up to asOf. Revenue is what the orders placed came to, other than staff
+// meals. Food cost is what the items sold cost to make at the last prices paid for their ingredients, with the stock
+// written off; the pace projects both over the rest of the month at the rate so far.
+func budgetReport(ctx context.Context, budget Budget, asOf time.Time) (BudgetReport, error) {
+	start, err := time.ParseInLocation("2006-01", budget.Month, asOf.Location())
+	if err != nil {
+		return BudgetReport{}, err
+	}
+	end := start.AddDate(0, 1, 0)
+	upTo := asOf
+	if upTo.Before(start) {
+		upTo = start
+	} else if upTo.After(end) {
+		upTo = end
+	}
+	report := BudgetReport{Month: budget.Month, From: start, To: end, Elapsed: float64(upTo.Sub(start)) / float64(end.Sub(start))}
+
+	orders, err := LoadOrdersBetween(ctx, start, upTo)
+	if err != nil {
+		return BudgetReport{}, err
+	}
+	costs, err := stockCosts(ctx)
+	if err != nil {
+		return BudgetReport{}, err
+	}
+	menu := LoadMenu(ctx)
+	var revenue, foodCost float64
+	for _, order := range slices.DeleteFunc(orders, staffMeal) {
+		revenue += order.Total
+		for _, line := range order.Items {
+			if item, found := FindMenuItem(menu, line.Name); found && !line.Open {
+				foodCost += itemCost(costs, item) * float64(line.Quantity)
+			}
+		}
+	}
+	writeOffs, err := storeFor(ctx).Stock().ListWriteOffs(ctx, currentBranch, start, upTo)
+	if err != nil {
+		return BudgetReport{}, err
+	}
+	for _, writeOff := range writeOffs {
+		foodCost += writeOff.Value
+	}
+	revenue, foodCost = roundPaise(revenue), roundPaise(foodCost)
+
+	report.Revenue = budgetLine(budget.Revenue, revenue, report.Elapsed, false)
+	report.FoodCost = budgetLine(budget.FoodCost, foodCost, report.Elapsed, true)
+	report.FoodCostPercent = budgetPercent(foodCost, revenue)
+	return report, nil
+}
+
+// ShowBudgetReport prints the month's revenue and food cost against their targets, with the pace of each
+func ShowBudgetReport(ctx context.Context, month string) error {
+	report, err := BuildBudgetReport(ctx, month)
+	if err != nil {
+		return err
+	}
+	title := fmt.Sprintf("Budget for %s, %.0f%% of the month gone, food cost %.1f%% of revenue:", report.From.Format("January 2006"),
+		report.Elapsed*100, report.FoodCostPercent)
+	budgetListing := listing{title: title, header: []string{"", "Target", "Actual", "Variance", "Projected", "Pace"}, records: report}
+	for _, row := range []struct {
+		name string
+		line BudgetLine
+	}{{"Revenue", report.Revenue}, {"Food cost", report.FoodCost}} {
+		budgetListing.rows = append(budgetListing.rows, []string{
+			row.name, fmt.Sprintf("Rs %.2f", row.line.Target), fmt.Sprintf("Rs %.2f", row.line.Actual),
+			fmt.Sprintf("Rs %.2f (%+.1f%%)", row.line.Variance, row.line.VariancePercent), fmt.Sprintf("Rs %.2f", row.line.Projected), row.line.Pace,
+		})
+		budgetListing.compact = append(budgetListing.compact, fmt.Sprintf("%s Rs %.2f of Rs %.2f, %s", row.name, row.line.Actual,
+			row.line.Target, row.line.Pace))
+	}
+	return printListing(os.Stdout, budgetListing)
+}
+
+// ShowBudgets prints every month's targets, latest first
+func ShowBudgets(ctx context.Context) error {
+	budgets, err := ListBudgets(ctx)
+	if err != nil {
+		return err
+	}
+	budgetListing := listing{title: "Budgets:", header: []string{"Month", "Revenue", "Food cost", "Set by"}, records: budgets}
+	for _, budget := range budgets {
+		budgetListing.rows = append(budgetListing.rows, []string{
+			budget.Month, fmt.Sprintf("%.2f", budget.Revenue), fmt.Sprintf("%.2f", budget.FoodCost), budget.By,
+		})
+		budgetListing.compact = append(budgetListing.compact, fmt.Sprintf("%s revenue Rs %.2f, food cost Rs %.2f", budget.Month,
+			budget.Revenue, budget.FoodCost))
+	}
+	return printListing(os.Stdout, budgetListing)
+}
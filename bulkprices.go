@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// ErrNotPriceUpdate is returned when rolling back a menu version that was not a bulk price update
+var ErrNotPriceUpdate = errors.New("is not a bulk price update")
+
+// PriceAdjustment changes many prices at once, e.g. +5% on all beverages rounded to the nearest 5.
+// The percentage is applied first, then the amount, then the rounding.
+type PriceAdjustment struct {
+	Category string  `bson:"category,omitempty" json:"category,omitempty"` // Only items in this category; the whole menu when empty
+	Percent  float64 `bson:"percent,omitempty" json:"percent,omitempty"`   // e.g. 5 for +5%, -10 for a 10% cut
+	Amount   float64 `bson:"amount,omitempty" json:"amount,omitempty"`     // Rupees added to each price, or taken off when negative
+	RoundTo  float64 `bson:"roundTo,omitempty" json:"roundTo,omitempty"`   // New prices are rounded to the nearest multiple; to the paisa when 0
+}
+
+// PriceUpdate is one item's price before and after a bulk change
+type PriceUpdate struct {
+	Item     string  `bson:"item" json:"item"`
+	OldPrice float64 `bson:"oldPrice" json:"oldPrice"`
+	NewPrice float64 `bson:"newPrice" json:"newPrice"`
+}
+
+// PriceUpdateResult lists the prices a bulk change sets. Version is the menu version published with
+// them, and 0 for a dry run.
+type PriceUpdateResult struct {
+	Version int           `json:"version,omitempty"`
+	Changes []PriceUpdate `json:"changes"`
+	Skipped []string      `json:"skipped,omitempty"` // Items a rollback left alone because their price was changed again since
+}
+
+// validate checks the adjustment changes something and cannot make prices meaningless
+func (a PriceAdjustment) validate() error {
+	if a.Percent == 0 && a.Amount == 0 && a.RoundTo == 0 {
+		return fmt.Errorf("give a percent, an amount or a rounding to change prices by")
+	}
+	if a.Percent <= -100 {
+		return fmt.Errorf("percent must be above -100")
+	}
+	if a.RoundTo < 0 {
+		return fmt.Errorf("roundTo must not be negative")
+	}
+	return nil
+}
+
+// apply returns the new price for an item priced at price
+func (a PriceAdjustment) apply(price float64) float64 {
+	price = price*(1+a.Percent/100) + a.Amount
+	if a.RoundTo > 0 {
+		price = math.Round(price/a.RoundTo) * a.RoundTo
+	}
+	return roundPaise(price)
+}
+
+// String describes the adjustment for the menu version's note, e.g. "+5% on beverages, rounded to the nearest 5"
+func (a PriceAdjustment) String() string {
+	var parts []string
+	if a.Percent != 0 {
+		parts = append(parts, fmt.Sprintf("%+g%%", a.Percent))
+	}
+	if a.Amount != 0 {
+		parts = append(parts, fmt.Sprintf("%+g rupees", a.Amount))
+	}
+	description := strings.Join(parts, " and ")
+	if description == "" {
+		description = "prices"
+	}
+	if a.Category != "" {
+		description += " on " + a.Category
+	} else {
+		description += " on the whole menu"
+	}
+	if a.RoundTo > 0 {
+		description += fmt.Sprintf(", rounded to the nearest %g", a.RoundTo)
+	}
+	return description
+}
+
+// adjustPrices applies the adjustment to a copy of items, returning the copy and the prices that changed
+func adjustPrices(items []MenuItem, adjustment PriceAdjustment) ([]MenuItem, []PriceUpdate, error) {
+	adjusted := make([]MenuItem, len(items))
+	copy(adjusted, items)
+	changes := []PriceUpdate{}
+	var matched bool
+	for i, item := range adjusted {
+		if adjustment.Category != "" && !strings.EqualFold(item.Category, adjustment.Category) {
+			continue
+		}
+		matched = true
+		price := adjustment.apply(item.Price)
+		if price <= 0 {
+			return nil, nil, fmt.Errorf("%s would cost Rs %.2f", item.Name, price)
+		}
+		if price != item.Price {
+			adjusted[i].Price = price
+			changes = append(changes, PriceUpdate{Item: item.Name, OldPrice: item.Price, NewPrice: price})
+		}
+	}
+	if !matched {
+		return nil, nil, fmt.Errorf("no items in category %s", adjustment.Category)
+	}
+	return adjusted, changes, nil
+}
+
+// PreviewPriceAdjustment shows the old and new price of every item the adjustment would change,
+// without changing anything
+func PreviewPriceAdjustment(ctx context.Context, adjustment PriceAdjustment) (PriceUpdateResult, error) {
+	if err := adjustment.validate(); err != nil {
+		return PriceUpdateResult{}, err
+	}
+	_, changes, err := adjustPrices(LoadMenu(ctx), adjustment)
+	return PriceUpdateResult{Changes: changes}, err
+}
+
+// ApplyPriceAdjustment publishes the adjusted prices as a new menu version. A draft being edited gets
+// the same adjustment, so publishing it later does not undo the new prices.
+func ApplyPriceAdjustment(ctx context.Context, adjustment PriceAdjustment) (PriceUpdateResult, error) {
+	if err := adjustment.validate(); err != nil {
+		return PriceUpdateResult{}, err
+	}
+	items, changes, err := adjustPrices(LoadMenu(ctx), adjustment)
+	if err != nil {
+		return PriceUpdateResult{}, err
+	}
+	if len(changes) == 0 {
+		return PriceUpdateResult{}, fmt.Errorf("no price would change")
+	}
+	version, err := publishMenu(ctx, MenuVersion{
+		Items: items, Note: "Price update: " + adjustment.String(), Adjustment: &adjustment, PriceUpdates: changes,
+	})
+	if err != nil {
+		return PriceUpdateResult{}, err
+	}
+
+	result := PriceUpdateResult{Version: version.Version, Changes: changes}
+	draft, err := storeFor(ctx).MenuVersions().LoadDraft(ctx)
+	if errors.Is(err, ErrNotFound) {
+		return result, nil
+	}
+	if err == nil {
+		draft, _, err = adjustPrices(draft, adjustment)
+	}
+	if err == nil {
+		err = storeFor(ctx).MenuVersions().SaveDraft(ctx, draft)
+	}
+	if err != nil {
+		return result, fmt.Errorf("prices were updated but not in the draft: %w", err)
+	}
+	return result, nil
+}
+
+// RollbackPriceAdjustment puts back the prices a bulk update changed, as a new menu version. Items whose
+// price has been changed again since, or that were taken off the menu, are left alone, as is everything
+// else about the menu.
+func RollbackPriceAdjustment(ctx context.Context, number int) (PriceUpdateResult, error) {
+	updated, err := FindMenuVersion(ctx, number)
+	if err != nil {
+		return PriceUpdateResult{}, err
+	}
+	if updated.Adjustment == nil {
+		return PriceUpdateResult{}, fmt.Errorf("menu version %d %w", number, ErrNotPriceUpdate)
+	}
+
+	live := LoadMenu(ctx)
+	items := make([]MenuItem, len(live))
+	copy(items, live)
+	result := PriceUpdateResult{Changes: []PriceUpdate{}}
+	for _, update := range updated.PriceUpdates {
+		i := slices.IndexFunc(items, func(item MenuItem) bool { return item.Name == update.Item })
+		if i < 0 {
+			continue
+		}
+		if items[i].Price != update.NewPrice {
+			result.Skipped = append(result.Skipped, update.Item)
+			continue
+		}
+		items[i].Price = update.OldPrice
+		result.Changes = append(result.Changes, PriceUpdate{Item: update.Item, OldPrice: update.NewPrice, NewPrice: update.OldPrice})
+	}
+	if len(result.Changes) == 0 {
+		return result, fmt.Errorf("every price set in menu version %d has already been changed again or rolled back", number)
+	}
+	version, err := publishMenu(ctx, MenuVersion{Items: items, Note: fmt.Sprintf("Rollback of the price update in version %d", number)})
+	if err != nil {
+		return PriceUpdateResult{}, err
+	}
+	result.Version = version.Version
+	return result, nil
+}
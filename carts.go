@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// defaultParkedCartTTL is how long a parked cart waits to be recalled when RMS_PARKED_CART_TTL is not set
+const defaultParkedCartTTL = 2 * time.Hour
+
+// parkedCartTTL is how long a parked cart waits to be recalled before it is forgotten, from RMS_PARKED_CART_TTL
+var parkedCartTTL = defaultParkedCartTTL
+
+// SetParkedCartTTL sets how long parked carts are kept from a duration like "90m"; empty keeps two hours
+func SetParkedCartTTL(spec string) error {
+	parkedCartTTL = defaultParkedCartTTL
+	if spec == "" {
+		return nil
+	}
+	ttl, err := time.ParseDuration(spec)
+	if err != nil || ttl <= 0 {
+		return fmt.Errorf("invalid parked cart time %q (want a duration like 90m)", spec)
+	}
+	parkedCartTTL = ttl
+	return nil
+}
+
+// ParkedCart is an order still being taken, put aside so the terminal can serve someone else and recalled later
+// by its table or customer's name, on any terminal. A table has at most one parked cart.
+type ParkedCart struct {
+	ID           primitive.ObjectID `bson:"_id" json:"id"`
+	CustomerName string             `bson:"customerName" json:"customerName"`
+	Table        int                `bson:"table,omitempty" json:"table,omitempty"`
+	Type         string             `bson:"type" json:"type"`
+	Items        []OrderLine        `bson:"items" json:"items"`
+	Notes        string             `bson:"notes,omitempty" json:"notes,omitempty"`
+	Waiter       string             `bson:"waiter,omitempty" json:"waiter,omitempty"`
+	ParkedBy     string             `bson:"parkedBy" json:"parkedBy"`
+	ParkedAt     time.Time          `bson:"parkedAt" json:"parkedAt"`
+	ExpiresAt    time.Time          `bson:"expiresAt" json:"expiresAt"`
+}
+
+// Order returns the order the cart was being made into, to carry on with
+func (c ParkedCart) Order() Order {
+	return Order{CustomerName: c.CustomerName, Table: c.Table, Type: c.Type, Items: c.Items, Notes: c.Notes, Waiter: c.Waiter}
+}
+
+// ParkCart puts an order still being taken aside until it is recalled or RMS_PARKED_CART_TTL passes. A waiter's key
+// parks it as theirs.
+func ParkCart(ctx context.Context, order Order) (ParkedCart, error) {
+	if len(order.Items) == 0 {
+		return ParkedCart{}, fmt.Errorf("the cart is empty")
+	}
+	if err := cleanOrderInstructions(&order); err != nil {
+		return ParkedCart{}, err
+	}
+	order.CustomerName = strings.TrimSpace(order.CustomerName)
+	if order.CustomerName == "" && order.Table == 0 {
+		return ParkedCart{}, fmt.Errorf("name the customer or table the cart is for, to recall it by")
+	}
+	if order.Type == "" {
+		order.Type = OrderDineIn
+	}
+	if order.Type == OrderTakeaway || order.Type == OrderDelivery {
+		order.Table = 0
+	}
+	if record, ok := APIKeyFrom(ctx); ok && record.Role == RoleWaiter {
+		order.Waiter = record.Staff
+	}
+	now := clock.Now()
+	cart := ParkedCart{
+		ID: primitive.NewObjectID(), CustomerName: order.CustomerName, Table: order.Table, Type: order.Type, Items: order.Items,
+		Notes: order.Notes, Waiter: strings.TrimSpace(order.Waiter), ParkedBy: changedBy(ctx), ParkedAt: now, ExpiresAt: now.Add(parkedCartTTL),
+	}
+	err := storeFor(ctx).ParkedCarts().Park(ctx, cart)
+	if errors.Is(err, ErrDuplicate) {
+		return ParkedCart{}, fmt.Errorf("table %d already has a parked cart; recall it first", cart.Table)
+	}
+	if err != nil {
+		return ParkedCart{}, err
+	}
+	return cart, nil
+}
+
+// ListParkedCarts returns the carts waiting to be recalled, oldest first. A waiter's key sees only their own.
+func ListParkedCarts(ctx context.Context) ([]ParkedCart, error) {
+	carts, err := storeFor(ctx).ParkedCarts().List(ctx, clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	record, ok := APIKeyFrom(ctx)
+	scoped := []ParkedCart{}
+	for _, cart := range carts {
+		if !ok || record.Role != RoleWaiter || strings.EqualFold(cart.Waiter, record.Staff) {
+			scoped = append(scoped, cart)
+		}
+	}
+	return scoped, nil
+}
+
+// RecallCart takes a parked cart back to carry on with the order: the table's cart when table is set, otherwise
+// the newest parked for the customer. It is no longer parked afterwards, so two terminals cannot both recall it.
+func RecallCart(ctx context.Context, table int, customerName string) (ParkedCart, error) {
+	customerName = strings.TrimSpace(customerName)
+	if table == 0 && customerName == "" {
+		return ParkedCart{}, fmt.Errorf("give the table or customer's name to recall the cart by")
+	}
+	carts, err := ListParkedCarts(ctx)
+	if err != nil {
+		return ParkedCart{}, err
+	}
+	for i := len(carts) - 1; i >= 0; i-- {
+		if (table > 0 && carts[i].Table == table) || (table == 0 && strings.EqualFold(carts[i].CustomerName, customerName)) {
+			return storeFor(ctx).ParkedCarts().Take(ctx, carts[i].ID)
+		}
+	}
+	return ParkedCart{}, ErrNotFound
+}
+
+// TakeParkedCart recalls or discards a parked cart by id. One that has expired, or another waiter's when a
+// waiter's key asks, is treated as not found.
+func TakeParkedCart(ctx context.Context, id primitive.ObjectID) (ParkedCart, error) {
+	carts, err := ListParkedCarts(ctx)
+	if err != nil {
+		return ParkedCart{}, err
+	}
+	if !slices.ContainsFunc(carts, func(cart ParkedCart) bool { return cart.ID == id }) {
+		return ParkedCart{}, ErrNotFound
+	}
+	return storeFor(ctx).ParkedCarts().Take(ctx, id)
+}
+
+// ShowParkedCarts prints the carts waiting to be recalled
+func ShowParkedCarts(ctx context.Context) error {
+	carts, err := ListParkedCarts(ctx)
+	if err != nil {
+		return err
+	}
+	cartListing := listing{
+		title:   "Parked carts:",
+		header:  []string{"ID", "Customer", "Table", "Items", "Total", "Waiter", "Parked", "Expires"},
+		records: carts,
+	}
+	for _, cart := range carts {
+		table := "-"
+		if cart.Table > 0 {
+			table = fmt.Sprint(cart.Table)
+		}
+		cartListing.rows = append(cartListing.rows, []string{
+			cart.ID.Hex(), cart.CustomerName, table, fmt.Sprint(len(cart.Items)), fmt.Sprintf("%.2f", CartTotal(cart.Items)),
+			cart.Waiter, cart.ParkedAt.Format("15:04"), cart.ExpiresAt.Format("15:04"),
+		})
+		cartListing.compact = append(cartListing.compact, fmt.Sprintf("%s %s table %s, Rs %.2f, parked %s", cart.ID.Hex(),
+			cart.CustomerName, table, CartTotal(cart.Items), cart.ParkedAt.Format("15:04")))
+	}
+	return printListing(os.Stdout, cartListing)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Names of the charges worked out from the menu
+const (
+	ChargePackaging        = "Packaging"
+	ChargeContainerDeposit = "Container deposit"
+)
+
+// OrderCharge is an amount added to a bill on top of its lines, e.g. a packaging charge or delivery fee
+type OrderCharge struct {
+	Name       string  `bson:"name" json:"name"`
+	Amount     float64 `bson:"amount" json:"amount"`
+	Refundable bool    `bson:"refundable,omitempty" json:"refundable,omitempty"` // A deposit, handed back later and not taxed
+	// ServicePercent is the service charge's percentage of the food, which Amount follows as the bill changes
+	ServicePercent float64 `bson:"servicePercent,omitempty" json:"servicePercent,omitempty"`
+}
+
+// ChargeRule adds a charge to delivery orders: a flat amount or a percentage of the food, optionally only below a
+// minimum order or during a time window, e.g. a small-order fee or a peak-hour fee
+type ChargeRule struct {
+	Name    string      `json:"name"`
+	Amount  float64     `json:"amount"`
+	Percent bool        `json:"percent,omitempty"` // Amount is a percentage of the food rather than rupees
+	Below   float64     `json:"below,omitempty"`   // Only orders whose food comes to less than this pay it; 0 for all
+	Window  *TimeWindow `json:"window,omitempty"`  // Only orders placed in this window pay it
+}
+
+// deliveryCharges are the rules charged on delivery orders, from RMS_DELIVERY_CHARGES
+var deliveryCharges []ChargeRule
+
+// packagingCharge is charged once on every takeaway and delivery order, besides its items' own. It is read
+// from RMS_PACKAGING_CHARGE.
+var packagingCharge float64
+
+// SetPackagingCharge sets the packaging charged on every takeaway and delivery order; empty charges none
+func SetPackagingCharge(spec string) error {
+	packagingCharge = 0
+	if strings.TrimSpace(spec) == "" {
+		return nil
+	}
+	amount, err := strconv.ParseFloat(strings.TrimSpace(spec), 64)
+	if err != nil || amount < 0 {
+		return fmt.Errorf("invalid packaging charge %q (want an amount in rupees)", spec)
+	}
+	packagingCharge = amount
+	return nil
+}
+
+// SetDeliveryCharges sets the delivery charge rules from a spec like
+// "packaging=20,small order=30<300,peak hour=40@19:00-22:00,surge=5%@20:00-21:00": a name and an amount, with % for
+// a percentage of the food, <min to charge only smaller orders and @HH:MM-HH:MM to charge only in that window
+func SetDeliveryCharges(spec string) error {
+	var rules []ChargeRule
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, rest, ok := strings.Cut(entry, "=")
+		rule := ChargeRule{Name: strings.TrimSpace(name)}
+		if !ok || rule.Name == "" {
+			return fmt.Errorf("invalid delivery charge %q (want name=amount)", entry)
+		}
+		amount, window, hasWindow := strings.Cut(rest, "@")
+		if hasWindow {
+			from, until, _ := strings.Cut(window, "-")
+			rule.Window = &TimeWindow{From: strings.TrimSpace(from), Until: strings.TrimSpace(until)}
+			if err := rule.Window.Validate(); err != nil {
+				return fmt.Errorf("delivery charge %s: %w", rule.Name, err)
+			}
+		}
+		amount, below, hasBelow := strings.Cut(amount, "<")
+		if hasBelow {
+			minimum, err := strconv.ParseFloat(strings.TrimSpace(below), 64)
+			if err != nil || minimum <= 0 {
+				return fmt.Errorf("invalid minimum order %q for delivery charge %s", below, rule.Name)
+			}
+			rule.Below = minimum
+		}
+		amount = strings.TrimSpace(amount)
+		amount, rule.Percent = strings.CutSuffix(amount, "%")
+		value, err := strconv.ParseFloat(strings.TrimSpace(amount), 64)
+		if err != nil || value <= 0 {
+			return fmt.Errorf("invalid amount %q for delivery charge %s", amount, rule.Name)
+		}
+		rule.Amount = value
+		rules = append(rules, rule)
+	}
+	deliveryCharges = rules
+	return nil
+}
+
+// applyCharges returns the charges the rules add to food coming to subtotal, ordered at t
+func applyCharges(rules []ChargeRule, subtotal float64, t time.Time) []OrderCharge {
+	var charges []OrderCharge
+	for _, rule := range rules {
+		if (rule.Below > 0 && subtotal >= rule.Below) || (rule.Window != nil && !rule.Window.Contains(t)) {
+			continue
+		}
+		amount := rule.Amount
+		if rule.Percent {
+			amount = roundPaise(subtotal * rule.Amount / 100)
+		}
+		charges = append(charges, OrderCharge{Name: rule.Name, Amount: amount})
+	}
+	return charges
+}
+
+// orderCharges works out the charges of a new order from the menu: the service charge on dine-in orders, packaging
+// on takeaway and delivery orders, the deposit on reusable containers if the customer takes them, and the delivery
+// charge rules
+func orderCharges(menu []MenuItem, order Order) []OrderCharge {
+	var charges []OrderCharge
+	if order.Type == OrderDineIn {
+		return serviceCharges(order)
+	}
+	if order.Type != OrderTakeaway && order.Type != OrderDelivery {
+		return nil
+	}
+	packaging, deposit := packagingCharge, 0.0
+	for _, line := range order.Items {
+		item, _ := FindMenuItem(menu, line.Name)
+		packaging += item.Packaging * float64(line.Quantity)
+		deposit += item.ContainerDeposit * float64(line.Quantity)
+	}
+	if packaging > 0 {
+		charges = append(charges, OrderCharge{Name: ChargePackaging, Amount: roundPaise(packaging)})
+	}
+	if order.ReusableContainers && deposit > 0 {
+		charges = append(charges, OrderCharge{Name: ChargeContainerDeposit, Amount: roundPaise(deposit), Refundable: true})
+	}
+	if order.Type == OrderDelivery {
+		charges = append(charges, applyCharges(deliveryCharges, CartTotal(order.Items), order.CreatedAt)...)
+	}
+	return charges
+}
+
+// chargesTotal sums the order's charges
+func chargesTotal(charges []OrderCharge) float64 {
+	var total float64
+	for _, charge := range charges {
+		total += charge.Amount
+	}
+	return roundPaise(total)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CODCollection is cash a driver took at the door for a delivery order. The driver holds it until they settle up
+// back at the restaurant.
+type CODCollection struct {
+	ID           primitive.ObjectID `bson:"_id" json:"paymentId"` // The payment's
+	OrderID      primitive.ObjectID `bson:"orderId" json:"orderId"`
+	Driver       string             `bson:"driver" json:"driver"`
+	Amount       float64            `bson:"amount" json:"amount"`
+	CollectedAt  time.Time          `bson:"collectedAt" json:"collectedAt"`
+	SettlementID primitive.ObjectID `bson:"settlementId,omitempty" json:"settlementId,omitzero"` // Set once the driver handed it in
+}
+
+// DriverSettlement is a driver handing in the cash on delivery they collected, checked against what they should have
+type DriverSettlement struct {
+	ID          primitive.ObjectID   `bson:"_id" json:"id"`
+	Driver      string               `bson:"driver" json:"driver"`
+	Collections []primitive.ObjectID `bson:"collections" json:"collections"` // Payments settled
+	Expected    float64              `bson:"expected" json:"expected"`
+	HandedIn    float64              `bson:"handedIn" json:"handedIn"`
+	Variance    float64              `bson:"variance" json:"variance"` // Handed in minus expected
+	Flagged     bool                 `bson:"flagged,omitempty" json:"flagged,omitempty"`
+	DrawerID    primitive.ObjectID   `bson:"drawerId,omitempty" json:"drawerId,omitzero"` // Drawer session the cash went into, if one was open
+	SettledBy   string               `bson:"settledBy" json:"settledBy"`
+	SettledAt   time.Time            `bson:"settledAt" json:"settledAt"`
+}
+
+// CODOutstanding is the cash on delivery a driver collected on one day and has not handed in
+type CODOutstanding struct {
+	Driver string  `json:"driver"`
+	Day    string  `json:"day"` // YYYY-MM-DD
+	Orders int     `json:"orders"`
+	Amount float64 `json:"amount"`
+}
+
+// collectCOD records the cash a driver took for a payment, once; replaying the payment does not count it again
+func collectCOD(ctx context.Context, payment Payment) error {
+	if payment.Driver == "" {
+		return nil
+	}
+	collection := CODCollection{
+		ID: payment.ID, OrderID: payment.OrderID, Driver: payment.Driver, Amount: payment.Amount, CollectedAt: payment.CreatedAt,
+	}
+	if err := storeFor(ctx).Drivers().AddCollection(ctx, collection); err != nil && !errors.Is(err, ErrDuplicate) {
+		return err
+	}
+	return nil
+}
+
+// SettleDriver takes in the cash a driver hands over for the cash on delivery they hold, putting it in the open
+// drawer. A count off what was collected by more than the tolerance is flagged.
+func SettleDriver(ctx context.Context, driver string, handedIn float64) (DriverSettlement, error) {
+	driver = strings.TrimSpace(driver)
+	if driver == "" {
+		return DriverSettlement{}, fmt.Errorf("driver is required")
+	}
+	if handedIn < 0 {
+		return DriverSettlement{}, fmt.Errorf("cash handed in must not be negative")
+	}
+	now := clock.Now()
+	settlement := DriverSettlement{
+		ID: primitive.NewObjectID(), Driver: driver, Collections: []primitive.ObjectID{}, HandedIn: roundPaise(handedIn),
+		SettledBy: changedBy(ctx), SettledAt: now,
+	}
+	// Claiming the collections first settles each one once, even if the driver is settled on two terminals
+	collections, err := storeFor(ctx).Drivers().Claim(ctx, driver, settlement.ID, now)
+	if err != nil {
+		return DriverSettlement{}, err
+	}
+	if len(collections) == 0 {
+		return DriverSettlement{}, fmt.Errorf("%s holds no cash on delivery", driver)
+	}
+	for _, collection := range collections {
+		settlement.Collections = append(settlement.Collections, collection.ID)
+		settlement.Expected += collection.Amount
+	}
+	settlement.Expected = roundPaise(settlement.Expected)
+	settlement.Variance = roundPaise(settlement.HandedIn - settlement.Expected)
+	settlement.Flagged = math.Abs(settlement.Variance) > cashVarianceTolerance
+	if drawerID, err := openDrawerID(ctx); err == nil && !drawerID.IsZero() && settlement.HandedIn > 0 {
+		settlement.DrawerID = drawerID
+	}
+	if err := storeFor(ctx).Drivers().AddSettlement(ctx, settlement); err != nil {
+		if releaseErr := storeFor(ctx).Drivers().Release(ctx, settlement.ID); releaseErr != nil {
+			log.Println("Error releasing the cash on delivery of a failed settlement:", releaseErr)
+		}
+		return DriverSettlement{}, err
+	}
+	if !settlement.DrawerID.IsZero() {
+		movement := CashMovement{Type: CashIn, Amount: settlement.HandedIn, Reason: "Cash on delivery from " + driver, By: settlement.SettledBy, At: now}
+		if err := storeFor(ctx).Drawers().AddMovement(ctx, settlement.DrawerID, movement); err != nil {
+			log.Println("Error putting a driver's cash on delivery in the drawer:", err)
+		}
+	}
+	if settlement.Flagged {
+		log.Printf("Driver %s settled cash on delivery with a variance of Rs %.2f (expected Rs %.2f, handed in Rs %.2f)",
+			driver, settlement.Variance, settlement.Expected, settlement.HandedIn)
+	}
+	return settlement, nil
+}
+
+// OutstandingCOD totals the cash on delivery drivers hold by driver and the day it was collected, oldest day first
+func OutstandingCOD(ctx context.Context) ([]CODOutstanding, error) {
+	collections, err := storeFor(ctx).Drivers().ListUnsettled(ctx)
+	if err != nil {
+		return nil, err
+	}
+	outstanding := []CODOutstanding{}
+	for _, collection := range collections {
+		day := collection.CollectedAt.Local().Format(time.DateOnly)
+		i := slices.IndexFunc(outstanding, func(o CODOutstanding) bool { return o.Driver == collection.Driver && o.Day == day })
+		if i < 0 {
+			outstanding = append(outstanding, CODOutstanding{Driver: collection.Driver, Day: day})
+			i = len(outstanding) - 1
+		}
+		outstanding[i].Orders++
+		outstanding[i].Amount = roundPaise(outstanding[i].Amount + collection.Amount)
+	}
+	slices.SortFunc(outstanding, func(a, b CODOutstanding) int {
+		if c := strings.Compare(a.Day, b.Day); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Driver, b.Driver)
+	})
+	return outstanding, nil
+}
+
+// ListDriverSettlements returns the settlements made in [from, to), oldest first
+func ListDriverSettlements(ctx context.Context, from, to time.Time) ([]DriverSettlement, error) {
+	settlements, err := storeFor(ctx).Drivers().ListSettlements(ctx, from, to)
+	if settlements == nil {
+		settlements = []DriverSettlement{}
+	}
+	return settlements, err
+}
+
+// ShowOutstandingCOD prints the cash on delivery drivers have not handed in
+func ShowOutstandingCOD(ctx context.Context) error {
+	outstanding, err := OutstandingCOD(ctx)
+	if err != nil {
+		return err
+	}
+	codListing := listing{
+		title:   "Cash on delivery outstanding:",
+		header:  []string{"Day", "Driver", "Orders", "Amount"},
+		records: outstanding,
+	}
+	for _, o := range outstanding {
+		codListing.rows = append(codListing.rows, []string{o.Day, o.Driver, fmt.Sprint(o.Orders), fmt.Sprintf("%.2f", o.Amount)})
+		codListing.compact = append(codListing.compact, fmt.Sprintf("%s %s %d orders Rs %.2f", o.Day, o.Driver, o.Orders, o.Amount))
+	}
+	return printListing(os.Stdout, codListing)
+}
@@ -0,0 +1,2359 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sampleCustomer is who the terminal takes orders for until customers are picked at the counter
+const sampleCustomer = "Gadapa Raghavendra"
+
+// closers release what the command opened, newest first, once it has finished
+var closers []func()
+
+// closeApp runs the closers
+func closeApp() {
+	for i := len(closers) - 1; i >= 0; i-- {
+		closers[i]()
+	}
+	closers = nil
+}
+
+// appOptions say what a command needs from startApp
+type appOptions struct {
+	saas    bool // Host many tenants; the store opened only holds tenants and API keys
+	offline bool // The command can work from the offline queue while the database is unreachable
+}
+
+// startApp applies the configuration and opens the database, as every command that works with the
+// restaurant's data needs
+func startApp(cfg Config, offlinePath string, opts appOptions) error {
+	if err := SetClockStart(cfg); err != nil {
+		return err
+	}
+	if err := SetMenuHours(cfg.MenuHours); err != nil {
+		return fmt.Errorf("reading menu hours: %w", err)
+	}
+	if err := SetDryHours(cfg.DryHours); err != nil {
+		return err
+	}
+	if err := SetGST(cfg); err != nil {
+		return fmt.Errorf("reading GST settings: %w", err)
+	}
+	if err := SetTaxClasses(cfg.TaxClasses); err != nil {
+		return err
+	}
+	if err := SetCategoryTaxClasses(cfg.CategoryTaxClasses); err != nil {
+		return err
+	}
+	if err := SetPrices(cfg.Prices); err != nil {
+		return err
+	}
+	if err := SetPayRules(cfg); err != nil {
+		return fmt.Errorf("reading pay rules: %w", err)
+	}
+	if err := SetDepositPolicy(cfg); err != nil {
+		return fmt.Errorf("reading deposit policy: %w", err)
+	}
+	if err := SetBulkPricing(cfg.BulkPricing); err != nil {
+		return fmt.Errorf("reading bulk pricing: %w", err)
+	}
+	if err := SetOccasionOffer(cfg); err != nil {
+		return fmt.Errorf("reading the occasion offer: %w", err)
+	}
+	if err := SetLoyaltyTiers(cfg.LoyaltyTiers); err != nil {
+		return fmt.Errorf("reading loyalty tiers: %w", err)
+	}
+	if err := SetStaffRatios(cfg.StaffRatios); err != nil {
+		return fmt.Errorf("reading staff ratios: %w", err)
+	}
+	if err := SetExpiryWarning(cfg.ExpiryWarningDays); err != nil {
+		return fmt.Errorf("reading the expiry warning: %w", err)
+	}
+	if err := SetRoyalties(cfg.RoyaltyRates, cfg.RoyaltyExclude); err != nil {
+		return fmt.Errorf("reading royalties: %w", err)
+	}
+	if err := SetStations(cfg.Stations); err != nil {
+		return fmt.Errorf("reading kitchen stations: %w", err)
+	}
+	if err := SetPrinters(cfg.Printers); err != nil {
+		return fmt.Errorf("reading printers: %w", err)
+	}
+	if err := SetManagerPINs(cfg.ManagerPINs, cfg.OverrideDiscount); err != nil {
+		return fmt.Errorf("reading manager overrides: %w", err)
+	}
+	if err := SetDiscountLimits(cfg.DiscountLimits); err != nil {
+		return fmt.Errorf("reading discount limits: %w", err)
+	}
+	if err := SetReprintRoles(cfg.ReprintRoles); err != nil {
+		return fmt.Errorf("reading reprint roles: %w", err)
+	}
+	if err := SetOpenItemRoles(cfg.OpenItemRoles); err != nil {
+		return fmt.Errorf("reading open item roles: %w", err)
+	}
+	if err := SetStaffMealAllowances(cfg.StaffMealAllowance); err != nil {
+		return fmt.Errorf("reading staff meal allowances: %w", err)
+	}
+	if err := SetNumberFormat(cfg.NumberFormat); err != nil {
+		return err
+	}
+	if err := SetBillRounding(cfg.BillRounding); err != nil {
+		return err
+	}
+	if err := SetCurrencies(cfg.Currencies); err != nil {
+		return err
+	}
+	if err := SetOrderNumbers(cfg.OrderNumbers); err != nil {
+		return err
+	}
+	if err := SetArchiveAfter(cfg.ArchiveAfter); err != nil {
+		return err
+	}
+	if err := SetParkedCartTTL(cfg.ParkedCartTTL); err != nil {
+		return fmt.Errorf("reading parked cart time: %w", err)
+	}
+	if err := SetDeliveryCharges(cfg.DeliveryCharges); err != nil {
+		return fmt.Errorf("reading delivery charges: %w", err)
+	}
+	if err := SetPackagingCharge(cfg.PackagingCharge); err != nil {
+		return fmt.Errorf("reading packaging charge: %w", err)
+	}
+	if err := SetServiceCharge(cfg.ServiceCharge); err != nil {
+		return fmt.Errorf("reading service charge: %w", err)
+	}
+	if err := SetDaySummary(cfg.DaySummaryTo, cfg.OwnerSummaryTo, cfg.DaySummaryAt); err != nil {
+		return err
+	}
+	if err := SetCompensationLimit(cfg.CompensationLimit); err != nil {
+		return fmt.Errorf("reading compensation limit: %w", err)
+	}
+	if err := SetPhoneChannel(cfg.PhoneChannel); err != nil {
+		return fmt.Errorf("reading phone channel: %w", err)
+	}
+	if err := SetQuietHours(cfg.QuietHours); err != nil {
+		return err
+	}
+	if cfg.RequireTwoFactor != "" {
+		require, err := strconv.ParseBool(cfg.RequireTwoFactor)
+		if err != nil {
+			return fmt.Errorf("RMS_REQUIRE_2FA must be true or false")
+		}
+		requireTwoFactor = require
+	}
+	if cfg.CashierOneTerminal != "" {
+		oneTerminal, err := strconv.ParseBool(cfg.CashierOneTerminal)
+		if err != nil {
+			return fmt.Errorf("RMS_CASHIER_ONE_TERMINAL must be true or false")
+		}
+		cashierOneTerminal = oneTerminal
+	}
+	if cfg.RequireDevice != "" {
+		require, err := strconv.ParseBool(cfg.RequireDevice)
+		if err != nil {
+			return fmt.Errorf("RMS_REQUIRE_DEVICE must be true or false")
+		}
+		requireDevice = require
+	}
+	if cfg.MarketingConsent != "" {
+		needsConsent, err := strconv.ParseBool(cfg.MarketingConsent)
+		if err != nil {
+			return fmt.Errorf("RMS_MARKETING_CONSENT must be true or false")
+		}
+		marketingNeedsConsent = needsConsent
+	}
+	if cfg.Surveys != "" {
+		send, err := strconv.ParseBool(cfg.Surveys)
+		if err != nil {
+			return fmt.Errorf("RMS_SURVEYS must be true or false")
+		}
+		sendSurveys = send
+	}
+	if cfg.TrustProxy != "" {
+		trust, err := strconv.ParseBool(cfg.TrustProxy)
+		if err != nil {
+			return fmt.Errorf("RMS_TRUST_PROXY must be true or false")
+		}
+		trustProxy = trust
+	}
+	publicURL = strings.TrimSuffix(cfg.PublicURL, "/")
+	stockAlertTo = strings.TrimSpace(cfg.StockAlertTo)
+	printAlertTo = strings.TrimSpace(cfg.PrintAlertTo)
+	currentBranch = strings.TrimSpace(cfg.Branch)
+	debugf("Running with the %s profile on %s", cfg.Profile, cfg.Backend)
+
+	// The offline queue belongs to a single terminal, not a hosted service or a one-off command
+	if opts.offline && !opts.saas && offlinePath != "" {
+		if err := OpenOfflineStore(offlinePath); err != nil {
+			return fmt.Errorf("opening offline store: %w", err)
+		}
+	}
+
+	var err error
+	store, err = OpenStore(cfg)
+	if store == nil {
+		return fmt.Errorf("opening the database: %w", err)
+	}
+	closers = append(closers, func() { store.Close(context.TODO()) })
+	if err != nil {
+		if offlineStore == nil || !store.Unavailable(err) {
+			return fmt.Errorf("connecting to the database: %w", err)
+		}
+		GoOffline(err)
+		notify("Working offline: orders and payments will be synced when the database is reachable.")
+	} else if err := store.Migrate(context.TODO()); err != nil {
+		return fmt.Errorf("migrating the database: %w", err)
+	}
+	if opts.saas {
+		EnableTenants(cfg)
+		closers = append(closers, func() { tenants.Close(context.TODO()) })
+	}
+	return nil
+}
+
+// startTerminal starts the background work of a long-running command, syncing the offline queue, relaying events,
+// sending birthday and anniversary offers, queued notifications and end-of-day summaries, placing standing orders and
+// printing tickets, archiving old orders, and adds the sample menu, tables and customer if the profile seeds them
+func startTerminal(cfg Config, saas bool) error {
+	StartSync()
+	publisher, err := OpenPublisher(cfg)
+	if err != nil {
+		return fmt.Errorf("connecting to the message broker: %w", err)
+	}
+	if publisher != nil {
+		closers = append(closers, func() { publisher.Close() })
+		StartOutboxRelay(publisher)
+	}
+	StartOccasionOffers()
+	StartStockAlerts()
+	StartStandingOrders()
+	StartNotificationQueue()
+	StartPrintSpooler()
+	StartOrderArchive()
+	StartDaySummaries()
+
+	if seedSampleData && !IsOffline() && !saas {
+		// Add sample menu items (only runs once; you can comment it out if items are already in the database)
+		AddMenuItems(context.TODO())
+
+		// Add the dining tables shown on the table map
+		AddTables(context.TODO())
+
+		// Add a sample customer
+		AddCustomer(context.TODO(), sampleCustomer, "1234567890")
+	}
+	return nil
+}
+
+// showCustomersAfterwards lists the customers once the ordering session is over
+func showCustomersAfterwards() {
+	if IsOffline() {
+		notify("Customer list unavailable while offline.")
+		return
+	}
+	GetCustomers(context.TODO())
+}
+
+// parseDay reads a YYYY-MM-DD flag, defaulting to today
+func parseDay(flagName, value string) (time.Time, error) {
+	if value == "" {
+		return clock.Now(), nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("--%s must be formatted as YYYY-MM-DD", flagName)
+	}
+	return day, nil
+}
+
+// lastDays returns the range covering the given number of days up to the end of today
+func lastDays(days int) (time.Time, time.Time) {
+	today := clock.Now()
+	to := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, 1)
+	return to.AddDate(0, 0, -days), to
+}
+
+// newRootCommand builds the restaurant command and its subcommands. Run without a subcommand it
+// opens the full-screen dashboard.
+func newRootCommand() *cobra.Command {
+	var cfg Config
+	var profile, backend, offlinePath, format string
+	var jsonFlag, force bool
+
+	root := &cobra.Command{
+		Use:   "restaurant",
+		Short: "Take orders, run the kitchen and serve the restaurant's API",
+		Long: "Restaurant management: the ordering dashboard for the counter and kitchen, the HTTP API\n" +
+			"and admin dashboard, and commands for menus, orders, reports and accounts.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if jsonFlag {
+				EnableJSONOutput()
+			} else if err := SetListFormat(format); err != nil {
+				return err
+			}
+			var err error
+			if cfg, err = LoadConfig(profile); err != nil {
+				return err
+			}
+			if backend != "" {
+				cfg.Backend = backend
+			}
+			cfg.Force = force
+			if !cmd.Flags().Changed("offline-db") {
+				offlinePath = cfg.OfflinePath
+			}
+			return applyProfile(cfg)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if jsonOutput {
+				return fmt.Errorf("--json needs a command to run, such as menu list")
+			}
+			if err := startApp(cfg, offlinePath, appOptions{offline: true}); err != nil {
+				return err
+			}
+			if err := startTerminal(cfg, false); err != nil {
+				return err
+			}
+			if err := RunTUI(sampleCustomer); err != nil {
+				return fmt.Errorf("running dashboard: %w", err)
+			}
+			showCustomersAfterwards()
+			return nil
+		},
+	}
+	flags := root.PersistentFlags()
+	flags.StringVar(&profile, "profile", envOr("RMS_PROFILE", ProfileDev), "dev, staging or prod, each with its own databases (overrides RMS_PROFILE)")
+	flags.StringVar(&backend, "store", "", "storage backend: mongo, sqlite or postgres (overrides RMS_STORE)")
+	flags.BoolVar(&force, "force", false, "let commands that change data in bulk run against prod")
+	flags.StringVar(&offlinePath, "offline-db", "", "local file used to queue orders and payments while the database is unreachable, one per profile by default (empty to disable)")
+	flags.BoolVar(&ignoreMenuHours, "ignore-menu-hours", false, "offer every menu item whatever its serving hours (admin override)")
+	flags.StringVar(&format, "format", FormatTable, "how menus and lists are printed: table, json or compact")
+	flags.BoolVar(&jsonFlag, "json", false, "print results as JSON on stdout and messages and errors as JSON lines on stderr, for scripts")
+	root.RegisterFlagCompletionFunc("profile", cobra.FixedCompletions([]string{ProfileDev, ProfileStaging, ProfileProd}, cobra.ShellCompDirectiveNoFileComp))
+	root.RegisterFlagCompletionFunc("store", cobra.FixedCompletions([]string{"mongo", "sqlite", "postgres"}, cobra.ShellCompDirectiveNoFileComp))
+	root.RegisterFlagCompletionFunc("format", cobra.FixedCompletions([]string{FormatTable, FormatJSON, FormatCompact}, cobra.ShellCompDirectiveNoFileComp))
+
+	root.AddCommand(
+		newServeCommand(&cfg, &offlinePath),
+		newMenuCommand(&cfg),
+		newOrderCommand(&cfg, &offlinePath),
+		newCartCommand(&cfg),
+		newStandingOrderCommand(&cfg),
+		newDeliveryZoneCommand(&cfg),
+		newDriverCommand(&cfg),
+		newCurrencyCommand(&cfg),
+		newTemplateCommand(&cfg),
+		newPrintCommand(&cfg),
+		newCustomerCommand(&cfg),
+		newReportCommand(&cfg),
+		newStockCommand(&cfg),
+		newAPIKeyCommand(&cfg),
+		newSessionCommand(&cfg),
+		newDeviceCommand(&cfg),
+		newAccountsCommand(&cfg),
+		newLogBookCommand(&cfg),
+		newBudgetCommand(&cfg),
+		newDemoCommand(&cfg),
+		newBenchmarkCommand(&cfg),
+	)
+	return root
+}
+
+func newServeCommand(cfg *Config, offlinePath *string) *cobra.Command {
+	var requireKey, saas bool
+	cmd := &cobra.Command{
+		Use:   "serve [address]",
+		Short: "Serve the HTTP API and admin dashboard",
+		Long:  "Serve the HTTP API and admin dashboard on the address, localhost:8080 by default.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			addr := "localhost:8080"
+			if len(args) > 0 {
+				addr = args[0]
+			}
+			if err := startApp(*cfg, *offlinePath, appOptions{saas: saas, offline: true}); err != nil {
+				return err
+			}
+			if err := startTerminal(*cfg, saas); err != nil {
+				return err
+			}
+			return Serve(addr, requireKey)
+		},
+	}
+	cmd.Flags().BoolVar(&requireKey, "require-api-key", false, "reject API calls that do not send an API key")
+	cmd.Flags().BoolVar(&saas, "saas", false, "host many tenants, each with its own database, behind API keys")
+	return cmd
+}
+
+func newMenuCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "menu", Short: "Show and change the menu"}
+
+	var all bool
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "Show the items served now, or the whole menu with --all",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			if all {
+				return printMenu(LoadMenu(context.TODO()))
+			}
+			ShowMenu(context.TODO())
+			return nil
+		},
+	}
+	list.Flags().BoolVar(&all, "all", false, "include items outside their serving hours")
+
+	var item MenuItem
+	var gstRate float64
+	var recipe []string
+	add := &cobra.Command{
+		Use:   "add <name> <price>",
+		Short: "Add an item to the menu",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			price, err := strconv.ParseFloat(args[1], 64)
+			if err != nil {
+				return fmt.Errorf("price must be a number")
+			}
+			item.Name, item.Price = args[0], price
+			if cmd.Flags().Changed("gst-rate") {
+				item.GSTRate = &gstRate
+			}
+			if item.Recipe, err = parseRecipe(recipe); err != nil {
+				return err
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			if err := AddMenuItem(context.TODO(), item); err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("%s added to the menu at Rs %.2f%s", item.Name, item.Price, priceUnit(item)), item)
+			return nil
+		},
+	}
+	add.Flags().StringVar(&item.Category, "category", "", "category, e.g. mains; may have serving hours in RMS_MENU_HOURS")
+	add.Flags().StringSliceVar(&item.Allergens, "allergens", nil, "comma separated allergens, e.g. gluten,dairy")
+	add.Flags().StringSliceVar(&item.Diets, "diets", nil, "comma separated diets the item suits, e.g. vegetarian")
+	add.Flags().StringVar(&item.HSN, "hsn", "", "HSN or SAC code for GST")
+	add.Flags().Float64Var(&gstRate, "gst-rate", 0, "GST percentage included in the price, if not the configured rate")
+	add.Flags().StringVar(&item.TaxClass, "tax-class", "", "tax class from RMS_TAX_CLASSES, e.g. liquor, if not its category's")
+	add.Flags().StringSliceVar(&recipe, "recipe", nil, `comma separated ingredients of one serving, e.g. "flour=0.25 kg,mozzarella=0.12 kg"`)
+	add.Flags().StringSliceVar(&item.Barcodes, "barcodes", nil, "comma separated barcodes or PLU codes a packaged item is scanned by")
+	add.Flags().BoolVar(&item.SoldByWeight, "per-kg", false, "sell the item by weight, taking the price as the rate per kg")
+	add.Flags().BoolVar(&item.AgeRestricted, "age-restricted", false, "sell the item, e.g. alcohol, only once the guest's ID is checked and not in RMS_DRY_HOURS")
+
+	cmd.AddCommand(list, add, newBranchMenuCommand(cfg))
+	return cmd
+}
+
+func newOrderCommand(cfg *Config, offlinePath *string) *cobra.Command {
+	cmd := &cobra.Command{Use: "order", Short: "Place and follow orders"}
+
+	var customer string
+	var staffMealOrder bool
+	place := &cobra.Command{
+		Use:   "place",
+		Short: "Take an order at the counter with plain text prompts",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if jsonOutput {
+				return fmt.Errorf("placing an order is interactive and has no JSON output")
+			}
+			if err := startApp(*cfg, *offlinePath, appOptions{offline: true}); err != nil {
+				return err
+			}
+			if err := startTerminal(*cfg, false); err != nil {
+				return err
+			}
+			fmt.Println("\nWelcome to the Restaurant Ordering System!")
+			PlaceOrder(context.TODO(), customer, staffMealOrder)
+			showCustomersAfterwards()
+			return nil
+		},
+	}
+	place.Flags().StringVar(&customer, "customer", sampleCustomer, "who the order is for")
+	place.Flags().BoolVar(&staffMealOrder, "staff-meal", false, "take it as the --customer employee's own meal, billed at cost less their allowance")
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "Show the orders the kitchen is working on",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowOrders(context.TODO())
+		},
+	}
+
+	scheduled := &cobra.Command{
+		Use:   "scheduled",
+		Short: "Show the pre-orders and catering orders not yet due in the kitchen",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowPreOrders(context.TODO())
+		},
+	}
+
+	var saas bool
+	rebuild := &cobra.Command{
+		Use:   "rebuild",
+		Short: "Rebuild every order from its event history",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := confirmDestructive(*cfg, "Rebuilding orders"); err != nil {
+				return err
+			}
+			if err := startApp(*cfg, "", appOptions{saas: saas}); err != nil {
+				return err
+			}
+			var total int
+			err := forEachTenant(context.TODO(), func(ctx context.Context) error {
+				count, err := RebuildOrderProjections(ctx)
+				total += count
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("rebuilding orders: %w", err)
+			}
+			printResult(fmt.Sprintf("Rebuilt %d orders from their events", total), map[string]int{"orders": total})
+			return nil
+		},
+	}
+	rebuild.Flags().BoolVar(&saas, "saas", false, "rebuild the orders of every tenant")
+
+	var archiveMonths int
+	archive := &cobra.Command{
+		Use:   "archive",
+		Short: "Move served and paid orders older than --months, with their events, to the archive",
+		Long: "Moves old settled orders out of the live orders, which the kitchen, bills and exports work from. Reports,\n" +
+			"invoices and order history still read them from the archive. The server does this every day when\n" +
+			"RMS_ARCHIVE_AFTER is set.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := confirmDestructive(*cfg, "Archiving orders"); err != nil {
+				return err
+			}
+			if err := startApp(*cfg, "", appOptions{saas: saas}); err != nil {
+				return err
+			}
+			if !cmd.Flags().Changed("months") && archiveAfterMonths > 0 {
+				archiveMonths = archiveAfterMonths
+			}
+			var total int
+			err := forEachTenant(context.TODO(), func(ctx context.Context) error {
+				count, err := ArchiveOrders(ctx, archiveMonths)
+				total += count
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("archiving orders: %w", err)
+			}
+			printResult(fmt.Sprintf("Archived %d orders placed over %d months ago", total, archiveMonths), map[string]int{"orders": total})
+			return nil
+		},
+	}
+	archive.Flags().IntVar(&archiveMonths, "months", 12, "archive orders placed more than this many months ago; RMS_ARCHIVE_AFTER when set")
+	archive.Flags().BoolVar(&saas, "saas", false, "archive the orders of every tenant")
+
+	ticket := &cobra.Command{
+		Use:   "ticket <order-id>",
+		Short: "Print an order's kitchen ticket",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid order id %q", args[0])
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			order, err := FindOrder(context.TODO(), id)
+			if err != nil {
+				return err
+			}
+			printResult(strings.TrimSuffix(FormatKitchenTicket(order), "\n"), order)
+			return nil
+		},
+	}
+
+	var reason, by string
+	refund := &cobra.Command{
+		Use:   "refund <order-id> <amount>",
+		Short: "Record money handed back to the customer for an order",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid order id %q", args[0])
+			}
+			amount, err := strconv.ParseFloat(args[1], 64)
+			if err != nil {
+				return fmt.Errorf("amount must be a number")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			refund, err := RefundOrder(context.TODO(), id, amount, reason, by)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Refunded Rs %.2f for order %s", refund.Amount, id.Hex()), refund)
+			return nil
+		},
+	}
+	refund.Flags().StringVar(&reason, "reason", "", "why the money was handed back")
+	refund.Flags().StringVar(&by, "by", "", "who handed it back")
+
+	containers := &cobra.Command{
+		Use:   "containers",
+		Short: "Show the reusable containers still out with customers",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowOutstandingContainers(context.TODO())
+		},
+	}
+
+	returnContainers := &cobra.Command{
+		Use:   "return-containers <order-id> <count>",
+		Short: "Take back an order's reusable containers and refund their deposit",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid order id %q", args[0])
+			}
+			count, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("count must be a whole number")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			loan, refund, err := ReturnContainers(context.TODO(), id, count)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Refunded Rs %.2f for %d containers, %d still out", refund.Amount, count, loan.Outstanding), loan)
+			return nil
+		},
+	}
+
+	var override Override
+	var seat, course int
+	var code string
+	void := &cobra.Command{
+		Use:   "void <order-id> <item> <quantity>",
+		Short: "Take an item off an order; once sent to the kitchen it needs a manager's override",
+		Args:  cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid order id %q", args[0])
+			}
+			quantity, err := strconv.Atoi(args[2])
+			if err != nil {
+				return fmt.Errorf("quantity must be a whole number")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			void := ItemVoid{Name: args[1], Course: course, Seat: seat, Quantity: quantity}
+			order, err := VoidOrderItem(context.TODO(), id, 0, void, reason, override)
+			if err != nil {
+				return overrideHint(err)
+			}
+			printResult(fmt.Sprintf("Voided %d x %s; order %s now comes to Rs %.2f", quantity, args[1], id.Hex(), order.Total), order)
+			return nil
+		},
+	}
+	void.Flags().IntVar(&seat, "seat", 0, "seat the item is at; 0 for shared")
+	void.Flags().IntVar(&course, "course", 0, "course the item is in")
+
+	discount := &cobra.Command{
+		Use:   "discount <order-id> <percent>",
+		Short: "Take a percentage off an order's bill; large discounts need a manager's override",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid order id %q", args[0])
+			}
+			percent, err := strconv.ParseFloat(strings.TrimSuffix(args[1], "%"), 64)
+			if err != nil {
+				return fmt.Errorf("percent must be a number")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			order, err := DiscountOrder(context.TODO(), id, 0, percent, code, reason, override)
+			if err != nil {
+				return overrideHint(err)
+			}
+			printResult(fmt.Sprintf("Order %s discounted %g%% to Rs %.2f", id.Hex(), percent, order.Total), order)
+			return nil
+		},
+	}
+
+	discountItem := &cobra.Command{
+		Use:   "discount-item <order-id> <item> <quantity> <percent>",
+		Short: "Take a percentage off items on an order, or comp them at 100; comps need a manager's override",
+		Args:  cobra.ExactArgs(4),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid order id %q", args[0])
+			}
+			quantity, err := strconv.Atoi(args[2])
+			if err != nil {
+				return fmt.Errorf("quantity must be a whole number")
+			}
+			percent, err := strconv.ParseFloat(strings.TrimSuffix(args[3], "%"), 64)
+			if err != nil {
+				return fmt.Errorf("percent must be a number")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			discount := ItemDiscount{Name: args[1], Course: course, Seat: seat, Quantity: quantity, Percent: percent}
+			order, err := DiscountOrderItem(context.TODO(), id, 0, discount, code, reason, override)
+			if err != nil {
+				return overrideHint(err)
+			}
+			printResult(fmt.Sprintf("%d x %s discounted %g%%; order %s now comes to Rs %.2f", quantity, args[1], percent, id.Hex(), order.Total), order)
+			return nil
+		},
+	}
+	discountItem.Flags().IntVar(&seat, "seat", 0, "seat the item is at; 0 for shared")
+	discountItem.Flags().IntVar(&course, "course", 0, "course the item is in")
+	for _, step := range []*cobra.Command{discount, discountItem} {
+		step.Flags().StringVar(&code, "code", "", "reason code: "+strings.Join(discountReasons, ", "))
+		step.RegisterFlagCompletionFunc("code", cobra.FixedCompletions(discountReasons, cobra.ShellCompDirectiveNoFileComp))
+	}
+
+	reopen := &cobra.Command{
+		Use:   "reopen <order-id>",
+		Short: "Reopen a served order with a manager's override",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid order id %q", args[0])
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			order, err := ReopenOrder(context.TODO(), id, reason, override)
+			if err != nil {
+				return overrideHint(err)
+			}
+			printResult(fmt.Sprintf("Order %s reopened, now %s", id.Hex(), order.Status), order)
+			return nil
+		},
+	}
+	waiveService := &cobra.Command{
+		Use:   "waive-service <order-id>",
+		Short: "Take the service charge off an order's bill at the customer's request",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid order id %q", args[0])
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			order, err := WaiveServiceCharge(context.TODO(), id, 0, reason)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Service charge waived; order %s now comes to Rs %.2f", id.Hex(), order.Total), order)
+			return nil
+		},
+	}
+	waiveService.Flags().StringVar(&reason, "reason", "", "why the customer asked, kept on the order")
+	reprint := &cobra.Command{
+		Use:   "reprint <order-id>",
+		Short: "Print a settled order's receipt again, marked as a duplicate",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid order id %q", args[0])
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			receipt, order, err := ReprintReceipt(context.TODO(), id, reason)
+			if err != nil {
+				return err
+			}
+			printResult(strings.TrimSuffix(withoutPrinterCodes(receipt), "\n"), order)
+			return nil
+		},
+	}
+	reprint.Flags().StringVar(&reason, "reason", "", "why it is reprinted, kept in the order's history")
+
+	for _, step := range []*cobra.Command{void, discount, discountItem, reopen} {
+		step.Flags().StringVar(&reason, "reason", "", "why it is done, kept in the order's history")
+		step.Flags().StringVar(&override.Manager, "manager", "", "manager overriding")
+		step.Flags().StringVar(&override.PIN, "pin", "", "the manager's PIN")
+	}
+
+	cmd.AddCommand(place, list, scheduled, ticket, refund, containers, returnContainers, void, discount, discountItem, reopen, waiveService, reprint, rebuild, archive)
+	return cmd
+}
+
+// overrideHint tells staff how to give a manager's override an action needs
+func overrideHint(err error) error {
+	var overrideErr *OverrideError
+	if errors.As(err, &overrideErr) {
+		return fmt.Errorf("%w: give the manager's --manager and --pin", err)
+	}
+	return err
+}
+
+func newCustomerCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "customer", Short: "Look up customers and send them offers"}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "Show every customer with what they have ordered",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			GetCustomers(context.TODO())
+			return nil
+		},
+	}, &cobra.Command{
+		Use:   "send-offers",
+		Short: "Send coupons for upcoming birthdays and anniversaries now, instead of waiting for the hourly run",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			coupons, err := SendOccasionOffers(context.TODO(), clock.Now())
+			if err != nil {
+				return fmt.Errorf("sending offers: %w", err)
+			}
+			printResult(fmt.Sprintf("Sent %d birthday and anniversary coupons", len(coupons)), coupons)
+			return nil
+		},
+	})
+	var channel string
+	unsubscribe := &cobra.Command{
+		Use:   "unsubscribe <name>",
+		Short: "Stop sending a customer offers and surveys, on one channel or all of them",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			customer, err := Unsubscribe(context.TODO(), args[0], channel)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("%s now takes marketing on: %s", customer.Name, strings.Join(customer.Communication.Marketing, ", ")), customer)
+			return nil
+		},
+	}
+	unsubscribe.Flags().StringVar(&channel, "channel", "", "sms, email or whatsapp; every channel when not given")
+	importLegacy := &cobra.Command{
+		Use:   "import <file.csv>",
+		Short: "Import customers exported from a legacy POS, with their visit counts and spend",
+		Long: "Reads a CSV with a header row naming the name, phone, visit count and spend columns. Customers are\n" +
+			"matched by phone number; each keeps their visits and spend from the old POS, which count towards their\n" +
+			"lifetime spend. Importing the same file again replaces what it imported before.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := confirmDestructive(*cfg, "Importing customers"); err != nil {
+				return err
+			}
+			file, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			result, err := ImportLegacyCustomers(context.TODO(), file)
+			if err != nil {
+				return fmt.Errorf("importing %s: %w", args[0], err)
+			}
+			message := fmt.Sprintf("Read %d customers (%d merged by phone): %d added, %d updated, %d skipped",
+				result.Rows, result.Merged, result.Added, result.Updated, len(result.Skipped))
+			for _, skipped := range result.Skipped {
+				message += "\n  " + skipped
+			}
+			printResult(message, result)
+			return nil
+		},
+	}
+	cmd.AddCommand(unsubscribe, importLegacy)
+	return cmd
+}
+
+func newReportCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "report", Short: "Print reports"}
+	var date string
+	daily := &cobra.Command{
+		Use:   "daily",
+		Short: "Show a day's orders and revenue by hour",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			day, err := parseDay("date", date)
+			if err != nil {
+				return err
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowDailySales(context.TODO(), day)
+		},
+	}
+	daily.Flags().StringVar(&date, "date", "", "day (YYYY-MM-DD), by default today")
+
+	var days int
+	prepTimes := &cobra.Command{
+		Use:   "prep-times",
+		Short: "Show how long the kitchen takes to prepare each item, flagging items slower than their target",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if days < 1 {
+				return fmt.Errorf("--days must be at least 1")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			from, to := lastDays(days)
+			return ShowPrepTimes(context.TODO(), from, to)
+		},
+	}
+	prepTimes.Flags().IntVar(&days, "days", 30, "how many days back to look, today included")
+
+	tables := &cobra.Command{
+		Use:   "tables",
+		Short: "Show table turnover, occupancy by hour and revenue per available seat hour",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if days < 1 {
+				return fmt.Errorf("--days must be at least 1")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			from, to := lastDays(days)
+			return ShowTableTurnover(context.TODO(), from, to)
+		},
+	}
+	tables.Flags().IntVar(&days, "days", 30, "how many days back to look, today included")
+
+	var weeks int
+	demand := &cobra.Command{
+		Use:   "demand",
+		Short: "Show the busiest hours of the week and the staff each hour needs",
+		Long:  "Show the average covers by day of the week and hour, and the staff each hour needs from the covers per staff member in RMS_STAFF_RATIOS.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if weeks < 1 {
+				return fmt.Errorf("--weeks must be at least 1")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			// Whole weeks up to the end of yesterday, so every day of the week is counted as often
+			_, to := lastDays(1)
+			to = to.AddDate(0, 0, -1)
+			return ShowDemand(context.TODO(), to.AddDate(0, 0, -7*weeks), to)
+		},
+	}
+	demand.Flags().IntVar(&weeks, "weeks", 8, "how many weeks of history to average")
+
+	var buffer float64
+	forecast := &cobra.Command{
+		Use:   "forecast",
+		Short: "Forecast next week's sales of each item and the ingredients to buy for them",
+		Long: "Forecast each item's sales for the week starting tomorrow as the average sold on the same day of the week " +
+			"over the last weeks, and turn them into a purchase list through the items' recipes.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowSalesForecast(context.TODO(), clock.Now().AddDate(0, 0, 1), weeks, buffer)
+		},
+	}
+	forecast.Flags().IntVar(&weeks, "weeks", 4, "how many weeks of history to average")
+	forecast.Flags().Float64Var(&buffer, "buffer", 10, "percentage to add to the ingredients for waste and surprises")
+	var month string
+	royalties := &cobra.Command{
+		Use:   "royalties",
+		Short: "Show the royalty each franchised branch owes for a month, from RMS_ROYALTY_RATES",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowRoyalties(context.TODO(), month)
+		},
+	}
+	royalties.Flags().StringVar(&month, "month", time.Now().AddDate(0, -1, 0).Format("2006-01"), "month (YYYY-MM), by default last month")
+	complaints := &cobra.Command{
+		Use:   "complaints",
+		Short: "Show a month's complaints by cause and item, with what putting them right cost",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowComplaintReport(context.TODO(), month)
+		},
+	}
+	complaints.Flags().StringVar(&month, "month", time.Now().AddDate(0, -1, 0).Format("2006-01"), "month (YYYY-MM), by default last month")
+	var fromMonth, toMonth string
+	nps := &cobra.Command{
+		Use:   "nps",
+		Short: "Show the net promoter score from customers' surveys by month and branch",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowNPSTrend(context.TODO(), fromMonth, toMonth)
+		},
+	}
+	nps.Flags().StringVar(&fromMonth, "from", time.Now().AddDate(0, -5, 0).Format("2006-01"), "first month (YYYY-MM), by default five months ago")
+	nps.Flags().StringVar(&toMonth, "to", time.Now().Format("2006-01"), "last month (YYYY-MM), by default this month")
+	openItems := &cobra.Command{
+		Use:   "open-items",
+		Short: "Show the open items cashiers described and priced themselves",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if days < 1 {
+				return fmt.Errorf("--days must be at least 1")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			from, to := lastDays(days)
+			return ShowOpenItemSales(context.TODO(), from, to)
+		},
+	}
+	openItems.Flags().IntVar(&days, "days", 30, "how many days back to look, today included")
+	discounts := &cobra.Command{
+		Use:   "discounts",
+		Short: "Show the comps and discounts given, with their totals by reason and approving manager",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if days < 1 {
+				return fmt.Errorf("--days must be at least 1")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			from, to := lastDays(days)
+			return ShowDiscountReport(context.TODO(), from, to)
+		},
+	}
+	discounts.Flags().IntVar(&days, "days", 30, "how many days back to look, today included")
+	serviceCharge := &cobra.Command{
+		Use:   "service-charge",
+		Short: "Show the service charge collected and waived, and each employee's share by hours worked",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if days < 1 {
+				return fmt.Errorf("--days must be at least 1")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			from, to := lastDays(days)
+			return ShowServiceChargeReport(context.TODO(), from, to)
+		},
+	}
+	serviceCharge.Flags().IntVar(&days, "days", 30, "how many days back to look, today included")
+	staffMeals := &cobra.Command{
+		Use:   "staff-meals",
+		Short: "Show a month's staff meals by employee: their cost, what the allowance covered and what was billed",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowStaffMealReport(context.TODO(), month)
+		},
+	}
+	staffMeals.Flags().StringVar(&month, "month", time.Now().AddDate(0, -1, 0).Format("2006-01"), "month (YYYY-MM), by default last month")
+	voids := &cobra.Command{
+		Use:   "voids",
+		Short: "Show the items voided after they were sent to the kitchen, and apart from them those removed before",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if days < 1 {
+				return fmt.Errorf("--days must be at least 1")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			from, to := lastDays(days)
+			return ShowVoidReport(context.TODO(), from, to)
+		},
+	}
+	voids.Flags().IntVar(&days, "days", 30, "how many days back to look, today included")
+	var audit OrderAuditFilter
+	orderAudit := &cobra.Command{
+		Use:   "order-audit",
+		Short: "Show the orders placed through the API with the device, terminal, IP address and user agent they came from",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if days < 1 {
+				return fmt.Errorf("--days must be at least 1")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			from, to := lastDays(days)
+			return ShowOrderAudit(context.TODO(), from, to, audit)
+		},
+	}
+	orderAudit.Flags().IntVar(&days, "days", 30, "how many days back to look, today included")
+	orderAudit.Flags().StringVar(&audit.Device, "device", "", "only orders from this device, by name or id")
+	orderAudit.Flags().StringVar(&audit.Terminal, "terminal", "", "only orders from this terminal")
+	orderAudit.Flags().StringVar(&audit.IP, "ip", "", "only orders from this IP address")
+	orderAudit.Flags().StringVar(&audit.UserAgent, "user-agent", "", "only orders whose user agent contains this")
+	fx := &cobra.Command{
+		Use:   "fx",
+		Short: "Show the foreign currency taken and exchanged, with the gain or loss against the till's rates",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if days < 1 {
+				return fmt.Errorf("--days must be at least 1")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			from, to := lastDays(days)
+			return ShowFXReport(context.TODO(), from, to)
+		},
+	}
+	fx.Flags().IntVar(&days, "days", 30, "how many days back to look, today included")
+	var budgetMonth string
+	budget := &cobra.Command{
+		Use:   "budget",
+		Short: "Show a month's revenue and food cost against its targets, with the variance and the pace",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowBudgetReport(context.TODO(), budgetMonth)
+		},
+	}
+	budget.Flags().StringVar(&budgetMonth, "month", time.Now().Format("2006-01"), "month (YYYY-MM), by default this month")
+	cmd.AddCommand(daily, prepTimes, tables, demand, forecast, royalties, complaints, nps, openItems, discounts, serviceCharge, staffMeals, voids, orderAudit, fx, budget)
+	return cmd
+}
+
+func newBranchMenuCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "branch", Short: "Set branches' prices and items, and sync head office's menu to them"}
+
+	show := &cobra.Command{
+		Use:   "show <branch>",
+		Short: "Show how a branch's menu differs from head office's and when it was last synced",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowBranchMenu(context.TODO(), args[0])
+		},
+	}
+
+	var price float64
+	var unavailable bool
+	override := &cobra.Command{
+		Use:   "override <branch> <item>",
+		Short: "Set a branch's price for an item or take it off the branch's menu; it reaches the branch at the next sync",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			change := MenuOverride{Item: args[1], Unavailable: unavailable}
+			if cmd.Flags().Changed("price") {
+				change.Price = &price
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			if _, err := SetMenuOverride(context.TODO(), args[0], change); err != nil {
+				return err
+			}
+			return ShowBranchMenu(context.TODO(), args[0])
+		},
+	}
+	override.Flags().Float64Var(&price, "price", 0, "the branch's price; leave out for head office's")
+	override.Flags().BoolVar(&unavailable, "unavailable", false, "not served at the branch")
+
+	var dryRun bool
+	sync := &cobra.Command{
+		Use:   "sync <branch>",
+		Short: "Copy head office's menu to a branch with its overrides, or with --dry-run only show what would change",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			if dryRun {
+				changes, err := PlanMenuSync(context.TODO(), args[0])
+				if err != nil {
+					return err
+				}
+				return ShowMenuChanges(args[0], changes)
+			}
+			_, changes, err := SyncBranchMenu(context.TODO(), args[0])
+			if err != nil {
+				return err
+			}
+			return ShowMenuChanges(args[0], changes)
+		},
+	}
+	sync.Flags().BoolVar(&dryRun, "dry-run", false, "only show what the sync would change")
+
+	cmd.AddCommand(show, override, sync)
+	return cmd
+}
+
+func newStockCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "stock", Short: "Count stock and see what is running low"}
+
+	var change StockChange
+	var counted, reorderAt, unitCost float64
+	set := &cobra.Command{
+		Use:   "set <name>",
+		Short: "Record a stock count or the reorder level of an ingredient or a menu item without a recipe",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cmd.Flags().Changed("count") {
+				change.Counted = &counted
+			}
+			if cmd.Flags().Changed("reorder-at") {
+				change.ReorderAt = &reorderAt
+			}
+			if cmd.Flags().Changed("cost") {
+				change.UnitCost = &unitCost
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			level, err := SetStock(context.TODO(), args[0], change)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("%s: %g %s counted, reorder at %g", level.Name, level.Counted, level.Unit, level.ReorderAt), level)
+			return nil
+		},
+	}
+	set.Flags().StringVar(&change.Unit, "unit", "", "unit counted in, the same as the recipes use, or piece for a menu item")
+	set.Flags().Float64Var(&counted, "count", 0, "how much is in stock now")
+	set.Flags().StringVar(&change.ExpiresOn, "expires", "", "use-by day (YYYY-MM-DD) of what was counted, if it perishes")
+	set.Flags().Float64Var(&unitCost, "cost", 0, "what one unit cost, by default the last cost paid")
+	set.Flags().Float64Var(&reorderAt, "reorder-at", 0, "alert when stock falls to this level; 0 turns alerts off")
+
+	var expiresOn string
+	receive := &cobra.Command{
+		Use:   "receive <name> <quantity>",
+		Short: "Add a delivery to an ingredient or menu item already stocked",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			quantity, err := strconv.ParseFloat(args[1], 64)
+			if err != nil {
+				return fmt.Errorf("quantity must be a number")
+			}
+			var cost *float64
+			if cmd.Flags().Changed("cost") {
+				cost = &unitCost
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			level, err := ReceiveStock(context.TODO(), args[0], quantity, cost, expiresOn)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Received %g %s of %s", quantity, level.Unit, level.Name), level)
+			return nil
+		},
+	}
+	receive.Flags().StringVar(&expiresOn, "expires", "", "use-by day (YYYY-MM-DD), if it perishes")
+	receive.Flags().Float64Var(&unitCost, "cost", 0, "what one unit cost, by default the last cost paid")
+
+	var days int
+	expiring := &cobra.Command{
+		Use:   "expiring",
+		Short: "Show the stock about to expire, and expired stock not yet written off",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			if !cmd.Flags().Changed("days") {
+				days = expiryWarningDays
+			}
+			return ShowExpiringStock(context.TODO(), days)
+		},
+	}
+	expiring.Flags().IntVar(&days, "days", 2, "how many days ahead to look, by default RMS_EXPIRY_WARNING_DAYS")
+
+	var months int
+	wastage := &cobra.Command{
+		Use:   "wastage",
+		Short: "Show the value of the stock written off as expired or for voided lines, by month",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if months < 1 {
+				return fmt.Errorf("--months must be at least 1")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			now := clock.Now()
+			from := time.Date(now.Year(), now.Month()+1-time.Month(months), 1, 0, 0, 0, 0, time.Local)
+			return ShowWastage(context.TODO(), from, from.AddDate(0, months, 0))
+		},
+	}
+	wastage.Flags().IntVar(&months, "months", 6, "how many months back to look, this one included")
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "Show what is in stock and when it runs out at the rate it sold over the last week",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowStock(context.TODO(), false)
+		},
+	}
+	alerts := &cobra.Command{
+		Use:   "alerts",
+		Short: "Show the stock at or below its reorder level, whatever runs out first at the top",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowStock(context.TODO(), true)
+		},
+	}
+	cmd.AddCommand(set, receive, list, alerts, expiring, wastage, newTransferCommand(cfg))
+	return cmd
+}
+
+// parseQuantities reads stock quantities written as "name=quantity", e.g. "mozzarella=2.5"
+func parseQuantities(entries []string) (map[string]float64, error) {
+	quantities := map[string]float64{}
+	for _, entry := range entries {
+		name, amount, ok := strings.Cut(entry, "=")
+		quantity, err := strconv.ParseFloat(strings.TrimSpace(amount), 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid quantity %q (want name=quantity)", entry)
+		}
+		quantities[strings.ToLower(strings.TrimSpace(name))] = quantity
+	}
+	return quantities, nil
+}
+
+func newTransferCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "transfer", Short: "Send stock to another branch and confirm it was sent and received"}
+	var by string
+
+	create := &cobra.Command{
+		Use:   "create <branch> <name=quantity>...",
+		Short: "Ask for stock to be sent from this branch to another; main is the main branch",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			quantities, err := parseQuantities(args[1:])
+			if err != nil {
+				return err
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			transfer, err := RequestTransfer(context.TODO(), args[0], quantities, by)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Transfer %s to %s requested", transfer.ID.Hex(), branchName(transfer.To)), transfer)
+			return nil
+		},
+	}
+
+	dispatch := &cobra.Command{
+		Use:   "dispatch <id>",
+		Short: "Confirm a transfer left this branch, taking it out of stock",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid transfer id")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			transfer, err := DispatchTransfer(context.TODO(), id, by)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Transfer %s dispatched to %s", transfer.ID.Hex(), branchName(transfer.To)), transfer)
+			return nil
+		},
+	}
+
+	var note string
+	receive := &cobra.Command{
+		Use:   "receive <id> [name=quantity]...",
+		Short: "Confirm a transfer arrived at this branch, adding it to stock; give what arrived of items that fell short",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid transfer id")
+			}
+			received, err := parseQuantities(args[1:])
+			if err != nil {
+				return err
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			transfer, err := ReceiveTransfer(context.TODO(), id, by, received, note)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Transfer %s from %s received", transfer.ID.Hex(), branchName(transfer.From)), transfer)
+			return nil
+		},
+	}
+	receive.Flags().StringVar(&note, "note", "", "why less arrived than was sent")
+
+	cancel := &cobra.Command{
+		Use:   "cancel <id>",
+		Short: "Call off a transfer that has not been dispatched",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid transfer id")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			transfer, err := CancelTransfer(context.TODO(), id, by)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Transfer %s cancelled", transfer.ID.Hex()), transfer)
+			return nil
+		},
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "Show the transfers to and from this branch",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowTransfers(context.TODO())
+		},
+	}
+	for _, step := range []*cobra.Command{create, dispatch, receive, cancel} {
+		step.Flags().StringVar(&by, "by", "", "who is taking this step, for the audit trail")
+	}
+	cmd.AddCommand(create, dispatch, receive, cancel, list)
+	return cmd
+}
+
+func newAPIKeyCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "apikey", Short: "Manage API keys"}
+	var scopes []string
+	var rateLimit int
+	var role, staff string
+	create := &cobra.Command{
+		Use:   "create <name>",
+		Short: "Issue an API key and print it",
+		Long:  "Issue an API key and print it. The key is shown only once. In SaaS mode keys are issued at signup and through the API.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			key, record, err := IssueAPIKey(context.TODO(), APIKey{Name: args[0], Scopes: scopes, RateLimit: rateLimit, Role: role, Staff: staff})
+			if err != nil {
+				return fmt.Errorf("creating API key: %w", err)
+			}
+			printResult(fmt.Sprintf("API key %q with scopes %s (shown only once):\n%s", record.Name, strings.Join(record.Scopes, ","), key),
+				struct {
+					APIKey
+					Key string `json:"key"`
+				}{record, key})
+			return nil
+		},
+	}
+	create.Flags().StringSliceVar(&scopes, "scopes", []string{ScopeAll}, "comma separated scopes")
+	create.Flags().IntVar(&rateLimit, "rate-limit", 0, "requests per minute (0 for the default)")
+	create.Flags().StringVar(&role, "role", "", "staff role whose orders alone the key sees: waiter, chef or cashier; or manager")
+	create.Flags().StringVar(&staff, "staff", "", "the waiter or manager's name, or the chef's station from RMS_STATIONS")
+
+	reset2FA := &cobra.Command{
+		Use:   "reset-2fa <id>",
+		Short: "Turn two-factor authentication off for a key whose admin lost their phone and backup codes",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid key id %q", args[0])
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			if _, err := store.APIKeys().Find(context.TODO(), id); err != nil {
+				return err
+			}
+			if err := ResetTwoFactor(context.TODO(), id); err != nil {
+				return err
+			}
+			printResult("Two-factor authentication is off for key "+id.Hex()+"; the admin can enroll again", map[string]string{"id": id.Hex()})
+			return nil
+		},
+	}
+	cmd.AddCommand(create, reset2FA)
+	return cmd
+}
+
+func newSessionCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "session", Short: "List and sign out API keys' sessions at terminals"}
+	var key string
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the sessions signed in, newest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var keyID primitive.ObjectID
+			if key != "" {
+				id, err := primitive.ObjectIDFromHex(key)
+				if err != nil {
+					return fmt.Errorf("invalid key id %q", key)
+				}
+				keyID = id
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowSessions(context.TODO(), keyID)
+		},
+	}
+	list.Flags().StringVar(&key, "key", "", "only the sessions of this key id")
+
+	revoke := &cobra.Command{
+		Use:   "revoke <id>",
+		Short: "Sign a session out, e.g. a terminal left signed in",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid session id %q", args[0])
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			session, err := RevokeSession(context.TODO(), "", id, primitive.NilObjectID)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Session %s at %s is signed out", session.ID.Hex(), session.Terminal), session)
+			return nil
+		},
+	}
+	cmd.AddCommand(list, revoke)
+	return cmd
+}
+
+func newDeviceCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "device", Short: "Register and disable the POS terminals and kiosks that call the API"}
+	var kind, location string
+	register := &cobra.Command{
+		Use:   "register <name>",
+		Short: "Register a device and print its credential",
+		Long:  "Register a device and print its credential, which it sends in the X-Device header. The credential is shown only once.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			credential, device, err := RegisterDevice(context.TODO(), Device{Name: args[0], Kind: kind, Location: location})
+			if err != nil {
+				return fmt.Errorf("registering device: %w", err)
+			}
+			printResult(fmt.Sprintf("Device %q (%s) registered; its credential (shown only once):\n%s", device.Name, device.Kind, credential),
+				struct {
+					Device
+					Credential string `json:"credential"`
+				}{device, credential})
+			return nil
+		},
+	}
+	register.Flags().StringVar(&kind, "kind", DevicePOS, "pos or kiosk")
+	register.Flags().StringVar(&location, "location", "", `where the device is, e.g. "front counter"`)
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the registered devices",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowDevices(context.TODO())
+		},
+	}
+
+	var reason string
+	disable := &cobra.Command{
+		Use:   "disable <id>",
+		Short: "Stop a device working at once, e.g. when it is stolen",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid device id %q", args[0])
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			device, err := DisableDevice(context.TODO(), "", id, reason)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Device %q is disabled", device.Name), device)
+			return nil
+		},
+	}
+	disable.Flags().StringVar(&reason, "reason", "", "why, e.g. stolen")
+
+	enable := &cobra.Command{
+		Use:   "enable <id>",
+		Short: "Let a disabled device work again",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid device id %q", args[0])
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			device, err := EnableDevice(context.TODO(), "", id)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Device %q is enabled again", device.Name), device)
+			return nil
+		},
+	}
+	cmd.AddCommand(register, list, disable, enable)
+	return cmd
+}
+
+func newCartCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "cart", Short: "See and discard carts parked to be recalled later"}
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the carts waiting to be recalled",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowParkedCarts(context.TODO())
+		},
+	}
+
+	discard := &cobra.Command{
+		Use:   "discard <id>",
+		Short: "Throw away a parked cart the customer walked away from",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid cart id %q", args[0])
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			cart, err := TakeParkedCart(context.TODO(), id)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Parked cart for %s discarded", cart.CustomerName), cart)
+			return nil
+		},
+	}
+	cmd.AddCommand(list, discard)
+	return cmd
+}
+
+func newDeliveryZoneCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "zone", Short: "Manage the areas delivery orders are taken to and their fees"}
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the delivery zones",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowDeliveryZones(context.TODO())
+		},
+	}
+
+	var zone DeliveryZone
+	var center string
+	set := &cobra.Command{
+		Use:   "set <name>",
+		Short: "Add a delivery zone of pin codes or a radius, or replace it",
+		Long:  "Add a delivery zone of pin codes (--pins) or a radius around a point (--center and --radius), or replace it.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			zone.Name = args[0]
+			if center != "" {
+				lat, lng, _ := strings.Cut(center, ",")
+				latitude, latErr := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+				longitude, lngErr := strconv.ParseFloat(strings.TrimSpace(lng), 64)
+				if latErr != nil || lngErr != nil {
+					return fmt.Errorf("center must be lat,lng, e.g. 12.9716,77.5946")
+				}
+				zone.Center = &Location{Lat: latitude, Lng: longitude}
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			saved, err := SaveDeliveryZone(context.TODO(), zone)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Delivery zone %s saved", saved.Name), saved)
+			return nil
+		},
+	}
+	set.Flags().StringSliceVar(&zone.PinCodes, "pins", nil, "pin codes in the zone, comma separated")
+	set.Flags().StringVar(&center, "center", "", "center of a zone drawn as a radius, as lat,lng")
+	set.Flags().Float64Var(&zone.RadiusKm, "radius", 0, "how far from the center the zone reaches, in km")
+	set.Flags().Float64Var(&zone.Fee, "fee", 0, "delivery fee added to the bill, in rupees")
+	set.Flags().Float64Var(&zone.MinimumOrder, "min", 0, "smallest order delivered to the zone, in rupees of food")
+
+	remove := &cobra.Command{
+		Use:   "remove <name>",
+		Short: "Stop delivering to a zone",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			if err := DeleteDeliveryZone(context.TODO(), args[0]); err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Delivery zone %s removed", args[0]), map[string]string{"zone": args[0]})
+			return nil
+		},
+	}
+	cmd.AddCommand(list, set, remove)
+	return cmd
+}
+
+func newBudgetCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "budget", Short: "Set monthly revenue and food cost targets, reported with report budget"}
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List every month's targets, latest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowBudgets(context.TODO())
+		},
+	}
+
+	var budget Budget
+	set := &cobra.Command{
+		Use:   "set <YYYY-MM>",
+		Short: "Set a month's revenue and food cost targets, replacing any set before",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			budget.Month = args[0]
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			saved, err := SaveBudget(context.TODO(), budget)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Targets for %s saved", saved.Month), saved)
+			return nil
+		},
+	}
+	set.Flags().Float64Var(&budget.Revenue, "revenue", 0, "revenue target for the month, in rupees")
+	set.Flags().Float64Var(&budget.FoodCost, "food-cost", 0, "food cost target for the month, in rupees")
+	cmd.AddCommand(list, set)
+	return cmd
+}
+
+func newTemplateCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "template", Short: "Reword customer messages and receipts without a redeploy"}
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the message and receipt templates and their wording in use",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowTemplates(context.TODO())
+		},
+	}
+
+	var subject, body, note string
+	set := &cobra.Command{
+		Use:   "set <name>",
+		Short: "Reword a template as a new version",
+		Long:  "Reword a template as a new version. Placeholders such as {{customerName}} are filled in when it is used; 'template list --format json' shows each template's.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			saved, err := SaveTemplate(context.TODO(), args[0], 0, subject, body, note)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Template %s saved as version %d", saved.Name, saved.Version), saved)
+			return nil
+		},
+	}
+	set.Flags().StringVar(&subject, "subject", "", "subject of the message; receipt templates have none")
+	set.Flags().StringVar(&body, "body", "", "wording of the message or receipt text")
+	set.Flags().StringVar(&note, "note", "", "why the wording changed")
+
+	rollback := &cobra.Command{
+		Use:   "rollback <name> <version>",
+		Short: "Use an earlier version's wording again",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			version, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid version %q", args[1])
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			saved, err := RollbackTemplate(context.TODO(), args[0], version)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Template %s rolled back to version %d as version %d", saved.Name, version, saved.Version), saved)
+			return nil
+		},
+	}
+	cmd.AddCommand(list, set, rollback)
+	return cmd
+}
+
+func newPrintCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "print", Short: "Follow and retry the tickets sent to the kitchen printers"}
+	var status, station string
+	jobs := &cobra.Command{
+		Use:   "jobs",
+		Short: "List the print jobs of the last 24 hours",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if status != "" && status != PrintQueued && status != PrintPrinted && status != PrintFailed {
+				return fmt.Errorf("status must be queued, printed or failed")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowPrintJobs(context.TODO(), status, station)
+		},
+	}
+	jobs.Flags().StringVar(&status, "status", "", "only jobs that are queued, printed or failed")
+	jobs.Flags().StringVar(&station, "station", "", "only jobs for the station")
+	jobs.RegisterFlagCompletionFunc("status", cobra.FixedCompletions([]string{PrintQueued, PrintPrinted, PrintFailed}, cobra.ShellCompDirectiveNoFileComp))
+
+	retry := &cobra.Command{
+		Use:   "retry <id>",
+		Short: "Send a failed print job to its station's main printer again",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid print job id %q", args[0])
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			job, err := RetryPrintJob(context.TODO(), id)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Print job %s queued again for %s", job.ID.Hex(), job.Station), job)
+			return nil
+		},
+	}
+
+	tickets := &cobra.Command{
+		Use:   "tickets <order-id>",
+		Short: "Queue an order's kitchen tickets for the station printers",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid order id %q", args[0])
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			order, err := PrintOrderTickets(context.TODO(), id)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Tickets for %s queued", orderPlace(order)), order)
+			return nil
+		},
+	}
+	cmd.AddCommand(jobs, retry, tickets)
+	return cmd
+}
+
+func newDriverCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "driver", Short: "Track and settle the cash on delivery drivers collect"}
+	cod := &cobra.Command{
+		Use:   "cod",
+		Short: "Show the cash on delivery each driver has not handed in, by day",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowOutstandingCOD(context.TODO())
+		},
+	}
+
+	settle := &cobra.Command{
+		Use:   "settle <driver> <amount>",
+		Short: "Take in the cash a driver hands over for their cash on delivery",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			amount, err := strconv.ParseFloat(args[1], 64)
+			if err != nil {
+				return fmt.Errorf("amount must be a number")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			settlement, err := SettleDriver(context.TODO(), args[0], amount)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("%s settled Rs %.2f of Rs %.2f for %d orders (variance Rs %.2f)", settlement.Driver,
+				settlement.HandedIn, settlement.Expected, len(settlement.Collections), settlement.Variance), settlement)
+			return nil
+		},
+	}
+	cmd.AddCommand(cod, settle)
+	return cmd
+}
+
+func newLogBookCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "logbook", Short: "Keep the managers' log book of shift notes, incidents and follow-ups"}
+	var kind, date string
+	add := &cobra.Command{
+		Use:   "add <text>",
+		Short: "Write an entry in the log book for today's business date, or --date",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			entry, err := AddLogEntry(context.TODO(), LogEntry{Kind: kind, Date: date, Text: args[0]})
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Logged %s %s for %s", entry.Kind, entry.ID.Hex(), entry.Date), entry)
+			return nil
+		},
+	}
+	add.Flags().StringVar(&kind, "kind", LogNote, "note, incident or follow-up")
+	add.Flags().StringVar(&date, "date", "", "business date (YYYY-MM-DD), by default today")
+	add.RegisterFlagCompletionFunc("kind", cobra.FixedCompletions(logKinds, cobra.ShellCompDirectiveNoFileComp))
+
+	var days int
+	show := &cobra.Command{
+		Use:   "show",
+		Short: "Show the log book in the order it was written, with how many follow-ups are open",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if days < 1 {
+				return fmt.Errorf("--days must be at least 1")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			from, to := lastDays(days)
+			return ShowLogBook(context.TODO(), from, to)
+		},
+	}
+	show.Flags().IntVar(&days, "days", 7, "how many days back to look, today included")
+
+	done := &cobra.Command{
+		Use:   "done <entry-id>",
+		Short: "Mark a follow-up done",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid entry id %q", args[0])
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			entry, err := CompleteFollowUp(context.TODO(), id, 0)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Follow-up %s done", entry.ID.Hex()), entry)
+			return nil
+		},
+	}
+
+	summary := &cobra.Command{
+		Use:   "summary",
+		Short: "Email the end-of-day summary of a day's sales, staff and log book to the managers and owners now",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			day, err := parseDay("date", date)
+			if err != nil {
+				return err
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			sent, err := SendDaySummary(context.TODO(), day)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Summary for %s sent to %s", sent.Date, strings.Join(daySummaryRecipients(), ", ")), sent)
+			return nil
+		},
+	}
+	summary.Flags().StringVar(&date, "date", "", "business date (YYYY-MM-DD), by default today")
+
+	cmd.AddCommand(add, show, done, summary)
+	return cmd
+}
+
+func newCurrencyCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "currency", Short: "Quote bills in and exchange the foreign currencies taken at the till"}
+	quote := &cobra.Command{
+		Use:   "quote <order-id> [currency]",
+		Short: "Quote what is due on an order in a foreign currency, or in every one taken",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid order id %q", args[0])
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			quotes, err := QuoteInCurrency(context.TODO(), id, strings.Join(args[1:], ""))
+			if err != nil {
+				return err
+			}
+			quoteListing := listing{title: "Amount due:", header: []string{"Currency", "Rate", "Due", "Amount"}, records: quotes}
+			for _, quote := range quotes {
+				amount := fmt.Sprintf("%s %.2f", quote.Currency, quote.Amount)
+				quoteListing.rows = append(quoteListing.rows, []string{
+					quote.Currency, fmt.Sprintf("Rs %.2f", quote.Rate), fmt.Sprintf("Rs %s", formatAmount(quote.Due)), amount,
+				})
+				quoteListing.compact = append(quoteListing.compact, amount)
+			}
+			return printListing(os.Stdout, quoteListing)
+		},
+	}
+
+	exchange := &cobra.Command{
+		Use:   "exchange <currency> <amount> <rupees>",
+		Short: "Record foreign cash from the till changed into rupees",
+		Args:  cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			amount, err := strconv.ParseFloat(args[1], 64)
+			if err != nil {
+				return fmt.Errorf("amount must be a number")
+			}
+			rupees, err := strconv.ParseFloat(args[2], 64)
+			if err != nil {
+				return fmt.Errorf("rupees must be a number")
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			recorded, err := RecordFXExchange(context.TODO(), FXExchange{Currency: args[0], ForeignAmount: amount, Rupees: rupees})
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("Exchanged %s %.2f for Rs %.2f", recorded.Currency, recorded.ForeignAmount, recorded.Rupees), recorded)
+			return nil
+		},
+	}
+	cmd.AddCommand(quote, exchange)
+	return cmd
+}
+
+func newStandingOrderCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "standing", Short: "See, skip and pause orders placed again on a schedule"}
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the standing orders and when each runs next",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			return ShowStandingOrders(context.TODO())
+		},
+	}
+
+	skip := &cobra.Command{
+		Use:   "skip <id> <YYYY-MM-DD>",
+		Short: "Leave out one coming run, e.g. on a holiday",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid standing order id %q", args[0])
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			standing, err := SkipStandingOrder(context.TODO(), id, args[1])
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("The standing order for %s will not be placed on %s", standing.CustomerName, args[1]), standing)
+			return nil
+		},
+	}
+
+	pause := &cobra.Command{
+		Use:   "pause <id>",
+		Short: "Stop placing a standing order until it is resumed",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid standing order id %q", args[0])
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			standing, err := PauseStandingOrder(context.TODO(), id)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("The standing order for %s is paused", standing.CustomerName), standing)
+			return nil
+		},
+	}
+
+	resume := &cobra.Command{
+		Use:   "resume <id>",
+		Short: "Place a paused standing order again from its next run",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := primitive.ObjectIDFromHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid standing order id %q", args[0])
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			standing, err := ResumeStandingOrder(context.TODO(), id)
+			if err != nil {
+				return err
+			}
+			printResult(fmt.Sprintf("The standing order for %s runs next %s", standing.CustomerName,
+				standing.NextRunAt.Format("Monday 2 January 15:04")), standing)
+			return nil
+		},
+	}
+	cmd.AddCommand(list, skip, pause, resume)
+	return cmd
+}
+
+func newAccountsCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{Use: "accounts", Short: "Export the books"}
+	var month string
+	export := &cobra.Command{
+		Use:       "export <tally|quickbooks>",
+		Short:     "Write a month's accounting journal to a file",
+		Long:      "Write a month's accounting journal for Tally (XML) or QuickBooks (CSV) to journal-YYYY-MM.xml or .csv.",
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{ExportTally, ExportQuickBooks},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			now := clock.Now()
+			from := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.Local)
+			if month != "" {
+				var err error
+				if from, err = time.ParseInLocation("2006-01", month, time.Local); err != nil {
+					return fmt.Errorf("--month must be formatted as YYYY-MM")
+				}
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			path, err := exportAccounts(context.TODO(), args[0], from)
+			if err != nil {
+				return fmt.Errorf("exporting accounts: %w", err)
+			}
+			printResult("Accounting journal written to "+path, map[string]string{"path": path, "format": args[0]})
+			return nil
+		},
+	}
+	export.Flags().StringVar(&month, "month", "", "month (YYYY-MM), by default last month")
+	cmd.AddCommand(export)
+	return cmd
+}
+
+// exportAccounts writes the month's journal from from to a file named after the month, returning its path
+func exportAccounts(ctx context.Context, format string, from time.Time) (string, error) {
+	extension := "xml"
+	if format == ExportQuickBooks {
+		extension = "csv"
+	}
+	path := fmt.Sprintf("journal-%s.%s", from.Format("2006-01"), extension)
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	err = WriteAccountingExport(ctx, file, format, from, from.AddDate(0, 1, 0))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// reportError prints the error a command failed with: as a JSON line with --json, plainly otherwise
+func reportError(err error) {
+	if jsonOutput {
+		log.Println(err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, "Error:", err)
+}
+
+func newDemoCommand(cfg *Config) *cobra.Command {
+	var opts DemoOptions
+	cmd := &cobra.Command{
+		Use:   "demo",
+		Short: "Fill the database with made-up customers and months of order history",
+		Long: "Generates customers and served, paid orders spread over past months, for trying out reports,\n" +
+			"pagination and performance. The same --seed always generates the same data.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := confirmDestructive(*cfg, "Generating demo data"); err != nil {
+				return err
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			summary, err := GenerateDemoData(context.TODO(), opts, func(orders int) {
+				notify(fmt.Sprintf("%d of %d orders generated", orders, opts.Orders))
+			})
+			if err != nil {
+				return fmt.Errorf("generating demo data: %w", err)
+			}
+			printResult(fmt.Sprintf("Generated %d customers and %d paid orders from %s to %s", summary.Customers, summary.Orders,
+				summary.From.Format(time.DateOnly), summary.To.Format(time.DateOnly)), summary)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.IntVar(&opts.Customers, "customers", 300, "how many customers to generate")
+	flags.IntVar(&opts.Orders, "orders", 3000, "how many past orders to generate")
+	flags.IntVar(&opts.Months, "months", 6, "how many months back the orders go")
+	flags.Uint64Var(&opts.Seed, "seed", 1, "seed for the random generator")
+	return cmd
+}
+
+func newBenchmarkCommand(cfg *Config) *cobra.Command {
+	var opts BenchmarkOptions
+	cmd := &cobra.Command{
+		Use:   "benchmark",
+		Short: "Place many simulated orders at once and report throughput and latency",
+		Long: "Places --orders simulated orders, --concurrency at a time, through the same steps as a real order,\n" +
+			"and reports orders per second and latency percentiles for each step. The step with the worst p99\n" +
+			"is where orders contend the most; fewer --customers makes them contend on the same customers.\n" +
+			"The orders are really recorded, so run it against a dev or staging database.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := confirmDestructive(*cfg, "Benchmarking"); err != nil {
+				return err
+			}
+			if err := startApp(*cfg, "", appOptions{}); err != nil {
+				return err
+			}
+			report, err := RunBenchmark(context.TODO(), opts)
+			if err != nil {
+				return fmt.Errorf("benchmarking: %w", err)
+			}
+			if jsonOutput {
+				printResult("", report)
+				return nil
+			}
+			return printBenchmark(os.Stdout, report)
+		},
+	}
+	flags := cmd.Flags()
+	flags.IntVar(&opts.Orders, "orders", 1000, "how many orders to place")
+	flags.IntVar(&opts.Concurrency, "concurrency", 20, "how many orders to place at the same time")
+	flags.IntVar(&opts.Customers, "customers", 50, "how many customers the orders are spread over")
+	flags.IntVar(&opts.Takeaway, "takeaway", 30, "percentage of orders that are takeaway")
+	return cmd
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Company is a business whose employees' meals are billed to it and invoiced once a month
+type Company struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name      string             `bson:"name" json:"name"`
+	GSTIN     string             `bson:"gstin,omitempty" json:"gstin,omitempty"` // The company's, for claiming input tax credit
+	Address   string             `bson:"address,omitempty" json:"address,omitempty"`
+	Employees []string           `bson:"employees" json:"employees"`                   // Who may bill meals to the company; anyone if empty
+	PONumber  string             `bson:"poNumber,omitempty" json:"poNumber,omitempty"` // Standing purchase order quoted on invoices
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// CompanyInvoice is the consolidated invoice for the meals billed to a company in a calendar month. Its
+// numbers run without gaps from 1 in each financial year, separately from the invoices for single orders.
+type CompanyInvoice struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Number        string             `bson:"number" json:"number"` // e.g. CORP/FY24-25/00012
+	FinancialYear string             `bson:"financialYear" json:"financialYear"`
+	Seq           int                `bson:"seq" json:"seq"`
+	CompanyID     primitive.ObjectID `bson:"companyId" json:"companyId"`
+	Company       string             `bson:"company" json:"company"`
+	CompanyGSTIN  string             `bson:"companyGstin,omitempty" json:"companyGstin,omitempty"`
+	GSTIN         string             `bson:"gstin,omitempty" json:"gstin,omitempty"` // The restaurant's
+	Month         string             `bson:"month" json:"month"`                     // e.g. 2024-05
+	PONumber      string             `bson:"poNumber,omitempty" json:"poNumber,omitempty"`
+	Employees     []EmployeeMeals    `bson:"employees" json:"employees"`
+	TaxableValue  float64            `bson:"taxableValue" json:"taxableValue"`
+	CGST          float64            `bson:"cgst" json:"cgst"`
+	SGST          float64            `bson:"sgst" json:"sgst"`
+	Taxes         []TaxLine          `bson:"taxes,omitempty" json:"taxes,omitempty"` // Each tax by rate under its statutory label
+	Total         float64            `bson:"total" json:"total"`
+	IssuedAt      time.Time          `bson:"issuedAt" json:"issuedAt"`
+}
+
+// EmployeeMeals is one employee's part of a company invoice
+type EmployeeMeals struct {
+	Employee string       `bson:"employee" json:"employee"`
+	Meals    []BilledMeal `bson:"meals" json:"meals"`
+	Total    float64      `bson:"total" json:"total"`
+}
+
+// BilledMeal is an order billed to the company, with the GST included in it broken out
+type BilledMeal struct {
+	OrderID      primitive.ObjectID `bson:"orderId" json:"orderId"`
+	At           time.Time          `bson:"at" json:"at"`
+	TaxableValue float64            `bson:"taxableValue" json:"taxableValue"`
+	CGST         float64            `bson:"cgst" json:"cgst"`
+	SGST         float64            `bson:"sgst" json:"sgst"`
+	Amount       float64            `bson:"amount" json:"amount"`
+}
+
+// prepareCompany tidies a company's details before it is stored
+func prepareCompany(company *Company) error {
+	company.Name = strings.TrimSpace(company.Name)
+	if company.Name == "" {
+		return fmt.Errorf("company name is required")
+	}
+	company.GSTIN = strings.ToUpper(strings.TrimSpace(company.GSTIN))
+	if company.GSTIN != "" && len(company.GSTIN) != 15 {
+		return fmt.Errorf("GSTIN must be 15 characters")
+	}
+	employees := []string{}
+	for _, employee := range company.Employees {
+		if employee = strings.TrimSpace(employee); employee != "" && !slices.Contains(employees, employee) {
+			employees = append(employees, employee)
+		}
+	}
+	company.Employees = employees
+	company.PONumber = strings.TrimSpace(company.PONumber)
+	return nil
+}
+
+// AddCompany stores a new company account
+func AddCompany(ctx context.Context, company Company) (Company, error) {
+	if err := prepareCompany(&company); err != nil {
+		return Company{}, err
+	}
+	company.ID, company.CreatedAt = primitive.NewObjectID(), clock.Now()
+	err := storeFor(ctx).Companies().Insert(ctx, company)
+	if errors.Is(err, ErrDuplicate) {
+		return Company{}, fmt.Errorf("%s already has an account: %w", company.Name, ErrDuplicate)
+	}
+	return company, err
+}
+
+// UpdateCompany changes a company's details, employees and standing purchase order
+func UpdateCompany(ctx context.Context, id primitive.ObjectID, change Company) (Company, error) {
+	company, err := FindCompany(ctx, id)
+	if err != nil {
+		return Company{}, err
+	}
+	change.ID, change.CreatedAt = company.ID, company.CreatedAt
+	if err := prepareCompany(&change); err != nil {
+		return Company{}, err
+	}
+	err = storeFor(ctx).Companies().Update(ctx, change)
+	if errors.Is(err, ErrDuplicate) {
+		return Company{}, fmt.Errorf("%s already has an account: %w", change.Name, ErrDuplicate)
+	}
+	return change, err
+}
+
+// FindCompany loads a company by ID
+func FindCompany(ctx context.Context, id primitive.ObjectID) (Company, error) {
+	return storeFor(ctx).Companies().Find(ctx, id)
+}
+
+// ListCompanies returns every company by name
+func ListCompanies(ctx context.Context) ([]Company, error) {
+	companies, err := storeFor(ctx).Companies().List(ctx)
+	if companies == nil {
+		companies = []Company{}
+	}
+	return companies, err
+}
+
+// BillToCompany charges what is left to pay on an employee's order to their company, settling the order.
+// The employee defaults to the name on the order.
+func BillToCompany(ctx context.Context, id, orderID primitive.ObjectID, employee string) (Payment, error) {
+	if IsOffline() {
+		return Payment{}, fmt.Errorf("orders can only be billed to a company while the database is reachable")
+	}
+	company, err := FindCompany(ctx, id)
+	if err != nil {
+		return Payment{}, err
+	}
+	order, err := FindOrder(ctx, orderID)
+	if err != nil {
+		return Payment{}, err
+	}
+	if employee = strings.TrimSpace(employee); employee == "" {
+		employee = order.CustomerName
+	}
+	if len(company.Employees) > 0 && !slices.Contains(company.Employees, employee) {
+		return Payment{}, fmt.Errorf("%s is not on %s's list of employees", employee, company.Name)
+	}
+	due := roundPaise(order.Total - order.AmountPaid)
+	if due <= 0 {
+		return Payment{}, fmt.Errorf("the order is already paid")
+	}
+	payment := Payment{
+		ID: primitive.NewObjectID(), OrderID: orderID, CompanyID: id, Employee: employee,
+		Method: PaymentOnAccount, Amount: due, CreatedAt: clock.Now(),
+	}
+	return payment, insertPayment(ctx, payment)
+}
+
+// monthRange parses a month like 2024-05 into the period it covers
+func monthRange(month string) (time.Time, time.Time, error) {
+	start, err := time.ParseInLocation("2006-01", month, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid month %q (want YYYY-MM)", month)
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// buildCompanyInvoice totals the meals billed to the company in [from, to) by employee, with the GST in
+// each order broken out as on its own invoice
+func buildCompanyInvoice(ctx context.Context, company Company, from, to time.Time) (CompanyInvoice, error) {
+	payments, err := storeFor(ctx).Payments().ListBetween(ctx, from, to)
+	if err != nil {
+		return CompanyInvoice{}, err
+	}
+	invoice := CompanyInvoice{
+		CompanyID: company.ID, Company: company.Name, CompanyGSTIN: company.GSTIN, GSTIN: gst.GSTIN,
+		Month: from.Format("2006-01"), PONumber: company.PONumber, Employees: []EmployeeMeals{},
+	}
+	menu := LoadMenu(ctx)
+	byEmployee := map[string]*EmployeeMeals{}
+	for _, payment := range payments {
+		if payment.CompanyID != company.ID {
+			continue
+		}
+		order, err := FindOrder(ctx, payment.OrderID)
+		if err != nil {
+			return CompanyInvoice{}, err
+		}
+		tax, err := storeFor(ctx).Invoices().FindByOrder(ctx, order.ID)
+		if errors.Is(err, ErrNotFound) {
+			tax, err = buildInvoice(order, menu), nil
+		}
+		if err != nil {
+			return CompanyInvoice{}, err
+		}
+		// Only part of the order may have been billed to the company; its tax is in the same proportion
+		share := 1.0
+		if tax.Total > 0 {
+			share = payment.Amount / tax.Total
+		}
+		meal := BilledMeal{
+			OrderID: order.ID, At: payment.CreatedAt, TaxableValue: roundPaise(tax.TaxableValue * share),
+			CGST: roundPaise(tax.CGST * share), SGST: roundPaise(tax.SGST * share), Amount: payment.Amount,
+		}
+		meals, ok := byEmployee[payment.Employee]
+		if !ok {
+			meals = &EmployeeMeals{Employee: payment.Employee}
+			byEmployee[payment.Employee] = meals
+		}
+		meals.Meals = append(meals.Meals, meal)
+		meals.Total += meal.Amount
+		invoice.TaxableValue += meal.TaxableValue
+		invoice.CGST += meal.CGST
+		invoice.SGST += meal.SGST
+		invoice.Taxes = addTaxLines(invoice.Taxes, invoiceTaxes(tax), share)
+		invoice.Total += meal.Amount
+	}
+	for _, meals := range byEmployee {
+		meals.Total = roundPaise(meals.Total)
+		invoice.Employees = append(invoice.Employees, *meals)
+	}
+	slices.SortFunc(invoice.Employees, func(a, b EmployeeMeals) int { return strings.Compare(a.Employee, b.Employee) })
+	invoice.TaxableValue, invoice.CGST = roundPaise(invoice.TaxableValue), roundPaise(invoice.CGST)
+	invoice.SGST, invoice.Total = roundPaise(invoice.SGST), roundPaise(invoice.Total)
+	return invoice, nil
+}
+
+// IssueCompanyInvoice returns the company's invoice for a month that has ended, issuing it with the next
+// number if it has none yet. The purchase order defaults to the company's standing one.
+func IssueCompanyInvoice(ctx context.Context, id primitive.ObjectID, month, poNumber string) (CompanyInvoice, error) {
+	invoice, err := storeFor(ctx).Companies().FindInvoice(ctx, id, month)
+	if !errors.Is(err, ErrNotFound) {
+		return invoice, err
+	}
+	from, to, err := monthRange(month)
+	if err != nil {
+		return CompanyInvoice{}, err
+	}
+	if clock.Now().Before(to) {
+		return CompanyInvoice{}, fmt.Errorf("%s can be invoiced from %s", from.Format("January 2006"), to.Format("02 Jan 2006"))
+	}
+	company, err := FindCompany(ctx, id)
+	if err != nil {
+		return CompanyInvoice{}, err
+	}
+
+	for attempt := 0; attempt < maxInvoiceAttempts; attempt++ {
+		if invoice, err = buildCompanyInvoice(ctx, company, from, to); err != nil {
+			return CompanyInvoice{}, err
+		}
+		if poNumber = strings.TrimSpace(poNumber); poNumber != "" {
+			invoice.PONumber = poNumber
+		}
+		invoice.ID = primitive.NewObjectID()
+		invoice.IssuedAt = clock.Now()
+		invoice.FinancialYear = financialYear(invoice.IssuedAt)
+		last, err := storeFor(ctx).Companies().LastInvoiceSeq(ctx, invoice.FinancialYear)
+		if err != nil {
+			return CompanyInvoice{}, err
+		}
+		invoice.Seq = last + 1
+		invoice.Number = fmt.Sprintf("%sCORP/%s/%05d", gst.InvoicePrefix, invoice.FinancialYear, invoice.Seq)
+
+		err = storeFor(ctx).Companies().InsertInvoice(ctx, invoice)
+		if errors.Is(err, ErrDuplicate) {
+			// Either the number was taken or the month was invoiced meanwhile
+			if existing, err := storeFor(ctx).Companies().FindInvoice(ctx, id, month); err == nil {
+				return existing, nil
+			}
+			continue
+		}
+		return invoice, err
+	}
+	return CompanyInvoice{}, fmt.Errorf("invoice numbers are being issued too fast, try again")
+}
+
+// FindCompanyInvoice loads the company's invoice for a month
+func FindCompanyInvoice(ctx context.Context, id primitive.ObjectID, month string) (CompanyInvoice, error) {
+	return storeFor(ctx).Companies().FindInvoice(ctx, id, month)
+}
+
+// ListCompanyInvoices returns the company's invoices, oldest month first
+func ListCompanyInvoices(ctx context.Context, id primitive.ObjectID) ([]CompanyInvoice, error) {
+	invoices, err := storeFor(ctx).Companies().ListInvoices(ctx, id)
+	if invoices == nil {
+		invoices = []CompanyInvoice{}
+	}
+	return invoices, err
+}
+
+// WriteCompanyInvoice prints the invoice for sending to the company, with each employee's meals
+func WriteCompanyInvoice(w io.Writer, invoice CompanyInvoice) error {
+	fmt.Fprintf(w, "Tax invoice %s, %s\n", invoice.Number, invoice.IssuedAt.Format("02 Jan 2006"))
+	if invoice.GSTIN != "" {
+		fmt.Fprintln(w, "GSTIN:", invoice.GSTIN)
+	}
+	fmt.Fprintf(w, "\nBilled to: %s\n", invoice.Company)
+	if invoice.CompanyGSTIN != "" {
+		fmt.Fprintln(w, "GSTIN:", invoice.CompanyGSTIN)
+	}
+	if invoice.PONumber != "" {
+		fmt.Fprintln(w, "PO reference:", invoice.PONumber)
+	}
+	month, _ := time.Parse("2006-01", invoice.Month)
+	fmt.Fprintf(w, "Meals in %s\n", month.Format("January 2006"))
+	for _, meals := range invoice.Employees {
+		fmt.Fprintf(w, "\n%s\n", meals.Employee)
+		for _, meal := range meals.Meals {
+			fmt.Fprintf(w, "  %-17s  Order %s %10.2f\n", meal.At.Format("02 Jan 2006 15:04"), meal.OrderID.Hex(), meal.Amount)
+		}
+		fmt.Fprintf(w, "  %-49s %10.2f\n", "Subtotal", meals.Total)
+	}
+	if len(invoice.Taxes) == 0 {
+		_, err := fmt.Fprintf(w, "\nTaxable value: Rs %.2f, CGST: Rs %.2f, SGST: Rs %.2f\nTotal: Rs %.2f\n",
+			invoice.TaxableValue, invoice.CGST, invoice.SGST, invoice.Total)
+		return err
+	}
+	fmt.Fprintf(w, "\nTaxable value: Rs %.2f\n", invoice.TaxableValue)
+	for _, tax := range invoice.Taxes {
+		fmt.Fprintf(w, "%s: Rs %.2f\n", tax.Label, tax.Amount)
+	}
+	_, err := fmt.Fprintf(w, "Total: Rs %.2f\n", invoice.Total)
+	return err
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// What a customer complained about
+const (
+	CauseWrongItem   = "wrong-item"
+	CauseMissingItem = "missing-item"
+	CauseQuality     = "quality" // Cold, undercooked, not as described...
+	CauseLate        = "late"
+	CauseOther       = "other"
+)
+
+var complaintCauses = []string{CauseWrongItem, CauseMissingItem, CauseQuality, CauseLate, CauseOther}
+
+// How a complaint is put right
+const (
+	ResolveRemake  = "remake"  // The item is made again, free
+	ResolveRefund  = "refund"  // Money is handed back
+	ResolveCoupon  = "coupon"  // A discount off the customer's next visit
+	ResolveApology = "apology" // Nothing beyond an apology, e.g. when the complaint did not stand up
+)
+
+var complaintResolutions = []string{ResolveRemake, ResolveRefund, ResolveCoupon, ResolveApology}
+
+// Complaint statuses
+const (
+	ComplaintOpen     = "open"
+	ComplaintResolved = "resolved"
+)
+
+const (
+	// complaintCouponValidity is how long a complaint's coupon can be used
+	complaintCouponValidity = 60 * 24 * time.Hour
+	// maxComplaintText caps a complaint's description and a resolution's note
+	maxComplaintText = 500
+)
+
+// compensationLimit is the value in rupees of a refund or remake above which a manager's override is needed, from
+// RMS_COMPENSATION_LIMIT. Coupons follow the discount limits.
+var compensationLimit = 300.0
+
+// SetCompensationLimit sets the refund or remake value above which a manager's override is needed; empty keeps
+// Rs 300
+func SetCompensationLimit(spec string) error {
+	compensationLimit = 300
+	if strings.TrimSpace(spec) == "" {
+		return nil
+	}
+	limit, err := strconv.ParseFloat(strings.TrimSpace(spec), 64)
+	if err != nil || limit < 0 {
+		return fmt.Errorf("invalid compensation limit %q (want an amount in rupees)", spec)
+	}
+	compensationLimit = limit
+	return nil
+}
+
+// Complaint is a customer's complaint about an order and how it was put right
+type Complaint struct {
+	ID           primitive.ObjectID   `bson:"_id" json:"id"`
+	Number       int                  `bson:"number" json:"number"` // Counted from 1, for staff and customers to refer to
+	OrderID      primitive.ObjectID   `bson:"orderId" json:"orderId"`
+	CustomerName string               `bson:"customerName" json:"customerName"`
+	Branch       string               `bson:"branch,omitempty" json:"branch,omitempty"`
+	Cause        string               `bson:"cause" json:"cause"`
+	Item         string               `bson:"item,omitempty" json:"item,omitempty"` // The dish complained about, if it was one
+	Quantity     int                  `bson:"quantity,omitempty" json:"quantity,omitempty"`
+	Description  string               `bson:"description" json:"description"`
+	Status       string               `bson:"status" json:"status"`
+	RaisedBy     string               `bson:"raisedBy" json:"raisedBy"`
+	CreatedAt    time.Time            `bson:"createdAt" json:"createdAt"`
+	Resolution   *ComplaintResolution `bson:"resolution,omitempty" json:"resolution,omitempty"`
+	Version      int                  `bson:"version" json:"version"`
+}
+
+// ComplaintResolution is what was done to put a complaint right
+type ComplaintResolution struct {
+	Action        string             `bson:"action" json:"action"`
+	Amount        float64            `bson:"amount,omitempty" json:"amount,omitempty"`   // Refunded, or what the remake is worth
+	Percent       float64            `bson:"percent,omitempty" json:"percent,omitempty"` // Off the coupon
+	Note          string             `bson:"note,omitempty" json:"note,omitempty"`
+	RefundID      primitive.ObjectID `bson:"refundId,omitempty" json:"refundId,omitzero"`
+	RemakeOrderID primitive.ObjectID `bson:"remakeOrderId,omitempty" json:"remakeOrderId,omitzero"`
+	CouponCode    string             `bson:"couponCode,omitempty" json:"couponCode,omitempty"`
+	ApprovedBy    string             `bson:"approvedBy,omitempty" json:"approvedBy,omitempty"`
+	ResolvedBy    string             `bson:"resolvedBy" json:"resolvedBy"`
+	ResolvedAt    time.Time          `bson:"resolvedAt" json:"resolvedAt"`
+}
+
+// RaiseComplaint records a complaint about an order. A complaint about a dish must name one on the order, and
+// says how many of it were affected.
+func RaiseComplaint(ctx context.Context, complaint Complaint) (Complaint, error) {
+	complaint.Cause = strings.ToLower(strings.TrimSpace(complaint.Cause))
+	complaint.Item, complaint.Description = strings.TrimSpace(complaint.Item), strings.TrimSpace(complaint.Description)
+	switch {
+	case !slices.Contains(complaintCauses, complaint.Cause):
+		return Complaint{}, fmt.Errorf("cause must be one of %s", strings.Join(complaintCauses, ", "))
+	case complaint.Description == "":
+		return Complaint{}, fmt.Errorf("describe what went wrong")
+	case utf8.RuneCountInString(complaint.Description) > maxComplaintText:
+		return Complaint{}, fmt.Errorf("the description must be at most %d characters", maxComplaintText)
+	}
+	order, err := FindOrder(ctx, complaint.OrderID)
+	if err != nil {
+		return Complaint{}, err
+	}
+	if complaint.Item != "" {
+		ordered := 0
+		for _, line := range order.Items {
+			if strings.EqualFold(line.Name, complaint.Item) {
+				complaint.Item = line.Name
+				ordered += line.Quantity
+			}
+		}
+		if ordered == 0 {
+			return Complaint{}, fmt.Errorf("%s is not on the order", complaint.Item)
+		}
+		complaint.Quantity = max(complaint.Quantity, 1)
+		if complaint.Quantity > ordered {
+			return Complaint{}, fmt.Errorf("only %d x %s was ordered", ordered, complaint.Item)
+		}
+	} else {
+		complaint.Quantity = 0
+	}
+	number, err := storeFor(ctx).Counters().Next(ctx, "complaint")
+	if err != nil {
+		return Complaint{}, err
+	}
+	complaint.ID, complaint.Number, complaint.Status = primitive.NewObjectID(), number, ComplaintOpen
+	complaint.CustomerName, complaint.Branch = order.CustomerName, order.Branch
+	complaint.RaisedBy, complaint.CreatedAt = changedBy(ctx), clock.Now()
+	complaint.Resolution, complaint.Version = nil, 1
+	if err := storeFor(ctx).Complaints().Insert(ctx, complaint); err != nil {
+		return Complaint{}, err
+	}
+	return complaint, nil
+}
+
+// FindComplaint looks up a complaint by its id
+func FindComplaint(ctx context.Context, id primitive.ObjectID) (Complaint, error) {
+	return storeFor(ctx).Complaints().Find(ctx, id)
+}
+
+// ListComplaints returns the complaints raised in [from, to), oldest first; with open only those not yet resolved
+func ListComplaints(ctx context.Context, from, to time.Time, open bool) ([]Complaint, error) {
+	complaints, err := storeFor(ctx).Complaints().ListBetween(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	listed := []Complaint{}
+	for _, complaint := range complaints {
+		if !open || complaint.Status == ComplaintOpen {
+			listed = append(listed, complaint)
+		}
+	}
+	return listed, nil
+}
+
+// ResolveComplaint puts an open complaint right with a remake of the item, a refund, a coupon or an apology. A
+// refund or remake worth more than the compensation limit, or a coupon above the caller's discount limit, needs a
+// manager's override. A version other than 0 must be the complaint's current one.
+func ResolveComplaint(ctx context.Context, id primitive.ObjectID, version int, resolution ComplaintResolution, override Override) (Complaint, error) {
+	resolution.Action = strings.ToLower(strings.TrimSpace(resolution.Action))
+	resolution.Note = strings.TrimSpace(resolution.Note)
+	if !slices.Contains(complaintResolutions, resolution.Action) {
+		return Complaint{}, fmt.Errorf("action must be one of %s", strings.Join(complaintResolutions, ", "))
+	}
+	if utf8.RuneCountInString(resolution.Note) > maxComplaintText {
+		return Complaint{}, fmt.Errorf("the note must be at most %d characters", maxComplaintText)
+	}
+	complaint, err := storeFor(ctx).Complaints().Find(ctx, id)
+	if err != nil {
+		return Complaint{}, err
+	}
+	if version != 0 && complaint.Version != version {
+		return Complaint{}, fmt.Errorf("complaint %d %w", complaint.Number, ErrVersionConflict)
+	}
+	if complaint.Status != ComplaintOpen {
+		return Complaint{}, fmt.Errorf("complaint %d is already %s", complaint.Number, complaint.Status)
+	}
+	order, err := FindOrder(ctx, complaint.OrderID)
+	if err != nil {
+		return Complaint{}, err
+	}
+
+	resolution.Amount, resolution.Percent = roundPaise(resolution.Amount), roundPaise(resolution.Percent)
+	if resolution.Action != ResolveCoupon {
+		resolution.Percent = 0
+	}
+	needsOverride := false
+	switch resolution.Action {
+	case ResolveRefund:
+		if resolution.Amount <= 0 {
+			return Complaint{}, fmt.Errorf("the refund must be positive")
+		}
+		needsOverride = resolution.Amount > compensationLimit
+	case ResolveRemake:
+		if complaint.Item == "" {
+			return Complaint{}, fmt.Errorf("only a complaint about a dish can be put right with a remake")
+		}
+		line := order.Items[slices.IndexFunc(order.Items, func(line OrderLine) bool { return line.Name == complaint.Item })]
+		line.Quantity = complaint.Quantity
+		resolution.Amount = roundPaise(line.Amount())
+		needsOverride = resolution.Amount > compensationLimit
+	case ResolveCoupon:
+		resolution.Amount = 0
+		if resolution.Percent <= 0 || resolution.Percent > 100 {
+			return Complaint{}, fmt.Errorf("the coupon must be for more than 0 and at most 100 percent")
+		}
+		if limit := managerDiscountLimit(); resolution.Percent > limit {
+			return Complaint{}, fmt.Errorf("coupons are limited to %g%%, even with a manager's override", limit)
+		}
+		needsOverride = resolution.Percent > discountLimit(ctx)
+	default:
+		resolution.Amount = 0
+	}
+	if needsOverride {
+		if resolution.ApprovedBy, err = approveOverride(ctx, OverrideCompensation, override); err != nil {
+			return Complaint{}, err
+		}
+	} else {
+		resolution.ApprovedBy = ""
+	}
+	resolution.RefundID, resolution.RemakeOrderID, resolution.CouponCode = primitive.NilObjectID, primitive.NilObjectID, ""
+	resolution.ResolvedBy, resolution.ResolvedAt = changedBy(ctx), clock.Now()
+
+	// The complaint is resolved first, so two terminals cannot both compensate it
+	resolved := complaint
+	resolved.Status, resolved.Resolution = ComplaintResolved, &resolution
+	if err := storeFor(ctx).Complaints().Update(ctx, resolved); err != nil {
+		if errors.Is(err, ErrVersionConflict) {
+			return Complaint{}, fmt.Errorf("complaint %d %w", complaint.Number, err)
+		}
+		return Complaint{}, err
+	}
+	resolved.Version++
+	if err := compensate(ctx, &resolved, order); err != nil {
+		reopened := complaint
+		reopened.Version = resolved.Version
+		if undoErr := storeFor(ctx).Complaints().Update(ctx, reopened); undoErr != nil {
+			log.Println("Error reopening a complaint whose compensation failed:", undoErr)
+		}
+		return Complaint{}, err
+	}
+	if resolved.Resolution.Action == ResolveApology {
+		return resolved, nil
+	}
+	if err := storeFor(ctx).Complaints().Update(ctx, resolved); err != nil {
+		// The compensation was given; only the reference to it is missing
+		log.Printf("Error recording the compensation of complaint %d: %v", resolved.Number, err)
+		return resolved, nil
+	}
+	resolved.Version++
+	return resolved, nil
+}
+
+// compensate gives the customer what the complaint's resolution says, recording a reference to it
+func compensate(ctx context.Context, complaint *Complaint, order Order) error {
+	resolution := complaint.Resolution
+	reason := fmt.Sprintf("Complaint %d: %s", complaint.Number, complaint.Cause)
+	switch resolution.Action {
+	case ResolveRefund:
+		refund, err := RefundOrder(ctx, complaint.OrderID, resolution.Amount, reason, resolution.ResolvedBy)
+		if err != nil {
+			return err
+		}
+		resolution.RefundID = refund.ID
+	case ResolveRemake:
+		remake, err := remakeItem(ctx, *complaint, order)
+		if err != nil {
+			return err
+		}
+		resolution.RemakeOrderID = remake.ID
+	case ResolveCoupon:
+		code, err := newCouponCode(OccasionComplaint)
+		if err != nil {
+			return err
+		}
+		now := clock.Now()
+		coupon := Coupon{
+			ID: primitive.NewObjectID(), Code: code, CustomerName: complaint.CustomerName, Occasion: OccasionComplaint,
+			Year: complaint.Number, Percent: resolution.Percent, ValidFrom: now, ValidUntil: now.Add(complaintCouponValidity), CreatedAt: now,
+		}
+		if err := storeFor(ctx).Coupons().Add(ctx, coupon); err != nil {
+			return err
+		}
+		resolution.CouponCode = coupon.Code
+		Notify(ctx, templateMessage(ctx, TemplateComplaintCoupon, customerPhone(ctx, complaint.CustomerName), map[string]string{
+			"customerName": complaint.CustomerName, "code": coupon.Code, "percent": fmt.Sprint(coupon.Percent),
+			"validUntil": coupon.ValidUntil.Format("2 January"),
+		}))
+	}
+	return nil
+}
+
+// remakeItem sends the dish complained about to the kitchen again, free, as an order of its own
+func remakeItem(ctx context.Context, complaint Complaint, order Order) (Order, error) {
+	item, found := FindMenuItem(LoadMenu(ctx), complaint.Item)
+	if !found {
+		return Order{}, fmt.Errorf("%s is no longer on the menu to remake", complaint.Item)
+	}
+	line := AddToCart(nil, item, complaint.Quantity)[0]
+	if i := slices.IndexFunc(order.Items, func(l OrderLine) bool { return l.Name == complaint.Item }); i >= 0 {
+		line.Weight = order.Items[i].Weight
+	}
+	// The remake is comped rather than priced at nothing, so the comps and discounts report shows what it was worth
+	resolution := complaint.Resolution
+	line.Discount = 100
+	line.DiscountReason = &DiscountReason{
+		Code: DiscountComplaint, Note: fmt.Sprintf("Remake for complaint %d", complaint.Number), By: resolution.ResolvedBy, ApprovedBy: resolution.ApprovedBy,
+	}
+	line.Flags = []string{LineFlagRush}
+	remake := Order{
+		CustomerName: order.CustomerName, Table: order.Table, Type: order.Type, Waiter: order.Waiter, Items: []OrderLine{line},
+		Notes: fmt.Sprintf("Remake for complaint %d", complaint.Number), DeliveryAddress: order.DeliveryAddress,
+		RemakeOf: order.ID, IgnoreMenuHours: true, AllergyOverride: order.AllergyOverride, IDVerified: order.IDVerified,
+	}
+	return SubmitOrder(ctx, remake)
+}
+
+// ComplaintCount is how many complaints were raised for one cause or item
+type ComplaintCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// ComplaintReport sums up a month's complaints by cause and by dish, with what putting them right cost
+type ComplaintReport struct {
+	Month      string           `json:"month"`
+	Complaints int              `json:"complaints"`
+	Open       int              `json:"open"`
+	ByCause    []ComplaintCount `json:"byCause"`
+	ByItem     []ComplaintCount `json:"byItem"`
+	Refunded   float64          `json:"refunded"`
+	Remade     float64          `json:"remade"` // What the remade dishes are worth on the menu
+	Coupons    int              `json:"coupons"`
+}
+
+// countComplaint adds one to name's count, keeping the counts most first
+func countComplaint(counts []ComplaintCount, name string) []ComplaintCount {
+	i := slices.IndexFunc(counts, func(c ComplaintCount) bool { return c.Name == name })
+	if i < 0 {
+		counts = append(counts, ComplaintCount{Name: name})
+		i = len(counts) - 1
+	}
+	counts[i].Count++
+	slices.SortStableFunc(counts, func(a, b ComplaintCount) int { return b.Count - a.Count })
+	return counts
+}
+
+// BuildComplaintReport sums up the complaints raised in the month (YYYY-MM)
+func BuildComplaintReport(ctx context.Context, month string) (ComplaintReport, error) {
+	from, to, err := monthRange(month)
+	if err != nil {
+		return ComplaintReport{}, err
+	}
+	complaints, err := storeFor(ctx).Complaints().ListBetween(ctx, from, to)
+	if err != nil {
+		return ComplaintReport{}, err
+	}
+	report := ComplaintReport{Month: month, ByCause: []ComplaintCount{}, ByItem: []ComplaintCount{}}
+	for _, complaint := range complaints {
+		report.Complaints++
+		report.ByCause = countComplaint(report.ByCause, complaint.Cause)
+		if complaint.Item != "" {
+			report.ByItem = countComplaint(report.ByItem, complaint.Item)
+		}
+		if complaint.Resolution == nil {
+			report.Open++
+			continue
+		}
+		switch complaint.Resolution.Action {
+		case ResolveRefund:
+			report.Refunded += complaint.Resolution.Amount
+		case ResolveRemake:
+			report.Remade += complaint.Resolution.Amount
+		case ResolveCoupon:
+			report.Coupons++
+		}
+	}
+	report.Refunded, report.Remade = roundPaise(report.Refunded), roundPaise(report.Remade)
+	return report, nil
+}
+
+// ShowComplaintReport prints the month's complaints by cause and by dish
+func ShowComplaintReport(ctx context.Context, month string) error {
+	report, err := BuildComplaintReport(ctx, month)
+	if err != nil {
+		return err
+	}
+	complaintListing := listing{
+		title: fmt.Sprintf("Complaints for %s: %d, %d open; Rs %.2f refunded, Rs %.2f remade, %d coupons:", month,
+			report.Complaints, report.Open, report.Refunded, report.Remade, report.Coupons),
+		header:  []string{"By", "Cause or item", "Complaints"},
+		records: report,
+	}
+	for _, group := range []struct {
+		by     string
+		counts []ComplaintCount
+	}{{"cause", report.ByCause}, {"item", report.ByItem}} {
+		for _, count := range group.counts {
+			complaintListing.rows = append(complaintListing.rows, []string{group.by, count.Name, fmt.Sprint(count.Count)})
+			complaintListing.compact = append(complaintListing.compact, fmt.Sprintf("%s %s: %d", group.by, count.Name, count.Count))
+		}
+	}
+	return printListing(os.Stdout, complaintListing)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Channels notifications go out on
+const (
+	ChannelSMS      = "sms"
+	ChannelEmail    = "email"
+	ChannelWhatsApp = "whatsapp"
+)
+
+var channels = []string{ChannelSMS, ChannelEmail, ChannelWhatsApp}
+
+// What a notification is about
+const (
+	MessageTransactional = "transactional" // Something the customer is doing with us: an order, a quote, a complaint
+	MessageMarketing     = "marketing"     // Offers and surveys they did not ask for
+)
+
+// Keywords a customer can reply with to stop or restart marketing on the channel they reply on
+var (
+	stopKeywords  = []string{"STOP", "UNSUBSCRIBE", "OPTOUT", "OPT OUT", "CANCEL"}
+	startKeywords = []string{"START", "SUBSCRIBE", "OPTIN", "OPT IN", "UNSTOP"}
+)
+
+// marketingNeedsConsent is set from RMS_MARKETING_CONSENT to send marketing only to customers who agreed to it;
+// otherwise customers who never said either way are sent it
+var marketingNeedsConsent = false
+
+// phoneChannel is the channel messages to phone numbers go out on, from RMS_PHONE_CHANNEL
+var phoneChannel = ChannelSMS
+
+// SetPhoneChannel sets the channel, sms or whatsapp, messages to phone numbers go out on; empty keeps sms
+func SetPhoneChannel(channel string) error {
+	switch channel = strings.ToLower(strings.TrimSpace(channel)); channel {
+	case "":
+		phoneChannel = ChannelSMS
+	case ChannelSMS, ChannelWhatsApp:
+		phoneChannel = channel
+	default:
+		return fmt.Errorf("invalid phone channel %q (want sms or whatsapp)", channel)
+	}
+	return nil
+}
+
+// CommunicationPrefs is what a customer agreed to be sent on each channel. A customer without them is sent
+// messages about their orders everywhere, and marketing unless RMS_MARKETING_CONSENT asks for consent first.
+type CommunicationPrefs struct {
+	Transactional []string  `bson:"transactional" json:"transactional"` // Channels they take messages about their orders on
+	Marketing     []string  `bson:"marketing" json:"marketing"`         // Channels they agreed to offers and surveys on
+	UpdatedAt     time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// allows reports whether a customer with the preferences may be sent a message of the kind on the channel
+func (p *CommunicationPrefs) allows(kind, channel string) bool {
+	switch {
+	case p == nil:
+		return kind != MessageMarketing || !marketingNeedsConsent
+	case kind == MessageMarketing:
+		return slices.Contains(p.Marketing, channel)
+	default:
+		return slices.Contains(p.Transactional, channel)
+	}
+}
+
+// cleanChannels lowercases the channels and checks that they are known
+func cleanChannels(list []string) ([]string, error) {
+	cleaned := []string{}
+	for _, channel := range list {
+		channel = strings.ToLower(strings.TrimSpace(channel))
+		if !slices.Contains(channels, channel) {
+			return nil, fmt.Errorf("unknown channel %q (want %s)", channel, strings.Join(channels, ", "))
+		}
+		if !slices.Contains(cleaned, channel) {
+			cleaned = append(cleaned, channel)
+		}
+	}
+	return cleaned, nil
+}
+
+// SetCommunicationPrefs records the channels a customer agreed to be sent messages about their orders and
+// marketing on, creating the customer if needed. With a version other than 0 the customer must exist and still be
+// at that version.
+func SetCommunicationPrefs(ctx context.Context, name string, version int, prefs CommunicationPrefs) (Customer, error) {
+	var err error
+	if prefs.Transactional, err = cleanChannels(prefs.Transactional); err != nil {
+		return Customer{}, err
+	}
+	if prefs.Marketing, err = cleanChannels(prefs.Marketing); err != nil {
+		return Customer{}, err
+	}
+	prefs.UpdatedAt = clock.Now()
+	return setCommunicationPrefs(ctx, name, version, &prefs)
+}
+
+// Unsubscribe stops marketing to the customer on the channel, or on every channel when channel is empty. Messages
+// about their orders carry on.
+func Unsubscribe(ctx context.Context, name, channel string) (Customer, error) {
+	return changeMarketing(ctx, name, channel, false)
+}
+
+// changeMarketing adds the channel to, or takes it out of, the channels a customer agreed to marketing on; an
+// empty channel means all of them
+func changeMarketing(ctx context.Context, name, channel string, subscribe bool) (Customer, error) {
+	name = strings.TrimSpace(name)
+	channel = strings.ToLower(strings.TrimSpace(channel))
+	if channel != "" && !slices.Contains(channels, channel) {
+		return Customer{}, fmt.Errorf("unknown channel %q (want %s)", channel, strings.Join(channels, ", "))
+	}
+	for {
+		customer, err := storeFor(ctx).Customers().FindByName(ctx, name)
+		if err != nil {
+			return Customer{}, err
+		}
+		prefs := CommunicationPrefs{Transactional: slices.Clone(channels), Marketing: []string{}}
+		if customer.Communication != nil {
+			prefs = *customer.Communication
+			prefs.Marketing = slices.Clone(prefs.Marketing)
+		} else if !marketingNeedsConsent {
+			prefs.Marketing = slices.Clone(channels)
+		}
+		switch {
+		case channel == "" && subscribe:
+			prefs.Marketing = slices.Clone(channels)
+		case channel == "":
+			prefs.Marketing = []string{}
+		case subscribe && !slices.Contains(prefs.Marketing, channel):
+			prefs.Marketing = append(prefs.Marketing, channel)
+		case !subscribe:
+			prefs.Marketing = slices.DeleteFunc(prefs.Marketing, func(c string) bool { return c == channel })
+		}
+		prefs.UpdatedAt = clock.Now()
+		updated, err := setCommunicationPrefs(ctx, name, customer.Version, &prefs)
+		if errors.Is(err, ErrVersionConflict) {
+			continue
+		}
+		return updated, err
+	}
+}
+
+func setCommunicationPrefs(ctx context.Context, name string, version int, prefs *CommunicationPrefs) (Customer, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Customer{}, fmt.Errorf("customer name is required")
+	}
+	err := storeFor(ctx).Customers().SetCommunication(ctx, name, version, prefs)
+	if errors.Is(err, ErrVersionConflict) {
+		return Customer{}, fmt.Errorf("%s %w", name, ErrVersionConflict)
+	}
+	if err != nil {
+		return Customer{}, err
+	}
+	return storeFor(ctx).Customers().FindByName(ctx, name)
+}
+
+// InboundReply is what was done with a message a customer sent in
+type InboundReply struct {
+	Customer string `json:"customer,omitempty"`
+	Action   string `json:"action"` // unsubscribed, subscribed or ignored
+}
+
+// HandleInboundMessage acts on a customer's reply from their phone, passed on by the messaging gateway: STOP and
+// the like stop marketing on the channel it came in on, START restarts it. Anything else is ignored.
+func HandleInboundMessage(ctx context.Context, from, channel, text string) (InboundReply, error) {
+	channel = strings.ToLower(strings.TrimSpace(channel))
+	if channel == "" {
+		channel = phoneChannel
+	}
+	keyword := strings.ToUpper(strings.Join(strings.Fields(text), " "))
+	subscribe := slices.Contains(startKeywords, keyword)
+	if !subscribe && !slices.Contains(stopKeywords, keyword) {
+		return InboundReply{Action: "ignored"}, nil
+	}
+	customer, err := storeFor(ctx).Customers().FindByPhone(ctx, strings.TrimSpace(from))
+	if err != nil {
+		return InboundReply{}, err
+	}
+	if _, err := changeMarketing(ctx, customer.Name, channel, subscribe); err != nil {
+		return InboundReply{}, err
+	}
+	reply := InboundReply{Customer: customer.Name, Action: "unsubscribed"}
+	message := "You will no longer receive offers from us. Reply START to subscribe again."
+	if subscribe {
+		reply.Action, message = "subscribed", "You will receive our offers again. Reply STOP to unsubscribe."
+	}
+	Notify(ctx, Notification{To: customer.Phone, Name: customer.Name, Channel: channel, Subject: "Preferences updated", Message: message})
+	return reply, nil
+}
+
+// notificationAllowed reports whether the customer the notification is for agreed to it. Messages to people who
+// are not customers, such as the kitchen, always go; offline marketing is held back as consent cannot be checked.
+func notificationAllowed(ctx context.Context, n Notification) bool {
+	if IsOffline() {
+		return n.Kind != MessageMarketing
+	}
+	customer, err := storeFor(ctx).Customers().FindByName(ctx, n.Name)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return true
+	case err != nil:
+		return n.Kind != MessageMarketing
+	}
+	return customer.Communication.allows(n.Kind, n.Channel)
+}
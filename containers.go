@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ContainerLoan is the reusable containers an order went out in, with the deposit paid for them and how many have
+// come back. Its ID is the order's.
+type ContainerLoan struct {
+	ID             primitive.ObjectID `bson:"_id" json:"orderId"`
+	CustomerName   string             `bson:"customerName" json:"customerName"`
+	Containers     int                `bson:"containers" json:"containers"`
+	Deposit        float64            `bson:"deposit" json:"deposit"` // For all of them
+	Returned       int                `bson:"returned" json:"returned"`
+	Outstanding    int                `bson:"outstanding" json:"outstanding"` // Containers not back yet
+	Refunded       float64            `bson:"refunded" json:"refunded"`
+	LentAt         time.Time          `bson:"lentAt" json:"lentAt"`
+	LastReturnedAt *time.Time         `bson:"lastReturnedAt,omitempty" json:"lastReturnedAt,omitempty"`
+	Version        int                `bson:"version" json:"version"`
+}
+
+// lendContainers records the reusable containers of an order that paid a deposit for them, a serving in each
+func lendContainers(ctx context.Context, order Order) error {
+	var deposit float64
+	for _, charge := range order.Charges {
+		if charge.Name == ChargeContainerDeposit {
+			deposit += charge.Amount
+		}
+	}
+	if deposit == 0 {
+		return nil
+	}
+	containers, menu := 0, LoadMenu(ctx)
+	for _, line := range order.Items {
+		if item, _ := FindMenuItem(menu, line.Name); item.ContainerDeposit > 0 {
+			containers += line.Quantity
+		}
+	}
+	loan := ContainerLoan{
+		ID: order.ID, CustomerName: order.CustomerName, Containers: containers, Deposit: deposit, Outstanding: containers,
+		LentAt: order.CreatedAt,
+	}
+	if err := storeFor(ctx).Containers().Insert(ctx, loan); err != nil && !errors.Is(err, ErrDuplicate) {
+		return err
+	}
+	return nil
+}
+
+// ReturnContainers takes back count of an order's reusable containers and refunds their share of the deposit, the
+// last one back getting whatever is left of it
+func ReturnContainers(ctx context.Context, orderID primitive.ObjectID, count int) (ContainerLoan, Refund, error) {
+	if count < 1 {
+		return ContainerLoan{}, Refund{}, fmt.Errorf("count must be at least 1")
+	}
+	for {
+		loan, err := storeFor(ctx).Containers().Find(ctx, orderID)
+		if err != nil {
+			return ContainerLoan{}, Refund{}, err
+		}
+		if count > loan.Outstanding {
+			return ContainerLoan{}, Refund{}, fmt.Errorf("only %d of the order's containers are still out", loan.Outstanding)
+		}
+		amount := roundPaise(loan.Deposit * float64(count) / float64(loan.Containers))
+		if count == loan.Outstanding {
+			amount = roundPaise(loan.Deposit - loan.Refunded)
+		}
+		returned := loan
+		now := clock.Now()
+		returned.Returned += count
+		returned.Outstanding -= count
+		returned.Refunded = roundPaise(loan.Refunded + amount)
+		returned.LastReturnedAt = &now
+		// The containers are taken back first, so two terminals cannot both refund them
+		err = storeFor(ctx).Containers().Update(ctx, returned)
+		if errors.Is(err, ErrVersionConflict) {
+			continue
+		}
+		if err != nil {
+			return ContainerLoan{}, Refund{}, err
+		}
+		returned.Version++
+
+		refund, err := RefundOrder(ctx, orderID, amount, fmt.Sprintf("%d reusable containers returned", count), changedBy(ctx))
+		if err != nil {
+			returned.Returned, returned.Outstanding, returned.Refunded = loan.Returned, loan.Outstanding, loan.Refunded
+			returned.LastReturnedAt = loan.LastReturnedAt
+			if undoErr := storeFor(ctx).Containers().Update(ctx, returned); undoErr != nil {
+				log.Println("Error putting back the containers of a failed refund:", undoErr)
+			}
+			return ContainerLoan{}, Refund{}, err
+		}
+		return returned, refund, nil
+	}
+}
+
+// ListOutstandingContainers returns the loans with containers not back yet, oldest first
+func ListOutstandingContainers(ctx context.Context) ([]ContainerLoan, error) {
+	loans, err := storeFor(ctx).Containers().ListOutstanding(ctx)
+	if loans == nil {
+		loans = []ContainerLoan{}
+	}
+	return loans, err
+}
+
+// ShowOutstandingContainers prints the reusable containers still out with customers
+func ShowOutstandingContainers(ctx context.Context) error {
+	loans, err := ListOutstandingContainers(ctx)
+	if err != nil {
+		return err
+	}
+	loanListing := listing{
+		title:   "Containers out:",
+		header:  []string{"Order", "Customer", "Out", "Of", "Deposit held", "Since"},
+		records: loans,
+	}
+	for _, loan := range loans {
+		held := roundPaise(loan.Deposit - loan.Refunded)
+		loanListing.rows = append(loanListing.rows, []string{
+			loan.ID.Hex(), loan.CustomerName, fmt.Sprint(loan.Outstanding), fmt.Sprint(loan.Containers), fmt.Sprintf("%.2f", held),
+			loan.LentAt.Format("02 Jan 2006"),
+		})
+		loanListing.compact = append(loanListing.compact, fmt.Sprintf("%s %s %d containers out, Rs %.2f held", loan.ID.Hex(),
+			loan.CustomerName, loan.Outstanding, held))
+	}
+	return printListing(os.Stdout, loanListing)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FirstCourse is the course sent to the kitchen with the order. Lines in later courses, e.g. desserts,
+// are held until a waiter fires them.
+const FirstCourse = 1
+
+// CourseTicket is the kitchen ticket for one course of an order, with when it was fired
+type CourseTicket struct {
+	Course  int        `bson:"course" json:"course"`
+	FiredAt *time.Time `bson:"firedAt,omitempty" json:"firedAt,omitempty"` // Unset while the course is held
+}
+
+// Held reports whether the course is still waiting to be fired
+func (t CourseTicket) Held() bool {
+	return t.FiredAt == nil
+}
+
+// lineCourse returns the course a line belongs to; lines without one are in the first course
+func lineCourse(line OrderLine) int {
+	return max(line.Course, FirstCourse)
+}
+
+// syncCourses gives the order a ticket for every course its lines are in, lowest first. The first
+// course is fired when the order is placed; a new later course starts out held.
+func syncCourses(order *Order) {
+	for _, line := range order.Items {
+		course := lineCourse(line)
+		if _, found := findCourse(*order, course); found {
+			continue
+		}
+		ticket := CourseTicket{Course: course}
+		if course == FirstCourse {
+			firedAt := order.CreatedAt
+			ticket.FiredAt = &firedAt
+		}
+		order.Courses = append(order.Courses, ticket)
+	}
+	slices.SortFunc(order.Courses, func(a, b CourseTicket) int { return a.Course - b.Course })
+}
+
+// dropEmptyCourses removes the tickets of held courses that no line is in any more, e.g. after a void
+func dropEmptyCourses(order *Order) {
+	order.Courses = slices.DeleteFunc(slices.Clone(order.Courses), func(ticket CourseTicket) bool {
+		return ticket.Held() && !slices.ContainsFunc(order.Items, func(line OrderLine) bool { return lineCourse(line) == ticket.Course })
+	})
+}
+
+// findCourse returns the order's ticket for a course
+func findCourse(order Order, course int) (CourseTicket, bool) {
+	for _, ticket := range order.Courses {
+		if ticket.Course == course {
+			return ticket, true
+		}
+	}
+	return CourseTicket{}, false
+}
+
+// NextHeldCourse returns the lowest course of the order that has not been fired yet
+func NextHeldCourse(order Order) (int, bool) {
+	for _, ticket := range order.Courses {
+		if ticket.Held() {
+			return ticket.Course, true
+		}
+	}
+	return 0, false
+}
+
+// LineHeld reports whether a line of the order is in a course that has not been fired yet
+func LineHeld(order Order, line OrderLine) bool {
+	ticket, found := findCourse(order, lineCourse(line))
+	return found && ticket.Held()
+}
+
+// FireCourse sends a held course to the kitchen. An order that was ready goes back into the
+// queue so the kitchen prepares the new course. A version other than 0 must be the order's current one.
+func FireCourse(ctx context.Context, id primitive.ObjectID, version, course int) (Order, error) {
+	order, err := changeOrder(ctx, id, atOrderVersion(version, func(order Order) (OrderEvent, error) {
+		if order.Status == StatusServed {
+			return OrderEvent{}, fmt.Errorf("order is already %s", order.Status)
+		}
+		ticket, found := findCourse(order, course)
+		if !found {
+			return OrderEvent{}, fmt.Errorf("order has no course %d", course)
+		}
+		if !ticket.Held() {
+			return OrderEvent{}, fmt.Errorf("course %d was already fired at %s", course, ticket.FiredAt.Format("15:04"))
+		}
+		return OrderEvent{Type: EventCourseFired, Course: course}, nil
+	}))
+	if err != nil {
+		return order, err
+	}
+	printTickets(ctx, order, fmt.Sprintf("*** FIRE COURSE %d ***", course), func(line OrderLine) bool { return lineCourse(line) == course })
+	return order, nil
+}
+
+// FireNextCourse fires the lowest held course of the order
+func FireNextCourse(ctx context.Context, order Order) (int, error) {
+	course, held := NextHeldCourse(order)
+	if !held {
+		return 0, fmt.Errorf("order has no held course")
+	}
+	_, err := FireCourse(ctx, order.ID, 0, course)
+	return course, err
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// currencyCode is an ISO 4217 code, e.g. USD
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// exchangeRates are the rupees the till gives for one unit of each foreign currency it takes, from RMS_CURRENCIES
+var exchangeRates = map[string]float64{}
+
+// SetCurrencies sets the foreign currencies bills can be paid in and their rates from a spec like
+// "USD=83.10,EUR=90.25", in rupees for one unit; empty takes rupees only
+func SetCurrencies(spec string) error {
+	rates := map[string]float64{}
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		code, amount, ok := strings.Cut(entry, "=")
+		code = strings.ToUpper(strings.TrimSpace(code))
+		rate, err := strconv.ParseFloat(strings.TrimSpace(amount), 64)
+		if !ok || err != nil || rate <= 0 || !currencyCode.MatchString(code) || code == "INR" {
+			return fmt.Errorf("invalid currency %q (want code=rupees for one unit, e.g. USD=83.10)", entry)
+		}
+		rates[code] = rate
+	}
+	exchangeRates = rates
+	return nil
+}
+
+// exchangeRate returns the till's rate for the currency
+func exchangeRate(currency string) (float64, error) {
+	rate, ok := exchangeRates[currency]
+	if !ok {
+		return 0, fmt.Errorf("%s is not taken here; RMS_CURRENCIES lists the currencies that are", currency)
+	}
+	return rate, nil
+}
+
+// CurrencyQuote is what is due on a bill in a foreign currency, at the till's rate
+type CurrencyQuote struct {
+	Currency string  `json:"currency"`
+	Rate     float64 `json:"rate"`   // Rupees for one unit
+	Due      float64 `json:"due"`    // In rupees
+	Amount   float64 `json:"amount"` // In the currency, rounded up to the cent so the bill is covered
+}
+
+// QuoteInCurrency quotes what is still due on the order in the currency, or when currency is empty
+// in every currency taken
+func QuoteInCurrency(ctx context.Context, id primitive.ObjectID, currency string) ([]CurrencyQuote, error) {
+	order, err := FindOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	currencies := []string{strings.ToUpper(strings.TrimSpace(currency))}
+	if currencies[0] == "" {
+		currencies = currencies[:0]
+		for code := range exchangeRates {
+			currencies = append(currencies, code)
+		}
+		slices.Sort(currencies)
+	}
+	due := max(roundPaise(order.Total-order.AmountPaid), 0)
+	quotes := []CurrencyQuote{}
+	for _, code := range currencies {
+		rate, err := exchangeRate(code)
+		if err != nil {
+			return nil, err
+		}
+		// A little over a cent is still rounded up, but not float noise like 12.000000001
+		amount := math.Ceil(roundPaise(due/rate*100)) / 100
+		quotes = append(quotes, CurrencyQuote{Currency: code, Rate: rate, Due: due, Amount: amount})
+	}
+	return quotes, nil
+}
+
+// convertPayment works out the rupee amount of a payment in a foreign currency from the amount given in it, at the
+// till's rate unless it already has one, e.g. when replaying a payment queued offline. Payments in rupees are left as
+// they are.
+func convertPayment(payment *Payment) error {
+	payment.Currency = strings.ToUpper(strings.TrimSpace(payment.Currency))
+	if payment.Currency == "" || payment.Currency == "INR" {
+		payment.Currency, payment.ForeignAmount, payment.Rate = "", 0, 0
+		return nil
+	}
+	if payment.Rate == 0 {
+		rate, err := exchangeRate(payment.Currency)
+		if err != nil {
+			return err
+		}
+		payment.Rate = rate
+	}
+	payment.Amount = roundPaise(payment.ForeignAmount * payment.Rate)
+	return nil
+}
+
+// FXExchange is foreign cash taken at the till and changed into rupees, e.g. at a bank
+type FXExchange struct {
+	ID            primitive.ObjectID `bson:"_id" json:"id"`
+	Currency      string             `bson:"currency" json:"currency"`
+	ForeignAmount float64            `bson:"foreignAmount" json:"foreignAmount"`
+	Rupees        float64            `bson:"rupees" json:"rupees"` // What it was changed for
+	By            string             `bson:"by,omitempty" json:"by,omitempty"`
+	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// RecordFXExchange records foreign cash changed into rupees, so the gain or loss on it against the till's rates is
+// reported
+func RecordFXExchange(ctx context.Context, exchange FXExchange) (FXExchange, error) {
+	exchange.Currency = strings.ToUpper(strings.TrimSpace(exchange.Currency))
+	switch {
+	case !currencyCode.MatchString(exchange.Currency) || exchange.Currency == "INR":
+		return FXExchange{}, fmt.Errorf("a foreign currency code such as USD is required")
+	case exchange.ForeignAmount <= 0 || exchange.Rupees <= 0:
+		return FXExchange{}, fmt.Errorf("the foreign amount and the rupees it was changed for must be more than 0")
+	}
+	exchange.ID, exchange.CreatedAt = primitive.NewObjectID(), clock.Now()
+	exchange.ForeignAmount, exchange.Rupees = roundPaise(exchange.ForeignAmount), roundPaise(exchange.Rupees)
+	exchange.By = strings.TrimSpace(exchange.By)
+	if exchange.By == "" {
+		exchange.By = changedBy(ctx)
+	}
+	return exchange, storeFor(ctx).FXExchanges().Add(ctx, exchange)
+}
+
+// CurrencyFX is what was taken in and changed out of one foreign currency over a period. The gain or loss is what
+// the exchanges brought in against what the same amount was credited to bills for at the till's average rate.
+type CurrencyFX struct {
+	Currency     string  `json:"currency"`
+	Payments     int     `json:"payments"`
+	Taken        float64 `json:"taken"`        // In the currency
+	Credited     float64 `json:"credited"`     // Rupees the bills were paid
+	AverageRate  float64 `json:"averageRate"`  // Credited over taken
+	Exchanged    float64 `json:"exchanged"`    // In the currency
+	Received     float64 `json:"received"`     // Rupees the exchanges brought in
+	ExchangeRate float64 `json:"exchangeRate"` // Received over exchanged
+	GainLoss     float64 `json:"gainLoss"`     // Negative for a loss
+	Held         float64 `json:"held"`         // Taken less exchanged in the period, in the currency
+}
+
+// FXReport is the foreign currency taken and changed over a period, by currency
+type FXReport struct {
+	From       time.Time    `json:"from"`
+	To         time.Time    `json:"to"`
+	Currencies []CurrencyFX `json:"currencies"`
+	GainLoss   float64      `json:"gainLoss"`
+}
+
+// BuildFXReport totals the foreign currency payments and exchanges in [from, to) by currency, with the gain or loss
+// on what was exchanged
+func BuildFXReport(ctx context.Context, from, to time.Time) (FXReport, error) {
+	payments, err := storeFor(ctx).Payments().ListBetween(ctx, from, to)
+	if err != nil {
+		return FXReport{}, err
+	}
+	exchanges, err := storeFor(ctx).FXExchanges().ListBetween(ctx, from, to)
+	if err != nil {
+		return FXReport{}, err
+	}
+	byCurrency := map[string]*CurrencyFX{}
+	currency := func(code string) *CurrencyFX {
+		if byCurrency[code] == nil {
+			byCurrency[code] = &CurrencyFX{Currency: code}
+		}
+		return byCurrency[code]
+	}
+	for _, payment := range payments {
+		if payment.Currency == "" {
+			continue
+		}
+		row := currency(payment.Currency)
+		row.Payments++
+		row.Taken += payment.ForeignAmount
+		row.Credited += payment.Amount
+	}
+	for _, exchange := range exchanges {
+		row := currency(exchange.Currency)
+		row.Exchanged += exchange.ForeignAmount
+		row.Received += exchange.Rupees
+	}
+
+	report := FXReport{From: from, To: to, Currencies: []CurrencyFX{}}
+	for _, row := range byCurrency {
+		row.Taken, row.Credited = roundPaise(row.Taken), roundPaise(row.Credited)
+		row.Exchanged, row.Received = roundPaise(row.Exchanged), roundPaise(row.Received)
+		// Exchanges of currency taken before the period are valued at the current till rate
+		row.AverageRate = exchangeRates[row.Currency]
+		if row.Taken > 0 {
+			row.AverageRate = math.Round(row.Credited/row.Taken*10000) / 10000
+		}
+		if row.Exchanged > 0 {
+			row.ExchangeRate = math.Round(row.Received/row.Exchanged*10000) / 10000
+			row.GainLoss = roundPaise(row.Received - row.Exchanged*row.AverageRate)
+		}
+		row.Held = roundPaise(row.Taken - row.Exchanged)
+		report.Currencies = append(report.Currencies, *row)
+		report.GainLoss = roundPaise(report.GainLoss + row.GainLoss)
+	}
+	slices.SortFunc(report.Currencies, func(a, b CurrencyFX) int { return strings.Compare(a.Currency, b.Currency) })
+	return report, nil
+}
+
+// ShowFXReport prints the foreign currency taken and exchanged in [from, to) with the gain or loss
+func ShowFXReport(ctx context.Context, from, to time.Time) error {
+	report, err := BuildFXReport(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	title := fmt.Sprintf("Foreign currency from %s to %s:", from.Format("02 Jan 2006"), to.AddDate(0, 0, -1).Format("02 Jan 2006"))
+	fxListing := listing{
+		title:   title,
+		header:  []string{"Currency", "Taken", "Credited", "Avg rate", "Exchanged", "Received", "Rate", "Gain/loss"},
+		records: report,
+	}
+	for _, row := range report.Currencies {
+		gainLoss := fmt.Sprintf("Rs %.2f", row.GainLoss)
+		fxListing.rows = append(fxListing.rows, []string{
+			row.Currency, fmt.Sprintf("%.2f (%d)", row.Taken, row.Payments), fmt.Sprintf("Rs %.2f", row.Credited),
+			fmt.Sprintf("%.4f", row.AverageRate), fmt.Sprintf("%.2f", row.Exchanged), fmt.Sprintf("Rs %.2f", row.Received),
+			fmt.Sprintf("%.4f", row.ExchangeRate), gainLoss,
+		})
+		fxListing.compact = append(fxListing.compact, fmt.Sprintf("%s %.2f taken, %.2f exchanged, %s", row.Currency, row.Taken, row.Exchanged, gainLoss))
+	}
+	fxListing.rows = append(fxListing.rows, []string{"Total", "", "", "", "", "", "", fmt.Sprintf("Rs %.2f", report.GainLoss)})
+	return printListing(os.Stdout, fxListing)
+}
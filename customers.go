@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxSearchResults caps how many customers a search returns
+const maxSearchResults = 50
+
+// maxFavorites caps how many of a customer's most ordered items check-in offers to reorder
+const maxFavorites = 5
+
+// SearchCustomers finds customers whose name or phone contains the query, ignoring case
+func SearchCustomers(ctx context.Context, query string) ([]Customer, error) {
+	customers, err := storeFor(ctx).Customers().Search(ctx, query, maxSearchResults)
+	if customers == nil {
+		customers = []Customer{}
+	}
+	return customers, err
+}
+
+// customerPhone looks up the phone number for a customer, or "" if it is not known
+func customerPhone(ctx context.Context, name string) string {
+	customer, err := storeFor(ctx).Customers().FindByName(ctx, name)
+	if err != nil {
+		return ""
+	}
+	return customer.Phone
+}
+
+// Favorite is an item a customer orders often, ready to put straight into their next order
+type Favorite struct {
+	Item  MenuItem `json:"item"`
+	Count int      `json:"count"` // How many the customer has ordered in all
+}
+
+// CheckIn is a customer at the counter, found or added by their phone number
+type CheckIn struct {
+	Customer  Customer   `json:"customer"`
+	Favorites []Favorite `json:"favorites"` // Most ordered first, only items that can be ordered now
+	New       bool       `json:"new"`       // The customer was added by this check-in
+}
+
+// normalizePhone strips the spaces, dashes, dots and brackets people type into phone numbers, so
+// "98765 43210" finds the customer saved as "9876543210"
+func normalizePhone(phone string) string {
+	return strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "").Replace(strings.TrimSpace(phone))
+}
+
+// CheckInCustomer finds the customer with the phone number and their favorite items, so their order can be
+// started in seconds. An unknown number returns ErrNotFound unless name is given, in which case the customer
+// is added, or an existing customer with that name gets the number.
+func CheckInCustomer(ctx context.Context, phone, name string) (CheckIn, error) {
+	phone, name = normalizePhone(phone), strings.TrimSpace(name)
+	if phone == "" {
+		return CheckIn{}, fmt.Errorf("a phone number is needed to check in")
+	}
+	customers := storeFor(ctx).Customers()
+	customer, err := customers.FindByPhone(ctx, phone)
+	if errors.Is(err, ErrNotFound) && name != "" {
+		return addCheckIn(ctx, phone, name)
+	}
+	if err != nil {
+		return CheckIn{}, fmt.Errorf("no customer has the phone number %s: %w", phone, err)
+	}
+	return CheckIn{Customer: customer, Favorites: favoriteItems(customer, LoadMenu(ctx), clock.Now())}, nil
+}
+
+// addCheckIn adds a customer checking in with a number not seen before. Someone who ordered before
+// without giving their number keeps their history.
+func addCheckIn(ctx context.Context, phone, name string) (CheckIn, error) {
+	customers := storeFor(ctx).Customers()
+	customer, err := customers.FindByName(ctx, name)
+	if errors.Is(err, ErrNotFound) {
+		customer = Customer{Name: name, Phone: phone, OrderedItems: []string{}}
+		if err := customers.Add(ctx, customer); err != nil {
+			return CheckIn{}, err
+		}
+		customer.Version = 1
+		return CheckIn{Customer: customer, Favorites: []Favorite{}, New: true}, nil
+	}
+	if err == nil {
+		err = customers.SetPhone(ctx, name, phone)
+	}
+	if err != nil {
+		return CheckIn{}, err
+	}
+	customer.Phone = phone
+	customer.Version++
+	return CheckIn{Customer: customer, Favorites: favoriteItems(customer, LoadMenu(ctx), clock.Now())}, nil
+}
+
+// favoriteItems counts what the customer has ordered and returns the items ordered most, leaving out
+// any that are not served at t or are 86'd
+func favoriteItems(customer Customer, menu []MenuItem, t time.Time) []Favorite {
+	counts := make(map[string]int)
+	for _, item := range customer.OrderedItems {
+		counts[item]++
+	}
+	favorites := []Favorite{}
+	for _, item := range AvailableMenu(menu, t) {
+		if counts[item.Name] > 0 {
+			favorites = append(favorites, Favorite{Item: item, Count: counts[item.Name]})
+		}
+	}
+	// Ties keep menu order
+	slices.SortStableFunc(favorites, func(a, b Favorite) int { return b.Count - a.Count })
+	return favorites[:min(len(favorites), maxFavorites)]
+}
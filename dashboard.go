@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+//go:embed web/templates/*.html web/static/*
+var webFiles embed.FS
+
+var dashboardTemplates = template.Must(template.ParseFS(webFiles, "web/templates/*.html"))
+
+// NewServer combines the JSON API with the embedded manager dashboard. With requireKey, or in
+// SaaS mode, every API call needs an API key.
+func NewServer(requireKey bool) http.Handler {
+	static, err := fs.Sub(webFiles, "web/static")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	if tenants != nil {
+		// SaaS mode: anyone may sign up, everything else needs a tenant's API key
+		mux.HandleFunc("POST /api/tenants", handleSignup)
+		requireKey = true
+	}
+	mux.Handle("/api/", authenticateAPI(NewAPIHandler(), requireKey))
+	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.FS(static))))
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/admin", http.StatusFound)
+	})
+	mux.HandleFunc("GET /admin", handleDashboard)
+	mux.HandleFunc("GET /now-serving", handleNowServingScreen)
+	mux.HandleFunc("GET /quotes/{token}", handleQuotePage)
+	mux.HandleFunc("POST /quotes/{token}", handleQuoteResponse)
+	mux.HandleFunc("GET /surveys/{token}", handleSurveyPage)
+	mux.HandleFunc("POST /surveys/{token}", handleSurveyResponse)
+	mux.HandleFunc("GET /menu", handlePublicMenu)
+	mux.HandleFunc("GET /menu.json", handlePublicMenu)
+	mux.HandleFunc("GET /bills/{token}", handleBillPage)
+	mux.HandleFunc("POST /bills/{token}", handleBillResponse)
+	return mux
+}
+
+// handleDashboard renders the manager dashboard shell; its panels load data from the API
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Restaurant string
+	}{
+		Restaurant: "Restaurant",
+	}
+	if err := dashboardTemplates.ExecuteTemplate(w, "dashboard.html", data); err != nil {
+		log.Println("Error rendering dashboard:", err)
+	}
+}
+
+// handleNowServingScreen renders the customer-facing pickup display for takeaway tokens
+func handleNowServingScreen(w http.ResponseWriter, r *http.Request) {
+	if err := dashboardTemplates.ExecuteTemplate(w, "now_serving.html", nil); err != nil {
+		log.Println("Error rendering now serving screen:", err)
+	}
+}
+
+// customerLinkContext is the context for a page a customer reaches from a link, such as a quote or survey. In SaaS
+// mode the link names the tenant.
+func customerLinkContext(r *http.Request) (context.Context, error) {
+	if tenants == nil {
+		return r.Context(), nil
+	}
+	tenant, err := store.Tenants().Find(r.Context(), r.URL.Query().Get("tenant"))
+	if err != nil {
+		return nil, err
+	}
+	return WithTenant(r.Context(), tenant)
+}
+
+// handleQuotePage shows a catering quote to the customer, with buttons to approve or decline it
+// while it is waiting for an answer
+func handleQuotePage(w http.ResponseWriter, r *http.Request) {
+	ctx, err := customerLinkContext(r)
+	var quote Quote
+	if err == nil {
+		quote, err = FindQuoteByToken(ctx, r.PathValue("token"))
+	}
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "the quote could not be loaded, please try again later", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Quote   Quote
+		Pending bool
+		Expired bool
+		Message string
+	}{
+		Quote:   quote,
+		Pending: quote.Status == QuoteSent,
+		Expired: quote.ValidUntil != nil && clock.Now().After(*quote.ValidUntil),
+		Message: r.URL.Query().Get("message"),
+	}
+	if err := dashboardTemplates.ExecuteTemplate(w, "quote.html", data); err != nil {
+		log.Println("Error rendering quote:", err)
+	}
+}
+
+// handleQuoteResponse records the customer's approval or decline from the quote page and shows the page again
+func handleQuoteResponse(w http.ResponseWriter, r *http.Request) {
+	ctx, err := customerLinkContext(r)
+	if err == nil {
+		_, err = RespondToQuote(ctx, r.PathValue("token"), r.FormValue("decision") == "approve")
+	}
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	query := url.Values{}
+	if tenant := r.URL.Query().Get("tenant"); tenant != "" {
+		query.Set("tenant", tenant)
+	}
+	if err != nil && !errors.Is(err, ErrQuoteStatus) {
+		log.Println("Error recording quote response:", err)
+		query.Set("message", "Your answer could not be recorded, please try again later.")
+	} else if err != nil {
+		query.Set("message", "This quote can no longer be answered.")
+	}
+	target := "/quotes/" + url.PathEscape(r.PathValue("token"))
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// handleSurveyPage asks the customer to score the restaurant from 0 to 10 while the survey is open
+func handleSurveyPage(w http.ResponseWriter, r *http.Request) {
+	ctx, err := customerLinkContext(r)
+	var survey Survey
+	if err == nil {
+		survey, err = FindSurveyByToken(ctx, r.PathValue("token"))
+	}
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "the survey could not be loaded, please try again later", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Survey  Survey
+		Open    bool
+		Scores  []int
+		Message string
+	}{
+		Survey:  survey,
+		Open:    survey.AnsweredAt == nil && clock.Now().Before(survey.ExpiresAt),
+		Scores:  []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		Message: r.URL.Query().Get("message"),
+	}
+	if err := dashboardTemplates.ExecuteTemplate(w, "survey.html", data); err != nil {
+		log.Println("Error rendering survey:", err)
+	}
+}
+
+// handleSurveyResponse records the customer's answer. The survey page posts a form and is shown again; a survey
+// service calling back with JSON, {"score": 9, "comment": "..."}, is answered with the survey.
+func handleSurveyResponse(w http.ResponseWriter, r *http.Request) {
+	ctx, err := customerLinkContext(r)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			Score   *int   `json:"score"`
+			Comment string `json:"comment"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Score == nil {
+			writeError(w, http.StatusBadRequest, "a JSON body with a score is required")
+			return
+		}
+		var survey Survey
+		if err == nil {
+			survey, err = AnswerSurvey(ctx, r.PathValue("token"), *body.Score, body.Comment)
+		}
+		switch {
+		case errors.Is(err, ErrNotFound):
+			writeStoreError(w, err)
+		case errors.Is(err, ErrSurveyClosed):
+			writeError(w, http.StatusConflict, err.Error())
+		case err != nil:
+			writeError(w, http.StatusBadRequest, err.Error())
+		default:
+			writeJSON(w, http.StatusOK, survey)
+		}
+		return
+	}
+	if err == nil {
+		var score int
+		if score, err = strconv.Atoi(r.FormValue("score")); err == nil {
+			_, err = AnswerSurvey(ctx, r.PathValue("token"), score, r.FormValue("comment"))
+		}
+	}
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	query := url.Values{}
+	if tenant := r.URL.Query().Get("tenant"); tenant != "" {
+		query.Set("tenant", tenant)
+	}
+	switch {
+	case errors.Is(err, ErrSurveyClosed):
+		query.Set("message", "This survey can no longer be answered.")
+	case err != nil:
+		log.Println("Error recording survey response:", err)
+		query.Set("message", "Your answer could not be recorded, please try again later.")
+	default:
+		query.Set("message", "Thank you for your feedback!")
+	}
+	http.Redirect(w, r, "/surveys/"+url.PathEscape(r.PathValue("token"))+"?"+query.Encode(), http.StatusSeeOther)
+}
+
+// handleBillPage shows the bill a receipt's QR code links to, with a feedback form once it is settled and a form
+// to sign up for offers
+func handleBillPage(w http.ResponseWriter, r *http.Request) {
+	ctx, err := customerLinkContext(r)
+	var bill DigitalBill
+	if err == nil {
+		bill, err = LoadDigitalBill(ctx, r.PathValue("token"))
+	}
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "the bill could not be loaded, please try again later", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Bill         DigitalBill
+		Place        string
+		Subtotal     float64
+		Discount     float64
+		Taxes        float64
+		RoundOff     float64
+		FeedbackOpen bool
+		Scores       []int
+		Message      string
+	}{
+		Bill:         bill,
+		Place:        orderPlace(bill.Order),
+		Subtotal:     CartTotal(bill.Order.Items),
+		Discount:     roundPaise(CartTotal(bill.Order.Items) - linesTotal(bill.Order)),
+		Taxes:        taxOnTop(bill.Order),
+		RoundOff:     roundOff(bill.Order),
+		FeedbackOpen: bill.Order.Paid && (bill.Feedback == nil || bill.Feedback.AnsweredAt == nil && clock.Now().Before(bill.Feedback.ExpiresAt)),
+		Scores:       []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		Message:      r.URL.Query().Get("message"),
+	}
+	if err := dashboardTemplates.ExecuteTemplate(w, "bill.html", data); err != nil {
+		log.Println("Error rendering bill:", err)
+	}
+}
+
+// handleBillResponse takes the bill page's feedback form, action=feedback, or its offers signup, action=join, and
+// shows the bill again
+func handleBillResponse(w http.ResponseWriter, r *http.Request) {
+	ctx, err := customerLinkContext(r)
+	token := r.PathValue("token")
+	var done string
+	if err == nil {
+		switch r.FormValue("action") {
+		case "feedback":
+			var score int
+			if score, err = strconv.Atoi(r.FormValue("score")); err == nil {
+				_, err = LeaveBillFeedback(ctx, token, score, r.FormValue("comment"))
+			}
+			done = "Thank you for your feedback!"
+		case "join":
+			_, err = JoinFromBill(ctx, token, r.FormValue("phone"), r.FormValue("name"))
+			done = "You are signed up for our offers, thank you!"
+		default:
+			err = fmt.Errorf("unknown action %q", r.FormValue("action"))
+		}
+	}
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	query := url.Values{}
+	if tenant := r.URL.Query().Get("tenant"); tenant != "" {
+		query.Set("tenant", tenant)
+	}
+	switch {
+	case errors.Is(err, ErrSurveyClosed):
+		query.Set("message", "Feedback on this bill can no longer be given.")
+	case err != nil:
+		log.Println("Error recording bill response:", err)
+		query.Set("message", "That could not be recorded: "+err.Error())
+	default:
+		query.Set("message", done)
+	}
+	http.Redirect(w, r, "/bills/"+url.PathEscape(token)+"?"+query.Encode(), http.StatusSeeOther)
+}
+
+// Serve runs the HTTP API and dashboard until the server fails
+func Serve(addr string, requireKey bool) error {
+	log.Printf("Serving dashboard on http://%s/admin", addr)
+	handler := NewServer(requireKey)
+	if logLevel == LogDebug {
+		handler = logRequests(handler)
+	}
+	return http.ListenAndServe(addr, handler)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// dashboardRebuild is the longest the dashboard summary is kept up to date from order events alone before it is
+// built again from the database, which picks up orders changed by other servers
+const dashboardRebuild = 5 * time.Minute
+
+// dashboardTopItems is how many of the day's best sellers the summary lists
+const dashboardTopItems = 5
+
+// ItemCount is how many of an item were ordered
+type ItemCount struct {
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+}
+
+// DashboardSummary is today at a glance, for the manager dashboard
+type DashboardSummary struct {
+	Day        string      `json:"day"`
+	Orders     int         `json:"orders"`  // Placed today, other than staff meals
+	Revenue    float64     `json:"revenue"` // Their totals
+	OpenOrders int         `json:"openOrders"`
+	TopItems   []ItemCount `json:"topItems"` // Most ordered today first
+	BuiltAt    time.Time   `json:"builtAt"`  // When it was last read from the database; order events have kept it current since
+}
+
+// dashboardModel is what one store's summary is made from. It is built from the database once and then moved
+// on by every order change, so the dashboard does not add up the day's orders on every request.
+type dashboardModel struct {
+	day     string
+	builtAt time.Time
+	today   map[primitive.ObjectID]Order // Today's orders as last seen, so a change can take out what they added
+	open    map[primitive.ObjectID]bool
+	orders  int
+	revenue float64
+	items   map[string]int
+}
+
+// dashboards holds each store's dashboard model, one per tenant in SaaS mode
+var dashboards = struct {
+	sync.Mutex
+	models map[Store]*dashboardModel
+}{models: map[Store]*dashboardModel{}}
+
+// LoadDashboardSummary returns today's summary from the cached model, building it first if there is none yet,
+// the day has changed or it is older than dashboardRebuild
+func LoadDashboardSummary(ctx context.Context) (DashboardSummary, error) {
+	s := storeFor(ctx)
+	now := clock.Now()
+	dashboards.Lock()
+	defer dashboards.Unlock()
+	model := dashboards.models[s]
+	if model == nil || model.day != now.Format("2006-01-02") || now.Sub(model.builtAt) > dashboardRebuild {
+		var err error
+		if model, err = buildDashboardModel(ctx, now); err != nil {
+			return DashboardSummary{}, err
+		}
+		dashboards.models[s] = model
+	}
+	return model.summary(), nil
+}
+
+// buildDashboardModel reads today's orders and the open ones from the database
+func buildDashboardModel(ctx context.Context, now time.Time) (*dashboardModel, error) {
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	today, err := LoadOrdersBetween(ctx, start, start.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	open, err := storeFor(ctx).Orders().ListOpen(ctx)
+	if err != nil {
+		return nil, err
+	}
+	model := &dashboardModel{
+		day: start.Format("2006-01-02"), builtAt: now,
+		today: map[primitive.ObjectID]Order{}, open: map[primitive.ObjectID]bool{}, items: map[string]int{},
+	}
+	for _, order := range open {
+		model.open[order.ID] = true
+	}
+	for _, order := range today {
+		model.apply(order)
+	}
+	return model, nil
+}
+
+// apply moves the model on to the order's new state
+func (m *dashboardModel) apply(order Order) {
+	if order.Status == StatusServed {
+		delete(m.open, order.ID)
+	} else {
+		m.open[order.ID] = true
+	}
+	if staffMeal(order) || order.CreatedAt.Format("2006-01-02") != m.day {
+		return
+	}
+	if before, seen := m.today[order.ID]; seen {
+		m.add(before, -1)
+	}
+	m.today[order.ID] = order
+	m.add(order, 1)
+}
+
+// add counts the order into the day's totals, or with sign -1 takes it out
+func (m *dashboardModel) add(order Order, sign int) {
+	m.orders += sign
+	m.revenue = roundPaise(m.revenue + float64(sign)*order.Total)
+	for _, line := range order.Items {
+		if m.items[line.Name] += sign * line.Quantity; m.items[line.Name] <= 0 {
+			delete(m.items, line.Name)
+		}
+	}
+}
+
+func (m *dashboardModel) summary() DashboardSummary {
+	summary := DashboardSummary{
+		Day: m.day, Orders: m.orders, Revenue: m.revenue, OpenOrders: len(m.open), TopItems: []ItemCount{}, BuiltAt: m.builtAt,
+	}
+	for name, quantity := range m.items {
+		summary.TopItems = append(summary.TopItems, ItemCount{Name: name, Quantity: quantity})
+	}
+	slices.SortFunc(summary.TopItems, func(a, b ItemCount) int {
+		if a.Quantity != b.Quantity {
+			return b.Quantity - a.Quantity
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	if len(summary.TopItems) > dashboardTopItems {
+		summary.TopItems = summary.TopItems[:dashboardTopItems]
+	}
+	return summary
+}
+
+// observeOrder moves the store's dashboard model on to an order just stored, if the dashboard has been asked for
+func observeOrder(ctx context.Context, order Order) {
+	dashboards.Lock()
+	defer dashboards.Unlock()
+	if model := dashboards.models[storeFor(ctx)]; model != nil {
+		model.apply(order)
+	}
+}
+
+// forgetDashboard drops the store's dashboard model, to be built again when it is next asked for
+func forgetDashboard(ctx context.Context) {
+	dashboards.Lock()
+	defer dashboards.Unlock()
+	delete(dashboards.models, storeFor(ctx))
+}
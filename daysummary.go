@@ -0,0 +1,311 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ChannelSales is what was sold through one channel, an order type such as dine-in or delivery
+type ChannelSales struct {
+	Channel string  `json:"channel"`
+	Orders  int     `json:"orders"`
+	Revenue float64 `json:"revenue"`
+}
+
+// DaySummary is what the end-of-day summary tells the managers and owners about a business date
+type DaySummary struct {
+	Date          string         `json:"date"`
+	Orders        int            `json:"orders"` // Placed that day, other than staff meals
+	Revenue       float64        `json:"revenue"`
+	ByChannel     []ChannelSales `json:"byChannel"`
+	ByMethod      []MethodTotal  `json:"byMethod"` // Payments taken that day, for any day's orders
+	TopItems      []ItemCount    `json:"topItems"` // Most ordered first
+	Discounts     int            `json:"discounts"`
+	DiscountValue float64        `json:"discountValue"`
+	Voids         int            `json:"voids"` // Lines voided after they went to the kitchen
+	VoidValue     float64        `json:"voidValue"`
+	LaborHours    float64        `json:"laborHours"` // Clocked by shifts that started that day
+	LaborCost     float64        `json:"laborCost"`
+	Complaints    []Complaint    `json:"complaints"`       // Raised that day
+	Budget        *BudgetReport  `json:"budget,omitempty"` // The month to the end of that day, when it has targets
+	Log           []LogEntry     `json:"log"`
+	OpenFollowUps []LogEntry     `json:"openFollowUps"` // From this and earlier dates
+}
+
+// daySummaryTo is the email address the end-of-day summary is sent to, from RMS_DAY_SUMMARY_TO
+var daySummaryTo string
+
+// ownerSummaryTo are the owners' email addresses the end-of-day summary is also sent to, from RMS_OWNER_SUMMARY_TO
+var ownerSummaryTo []string
+
+// daySummaryAt is the time of day, HH:MM, after which the day's summary is sent, from RMS_DAY_SUMMARY_AT
+var daySummaryAt = "23:30"
+
+// daySummaryInterval is how often the background loop checks whether the day's summary is due
+const daySummaryInterval = 5 * time.Minute
+
+// SetDaySummary sets where and when the end-of-day summary is sent: to the managers' address and the owners', a
+// comma-separated list. With neither none is sent, and an empty time keeps 23:30.
+func SetDaySummary(to, owners, at string) error {
+	daySummaryTo, ownerSummaryTo, daySummaryAt = strings.TrimSpace(to), nil, "23:30"
+	for _, owner := range strings.Split(owners, ",") {
+		if owner = strings.TrimSpace(owner); owner != "" {
+			ownerSummaryTo = append(ownerSummaryTo, owner)
+		}
+	}
+	if at = strings.TrimSpace(at); at != "" {
+		if _, err := time.Parse("15:04", at); err != nil {
+			return fmt.Errorf("invalid day summary time %q (want HH:MM)", at)
+		}
+		daySummaryAt = at
+	}
+	return nil
+}
+
+// daySummaryRecipients lists everyone the end-of-day summary goes to, the managers first
+func daySummaryRecipients() []string {
+	recipients := slices.Clone(ownerSummaryTo)
+	if daySummaryTo != "" {
+		recipients = append([]string{daySummaryTo}, recipients...)
+	}
+	return recipients
+}
+
+// BuildDaySummary puts together the business date day from the reports: sales by channel and payment method, the
+// best sellers, discounts and voids, the hours worked, complaints, and the log book
+func BuildDaySummary(ctx context.Context, day time.Time) (DaySummary, error) {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	end := start.AddDate(0, 0, 1)
+	summary := DaySummary{Date: start.Format(time.DateOnly), ByChannel: []ChannelSales{}, ByMethod: []MethodTotal{}, TopItems: []ItemCount{}}
+
+	orders, err := LoadOrdersBetween(ctx, start, end)
+	if err != nil {
+		return DaySummary{}, err
+	}
+	channels := map[string]*ChannelSales{}
+	items := map[string]int{}
+	for _, order := range slices.DeleteFunc(orders, staffMeal) {
+		summary.Orders++
+		summary.Revenue = roundPaise(summary.Revenue + order.Total)
+		channel, ok := channels[order.Type]
+		if !ok {
+			channel = &ChannelSales{Channel: order.Type}
+			channels[order.Type] = channel
+		}
+		channel.Orders++
+		channel.Revenue = roundPaise(channel.Revenue + order.Total)
+		for _, line := range order.Items {
+			items[line.Name] += line.Quantity
+		}
+	}
+	for _, channel := range channels {
+		summary.ByChannel = append(summary.ByChannel, *channel)
+	}
+	slices.SortFunc(summary.ByChannel, func(a, b ChannelSales) int {
+		if a.Revenue != b.Revenue {
+			return cmp.Compare(b.Revenue, a.Revenue)
+		}
+		return strings.Compare(a.Channel, b.Channel)
+	})
+	for name, quantity := range items {
+		summary.TopItems = append(summary.TopItems, ItemCount{Name: name, Quantity: quantity})
+	}
+	slices.SortFunc(summary.TopItems, func(a, b ItemCount) int {
+		if a.Quantity != b.Quantity {
+			return b.Quantity - a.Quantity
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	if len(summary.TopItems) > dashboardTopItems {
+		summary.TopItems = summary.TopItems[:dashboardTopItems]
+	}
+
+	payments, err := storeFor(ctx).Payments().ListBetween(ctx, start, end)
+	if err != nil {
+		return DaySummary{}, err
+	}
+	methods := map[string]*MethodTotal{}
+	for _, payment := range payments {
+		total, ok := methods[payment.Method]
+		if !ok {
+			total = &MethodTotal{Method: payment.Method}
+			methods[payment.Method] = total
+		}
+		total.Count++
+		total.Amount = roundPaise(total.Amount + payment.Amount)
+	}
+	for _, method := range append(slices.Clone(paymentMethods), PaymentOnAccount) {
+		if total, ok := methods[method]; ok {
+			summary.ByMethod = append(summary.ByMethod, *total)
+		}
+	}
+
+	discounts, err := BuildDiscountReport(ctx, start, end)
+	if err != nil {
+		return DaySummary{}, err
+	}
+	summary.Discounts, summary.DiscountValue = len(discounts.Entries), discounts.Total
+	voids, err := BuildVoidReport(ctx, start, end)
+	if err != nil {
+		return DaySummary{}, err
+	}
+	summary.Voids, summary.VoidValue = len(voids.Voids), voids.VoidValue
+	payroll, err := PayrollReport(ctx, start, end, 0)
+	if err != nil {
+		return DaySummary{}, err
+	}
+	for _, line := range payroll {
+		summary.LaborHours = roundPaise(summary.LaborHours + line.RegularHours + line.OvertimeHours)
+		summary.LaborCost = roundPaise(summary.LaborCost + line.Total)
+	}
+	if summary.Complaints, err = ListComplaints(ctx, start, end, false); err != nil {
+		return DaySummary{}, err
+	}
+	budget, err := storeFor(ctx).Budgets().Find(ctx, start.Format("2006-01"))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return DaySummary{}, err
+	}
+	if err == nil {
+		asOf := end
+		if now := clock.Now(); now.Before(end) {
+			asOf = now
+		}
+		report, err := budgetReport(ctx, budget, asOf)
+		if err != nil {
+			return DaySummary{}, err
+		}
+		summary.Budget = &report
+	}
+	if summary.Log, err = ListLogBook(ctx, start, end); err != nil {
+		return DaySummary{}, err
+	}
+	if summary.OpenFollowUps, err = OpenFollowUps(ctx); err != nil {
+		return DaySummary{}, err
+	}
+	return summary, nil
+}
+
+// daySummaryMessage writes the summary out as the body of the email
+func daySummaryMessage(summary DaySummary) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d orders, Rs %s taken.\n", summary.Orders, formatAmount(summary.Revenue))
+	if len(summary.ByChannel) > 0 {
+		b.WriteString("\nBy channel:\n")
+		for _, channel := range summary.ByChannel {
+			fmt.Fprintf(&b, "%s: %d orders, Rs %s\n", channel.Channel, channel.Orders, formatAmount(channel.Revenue))
+		}
+	}
+	if len(summary.ByMethod) > 0 {
+		b.WriteString("\nPayments:\n")
+		for _, method := range summary.ByMethod {
+			fmt.Fprintf(&b, "%s: %d, Rs %s\n", method.Method, method.Count, formatAmount(method.Amount))
+		}
+	}
+	if len(summary.TopItems) > 0 {
+		b.WriteString("\nTop items:\n")
+		for _, item := range summary.TopItems {
+			fmt.Fprintf(&b, "%d x %s\n", item.Quantity, item.Name)
+		}
+	}
+	fmt.Fprintf(&b, "\n%d discounts worth Rs %s, %d voids worth Rs %s.\n", summary.Discounts, formatAmount(summary.DiscountValue),
+		summary.Voids, formatAmount(summary.VoidValue))
+	fmt.Fprintf(&b, "%.2f hours worked, Rs %s in wages.\n", summary.LaborHours, formatAmount(summary.LaborCost))
+	if budget := summary.Budget; budget != nil {
+		b.WriteString("\nMonth to date:\n")
+		for _, row := range []struct {
+			name string
+			line BudgetLine
+		}{{"Revenue", budget.Revenue}, {"Food cost", budget.FoodCost}} {
+			if row.line.Target != 0 {
+				fmt.Fprintf(&b, "%s Rs %s of Rs %s target, %s\n", row.name, formatAmount(row.line.Actual), formatAmount(row.line.Target), row.line.Pace)
+			}
+		}
+	}
+	if len(summary.Complaints) > 0 {
+		b.WriteString("\nComplaints:\n")
+		for _, complaint := range summary.Complaints {
+			about := complaint.Cause
+			if complaint.Item != "" {
+				about += ", " + complaint.Item
+			}
+			fmt.Fprintf(&b, "#%d %s (%s, %s): %s\n", complaint.Number, complaint.CustomerName, about, complaint.Status, complaint.Description)
+		}
+	}
+	b.WriteString("\nLog book:\n")
+	if len(summary.Log) == 0 {
+		b.WriteString("Nothing logged.\n")
+	}
+	for _, entry := range summary.Log {
+		fmt.Fprintf(&b, "%s\n", logEntryLine(entry))
+	}
+	if len(summary.OpenFollowUps) > 0 {
+		b.WriteString("\nOpen follow-ups:\n")
+		for _, entry := range summary.OpenFollowUps {
+			fmt.Fprintf(&b, "%s %s\n", entry.Date, logEntryLine(entry))
+		}
+	}
+	return b.String()
+}
+
+// SendDaySummary emails the summary of the business date day to RMS_DAY_SUMMARY_TO and RMS_OWNER_SUMMARY_TO
+func SendDaySummary(ctx context.Context, day time.Time) (DaySummary, error) {
+	recipients := daySummaryRecipients()
+	if len(recipients) == 0 {
+		return DaySummary{}, fmt.Errorf("no address to send the summary to; set RMS_DAY_SUMMARY_TO or RMS_OWNER_SUMMARY_TO")
+	}
+	summary, err := BuildDaySummary(ctx, day)
+	if err != nil {
+		return DaySummary{}, err
+	}
+	message := daySummaryMessage(summary)
+	for _, to := range recipients {
+		name := "Owners"
+		if to == daySummaryTo {
+			name = "Managers"
+		}
+		Notify(ctx, Notification{
+			To: to, Name: name, Channel: ChannelEmail, Subject: "End of day summary for " + day.Format("Mon 02 Jan 2006"),
+			Message: message,
+		})
+	}
+	return summary, nil
+}
+
+// sendDueDaySummary sends today's summary once it is past RMS_DAY_SUMMARY_AT, reporting whether it did. A counter
+// for the date makes sure only one terminal sends it, once.
+func sendDueDaySummary(ctx context.Context, now time.Time) (bool, error) {
+	if len(daySummaryRecipients()) == 0 || now.Format("15:04") < daySummaryAt {
+		return false, nil
+	}
+	sent, err := storeFor(ctx).Counters().Next(ctx, "day-summary:"+now.Format(time.DateOnly))
+	if err != nil || sent > 1 {
+		return false, err
+	}
+	_, err = SendDaySummary(ctx, now)
+	return err == nil, err
+}
+
+// StartDaySummaries sends the end-of-day summary in the background
+func StartDaySummaries() {
+	go func() {
+		for {
+			err := forEachTenant(context.TODO(), func(ctx context.Context) error {
+				if IsOffline() {
+					return nil
+				}
+				_, err := sendDueDaySummary(ctx, clock.Now())
+				return err
+			})
+			if err != nil {
+				log.Println("Day summaries:", err)
+			}
+			time.Sleep(daySummaryInterval)
+		}
+	}()
+}
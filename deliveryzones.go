@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ChargeDeliveryFee names the fee a delivery order's zone adds to the bill
+const ChargeDeliveryFee = "Delivery fee"
+
+// earthRadiusKm is used to work out how far an address is from a zone's center
+const earthRadiusKm = 6371.0
+
+// ErrDeliveryAddress is returned for a delivery order that cannot be taken to its address
+var ErrDeliveryAddress = errors.New("cannot deliver the order")
+
+// Location is a point on the map, in degrees
+type Location struct {
+	Lat float64 `bson:"lat" json:"lat"`
+	Lng float64 `bson:"lng" json:"lng"`
+}
+
+// Validate checks that the location is on the map
+func (l Location) Validate() error {
+	if l.Lat < -90 || l.Lat > 90 || l.Lng < -180 || l.Lng > 180 {
+		return fmt.Errorf("location %.6f,%.6f is not on the map", l.Lat, l.Lng)
+	}
+	return nil
+}
+
+// DistanceKm is how far it is from l to other as the crow flies
+func (l Location) DistanceKm(other Location) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLng := rad(other.Lat-l.Lat), rad(other.Lng-l.Lng)
+	h := math.Pow(math.Sin(dLat/2), 2) + math.Cos(rad(l.Lat))*math.Cos(rad(other.Lat))*math.Pow(math.Sin(dLng/2), 2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+// Address is where a delivery order is taken
+type Address struct {
+	Line     string    `bson:"line" json:"line"`
+	PinCode  string    `bson:"pinCode,omitempty" json:"pinCode,omitempty"`
+	Location *Location `bson:"location,omitempty" json:"location,omitempty"` // Needed for zones drawn as a radius
+}
+
+// String is the address on one line, as printed on tickets
+func (a Address) String() string {
+	if a.PinCode == "" {
+		return a.Line
+	}
+	return a.Line + " - " + a.PinCode
+}
+
+// DeliveryZone is an area the restaurant delivers to, either a list of pin codes or a radius around a point, with
+// the fee it adds to the bill and the smallest order it takes
+type DeliveryZone struct {
+	Name         string    `bson:"name" json:"name"`
+	PinCodes     []string  `bson:"pinCodes,omitempty" json:"pinCodes,omitempty"`
+	Center       *Location `bson:"center,omitempty" json:"center,omitempty"`
+	RadiusKm     float64   `bson:"radiusKm,omitempty" json:"radiusKm,omitempty"`
+	Fee          float64   `bson:"fee" json:"fee"`
+	MinimumOrder float64   `bson:"minimumOrder,omitempty" json:"minimumOrder,omitempty"` // Of the food, before charges; 0 for any
+	UpdatedAt    time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// covers reports whether the zone takes in the address
+func (z DeliveryZone) covers(address Address) bool {
+	if len(z.PinCodes) > 0 {
+		return slices.Contains(z.PinCodes, address.PinCode)
+	}
+	return address.Location != nil && z.Center.DistanceKm(*address.Location) <= z.RadiusKm
+}
+
+// SaveDeliveryZone adds the zone or replaces the one with its name
+func SaveDeliveryZone(ctx context.Context, zone DeliveryZone) (DeliveryZone, error) {
+	zone.Name = strings.TrimSpace(zone.Name)
+	if zone.Name == "" {
+		return DeliveryZone{}, fmt.Errorf("a delivery zone needs a name")
+	}
+	var pinCodes []string
+	for _, pinCode := range zone.PinCodes {
+		pinCode = strings.ReplaceAll(pinCode, " ", "")
+		if len(pinCode) != 6 || strings.Trim(pinCode, "0123456789") != "" {
+			return DeliveryZone{}, fmt.Errorf("invalid pin code %q (want 6 digits)", pinCode)
+		}
+		if !slices.Contains(pinCodes, pinCode) {
+			pinCodes = append(pinCodes, pinCode)
+		}
+	}
+	zone.PinCodes = pinCodes
+	switch {
+	case len(zone.PinCodes) > 0 && zone.Center != nil:
+		return DeliveryZone{}, fmt.Errorf("a delivery zone is either pin codes or a radius, not both")
+	case len(zone.PinCodes) == 0 && zone.Center == nil:
+		return DeliveryZone{}, fmt.Errorf("a delivery zone needs pin codes or a center and radius")
+	case zone.Center != nil:
+		if err := zone.Center.Validate(); err != nil {
+			return DeliveryZone{}, err
+		}
+		if zone.RadiusKm <= 0 {
+			return DeliveryZone{}, fmt.Errorf("radius must be more than 0 km")
+		}
+	default:
+		zone.RadiusKm = 0
+	}
+	if zone.Fee < 0 || zone.MinimumOrder < 0 {
+		return DeliveryZone{}, fmt.Errorf("fee and minimum order must not be negative")
+	}
+	zone.UpdatedAt = clock.Now()
+	if err := storeFor(ctx).DeliveryZones().Save(ctx, zone); err != nil {
+		return DeliveryZone{}, err
+	}
+	return zone, nil
+}
+
+// ListDeliveryZones returns the restaurant's delivery zones by name
+func ListDeliveryZones(ctx context.Context) ([]DeliveryZone, error) {
+	zones, err := storeFor(ctx).DeliveryZones().List(ctx)
+	if zones == nil {
+		zones = []DeliveryZone{}
+	}
+	return zones, err
+}
+
+// DeleteDeliveryZone stops delivering to the zone
+func DeleteDeliveryZone(ctx context.Context, name string) error {
+	return storeFor(ctx).DeliveryZones().Delete(ctx, name)
+}
+
+// zoneFor returns the zone an address falls in. A pin code names an area exactly, so pin code zones are tried
+// first; of the radius zones the smallest that reaches the address wins.
+func zoneFor(zones []DeliveryZone, address Address) (DeliveryZone, bool) {
+	var found *DeliveryZone
+	for i, zone := range zones {
+		if !zone.covers(address) {
+			continue
+		}
+		if len(zone.PinCodes) > 0 {
+			return zone, true
+		}
+		if found == nil || zone.RadiusKm < found.RadiusKm {
+			found = &zones[i]
+		}
+	}
+	if found == nil {
+		return DeliveryZone{}, false
+	}
+	return *found, true
+}
+
+// deliveryFee checks that a delivery order can be taken to its address and returns its zone's fee as a charge, or
+// nil when it has none. Restaurants without zones deliver anywhere without a fee.
+func deliveryFee(zones []DeliveryZone, order Order) ([]OrderCharge, error) {
+	if order.Type != OrderDelivery || len(zones) == 0 {
+		return nil, nil
+	}
+	if order.DeliveryAddress == nil {
+		return nil, fmt.Errorf("%w: a delivery order needs an address", ErrDeliveryAddress)
+	}
+	zone, found := zoneFor(zones, *order.DeliveryAddress)
+	if !found {
+		return nil, fmt.Errorf("%w: %s is outside the delivery area", ErrDeliveryAddress, order.DeliveryAddress)
+	}
+	if food := linesTotal(order); food < zone.MinimumOrder {
+		return nil, fmt.Errorf("%w: orders to %s must come to at least Rs %.2f, this one is Rs %.2f", ErrDeliveryAddress,
+			zone.Name, zone.MinimumOrder, food)
+	}
+	if zone.Fee == 0 {
+		return nil, nil
+	}
+	return []OrderCharge{{Name: ChargeDeliveryFee, Amount: zone.Fee}}, nil
+}
+
+// chargeDelivery places a new delivery order's address on the map and checks it against the restaurant's zones,
+// adding the zone's fee to its charges. Offline the zones cannot be looked up, so the order goes without one.
+func chargeDelivery(ctx context.Context, order *Order) error {
+	if order.Type != OrderDelivery || IsOffline() {
+		return nil
+	}
+	if order.DeliveryAddress != nil {
+		address, err := cleanAddress(ctx, *order.DeliveryAddress)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrDeliveryAddress, err)
+		}
+		order.DeliveryAddress = &address
+	}
+	zones, err := storeFor(ctx).DeliveryZones().List(ctx)
+	if err != nil {
+		if storeFor(ctx).Unavailable(err) {
+			return nil
+		}
+		return err
+	}
+	fee, err := deliveryFee(zones, *order)
+	if err != nil {
+		return err
+	}
+	order.Charges = append(order.Charges, fee...)
+	return nil
+}
+
+// ShowDeliveryZones prints the delivery zones
+func ShowDeliveryZones(ctx context.Context) error {
+	zones, err := ListDeliveryZones(ctx)
+	if err != nil {
+		return err
+	}
+	zoneListing := listing{
+		title:   "Delivery zones:",
+		header:  []string{"Zone", "Area", "Fee", "Minimum order"},
+		records: zones,
+	}
+	for _, zone := range zones {
+		area := strings.Join(zone.PinCodes, " ")
+		if zone.Center != nil {
+			area = fmt.Sprintf("%g km of %.5f,%.5f", zone.RadiusKm, zone.Center.Lat, zone.Center.Lng)
+		}
+		zoneListing.rows = append(zoneListing.rows, []string{
+			zone.Name, area, fmt.Sprintf("%.2f", zone.Fee), fmt.Sprintf("%.2f", zone.MinimumOrder),
+		})
+		zoneListing.compact = append(zoneListing.compact, fmt.Sprintf("%s (%s) Rs %.2f, min Rs %.2f", zone.Name, area, zone.Fee,
+			zone.MinimumOrder))
+	}
+	return printListing(os.Stdout, zoneListing)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StaffRatio is how many covers an hour one member of staff in a role can look after
+type StaffRatio struct {
+	Role   string  `json:"role"`
+	Covers float64 `json:"covers"`
+}
+
+// staffRatios are the ratios staffing is suggested from, in the order roles are shown
+var staffRatios = defaultStaffRatios
+
+var defaultStaffRatios = []StaffRatio{{Role: "servers", Covers: 16}, {Role: "cooks", Covers: 25}}
+
+// SetStaffRatios sets the covers per staff member of each role from a spec like "servers=16,cooks=25,bussers=40".
+// An empty spec keeps the default servers and cooks.
+func SetStaffRatios(spec string) error {
+	if strings.TrimSpace(spec) == "" {
+		staffRatios = defaultStaffRatios
+		return nil
+	}
+	var ratios []StaffRatio
+	for _, entry := range strings.Split(spec, ",") {
+		role, amount, ok := strings.Cut(entry, "=")
+		covers, err := strconv.ParseFloat(strings.TrimSpace(amount), 64)
+		role = strings.TrimSpace(role)
+		if !ok || err != nil || covers <= 0 || role == "" {
+			return fmt.Errorf("invalid staff ratio %q (want role=covers per staff member)", entry)
+		}
+		ratios = append(ratios, StaffRatio{Role: role, Covers: covers})
+	}
+	staffRatios = ratios
+	return nil
+}
+
+// DemandCell is the average demand in one hour of one day of the week
+type DemandCell struct {
+	Weekday string         `json:"weekday"`
+	Hour    int            `json:"hour"`
+	Orders  float64        `json:"orders"` // Average orders placed in the hour on that day of the week
+	Covers  float64        `json:"covers"` // Average guests served, counting each takeaway order as one
+	Staff   map[string]int `json:"staff"`  // Suggested staff in each role
+}
+
+// DemandHeatmap is the average demand by day of the week and hour over [From, To), with staffing suggested for it.
+// Hours with no orders on any day are left out.
+type DemandHeatmap struct {
+	From   time.Time    `json:"from"`
+	To     time.Time    `json:"to"`
+	Ratios []StaffRatio `json:"ratios"`
+	Cells  []DemandCell `json:"cells"`
+	Peak   *DemandCell  `json:"peak,omitempty"` // The busiest hour of the week
+}
+
+// weekdays run Monday first, as a restaurant's week usually does
+var weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+
+// DemandReport averages the orders placed in [from, to) by day of the week and hour, and suggests how many staff
+// each hour needs from the staff ratios
+func DemandReport(ctx context.Context, from, to time.Time) (DemandHeatmap, error) {
+	orders, err := LoadOrdersBetween(ctx, from, to)
+	if err != nil {
+		return DemandHeatmap{}, err
+	}
+	var orderCount, covers [7][24]float64
+	for _, order := range orders {
+		day, hour := order.CreatedAt.Weekday(), order.CreatedAt.Hour()
+		orderCount[day][hour]++
+		covers[day][hour] += float64(orderCovers(order))
+	}
+	// Each day of the week is averaged over the number of times it occurs in the period
+	var occurrences [7]float64
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		occurrences[day.Weekday()]++
+	}
+
+	heatmap := DemandHeatmap{From: from, To: to, Ratios: staffRatios, Cells: []DemandCell{}}
+	for _, day := range weekdays {
+		for hour := range 24 {
+			if orderCount[day][hour] == 0 {
+				continue
+			}
+			cell := DemandCell{
+				Weekday: day.String(), Hour: hour,
+				Orders: roundPaise(orderCount[day][hour] / occurrences[day]),
+				Covers: roundPaise(covers[day][hour] / occurrences[day]),
+			}
+			cell.Staff = suggestStaff(cell.Covers)
+			heatmap.Cells = append(heatmap.Cells, cell)
+			if heatmap.Peak == nil || cell.Covers > heatmap.Peak.Covers {
+				peak := cell
+				heatmap.Peak = &peak
+			}
+		}
+	}
+	return heatmap, nil
+}
+
+// suggestStaff returns how many staff of each role the covers need: at least one of each while guests are in
+func suggestStaff(covers float64) map[string]int {
+	staff := map[string]int{}
+	for _, ratio := range staffRatios {
+		staff[ratio.Role] = max(int(math.Ceil(covers/ratio.Covers)), 1)
+	}
+	return staff
+}
+
+// ShowDemand prints the average covers and the suggested staff for each hour and day of the week in [from, to)
+func ShowDemand(ctx context.Context, from, to time.Time) error {
+	heatmap, err := DemandReport(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		printResult("", heatmap)
+		return nil
+	}
+	cells := map[string]DemandCell{}
+	var busy [24]bool
+	for _, cell := range heatmap.Cells {
+		cells[fmt.Sprint(cell.Weekday, cell.Hour)] = cell
+		busy[cell.Hour] = true
+	}
+
+	header := []string{"Hour"}
+	for _, day := range weekdays {
+		header = append(header, day.String()[:3])
+	}
+	var roles []string
+	for _, ratio := range heatmap.Ratios {
+		roles = append(roles, ratio.Role)
+	}
+	var coverRows, staffRows [][]string
+	for hour := range 24 {
+		if !busy[hour] {
+			continue
+		}
+		coverRow, staffRow := []string{fmt.Sprintf("%02d:00", hour)}, []string{fmt.Sprintf("%02d:00", hour)}
+		for _, day := range weekdays {
+			cell, ok := cells[fmt.Sprint(day.String(), hour)]
+			if !ok {
+				coverRow, staffRow = append(coverRow, ""), append(staffRow, "")
+				continue
+			}
+			var counts []string
+			for _, role := range roles {
+				counts = append(counts, fmt.Sprint(cell.Staff[role]))
+			}
+			coverRow = append(coverRow, fmt.Sprintf("%.1f", cell.Covers))
+			staffRow = append(staffRow, strings.Join(counts, "/"))
+		}
+		coverRows, staffRows = append(coverRows, coverRow), append(staffRows, staffRow)
+	}
+
+	fmt.Printf("Average covers from %s to %s:\n", from.Format("02 Jan 2006"), to.AddDate(0, 0, -1).Format("02 Jan 2006"))
+	if err := writeTable(os.Stdout, header, coverRows); err != nil {
+		return err
+	}
+	if heatmap.Peak != nil {
+		fmt.Printf("Busiest: %s %02d:00 with %.1f covers\n", heatmap.Peak.Weekday, heatmap.Peak.Hour, heatmap.Peak.Covers)
+	}
+	fmt.Printf("Suggested staff (%s):\n", strings.Join(roles, "/"))
+	return writeTable(os.Stdout, header, staffRows)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DemoOptions size the synthetic restaurant the demo command generates
+type DemoOptions struct {
+	Customers int
+	Orders    int
+	Months    int    // How far back the order history goes
+	Seed      uint64 // The same seed generates the same customers and orders
+}
+
+// DemoSummary counts what GenerateDemoData added
+type DemoSummary struct {
+	Customers int       `json:"customers"`
+	Orders    int       `json:"orders"`
+	Payments  int       `json:"payments"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+}
+
+// Names demo customers and waiters are made from
+var (
+	demoFirstNames = []string{
+		"Aarav", "Aditi", "Akash", "Ananya", "Arjun", "Divya", "Farhan", "Gauri", "Harish", "Isha",
+		"Jaya", "Karan", "Kavya", "Lakshmi", "Manish", "Meera", "Naveen", "Neha", "Pooja", "Pranav",
+		"Rahul", "Riya", "Rohan", "Sanjana", "Shreya", "Siddharth", "Sneha", "Suresh", "Tanvi", "Varun",
+		"Vikram", "Zoya", "Deepak", "Anjali", "Imran", "Nisha", "Omkar", "Priya", "Rekha", "Tarun",
+	}
+	demoLastNames = []string{
+		"Agarwal", "Bhat", "Chopra", "Das", "Desai", "Fernandes", "Gupta", "Iyer", "Joshi", "Kapoor",
+		"Khan", "Kulkarni", "Menon", "Mishra", "Nair", "Patel", "Pillai", "Rao", "Reddy", "Shah",
+		"Sharma", "Singh", "Srinivasan", "Thomas", "Verma",
+	}
+	demoWaiters = []string{"Asha", "Ravi", "Sunil", "Fatima", "Joseph"}
+)
+
+// demoPaymentMethods are weighted towards how customers tend to pay
+var demoPaymentMethods = []string{PaymentUPI, PaymentUPI, PaymentUPI, PaymentCard, PaymentCard, PaymentCash}
+
+// demoHours weights the hour an order is placed towards lunch and dinner
+var demoHours = []int{8, 9, 10, 11, 12, 12, 13, 13, 13, 14, 14, 15, 16, 17, 18, 19, 19, 20, 20, 20, 21, 21, 22}
+
+// GenerateDemoData fills the database with made-up customers and months of served and paid orders,
+// so reports, pagination and performance can be tried without a real restaurant. Progress is called
+// after every hundred orders.
+func GenerateDemoData(ctx context.Context, opts DemoOptions, progress func(orders int)) (DemoSummary, error) {
+	if opts.Customers < 1 || opts.Orders < 1 || opts.Months < 1 {
+		return DemoSummary{}, fmt.Errorf("customers, orders and months must be at least 1")
+	}
+	if opts.Customers > len(demoFirstNames)*len(demoLastNames) {
+		return DemoSummary{}, fmt.Errorf("at most %d customers can be generated", len(demoFirstNames)*len(demoLastNames))
+	}
+	random := rand.New(rand.NewPCG(opts.Seed, opts.Seed))
+
+	AddMenuItems(ctx)
+	AddTables(ctx)
+	menu := LoadMenu(ctx)
+	tables, err := LoadTables(ctx)
+	if err != nil {
+		return DemoSummary{}, err
+	}
+
+	customers, err := generateDemoCustomers(ctx, random, opts.Customers)
+	if err != nil {
+		return DemoSummary{}, err
+	}
+
+	now := time.Now()
+	summary := DemoSummary{Customers: len(customers), To: now.AddDate(0, 0, -1)}
+	summary.From = summary.To.AddDate(0, -opts.Months, 0)
+	times := make([]time.Time, opts.Orders)
+	days := int(summary.To.Sub(summary.From).Hours()/24) + 1
+	for i := range times {
+		day := summary.From.AddDate(0, 0, random.IntN(days))
+		hour := demoHours[random.IntN(len(demoHours))]
+		times[i] = time.Date(day.Year(), day.Month(), day.Day(), hour, random.IntN(60), random.IntN(60), 0, time.Local)
+	}
+	slices.SortFunc(times, func(a, b time.Time) int { return a.Compare(b) })
+
+	ordered := make(map[string][]OrderLine)
+	for i, at := range times {
+		// Squaring favours the first customers, who become the regulars
+		customer := customers[int(float64(len(customers))*random.Float64()*random.Float64())]
+		order := demoOrder(random, menu, tables, customer, at)
+		if order, err = RecordOrder(ctx, order); err != nil {
+			return summary, err
+		}
+		if err := serveDemoOrder(ctx, random, order, tables); err != nil {
+			return summary, err
+		}
+		ordered[customer] = append(ordered[customer], order.Items...)
+		summary.Orders++
+		summary.Payments++
+		if progress != nil && (i+1)%100 == 0 {
+			progress(i + 1)
+		}
+	}
+
+	for _, customer := range customers {
+		if lines := ordered[customer]; len(lines) > 0 {
+			if err := addCustomerItems(ctx, customer, lines); err != nil {
+				return summary, err
+			}
+		}
+	}
+	return summary, nil
+}
+
+// generateDemoCustomers adds customers with made-up names and phone numbers, returning their names
+func generateDemoCustomers(ctx context.Context, random *rand.Rand, count int) ([]string, error) {
+	seen := make(map[string]bool, count)
+	names := make([]string, 0, count)
+	for len(names) < count {
+		name := demoFirstNames[random.IntN(len(demoFirstNames))] + " " + demoLastNames[random.IntN(len(demoLastNames))]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		phone := fmt.Sprintf("9%09d", random.IntN(1_000_000_000))
+		// A customer left by an earlier run is reused, so the history just grows
+		_, err := storeFor(ctx).Customers().FindByName(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			err = storeFor(ctx).Customers().Add(ctx, Customer{Name: name, Phone: phone, OrderedItems: []string{}})
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// demoOrder makes up an order of one to four dishes, eaten in or taken away
+func demoOrder(random *rand.Rand, menu []MenuItem, tables []Table, customer string, at time.Time) Order {
+	order := Order{
+		ID: primitive.NewObjectIDFromTimestamp(at), CustomerName: customer, Type: OrderDineIn,
+		Waiter: demoWaiters[random.IntN(len(demoWaiters))], CreatedAt: at,
+	}
+	if random.IntN(10) < 3 || len(tables) == 0 {
+		order.Type, order.Waiter = OrderTakeaway, ""
+	} else {
+		table := tables[random.IntN(len(tables))]
+		order.Table, order.Covers = table.Number, 1+random.IntN(table.Seats)
+	}
+	for _, i := range random.Perm(len(menu))[:1+random.IntN(min(4, len(menu)))] {
+		quantity := 1
+		if random.IntN(4) == 0 {
+			quantity += 1 + random.IntN(2)
+		}
+		order.Items = AddToCart(order.Items, menu[i], quantity)
+	}
+	return order
+}
+
+// serveDemoOrder takes the order through the kitchen and has it paid in full, at realistic times
+// after it was placed, with the party's time at the table recorded for dine-in orders
+func serveDemoOrder(ctx context.Context, random *rand.Rand, order Order, tables []Table) error {
+	at := order.CreatedAt
+	for _, status := range []string{StatusPreparing, StatusReady, StatusServed} {
+		at = at.Add(time.Duration(3+random.IntN(15)) * time.Minute)
+		_, err := changeOrder(ctx, order.ID, func(Order) (OrderEvent, error) {
+			return OrderEvent{Type: EventStatusChanged, Status: status, At: at}, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	at = at.Add(time.Duration(10+random.IntN(40)) * time.Minute)
+	err := insertPayment(ctx, Payment{
+		ID: primitive.NewObjectIDFromTimestamp(at), OrderID: order.ID, Method: demoPaymentMethods[random.IntN(len(demoPaymentMethods))],
+		Amount: order.Total, Cashier: order.Waiter, CreatedAt: at,
+	})
+	if err != nil || order.Table == 0 {
+		return err
+	}
+
+	// The party sat down a little before ordering and left a little after paying
+	seatedAt := order.CreatedAt.Add(-time.Duration(2+random.IntN(8)) * time.Minute)
+	sitting := TableSitting{ID: primitive.NewObjectIDFromTimestamp(seatedAt), Open: true, Table: order.Table, SeatedAt: seatedAt}
+	if i := slices.IndexFunc(tables, func(table Table) bool { return table.Number == order.Table }); i >= 0 {
+		sitting.Seats = tables[i].Seats
+	}
+	if err := storeFor(ctx).Sittings().Open(ctx, sitting); err != nil {
+		return err
+	}
+	vacatedAt := at.Add(time.Duration(2+random.IntN(10)) * time.Minute)
+	sitting.Open, sitting.VacatedAt = false, &vacatedAt
+	return storeFor(ctx).Sittings().Close(ctx, sitting)
+}
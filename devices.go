@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of device that call the API
+const (
+	DevicePOS   = "pos"   // A till or tablet staff take orders and payments on
+	DeviceKiosk = "kiosk" // A self-ordering kiosk customers use
+)
+
+var deviceKinds = []string{DevicePOS, DeviceKiosk}
+
+const (
+	// devicePrefix starts every device's credential, as apiKeyPrefix does keys
+	devicePrefix = "rms_device_"
+	// deviceHeader carries the device's credential along with the API key
+	deviceHeader = "X-Device"
+	// deviceTouchInterval is how often a device's last use is written down
+	deviceTouchInterval = time.Minute
+	// maxDeviceLocation caps where a device is said to be
+	maxDeviceLocation = 80
+)
+
+// ErrDeviceDisabled is returned for a device that was disabled, e.g. because it was stolen
+var ErrDeviceDisabled = errors.New("this device has been disabled")
+
+// requireDevice makes staff keys, which have a role, work only from a registered device, from RMS_REQUIRE_DEVICE
+var requireDevice bool
+
+// Device is a POS terminal or kiosk registered to call the API with its own credential, sent in the X-Device
+// header. Orders and payments taken on it record it. Only a hash of the credential is stored.
+type Device struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TenantID       string             `bson:"tenantId,omitempty" json:"tenantId,omitempty"` // Set in SaaS mode
+	Name           string             `bson:"name" json:"name"`
+	Kind           string             `bson:"kind" json:"kind"`
+	Location       string             `bson:"location,omitempty" json:"location,omitempty"` // e.g. "front counter" or "patio"
+	Prefix         string             `bson:"prefix" json:"prefix"`                         // First characters of the credential, to tell devices apart
+	Hash           string             `bson:"hash" json:"-"`
+	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+	LastSeenAt     *time.Time         `bson:"lastSeenAt,omitempty" json:"lastSeenAt,omitempty"`
+	DisabledAt     *time.Time         `bson:"disabledAt,omitempty" json:"disabledAt,omitempty"`
+	DisabledBy     string             `bson:"disabledBy,omitempty" json:"disabledBy,omitempty"`
+	DisabledReason string             `bson:"disabledReason,omitempty" json:"disabledReason,omitempty"`
+}
+
+type deviceContextKey struct{}
+
+// DeviceFrom returns the device a request was made from, if any
+func DeviceFrom(ctx context.Context) (Device, bool) {
+	device, ok := ctx.Value(deviceContextKey{}).(Device)
+	return device, ok
+}
+
+// deviceIdentity returns the id and name of the device a request was made from, for recording on what it took
+func deviceIdentity(ctx context.Context) (primitive.ObjectID, string) {
+	device, _ := DeviceFrom(ctx)
+	return device.ID, device.Name
+}
+
+// RegisterDevice registers a device with the name, kind and location of device and returns its credential;
+// the credential cannot be retrieved later
+func RegisterDevice(ctx context.Context, device Device) (string, Device, error) {
+	device.Name, device.Location = strings.TrimSpace(device.Name), strings.TrimSpace(device.Location)
+	device.Kind = strings.ToLower(strings.TrimSpace(device.Kind))
+	if device.Kind == "" {
+		device.Kind = DevicePOS
+	}
+	switch {
+	case device.Name == "":
+		return "", Device{}, fmt.Errorf("device name is required")
+	case utf8.RuneCountInString(device.Name) > maxTerminalName:
+		return "", Device{}, fmt.Errorf("device names must be at most %d characters", maxTerminalName)
+	case utf8.RuneCountInString(device.Location) > maxDeviceLocation:
+		return "", Device{}, fmt.Errorf("device locations must be at most %d characters", maxDeviceLocation)
+	case !slices.Contains(deviceKinds, device.Kind):
+		return "", Device{}, fmt.Errorf("unknown device kind %q (want one of %s)", device.Kind, strings.Join(deviceKinds, ", "))
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", Device{}, err
+	}
+	credential := devicePrefix + secret
+	device.ID = primitive.NewObjectID()
+	device.Prefix = credential[:len(devicePrefix)+6]
+	device.Hash = hashAPIKey(credential)
+	device.CreatedAt = time.Now()
+	device.LastSeenAt, device.DisabledAt, device.DisabledBy, device.DisabledReason = nil, nil, "", ""
+	err = store.Devices().Create(ctx, device)
+	if errors.Is(err, ErrDuplicate) {
+		return "", Device{}, fmt.Errorf("a device named %q is already registered", device.Name)
+	}
+	if err != nil {
+		return "", Device{}, err
+	}
+	log.Printf("Device %s (%s) registered at %q", device.Prefix, device.Name, device.Location)
+	return credential, device, nil
+}
+
+// ListDevices returns the devices registered to the tenant ("" outside SaaS mode)
+func ListDevices(ctx context.Context, tenantID string) ([]Device, error) {
+	devices, err := store.Devices().ListByTenant(ctx, tenantID)
+	if devices == nil {
+		devices = []Device{}
+	}
+	return devices, err
+}
+
+// findTenantDevice loads a device, treating another tenant's device as not found
+func findTenantDevice(ctx context.Context, tenantID string, id primitive.ObjectID) (Device, error) {
+	device, err := store.Devices().Find(ctx, id)
+	if err != nil {
+		return Device{}, err
+	}
+	if device.TenantID != tenantID {
+		return Device{}, ErrNotFound
+	}
+	return device, nil
+}
+
+// DisableDevice stops a device working at once, e.g. when it is stolen. Sessions started on it stop working with it,
+// as they can only be used from the device they started on.
+func DisableDevice(ctx context.Context, tenantID string, id primitive.ObjectID, reason string) (Device, error) {
+	device, err := findTenantDevice(ctx, tenantID, id)
+	if err != nil {
+		return Device{}, err
+	}
+	if device.DisabledAt != nil {
+		return device, nil
+	}
+	now := time.Now()
+	device.DisabledAt, device.DisabledBy, device.DisabledReason = &now, changedBy(ctx), strings.TrimSpace(reason)
+	if err := store.Devices().Update(ctx, device); err != nil {
+		return Device{}, err
+	}
+	log.Printf("Device %s (%s) disabled by %s", device.Prefix, device.Name, device.DisabledBy)
+	return device, nil
+}
+
+// EnableDevice lets a disabled device work again, e.g. when it turns up. A device that may have been tampered with
+// is better registered again, which gives it a new credential.
+func EnableDevice(ctx context.Context, tenantID string, id primitive.ObjectID) (Device, error) {
+	device, err := findTenantDevice(ctx, tenantID, id)
+	if err != nil {
+		return Device{}, err
+	}
+	if device.DisabledAt == nil {
+		return device, nil
+	}
+	device.DisabledAt, device.DisabledBy, device.DisabledReason = nil, "", ""
+	if err := store.Devices().Update(ctx, device); err != nil {
+		return Device{}, err
+	}
+	log.Printf("Device %s (%s) enabled again", device.Prefix, device.Name)
+	return device, nil
+}
+
+// AuthenticateDevice returns the device of the tenant ("" outside SaaS mode) matching the credential. A device
+// that was disabled gets ErrDeviceDisabled.
+func AuthenticateDevice(ctx context.Context, tenantID, credential string) (Device, error) {
+	if !strings.HasPrefix(credential, devicePrefix) {
+		return Device{}, ErrNotFound
+	}
+	device, err := store.Devices().FindByHash(ctx, hashAPIKey(credential))
+	if err != nil {
+		return Device{}, err
+	}
+	if device.TenantID != tenantID {
+		return Device{}, ErrNotFound
+	}
+	if device.DisabledAt != nil {
+		return Device{}, ErrDeviceDisabled
+	}
+	if device.LastSeenAt == nil || time.Since(*device.LastSeenAt) > deviceTouchInterval {
+		now := time.Now()
+		device.LastSeenAt = &now
+		if err := store.Devices().Update(ctx, device); err != nil {
+			log.Println("Error recording device use:", err)
+		}
+	}
+	return device, nil
+}
+
+// ShowDevices prints the registered devices
+func ShowDevices(ctx context.Context) error {
+	devices, err := ListDevices(ctx, "")
+	if err != nil {
+		return err
+	}
+	deviceListing := listing{
+		title:   "Devices:",
+		header:  []string{"ID", "Name", "Kind", "Location", "Prefix", "Last seen", "Status"},
+		records: devices,
+	}
+	for _, device := range devices {
+		lastSeen, status := "never", "active"
+		if device.LastSeenAt != nil {
+			lastSeen = device.LastSeenAt.Format("02 Jan 15:04")
+		}
+		if device.DisabledAt != nil {
+			status = "disabled " + device.DisabledAt.Format("02 Jan 15:04")
+		}
+		deviceListing.rows = append(deviceListing.rows, []string{
+			device.ID.Hex(), device.Name, device.Kind, device.Location, device.Prefix, lastSeen, status,
+		})
+		deviceListing.compact = append(deviceListing.compact, fmt.Sprintf("%s %s (%s) %s", device.ID.Hex(), device.Name, device.Kind, status))
+	}
+	return printListing(os.Stdout, deviceListing)
+}
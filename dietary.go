@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DietaryCheck is what an order looks like against the customer's dietary profile
+type DietaryCheck struct {
+	Allergies []string `json:"allergies,omitempty"` // Items with an allergen the customer has; these block the order
+	Warnings  []string `json:"warnings,omitempty"`  // Items that do not suit the customer's diet
+}
+
+// AllergyError stops an order containing something the customer is allergic to. Staff can
+// still send it by setting AllergyOverride on the order once they have checked with the customer.
+type AllergyError struct {
+	Conflicts []string
+}
+
+func (e *AllergyError) Error() string {
+	return "allergy warning: " + strings.Join(e.Conflicts, "; ")
+}
+
+// normalizeTags lowercases, trims and de-duplicates allergen and diet names so they compare reliably
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// checkMenuItem compares one menu item with the customer's allergies and diets
+func checkMenuItem(customer Customer, item MenuItem, check *DietaryCheck) {
+	for _, allergen := range item.Allergens {
+		if slices.Contains(customer.Allergies, allergen) {
+			check.Allergies = append(check.Allergies, fmt.Sprintf("%s contains %s", item.Name, allergen))
+		}
+	}
+	for _, diet := range customer.Diets {
+		if !slices.Contains(item.Diets, diet) {
+			check.Warnings = append(check.Warnings, fmt.Sprintf("%s is not marked %s", item.Name, diet))
+		}
+	}
+}
+
+// CheckDietary compares the order lines with the customer's allergies and diets. A customer
+// without a profile, or an item no longer on the menu, is not flagged.
+func CheckDietary(ctx context.Context, customerName string, lines []OrderLine) (DietaryCheck, error) {
+	var check DietaryCheck
+	customer, err := storeFor(ctx).Customers().FindByName(ctx, customerName)
+	if errors.Is(err, ErrNotFound) {
+		return check, nil
+	}
+	if err != nil {
+		return check, err
+	}
+	if len(customer.Allergies) == 0 && len(customer.Diets) == 0 {
+		return check, nil
+	}
+
+	menu := LoadMenu(ctx)
+	for _, line := range lines {
+		if item, found := FindMenuItem(menu, line.Name); found {
+			checkMenuItem(customer, item, &check)
+		}
+	}
+	return check, nil
+}
+
+// SetCustomerDietary records a customer's allergies and diets, creating the customer if needed.
+// With a version other than 0 the customer must exist and still be at that version.
+func SetCustomerDietary(ctx context.Context, name string, version int, allergies, diets []string) (Customer, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Customer{}, fmt.Errorf("customer name is required")
+	}
+	allergies, diets = normalizeTags(allergies), normalizeTags(diets)
+	err := storeFor(ctx).Customers().SetDietary(ctx, name, version, allergies, diets)
+	if errors.Is(err, ErrVersionConflict) {
+		return Customer{}, fmt.Errorf("%s %w", name, ErrVersionConflict)
+	}
+	if err != nil {
+		return Customer{}, err
+	}
+	return storeFor(ctx).Customers().FindByName(ctx, name)
+}
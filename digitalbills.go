@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newBillToken makes the secret in an order's digital bill link. It starts with the order's ID, so the bill is
+// found without an index, followed by random hex that makes it hard to guess.
+func newBillToken(id primitive.ObjectID) (string, error) {
+	secret, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	return id.Hex() + secret, nil
+}
+
+// billLink is the digital bill page a receipt's QR code opens, or "" for an order without one. In SaaS mode it
+// names the tenant, like a survey's.
+func billLink(ctx context.Context, order Order) string {
+	if order.BillToken == "" {
+		return ""
+	}
+	link := publicURL + "/bills/" + order.BillToken
+	if tenant, ok := TenantFrom(ctx); ok {
+		link += "?tenant=" + tenant.ID
+	}
+	return link
+}
+
+// DigitalBill is what the customer sees after scanning a receipt: the bill, how it was paid and their feedback
+type DigitalBill struct {
+	Order    Order
+	Payments []Payment
+	Feedback *Survey // The order's survey, if one was sent or the customer left feedback on the bill
+}
+
+// FindOrderByBillToken loads the order a digital bill link is for
+func FindOrderByBillToken(ctx context.Context, token string) (Order, error) {
+	if len(token) <= 24 {
+		return Order{}, ErrNotFound
+	}
+	id, err := primitive.ObjectIDFromHex(token[:24])
+	if err != nil {
+		return Order{}, ErrNotFound
+	}
+	order, err := FindOrder(ctx, id)
+	if err != nil {
+		return Order{}, err
+	}
+	if order.BillToken == "" || subtle.ConstantTimeCompare([]byte(order.BillToken), []byte(token)) != 1 {
+		return Order{}, ErrNotFound
+	}
+	return order, nil
+}
+
+// LoadDigitalBill loads the order, payments and feedback a digital bill link shows
+func LoadDigitalBill(ctx context.Context, token string) (DigitalBill, error) {
+	order, err := FindOrderByBillToken(ctx, token)
+	if err != nil {
+		return DigitalBill{}, err
+	}
+	events, err := LoadOrderHistory(ctx, order.ID)
+	if err != nil {
+		return DigitalBill{}, err
+	}
+	bill := DigitalBill{Order: order}
+	for _, event := range events {
+		if event.Type == EventPaid {
+			bill.Payments = append(bill.Payments, *event.Payment)
+		}
+	}
+	survey, err := storeFor(ctx).Surveys().FindByOrder(ctx, order.ID)
+	if err == nil {
+		bill.Feedback = &survey
+	} else if !errors.Is(err, ErrNotFound) {
+		return DigitalBill{}, err
+	}
+	return bill, nil
+}
+
+// LeaveBillFeedback records the customer's score from 0 to 10 and comment from their digital bill. It answers
+// the order's survey, starting one if none was sent, so the feedback counts towards the net promoter score.
+func LeaveBillFeedback(ctx context.Context, token string, score int, comment string) (Survey, error) {
+	order, err := FindOrderByBillToken(ctx, token)
+	if err != nil {
+		return Survey{}, err
+	}
+	if !order.Paid {
+		return Survey{}, fmt.Errorf("%w: the bill is not settled yet", ErrSurveyClosed)
+	}
+	survey, err := storeFor(ctx).Surveys().FindByOrder(ctx, order.ID)
+	if errors.Is(err, ErrNotFound) {
+		now := clock.Now()
+		survey = Survey{
+			ID: order.ID, CustomerName: order.CustomerName, Branch: order.Branch, SentAt: now, ExpiresAt: now.Add(surveyValidity),
+		}
+		if survey.Token, err = randomHex(16); err != nil {
+			return Survey{}, err
+		}
+		err = storeFor(ctx).Surveys().Insert(ctx, survey)
+		if errors.Is(err, ErrDuplicate) {
+			// The survey was sent while the customer was reading the bill
+			survey, err = storeFor(ctx).Surveys().FindByOrder(ctx, order.ID)
+		}
+	}
+	if err != nil {
+		return Survey{}, err
+	}
+	return AnswerSurvey(ctx, survey.Token, score, comment)
+}
+
+// JoinFromBill signs the customer up for offers from their digital bill: the customer with the phone number, or
+// the one named on the bill, is found or added with it and agrees to marketing on the phone channel. Anyone
+// holding the receipt can open the bill, so a customer who already has another number keeps it.
+func JoinFromBill(ctx context.Context, token, phone, name string) (Customer, error) {
+	order, err := FindOrderByBillToken(ctx, token)
+	if err != nil {
+		return Customer{}, err
+	}
+	if strings.TrimSpace(name) == "" {
+		name = order.CustomerName
+	}
+	phone = normalizePhone(phone)
+	customers := storeFor(ctx).Customers()
+	if _, err := customers.FindByPhone(ctx, phone); errors.Is(err, ErrNotFound) {
+		named, err := customers.FindByName(ctx, strings.TrimSpace(name))
+		if err == nil && named.Phone != "" {
+			return Customer{}, fmt.Errorf("%s already has another phone number, please ask at the counter", named.Name)
+		}
+	}
+	checkIn, err := CheckInCustomer(ctx, phone, name)
+	if err != nil {
+		return Customer{}, err
+	}
+	return changeMarketing(ctx, checkIn.Customer.Name, phoneChannel, true)
+}
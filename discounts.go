@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reason codes every discount and comp is given with, for the comps and discounts report
+const (
+	DiscountStaffMeal   = "staff-meal"
+	DiscountManagerComp = "manager-comp" // Always needs a manager's override
+	DiscountPromo       = "promo"
+	DiscountComplaint   = "complaint" // Also given to the free remakes that put complaints right
+)
+
+// discountReasons are the reason codes, in the order they are reported
+var discountReasons = []string{DiscountStaffMeal, DiscountManagerComp, DiscountPromo, DiscountComplaint}
+
+// DiscountReason is why a discount or comp was given, by whom and with which manager's override, kept on the bill
+type DiscountReason struct {
+	Code       string `bson:"code" json:"code"`
+	Note       string `bson:"note,omitempty" json:"note,omitempty"`
+	By         string `bson:"by,omitempty" json:"by,omitempty"`
+	ApprovedBy string `bson:"approvedBy,omitempty" json:"approvedBy,omitempty"`
+}
+
+// ItemDiscount is a percentage taken off quantity of a line; 100 comps it
+type ItemDiscount struct {
+	Name     string  `bson:"name" json:"name"`
+	Course   int     `bson:"course,omitempty" json:"course,omitempty"`
+	Seat     int     `bson:"seat" json:"seat"`
+	Quantity int     `bson:"quantity" json:"quantity"`
+	Percent  float64 `bson:"percent" json:"percent"`
+}
+
+// checkDiscountReason checks a discount's reason code
+func checkDiscountReason(code string) error {
+	if !slices.Contains(discountReasons, code) {
+		return fmt.Errorf("a reason code is required: one of %s", strings.Join(discountReasons, ", "))
+	}
+	return nil
+}
+
+// discountDetail describes a discount for receipts, e.g. "Discount 10% promo" or "Comp staff-meal"; it is empty
+// when there is none
+func discountDetail(percent float64, reason *DiscountReason) string {
+	if percent == 0 {
+		return ""
+	}
+	detail := fmt.Sprintf("Discount %g%%", percent)
+	if percent == 100 {
+		detail = "Comp"
+	}
+	if reason != nil {
+		detail += " " + reason.Code
+	}
+	return detail
+}
+
+// eventDiscountReason is the reason a discount event was recorded with, nil when it has none
+func eventDiscountReason(event OrderEvent) *DiscountReason {
+	if event.ReasonCode == "" {
+		return nil
+	}
+	return &DiscountReason{Code: event.ReasonCode, Note: event.Reason, By: event.By, ApprovedBy: event.ApprovedBy}
+}
+
+// discountCode is the reason code of a line's discount, empty when it has none
+func discountCode(line OrderLine) string {
+	if line.DiscountReason == nil {
+		return ""
+	}
+	return line.DiscountReason.Code
+}
+
+// findUndiscountedLine returns the index of the line for the item, course and seat that has no discount yet
+func findUndiscountedLine(lines []OrderLine, name string, course, seat int) int {
+	return slices.IndexFunc(lines, func(line OrderLine) bool {
+		return line.Name == name && lineCourse(line) == max(course, FirstCourse) && line.Seat == seat && line.Discount == 0
+	})
+}
+
+// discountLine returns a copy of lines with the discount applied, splitting the discounted quantity off its line
+func discountLine(lines []OrderLine, discount ItemDiscount, reason *DiscountReason) []OrderLine {
+	lines = append([]OrderLine(nil), lines...)
+	i := findUndiscountedLine(lines, discount.Name, discount.Course, discount.Seat)
+	if i < 0 {
+		return lines
+	}
+	discounted := lines[i]
+	discounted.Quantity, discounted.Discount, discounted.DiscountReason = discount.Quantity, discount.Percent, reason
+	if lines[i].Quantity -= discount.Quantity; lines[i].Quantity <= 0 {
+		lines[i] = discounted
+		return lines
+	}
+	return slices.Insert(lines, i+1, discounted)
+}
+
+// DiscountEntry is one discount or comp in the comps and discounts report; Item is empty for a discount off the bill
+type DiscountEntry struct {
+	OrderID    primitive.ObjectID `json:"orderId"`
+	At         time.Time          `json:"at"`
+	Item       string             `json:"item,omitempty"`
+	Quantity   int                `json:"quantity,omitempty"`
+	Percent    float64            `json:"percent"`
+	Reason     string             `json:"reason"`
+	Note       string             `json:"note,omitempty"`
+	By         string             `json:"by,omitempty"`
+	ApprovedBy string             `json:"approvedBy,omitempty"`
+	Value      float64            `json:"value"`
+}
+
+// DiscountTotal is the count and value of discounts given for one reason or approved by one manager
+type DiscountTotal struct {
+	Key   string  `json:"key"`
+	Count int     `json:"count"`
+	Value float64 `json:"value"`
+}
+
+// DiscountReport lists the comps and discounts given over a period, totalled by reason and approver
+type DiscountReport struct {
+	From       time.Time       `json:"from"`
+	To         time.Time       `json:"to"`
+	Entries    []DiscountEntry `json:"entries"`
+	ByReason   []DiscountTotal `json:"byReason"`
+	ByApprover []DiscountTotal `json:"byApprover"` // "none" for discounts within the giver's own limit
+	Total      float64         `json:"total"`
+}
+
+// noReason and noApprover stand in for discounts given before reason codes were kept, and for those that
+// needed no override
+const (
+	noReason   = "unspecified"
+	noApprover = "none"
+)
+
+// BuildDiscountReport lists the comps and discounts on orders created in [from, to), oldest first
+func BuildDiscountReport(ctx context.Context, from, to time.Time) (DiscountReport, error) {
+	orders, err := LoadOrdersBetween(ctx, from, to)
+	if err != nil {
+		return DiscountReport{}, err
+	}
+	report := DiscountReport{From: from, To: to, Entries: []DiscountEntry{}, ByReason: []DiscountTotal{}, ByApprover: []DiscountTotal{}}
+	add := func(entry DiscountEntry, reason *DiscountReason) {
+		entry.Reason = noReason
+		if reason != nil {
+			entry.Reason, entry.Note, entry.By, entry.ApprovedBy = reason.Code, reason.Note, reason.By, reason.ApprovedBy
+		}
+		report.Entries = append(report.Entries, entry)
+	}
+	for _, order := range orders {
+		for _, line := range order.Items {
+			if line.Discount == 0 {
+				continue
+			}
+			full := line
+			full.Discount = 0
+			add(DiscountEntry{
+				OrderID: order.ID, At: order.CreatedAt, Item: line.Name, Quantity: line.Quantity, Percent: line.Discount,
+				Value: roundPaise(full.Amount() - line.Amount()),
+			}, line.DiscountReason)
+		}
+		if order.Discount != 0 {
+			add(DiscountEntry{
+				OrderID: order.ID, At: order.CreatedAt, Percent: order.Discount,
+				Value: roundPaise(CartTotal(order.Items) - linesTotal(order)),
+			}, order.DiscountReason)
+		}
+	}
+
+	byReason, byApprover := map[string]*DiscountTotal{}, map[string]*DiscountTotal{}
+	var approvers []string
+	count := func(totals map[string]*DiscountTotal, key string, value float64) bool {
+		total, ok := totals[key]
+		if !ok {
+			total = &DiscountTotal{Key: key}
+			totals[key] = total
+		}
+		total.Count++
+		total.Value = roundPaise(total.Value + value)
+		return !ok
+	}
+	for _, entry := range report.Entries {
+		count(byReason, entry.Reason, entry.Value)
+		approver := entry.ApprovedBy
+		if approver == "" {
+			approver = noApprover
+		}
+		if count(byApprover, approver, entry.Value) {
+			approvers = append(approvers, approver)
+		}
+		report.Total = roundPaise(report.Total + entry.Value)
+	}
+	for _, reason := range append(slices.Clone(discountReasons), noReason) {
+		if total, ok := byReason[reason]; ok {
+			report.ByReason = append(report.ByReason, *total)
+		}
+	}
+	slices.Sort(approvers)
+	for _, approver := range approvers {
+		report.ByApprover = append(report.ByApprover, *byApprover[approver])
+	}
+	return report, nil
+}
+
+// ShowDiscountReport prints the comps and discounts given in [from, to), with their totals by reason and approver
+func ShowDiscountReport(ctx context.Context, from, to time.Time) error {
+	report, err := BuildDiscountReport(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	title := fmt.Sprintf("Comps and discounts from %s to %s:", from.Format("02 Jan 2006"), to.AddDate(0, 0, -1).Format("02 Jan 2006"))
+	discountListing := listing{
+		title:   title,
+		header:  []string{"Time", "Order", "Item", "Off", "Reason", "By", "Approved by", "Value"},
+		records: report,
+	}
+	for _, entry := range report.Entries {
+		item := "whole bill"
+		if entry.Item != "" {
+			item = fmt.Sprintf("%d x %s", entry.Quantity, entry.Item)
+		}
+		value := fmt.Sprintf("Rs %.2f", entry.Value)
+		discountListing.rows = append(discountListing.rows, []string{
+			entry.At.Format("02 Jan 15:04"), entry.OrderID.Hex(), item, fmt.Sprintf("%g%%", entry.Percent), entry.Reason,
+			entry.By, entry.ApprovedBy, value,
+		})
+		discountListing.compact = append(discountListing.compact, fmt.Sprintf("%s %s %s %s", entry.At.Format("02 Jan 15:04"), item, entry.Reason, value))
+	}
+	for _, total := range report.ByReason {
+		discountListing.rows = append(discountListing.rows, []string{"By reason", "", "", "", total.Key, "", "", fmt.Sprintf("Rs %.2f (%d)", total.Value, total.Count)})
+	}
+	for _, total := range report.ByApprover {
+		discountListing.rows = append(discountListing.rows, []string{"By approver", "", "", "", "", "", total.Key, fmt.Sprintf("Rs %.2f (%d)", total.Value, total.Count)})
+	}
+	discountListing.rows = append(discountListing.rows, []string{"Total", "", "", "", "", "", "", fmt.Sprintf("Rs %.2f", report.Total)})
+	return printListing(os.Stdout, discountListing)
+}
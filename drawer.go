@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Cash movement types
+const (
+	CashIn  = "in"
+	CashOut = "out"
+)
+
+// cashVarianceTolerance is how far, in rupees, counted cash may be from expected before the session is flagged
+const cashVarianceTolerance = 1.0
+
+// ErrDrawerOpen is returned when opening the cash drawer while a session is already open
+var ErrDrawerOpen = errors.New("the cash drawer is already open; close the current session first")
+
+// DrawerSession is one stretch of the cash drawer being in use, from the opening float to the count at close.
+// Cash payments taken while it is open are tied to it.
+type DrawerSession struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Open         bool               `bson:"open,omitempty" json:"open"`
+	Cashier      string             `bson:"cashier" json:"cashier"`
+	OpeningFloat float64            `bson:"openingFloat" json:"openingFloat"`
+	OpenedBy     string             `bson:"openedBy" json:"openedBy"`
+	OpenedAt     time.Time          `bson:"openedAt" json:"openedAt"`
+	Movements    []CashMovement     `bson:"movements" json:"movements"`
+	// Set when the session is closed; CashSales and Expected are also filled in while it is open
+	CashSales float64    `bson:"cashSales" json:"cashSales"`
+	Expected  float64    `bson:"expected" json:"expected"`
+	Counted   float64    `bson:"counted" json:"counted"`
+	Variance  float64    `bson:"variance" json:"variance"` // Counted minus expected
+	Flagged   bool       `bson:"flagged,omitempty" json:"flagged,omitempty"`
+	ClosedBy  string     `bson:"closedBy,omitempty" json:"closedBy,omitempty"`
+	ClosedAt  *time.Time `bson:"closedAt,omitempty" json:"closedAt,omitempty"`
+}
+
+// CashMovement is cash put into or taken out of the drawer other than for a sale, e.g. petty cash for supplies
+type CashMovement struct {
+	Type   string    `bson:"type" json:"type"`
+	Amount float64   `bson:"amount" json:"amount"`
+	Reason string    `bson:"reason" json:"reason"`
+	By     string    `bson:"by" json:"by"`
+	At     time.Time `bson:"at" json:"at"`
+}
+
+// OpenDrawer starts a drawer session for the cashier with the float counted into the drawer
+func OpenDrawer(ctx context.Context, cashier string, openingFloat float64) (DrawerSession, error) {
+	cashier = strings.TrimSpace(cashier)
+	if cashier == "" {
+		return DrawerSession{}, fmt.Errorf("cashier is required")
+	}
+	if openingFloat < 0 {
+		return DrawerSession{}, fmt.Errorf("opening float must not be negative")
+	}
+	session := DrawerSession{
+		ID: primitive.NewObjectID(), Open: true, Cashier: cashier, OpeningFloat: roundPaise(openingFloat),
+		OpenedBy: changedBy(ctx), OpenedAt: clock.Now(), Movements: []CashMovement{},
+	}
+	session.Expected = session.OpeningFloat
+	err := storeFor(ctx).Drawers().Open(ctx, session)
+	if errors.Is(err, ErrDuplicate) {
+		return DrawerSession{}, ErrDrawerOpen
+	}
+	return session, err
+}
+
+// CurrentDrawer returns the open drawer session with the cash it should hold right now
+func CurrentDrawer(ctx context.Context) (DrawerSession, error) {
+	session, err := storeFor(ctx).Drawers().FindOpen(ctx)
+	if err != nil {
+		return DrawerSession{}, err
+	}
+	return session, tallyDrawer(ctx, &session, clock.Now())
+}
+
+// tallyDrawer works out the cash sales and the cash expected in the drawer up to time t
+func tallyDrawer(ctx context.Context, session *DrawerSession, t time.Time) error {
+	payments, err := storeFor(ctx).Payments().ListBetween(ctx, session.OpenedAt, t.Add(time.Nanosecond))
+	if err != nil {
+		return err
+	}
+	session.CashSales = 0
+	for _, payment := range payments {
+		if payment.DrawerID == session.ID {
+			session.CashSales += payment.Amount
+		}
+	}
+	session.Expected = session.OpeningFloat + session.CashSales
+	for _, movement := range session.Movements {
+		if movement.Type == CashIn {
+			session.Expected += movement.Amount
+		} else {
+			session.Expected -= movement.Amount
+		}
+	}
+	session.CashSales, session.Expected = roundPaise(session.CashSales), roundPaise(session.Expected)
+	return nil
+}
+
+// RecordCashMovement logs cash put into (CashIn) or taken out of (CashOut) the open drawer, with the reason
+func RecordCashMovement(ctx context.Context, kind string, amount float64, reason string) (DrawerSession, error) {
+	if kind != CashIn && kind != CashOut {
+		return DrawerSession{}, fmt.Errorf("type must be %s or %s", CashIn, CashOut)
+	}
+	if amount <= 0 {
+		return DrawerSession{}, fmt.Errorf("amount must be positive")
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return DrawerSession{}, fmt.Errorf("a reason is required")
+	}
+	session, err := storeFor(ctx).Drawers().FindOpen(ctx)
+	if err != nil {
+		return DrawerSession{}, err
+	}
+	movement := CashMovement{Type: kind, Amount: roundPaise(amount), Reason: reason, By: changedBy(ctx), At: clock.Now()}
+	if err := storeFor(ctx).Drawers().AddMovement(ctx, session.ID, movement); err != nil {
+		return DrawerSession{}, err
+	}
+	session.Movements = append(session.Movements, movement)
+	return session, tallyDrawer(ctx, &session, clock.Now())
+}
+
+// CloseDrawer ends the open session with the cash counted in the drawer, flagging it when the count
+// differs from what was expected by more than the tolerance
+func CloseDrawer(ctx context.Context, counted float64) (DrawerSession, error) {
+	if counted < 0 {
+		return DrawerSession{}, fmt.Errorf("counted cash must not be negative")
+	}
+	session, err := storeFor(ctx).Drawers().FindOpen(ctx)
+	if err != nil {
+		return DrawerSession{}, err
+	}
+	now := clock.Now()
+	if err := tallyDrawer(ctx, &session, now); err != nil {
+		return DrawerSession{}, err
+	}
+	session.Open = false
+	session.Counted = roundPaise(counted)
+	session.Variance = roundPaise(session.Counted - session.Expected)
+	session.Flagged = math.Abs(session.Variance) > cashVarianceTolerance
+	session.ClosedBy, session.ClosedAt = changedBy(ctx), &now
+	if err := storeFor(ctx).Drawers().Close(ctx, session); err != nil {
+		return DrawerSession{}, err
+	}
+	if session.Flagged {
+		log.Printf("Cash drawer of %s closed with a variance of Rs %.2f (expected Rs %.2f, counted Rs %.2f)",
+			session.Cashier, session.Variance, session.Expected, session.Counted)
+	}
+	return session, nil
+}
+
+// ListDrawerSessions returns the drawer sessions opened in [from, to), oldest first
+func ListDrawerSessions(ctx context.Context, from, to time.Time) ([]DrawerSession, error) {
+	sessions, err := storeFor(ctx).Drawers().ListBetween(ctx, from, to)
+	if sessions == nil {
+		sessions = []DrawerSession{}
+	}
+	return sessions, err
+}
+
+// openDrawerID returns the open drawer session that a cash payment goes into, or a zero ID when none is open
+func openDrawerID(ctx context.Context) (primitive.ObjectID, error) {
+	session, err := storeFor(ctx).Drawers().FindOpen(ctx)
+	if errors.Is(err, ErrNotFound) {
+		return primitive.NilObjectID, nil
+	}
+	return session.ID, err
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Order event types. Every change to an order is stored as one of these, and the order
+// itself is a projection of its events.
+const (
+	EventOrderCreated   = "OrderCreated"
+	EventItemAdded      = "ItemAdded"
+	EventStatusChanged  = "StatusChanged"
+	EventPaid           = "Paid"
+	EventCourseFired    = "CourseFired"
+	EventItemMoved      = "ItemMoved"
+	EventItemVoided     = "ItemVoided"
+	EventDiscountGiven  = "DiscountGiven"
+	EventItemDiscounted = "ItemDiscounted"
+	EventOrderReopened  = "OrderReopened"
+	// EventServiceChargeWaived records the service charge taken off the bill at the customer's request
+	EventServiceChargeWaived = "ServiceChargeWaived"
+	// EventReceiptReprinted records a duplicate receipt being printed; it changes nothing else on the order
+	EventReceiptReprinted = "ReceiptReprinted"
+)
+
+// maxEventAttempts is how many times a change is retried when another terminal writes to the same order first
+const maxEventAttempts = 5
+
+// OrderEvent is one entry in an order's append-only history. Only the field for its type is set.
+type OrderEvent struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrderID primitive.ObjectID `bson:"orderId" json:"orderId"`
+	Seq     int                `bson:"seq" json:"seq"` // Position in the order's stream, starting at 1
+	Type    string             `bson:"type" json:"type"`
+	At      time.Time          `bson:"at" json:"at"`
+	Order   *Order             `bson:"order,omitempty" json:"order,omitempty"` // OrderCreated: the order as it was placed
+	Item    *OrderLine         `bson:"item,omitempty" json:"item,omitempty"`   // ItemAdded
+	// IDVerified is the guest's ID being checked for an age-restricted item, for ItemAdded
+	IDVerified *IDCheck  `bson:"idVerified,omitempty" json:"idVerified,omitempty"`
+	Status     string    `bson:"status,omitempty" json:"status,omitempty"`     // StatusChanged
+	Payment    *Payment  `bson:"payment,omitempty" json:"payment,omitempty"`   // Paid
+	Course     int       `bson:"course,omitempty" json:"course,omitempty"`     // CourseFired
+	Move       *ItemMove `bson:"move,omitempty" json:"move,omitempty"`         // ItemMoved
+	Void       *ItemVoid `bson:"void,omitempty" json:"void,omitempty"`         // ItemVoided
+	Discount   float64   `bson:"discount,omitempty" json:"discount,omitempty"` // DiscountGiven: percentage off the bill
+	// ItemDiscount is the percentage off quantity of a line, for ItemDiscounted
+	ItemDiscount *ItemDiscount `bson:"itemDiscount,omitempty" json:"itemDiscount,omitempty"`
+
+	// Why, by whom and with which manager's override a void, discount, reopening, reprint or service charge waiver
+	// was made. Discounts also carry one of the discountReasons codes.
+	Reason     string `bson:"reason,omitempty" json:"reason,omitempty"`
+	ReasonCode string `bson:"reasonCode,omitempty" json:"reasonCode,omitempty"`
+	By         string `bson:"by,omitempty" json:"by,omitempty"`
+	ApprovedBy string `bson:"approvedBy,omitempty" json:"approvedBy,omitempty"`
+
+	PublishedAt *time.Time `bson:"publishedAt,omitempty" json:"-"` // When the outbox relay sent it to the broker
+}
+
+// ApplyOrderEvent returns the order as it is after the event
+func ApplyOrderEvent(order Order, event OrderEvent) Order {
+	switch event.Type {
+	case EventOrderCreated:
+		order = *event.Order
+		order.Items = append([]OrderLine(nil), event.Order.Items...)
+		order.Courses = append([]CourseTicket(nil), event.Order.Courses...)
+	case EventItemAdded:
+		order.Items = addLine(order.Items, *event.Item)
+		if event.IDVerified != nil && order.IDVerified == nil {
+			order.IDVerified = event.IDVerified
+		}
+		syncServiceCharge(&order)
+		order.Total = orderTotal(order)
+		order.Calories = CartCalories(order.Items)
+		order.Paid = paidInFull(order)
+		syncCourses(&order)
+	case EventStatusChanged:
+		order.Status = event.Status
+	case EventPaid:
+		order.AmountPaid = roundPaise(order.AmountPaid + event.Payment.Amount)
+		order.Paid = paidInFull(order)
+	case EventCourseFired:
+		order.Courses = append([]CourseTicket(nil), order.Courses...)
+		for i := range order.Courses {
+			if order.Courses[i].Course == event.Course {
+				firedAt := event.At
+				order.Courses[i].FiredAt = &firedAt
+			}
+		}
+		if order.Status == StatusReady {
+			order.Status = StatusQueued
+		}
+	case EventItemMoved:
+		order.Items = moveLine(order.Items, *event.Move)
+	case EventItemVoided:
+		if event.Void.Sent {
+			order.Wasted = wasteLine(order.Wasted, order.Items, *event.Void)
+		}
+		order.Items = voidLine(order.Items, *event.Void)
+		syncServiceCharge(&order)
+		order.Total = orderTotal(order)
+		order.Calories = CartCalories(order.Items)
+		order.Paid = paidInFull(order)
+		dropEmptyCourses(&order)
+	case EventDiscountGiven:
+		order.Discount, order.DiscountReason = event.Discount, eventDiscountReason(event)
+		if order.Discount == 0 {
+			order.DiscountReason = nil
+		}
+		syncServiceCharge(&order)
+		order.Total = orderTotal(order)
+		order.Paid = paidInFull(order)
+	case EventItemDiscounted:
+		order.Items = discountLine(order.Items, *event.ItemDiscount, eventDiscountReason(event))
+		syncServiceCharge(&order)
+		order.Total = orderTotal(order)
+		order.Paid = paidInFull(order)
+	case EventOrderReopened:
+		order.Status = StatusReady
+	case EventReceiptReprinted:
+		order.Reprints++
+	case EventServiceChargeWaived:
+		waiveServiceCharge(&order, event)
+	}
+	order.Version, order.UpdatedAt = event.Seq, event.At
+	return order
+}
+
+// paidInFull reports whether what was paid covers the order's total, to the paisa
+func paidInFull(order Order) bool {
+	return roundPaise(order.AmountPaid) >= order.Total
+}
+
+// ProjectOrder rebuilds an order from its events, which must be in sequence
+func ProjectOrder(events []OrderEvent) (Order, error) {
+	if len(events) == 0 || events[0].Type != EventOrderCreated {
+		return Order{}, ErrNotFound
+	}
+	var order Order
+	for _, event := range events {
+		order = ApplyOrderEvent(order, event)
+	}
+	return order, nil
+}
+
+// LoadOrderHistory returns every event recorded for the order, oldest first
+func LoadOrderHistory(ctx context.Context, id primitive.ObjectID) ([]OrderEvent, error) {
+	events, err := storeFor(ctx).Events().ListForOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, ErrNotFound
+	}
+	return events, nil
+}
+
+// loadOrderStream projects the order's current state from its events. An order stored before
+// events were recorded gets a stream started from its stored state.
+func loadOrderStream(ctx context.Context, id primitive.ObjectID) (Order, error) {
+	events, err := storeFor(ctx).Events().ListForOrder(ctx, id)
+	if err != nil {
+		return Order{}, err
+	}
+	if len(events) > 0 {
+		return ProjectOrder(events)
+	}
+
+	order, err := storeFor(ctx).Orders().Find(ctx, id)
+	if err != nil {
+		return Order{}, err
+	}
+	created := OrderEvent{OrderID: id, Seq: 1, Type: EventOrderCreated, At: order.CreatedAt, Order: &order}
+	if err := appendOrderEvent(ctx, created); err != nil && !errors.Is(err, ErrDuplicate) {
+		return Order{}, err
+	}
+	return loadOrderStream(ctx, id)
+}
+
+// appendOrderEvent stores an event, returning ErrDuplicate if the order already has one at that position
+func appendOrderEvent(ctx context.Context, event OrderEvent) error {
+	event.ID = primitive.NewObjectID()
+	if event.At.IsZero() {
+		event.At = clock.Now()
+	}
+	return storeFor(ctx).Events().Append(ctx, event)
+}
+
+// startOrder records the OrderCreated event for a new order and stores its projection
+func startOrder(ctx context.Context, order Order) (Order, error) {
+	order.Version = 0
+	created := OrderEvent{OrderID: order.ID, Seq: 1, Type: EventOrderCreated, At: order.CreatedAt, Order: &order}
+	if err := appendOrderEvent(ctx, created); err != nil {
+		return Order{}, err
+	}
+	order = ApplyOrderEvent(Order{}, created)
+	if err := storeFor(ctx).Orders().Save(ctx, order); err != nil {
+		return order, err
+	}
+	observeOrder(ctx, order)
+	return order, nil
+}
+
+// changeOrder appends the event that decide returns for the order's current state, then stores the
+// new projection. If another terminal changed the order in the meantime it decides again.
+func changeOrder(ctx context.Context, id primitive.ObjectID, decide func(order Order) (OrderEvent, error)) (Order, error) {
+	for attempt := 0; attempt < maxEventAttempts; attempt++ {
+		order, err := loadOrderStream(ctx, id)
+		if err != nil {
+			return Order{}, err
+		}
+		event, err := decide(order)
+		if err != nil {
+			return order, err
+		}
+		event.OrderID = id
+		event.Seq = order.Version + 1
+		if event.At.IsZero() {
+			event.At = clock.Now()
+		}
+		if err := appendOrderEvent(ctx, event); errors.Is(err, ErrDuplicate) {
+			continue
+		} else if err != nil {
+			return order, err
+		}
+
+		order = ApplyOrderEvent(order, event)
+		if err := storeFor(ctx).Orders().Save(ctx, order); err != nil {
+			return order, err
+		}
+		observeOrder(ctx, order)
+		return order, nil
+	}
+	return Order{}, fmt.Errorf("order %s is changing too often, try again", id.Hex())
+}
+
+// atOrderVersion refuses the change decide makes if the order has moved past version, the one the
+// caller last saw. With version 0 the change is decided against whatever the order is now.
+func atOrderVersion(version int, decide func(order Order) (OrderEvent, error)) func(order Order) (OrderEvent, error) {
+	return func(order Order) (OrderEvent, error) {
+		if version != 0 && order.Version != version {
+			return OrderEvent{}, fmt.Errorf("order is at version %d, not %d: it %w", order.Version, version, ErrVersionConflict)
+		}
+		return decide(order)
+	}
+}
+
+// RebuildOrderProjections replays every order's events and stores the resulting state,
+// repairing any order whose stored state fell behind its history
+func RebuildOrderProjections(ctx context.Context) (int, error) {
+	events, err := storeFor(ctx).Events().ListSince(ctx, time.Unix(0, 0))
+	if err != nil {
+		return 0, err
+	}
+
+	streams := make(map[primitive.ObjectID][]OrderEvent)
+	var ids []primitive.ObjectID
+	for _, event := range events {
+		if _, seen := streams[event.OrderID]; !seen {
+			ids = append(ids, event.OrderID)
+		}
+		streams[event.OrderID] = append(streams[event.OrderID], event)
+	}
+
+	for _, id := range ids {
+		stream := streams[id]
+		sort.Slice(stream, func(i, j int) bool { return stream[i].Seq < stream[j].Seq })
+		if stream[0].Seq != 1 {
+			// The order was archived and changed since; the start of its stream is in the archive
+			if stream, err = storeFor(ctx).Events().ListForOrder(ctx, id); err != nil {
+				return 0, err
+			}
+		}
+		order, err := ProjectOrder(stream)
+		if err != nil {
+			return 0, fmt.Errorf("order %s: %w", id.Hex(), err)
+		}
+		if err := storeFor(ctx).Orders().Save(ctx, order); err != nil {
+			return 0, err
+		}
+	}
+	forgetDashboard(ctx)
+	return len(ids), nil
+}
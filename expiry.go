@@ -0,0 +1,388 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StockBatch is one delivery or count of stock. Batches are used up first in, first out, skipping any that have
+// expired.
+type StockBatch struct {
+	Quantity   float64   `bson:"quantity" json:"quantity"`
+	UnitCost   float64   `bson:"unitCost" json:"unitCost"`                       // What one unit cost, to value wastage
+	ExpiresOn  string    `bson:"expiresOn,omitempty" json:"expiresOn,omitempty"` // Last day (YYYY-MM-DD) it can be used; empty if it keeps
+	ReceivedAt time.Time `bson:"receivedAt" json:"receivedAt"`
+	Warned     bool      `bson:"warned,omitempty" json:"warned,omitempty"` // Staff were told it is about to expire
+}
+
+// expiresAt is when the batch can no longer be used: the start of the day after its use-by day
+func (b StockBatch) expiresAt() (time.Time, bool) {
+	if b.ExpiresOn == "" {
+		return time.Time{}, false
+	}
+	day, err := time.ParseInLocation("2006-01-02", b.ExpiresOn, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return day.AddDate(0, 0, 1), true
+}
+
+// batchExpired reports whether the batch can no longer be used at the time
+func batchExpired(batch StockBatch, at time.Time) bool {
+	expiresAt, perishes := batch.expiresAt()
+	return perishes && !at.Before(expiresAt)
+}
+
+// newStockBatch checks a delivery or count of the stock level's item. Without a unit cost it is valued at the last
+// cost paid.
+func newStockBatch(quantity float64, unitCost *float64, expiresOn string, level StockLevel, at time.Time) (StockBatch, error) {
+	if quantity < 0 {
+		return StockBatch{}, fmt.Errorf("the quantity must not be negative")
+	}
+	batch := StockBatch{Quantity: quantity, ExpiresOn: strings.TrimSpace(expiresOn), ReceivedAt: at}
+	if batch.ExpiresOn != "" {
+		if _, err := time.ParseInLocation("2006-01-02", batch.ExpiresOn, time.Local); err != nil {
+			return StockBatch{}, fmt.Errorf("the expiry date must be formatted as YYYY-MM-DD")
+		}
+	}
+	switch {
+	case unitCost != nil && *unitCost < 0:
+		return StockBatch{}, fmt.Errorf("the unit cost must not be negative")
+	case unitCost != nil:
+		batch.UnitCost = *unitCost
+	case len(level.Batches) > 0:
+		batch.UnitCost = level.Batches[len(level.Batches)-1].UnitCost
+	}
+	return batch, nil
+}
+
+// levelBatches returns the level's batches; a level counted before batches were kept is one batch that keeps
+func levelBatches(level StockLevel) []StockBatch {
+	if len(level.Batches) == 0 && level.Counted > 0 {
+		return []StockBatch{{Quantity: level.Counted, ReceivedAt: level.CountedAt}}
+	}
+	return slices.Clone(level.Batches)
+}
+
+// consumeBatches takes the uses since the level was counted off its batches, first in first out, and returns what
+// is left of each batch now. A use can only draw on batches received before it and not yet expired; what they
+// cannot cover is left out, as the stock must have been miscounted.
+func consumeBatches(level StockLevel, uses []stockUse, now time.Time) []StockBatch {
+	batches := levelBatches(level)
+	for _, use := range uses {
+		if use.at.Before(level.CountedAt) || !use.at.Before(now) {
+			continue
+		}
+		need := use.quantity
+		for i := range batches {
+			if need <= 0 {
+				break
+			}
+			if batches[i].ReceivedAt.After(use.at) || batchExpired(batches[i], use.at) {
+				continue
+			}
+			taken := min(need, batches[i].Quantity)
+			batches[i].Quantity -= taken
+			need -= taken
+		}
+	}
+	left := []StockBatch{}
+	for _, batch := range batches {
+		if batch.Quantity > 0 {
+			batch.Quantity = roundPaise(batch.Quantity)
+			left = append(left, batch)
+		}
+	}
+	return left
+}
+
+// stockExpiresAt returns when the last of what is left expires, if all of it perishes
+func stockExpiresAt(left []StockBatch, now time.Time) (time.Time, bool) {
+	var last time.Time
+	for _, batch := range left {
+		if batchExpired(batch, now) {
+			continue
+		}
+		expiresAt, perishes := batch.expiresAt()
+		if !perishes {
+			return time.Time{}, false
+		}
+		last = latest(last, expiresAt)
+	}
+	return last, !last.IsZero()
+}
+
+// ReceiveStock adds a delivery of an ingredient or menu item already stocked
+func ReceiveStock(ctx context.Context, name string, quantity float64, unitCost *float64, expiresOn string) (StockLevel, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	level, err := storeFor(ctx).Stock().Find(ctx, currentBranch, name)
+	if errors.Is(err, ErrNotFound) {
+		return StockLevel{}, fmt.Errorf("%s is not stocked yet: count it first", name)
+	}
+	if err != nil {
+		return StockLevel{}, err
+	}
+	if quantity <= 0 {
+		return StockLevel{}, fmt.Errorf("the quantity received must be positive")
+	}
+	batch, err := newStockBatch(quantity, unitCost, expiresOn, level, clock.Now())
+	if err != nil {
+		return StockLevel{}, err
+	}
+	level.Batches = append(levelBatches(level), batch)
+	if err := storeFor(ctx).Stock().Save(ctx, level); err != nil {
+		return StockLevel{}, err
+	}
+	level.Version++
+	return level, nil
+}
+
+// expiryWarningDays is how many days ahead of its use-by day staff are warned about stock, from RMS_EXPIRY_WARNING_DAYS
+var expiryWarningDays = 2
+
+// SetExpiryWarning sets how many days ahead of its use-by day staff are warned about stock; empty keeps 2
+func SetExpiryWarning(days string) error {
+	expiryWarningDays = 2
+	if days == "" {
+		return nil
+	}
+	parsed, err := strconv.Atoi(days)
+	if err != nil || parsed < 0 {
+		return fmt.Errorf("invalid expiry warning %q (want a number of days)", days)
+	}
+	expiryWarningDays = parsed
+	return nil
+}
+
+// expiringSoon reports whether the batch reaches its use-by day within the warning days, but has not expired yet
+func expiringSoon(batch StockBatch, now time.Time) bool {
+	expiresAt, perishes := batch.expiresAt()
+	return perishes && now.Before(expiresAt) && !now.AddDate(0, 0, expiryWarningDays+1).Before(expiresAt)
+}
+
+// ExpiringBatch is what is left of a batch that is about to expire, or has
+type ExpiringBatch struct {
+	Name      string  `json:"name"`
+	Unit      string  `json:"unit"`
+	Quantity  float64 `json:"quantity"`
+	ExpiresOn string  `json:"expiresOn"`
+	Value     float64 `json:"value"`
+	Expired   bool    `json:"expired"`
+}
+
+// ExpiringStock lists what is left of the batches that expire within the given days, or have expired without
+// being written off yet, soonest first
+func ExpiringStock(ctx context.Context, now time.Time, days int) ([]ExpiringBatch, error) {
+	statuses, err := StockStatuses(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	horizon := now.AddDate(0, 0, days+1)
+	expiring := []ExpiringBatch{}
+	for _, status := range statuses {
+		for _, batch := range status.Left {
+			expiresAt, perishes := batch.expiresAt()
+			if !perishes || !expiresAt.Before(horizon) {
+				continue
+			}
+			expiring = append(expiring, ExpiringBatch{
+				Name: status.Name, Unit: status.Unit, Quantity: batch.Quantity, ExpiresOn: batch.ExpiresOn,
+				Value: roundPaise(batch.Quantity * batch.UnitCost), Expired: batchExpired(batch, now),
+			})
+		}
+	}
+	slices.SortStableFunc(expiring, func(a, b ExpiringBatch) int { return strings.Compare(a.ExpiresOn, b.ExpiresOn) })
+	return expiring, nil
+}
+
+// Why stock was written off
+const (
+	WriteOffExpired = "expired"
+	WriteOffVoided  = "voided" // Used for a line voided after it went to the kitchen
+)
+
+// StockWriteOff is stock thrown away because it expired, or was cooked for a line that was then voided
+type StockWriteOff struct {
+	ID           primitive.ObjectID `bson:"_id" json:"id"`
+	Branch       string             `bson:"branch,omitempty" json:"branch,omitempty"`
+	Name         string             `bson:"name" json:"name"`
+	Unit         string             `bson:"unit" json:"unit"`
+	Quantity     float64            `bson:"quantity" json:"quantity"`
+	Value        float64            `bson:"value" json:"value"`
+	ExpiresOn    string             `bson:"expiresOn,omitempty" json:"expiresOn,omitempty"`
+	WrittenOffAt time.Time          `bson:"writtenOffAt" json:"writtenOffAt"`
+	Reason       string             `bson:"reason,omitempty" json:"reason,omitempty"`  // WriteOffExpired when empty, as before voids were written off
+	OrderID      primitive.ObjectID `bson:"orderId,omitempty" json:"orderId,omitzero"` // The order a voided line was on
+}
+
+// writeOffReason is why the stock was written off
+func writeOffReason(writeOff StockWriteOff) string {
+	if writeOff.Reason == "" {
+		return WriteOffExpired
+	}
+	return writeOff.Reason
+}
+
+// settleExpiry writes off what has expired and warns staff about what is about to. A level with either is
+// re-counted as what is left of it now, so written-off stock is not taken off again. A level changed meanwhile
+// is left for the next check.
+func settleExpiry(ctx context.Context, statuses []StockStatus, now time.Time) error {
+	for _, status := range statuses {
+		var writeOffs []StockWriteOff
+		var warn []StockBatch
+		batches := []StockBatch{}
+		for _, batch := range status.Left {
+			switch {
+			case batchExpired(batch, now):
+				writeOffs = append(writeOffs, StockWriteOff{
+					ID: primitive.NewObjectID(), Branch: status.Branch, Name: status.Name, Unit: status.Unit, Quantity: batch.Quantity,
+					Value: roundPaise(batch.Quantity * batch.UnitCost), ExpiresOn: batch.ExpiresOn, WrittenOffAt: now,
+					Reason: WriteOffExpired,
+				})
+				continue
+			case expiringSoon(batch, now) && !batch.Warned:
+				batch.Warned = true
+				warn = append(warn, batch)
+			}
+			batches = append(batches, batch)
+		}
+		if len(writeOffs) == 0 && len(warn) == 0 {
+			continue
+		}
+
+		level := status.StockLevel
+		level.Counted, level.CountedAt, level.Batches = status.OnHand, now, batches
+		err := storeFor(ctx).Stock().Save(ctx, level)
+		if errors.Is(err, ErrVersionConflict) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, writeOff := range writeOffs {
+			if err := storeFor(ctx).Stock().AddWriteOff(ctx, writeOff); err != nil {
+				return err
+			}
+		}
+		for _, batch := range warn {
+			Notify(ctx, Notification{
+				To:      stockAlertTo,
+				Name:    "Kitchen",
+				Subject: fmt.Sprintf("Use soon: %s", status.Name),
+				Message: fmt.Sprintf("%g %s of %s must be used by %s.", batch.Quantity, status.Unit, status.Name, batch.ExpiresOn),
+			})
+		}
+	}
+	return nil
+}
+
+// WastedItem is how much of one item was written off in a month for one reason
+type WastedItem struct {
+	Name     string  `json:"name"`
+	Unit     string  `json:"unit"`
+	Reason   string  `json:"reason"` // expired or voided
+	Quantity float64 `json:"quantity"`
+	Value    float64 `json:"value"`
+}
+
+// MonthlyWastage is the value of the stock written off in one month
+type MonthlyWastage struct {
+	Month string       `json:"month"` // YYYY-MM
+	Value float64      `json:"value"`
+	Items []WastedItem `json:"items"`
+}
+
+// WastageReport totals the stock this branch wrote off in [from, to) by month, and by item and reason within each month
+func WastageReport(ctx context.Context, from, to time.Time) ([]MonthlyWastage, error) {
+	writeOffs, err := storeFor(ctx).Stock().ListWriteOffs(ctx, currentBranch, from, to)
+	if err != nil {
+		return nil, err
+	}
+	months := []MonthlyWastage{}
+	for _, writeOff := range writeOffs {
+		month := writeOff.WrittenOffAt.Format("2006-01")
+		if len(months) == 0 || months[len(months)-1].Month != month {
+			months = append(months, MonthlyWastage{Month: month, Items: []WastedItem{}})
+		}
+		current := &months[len(months)-1]
+		current.Value += writeOff.Value
+		reason := writeOffReason(writeOff)
+		i := slices.IndexFunc(current.Items, func(item WastedItem) bool {
+			return item.Name == writeOff.Name && item.Unit == writeOff.Unit && item.Reason == reason
+		})
+		if i < 0 {
+			current.Items = append(current.Items, WastedItem{Name: writeOff.Name, Unit: writeOff.Unit, Reason: reason})
+			i = len(current.Items) - 1
+		}
+		current.Items[i].Quantity += writeOff.Quantity
+		current.Items[i].Value += writeOff.Value
+	}
+	for m := range months {
+		months[m].Value = roundPaise(months[m].Value)
+		for i := range months[m].Items {
+			months[m].Items[i].Quantity = roundPaise(months[m].Items[i].Quantity)
+			months[m].Items[i].Value = roundPaise(months[m].Items[i].Value)
+		}
+		slices.SortFunc(months[m].Items, func(a, b WastedItem) int {
+			return cmp.Or(strings.Compare(a.Name, b.Name), strings.Compare(a.Reason, b.Reason))
+		})
+	}
+	return months, nil
+}
+
+// ShowExpiringStock prints what expires within the given days, and what has expired
+func ShowExpiringStock(ctx context.Context, days int) error {
+	expiring, err := ExpiringStock(ctx, clock.Now(), days)
+	if err != nil {
+		return err
+	}
+	expiringListing := listing{
+		title:   fmt.Sprintf("Stock expiring within %d days:", days),
+		header:  []string{"Name", "Quantity", "Use by", "Value", ""},
+		records: expiring,
+	}
+	for _, batch := range expiring {
+		expired := ""
+		if batch.Expired {
+			expired = "EXPIRED"
+		}
+		expiringListing.rows = append(expiringListing.rows, []string{
+			batch.Name, fmt.Sprintf("%g %s", batch.Quantity, batch.Unit), batch.ExpiresOn, fmt.Sprintf("Rs %.2f", batch.Value), expired,
+		})
+		expiringListing.compact = append(expiringListing.compact, strings.TrimSpace(fmt.Sprintf("%s %g %s by %s %s",
+			batch.Name, batch.Quantity, batch.Unit, batch.ExpiresOn, expired)))
+	}
+	return printListing(os.Stdout, expiringListing)
+}
+
+// ShowWastage prints the value of the stock written off as expired in each month of [from, to)
+func ShowWastage(ctx context.Context, from, to time.Time) error {
+	months, err := WastageReport(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	wastageListing := listing{
+		title:   fmt.Sprintf("Stock written off from %s to %s:", from.Format("Jan 2006"), to.AddDate(0, 0, -1).Format("Jan 2006")),
+		header:  []string{"Month", "Item", "Reason", "Quantity", "Value"},
+		records: months,
+	}
+	for _, month := range months {
+		for _, item := range month.Items {
+			wastageListing.rows = append(wastageListing.rows, []string{
+				month.Month, item.Name, item.Reason, fmt.Sprintf("%g %s", item.Quantity, item.Unit), fmt.Sprintf("Rs %.2f", item.Value),
+			})
+		}
+		wastageListing.rows = append(wastageListing.rows, []string{month.Month, "Total", "", "", fmt.Sprintf("Rs %.2f", month.Value)})
+		wastageListing.compact = append(wastageListing.compact, fmt.Sprintf("%s Rs %.2f", month.Month, month.Value))
+	}
+	return printListing(os.Stdout, wastageListing)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Page sizes of the data exports
+const (
+	defaultExportLimit = 1000
+	maxExportLimit     = 10000
+)
+
+// exportSettle holds changes this recent back from exports until the next sync. A change is stamped before it
+// is stored, so one stamped just before a sync could otherwise be stored just after it, behind the watermark.
+const exportSettle = 5 * time.Second
+
+// ExportCursor is how far an incremental export has got: the watermark of the last record sent and its ID, which
+// orders records changed at the same instant. The zero cursor starts from the beginning.
+type ExportCursor struct {
+	After time.Time
+	ID    primitive.ObjectID
+}
+
+// String encodes the cursor for the X-Next-Cursor header, to be sent back as ?cursor
+func (c ExportCursor) String() string {
+	if c.After.IsZero() {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.After.UnixNano(), 10) + "." + c.ID.Hex()))
+}
+
+// ParseExportCursor reads a cursor from an earlier export; "" is the start
+func ParseExportCursor(s string) (ExportCursor, error) {
+	if s == "" {
+		return ExportCursor{}, nil
+	}
+	invalid := fmt.Errorf("cursor %q is not one an export returned", s)
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return ExportCursor{}, invalid
+	}
+	nanos, hex, found := strings.Cut(string(raw), ".")
+	at, err := strconv.ParseInt(nanos, 10, 64)
+	if !found || err != nil {
+		return ExportCursor{}, invalid
+	}
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return ExportCursor{}, invalid
+	}
+	return ExportCursor{After: time.Unix(0, at), ID: id}, nil
+}
+
+// ExportPage is one page of an export and the cursor to ask for the next from
+type ExportPage[T any] struct {
+	Records []T
+	Next    ExportCursor
+	More    bool // Another page is ready now; otherwise sync again from Next later
+}
+
+// ExportOrderLine is a line of an exported order. Every line of an order is exported again whenever the order
+// changes, so a warehouse replaces an order's lines by OrderID.
+type ExportOrderLine struct {
+	OrderID   primitive.ObjectID `json:"orderId"`
+	Line      int                `json:"line"` // Position on the order, from 1
+	UpdatedAt time.Time          `json:"updatedAt"`
+	OrderLine
+}
+
+// ExportOrders returns up to limit orders changed after the cursor, oldest change first
+func ExportOrders(ctx context.Context, cursor ExportCursor, limit int) (ExportPage[Order], error) {
+	orders, err := storeFor(ctx).Orders().ListUpdatedAfter(ctx, cursor.After, cursor.ID, clock.Now().Add(-exportSettle), limit+1)
+	if err != nil {
+		return ExportPage[Order]{}, err
+	}
+	page := ExportPage[Order]{Records: orders, Next: cursor, More: len(orders) > limit}
+	if page.More {
+		page.Records = orders[:limit]
+	}
+	for i := range page.Records {
+		// Orders stored before they kept a watermark were last changed when they were placed, as far as exports know
+		if page.Records[i].UpdatedAt.IsZero() {
+			page.Records[i].UpdatedAt = page.Records[i].CreatedAt
+		}
+	}
+	if n := len(page.Records); n > 0 {
+		page.Next = ExportCursor{After: page.Records[n-1].UpdatedAt, ID: page.Records[n-1].ID}
+	}
+	return page, nil
+}
+
+// ExportOrderLines returns the lines of up to limit orders changed after the cursor
+func ExportOrderLines(ctx context.Context, cursor ExportCursor, limit int) (ExportPage[ExportOrderLine], error) {
+	orders, err := ExportOrders(ctx, cursor, limit)
+	if err != nil {
+		return ExportPage[ExportOrderLine]{}, err
+	}
+	page := ExportPage[ExportOrderLine]{Records: []ExportOrderLine{}, Next: orders.Next, More: orders.More}
+	for _, order := range orders.Records {
+		for i, line := range order.Items {
+			page.Records = append(page.Records, ExportOrderLine{OrderID: order.ID, Line: i + 1, UpdatedAt: order.UpdatedAt, OrderLine: line})
+		}
+	}
+	return page, nil
+}
+
+// ExportPayments returns up to limit payments taken after the cursor, oldest first. Payments do not change once
+// taken, so their watermark is when they were taken.
+func ExportPayments(ctx context.Context, cursor ExportCursor, limit int) (ExportPage[Payment], error) {
+	payments, err := storeFor(ctx).Payments().ListAfter(ctx, cursor.After, cursor.ID, clock.Now().Add(-exportSettle), limit+1)
+	if err != nil {
+		return ExportPage[Payment]{}, err
+	}
+	page := ExportPage[Payment]{Records: payments, Next: cursor, More: len(payments) > limit}
+	if page.More {
+		page.Records = payments[:limit]
+	}
+	if n := len(page.Records); n > 0 {
+		page.Next = ExportCursor{After: page.Records[n-1].CreatedAt, ID: page.Records[n-1].ID}
+	}
+	return page, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Kinds of change on the live feed
+const (
+	ChangeOrder   = "order"
+	ChangePayment = "payment"
+)
+
+// feedHeartbeat is how often an idle feed sends a comment, so proxies do not close the connection
+const feedHeartbeat = 15 * time.Second
+
+// feedPollInterval is how often stores without change streams look for new changes
+const feedPollInterval = time.Second
+
+// feedSettle holds back changes this recent on stores without change streams, like exportSettle but short
+// enough for a kitchen screen
+const feedSettle = time.Second
+
+// ErrResumeToken is returned when the feed cannot resume from a token, because it is not one the feed sent or
+// the database no longer has the changes after it
+var ErrResumeToken = errors.New("the feed cannot resume from this token")
+
+// Change is an order or payment as it was just stored, on the live feed. Token resumes the feed after it.
+type Change struct {
+	Token   string   `json:"-"`
+	Kind    string   `json:"kind"`
+	Order   *Order   `json:"order,omitempty"`
+	Payment *Payment `json:"payment,omitempty"`
+}
+
+// pollChanges feeds changes on stores without change streams by reading orders and payments past the export
+// watermarks every feedPollInterval. The token is the two export cursors; an empty one starts from now.
+func pollChanges(ctx context.Context, orders OrderRepository, payments PaymentRepository, token string, changes chan<- Change) error {
+	orderCursor, paymentCursor, err := parseFeedToken(token)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(feedPollInterval)
+	defer ticker.Stop()
+	for {
+		until := clock.Now().Add(-feedSettle)
+		updated, err := orders.ListUpdatedAfter(ctx, orderCursor.After, orderCursor.ID, until, maxExportLimit)
+		if err != nil {
+			return err
+		}
+		taken, err := payments.ListAfter(ctx, paymentCursor.After, paymentCursor.ID, until, maxExportLimit)
+		if err != nil {
+			return err
+		}
+		for _, order := range updated {
+			if order.UpdatedAt.IsZero() {
+				order.UpdatedAt = order.CreatedAt
+			}
+			orderCursor = ExportCursor{After: order.UpdatedAt, ID: order.ID}
+			select {
+			case changes <- Change{Token: feedToken(orderCursor, paymentCursor), Kind: ChangeOrder, Order: &order}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		for _, payment := range taken {
+			paymentCursor = ExportCursor{After: payment.CreatedAt, ID: payment.ID}
+			select {
+			case changes <- Change{Token: feedToken(orderCursor, paymentCursor), Kind: ChangePayment, Payment: &payment}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// feedToken joins the order and payment cursors a polled feed has reached
+func feedToken(orders, payments ExportCursor) string {
+	return orders.String() + "~" + payments.String()
+}
+
+// parseFeedToken splits a polled feed's token into its cursors; an empty token is now
+func parseFeedToken(token string) (ExportCursor, ExportCursor, error) {
+	if token == "" {
+		now := ExportCursor{After: clock.Now().Add(-feedSettle)}
+		return now, now, nil
+	}
+	orders, payments, found := strings.Cut(token, "~")
+	orderCursor, orderErr := ParseExportCursor(orders)
+	paymentCursor, paymentErr := ParseExportCursor(payments)
+	if !found || orderErr != nil || paymentErr != nil {
+		return ExportCursor{}, ExportCursor{}, fmt.Errorf("%w: %q is not one the feed sent", ErrResumeToken, token)
+	}
+	return orderCursor, paymentCursor, nil
+}
+
+// handleFeed streams orders and payments as they are stored, as server-sent events named after the kind of
+// change, each with the whole order or payment. Every event's id is a resume token: a client that reconnects
+// with it in Last-Event-ID, as browsers' EventSource do, or in ?resume, picks up where it left off. Without one
+// the feed starts from now.
+func handleFeed(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("Last-Event-ID")
+	if token == "" {
+		token = r.URL.Query().Get("resume")
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	changes := make(chan Change)
+	failed := make(chan error, 1)
+	go func() {
+		failed <- storeFor(ctx).Changes().Watch(ctx, token, changes)
+	}()
+
+	// A bad resume token or an unreachable database fails the request before the stream starts
+	var first *Change
+	select {
+	case change := <-changes:
+		first = &change
+	case err := <-failed:
+		if err == nil {
+			return
+		}
+		if errors.Is(err, ErrResumeToken) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeStoreError(w, err)
+		return
+	case <-time.After(feedPollInterval):
+	}
+
+	flusher := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	send := func(change Change) bool {
+		data, err := json.Marshal(change)
+		if err != nil {
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", change.Token, change.Kind, data); err != nil {
+			return false
+		}
+		return flusher.Flush() == nil
+	}
+	if first != nil && !send(*first) {
+		return
+	}
+	heartbeat := time.NewTicker(feedHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case change := <-changes:
+			if !send(change) {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil || flusher.Flush() != nil {
+				return
+			}
+		case err := <-failed:
+			if err != nil {
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", strings.ReplaceAll(err.Error(), "\n", " "))
+				flusher.Flush()
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxFlagReason caps how long the reason for flagging a customer can be
+const maxFlagReason = 200
+
+// CustomerFlag warns staff about a customer, e.g. a chronic no-show or someone who abused chargebacks.
+// A flagged customer can only book a table or get credit with a manager's approval.
+type CustomerFlag struct {
+	Reason    string    `bson:"reason" json:"reason"`
+	FlaggedBy string    `bson:"flaggedBy" json:"flaggedBy"`
+	FlaggedAt time.Time `bson:"flaggedAt" json:"flaggedAt"`
+}
+
+// FlaggedError stops a reservation or credit for a flagged customer. Staff can go ahead once a manager
+// approves, by naming them as the approver.
+type FlaggedError struct {
+	Customer string
+	Flag     CustomerFlag
+}
+
+func (e *FlaggedError) Error() string {
+	return fmt.Sprintf("%s is flagged (%s) and needs a manager's approval", e.Customer, e.Flag.Reason)
+}
+
+// FlagCustomer flags a customer with the reason, creating the customer if needed. With a version other
+// than 0 the customer must exist and still be at that version.
+func FlagCustomer(ctx context.Context, name string, version int, reason, by string) (Customer, error) {
+	reason, by = strings.TrimSpace(reason), strings.TrimSpace(by)
+	if reason == "" || by == "" {
+		return Customer{}, fmt.Errorf("a reason and who is flagging the customer are required")
+	}
+	if utf8.RuneCountInString(reason) > maxFlagReason {
+		return Customer{}, fmt.Errorf("the reason must be at most %d characters", maxFlagReason)
+	}
+	return setCustomerFlag(ctx, name, version, &CustomerFlag{Reason: reason, FlaggedBy: by, FlaggedAt: clock.Now()})
+}
+
+// ClearCustomerFlag lifts a customer's flag
+func ClearCustomerFlag(ctx context.Context, name string, version int) (Customer, error) {
+	if _, err := storeFor(ctx).Customers().FindByName(ctx, strings.TrimSpace(name)); err != nil {
+		return Customer{}, err
+	}
+	return setCustomerFlag(ctx, name, version, nil)
+}
+
+func setCustomerFlag(ctx context.Context, name string, version int, flag *CustomerFlag) (Customer, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Customer{}, fmt.Errorf("customer name is required")
+	}
+	err := storeFor(ctx).Customers().SetFlag(ctx, name, version, flag)
+	if errors.Is(err, ErrVersionConflict) {
+		return Customer{}, fmt.Errorf("%s %w", name, ErrVersionConflict)
+	}
+	if err != nil {
+		return Customer{}, err
+	}
+	return storeFor(ctx).Customers().FindByName(ctx, name)
+}
+
+// checkFlag returns a *FlaggedError if the customer is flagged and no manager approved going ahead.
+// Someone who is not a customer yet has no flag.
+func checkFlag(ctx context.Context, name, approvedBy string) error {
+	if strings.TrimSpace(approvedBy) != "" {
+		return nil
+	}
+	customer, err := storeFor(ctx).Customers().FindByName(ctx, name)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if customer.Flag != nil {
+		return &FlaggedError{Customer: customer.Name, Flag: *customer.Flag}
+	}
+	return nil
+}
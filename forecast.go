@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RecipeIngredient is how much of an ingredient goes into one serving of a menu item
+type RecipeIngredient struct {
+	Ingredient string  `bson:"ingredient" json:"ingredient"`
+	Quantity   float64 `bson:"quantity" json:"quantity"`
+	Unit       string  `bson:"unit" json:"unit"` // e.g. kg, l, piece
+}
+
+// validateRecipe trims the ingredients and rejects ones without a name or unit, with a quantity that is not
+// positive, or listed twice
+func validateRecipe(recipe []RecipeIngredient) error {
+	var seen []string
+	for i := range recipe {
+		ingredient := &recipe[i]
+		ingredient.Ingredient = strings.ToLower(strings.TrimSpace(ingredient.Ingredient))
+		ingredient.Unit = strings.ToLower(strings.TrimSpace(ingredient.Unit))
+		if ingredient.Ingredient == "" || ingredient.Unit == "" {
+			return fmt.Errorf("every recipe ingredient needs a name and a unit")
+		}
+		if ingredient.Quantity <= 0 {
+			return fmt.Errorf("%s: the quantity must be positive", ingredient.Ingredient)
+		}
+		if slices.Contains(seen, ingredient.Ingredient) {
+			return fmt.Errorf("%s is in the recipe twice", ingredient.Ingredient)
+		}
+		seen = append(seen, ingredient.Ingredient)
+	}
+	return nil
+}
+
+// parseRecipe reads recipe ingredients written as "ingredient=quantity unit", e.g. "mozzarella=0.12 kg"
+func parseRecipe(entries []string) ([]RecipeIngredient, error) {
+	var recipe []RecipeIngredient
+	for _, entry := range entries {
+		name, amount, ok := strings.Cut(entry, "=")
+		quantity, unit, _ := strings.Cut(strings.TrimSpace(amount), " ")
+		parsed, err := strconv.ParseFloat(quantity, 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid recipe ingredient %q (want ingredient=quantity unit)", entry)
+		}
+		recipe = append(recipe, RecipeIngredient{Ingredient: name, Quantity: parsed, Unit: unit})
+	}
+	if err := validateRecipe(recipe); err != nil {
+		return nil, err
+	}
+	return recipe, nil
+}
+
+// ItemForecast is how many servings of a menu item are expected to sell on each day of the forecast week
+type ItemForecast struct {
+	Item  string    `json:"item"`
+	Daily []float64 `json:"daily"` // One per day of the week, starting on the forecast's From
+	Total float64   `json:"total"`
+}
+
+// IngredientNeed is how much of an ingredient the forecast sales use up
+type IngredientNeed struct {
+	Ingredient string   `json:"ingredient"`
+	Unit       string   `json:"unit"`
+	Quantity   float64  `json:"quantity"` // With the buffer added
+	Items      []string `json:"items"`    // The menu items that use it
+}
+
+// SalesForecast is the expected sales of each menu item in the week [From, To), and the ingredients to buy for them
+type SalesForecast struct {
+	From        time.Time        `json:"from"`
+	To          time.Time        `json:"to"`
+	Weeks       int              `json:"weeks"`  // Weeks of history averaged
+	Buffer      float64          `json:"buffer"` // Percentage added to the ingredients for waste and surprises
+	Items       []ItemForecast   `json:"items"`
+	Ingredients []IngredientNeed `json:"ingredients"`
+	NoRecipe    []string         `json:"noRecipe"` // Items expected to sell that have no recipe, so buy nothing
+}
+
+// ForecastSales projects each item's sales for the week starting on from with a seasonal moving average: each day
+// is forecast as the average sold on the same day of the week over the weeks of history before from. The servings
+// are then turned into an ingredient purchase list through the menu's recipes, with the buffer percentage on top.
+func ForecastSales(ctx context.Context, from time.Time, weeks int, buffer float64) (SalesForecast, error) {
+	if weeks < 1 {
+		return SalesForecast{}, fmt.Errorf("at least one week of history is needed")
+	}
+	if buffer < 0 {
+		return SalesForecast{}, fmt.Errorf("the buffer must not be negative")
+	}
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	orders, err := LoadOrdersBetween(ctx, from.AddDate(0, 0, -7*weeks), from)
+	if err != nil {
+		return SalesForecast{}, err
+	}
+	// Servings sold by item and day of the week, over all the weeks of history
+	sold := map[string]*[7]float64{}
+	for _, order := range orders {
+		for _, line := range order.Items {
+			if sold[line.Name] == nil {
+				sold[line.Name] = &[7]float64{}
+			}
+			sold[line.Name][order.CreatedAt.Weekday()] += float64(line.Quantity)
+		}
+	}
+
+	forecast := SalesForecast{
+		From: from, To: from.AddDate(0, 0, 7), Weeks: weeks, Buffer: buffer,
+		Items: []ItemForecast{}, Ingredients: []IngredientNeed{}, NoRecipe: []string{},
+	}
+	menu := LoadMenu(ctx)
+	needs := map[string]*IngredientNeed{}
+	for name, byWeekday := range sold {
+		row := ItemForecast{Item: name}
+		for day := range 7 {
+			servings := roundPaise(byWeekday[from.AddDate(0, 0, day).Weekday()] / float64(weeks))
+			row.Daily = append(row.Daily, servings)
+			row.Total += servings
+		}
+		row.Total = roundPaise(row.Total)
+		forecast.Items = append(forecast.Items, row)
+
+		item, found := FindMenuItem(menu, name)
+		if !found || len(item.Recipe) == 0 {
+			forecast.NoRecipe = append(forecast.NoRecipe, name)
+			continue
+		}
+		for _, ingredient := range item.Recipe {
+			key := ingredient.Ingredient + "\x00" + ingredient.Unit
+			if needs[key] == nil {
+				needs[key] = &IngredientNeed{Ingredient: ingredient.Ingredient, Unit: ingredient.Unit}
+			}
+			needs[key].Quantity += ingredient.Quantity * row.Total
+			needs[key].Items = append(needs[key].Items, name)
+		}
+	}
+	for _, need := range needs {
+		// Rounded up, so the rounding never leaves the kitchen short
+		need.Quantity = math.Ceil(need.Quantity*(1+buffer/100)*100) / 100
+		slices.Sort(need.Items)
+		forecast.Ingredients = append(forecast.Ingredients, *need)
+	}
+	slices.SortFunc(forecast.Items, func(a, b ItemForecast) int { return strings.Compare(a.Item, b.Item) })
+	slices.SortFunc(forecast.Ingredients, func(a, b IngredientNeed) int {
+		return strings.Compare(a.Ingredient+" "+a.Unit, b.Ingredient+" "+b.Unit)
+	})
+	slices.Sort(forecast.NoRecipe)
+	return forecast, nil
+}
+
+// ShowSalesForecast prints the expected sales of each item for the week starting on from, and what to buy for them
+func ShowSalesForecast(ctx context.Context, from time.Time, weeks int, buffer float64) error {
+	forecast, err := ForecastSales(ctx, from, weeks, buffer)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		printResult("", forecast)
+		return nil
+	}
+	fmt.Printf("Forecast for %s to %s from the last %d weeks:\n", forecast.From.Format("02 Jan 2006"),
+		forecast.To.AddDate(0, 0, -1).Format("02 Jan 2006"), weeks)
+	header := []string{"Item"}
+	for day := range 7 {
+		header = append(header, forecast.From.AddDate(0, 0, day).Format("Mon"))
+	}
+	header = append(header, "Week")
+	var itemRows [][]string
+	for _, item := range forecast.Items {
+		row := []string{item.Item}
+		for _, servings := range item.Daily {
+			row = append(row, fmt.Sprintf("%.1f", servings))
+		}
+		itemRows = append(itemRows, append(row, fmt.Sprintf("%.1f", item.Total)))
+	}
+	if err := writeTable(os.Stdout, header, itemRows); err != nil {
+		return err
+	}
+
+	fmt.Printf("To buy, with a %.0f%% buffer:\n", buffer)
+	var ingredientRows [][]string
+	for _, need := range forecast.Ingredients {
+		ingredientRows = append(ingredientRows, []string{
+			need.Ingredient, fmt.Sprintf("%.2f %s", need.Quantity, need.Unit), strings.Join(need.Items, ", "),
+		})
+	}
+	if err := writeTable(os.Stdout, []string{"Ingredient", "Quantity", "Used in"}, ingredientRows); err != nil {
+		return err
+	}
+	if len(forecast.NoRecipe) > 0 {
+		fmt.Printf("No recipe, so nothing to buy for: %s\n", strings.Join(forecast.NoRecipe, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// idempotencyTTL is how long a key is remembered; a retry after that is treated as a new request
+const idempotencyTTL = 24 * time.Hour
+
+// maxIdempotencyKey caps the length of an Idempotency-Key header
+const maxIdempotencyKey = 255
+
+// IdempotencyRecord remembers a request made with an Idempotency-Key and, once it has been handled,
+// the response to send again if the request is retried
+type IdempotencyRecord struct {
+	Key         string    `bson:"_id" json:"key"`                 // Tenant/API key id/Idempotency-Key
+	RequestHash string    `bson:"requestHash" json:"requestHash"` // Of the method, path and body, to spot a key reused for another request
+	Done        bool      `bson:"done" json:"done"`
+	Status      int       `bson:"status,omitempty" json:"status,omitempty"`
+	ContentType string    `bson:"contentType,omitempty" json:"contentType,omitempty"`
+	Body        []byte    `bson:"body,omitempty" json:"body,omitempty"`
+	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// responseRecorder keeps a copy of the response written, to store for replays
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+// idempotent lets clients safely retry a request that creates something by sending an Idempotency-Key
+// header: the first request with the key is handled and its response stored, and retries get that same
+// response back instead of creating a duplicate. Requests without the header are handled as usual.
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || IsOffline() {
+			// While offline nothing can be remembered, and the request is queued like any other
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			writeError(w, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "could not read the request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.New()
+		io.WriteString(hash, r.Method+" "+r.URL.Path+"\n")
+		hash.Write(body)
+
+		// Keys are chosen by clients, so one client's key must not find another's response, least of all another tenant's
+		ctx := r.Context()
+		var caller string
+		if apiKey, ok := APIKeyFrom(ctx); ok {
+			caller = apiKey.ID.Hex()
+		}
+		record := IdempotencyRecord{Key: callerTenantID(r) + "/" + caller + "/" + key, RequestHash: hex.EncodeToString(hash.Sum(nil)), CreatedAt: time.Now()}
+		err = storeFor(ctx).Idempotency().Begin(ctx, record, record.CreatedAt.Add(-idempotencyTTL))
+		if storeFor(ctx).Unavailable(err) {
+			next(w, r)
+			return
+		}
+		if errors.Is(err, ErrDuplicate) {
+			replayResponse(w, r, record)
+			return
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+		ctx = context.WithoutCancel(ctx)
+		if recorder.status >= http.StatusInternalServerError {
+			// Nothing was created, so let the client try again with the same key
+			err = storeFor(ctx).Idempotency().Delete(ctx, record.Key)
+		} else {
+			record.Done, record.Status, record.Body = true, recorder.status, recorder.body.Bytes()
+			record.ContentType = recorder.Header().Get("Content-Type")
+			err = storeFor(ctx).Idempotency().Complete(ctx, record)
+		}
+		if err != nil {
+			log.Printf("Error storing the response for Idempotency-Key %q: %v", key, err)
+		}
+	}
+}
+
+// replayResponse answers a retry with the response stored for its key
+func replayResponse(w http.ResponseWriter, r *http.Request, request IdempotencyRecord) {
+	stored, err := storeFor(r.Context()).Idempotency().Find(r.Context(), request.Key)
+	switch {
+	case err != nil:
+		writeStoreError(w, err)
+	case stored.RequestHash != request.RequestHash:
+		writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+	case !stored.Done:
+		writeError(w, http.StatusConflict, "a request with this Idempotency-Key is still being handled")
+	default:
+		if stored.ContentType != "" {
+			w.Header().Set("Content-Type", stored.ContentType)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(stored.Status)
+		w.Write(stored.Body)
+	}
+}
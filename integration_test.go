@@ -0,0 +1,371 @@
+//go:build integration
+
+// Integration tests run complete flows against a real MongoDB through the real repositories. They start a throwaway
+// MongoDB in Docker with testcontainers, or use the server at RMS_TEST_MONGO_URI when it is set:
+//
+//	go test -tags integration -run Integration ./...
+//	RMS_TEST_MONGO_URI=mongodb://localhost:27017 go test -tags integration -run Integration ./...
+//
+// Every test works in databases of its own, dropped when it ends.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoImage is the MongoDB started when RMS_TEST_MONGO_URI is not set
+const mongoImage = "mongo:7"
+
+// integrationMongoURI is where the MongoDB the tests use listens
+var integrationMongoURI string
+
+func TestMain(m *testing.M) {
+	integrationMongoURI = os.Getenv("RMS_TEST_MONGO_URI")
+	if integrationMongoURI != "" {
+		os.Exit(m.Run())
+	}
+	ctx := context.Background()
+	container, err := mongodb.Run(ctx, mongoImage)
+	if err != nil {
+		log.Fatalf("starting MongoDB (set RMS_TEST_MONGO_URI to use a running one): %v", err)
+	}
+	integrationMongoURI, err = container.ConnectionString(ctx)
+	if err != nil {
+		testcontainers.TerminateContainer(container)
+		log.Fatalf("reading the MongoDB address: %v", err)
+	}
+	code := m.Run()
+	if err := testcontainers.TerminateContainer(container); err != nil {
+		log.Printf("stopping MongoDB: %v", err)
+	}
+	os.Exit(code)
+}
+
+// integrationStore opens a store on a database of the test's own and makes it the one the app uses. The config it
+// returns opens tenants' databases next to it; all of them are dropped when the test ends.
+func integrationStore(t *testing.T) Config {
+	t.Helper()
+	ctx := context.Background()
+	cfg := Config{Backend: BackendMongo, MongoURI: integrationMongoURI, MongoDatabase: fmt.Sprintf("rms_it_%d", time.Now().UnixNano())}
+	s, err := OpenMongoStore(cfg.MongoURI, cfg.MongoDatabase)
+	if err != nil {
+		t.Fatalf("connecting to MongoDB at %s: %v", cfg.MongoURI, err)
+	}
+	if err := s.Migrate(ctx); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	previous := store
+	store = s
+	t.Cleanup(func() {
+		if tenants != nil {
+			tenants.Close(ctx)
+			tenants = nil
+		}
+		store = previous
+		s.Close(ctx)
+		dropDatabases(t, cfg.MongoDatabase)
+	})
+	return cfg
+}
+
+// dropDatabases drops the test's database and its tenants' databases, which are named after it
+func dropDatabases(t *testing.T, prefix string) {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(integrationMongoURI))
+	if err != nil {
+		t.Logf("connecting to drop the test databases: %v", err)
+		return
+	}
+	defer client.Disconnect(ctx)
+	names, err := client.ListDatabaseNames(ctx, bson.M{"name": bson.M{"$regex": "^" + prefix}})
+	if err != nil {
+		t.Logf("listing the test databases: %v", err)
+		return
+	}
+	for _, name := range names {
+		if err := client.Database(name).Drop(ctx); err != nil {
+			t.Logf("dropping %s: %v", name, err)
+		}
+	}
+}
+
+// apiClient calls the API of a test server with an API key
+type apiClient struct {
+	t      *testing.T
+	server *httptest.Server
+	key    string
+}
+
+// call sends body as JSON, checks the response has the status wanted and decodes it into out, if given
+func (c apiClient) call(method, path string, body any, want int, out any) {
+	c.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, c.server.URL+path, reader)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.key)
+	}
+	res, err := c.server.Client().Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer res.Body.Close()
+	raw, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if res.StatusCode != want {
+		c.t.Fatalf("%s %s: got %d, want %d: %s", method, path, res.StatusCode, want, strings.TrimSpace(string(raw)))
+	}
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			c.t.Fatalf("%s %s: decoding %s: %v", method, path, raw, err)
+		}
+	}
+}
+
+// dailyTotals adds up the hourly sales of a day
+func dailyTotals(sales []HourlySales) (orders int, revenue float64) {
+	for _, hour := range sales {
+		orders += hour.Orders
+		revenue += hour.Revenue
+	}
+	return orders, roundPaise(revenue)
+}
+
+// TestIntegrationSignupToReport signs a restaurant up on the hosted service and takes it from its first menu item to
+// an order, its bill, its payment and the day's sales report, all through the API
+func TestIntegrationSignupToReport(t *testing.T) {
+	EnableTenants(integrationStore(t))
+	server := httptest.NewServer(NewServer(true))
+	defer server.Close()
+	api := apiClient{t: t, server: server}
+
+	var signup struct {
+		Tenant Tenant `json:"tenant"`
+		APIKey string `json:"apiKey"`
+	}
+	api.call("POST", "/api/tenants", map[string]string{"name": "Integration Diner"}, http.StatusCreated, &signup)
+	if signup.Tenant.ID != "integration_diner" || signup.APIKey == "" {
+		t.Fatalf("signup returned tenant %q and key %q", signup.Tenant.ID, signup.APIKey)
+	}
+	api.call("GET", "/api/menu", nil, http.StatusUnauthorized, nil)
+	api.key = signup.APIKey
+
+	api.call("POST", "/api/menu", MenuItem{Name: "Masala Dosa", Price: 120, Category: "mains"}, http.StatusCreated, nil)
+	api.call("POST", "/api/menu", MenuItem{Name: "Filter Coffee", Price: 40}, http.StatusCreated, nil)
+	var menu []MenuItem
+	api.call("GET", "/api/menu", nil, http.StatusOK, &menu)
+	if len(menu) != 2 {
+		t.Fatalf("the tenant's menu has %d items, want 2", len(menu))
+	}
+
+	var placed orderResponse
+	api.call("POST", "/api/orders", map[string]any{
+		"customerName": "Asha", "type": OrderDineIn,
+		"items": []map[string]any{{"name": "Masala Dosa", "quantity": 2}, {"name": "Filter Coffee", "quantity": 1}},
+	}, http.StatusCreated, &placed)
+	order := placed.Order
+	if order.Total != 280 {
+		t.Fatalf("order total is %.2f, want 280.00", order.Total)
+	}
+
+	var invoice Invoice
+	api.call("POST", "/api/orders/"+order.ID.Hex()+"/invoice", nil, http.StatusOK, &invoice)
+	if invoice.Number == "" || invoice.Total != order.Total {
+		t.Fatalf("invoice %q totals %.2f, want %.2f", invoice.Number, invoice.Total, order.Total)
+	}
+
+	var payment Payment
+	api.call("POST", "/api/orders/"+order.ID.Hex()+"/payments", Payment{Method: PaymentCard, Amount: order.Total}, http.StatusCreated, &payment)
+	if payment.ID.IsZero() || payment.Amount != order.Total {
+		t.Fatalf("payment of %.2f recorded as %+v", order.Total, payment)
+	}
+	var bills []Order
+	api.call("GET", "/api/bills", nil, http.StatusOK, &bills)
+	if len(bills) != 0 {
+		t.Fatalf("%d bills still unsettled after paying in full", len(bills))
+	}
+
+	var sales []HourlySales
+	api.call("GET", "/api/reports/daily-sales", nil, http.StatusOK, &sales)
+	if orders, revenue := dailyTotals(sales); orders != 1 || revenue != order.Total {
+		t.Fatalf("daily sales show %d orders for %.2f, want 1 for %.2f", orders, revenue, order.Total)
+	}
+}
+
+// TestIntegrationKitchenToPayment takes a table order on a single restaurant's store through the kitchen, adds to it,
+// bills it and settles it in two payments, then checks what the repositories hold
+func TestIntegrationKitchenToPayment(t *testing.T) {
+	integrationStore(t)
+	ctx := context.Background()
+	AddMenuItems(ctx)
+	menu := LoadMenu(ctx)
+	pizza, found := FindMenuItem(menu, "Pizza")
+	if !found {
+		t.Fatal("the sample menu has no Pizza")
+	}
+	burger, _ := FindMenuItem(menu, "Burger")
+
+	order, err := SubmitOrder(ctx, Order{CustomerName: "Ravi", Table: 4, Type: OrderDineIn, Covers: 2, Items: AddToCart(nil, pizza, 1)})
+	if err != nil {
+		t.Fatalf("placing the order: %v", err)
+	}
+	if order, err = AddOrderItem(ctx, order.ID, order.Version, burger, OrderLine{Quantity: 2}, false, false); err != nil {
+		t.Fatalf("adding to the order: %v", err)
+	}
+	for order.Status != StatusServed {
+		if _, err := AdvanceOrderStatus(ctx, order, 0); err != nil {
+			t.Fatalf("advancing the order from %s: %v", order.Status, err)
+		}
+		if order, err = FindOrder(ctx, order.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := roundPaise(pizza.Price + 2*burger.Price); order.Total != want {
+		t.Fatalf("order total is %.2f, want %.2f", order.Total, want)
+	}
+
+	invoice, err := IssueInvoice(ctx, order.ID)
+	if err != nil {
+		t.Fatalf("issuing the invoice: %v", err)
+	}
+	if again, err := IssueInvoice(ctx, order.ID); err != nil || again.Number != invoice.Number {
+		t.Fatalf("issuing the invoice again gave %q (%v), want %q", again.Number, err, invoice.Number)
+	}
+
+	half := roundPaise(order.Total / 2)
+	for _, payment := range []Payment{{OrderID: order.ID, Method: PaymentUPI, Amount: half}, {OrderID: order.ID, Method: PaymentCard, Amount: roundPaise(order.Total - half)}} {
+		if _, err := RecordPayment(ctx, payment); err != nil {
+			t.Fatalf("paying %.2f by %s: %v", payment.Amount, payment.Method, err)
+		}
+	}
+	if order, err = FindOrder(ctx, order.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !order.Paid || order.AmountPaid != order.Total {
+		t.Fatalf("order paid %.2f of %.2f (paid %v)", order.AmountPaid, order.Total, order.Paid)
+	}
+	payments, err := store.Payments().ListBetween(ctx, order.CreatedAt, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 2 {
+		t.Fatalf("%d payments stored, want 2", len(payments))
+	}
+	events, err := store.Events().ListForOrder(ctx, order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) < 5 || events[0].Type != EventOrderCreated {
+		t.Fatalf("the order's stream has %d events, want the order created, the item added, its statuses and payments", len(events))
+	}
+
+	sales, err := DailySales(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orders, revenue := dailyTotals(sales); orders != 1 || revenue != order.Total {
+		t.Fatalf("daily sales show %d orders for %.2f, want 1 for %.2f", orders, revenue, order.Total)
+	}
+	if _, err := storeFor(ctx).Orders().Find(ctx, primitive.NewObjectID()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("finding an order that does not exist gave %v, want ErrNotFound", err)
+	}
+}
+
+// TestIntegrationManualClock pins the app's clock to check what turns on the time of day: an item going off the
+// menu when its serving hours end, and a reservation that can only be marked a no-show once its grace period has
+// passed, forfeiting the deposit and leaving its table free
+func TestIntegrationManualClock(t *testing.T) {
+	integrationStore(t)
+	ctx := context.Background()
+	AddMenuItems(ctx)
+	AddTables(ctx)
+	menu := LoadMenu(ctx)
+	salad, found := FindMenuItem(menu, "Salad")
+	if !found {
+		t.Fatal("the sample menu has no Salad")
+	}
+	clk := NewManualClock(time.Date(2026, time.March, 2, 10, 59, 0, 0, time.Local))
+	SetClock(clk)
+	if err := SetMenuHours("starters=07:00-11:00"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		SetClock(nil)
+		SetMenuHours("")
+	})
+
+	if _, err := SubmitOrder(ctx, Order{CustomerName: "Ravi", Type: OrderTakeaway, Items: AddToCart(nil, salad, 1)}); err != nil {
+		t.Fatalf("ordering a starter at 10:59: %v", err)
+	}
+	clk.Advance(time.Minute)
+	var unavailable *UnavailableError
+	if _, err := SubmitOrder(ctx, Order{CustomerName: "Ravi", Type: OrderTakeaway, Items: AddToCart(nil, salad, 1)}); !errors.As(err, &unavailable) {
+		t.Fatalf("ordering a starter at 11:00 gave %v, want it unavailable", err)
+	}
+	if ItemAvailable(salad, clk.Now()) || !ItemAvailable(salad, clk.Now().Add(20*time.Hour)) {
+		t.Fatal("starters should be off the menu from 11:00 until 07:00")
+	}
+
+	at := time.Date(2026, time.March, 2, 19, 0, 0, 0, time.Local)
+	reservation, err := BookReservation(ctx, Reservation{CustomerName: "Meera", Table: 3, PartySize: 4, At: at})
+	if err != nil {
+		t.Fatalf("booking: %v", err)
+	}
+	if reservation, err = TakeDeposit(ctx, reservation.ID, Payment{Method: PaymentCard, Amount: 500}); err != nil {
+		t.Fatalf("taking the deposit: %v", err)
+	}
+	clk.Set(at.Add(depositPolicy.NoShowGrace - time.Minute))
+	if _, err := MarkNoShow(ctx, reservation.ID); err == nil {
+		t.Fatal("the party was marked a no-show before its grace period was up")
+	}
+	clk.Advance(time.Minute)
+	if reservation, err = MarkNoShow(ctx, reservation.ID); err != nil {
+		t.Fatalf("marking a no-show once the grace period was up: %v", err)
+	}
+	if reservation.Status != ReservationNoShow || reservation.Forfeited != 500 || reservation.DepositStatus != DepositForfeited {
+		t.Fatalf("no-show is %s with Rs %.2f forfeited (%s), want the whole deposit forfeited", reservation.Status, reservation.Forfeited, reservation.DepositStatus)
+	}
+	if _, err := SeatReservation(ctx, reservation.ID, primitive.NilObjectID); err == nil {
+		t.Fatal("a no-show's party was seated")
+	}
+	tables, err := LoadTables(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		if table.Number == 3 && table.Occupied {
+			t.Fatal("the no-show's table is held as occupied")
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultSAC is the GST services code for restaurant service, used for items without a code of their own
+const DefaultSAC = "996331"
+
+// GSTSettings are the restaurant's tax registration and invoice numbering, from the config
+type GSTSettings struct {
+	GSTIN         string  // Printed on every invoice
+	Rate          float64 // Default GST percentage included in menu prices, split equally into CGST and SGST
+	InvoicePrefix string  // Put before the financial year in invoice numbers, e.g. "MUM/"
+}
+
+// gst holds the GST settings in effect
+var gst = GSTSettings{Rate: 5}
+
+// SetGST reads the GST settings from the config
+func SetGST(cfg Config) error {
+	settings := GSTSettings{GSTIN: cfg.GSTIN, Rate: 5, InvoicePrefix: cfg.InvoicePrefix}
+	if cfg.GSTRate != "" {
+		rate, err := strconv.ParseFloat(cfg.GSTRate, 64)
+		if err != nil || rate < 0 || rate > 100 {
+			return fmt.Errorf("invalid GST rate %q (want a percentage)", cfg.GSTRate)
+		}
+		settings.Rate = rate
+	}
+	gst = settings
+	return nil
+}
+
+// Invoice is the tax invoice for an order. Invoice numbers run without gaps from 1 in each
+// financial year, which in India starts on 1 April.
+type Invoice struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Number        string             `bson:"number" json:"number"` // e.g. FY24-25/000123
+	FinancialYear string             `bson:"financialYear" json:"financialYear"`
+	Seq           int                `bson:"seq" json:"seq"`
+	OrderID       primitive.ObjectID `bson:"orderId" json:"orderId"`
+	CustomerName  string             `bson:"customerName" json:"customerName"`
+	GSTIN         string             `bson:"gstin,omitempty" json:"gstin,omitempty"`
+	Lines         []InvoiceLine      `bson:"lines" json:"lines"`
+	Discount      float64            `bson:"discount,omitempty" json:"discount,omitempty"` // Percentage taken off every line's price
+	TaxableValue  float64            `bson:"taxableValue" json:"taxableValue"`
+	CGST          float64            `bson:"cgst" json:"cgst"`
+	SGST          float64            `bson:"sgst" json:"sgst"`
+	Taxes         []TaxLine          `bson:"taxes,omitempty" json:"taxes,omitempty"`       // Each tax by rate under its statutory label, e.g. CGST 2.5%
+	RoundOff      float64            `bson:"roundOff,omitempty" json:"roundOff,omitempty"` // Rounding the bill added, not a supply so not taxed
+	Total         float64            `bson:"total" json:"total"`
+	IssuedAt      time.Time          `bson:"issuedAt" json:"issuedAt"`
+}
+
+// InvoiceLine is one order line with the tax included in its price broken out
+type InvoiceLine struct {
+	Name         string  `bson:"name" json:"name"`
+	HSN          string  `bson:"hsn" json:"hsn"` // HSN or SAC code
+	Quantity     int     `bson:"quantity" json:"quantity"`
+	Price        float64 `bson:"price" json:"price"`
+	Weight       float64 `bson:"weight,omitempty" json:"weight,omitempty"`     // Kilograms of each, when the price is per kg
+	TaxClass     string  `bson:"taxClass,omitempty" json:"taxClass,omitempty"` // From RMS_TAX_CLASSES; empty for the default
+	Rate         float64 `bson:"rate" json:"rate"`                             // GST percentage, or Tax's
+	TaxableValue float64 `bson:"taxableValue" json:"taxableValue"`
+	CGST         float64 `bson:"cgst" json:"cgst"`
+	SGST         float64 `bson:"sgst" json:"sgst"`
+	Tax          string  `bson:"tax,omitempty" json:"tax,omitempty"`             // A tax outside GST charged instead, e.g. VAT on liquor
+	TaxAmount    float64 `bson:"taxAmount,omitempty" json:"taxAmount,omitempty"` // How much of it
+	Amount       float64 `bson:"amount" json:"amount"`
+}
+
+// maxInvoiceAttempts is how many times issuing retries when another terminal takes the same number first
+const maxInvoiceAttempts = 5
+
+// financialYear names the Indian financial year t falls in, e.g. FY24-25 for April 2024 to March 2025
+func financialYear(t time.Time) string {
+	start := t.Year()
+	if t.Month() < time.April {
+		start--
+	}
+	return fmt.Sprintf("FY%02d-%02d", start%100, (start+1)%100)
+}
+
+// roundPaise rounds an amount to the nearest paisa
+func roundPaise(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// itemTax returns the HSN/SAC code and tax class of a menu item, falling back to the defaults
+func itemTax(item MenuItem) (string, TaxClass) {
+	code := item.HSN
+	if code == "" {
+		code = DefaultSAC
+	}
+	return code, itemTaxClass(item)
+}
+
+// invoiceLine breaks the tax of its class out of a line's price or, onTop, adds it to a price that excluded it. Under
+// GST, CGST and SGST are half each; any odd paisa goes to SGST so the parts always add up to the amount charged.
+func invoiceLine(line OrderLine, code string, class TaxClass, onTop bool) InvoiceLine {
+	amount := roundPaise(line.Amount())
+	tax := roundPaise(amount - amount/(1+class.Rate/100))
+	if onTop {
+		tax = roundPaise(amount * class.Rate / 100)
+		amount = roundPaise(amount + tax)
+	}
+	taxed := InvoiceLine{
+		Name: line.Name, HSN: code, Quantity: line.Quantity, Price: line.Price, Weight: line.Weight, TaxClass: class.Name,
+		Rate: class.Rate, TaxableValue: roundPaise(amount - tax), Amount: amount,
+	}
+	if class.Tax != TaxGST {
+		taxed.Tax, taxed.TaxAmount = class.Tax, tax
+		return taxed
+	}
+	taxed.CGST = roundPaise(tax / 2)
+	taxed.SGST = roundPaise(tax - taxed.CGST)
+	return taxed
+}
+
+// buildInvoice works out the tax breakup of an order, using the codes and tax classes on the current menu, with a
+// tax line for each tax and rate. A discount is taken off each line's price before tax; charges are listed after
+// the lines.
+func buildInvoice(order Order, menu []MenuItem) Invoice {
+	invoice := Invoice{OrderID: order.ID, CustomerName: order.CustomerName, GSTIN: gst.GSTIN, Lines: []InvoiceLine{}, Discount: order.Discount}
+	for _, line := range order.Items {
+		if order.Discount != 0 {
+			line.Price = roundPaise(line.Price * (1 - order.Discount/100))
+		}
+		item, _ := FindMenuItem(menu, line.Name)
+		code, class := itemTax(item)
+		// Tax added on top is at the rate the line was ordered at, which the order's Total was worked out with
+		if order.TaxOnTop {
+			class.Rate = line.TaxRate
+		}
+		taxed := invoiceLine(line, code, class, order.TaxOnTop)
+		invoice.Lines = append(invoice.Lines, taxed)
+		invoice.Taxes = addTaxLines(invoice.Taxes, lineTaxes(taxed), 1)
+		invoice.TaxableValue += taxed.TaxableValue
+		invoice.CGST += taxed.CGST
+		invoice.SGST += taxed.SGST
+		invoice.Total += taxed.Amount
+	}
+	// Charges are services, taxed at the default rate; deposits are not a supply and are left off
+	for _, charge := range order.Charges {
+		if charge.Refundable {
+			continue
+		}
+		taxed := invoiceLine(OrderLine{Name: charge.Name, Price: charge.Amount, Quantity: 1}, DefaultSAC, TaxClass{Tax: TaxGST, Rate: gst.Rate}, order.TaxOnTop)
+		invoice.Lines = append(invoice.Lines, taxed)
+		invoice.Taxes = addTaxLines(invoice.Taxes, lineTaxes(taxed), 1)
+		invoice.TaxableValue += taxed.TaxableValue
+		invoice.CGST += taxed.CGST
+		invoice.SGST += taxed.SGST
+		invoice.Total += taxed.Amount
+	}
+	invoice.RoundOff = roundOff(order)
+	invoice.TaxableValue, invoice.CGST = roundPaise(invoice.TaxableValue), roundPaise(invoice.CGST)
+	invoice.SGST, invoice.Total = roundPaise(invoice.SGST), roundPaise(invoice.Total+invoice.RoundOff)
+	return invoice
+}
+
+// IssueInvoice returns the order's invoice, issuing it with the next number of the financial year if it
+// has none yet. A number is only used once the invoice is stored, so numbering has no gaps.
+func IssueInvoice(ctx context.Context, orderID primitive.ObjectID) (Invoice, error) {
+	invoice, err := storeFor(ctx).Invoices().FindByOrder(ctx, orderID)
+	if !errors.Is(err, ErrNotFound) {
+		return invoice, err
+	}
+	order, err := FindOrder(ctx, orderID)
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	for attempt := 0; attempt < maxInvoiceAttempts; attempt++ {
+		invoice = buildInvoice(order, LoadMenu(ctx))
+		invoice.ID = primitive.NewObjectID()
+		invoice.IssuedAt = clock.Now()
+		invoice.FinancialYear = financialYear(invoice.IssuedAt)
+		last, err := storeFor(ctx).Invoices().LastSeq(ctx, invoice.FinancialYear)
+		if err != nil {
+			return Invoice{}, err
+		}
+		invoice.Seq = last + 1
+		invoice.Number = fmt.Sprintf("%s%s/%06d", gst.InvoicePrefix, invoice.FinancialYear, invoice.Seq)
+
+		err = storeFor(ctx).Invoices().Insert(ctx, invoice)
+		if errors.Is(err, ErrDuplicate) {
+			// Either the number was taken or the order was invoiced meanwhile
+			if existing, err := storeFor(ctx).Invoices().FindByOrder(ctx, orderID); err == nil {
+				return existing, nil
+			}
+			continue
+		}
+		return invoice, err
+	}
+	return Invoice{}, fmt.Errorf("invoice numbers are being issued too fast, try again")
+}
+
+// FindInvoice loads an invoice by its number
+func FindInvoice(ctx context.Context, number string) (Invoice, error) {
+	return storeFor(ctx).Invoices().Find(ctx, number)
+}
+
+// ListInvoices returns the invoices issued in [from, to), oldest first
+func ListInvoices(ctx context.Context, from, to time.Time) ([]Invoice, error) {
+	invoices, err := storeFor(ctx).Invoices().ListBetween(ctx, from, to)
+	if invoices == nil {
+		invoices = []Invoice{}
+	}
+	return invoices, err
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LegacyHistory is what a customer did before the restaurant moved to this system, as their old POS recorded it
+type LegacyHistory struct {
+	Visits     int       `bson:"visits" json:"visits"`
+	Spend      float64   `bson:"spend" json:"spend"`
+	ImportedAt time.Time `bson:"importedAt" json:"importedAt"`
+}
+
+// LifetimeSpend is everything the customer has spent, here and on the old POS, which loyalty tiers go by
+func (c Customer) LifetimeSpend() float64 {
+	if c.Legacy == nil {
+		return c.TotalAmount
+	}
+	return roundPaise(c.TotalAmount + c.Legacy.Spend)
+}
+
+// LoyaltyTier is a tier customers reach once their lifetime spend comes to a minimum
+type LoyaltyTier struct {
+	Name     string
+	MinSpend float64
+}
+
+// loyaltyTiers holds the loyalty tiers in effect, lowest spend first
+var loyaltyTiers = []LoyaltyTier{{"Silver", 10000}, {"Gold", 25000}, {"Platinum", 50000}}
+
+// SetLoyaltyTiers reads the loyalty tiers from a spec like "Silver=10000,Gold=25000", meaning a customer who has
+// spent Rs 10,000 or more is Silver and one who has spent Rs 25,000 or more is Gold
+func SetLoyaltyTiers(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	var tiers []LoyaltyTier
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, spend, ok := strings.Cut(entry, "=")
+		minimum, err := strconv.ParseFloat(strings.TrimSpace(spend), 64)
+		if !ok || err != nil || strings.TrimSpace(name) == "" || minimum <= 0 {
+			return fmt.Errorf("invalid loyalty tier %q (want name=spend)", entry)
+		}
+		tiers = append(tiers, LoyaltyTier{strings.TrimSpace(name), minimum})
+	}
+	slices.SortFunc(tiers, func(a, b LoyaltyTier) int { return cmp.Compare(a.MinSpend, b.MinSpend) })
+	loyaltyTiers = tiers
+	return nil
+}
+
+// LoyaltyTier returns the name of the highest tier the customer's lifetime spend reaches, empty for none
+func (c Customer) LoyaltyTier() string {
+	var tier string
+	for _, t := range loyaltyTiers {
+		if c.LifetimeSpend() >= t.MinSpend {
+			tier = t.Name
+		}
+	}
+	return tier
+}
+
+// legacyColumns are the header names legacy POS exports use for each column the import reads
+var legacyColumns = map[string][]string{
+	"name":   {"name", "customer", "customername", "fullname"},
+	"phone":  {"phone", "mobile", "phonenumber", "phoneno", "mobilenumber", "mobileno", "contact"},
+	"visits": {"visits", "visitcount", "noofvisits", "billcount", "bills"},
+	"spend":  {"spend", "totalspend", "lifetimespend", "totalamount", "amount", "total"},
+}
+
+// LegacyImport counts what ImportLegacyCustomers did with an export
+type LegacyImport struct {
+	Rows    int      `json:"rows"`    // Customer rows read from the file
+	Merged  int      `json:"merged"`  // Rows folded into another row with the same phone number
+	Added   int      `json:"added"`   // New customers
+	Updated int      `json:"updated"` // Existing customers given their legacy history
+	Skipped []string `json:"skipped"` // Rows that could not be imported, and why
+}
+
+// legacyRow is one customer from the export, after rows with the same phone are added together
+type legacyRow struct {
+	name   string
+	phone  string
+	visits int
+	spend  float64
+}
+
+// ImportLegacyCustomers reads a customer export from a legacy POS, a CSV with a header row naming the name,
+// phone, visit count and spend columns, and keeps each customer's visits and spend as their legacy history.
+// Rows are matched by phone number: rows sharing one are added together, and a customer already on file with
+// the number, or with the name and no number, gets the history rather than being added again. Importing the
+// same file twice replaces the history rather than counting it twice.
+func ImportLegacyCustomers(ctx context.Context, r io.Reader) (LegacyImport, error) {
+	result := LegacyImport{Skipped: []string{}}
+	rows, err := readLegacyCustomers(r, &result)
+	if err != nil {
+		return result, err
+	}
+	now := clock.Now()
+	for _, row := range rows {
+		err := importLegacyCustomer(ctx, row, &LegacyHistory{Visits: row.visits, Spend: roundPaise(row.spend), ImportedAt: now}, &result)
+		if err != nil {
+			return result, fmt.Errorf("importing %s: %w", row.phone, err)
+		}
+	}
+	return result, nil
+}
+
+// readLegacyCustomers parses the export into one row per phone number, in the order they first appear
+func readLegacyCustomers(r io.Reader, result *LegacyImport) ([]*legacyRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns, err := legacyHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	var rows []*legacyRow
+	byPhone := map[string]*legacyRow{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		result.Rows++
+		row, err := parseLegacyRow(field("name"), field("phone"), field("visits"), field("spend"))
+		if err != nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		if seen, ok := byPhone[row.phone]; ok {
+			seen.visits += row.visits
+			seen.spend += row.spend
+			if seen.name == "" {
+				seen.name = row.name
+			}
+			result.Merged++
+			continue
+		}
+		byPhone[row.phone] = &row
+		rows = append(rows, &row)
+	}
+}
+
+// legacyHeader finds the column of each field the import reads. Name and phone are required.
+func legacyHeader(header []string) (map[string]int, error) {
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.NewReplacer(" ", "", "_", "", "-", "", ".", "").Replace(strings.ToLower(strings.TrimSpace(name)))
+		for column, aliases := range legacyColumns {
+			if _, found := columns[column]; !found && slices.Contains(aliases, name) {
+				columns[column] = i
+			}
+		}
+	}
+	for _, required := range []string{"name", "phone"} {
+		if _, found := columns[required]; !found {
+			return nil, fmt.Errorf("the header row has no %s column (found %s)", required, strings.Join(header, ", "))
+		}
+	}
+	return columns, nil
+}
+
+// parseLegacyRow reads one customer's fields. Amounts may carry a rupee sign and digit grouping, e.g. "Rs 1,23,456.50".
+func parseLegacyRow(name, phone, visits, spend string) (legacyRow, error) {
+	row := legacyRow{name: name, phone: normalizePhone(phone)}
+	if row.phone == "" {
+		return row, fmt.Errorf("no phone number for %q", name)
+	}
+	var err error
+	if visits != "" {
+		if row.visits, err = strconv.Atoi(strings.ReplaceAll(visits, ",", "")); err != nil || row.visits < 0 {
+			return row, fmt.Errorf("visit count %q is not a whole number", visits)
+		}
+	}
+	if spend != "" {
+		amount := strings.NewReplacer(",", "", "₹", "", "Rs.", "", "Rs", "", "INR", "", " ", "").Replace(spend)
+		if row.spend, err = strconv.ParseFloat(amount, 64); err != nil || row.spend < 0 {
+			return row, fmt.Errorf("spend %q is not an amount", spend)
+		}
+	}
+	return row, nil
+}
+
+// importLegacyCustomer gives the customer with the row's phone number their legacy history, or the customer
+// with the row's name if they have no number yet, or adds them
+func importLegacyCustomer(ctx context.Context, row *legacyRow, legacy *LegacyHistory, result *LegacyImport) error {
+	customers := storeFor(ctx).Customers()
+	customer, err := customers.FindByPhone(ctx, row.phone)
+	if errors.Is(err, ErrNotFound) && row.name != "" {
+		customer, err = customers.FindByName(ctx, row.name)
+		if err == nil && customer.Phone != "" {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s (%s): a customer of that name has the number %s", row.name, row.phone, customer.Phone))
+			return nil
+		}
+		if errors.Is(err, ErrNotFound) {
+			result.Added++
+			return customers.Add(ctx, Customer{Name: row.name, Phone: row.phone, OrderedItems: []string{}, Legacy: legacy})
+		}
+		if err == nil {
+			if err := customers.SetPhone(ctx, customer.Name, row.phone); err != nil {
+				return err
+			}
+		}
+	}
+	if errors.Is(err, ErrNotFound) {
+		result.Skipped = append(result.Skipped, fmt.Sprintf("%s: no name to add the customer under", row.phone))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	result.Updated++
+	return customers.SetLegacy(ctx, customer.Name, 0, legacy)
+}
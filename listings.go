@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Formats the CLI can print menus and other listings in
+const (
+	FormatTable   = "table"   // An aligned ASCII table
+	FormatJSON    = "json"    // The records as a JSON array, for scripts
+	FormatCompact = "compact" // One short line per record
+)
+
+// listFormat is how the CLI prints listings, set with --format
+var listFormat = FormatTable
+
+// SetListFormat chooses the format listings are printed in
+func SetListFormat(format string) error {
+	switch format {
+	case FormatTable, FormatJSON, FormatCompact:
+		listFormat = format
+		return nil
+	}
+	return fmt.Errorf("format must be %s, %s or %s", FormatTable, FormatJSON, FormatCompact)
+}
+
+// jsonOutput is set by --json: results go to stdout as JSON for scripts, and everything else, errors
+// included, goes to stderr as JSON lines
+var jsonOutput bool
+
+// EnableJSONOutput switches the CLI to machine-readable output
+func EnableJSONOutput() {
+	jsonOutput, listFormat = true, FormatJSON
+	log.SetFlags(0)
+	log.SetOutput(jsonLogWriter{os.Stderr})
+}
+
+// jsonLogWriter writes each log line as a JSON object with the time and the message
+type jsonLogWriter struct{ w io.Writer }
+
+func (j jsonLogWriter) Write(p []byte) (int, error) {
+	line, err := json.Marshal(struct {
+		Time    time.Time `json:"time"`
+		Message string    `json:"message"`
+	}{clock.Now(), strings.TrimSuffix(string(p), "\n")})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := j.w.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// notify tells the person at the terminal what is happening. With -json it is logged to stderr instead,
+// so stdout only carries results.
+func notify(a ...any) {
+	if jsonOutput {
+		log.Println(a...)
+		return
+	}
+	fmt.Println(a...)
+}
+
+// printResult reports what a command did: text for people, or result as JSON with -json
+func printResult(text string, result any) {
+	if !jsonOutput {
+		fmt.Println(text)
+		return
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		log.Fatal("Error printing the result:", err)
+	}
+}
+
+// listing is a list of records ready to print in any of the formats
+type listing struct {
+	title   string // Printed above the table or compact list, but not the JSON
+	header  []string
+	rows    [][]string
+	compact []string // The line for each row in the compact format
+	records any      // What the JSON format prints
+}
+
+// printListing writes the listing in the chosen format
+func printListing(w io.Writer, l listing) error {
+	if listFormat == FormatJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(l.records)
+	}
+	fmt.Fprintln(w, l.title)
+	if listFormat == FormatCompact {
+		for _, line := range l.compact {
+			fmt.Fprintln(w, line)
+		}
+		return nil
+	}
+	return writeTable(w, l.header, l.rows)
+}
+
+// writeTable draws rows under the header with every column padded to its widest cell
+func writeTable(w io.Writer, header []string, rows [][]string) error {
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	var border strings.Builder
+	border.WriteString("+")
+	for _, width := range widths {
+		border.WriteString(strings.Repeat("-", width+2) + "+")
+	}
+	line := func(row []string) {
+		var b strings.Builder
+		b.WriteString("|")
+		for i, cell := range row {
+			b.WriteString(" " + cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)) + " |")
+		}
+		fmt.Fprintln(w, b.String())
+	}
+
+	fmt.Fprintln(w, border.String())
+	line(header)
+	fmt.Fprintln(w, border.String())
+	for _, row := range rows {
+		line(row)
+	}
+	_, err := fmt.Fprintln(w, border.String())
+	return err
+}
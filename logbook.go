@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of log book entry
+const (
+	LogNote     = "note"      // A shift handover note, e.g. "walk-in freezer door sticks"
+	LogIncident = "incident"  // Something that went wrong, e.g. a guest slipping or a power cut
+	LogFollowUp = "follow-up" // Something someone must do, open until it is marked done
+)
+
+var logKinds = []string{LogNote, LogIncident, LogFollowUp}
+
+// maxLogText caps a log book entry
+const maxLogText = 2000
+
+// LogEntry is one entry in the managers' log book, attached to the business date it is about
+type LogEntry struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	Date      string             `bson:"date" json:"date"` // Business date, YYYY-MM-DD
+	Kind      string             `bson:"kind" json:"kind"`
+	Text      string             `bson:"text" json:"text"`
+	Branch    string             `bson:"branch,omitempty" json:"branch,omitempty"`
+	By        string             `bson:"by" json:"by"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	DoneBy    string             `bson:"doneBy,omitempty" json:"doneBy,omitempty"` // Who marked a follow-up done
+	DoneAt    *time.Time         `bson:"doneAt,omitempty" json:"doneAt,omitempty"`
+	Version   int                `bson:"version" json:"version"`
+}
+
+// Open reports whether the entry is a follow-up not yet done
+func (entry LogEntry) Open() bool {
+	return entry.Kind == LogFollowUp && entry.DoneAt == nil
+}
+
+// AddLogEntry writes an entry in the log book, on today's business date unless it names another
+func AddLogEntry(ctx context.Context, entry LogEntry) (LogEntry, error) {
+	entry.Kind = strings.ToLower(strings.TrimSpace(entry.Kind))
+	entry.Text, entry.Date = strings.TrimSpace(entry.Text), strings.TrimSpace(entry.Date)
+	if entry.Kind == "" {
+		entry.Kind = LogNote
+	}
+	switch {
+	case !slices.Contains(logKinds, entry.Kind):
+		return LogEntry{}, fmt.Errorf("kind must be one of %s", strings.Join(logKinds, ", "))
+	case entry.Text == "":
+		return LogEntry{}, fmt.Errorf("the entry needs some text")
+	case utf8.RuneCountInString(entry.Text) > maxLogText:
+		return LogEntry{}, fmt.Errorf("an entry must be at most %d characters", maxLogText)
+	}
+	if entry.Date == "" {
+		entry.Date = clock.Now().Format(time.DateOnly)
+	} else if _, err := time.Parse(time.DateOnly, entry.Date); err != nil {
+		return LogEntry{}, fmt.Errorf("the date must be formatted as YYYY-MM-DD")
+	}
+	entry.ID, entry.Branch, entry.By, entry.CreatedAt = primitive.NewObjectID(), currentBranch, changedBy(ctx), clock.Now()
+	entry.DoneBy, entry.DoneAt, entry.Version = "", nil, 1
+	if err := storeFor(ctx).LogBook().Insert(ctx, entry); err != nil {
+		return LogEntry{}, err
+	}
+	return entry, nil
+}
+
+// ListLogBook returns the entries for the business dates in [from, to), in the order they were written
+func ListLogBook(ctx context.Context, from, to time.Time) ([]LogEntry, error) {
+	entries, err := storeFor(ctx).LogBook().ListBetween(ctx, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	if entries == nil {
+		entries = []LogEntry{}
+	}
+	return entries, err
+}
+
+// OpenFollowUps returns the follow-ups not yet done, from any date, oldest first
+func OpenFollowUps(ctx context.Context) ([]LogEntry, error) {
+	entries, err := storeFor(ctx).LogBook().ListOpen(ctx)
+	if entries == nil {
+		entries = []LogEntry{}
+	}
+	return entries, err
+}
+
+// CompleteFollowUp marks a follow-up done. A version other than 0 must be the entry's current one.
+func CompleteFollowUp(ctx context.Context, id primitive.ObjectID, version int) (LogEntry, error) {
+	entry, err := storeFor(ctx).LogBook().Find(ctx, id)
+	if err != nil {
+		return LogEntry{}, err
+	}
+	if version != 0 && entry.Version != version {
+		return LogEntry{}, fmt.Errorf("log entry %w", ErrVersionConflict)
+	}
+	if !entry.Open() {
+		return LogEntry{}, fmt.Errorf("only an open follow-up can be marked done")
+	}
+	now := clock.Now()
+	entry.DoneBy, entry.DoneAt = changedBy(ctx), &now
+	if err := storeFor(ctx).LogBook().Update(ctx, entry); err != nil {
+		return LogEntry{}, err
+	}
+	entry.Version++
+	return entry, nil
+}
+
+// logEntryLine describes an entry in one line, e.g. "21:40 incident (Priya): power cut for 20 minutes"
+func logEntryLine(entry LogEntry) string {
+	line := fmt.Sprintf("%s %s (%s): %s", entry.CreatedAt.Format("15:04"), entry.Kind, entry.By, entry.Text)
+	if entry.Kind == LogFollowUp && entry.DoneAt != nil {
+		line += fmt.Sprintf(" [done by %s %s]", entry.DoneBy, entry.DoneAt.Format("02 Jan 15:04"))
+	}
+	return line
+}
+
+// ShowLogBook prints the log book for the business dates in [from, to), with the follow-ups still open
+func ShowLogBook(ctx context.Context, from, to time.Time) error {
+	entries, err := ListLogBook(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	open, err := OpenFollowUps(ctx)
+	if err != nil {
+		return err
+	}
+	title := fmt.Sprintf("Log book from %s to %s, %d follow-ups open:", from.Format("02 Jan 2006"), to.AddDate(0, 0, -1).Format("02 Jan 2006"), len(open))
+	logListing := listing{title: title, header: []string{"Date", "Time", "Kind", "By", "Entry", "Done", "ID"}, records: entries}
+	for _, entry := range entries {
+		done := ""
+		if entry.Open() {
+			done = "OPEN"
+		} else if entry.DoneAt != nil {
+			done = entry.DoneBy + " " + entry.DoneAt.Format("02 Jan 15:04")
+		}
+		logListing.rows = append(logListing.rows, []string{
+			entry.Date, entry.CreatedAt.Format("15:04"), entry.Kind, entry.By, entry.Text, done, entry.ID.Hex(),
+		})
+		logListing.compact = append(logListing.compact, entry.Date+" "+logEntryLine(entry))
+	}
+	return printListing(os.Stdout, logListing)
+}
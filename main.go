@@ -3,229 +3,597 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
 )
 
 // Customer represents a customer in the database
 type Customer struct {
-	Name         string   `bson:"name"`
-	Phone        string   `bson:"phone"`
-	OrderedItems []string `bson:"orderedItems"` // Stores ordered menu items
-	TotalAmount  float64  `bson:"totalAmount"`  // Total amount for the customer's orders
+	Name         string         `bson:"name" json:"name"`
+	Phone        string         `bson:"phone" json:"phone"`
+	OrderedItems []string       `bson:"orderedItems" json:"orderedItems"`                   // Stores ordered menu items
+	TotalAmount  float64        `bson:"totalAmount" json:"totalAmount"`                     // Total amount for the customer's orders
+	Allergies    []string       `bson:"allergies,omitempty" json:"allergies,omitempty"`     // Allergens the customer must not be served
+	Diets        []string       `bson:"diets,omitempty" json:"diets,omitempty"`             // Diets the customer follows, e.g. vegetarian
+	Birthday     string         `bson:"birthday,omitempty" json:"birthday,omitempty"`       // MM-DD, for the birthday offer
+	Anniversary  string         `bson:"anniversary,omitempty" json:"anniversary,omitempty"` // MM-DD, for the anniversary offer
+	Flag         *CustomerFlag  `bson:"flag,omitempty" json:"flag,omitempty"`               // Set when staff have flagged the customer
+	Addresses    []SavedAddress `bson:"addresses,omitempty" json:"addresses,omitempty"`     // Where they have orders delivered, by label
+	// Communication is what the customer agreed to be sent on each channel; nil when they never said
+	Communication *CommunicationPrefs `bson:"communication,omitempty" json:"communication,omitempty"`
+	Legacy        *LegacyHistory      `bson:"legacy,omitempty" json:"legacy,omitempty"` // Visits and spend imported from the old POS
+	Version       int                 `bson:"version" json:"version"`                   // Bumped by every change, to catch conflicting edits
 }
 
 // MenuItem represents a menu item in the database
 type MenuItem struct {
-	Name  string  `bson:"name"`
-	Price float64 `bson:"price"`
-}
-
-var client *mongo.Client
-
-// ConnectDB initializes a MongoDB client connection
-func ConnectDB() *mongo.Client {
-	clientOptions := options.Client().ApplyURI("mongodb://localhost:27017")
-	client, err := mongo.Connect(context.TODO(), clientOptions)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Check the connection
-	err = client.Ping(context.TODO(), nil)
-	if err != nil {
-		log.Fatal("Failed to connect to MongoDB:", err)
-	}
-	fmt.Println("Connected to MongoDB!")
-	return client
+	Name        string             `bson:"name" json:"name"`
+	Price       float64            `bson:"price" json:"price"`
+	Allergens   []string           `bson:"allergens,omitempty" json:"allergens,omitempty"`     // e.g. gluten, dairy, nuts
+	Diets       []string           `bson:"diets,omitempty" json:"diets,omitempty"`             // Diets the item suits, e.g. vegetarian, vegan
+	Nutrition   *Nutrition         `bson:"nutrition,omitempty" json:"nutrition,omitempty"`     // Per serving; nil when not known
+	Category    string             `bson:"category,omitempty" json:"category,omitempty"`       // e.g. breakfast; may have serving hours in RMS_MENU_HOURS
+	Hours       *TimeWindow        `bson:"hours,omitempty" json:"hours,omitempty"`             // Serving hours of the item itself, overriding its category's
+	SoldOutOn   string             `bson:"soldOutOn,omitempty" json:"soldOutOn,omitempty"`     // Day (YYYY-MM-DD) the item was 86'd; it is back the next day
+	HSN         string             `bson:"hsn,omitempty" json:"hsn,omitempty"`                 // HSN or SAC code for GST; restaurant service (996331) when empty
+	GSTRate     *float64           `bson:"gstRate,omitempty" json:"gstRate,omitempty"`         // GST percentage included in the price; the configured rate when nil
+	TaxClass    string             `bson:"taxClass,omitempty" json:"taxClass,omitempty"`       // From RMS_TAX_CLASSES, e.g. liquor; its category's when empty
+	PrepMinutes int                `bson:"prepMinutes,omitempty" json:"prepMinutes,omitempty"` // Target preparation time; 0 when none is set
+	Recipe      []RecipeIngredient `bson:"recipe,omitempty" json:"recipe,omitempty"`           // Ingredients of one serving, for purchase forecasts
+	Packaging   float64            `bson:"packaging,omitempty" json:"packaging,omitempty"`     // Charged per serving on takeaway and delivery orders
+	Barcodes    []string           `bson:"barcodes,omitempty" json:"barcodes,omitempty"`       // Barcodes or PLU codes of a packaged item, scanned to order it
+	// SoldByWeight makes Price the rate per kg, charged for the weight on each order line, e.g. biryani by the kg
+	SoldByWeight bool `bson:"soldByWeight,omitempty" json:"soldByWeight,omitempty"`
+	// ContainerDeposit is charged per serving when the customer takes it in a reusable container, and
+	// refunded on its return
+	ContainerDeposit float64 `bson:"containerDeposit,omitempty" json:"containerDeposit,omitempty"`
+	// AgeRestricted items, such as alcohol, need the guest's ID checked and are not sold in RMS_DRY_HOURS
+	AgeRestricted bool `bson:"ageRestricted,omitempty" json:"ageRestricted,omitempty"`
+	Version       int  `bson:"version" json:"version"` // Bumped by every change, to catch conflicting edits
 }
 
 // AddCustomer inserts a new customer into the database
-func AddCustomer(name string, phone string) {
-	collection := client.Database("restaurant").Collection("customers")
+func AddCustomer(ctx context.Context, name string, phone string) {
 	customer := Customer{Name: name, Phone: phone, OrderedItems: []string{}, TotalAmount: 0}
-	_, err := collection.InsertOne(context.TODO(), customer)
-	if err != nil {
+	if err := storeFor(ctx).Customers().Add(ctx, customer); err != nil {
 		log.Fatal("Error adding customer:", err)
 	}
 	fmt.Println("Customer added:", name)
 }
 
 // AddMenuItems adds predefined items to the menu collection
-func AddMenuItems() {
+func AddMenuItems(ctx context.Context) {
 	menuItems := []MenuItem{
-		{"Pizza", 829.17},
-		{"Burger", 497.17},
-		{"Pasta", 663.17},
-		{"Salad", 414.17},
-		{"Sushi", 1078.17},
-		{"Sandwich", 331.17},
-		{"Tacos", 580.17},
-		{"Steak", 1327.17},
-		{"Fries", 248.17},
-		{"Ice Cream", 290.50},
-	}
-
-	collection := client.Database("restaurant").Collection("menu")
+		{Name: "Pizza", PrepMinutes: 15, Category: "mains", Price: 829.17, Allergens: []string{"gluten", "dairy"}, Diets: []string{"vegetarian"}, Nutrition: &Nutrition{850, 32, 96, 34},
+			Recipe: []RecipeIngredient{{"flour", 0.25, "kg"}, {"mozzarella", 0.12, "kg"}, {"tomatoes", 0.1, "kg"}}},
+		{Name: "Burger", PrepMinutes: 10, Category: "mains", Price: 497.17, Allergens: []string{"gluten"}, Nutrition: &Nutrition{650, 30, 45, 38},
+			Recipe: []RecipeIngredient{{"burger buns", 1, "piece"}, {"minced beef", 0.15, "kg"}, {"lettuce", 0.02, "kg"}, {"tomatoes", 0.03, "kg"}}},
+		{Name: "Pasta", PrepMinutes: 12, Category: "mains", Price: 663.17, Allergens: []string{"gluten"}, Diets: []string{"vegetarian"}, Nutrition: &Nutrition{700, 24, 95, 22},
+			Recipe: []RecipeIngredient{{"pasta", 0.12, "kg"}, {"tomatoes", 0.15, "kg"}, {"olive oil", 0.02, "l"}}},
+		{Name: "Salad", PrepMinutes: 5, Category: "starters", Price: 414.17, Diets: []string{"vegetarian", "vegan"}, Nutrition: &Nutrition{250, 6, 18, 16},
+			Recipe: []RecipeIngredient{{"lettuce", 0.1, "kg"}, {"tomatoes", 0.05, "kg"}, {"olive oil", 0.01, "l"}}},
+		{Name: "Sushi", PrepMinutes: 15, Category: "mains", Price: 1078.17, Allergens: []string{"fish", "soy"}, Nutrition: &Nutrition{450, 20, 70, 8},
+			Recipe: []RecipeIngredient{{"sushi rice", 0.15, "kg"}, {"salmon", 0.08, "kg"}, {"nori", 2, "sheet"}}},
+		{Name: "Sandwich", PrepMinutes: 5, Category: "mains", Price: 331.17, Allergens: []string{"gluten"}, Nutrition: &Nutrition{420, 18, 45, 17}},
+		{Name: "Tacos", PrepMinutes: 10, Category: "mains", Price: 580.17, Nutrition: &Nutrition{500, 22, 40, 26}},
+		{Name: "Steak", PrepMinutes: 20, Category: "mains", Price: 1327.17, Nutrition: &Nutrition{680, 62, 0, 46},
+			Recipe: []RecipeIngredient{{"sirloin", 0.3, "kg"}, {"butter", 0.02, "kg"}}},
+		{Name: "Fries", PrepMinutes: 5, Category: "sides", Price: 248.17, Diets: []string{"vegetarian", "vegan"}, Nutrition: &Nutrition{365, 4, 48, 17},
+			Recipe: []RecipeIngredient{{"potatoes", 0.2, "kg"}, {"frying oil", 0.03, "l"}}},
+		{Name: "Ice Cream", PrepMinutes: 3, Category: "desserts", Price: 290.50, Allergens: []string{"dairy"}, Diets: []string{"vegetarian"}, Nutrition: &Nutrition{270, 5, 31, 14}},
+	}
+
 	for _, item := range menuItems {
-		_, err := collection.InsertOne(context.TODO(), item)
-		if err != nil {
+		// Items already on the menu are left alone, so seeding is safe to repeat
+		err := storeFor(ctx).Menu().Add(ctx, item)
+		if err != nil && !errors.Is(err, ErrDuplicate) {
 			log.Fatal("Error adding menu item:", err)
 		}
 	}
-	fmt.Println("Menu items added to the database!")
+	notify("Menu items added to the database!")
+}
+
+// LoadMenu retrieves all menu items in the order they were added, so item numbers stay stable. At a branch it
+// is the menu last synced to the branch. Offline, it falls back to the copy of the menu cached the last time it
+// was loaded.
+func LoadMenu(ctx context.Context) []MenuItem {
+	return loadMenu(ctx, currentBranch)
 }
 
-// ShowMenu displays all items available in the menu
-func ShowMenu() {
-	collection := client.Database("restaurant").Collection("menu")
-	cursor, err := collection.Find(context.TODO(), bson.D{})
+// loadHeadOfficeMenu retrieves head office's menu, which is the one edited and synced to the branches
+func loadHeadOfficeMenu(ctx context.Context) []MenuItem {
+	return loadMenu(ctx, "")
+}
+
+func loadMenu(ctx context.Context, branch string) []MenuItem {
+	if IsOffline() {
+		return loadCachedMenu()
+	}
+
+	menu, err := storeFor(ctx).Menu().List(ctx)
+	if err == nil && branch != "" {
+		menu, err = branchMenuItems(ctx, branch, menu)
+	}
+	if storeFor(ctx).Unavailable(err) {
+		GoOffline(err)
+		return loadCachedMenu()
+	}
 	if err != nil {
 		log.Fatal("Error retrieving menu:", err)
 	}
-	defer cursor.Close(context.TODO())
+	// The cache is what this terminal serves
+	if branch == currentBranch {
+		cacheMenu(menu)
+	}
+	return menu
+}
 
-	fmt.Println("Menu:")
-	for cursor.Next(context.TODO()) {
-		var menuItem MenuItem
-		err := cursor.Decode(&menuItem)
-		if err != nil {
-			log.Fatal(err)
+// loadCachedMenu returns the locally cached menu, which is all there is to order from while offline
+func loadCachedMenu() []MenuItem {
+	menu, err := cachedMenu()
+	if err != nil {
+		log.Fatal("Error retrieving menu while offline:", err)
+	}
+	return menu
+}
+
+// ShowMenu displays the items served at this time of day, numbered for quick ordering
+func ShowMenu(ctx context.Context) []MenuItem {
+	menu := AvailableMenu(LoadMenu(ctx), clock.Now())
+	if err := printMenu(menu); err != nil {
+		log.Println("Error printing the menu:", err)
+	}
+	return menu
+}
+
+// printMenu lists the items with their prices, nutrition and allergens, numbered from 1
+func printMenu(menu []MenuItem) error {
+	menuListing := listing{title: "Menu:", header: []string{"#", "Item", "Price", "Nutrition", "Contains"}, records: menu}
+	if menu == nil {
+		menuListing.records = []MenuItem{}
+	}
+	for i, menuItem := range menu {
+		var nutrition string
+		if menuItem.Nutrition != nil {
+			nutrition = menuItem.Nutrition.String()
 		}
-		fmt.Printf("%s, Price: Rs %.2f\n", menuItem.Name, menuItem.Price)
+		allergens := strings.Join(menuItem.Allergens, ", ")
+		price := fmt.Sprintf("Rs %.2f%s%s", menuItem.Price, priceUnit(menuItem), priceTax(menuItem))
+		menuListing.rows = append(menuListing.rows, []string{strconv.Itoa(i + 1), menuItem.Name, price, nutrition, allergens})
+		menuListing.compact = append(menuListing.compact, fmt.Sprintf("%d. %s %s", i+1, menuItem.Name, price))
 	}
+	return printListing(os.Stdout, menuListing)
 }
 
-// OrderItem allows a customer to order an item from the menu
-func OrderItem(customerName string, itemName string) {
-	customersCollection := client.Database("restaurant").Collection("customers")
-	menuCollection := client.Database("restaurant").Collection("menu")
+// priceTax is e.g. " + 5% tax" when menu prices exclude tax, and empty when they include it
+func priceTax(item MenuItem) string {
+	if !pricesExcludeTax {
+		return ""
+	}
+	return " + " + strconv.FormatFloat(itemTaxClass(item).Rate, 'f', -1, 64) + "% tax"
+}
 
-	// Check if the item exists in the menu
-	var menuItem MenuItem
-	err := menuCollection.FindOne(context.TODO(), bson.D{{"name", itemName}}).Decode(&menuItem)
-	if err != nil {
-		fmt.Printf("Item %s not found in menu\n", itemName)
-		return
+// priceUnit is "/kg" for an item sold by weight, whose price is a rate per kg, and empty for others
+func priceUnit(item MenuItem) string {
+	if item.SoldByWeight {
+		return "/kg"
 	}
+	return ""
+}
 
-	// Update customer's ordered items
-	filter := bson.D{{"name", customerName}}
-	update := bson.D{{"$push", bson.D{{"orderedItems", itemName}}}}
+// OrderItem allows a customer to order one or more of an item from the menu as an order of its own.
+// It reports the menu item that was ordered, or false if nothing was ordered.
+func OrderItem(ctx context.Context, customerName string, itemName string, quantity int) (MenuItem, bool) {
+	menuItem, found := pickMenuItem(AvailableMenu(LoadMenu(ctx), clock.Now()), itemName)
+	if !found {
+		return MenuItem{}, false
+	}
 
-	result, err := customersCollection.UpdateOne(context.TODO(), filter, update)
+	order := Order{CustomerName: customerName, Items: AddToCart(nil, menuItem, quantity), IgnoreMenuHours: ignoreMenuHours}
+	_, err := SubmitOrder(ctx, order)
+	var allergyErr *AllergyError
+	var ageErr *AgeCheckError
+	if errors.As(err, &allergyErr) || errors.As(err, &ageErr) {
+		fmt.Println(err)
+		return MenuItem{}, false
+	}
 	if err != nil {
 		log.Fatal("Error ordering item:", err)
 	}
-	if result.MatchedCount > 0 {
-		fmt.Printf("Customer %s ordered item: %s\n", customerName, itemName)
-	} else {
-		fmt.Printf("No customer found with name: %s\n", customerName)
+	fmt.Printf("Customer %s ordered item: %s x%d\n", customerName, menuItem.Name, quantity)
+	return menuItem, true
+}
+
+// pickMenuItem finds an item in the menu, ignoring case and stray whitespace,
+// and tells the user about close matches when there is none
+func pickMenuItem(menu []MenuItem, itemName string) (MenuItem, bool) {
+	menuItem, found := FindMenuItem(menu, itemName)
+	if !found {
+		fmt.Printf("Item %s not found in menu\n", strings.TrimSpace(itemName))
+		if suggestions := SuggestMenuItems(menu, itemName); len(suggestions) > 0 {
+			fmt.Printf("Did you mean: %s?\n", strings.Join(suggestions, ", "))
+		}
 	}
+	return menuItem, found
 }
 
-// PlaceOrder lets a customer choose multiple items from the menu
-func PlaceOrder(customerName string) {
+// PlaceOrder lets a customer choose multiple items from the menu, by number, by name or by scanning their barcode.
+// A staff meal is the named employee's own, billed at cost less their allowance.
+func PlaceOrder(ctx context.Context, customerName string, forStaff bool) {
 	reader := bufio.NewReader(os.Stdin)
+	var lines []OrderLine
+	allergyOverride := false
+	var idVerified *IDCheck
+
+	orderType := OrderDineIn
+	if forStaff {
+		orderType = OrderStaffMeal
+	} else {
+		fmt.Println("Is this a takeaway order? (y/n):")
+		answer, _ := reader.ReadString('\n')
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y") {
+			orderType = OrderTakeaway
+		}
+	}
 
 	for {
-		ShowMenu()
-		fmt.Println("Enter the name of the item you want to order (or type 'done' to finish):")
-		itemName, _ := reader.ReadString('\n')
-		itemName = strings.TrimSpace(itemName)
+		menu := ShowMenu(ctx)
+		fmt.Println("Enter an item number or name or scan a barcode, optionally with a quantity like '3 x2' (or type 'open' for an item not on the menu, 'done' to finish):")
+		input, _ := reader.ReadString('\n')
+		input = strings.TrimSpace(input)
 
-		if strings.ToLower(itemName) == "done" {
+		if strings.ToLower(input) == "done" {
 			break
 		}
+		if strings.ToLower(input) == "open" {
+			if line, ok := askOpenItem(ctx, reader, menu); ok {
+				lines = addLine(lines, line)
+			}
+			continue
+		}
 
-		OrderItem(customerName, itemName)
+		ref, quantity, err := ParseOrderEntry(input)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		// A scanner types the barcode and Enter like a keyboard, so a barcode on the menu wins over an item number.
+		// Item numbers refer to the menu just shown; anything else is treated as a name.
+		itemName := ref
+		if item, found := FindMenuItemByBarcode(menu, ref); found {
+			itemName = item.Name
+		} else if number, err := strconv.Atoi(ref); err == nil {
+			if number < 1 || number > len(menu) {
+				fmt.Printf("There is no item number %d on the menu\n", number)
+				continue
+			}
+			itemName = menu[number-1].Name
+		}
+
+		if menuItem, ok := pickMenuItem(menu, itemName); ok {
+			add, overridden := confirmDietary(ctx, reader, customerName, menuItem)
+			if !add {
+				continue
+			}
+			allergyOverride = allergyOverride || overridden
+			if menuItem.AgeRestricted && idVerified == nil {
+				if !confirmID(reader, menuItem) {
+					continue
+				}
+				idVerified = &IDCheck{}
+			}
+			if !menuItem.SoldByWeight {
+				lines = AddToCart(lines, menuItem, quantity)
+				fmt.Printf("Added %s x%d\n", menuItem.Name, quantity)
+				continue
+			}
+			// The rate is per kg, so each one is weighed before it goes in the cart
+			fmt.Printf("Weight of each %s in kg, e.g. 1.25:\n", menuItem.Name)
+			input, _ := reader.ReadString('\n')
+			weight, err := strconv.ParseFloat(strings.TrimSpace(input), 64)
+			line := AddToCart(nil, menuItem, quantity)[0]
+			if err == nil {
+				err = weighLine(&line, menuItem, weight)
+			}
+			if err != nil {
+				fmt.Println("Not added:", err)
+				continue
+			}
+			lines = addLine(lines, line)
+			fmt.Printf("Added %s x%d, %.3f kg each: Rs %.2f\n", menuItem.Name, quantity, line.Weight, line.Amount())
+		}
+	}
+	if len(lines) == 0 {
+		return
 	}
-	CalculateAndStoreTotal(customerName) // Calculate total after order completion
+
+	var notes string
+	for {
+		fmt.Println("Any notes for the kitchen, e.g. 'birthday - bring a candle'? (press enter for none):")
+		input, _ := reader.ReadString('\n')
+		var err error
+		if notes, err = cleanNote(input); err == nil {
+			break
+		}
+		fmt.Println(err)
+	}
+
+	// Send what was ordered in this session to the kitchen queue
+	order, err := SubmitOrder(ctx, Order{CustomerName: customerName, Type: orderType, Items: lines, Notes: notes, AllergyOverride: allergyOverride, IgnoreMenuHours: ignoreMenuHours, IDVerified: idVerified})
+	if err != nil {
+		log.Fatal("Error sending order to the kitchen:", err)
+	}
+	fmt.Printf("Thank you, %s! Your order has been received. Please wait while we prepare your meal...!\n", customerName)
+	if order.Number != "" {
+		fmt.Printf("Your order number is %s.\n", order.Number)
+	}
+	if order.Token > 0 {
+		fmt.Printf("Your token number is %d. We'll call it out when your order is ready.\n", order.Token)
+	}
+	if IsOffline() {
+		fmt.Println("(Working offline: the order will be synced once the database is reachable.)")
+	}
+
+	PrintReceipt(order)
+	if !IsOffline() {
+		// Offline orders are invoiced later, from the API, so invoice numbers never skip
+		invoice, err := IssueInvoice(ctx, order.ID)
+		if err != nil {
+			fmt.Println("Could not issue the tax invoice:", err)
+		} else {
+			PrintTaxBreakup(invoice)
+		}
+	}
+	TakePayment(ctx, reader, order)
 }
 
-func CalculateAndStoreTotal(customerName string) {
-	customersCollection := client.Database("restaurant").Collection("customers")
-	menuCollection := client.Database("restaurant").Collection("menu")
+// PrintReceipt lists what was ordered with the order's total price and calories
+func PrintReceipt(order Order) {
+	fmt.Println("Receipt:")
+	for _, line := range order.Items {
+		fmt.Printf("  %-16s x%-3d Rs %8s", line.Name, line.Quantity, formatAmount(line.Amount()))
+		if line.Calories > 0 {
+			fmt.Printf("  %5d kcal", line.Calories*line.Quantity)
+		}
+		fmt.Println()
+		if detail := weightDetail(line); detail != "" {
+			fmt.Printf("    %s\n", detail)
+		}
+		if detail := discountDetail(line.Discount, line.DiscountReason); detail != "" {
+			fmt.Printf("    %s\n", detail)
+		}
+		if line.Note != "" {
+			fmt.Printf("    Note: %s\n", line.Note)
+		}
+	}
+	if order.Notes != "" {
+		fmt.Println("Notes:", order.Notes)
+	}
+	for _, charge := range order.Charges {
+		fmt.Printf("%s: Rs %s\n", charge.Name, formatAmount(charge.Amount))
+	}
+	if off := roundOff(order); off != 0 {
+		fmt.Printf("%s: Rs %s\n", roundOffLabel, formatAmount(off))
+	}
+	fmt.Printf("Total: Rs %s\n", formatAmount(order.Total))
+	if order.Calories > 0 {
+		fmt.Printf("Total calories: %d kcal\n", order.Calories)
+	}
+	if link := billLink(context.TODO(), order); link != "" {
+		fmt.Println("Your bill online:", link)
+	}
+}
 
-	// Retrieve the customer's orders
-	var customer Customer
-	err := customersCollection.FindOne(context.TODO(), bson.D{{"name", customerName}}).Decode(&customer)
+// PrintTaxBreakup prints the invoice number and the tax included in each line of the receipt
+func PrintTaxBreakup(invoice Invoice) {
+	fmt.Printf("Tax invoice %s, %s\n", invoice.Number, invoice.IssuedAt.Format("02 Jan 2006 15:04"))
+	if invoice.GSTIN != "" {
+		fmt.Println("GSTIN:", invoice.GSTIN)
+	}
+	fmt.Printf("  %-16s %-8s %6s %10s %8s %8s %8s\n", "Item", "HSN/SAC", "Tax %", "Taxable", "CGST", "SGST", "Other")
+	for _, line := range invoice.Lines {
+		fmt.Printf("  %-16s %-8s %6.2f %10.2f %8.2f %8.2f %8.2f\n", line.Name, line.HSN, line.Rate, line.TaxableValue, line.CGST, line.SGST, line.TaxAmount)
+	}
+	fmt.Printf("Taxable value: Rs %s. Included in the total:\n", formatAmount(invoice.TaxableValue))
+	for _, tax := range invoiceTaxes(invoice) {
+		fmt.Printf("  %-12s on Rs %10s: Rs %s\n", tax.Label, formatAmount(tax.TaxableValue), formatAmount(tax.Amount))
+	}
+}
+
+// confirmDietary warns when an item does not suit the customer and, if they are allergic to it, asks
+// whether to add it anyway. It reports whether to add the item and whether an allergy was overridden.
+func confirmDietary(ctx context.Context, reader *bufio.Reader, customerName string, item MenuItem) (bool, bool) {
+	if IsOffline() {
+		return true, false
+	}
+	check, err := CheckDietary(ctx, customerName, []OrderLine{{Name: item.Name}})
 	if err != nil {
-		fmt.Println("Customer not found.")
-		return
+		fmt.Println("Could not check dietary preferences:", err)
+		return true, false
+	}
+	for _, warning := range check.Warnings {
+		fmt.Println("Note:", warning)
+	}
+	if len(check.Allergies) == 0 {
+		return true, false
 	}
+	fmt.Printf("Allergy warning: %s. Add it anyway? (y/n):\n", strings.Join(check.Allergies, "; "))
+	answer, _ := reader.ReadString('\n')
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y") {
+		return true, true
+	}
+	return false, false
+}
 
-	// Calculate total price based on the ordered items
-	itemCounts := make(map[string]int)
-	for _, itemName := range customer.OrderedItems {
-		itemCounts[itemName]++
+// confirmID asks whether the guest's ID was checked before adding an age-restricted item
+func confirmID(reader *bufio.Reader, item MenuItem) bool {
+	fmt.Printf("%s is age-restricted. Has the guest's ID been checked? (y/n):\n", item.Name)
+	answer, _ := reader.ReadString('\n')
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y") {
+		return true
 	}
+	fmt.Println("Not added: the guest's ID must be checked first")
+	return false
+}
 
-	var totalAmount float64
-	for itemName, count := range itemCounts {
-		var menuItem MenuItem
-		err := menuCollection.FindOne(context.TODO(), bson.D{{"name", itemName}}).Decode(&menuItem)
-		if err == nil {
-			totalAmount += menuItem.Price * float64(count)
+// TakePayment asks how the customer is paying for the order and records the payment
+func TakePayment(ctx context.Context, reader *bufio.Reader, order Order) {
+	fmt.Printf("Amount due: Rs %s\n", formatAmount(order.Total))
+	for {
+		fmt.Printf("Enter payment method (%s), or press enter to pay later:\n", strings.Join(paymentMethods, "/"))
+		method, _ := reader.ReadString('\n')
+		method = strings.ToLower(strings.TrimSpace(method))
+		if method == "" {
+			return
+		}
+
+		payment := Payment{OrderID: order.ID, Method: method, Amount: order.Total}
+		if method == PaymentCash && len(exchangeRates) > 0 {
+			payment.Currency, payment.ForeignAmount = askCurrency(ctx, reader, order)
+		}
+		payment, err := RecordPayment(ctx, payment)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if payment.Currency != "" {
+			fmt.Printf("Payment of %s %.2f (Rs %s) received by %s. Thank you!\n", payment.Currency, payment.ForeignAmount, formatAmount(payment.Amount), method)
+			return
 		}
+		fmt.Printf("Payment of Rs %s received by %s. Thank you!\n", formatAmount(order.Total), method)
+		return
 	}
+}
 
-	// Update the customer's total amount in the database
-	filter := bson.D{{"name", customerName}}
-	update := bson.D{{"$set", bson.D{{"totalAmount", totalAmount}}}}
-	_, err = customersCollection.UpdateOne(context.TODO(), filter, update)
+// askCurrency quotes the bill in each foreign currency taken and asks which the customer is paying in, returning it
+// with the amount quoted in it, or nothing for rupees
+func askCurrency(ctx context.Context, reader *bufio.Reader, order Order) (string, float64) {
+	quotes, err := QuoteInCurrency(ctx, order.ID, "")
 	if err != nil {
-		log.Fatal("Error updating total amount:", err)
+		fmt.Println(err)
+		return "", 0
+	}
+	for _, quote := range quotes {
+		fmt.Printf("  %s %.2f at Rs %.2f\n", quote.Currency, quote.Amount, quote.Rate)
+	}
+	fmt.Println("Enter the currency paid in, or press enter for rupees:")
+	currency, _ := reader.ReadString('\n')
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	for _, quote := range quotes {
+		if quote.Currency == currency {
+			return quote.Currency, quote.Amount
+		}
+	}
+	if currency != "" && currency != "INR" {
+		fmt.Printf("%s is not taken here; taking rupees\n", currency)
 	}
+	return "", 0
+}
 
-	fmt.Printf("Thank you, %s! Your order has been received. Please wait while we prepare your meal...!\n", customerName)
+// storeCustomerTotal prices every item the customer has ordered and saves the sum as their total
+func storeCustomerTotal(ctx context.Context, customerName string) error {
+	// Retrieve the customer's orders
+	customer, err := storeFor(ctx).Customers().FindByName(ctx, customerName)
+	if err != nil {
+		return err
+	}
+	menu, err := storeFor(ctx).Menu().List(ctx)
+	if err != nil {
+		return err
+	}
+	prices := make(map[string]float64, len(menu))
+	for _, menuItem := range menu {
+		prices[menuItem.Name] = menuItem.Price
+	}
+
+	// Calculate total price based on the ordered items; items no longer on the menu count for nothing
+	var totalAmount float64
+	for _, itemName := range customer.OrderedItems {
+		totalAmount += prices[itemName]
+	}
+
+	// Update the customer's total amount in the database
+	return storeFor(ctx).Customers().SetTotal(ctx, customerName, totalAmount)
 }
 
 // GetCustomers retrieves all customers from the database
-func GetCustomers() {
-	collection := client.Database("restaurant").Collection("customers")
-	cursor, err := collection.Find(context.TODO(), bson.D{})
+func GetCustomers(ctx context.Context) {
+	customers, err := storeFor(ctx).Customers().List(ctx)
 	if err != nil {
 		log.Fatal("Error retrieving customers:", err)
 	}
-	defer cursor.Close(context.TODO())
 
-	fmt.Println("Total Customers:")
-	for cursor.Next(context.TODO()) {
-		var customer Customer
-		err := cursor.Decode(&customer)
-		if err != nil {
-			log.Fatal(err)
-		}
-		fmt.Printf("Name: %s, Phone: %s, Orders: %v, Total Amount: Rs %.2f\n", customer.Name, customer.Phone, customer.OrderedItems, customer.TotalAmount) // Include total amount in display
+	customerListing := listing{title: "Total Customers:", header: []string{"Name", "Phone", "Orders", "Total Amount", "Tier"}, records: customers}
+	if customers == nil {
+		customerListing.records = []Customer{}
+	}
+	for _, customer := range customers {
+		total := fmt.Sprintf("Rs %.2f", customer.LifetimeSpend())
+		customerListing.rows = append(customerListing.rows, []string{customer.Name, customer.Phone, strings.Join(customer.OrderedItems, ", "), total, customer.LoyaltyTier()})
+		customerListing.compact = append(customerListing.compact, strings.TrimSpace(fmt.Sprintf("%s %s %s %s", customer.Name, customer.Phone, total, customer.LoyaltyTier())))
+	}
+	if err := printListing(os.Stdout, customerListing); err != nil {
+		log.Println("Error printing customers:", err)
 	}
 }
 
-func main() {
-	// Initialize the MongoDB connection
-	client = ConnectDB()
-	if client == nil {
-		fmt.Println("Failed to connect to MongoDB")
-		return
+// ShowOrders lists the orders the kitchen is working on, oldest first
+func ShowOrders(ctx context.Context) error {
+	orders, err := LoadKitchenQueue(ctx)
+	if err != nil {
+		return err
 	}
-	defer client.Disconnect(context.TODO())
-
-	// Add sample menu items (only runs once; you can comment it out if items are already in the database)
-	AddMenuItems()
-
-	// Add a sample customer
-	AddCustomer("Gadapa Raghavendra", "1234567890")
+	orderListing := listing{title: "Orders:", header: []string{"Order", "Customer", "Type", "Status", "Items", "Total", "Placed", "Notes"}, records: orders}
+	if orders == nil {
+		orderListing.records = []Order{}
+	}
+	for _, order := range orders {
+		total := fmt.Sprintf("Rs %.2f", order.Total)
+		orderListing.rows = append(orderListing.rows, []string{
+			order.ID.Hex(), order.CustomerName, order.Type, order.Status, strconv.Itoa(len(order.Items)), total, order.CreatedAt.Format("15:04"), order.Notes,
+		})
+		orderListing.compact = append(orderListing.compact, fmt.Sprintf("%s %s %s %s", order.ID.Hex(), order.CustomerName, order.Status, total))
+	}
+	return printListing(os.Stdout, orderListing)
+}
 
-	// Allow customer to place an order from the menu
-	fmt.Println("\nWelcome to the Restaurant Ordering System!")
-	PlaceOrder("Gadapa Raghavendra")
+// ShowDailySales lists the day's orders and revenue by hour
+func ShowDailySales(ctx context.Context, day time.Time) error {
+	sales, err := DailySales(ctx, day)
+	if err != nil {
+		return err
+	}
+	salesListing := listing{
+		title:   "Sales on " + day.Format("02 Jan 2006") + ":",
+		header:  []string{"Hour", "Orders", "Revenue", "Open items"},
+		records: sales,
+	}
+	if sales == nil {
+		salesListing.records = []HourlySales{}
+	}
+	for _, hour := range sales {
+		revenue := fmt.Sprintf("Rs %.2f", hour.Revenue)
+		var open string
+		if hour.OpenItems > 0 {
+			open = fmt.Sprintf("OPEN %d, Rs %.2f", hour.OpenItems, hour.OpenRevenue)
+		}
+		salesListing.rows = append(salesListing.rows, []string{fmt.Sprintf("%02d:00", hour.Hour), strconv.Itoa(hour.Orders), revenue, open})
+		salesListing.compact = append(salesListing.compact, strings.TrimSpace(fmt.Sprintf("%02d:00 %d %s %s", hour.Hour, hour.Orders, revenue, open)))
+	}
+	return printListing(os.Stdout, salesListing)
+}
 
-	// Display customers and their orders
-	GetCustomers()
+func main() {
+	err := newRootCommand().Execute()
+	closeApp()
+	if err != nil {
+		reportError(err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxSuggestions caps how many did-you-mean names are offered for a typo
+const maxSuggestions = 3
+
+// quantityPatterns match an order line ending in a quantity: "pizza x2", "3 x 2", "3x2" for a menu number, or
+// "pizza 2x". An x inside a name, as in "Fox 2" or "Mexican Rice", is not a quantity marker.
+var quantityPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)^(.*?\S)\s+x\s*(\d+)$`),
+	regexp.MustCompile(`(?i)^(\d+)x(\d+)$`),
+	regexp.MustCompile(`(?i)^(.*?\S)\s+(\d+)\s*x$`),
+}
+
+// ParseOrderEntry splits an order line such as "3 x2", "3x2", "pizza x2" or "Pizza"
+// into the item reference (a menu number or a name) and the quantity, which defaults to 1
+func ParseOrderEntry(input string) (string, int, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "", 0, errors.New("please enter an item number or name")
+	}
+	for _, pattern := range quantityPatterns {
+		match := pattern.FindStringSubmatch(input)
+		if match == nil {
+			continue
+		}
+		n, err := strconv.Atoi(match[2])
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid quantity %s", match[2])
+		}
+		if n < 1 {
+			return "", 0, fmt.Errorf("quantity must be at least 1, got %d", n)
+		}
+		return match[1], n, nil
+	}
+	return input, 1, nil
+}
+
+// FindMenuItem looks up an item by name, ignoring case and surrounding whitespace
+func FindMenuItem(menu []MenuItem, name string) (MenuItem, bool) {
+	name = strings.TrimSpace(name)
+	for _, item := range menu {
+		if strings.EqualFold(item.Name, name) {
+			return item, true
+		}
+	}
+	return MenuItem{}, false
+}
+
+// SuggestMenuItems returns the menu item names closest to name by edit distance,
+// best match first. Names that are too far off to be a plausible typo are skipped.
+func SuggestMenuItems(menu []MenuItem, name string) []string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return nil
+	}
+
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var candidates []candidate
+	for _, item := range menu {
+		itemName := strings.ToLower(item.Name)
+		distance := levenshtein(name, itemName)
+		// Allow roughly one typo per three characters, and always accept prefixes like "ice" for "Ice Cream"
+		if distance <= maxTypos(itemName) || strings.HasPrefix(itemName, name) {
+			candidates = append(candidates, candidate{item.Name, distance})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+	var suggestions []string
+	for i := 0; i < len(candidates) && i < maxSuggestions; i++ {
+		suggestions = append(suggestions, candidates[i].name)
+	}
+	return suggestions
+}
+
+// maxTypos is the largest edit distance still treated as a misspelling of name
+func maxTypos(name string) int {
+	typos := len([]rune(name)) / 3
+	if typos < 1 {
+		typos = 1
+	}
+	return typos
+}
+
+// levenshtein computes the edit distance between two strings
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
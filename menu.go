@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// prepareMenuItem checks an item before it is saved and normalizes its name and tags
+func prepareMenuItem(item *MenuItem) error {
+	item.Name = strings.TrimSpace(item.Name)
+	if item.Name == "" {
+		return fmt.Errorf("menu item name is required")
+	}
+	if item.Price <= 0 {
+		return fmt.Errorf("menu item price must be positive")
+	}
+	if err := validateNutrition(item.Nutrition); err != nil {
+		return err
+	}
+	if item.Hours != nil {
+		if err := item.Hours.Validate(); err != nil {
+			return err
+		}
+	}
+	if item.GSTRate != nil && (*item.GSTRate < 0 || *item.GSTRate > 100) {
+		return fmt.Errorf("GST rate must be a percentage")
+	}
+	item.TaxClass = strings.ToLower(strings.TrimSpace(item.TaxClass))
+	if err := checkTaxClass(item.TaxClass); err != nil {
+		return err
+	}
+	if item.PrepMinutes < 0 {
+		return fmt.Errorf("target prep time must not be negative")
+	}
+	if item.Packaging < 0 || item.ContainerDeposit < 0 {
+		return fmt.Errorf("packaging charge and container deposit must not be negative")
+	}
+	if err := validateRecipe(item.Recipe); err != nil {
+		return err
+	}
+	barcodes, err := cleanBarcodes(item.Barcodes)
+	if err != nil {
+		return err
+	}
+	item.Barcodes = barcodes
+	item.HSN = strings.TrimSpace(item.HSN)
+	item.Allergens, item.Diets = normalizeTags(item.Allergens), normalizeTags(item.Diets)
+	return nil
+}
+
+// AddMenuItem adds a single item to the menu, refusing duplicates by name
+func AddMenuItem(ctx context.Context, item MenuItem) error {
+	if err := prepareMenuItem(&item); err != nil {
+		return err
+	}
+	menu := loadHeadOfficeMenu(ctx)
+	if _, found := FindMenuItem(menu, item.Name); found {
+		return fmt.Errorf("%s is already on the menu", item.Name)
+	}
+	if err := checkBarcodesFree(menu, item); err != nil {
+		return err
+	}
+
+	err := storeFor(ctx).Menu().Add(ctx, item)
+	if err == ErrDuplicate {
+		return fmt.Errorf("%s is already on the menu", item.Name)
+	}
+	if err != nil {
+		return err
+	}
+	return recordPriceChange(ctx, item.Name, 0, item.Price)
+}
+
+// UpdateMenuItem applies change to the named item and saves it, recording any change of price.
+// The name cannot be changed. With a version other than 0 the item must still be at that version,
+// so an edit made from a stale copy is refused with ErrVersionConflict instead of overwriting
+// someone else's; with 0 the change is applied again to the latest copy if the item changed meanwhile.
+func UpdateMenuItem(ctx context.Context, name string, version int, change func(item *MenuItem)) (MenuItem, error) {
+	for attempt := 0; attempt < maxEventAttempts; attempt++ {
+		menu := loadHeadOfficeMenu(ctx)
+		item, found := FindMenuItem(menu, name)
+		if !found {
+			return MenuItem{}, ErrNotFound
+		}
+		if version != 0 && item.Version != version {
+			return MenuItem{}, fmt.Errorf("%s %w", item.Name, ErrVersionConflict)
+		}
+		storedName, oldPrice := item.Name, item.Price
+		change(&item)
+		item.Name = storedName
+		if err := prepareMenuItem(&item); err != nil {
+			return MenuItem{}, err
+		}
+		if err := checkBarcodesFree(menu, item); err != nil {
+			return MenuItem{}, err
+		}
+		err := storeFor(ctx).Menu().Update(ctx, item)
+		if errors.Is(err, ErrVersionConflict) {
+			if version == 0 {
+				continue
+			}
+			return MenuItem{}, fmt.Errorf("%s %w", item.Name, ErrVersionConflict)
+		}
+		if err != nil {
+			return MenuItem{}, err
+		}
+		item.Version++
+		if item.Price != oldPrice {
+			return item, recordPriceChange(ctx, item.Name, oldPrice, item.Price)
+		}
+		return item, nil
+	}
+	return MenuItem{}, fmt.Errorf("%s is changing too often, try again", name)
+}
+
+// DeleteMenuItem removes an item from the menu
+func DeleteMenuItem(ctx context.Context, name string) error {
+	return storeFor(ctx).Menu().Delete(ctx, name)
+}
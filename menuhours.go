@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a daily span of local time, e.g. 07:00 to 11:00. A window whose Until is
+// earlier than its From runs past midnight.
+type TimeWindow struct {
+	From  string `bson:"from" json:"from"`   // HH:MM
+	Until string `bson:"until" json:"until"` // HH:MM, exclusive
+}
+
+// categoryHours holds the serving hours of each menu category, e.g. breakfast, from RMS_MENU_HOURS
+var categoryHours = map[string]TimeWindow{}
+
+// ignoreMenuHours is the admin override that offers every item whatever the time
+var ignoreMenuHours bool
+
+// parseClock turns HH:MM into minutes after midnight
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Validate checks both ends of the window are valid times
+func (w TimeWindow) Validate() error {
+	if _, err := parseClock(w.From); err != nil {
+		return err
+	}
+	_, err := parseClock(w.Until)
+	return err
+}
+
+// Contains reports whether the local time of day of t falls inside the window
+func (w TimeWindow) Contains(t time.Time) bool {
+	from, err := parseClock(w.From)
+	if err != nil {
+		return false
+	}
+	until, err := parseClock(w.Until)
+	if err != nil {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	if from <= until {
+		return now >= from && now < until
+	}
+	return now >= from || now < until
+}
+
+func (w TimeWindow) String() string {
+	return w.From + "-" + w.Until
+}
+
+// SetMenuHours sets the category hours from a spec like "breakfast=07:00-11:00,dinner=18:00-23:00"
+func SetMenuHours(spec string) error {
+	hours := map[string]TimeWindow{}
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		category, span, ok := strings.Cut(entry, "=")
+		from, until, ok2 := strings.Cut(span, "-")
+		if !ok || !ok2 {
+			return fmt.Errorf("invalid menu hours %q (want category=HH:MM-HH:MM)", entry)
+		}
+		window := TimeWindow{From: strings.TrimSpace(from), Until: strings.TrimSpace(until)}
+		if err := window.Validate(); err != nil {
+			return fmt.Errorf("menu hours for %s: %w", category, err)
+		}
+		hours[strings.ToLower(strings.TrimSpace(category))] = window
+	}
+	categoryHours = hours
+	return nil
+}
+
+// servingHours returns the window an item is served in: its own hours, else its category's
+func servingHours(item MenuItem) (TimeWindow, bool) {
+	if item.Hours != nil {
+		return *item.Hours, true
+	}
+	window, ok := categoryHours[strings.ToLower(item.Category)]
+	return window, ok
+}
+
+// ItemAvailable reports whether the item is served at time t. Items without hours are served all day.
+func ItemAvailable(item MenuItem, t time.Time) bool {
+	window, ok := servingHours(item)
+	return !ok || window.Contains(t)
+}
+
+// ServedMenu returns the items within their serving hours at time t, keeping their order, or when the admin override
+// is on every item. Age-restricted items are left out in dry hours either way.
+func ServedMenu(menu []MenuItem, t time.Time) []MenuItem {
+	var served []MenuItem
+	for _, item := range menu {
+		if (ignoreMenuHours || ItemAvailable(item, t)) && !dryFor(item, t) {
+			served = append(served, item)
+		}
+	}
+	return served
+}
+
+// AvailableMenu returns the items that can be ordered at time t: those served then that are not 86'd
+func AvailableMenu(menu []MenuItem, t time.Time) []MenuItem {
+	var available []MenuItem
+	for _, item := range ServedMenu(menu, t) {
+		if !IsSoldOut(item, t) {
+			available = append(available, item)
+		}
+	}
+	return available
+}
+
+// UnavailableError stops an order for items that are 86'd or not served at the time it is placed
+type UnavailableError struct {
+	Items []string
+}
+
+func (e *UnavailableError) Error() string {
+	return "not available: " + strings.Join(e.Items, ", ")
+}
+
+// checkAvailability returns an *UnavailableError naming the lines that cannot be ordered at time t.
+// With ignoreHours only 86'd items and age-restricted items in dry hours are refused.
+func checkAvailability(menu []MenuItem, lines []OrderLine, t time.Time, ignoreHours bool) error {
+	var unavailable []string
+	for _, line := range lines {
+		item, found := FindMenuItem(menu, line.Name)
+		switch {
+		case !found:
+		case IsSoldOut(item, t):
+			unavailable = append(unavailable, item.Name+" (86'd today)")
+		case dryFor(item, t):
+			unavailable = append(unavailable, item.Name+" (not sold in dry hours)")
+		case !ignoreHours && !ItemAvailable(item, t):
+			window, _ := servingHours(item)
+			unavailable = append(unavailable, fmt.Sprintf("%s (served %s)", item.Name, window))
+		}
+	}
+	if len(unavailable) > 0 {
+		return &UnavailableError{Items: unavailable}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// MenuVersion is a published menu. Orders record the version they were placed against.
+type MenuVersion struct {
+	Version        int              `bson:"_id" json:"version"`
+	Items          []MenuItem       `bson:"items" json:"items"`
+	Note           string           `bson:"note,omitempty" json:"note,omitempty"`
+	PublishedBy    string           `bson:"publishedBy" json:"publishedBy"`
+	PublishedAt    time.Time        `bson:"publishedAt" json:"publishedAt"`
+	RolledBackFrom int              `bson:"rolledBackFrom,omitempty" json:"rolledBackFrom,omitempty"` // Version whose items were republished
+	Adjustment     *PriceAdjustment `bson:"adjustment,omitempty" json:"adjustment,omitempty"`         // Set when the version was a bulk price update
+	PriceUpdates   []PriceUpdate    `bson:"priceUpdates,omitempty" json:"priceUpdates,omitempty"`     // Prices the bulk update changed, to roll it back
+}
+
+// Errors publishing a menu that the caller can act on
+var (
+	ErrNoMenuDraft       = errors.New("there is no draft to publish")
+	ErrMenuPublishedRace = errors.New("another menu was published at the same time; try again")
+)
+
+// LoadMenuDraft returns the draft being edited, or a copy of the live menu to start one from
+func LoadMenuDraft(ctx context.Context) ([]MenuItem, error) {
+	draft, err := storeFor(ctx).MenuVersions().LoadDraft(ctx)
+	if errors.Is(err, ErrNotFound) {
+		return loadHeadOfficeMenu(ctx), nil
+	}
+	return draft, err
+}
+
+// SaveMenuDraft replaces the draft with items, which are checked as if added one by one.
+// Nothing changes for customers until the draft is published.
+func SaveMenuDraft(ctx context.Context, items []MenuItem) ([]MenuItem, error) {
+	if err := prepareMenu(items); err != nil {
+		return nil, err
+	}
+	return items, storeFor(ctx).MenuVersions().SaveDraft(ctx, items)
+}
+
+// DiscardMenuDraft throws the draft away
+func DiscardMenuDraft(ctx context.Context) error {
+	return storeFor(ctx).MenuVersions().DeleteDraft(ctx)
+}
+
+// prepareMenu checks every item of a whole menu and that no two share a name or a barcode
+func prepareMenu(items []MenuItem) error {
+	for i := range items {
+		if err := prepareMenuItem(&items[i]); err != nil {
+			return fmt.Errorf("item %d: %w", i+1, err)
+		}
+		if _, found := FindMenuItem(items[:i], items[i].Name); found {
+			return fmt.Errorf("%s is on the menu twice", items[i].Name)
+		}
+		if err := checkBarcodesFree(items[:i], items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PublishMenuDraft makes the draft the live menu as a new version and clears the draft
+func PublishMenuDraft(ctx context.Context, note string) (MenuVersion, error) {
+	draft, err := storeFor(ctx).MenuVersions().LoadDraft(ctx)
+	if errors.Is(err, ErrNotFound) {
+		return MenuVersion{}, ErrNoMenuDraft
+	}
+	if err != nil {
+		return MenuVersion{}, err
+	}
+	version, err := publishMenu(ctx, MenuVersion{Items: draft, Note: note})
+	if err != nil {
+		return MenuVersion{}, err
+	}
+	return version, DiscardMenuDraft(ctx)
+}
+
+// RollbackMenu publishes the items of an earlier version again, as a new version
+func RollbackMenu(ctx context.Context, to int) (MenuVersion, error) {
+	old, err := storeFor(ctx).MenuVersions().Find(ctx, to)
+	if err != nil {
+		return MenuVersion{}, err
+	}
+	return publishMenu(ctx, MenuVersion{Items: old.Items, Note: fmt.Sprintf("rollback to version %d", to), RolledBackFrom: to})
+}
+
+// publishMenu switches the live menu to version's items in one step. Items that are 86'd today stay
+// 86'd, and price changes are recorded as they would be for edits to single items.
+func publishMenu(ctx context.Context, version MenuVersion) (MenuVersion, error) {
+	latest, err := storeFor(ctx).MenuVersions().Latest(ctx)
+	if err != nil {
+		return MenuVersion{}, err
+	}
+	live := loadHeadOfficeMenu(ctx)
+	items := make([]MenuItem, len(version.Items))
+	copy(items, version.Items)
+	for i := range items {
+		// Publishing is a change to every item, so edits made from copies read before it are refused
+		items[i].SoldOutOn, items[i].Version = "", 1
+		if current, found := FindMenuItem(live, items[i].Name); found {
+			items[i].SoldOutOn, items[i].Version = current.SoldOutOn, current.Version+1
+		}
+	}
+
+	version.Version = latest + 1
+	version.Items = items
+	version.PublishedBy = changedBy(ctx)
+	version.PublishedAt = clock.Now()
+	err = storeFor(ctx).MenuVersions().Publish(ctx, version)
+	if errors.Is(err, ErrDuplicate) {
+		return MenuVersion{}, ErrMenuPublishedRace
+	}
+	if err != nil {
+		return MenuVersion{}, err
+	}
+	log.Printf("Menu version %d published by %s", version.Version, version.PublishedBy)
+
+	for _, item := range items {
+		current, found := FindMenuItem(live, item.Name)
+		if !found || current.Price != item.Price {
+			if err := recordPriceChange(ctx, item.Name, current.Price, item.Price); err != nil {
+				return version, err
+			}
+		}
+	}
+	return version, nil
+}
+
+// ListMenuVersions returns every published version, newest first
+func ListMenuVersions(ctx context.Context) ([]MenuVersion, error) {
+	versions, err := storeFor(ctx).MenuVersions().List(ctx)
+	if versions == nil {
+		versions = []MenuVersion{}
+	}
+	return versions, err
+}
+
+// FindMenuVersion returns a published version
+func FindMenuVersion(ctx context.Context, version int) (MenuVersion, error) {
+	return storeFor(ctx).MenuVersions().Find(ctx, version)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Notification is a message for a customer, addressed by phone number where one is known
+type Notification struct {
+	To      string `json:"to"`
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+	Channel string `json:"channel"` // sms, email or whatsapp; worked out from To when empty
+	Kind    string `json:"kind"`    // transactional or marketing; transactional when empty
+}
+
+// Notifier delivers notifications to customers over some channel (SMS gateway, display, log...)
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// LogNotifier writes notifications to the application log; it is the default when nothing else is configured
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(n Notification) error {
+	log.Printf("Notification for %s <%s>: %s - %s", n.Name, n.To, n.Subject, n.Message)
+	return nil
+}
+
+// WebhookNotifier posts notifications as JSON to an external gateway
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (w WebhookNotifier) Notify(n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	resp, err := w.Client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// notifier is used for every customer notification. Setting NOTIFY_WEBHOOK_URL sends them to a webhook.
+var notifier = defaultNotifier()
+
+func defaultNotifier() Notifier {
+	if url := os.Getenv("NOTIFY_WEBHOOK_URL"); url != "" {
+		return WebhookNotifier{URL: url, Client: &http.Client{Timeout: 5 * time.Second}}
+	}
+	return LogNotifier{}
+}
+
+// Notify sends a notification, logging rather than failing when delivery does not work,
+// so a broken gateway never blocks the kitchen or the till. A customer is only sent what they agreed to on the
+// channel, and marketing tells them how to stop it and is held back during quiet hours.
+func Notify(ctx context.Context, n Notification) {
+	if n.Channel == "" {
+		n.Channel = phoneChannel
+		if strings.Contains(n.To, "@") {
+			n.Channel = ChannelEmail
+		}
+	}
+	if n.Kind == "" {
+		n.Kind = MessageTransactional
+	}
+	if !notificationAllowed(ctx, n) {
+		debugf("Not sending %q to %s over %s: no consent for %s messages", n.Subject, n.Name, n.Channel, n.Kind)
+		return
+	}
+	if n.Kind == MessageMarketing {
+		// Marketing waits out the quiet hours; messages about orders always go
+		if until := quietUntil(clock.Now()); !until.IsZero() {
+			if err := holdNotification(ctx, n, until); err != nil {
+				log.Println("Error holding a notification until the quiet hours end:", err)
+			}
+			return
+		}
+		n.Message += " Reply STOP to unsubscribe."
+	}
+	if err := notifier.Notify(n); err != nil {
+		log.Println("Error sending notification:", err)
+	}
+}
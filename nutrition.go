@@ -0,0 +1,33 @@
+package main
+
+import "fmt"
+
+// Nutrition is the nutritional content of one serving of a menu item
+type Nutrition struct {
+	Calories int     `bson:"calories" json:"calories"` // kcal
+	Protein  float64 `bson:"protein" json:"protein"`   // Grams
+	Carbs    float64 `bson:"carbs" json:"carbs"`       // Grams
+	Fat      float64 `bson:"fat" json:"fat"`           // Grams
+}
+
+// String formats the nutrition the way the menu shows it, e.g. "850 kcal, P 32g C 96g F 34g"
+func (n Nutrition) String() string {
+	return fmt.Sprintf("%d kcal, P %.0fg C %.0fg F %.0fg", n.Calories, n.Protein, n.Carbs, n.Fat)
+}
+
+// validateNutrition rejects negative amounts
+func validateNutrition(n *Nutrition) error {
+	if n != nil && (n.Calories < 0 || n.Protein < 0 || n.Carbs < 0 || n.Fat < 0) {
+		return fmt.Errorf("nutrition values cannot be negative")
+	}
+	return nil
+}
+
+// CartCalories sums the calories of every line in the cart; items without nutrition count for nothing
+func CartCalories(lines []OrderLine) int {
+	var calories int
+	for _, line := range lines {
+		calories += line.Calories * line.Quantity
+	}
+	return calories
+}
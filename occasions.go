@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Occasions a customer can be sent an offer for
+const (
+	OccasionBirthday    = "birthday"
+	OccasionAnniversary = "anniversary"
+	OccasionComplaint   = "complaint" // Given to make up for a complaint; the coupon's Year is the complaint's number
+)
+
+// occasionInterval is how often the background job looks for upcoming occasions
+const occasionInterval = time.Hour
+
+// couponGrace is how long after the occasion its coupon can still be used
+const couponGrace = 7 * 24 * time.Hour
+
+// Coupon is a personal discount sent to a customer for their birthday or anniversary, or to make up for a complaint
+type Coupon struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Code         string             `bson:"code" json:"code"`
+	CustomerName string             `bson:"customerName" json:"customerName"`
+	Occasion     string             `bson:"occasion" json:"occasion"`
+	Year         int                `bson:"year" json:"year"` // Year of the occasion; each customer gets one coupon per occasion a year
+	Percent      float64            `bson:"percent" json:"percent"`
+	ValidFrom    time.Time          `bson:"validFrom" json:"validFrom"`
+	ValidUntil   time.Time          `bson:"validUntil" json:"validUntil"`
+	CreatedAt    time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// OccasionOffer is the discount sent ahead of customers' birthdays and anniversaries
+type OccasionOffer struct {
+	Percent   float64 // 0 turns the offers off
+	DaysAhead int     // How many days before the occasion the coupon is sent
+}
+
+// occasionOffer holds the offer in effect
+var occasionOffer = OccasionOffer{Percent: 10, DaysAhead: 7}
+
+// SetOccasionOffer reads the birthday and anniversary offer from the config
+func SetOccasionOffer(cfg Config) error {
+	offer := OccasionOffer{Percent: 10, DaysAhead: 7}
+	if cfg.OccasionDiscount != "" {
+		percent, err := strconv.ParseFloat(cfg.OccasionDiscount, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return fmt.Errorf("invalid occasion discount %q (want a percentage)", cfg.OccasionDiscount)
+		}
+		offer.Percent = percent
+	}
+	if cfg.OccasionDaysAhead != "" {
+		days, err := strconv.Atoi(cfg.OccasionDaysAhead)
+		if err != nil || days < 0 || days > 60 {
+			return fmt.Errorf("invalid occasion notice %q (want days, at most 60)", cfg.OccasionDaysAhead)
+		}
+		offer.DaysAhead = days
+	}
+	occasionOffer = offer
+	return nil
+}
+
+// parseOccasionDate checks a birthday or anniversary given as MM-DD, or as YYYY-MM-DD whose year is
+// dropped, returning it as MM-DD. An empty date clears it.
+func parseOccasionDate(occasion, date string) (string, error) {
+	date = strings.TrimSpace(date)
+	if date == "" {
+		return "", nil
+	}
+	if len(date) == len("2006-01-02") {
+		date = date[len("2006-"):]
+	}
+	// Parsed in a leap year so 02-29 is accepted
+	if _, err := time.Parse("2006-01-02", "2024-"+date); err != nil {
+		return "", fmt.Errorf("%s must be formatted as MM-DD", occasion)
+	}
+	return date, nil
+}
+
+// SetCustomerOccasions records a customer's birthday and anniversary as MM-DD, creating the customer if
+// needed. With a version other than 0 the customer must exist and still be at that version.
+func SetCustomerOccasions(ctx context.Context, name string, version int, birthday, anniversary string) (Customer, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Customer{}, fmt.Errorf("customer name is required")
+	}
+	birthday, err := parseOccasionDate(OccasionBirthday, birthday)
+	if err != nil {
+		return Customer{}, err
+	}
+	if anniversary, err = parseOccasionDate(OccasionAnniversary, anniversary); err != nil {
+		return Customer{}, err
+	}
+	err = storeFor(ctx).Customers().SetOccasions(ctx, name, version, birthday, anniversary)
+	if errors.Is(err, ErrVersionConflict) {
+		return Customer{}, fmt.Errorf("%s %w", name, ErrVersionConflict)
+	}
+	if err != nil {
+		return Customer{}, err
+	}
+	return storeFor(ctx).Customers().FindByName(ctx, name)
+}
+
+// nextOccurrence returns the next day on or after today that falls on the MM-DD date. Someone born on
+// 29 February celebrates on the 28th in other years.
+func nextOccurrence(date string, today time.Time) time.Time {
+	month, _ := strconv.Atoi(date[:2])
+	day, _ := strconv.Atoi(date[3:])
+	start := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+	for year := start.Year(); ; year++ {
+		occurs := time.Date(year, time.Month(month), day, 0, 0, 0, 0, start.Location())
+		if occurs.Month() != time.Month(month) {
+			occurs = time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, start.Location())
+		}
+		if !occurs.Before(start) {
+			return occurs
+		}
+	}
+}
+
+// newCouponCode makes a short code that is hard to guess, e.g. BDAY-3F9A2C
+func newCouponCode(occasion string) (string, error) {
+	secret := make([]byte, 3)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	prefix := "BDAY"
+	switch occasion {
+	case OccasionAnniversary:
+		prefix = "ANNIV"
+	case OccasionComplaint:
+		prefix = "SORRY"
+	}
+	return prefix + "-" + strings.ToUpper(hex.EncodeToString(secret)), nil
+}
+
+// SendOccasionOffers sends a coupon to every customer whose birthday or anniversary is coming up within
+// the offer's notice, returning the coupons sent. A customer already sent a coupon for the occasion this
+// year is skipped, so the job can run as often as it likes, from any number of terminals.
+func SendOccasionOffers(ctx context.Context, now time.Time) ([]Coupon, error) {
+	sent := []Coupon{}
+	if occasionOffer.Percent == 0 {
+		return sent, nil
+	}
+	customers, err := storeFor(ctx).Customers().List(ctx)
+	if err != nil {
+		return sent, err
+	}
+	horizon := now.AddDate(0, 0, occasionOffer.DaysAhead)
+	for _, customer := range customers {
+		if !customer.Communication.allows(MessageMarketing, phoneChannel) {
+			// A coupon the customer cannot be told about is not issued
+			continue
+		}
+		occasions := []struct{ occasion, date string }{
+			{OccasionBirthday, customer.Birthday}, {OccasionAnniversary, customer.Anniversary},
+		}
+		for _, o := range occasions {
+			if o.date == "" {
+				continue
+			}
+			occurs := nextOccurrence(o.date, now)
+			if occurs.After(horizon) {
+				continue
+			}
+			coupon, err := issueCoupon(ctx, customer, o.occasion, occurs, now)
+			if errors.Is(err, ErrDuplicate) {
+				continue
+			}
+			if err != nil {
+				return sent, err
+			}
+			sent = append(sent, coupon)
+		}
+	}
+	return sent, nil
+}
+
+// issueCoupon stores the customer's coupon for the occasion and sends it to them
+func issueCoupon(ctx context.Context, customer Customer, occasion string, occurs, now time.Time) (Coupon, error) {
+	code, err := newCouponCode(occasion)
+	if err != nil {
+		return Coupon{}, err
+	}
+	coupon := Coupon{
+		ID: primitive.NewObjectID(), Code: code, CustomerName: customer.Name, Occasion: occasion, Year: occurs.Year(),
+		Percent: occasionOffer.Percent, ValidFrom: now, ValidUntil: occurs.Add(couponGrace), CreatedAt: now,
+	}
+	if err := storeFor(ctx).Coupons().Add(ctx, coupon); err != nil {
+		return Coupon{}, err
+	}
+
+	greeting := "Happy birthday"
+	if occasion == OccasionAnniversary {
+		greeting = "Happy anniversary"
+	}
+	offer := templateMessage(ctx, TemplateOccasionOffer, customer.Phone, map[string]string{
+		"customerName": customer.Name, "greeting": greeting, "occasion": occasion, "code": coupon.Code,
+		"percent": fmt.Sprint(coupon.Percent), "validUntil": coupon.ValidUntil.Format("2 January"),
+	})
+	offer.Kind = MessageMarketing
+	Notify(ctx, offer)
+	return coupon, nil
+}
+
+// FindCoupon looks up a coupon by its code, ignoring case
+func FindCoupon(ctx context.Context, code string) (Coupon, error) {
+	return storeFor(ctx).Coupons().FindByCode(ctx, strings.ToUpper(strings.TrimSpace(code)))
+}
+
+// StartOccasionOffers sends birthday and anniversary coupons in the background
+func StartOccasionOffers() {
+	go func() {
+		for {
+			err := forEachTenant(context.TODO(), func(ctx context.Context) error {
+				if IsOffline() {
+					return nil
+				}
+				_, err := SendOccasionOffers(ctx, clock.Now())
+				return err
+			})
+			if err != nil {
+				log.Println("Occasion offers:", err)
+			}
+			time.Sleep(occasionInterval)
+		}
+	}()
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// offlineTokenBase is where tokens handed out while offline start, well clear of the
+// online counter, so they rarely collide with tokens issued by other terminals
+const offlineTokenBase = 900
+
+// syncInterval is how often the background sync checks whether the database is back
+const syncInterval = 15 * time.Second
+
+// Local store buckets
+var (
+	menuCacheBucket = []byte("menu")
+	queueBucket     = []byte("queue")
+	countersBucket  = []byte("counters")
+)
+
+// Kinds of writes held in the local queue
+const (
+	pendingOrder   = "order"
+	pendingPayment = "payment"
+)
+
+// pendingWrite is a write made while offline, waiting to be replayed against the database
+type pendingWrite struct {
+	Kind     string    `json:"kind"`
+	Order    *Order    `json:"order,omitempty"`
+	Payment  *Payment  `json:"payment,omitempty"`
+	QueuedAt time.Time `json:"queuedAt"`
+}
+
+// SyncConflict records a queued write that could not be replayed exactly as it was made offline
+type SyncConflict struct {
+	Kind       string             `bson:"kind" json:"kind"`
+	DocumentID primitive.ObjectID `bson:"documentId" json:"documentId"`
+	Reason     string             `bson:"reason" json:"reason"`
+	Resolution string             `bson:"resolution" json:"resolution"`
+	QueuedAt   time.Time          `bson:"queuedAt" json:"queuedAt"`
+	SyncedAt   time.Time          `bson:"syncedAt" json:"syncedAt"`
+}
+
+var (
+	offlineStore *bbolt.DB
+	offlineMu    sync.Mutex
+	offline      bool
+)
+
+// OpenOfflineStore opens the local file that caches the menu and queues writes while the database is down
+func OpenOfflineStore(path string) error {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{menuCacheBucket, queueBucket, countersBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+	offlineStore = db
+	return nil
+}
+
+// IsOffline reports whether writes are currently being queued locally
+func IsOffline() bool {
+	offlineMu.Lock()
+	defer offlineMu.Unlock()
+	return offline
+}
+
+// GoOffline switches to queueing writes locally after a connectivity failure
+func GoOffline(cause error) {
+	offlineMu.Lock()
+	defer offlineMu.Unlock()
+	if offlineStore == nil {
+		return
+	}
+	if !offline {
+		log.Println("Database unreachable, switching to offline mode:", cause)
+	}
+	offline = true
+}
+
+// cacheMenu keeps a local copy of the menu so orders can still be taken offline
+func cacheMenu(menu []MenuItem) {
+	if offlineStore == nil {
+		return
+	}
+	data, err := json.Marshal(menu)
+	if err != nil {
+		return
+	}
+	err = offlineStore.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(menuCacheBucket).Put([]byte("items"), data)
+	})
+	if err != nil {
+		log.Println("Error caching menu:", err)
+	}
+}
+
+// cachedMenu returns the menu as it was last loaded from the database
+func cachedMenu() ([]MenuItem, error) {
+	if offlineStore == nil {
+		return nil, errors.New("offline store not available")
+	}
+	var menu []MenuItem
+	err := offlineStore.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(menuCacheBucket).Get([]byte("items"))
+		if data == nil {
+			return errors.New("no menu has been cached yet")
+		}
+		return json.Unmarshal(data, &menu)
+	})
+	return menu, err
+}
+
+// enqueue appends a write to the local queue; keys are sequence numbers so replay keeps the original order
+func enqueue(write pendingWrite) error {
+	if offlineStore == nil {
+		return errors.New("offline store not available")
+	}
+	write.QueuedAt = time.Now()
+	data, err := json.Marshal(write)
+	if err != nil {
+		return err
+	}
+	return offlineStore.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(queueBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return bucket.Put(key, data)
+	})
+}
+
+// QueueOrder holds an order locally until the database is reachable again, giving takeaway
+// orders a token from the local offline range
+func QueueOrder(order Order) (Order, error) {
+	if order.Type == OrderTakeaway && order.Token == 0 {
+		token, err := nextOfflineToken(order.CreatedAt)
+		if err != nil {
+			return Order{}, err
+		}
+		order.Token = token
+	}
+	if err := enqueue(pendingWrite{Kind: pendingOrder, Order: &order}); err != nil {
+		return Order{}, err
+	}
+	return order, nil
+}
+
+// QueuePayment holds a payment locally until the database is reachable again
+func QueuePayment(payment Payment) error {
+	return enqueue(pendingWrite{Kind: pendingPayment, Payment: &payment})
+}
+
+// nextOfflineToken hands out takeaway tokens for the day while offline
+func nextOfflineToken(t time.Time) (int, error) {
+	var token int
+	err := offlineStore.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(countersBucket)
+		key := []byte("token:" + t.Format("2006-01-02"))
+		seq := offlineTokenBase
+		if data := bucket.Get(key); data != nil {
+			seq = int(binary.BigEndian.Uint64(data))
+		}
+		seq++
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, uint64(seq))
+		token = seq
+		return bucket.Put(key, data)
+	})
+	return token, err
+}
+
+// PendingOrders lists the orders still waiting in the local queue, so the kitchen can see them offline
+func PendingOrders() []Order {
+	var orders []Order
+	if offlineStore == nil {
+		return orders
+	}
+	offlineStore.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(queueBucket).ForEach(func(_, data []byte) error {
+			var write pendingWrite
+			if json.Unmarshal(data, &write) == nil && write.Kind == pendingOrder {
+				orders = append(orders, *write.Order)
+			}
+			return nil
+		})
+	})
+	return orders
+}
+
+// StartSync checks the database in the background and replays queued writes once it is reachable
+func StartSync() {
+	if offlineStore == nil {
+		return
+	}
+	go func() {
+		for {
+			if err := SyncPending(); err != nil {
+				log.Println("Offline sync:", err)
+			}
+			time.Sleep(syncInterval)
+		}
+	}()
+}
+
+// SyncPending replays the local queue against the database in the order the writes were made.
+// It stops at the first connectivity failure and leaves the rest queued for the next attempt.
+func SyncPending() error {
+	if offlineStore == nil {
+		return nil
+	}
+	ctx := context.TODO()
+	if err := storeFor(ctx).Ping(ctx); err != nil {
+		GoOffline(err)
+		return nil
+	}
+
+	for {
+		key, write, err := nextPending()
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			break
+		}
+
+		err = replay(ctx, write)
+		if storeFor(ctx).Unavailable(err) {
+			GoOffline(err)
+			return nil
+		}
+		if err != nil {
+			// Keep going rather than blocking the queue forever on one bad write; it is logged for review
+			recordSyncConflict(ctx, write, documentID(write), "could not be replayed: "+err.Error(), "dropped")
+		}
+		if err := offlineStore.Update(func(tx *bbolt.Tx) error {
+			return tx.Bucket(queueBucket).Delete(key)
+		}); err != nil {
+			return err
+		}
+	}
+
+	offlineMu.Lock()
+	if offline {
+		log.Println("Database reachable again, offline queue synced")
+	}
+	offline = false
+	offlineMu.Unlock()
+	return nil
+}
+
+// nextPending returns the oldest queued write, or a nil key when the queue is empty
+func nextPending() ([]byte, pendingWrite, error) {
+	var key []byte
+	var write pendingWrite
+	err := offlineStore.View(func(tx *bbolt.Tx) error {
+		k, data := tx.Bucket(queueBucket).Cursor().First()
+		if k == nil {
+			return nil
+		}
+		key = append([]byte(nil), k...)
+		return json.Unmarshal(data, &write)
+	})
+	return key, write, err
+}
+
+// replay applies one queued write, resolving conflicts with what happened online in the meantime
+func replay(ctx context.Context, write pendingWrite) error {
+	switch write.Kind {
+	case pendingOrder:
+		return replayOrder(ctx, write)
+	case pendingPayment:
+		return replayPayment(ctx, write)
+	}
+	return fmt.Errorf("unknown queued write %q", write.Kind)
+}
+
+func replayOrder(ctx context.Context, write pendingWrite) error {
+	order := *write.Order
+
+	// The order keeps the ID it was given offline, so one that already made it to the database is skipped
+	if _, err := FindOrder(ctx, order.ID); err == nil {
+		return nil
+	} else if err != ErrNotFound {
+		return err
+	}
+
+	// Another terminal may have handed out the same token while this one was offline
+	if order.Token > 0 {
+		taken, err := tokenTaken(ctx, order.CreatedAt, order.Token)
+		if err != nil {
+			return err
+		}
+		if taken {
+			offlineToken := order.Token
+			if order.Token, err = NextToken(ctx, order.CreatedAt); err != nil {
+				return err
+			}
+			recordSyncConflict(ctx, write, order.ID, fmt.Sprintf("token %d was already in use", offlineToken), fmt.Sprintf("reassigned token %d", order.Token))
+			Notify(ctx, templateMessage(ctx, TemplateTokenChanged, customerPhone(ctx, order.CustomerName), map[string]string{
+				"customerName": order.CustomerName, "oldToken": fmt.Sprint(offlineToken), "token": fmt.Sprint(order.Token),
+			}))
+		}
+	}
+
+	_, err := submitOrderOnline(ctx, order)
+	return err
+}
+
+func replayPayment(ctx context.Context, write pendingWrite) error {
+	payment := *write.Payment
+
+	if _, err := storeFor(ctx).Payments().Find(ctx, payment.ID); err == nil {
+		return nil
+	} else if err != ErrNotFound {
+		return err
+	}
+
+	// Money was taken either way, so the payment is always recorded; overpayment is flagged for a refund
+	order, err := FindOrder(ctx, payment.OrderID)
+	if err != nil {
+		return err
+	}
+	if order.Paid {
+		recordSyncConflict(ctx, write, payment.ID, "order was already paid while this terminal was offline", "recorded, refund needed")
+	}
+	return insertPayment(ctx, payment)
+}
+
+// tokenTaken reports whether an order on the day of t already uses the token
+func tokenTaken(ctx context.Context, t time.Time, token int) (bool, error) {
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return storeFor(ctx).Orders().TokenInUse(ctx, start, start.AddDate(0, 0, 1), token)
+}
+
+// documentID returns the ID of the order or payment a queued write creates
+func documentID(write pendingWrite) primitive.ObjectID {
+	if write.Order != nil {
+		return write.Order.ID
+	}
+	if write.Payment != nil {
+		return write.Payment.ID
+	}
+	return primitive.NilObjectID
+}
+
+// recordSyncConflict keeps a record of every queued write that needed resolving, for a manager to review
+func recordSyncConflict(ctx context.Context, write pendingWrite, id primitive.ObjectID, reason, resolution string) {
+	log.Printf("Offline sync conflict on %s %s: %s (%s)", write.Kind, id.Hex(), reason, resolution)
+	conflict := SyncConflict{
+		Kind:       write.Kind,
+		DocumentID: id,
+		Reason:     reason,
+		Resolution: resolution,
+		QueuedAt:   write.QueuedAt,
+		SyncedAt:   time.Now(),
+	}
+	if err := storeFor(ctx).SyncConflicts().Insert(ctx, conflict); err != nil {
+		log.Println("Error recording sync conflict:", err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrOpenItemNotAllowed is returned when the caller's role may not ring up open items
+var ErrOpenItemNotAllowed = errors.New("your role may not ring up open items")
+
+// maxOpenItemName caps the description of an open item, so it fits on a receipt line
+const maxOpenItemName = 40
+
+// maxOpenItemPrice caps the price of an open item, to catch a mistyped amount
+const maxOpenItemPrice = 100000
+
+// openItemRoles are the staff roles that may ring up open items, from RMS_OPEN_ITEM_ROLES. Callers without a role,
+// such as the owner's key or the command line, always may.
+var openItemRoles = []string{RoleCashier, RoleManager}
+
+// SetOpenItemRoles sets the staff roles that may ring up open items from a spec like "cashier,manager"; an empty
+// spec leaves cashiers and managers
+func SetOpenItemRoles(spec string) error {
+	if strings.TrimSpace(spec) == "" {
+		openItemRoles = []string{RoleCashier, RoleManager}
+		return nil
+	}
+	var parsed []string
+	for _, role := range strings.Split(spec, ",") {
+		role = strings.ToLower(strings.TrimSpace(role))
+		if role == "" {
+			continue
+		}
+		if !slices.Contains(staffRoles, role) {
+			return fmt.Errorf("unknown role %q in open item roles (want one of %s)", role, strings.Join(staffRoles, ", "))
+		}
+		parsed = append(parsed, role)
+	}
+	openItemRoles = parsed
+	return nil
+}
+
+// OpenItemLine is a line for something not on the menu, such as a custom cake or corkage, described and priced by
+// the cashier when it is ordered. Only callers whose role is in RMS_OPEN_ITEM_ROLES may ring one up.
+func OpenItemLine(ctx context.Context, menu []MenuItem, description string, price float64, quantity int) (OrderLine, error) {
+	if record, ok := APIKeyFrom(ctx); ok && record.Role != "" && !slices.Contains(openItemRoles, record.Role) {
+		return OrderLine{}, ErrOpenItemNotAllowed
+	}
+	description, err := cleanNote(description)
+	if err != nil {
+		return OrderLine{}, err
+	}
+	switch {
+	case description == "":
+		return OrderLine{}, fmt.Errorf("an open item needs a description")
+	case utf8.RuneCountInString(description) > maxOpenItemName:
+		return OrderLine{}, fmt.Errorf("an open item's description must be at most %d characters", maxOpenItemName)
+	case price <= 0 || price > maxOpenItemPrice:
+		return OrderLine{}, fmt.Errorf("an open item's price must be more than 0 and at most %d", maxOpenItemPrice)
+	case quantity < 1:
+		return OrderLine{}, fmt.Errorf("quantity must be at least 1")
+	}
+	// An open item named like a menu item would be taken for it by the kitchen, the reports and the tax breakup
+	if item, found := FindMenuItem(menu, description); found {
+		return OrderLine{}, fmt.Errorf("%s is on the menu; order it from there", item.Name)
+	}
+	line := OrderLine{Name: description, Price: roundPaise(price), Quantity: quantity, Open: true}
+	if pricesExcludeTax {
+		line.TaxRate = gst.Rate
+	}
+	return line, nil
+}
+
+// askOpenItem asks the cashier for the description and price of an open item, reporting false if none was added
+func askOpenItem(ctx context.Context, reader *bufio.Reader, menu []MenuItem) (OrderLine, bool) {
+	fmt.Println("Describe the item, e.g. 'custom cake':")
+	description, _ := reader.ReadString('\n')
+	fmt.Println("Price in rupees:")
+	input, _ := reader.ReadString('\n')
+	price, err := strconv.ParseFloat(strings.TrimSpace(input), 64)
+	if err != nil {
+		fmt.Println("Not added: the price must be a number")
+		return OrderLine{}, false
+	}
+	line, err := OpenItemLine(ctx, menu, description, price, 1)
+	if err != nil {
+		fmt.Println("Not added:", err)
+		return OrderLine{}, false
+	}
+	fmt.Printf("Added open item %s at Rs %.2f\n", line.Name, line.Price)
+	return line, true
+}
+
+// OpenItemSale is an open item rung up on an order
+type OpenItemSale struct {
+	OrderID  primitive.ObjectID `json:"orderId"`
+	At       time.Time          `json:"at"`
+	Waiter   string             `json:"waiter,omitempty"`
+	Name     string             `json:"name"`
+	Quantity int                `json:"quantity"`
+	Price    float64            `json:"price"`
+	Amount   float64            `json:"amount"`
+}
+
+// OpenItemSales lists the open items on orders created in [from, to), oldest first, so what was charged off the
+// menu can be checked
+func OpenItemSales(ctx context.Context, from, to time.Time) ([]OpenItemSale, error) {
+	orders, err := LoadOrdersBetween(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	sales := []OpenItemSale{}
+	for _, order := range orders {
+		for _, line := range order.Items {
+			if !line.Open {
+				continue
+			}
+			sales = append(sales, OpenItemSale{
+				OrderID: order.ID, At: order.CreatedAt, Waiter: order.Waiter, Name: line.Name, Quantity: line.Quantity,
+				Price: line.Price, Amount: roundPaise(line.Amount()),
+			})
+		}
+	}
+	return sales, nil
+}
+
+// ShowOpenItemSales prints the open items rung up in [from, to)
+func ShowOpenItemSales(ctx context.Context, from, to time.Time) error {
+	sales, err := OpenItemSales(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	title := fmt.Sprintf("Open items from %s to %s:", from.Format("02 Jan 2006"), to.AddDate(0, 0, -1).Format("02 Jan 2006"))
+	openListing := listing{title: title, header: []string{"Time", "Order", "Waiter", "Item", "Qty", "Price", "Amount"}, records: sales}
+	for _, sale := range sales {
+		amount := fmt.Sprintf("Rs %.2f", sale.Amount)
+		openListing.rows = append(openListing.rows, []string{
+			sale.At.Format("02 Jan 15:04"), sale.OrderID.Hex(), sale.Waiter, sale.Name, fmt.Sprint(sale.Quantity),
+			fmt.Sprintf("Rs %.2f", sale.Price), amount,
+		})
+		openListing.compact = append(openListing.compact, fmt.Sprintf("%s %d x %s %s", sale.At.Format("02 Jan 15:04"), sale.Quantity, sale.Name, amount))
+	}
+	return printListing(os.Stdout, openListing)
+}
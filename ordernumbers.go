@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// orderNumberPattern is an order number format: a short prefix and the first number of the day, e.g. A-101
+var orderNumberPattern = regexp.MustCompile(`^([A-Za-z]{0,3}[-/]?)(\d{1,6})$`)
+
+// orderNumberPrefix and orderNumberStart are how orders are numbered each day, from RMS_ORDER_NUMBERS
+var (
+	orderNumberPrefix = "A-"
+	orderNumberStart  = 101
+)
+
+// SetOrderNumbers sets how orders are numbered each day from an example of the first number, e.g. "A-101" or "K1";
+// an empty format leaves A-101
+func SetOrderNumbers(format string) error {
+	orderNumberPrefix, orderNumberStart = "A-", 101
+	format = strings.TrimSpace(format)
+	if format == "" {
+		return nil
+	}
+	match := orderNumberPattern.FindStringSubmatch(format)
+	if match == nil {
+		return fmt.Errorf("invalid order number format %q (want a prefix of up to 3 letters and the first number, e.g. A-101)", format)
+	}
+	start, _ := strconv.Atoi(match[2])
+	orderNumberPrefix, orderNumberStart = strings.ToUpper(match[1]), start
+	return nil
+}
+
+// NextOrderNumber returns the next order number for the day of t. Like tokens they come from a counter in the
+// database keyed by date, so every terminal and server hands out a different number and they start again daily.
+func NextOrderNumber(ctx context.Context, t time.Time) (string, error) {
+	seq, err := storeFor(ctx).Counters().Next(ctx, "order:"+t.Format("2006-01-02"))
+	if err != nil {
+		return "", err
+	}
+	return orderNumberPrefix + strconv.Itoa(orderNumberStart+seq-1), nil
+}
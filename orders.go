@@ -0,0 +1,575 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Order statuses, in the sequence the kitchen moves a ticket through them
+const (
+	StatusQueued    = "queued"
+	StatusPreparing = "preparing"
+	StatusReady     = "ready"
+	StatusServed    = "served"
+)
+
+// Order types
+const (
+	OrderDineIn    = "dine-in"
+	OrderTakeaway  = "takeaway"
+	OrderCatering  = "catering"   // From an approved catering quote, for a later date
+	OrderDelivery  = "delivery"   // Taken to the customer; charged the RMS_DELIVERY_CHARGES rules and its zone's fee
+	OrderStaffMeal = "staff-meal" // An employee's own meal, for the customer named; billed at cost less their allowance
+)
+
+// orderStatusFlow maps each status to the one that follows it in the kitchen
+var orderStatusFlow = map[string]string{
+	StatusQueued:    StatusPreparing,
+	StatusPreparing: StatusReady,
+	StatusReady:     StatusServed,
+}
+
+// OrderLine is a single menu item and quantity on an order
+type OrderLine struct {
+	Name     string   `bson:"name" json:"name"`
+	Price    float64  `bson:"price" json:"price"`
+	Quantity int      `bson:"quantity" json:"quantity"`
+	Calories int      `bson:"calories,omitempty" json:"calories,omitempty"` // Per unit, as on the menu when ordered
+	Course   int      `bson:"course,omitempty" json:"course,omitempty"`     // 0 or 1 for the first course; later courses are held
+	Seat     int      `bson:"seat,omitempty" json:"seat,omitempty"`         // Guest seat at the table, for splitting the bill; 0 is shared
+	Note     string   `bson:"note,omitempty" json:"note,omitempty"`         // Special instructions for the kitchen, e.g. "no ice"
+	Flags    []string `bson:"flags,omitempty" json:"flags,omitempty"`       // LineFlagAllergy or LineFlagRush, shown prominently to the kitchen
+	Weight   float64  `bson:"weight,omitempty" json:"weight,omitempty"`     // Kilograms of each, for an item sold by weight; Price is then per kg
+	Open     bool     `bson:"open,omitempty" json:"open,omitempty"`         // Not on the menu: Name and Price were typed by the cashier
+	Discount float64  `bson:"discount,omitempty" json:"discount,omitempty"` // Percentage off this line, taken off its Amount; 100 comps it
+	TaxRate  float64  `bson:"taxRate,omitempty" json:"taxRate,omitempty"`   // Tax percentage added to its Amount, when prices excluded tax
+	// DiscountReason is why the line was discounted or comped
+	DiscountReason *DiscountReason `bson:"discountReason,omitempty" json:"discountReason,omitempty"`
+}
+
+// Amount is what the line costs: the price times the quantity and, for an item sold by weight, times its weight,
+// less the line's own discount
+func (line OrderLine) Amount() float64 {
+	amount := line.Price * float64(line.Quantity)
+	if line.Weight > 0 {
+		amount = roundPaise(amount * line.Weight)
+	}
+	if line.Discount > 0 {
+		amount = roundPaise(amount * (1 - line.Discount/100))
+	}
+	return amount
+}
+
+// weightDetail describes the weight and rate of a line sold by weight for receipts, e.g. "1.250 kg @ 480.00/kg";
+// it is empty for other lines
+func weightDetail(line OrderLine) string {
+	if line.Weight <= 0 {
+		return ""
+	}
+	detail := fmt.Sprintf("%.3f kg @ %.2f/kg", line.Weight, line.Price)
+	if line.Quantity > 1 {
+		detail = fmt.Sprintf("%d x %s", line.Quantity, detail)
+	}
+	return detail
+}
+
+// Order is a ticket sent to the kitchen for one customer, optionally at a table
+type Order struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CustomerName string             `bson:"customerName" json:"customerName"`
+	Table        int                `bson:"table,omitempty" json:"table,omitempty"` // 0 means no table (counter or takeaway)
+	Type         string             `bson:"type" json:"type"`
+	Number       string             `bson:"number,omitempty" json:"number,omitempty"` // Daily order number for people, e.g. A-104, from RMS_ORDER_NUMBERS
+	Token        int                `bson:"token,omitempty" json:"token,omitempty"`   // Daily pickup number for takeaway orders
+	Waiter       string             `bson:"waiter,omitempty" json:"waiter,omitempty"` // Who took the order, for performance reports
+	Covers       int                `bson:"covers,omitempty" json:"covers,omitempty"` // Guests served; 0 when not given
+	Notes        string             `bson:"notes,omitempty" json:"notes,omitempty"`   // Instructions for the whole order, e.g. "birthday - bring a candle"
+	Items        []OrderLine        `bson:"items" json:"items"`
+	Total        float64            `bson:"total" json:"total"`
+	Discount     float64            `bson:"discount,omitempty" json:"discount,omitempty"` // Percentage off the bill, taken off Total
+	// DiscountReason is why the bill was discounted
+	DiscountReason *DiscountReason `bson:"discountReason,omitempty" json:"discountReason,omitempty"`
+	Charges        []OrderCharge   `bson:"charges,omitempty" json:"charges,omitempty"` // Added to the bill when it is placed, in Total; not discounted
+	RoundTo        float64         `bson:"roundTo,omitempty" json:"roundTo,omitempty"` // Rupees Total is rounded to, from RMS_BILL_ROUNDING; 0 when it is not
+	// ServiceChargeWaived records the service charge being taken off the bill at the customer's request
+	ServiceChargeWaived *ServiceChargeWaiver `bson:"serviceChargeWaived,omitempty" json:"serviceChargeWaived,omitempty"`
+	// TaxOnTop is set when the order was placed while menu prices excluded tax, so Total adds it to them
+	TaxOnTop bool `bson:"taxOnTop,omitempty" json:"taxOnTop,omitempty"`
+	// BillToken is the secret in the digital bill link its receipts carry as a QR code
+	BillToken string `bson:"billToken,omitempty" json:"billToken,omitempty"`
+	// ReusableContainers is set when the customer takes the items in reusable containers, for a deposit
+	ReusableContainers bool               `bson:"reusableContainers,omitempty" json:"reusableContainers,omitempty"`
+	DeliveryAddress    *Address           `bson:"deliveryAddress,omitempty" json:"deliveryAddress,omitempty"`
+	Calories           int                `bson:"calories,omitempty" json:"calories,omitempty"` // Total for every line
+	Status             string             `bson:"status" json:"status"`
+	AmountPaid         float64            `bson:"amountPaid" json:"amountPaid"`
+	Paid               bool               `bson:"paid" json:"paid"`
+	CreatedAt          time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt          time.Time          `bson:"updatedAt" json:"updatedAt"`                         // When the last event applied to the order happened
+	Version            int                `bson:"version" json:"version"`                             // Seq of the last event applied to the order
+	MenuVersion        int                `bson:"menuVersion,omitempty" json:"menuVersion,omitempty"` // Published menu the order was placed against; 0 before menus were versioned
+	Courses            []CourseTicket     `bson:"courses,omitempty" json:"courses,omitempty"`
+	ScheduledFor       *time.Time         `bson:"scheduledFor,omitempty" json:"scheduledFor,omitempty"` // When a catering order or pre-order is to be ready
+	Branch             string             `bson:"branch,omitempty" json:"branch,omitempty"`             // The branch it was placed at; empty for the main one
+	Reprints           int                `bson:"reprints,omitempty" json:"reprints,omitempty"`         // Duplicate receipts printed since it was settled
+	DeviceID           primitive.ObjectID `bson:"deviceId,omitempty" json:"deviceId,omitzero"`          // The POS terminal or kiosk it was placed on, if any
+	Device             string             `bson:"device,omitempty" json:"device,omitempty"`             // That device's name
+	Source             *RequestSource     `bson:"source,omitempty" json:"source,omitempty"`             // Where it was placed through the API from
+	// AllergyOverride is set when staff confirmed with the customer that an item they are allergic to should be sent
+	AllergyOverride bool `bson:"allergyOverride,omitempty" json:"allergyOverride,omitempty"`
+	// IDVerified records who confirmed the guest's ID was checked, needed before age-restricted items are ordered
+	IDVerified *IDCheck `bson:"idVerified,omitempty" json:"idVerified,omitempty"`
+	// IgnoreMenuHours is set when an admin allowed items outside their serving hours
+	IgnoreMenuHours bool `bson:"ignoreMenuHours,omitempty" json:"ignoreMenuHours,omitempty"`
+	// RemakeOf is the order a free remake was made for, to put a complaint right; remakes carry no charges
+	RemakeOf primitive.ObjectID `bson:"remakeOf,omitempty" json:"remakeOf,omitzero"`
+	// Wasted is what was voided after it went to the kitchen: off the bill, but its stock was used
+	Wasted []OrderLine `bson:"wasted,omitempty" json:"wasted,omitempty"`
+}
+
+// AddToCart adds quantity of a menu item to the cart, merging with an existing line for the same item
+func AddToCart(lines []OrderLine, item MenuItem, quantity int) []OrderLine {
+	line := OrderLine{Name: item.Name, Price: item.Price, Quantity: quantity}
+	if pricesExcludeTax {
+		line.TaxRate = itemTaxClass(item).Rate
+	}
+	// Nutrition is per serving, which says nothing about a weighed portion
+	if item.Nutrition != nil && !item.SoldByWeight {
+		line.Calories = item.Nutrition.Calories
+	}
+	return addLine(lines, line)
+}
+
+// maxLineWeight caps the kilograms of each of an item sold by weight, to catch grams typed as kilograms
+const maxLineWeight = 50
+
+// weighLine sets the weight of a line of item, rounded to the gram. An item sold by weight needs one; other items
+// must not be given one.
+func weighLine(line *OrderLine, item MenuItem, weight float64) error {
+	if !item.SoldByWeight {
+		if weight != 0 {
+			return fmt.Errorf("%s is not sold by weight", item.Name)
+		}
+		return nil
+	}
+	weight = math.Round(weight*1000) / 1000
+	if weight <= 0 || weight > maxLineWeight {
+		return fmt.Errorf("%s is sold by weight: the weight must be more than 0 and at most %d kg", item.Name, maxLineWeight)
+	}
+	line.Weight = weight
+	return nil
+}
+
+// checkWeights checks that every line of an item sold by weight was weighed, and no other line was
+func checkWeights(menu []MenuItem, lines []OrderLine) error {
+	for _, line := range lines {
+		item, found := FindMenuItem(menu, line.Name)
+		if !found {
+			continue
+		}
+		if item.SoldByWeight && line.Weight <= 0 {
+			return fmt.Errorf("%s is sold by weight: give the weight of each", line.Name)
+		}
+		if !item.SoldByWeight && line.Weight != 0 {
+			return fmt.Errorf("%s is not sold by weight", line.Name)
+		}
+	}
+	return nil
+}
+
+// addLine adds a line to the cart, merging it with an existing line for the same item in the same course and seat.
+// Lines with different notes, flags, weights or discounts are kept apart, so "no ice" is not lost on the other drinks,
+// and so are open items at different prices.
+func addLine(lines []OrderLine, line OrderLine) []OrderLine {
+	i := slices.IndexFunc(lines, func(l OrderLine) bool {
+		return l.Name == line.Name && lineCourse(l) == lineCourse(line) && l.Seat == line.Seat && l.Note == line.Note &&
+			slices.Equal(l.Flags, line.Flags) && l.Weight == line.Weight && l.Open == line.Open && (!l.Open || l.Price == line.Price) &&
+			l.Discount == line.Discount && discountCode(l) == discountCode(line)
+	})
+	if i >= 0 {
+		lines[i].Quantity += line.Quantity
+		return lines
+	}
+	return append(lines, line)
+}
+
+// maxNoteLength caps an order or line note, so it fits on a kitchen ticket
+const maxNoteLength = 140
+
+// cleanNote tidies a note typed by staff or sent through the API: control characters such as newlines,
+// which would break a printed ticket, become spaces and runs of spaces are collapsed
+func cleanNote(note string) (string, error) {
+	note = strings.Join(strings.Fields(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return ' '
+		}
+		return r
+	}, note)), " ")
+	if utf8.RuneCountInString(note) > maxNoteLength {
+		return "", fmt.Errorf("notes must be at most %d characters", maxNoteLength)
+	}
+	return note, nil
+}
+
+// cleanLine cleans the note and checks the flags on an order line
+func cleanLine(line *OrderLine) error {
+	note, err := cleanNote(line.Note)
+	if err != nil {
+		return fmt.Errorf("%s: %w", line.Name, err)
+	}
+	flags, err := cleanLineFlags(line.Flags)
+	if err != nil {
+		return fmt.Errorf("%s: %w", line.Name, err)
+	}
+	line.Note, line.Flags = note, flags
+	return nil
+}
+
+// cleanOrderInstructions cleans the order's notes and the note and flags on each of its lines
+func cleanOrderInstructions(order *Order) error {
+	notes, err := cleanNote(order.Notes)
+	if err != nil {
+		return err
+	}
+	order.Notes = notes
+	for i := range order.Items {
+		if err := cleanLine(&order.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CartTotal sums the price of every line in the cart
+func CartTotal(lines []OrderLine) float64 {
+	var total float64
+	for _, line := range lines {
+		total += line.Amount()
+	}
+	return total
+}
+
+// linesTotal is what the order's lines come to after its discount
+func linesTotal(order Order) float64 {
+	total := CartTotal(order.Items)
+	if order.Discount == 0 {
+		return total
+	}
+	return roundPaise(total * (1 - order.Discount/100))
+}
+
+// orderTotal is what the order comes to: its lines after the discount, and its charges, rounded as the bill was
+// when it was placed
+func orderTotal(order Order) float64 {
+	return roundBill(exactTotal(order), order.RoundTo)
+}
+
+// exactTotal is what the order comes to before its bill is rounded, including any tax added on top
+func exactTotal(order Order) float64 {
+	if len(order.Charges) == 0 && !order.TaxOnTop {
+		return linesTotal(order)
+	}
+	return roundPaise(linesTotal(order) + chargesTotal(order.Charges) + taxOnTop(order))
+}
+
+// RecordOrder stores a new order in the kitchen queue with the day's next order number and a digital bill link,
+// giving takeaway orders a pickup token for the day they are picked up. An ID, creation time, number or token
+// already set on the order (e.g. when replaying an offline order) is kept.
+// It returns ErrDuplicate if an order with the same ID has already been recorded.
+func RecordOrder(ctx context.Context, order Order) (Order, error) {
+	prepareOrder(&order)
+
+	if order.Number == "" {
+		number, err := NextOrderNumber(ctx, order.CreatedAt)
+		if err != nil {
+			return Order{}, err
+		}
+		order.Number = number
+	}
+	if order.BillToken == "" {
+		token, err := newBillToken(order.ID)
+		if err != nil {
+			return Order{}, err
+		}
+		order.BillToken = token
+	}
+	if order.Type == OrderTakeaway && order.Token == 0 {
+		pickup := order.CreatedAt
+		if order.ScheduledFor != nil {
+			pickup = *order.ScheduledFor
+		}
+		token, err := NextToken(ctx, pickup)
+		if err != nil {
+			return Order{}, err
+		}
+		order.Token = token
+	}
+
+	return startOrder(ctx, order)
+}
+
+// prepareOrder fills in the fields every new order starts with
+func prepareOrder(order *Order) {
+	if order.ID.IsZero() {
+		order.ID = primitive.NewObjectID()
+	}
+	if order.Type == "" {
+		order.Type = OrderDineIn
+	}
+	if order.Type == OrderTakeaway || order.Type == OrderDelivery {
+		order.Table = 0
+	}
+	if order.CreatedAt.IsZero() {
+		order.CreatedAt = clock.Now()
+	}
+	order.Branch = currentBranch
+	order.Discount, order.DiscountReason = 0, nil // Only DiscountOrder gives one, so it is on record
+	order.Reprints = 0
+	order.RoundTo = billRounding
+	order.TaxOnTop = pricesExcludeTax
+	order.Total = orderTotal(*order)
+	order.Calories = CartCalories(order.Items)
+	order.Status = StatusQueued
+	syncCourses(order)
+}
+
+// SubmitOrder sends the order to the kitchen and adds its items to the customer's ordered items,
+// creating the customer if needed. When the database is unreachable the order is queued locally instead.
+// It returns an *UnavailableError if an item is 86'd or, unless IgnoreMenuHours is set, not served at this time,
+// and an *AllergyError if an item conflicts with the customer's allergies, unless AllergyOverride is set.
+func SubmitOrder(ctx context.Context, order Order) (Order, error) {
+	if len(order.Items) == 0 {
+		return Order{}, fmt.Errorf("the cart is empty")
+	}
+	if err := cleanOrderInstructions(&order); err != nil {
+		return Order{}, err
+	}
+	order.DeviceID, order.Device = deviceIdentity(ctx)
+	if source, ok := RequestSourceFrom(ctx); ok {
+		order.Source = &source
+	}
+	prepareOrder(&order)
+	menu := LoadMenu(ctx)
+	// Charges are worked out here once, so an offline order replayed later keeps those of when it was placed
+	if order.RemakeOf.IsZero() {
+		order.Charges = orderCharges(menu, order)
+		if err := chargeDelivery(ctx, &order); err != nil {
+			return Order{}, err
+		}
+		if staffMeal(order) {
+			if err := priceStaffMeal(ctx, menu, &order); err != nil {
+				return Order{}, err
+			}
+		}
+	}
+	order.Total = orderTotal(order)
+
+	// A pre-order is checked against the menu at its pickup time
+	if order.ScheduledFor != nil {
+		if err := checkPickup(menu, order, order.CreatedAt); err != nil {
+			return Order{}, err
+		}
+	} else if err := checkAvailability(menu, order.Items, order.CreatedAt, order.IgnoreMenuHours || ignoreMenuHours); err != nil {
+		return Order{}, err
+	}
+	if err := checkIDVerified(ctx, menu, &order); err != nil {
+		return Order{}, err
+	}
+	if err := checkWeights(menu, order.Items); err != nil {
+		return Order{}, err
+	}
+
+	if !order.AllergyOverride && !IsOffline() {
+		// Offline there is no customer profile to check against
+		check, err := CheckDietary(ctx, order.CustomerName, order.Items)
+		if err != nil && !storeFor(ctx).Unavailable(err) {
+			return Order{}, err
+		}
+		if len(check.Allergies) > 0 {
+			return Order{}, &AllergyError{Conflicts: check.Allergies}
+		}
+	}
+
+	if IsOffline() {
+		return QueueOrder(order)
+	}
+	menuVersion, err := storeFor(ctx).MenuVersions().Latest(ctx)
+	var submitted Order
+	if err == nil {
+		order.MenuVersion = menuVersion
+		submitted, err = submitOrderOnline(ctx, order)
+	}
+	if storeFor(ctx).Unavailable(err) {
+		GoOffline(err)
+		return QueueOrder(order)
+	}
+	return submitted, err
+}
+
+// submitOrderOnline writes the order first and then its containers and customer, so a replayed offline
+// order that already exists can be skipped as a whole
+func submitOrderOnline(ctx context.Context, order Order) (Order, error) {
+	order, err := RecordOrder(ctx, order)
+	if err != nil {
+		return Order{}, err
+	}
+	if err := lendContainers(ctx, order); err != nil {
+		return Order{}, err
+	}
+	if err := addCustomerItems(ctx, order.CustomerName, order.Items); err != nil {
+		return Order{}, err
+	}
+	if order.ScheduledFor == nil {
+		// Scheduled orders are printed when staff ask for their tickets
+		printTickets(ctx, order, "", everyLine)
+	}
+	return order, nil
+}
+
+// addCustomerItems adds the lines to the customer's ordered items, once per unit, and updates their total
+func addCustomerItems(ctx context.Context, customerName string, lines []OrderLine) error {
+	var items []string
+	for _, line := range lines {
+		for i := 0; i < line.Quantity; i++ {
+			items = append(items, line.Name)
+		}
+	}
+
+	if err := storeFor(ctx).Customers().AppendOrderedItems(ctx, customerName, items); err != nil {
+		return err
+	}
+	return storeCustomerTotal(ctx, customerName)
+}
+
+// AddOrderItem adds a menu item to an order that has not been served yet. The quantity, course, seat, note, flags
+// and weight are taken from want; the name and price come from the item, which for an open item (want.Open) is just
+// its description and price. Like SubmitOrder, it returns an *AllergyError if the customer is allergic to the item,
+// unless allowAllergens is set, and an *AgeCheckError for an age-restricted item unless the guest's ID was checked,
+// for this order or now (idVerified). A version other than 0 must be the order's current one.
+func AddOrderItem(ctx context.Context, id primitive.ObjectID, version int, item MenuItem, want OrderLine, allowAllergens, idVerified bool) (Order, error) {
+	if want.Quantity < 1 {
+		return Order{}, fmt.Errorf("quantity must be at least 1")
+	}
+	if want.Course < 0 {
+		return Order{}, fmt.Errorf("course must be at least 1")
+	}
+	if want.Seat < 0 {
+		return Order{}, fmt.Errorf("seat must not be negative")
+	}
+	if err := checkOrderUnlocked(ctx, id); err != nil {
+		return Order{}, err
+	}
+	line := AddToCart(nil, item, want.Quantity)[0]
+	line.Course, line.Seat, line.Note, line.Flags, line.Open = want.Course, want.Seat, want.Note, want.Flags, want.Open
+	if err := weighLine(&line, item, want.Weight); err != nil {
+		return Order{}, err
+	}
+	if err := cleanLine(&line); err != nil {
+		return Order{}, err
+	}
+	order, err := changeOrder(ctx, id, atOrderVersion(version, func(order Order) (OrderEvent, error) {
+		if order.Status == StatusServed {
+			return OrderEvent{}, fmt.Errorf("order is already %s", order.Status)
+		}
+		if staffMeal(order) && !line.Open {
+			costs, err := stockCosts(ctx)
+			if err != nil {
+				return OrderEvent{}, err
+			}
+			line.Price = itemCost(costs, item)
+		}
+		if !allowAllergens {
+			customer, err := storeFor(ctx).Customers().FindByName(ctx, order.CustomerName)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return OrderEvent{}, err
+			}
+			var check DietaryCheck
+			if checkMenuItem(customer, item, &check); len(check.Allergies) > 0 {
+				return OrderEvent{}, &AllergyError{Conflicts: check.Allergies}
+			}
+		}
+		event := OrderEvent{Type: EventItemAdded, Item: &line}
+		if item.AgeRestricted && !line.Open && order.IDVerified == nil {
+			if !idVerified {
+				return OrderEvent{}, &AgeCheckError{Items: []string{item.Name}}
+			}
+			event.IDVerified = &IDCheck{}
+			stampIDCheck(ctx, event.IDVerified, clock.Now())
+		}
+		return event, nil
+	}))
+	if err != nil {
+		return order, err
+	}
+	added := order
+	added.Items = []OrderLine{line}
+	printTickets(ctx, added, "*** ADDED ***", everyLine)
+	return order, addCustomerItems(ctx, order.CustomerName, []OrderLine{line})
+}
+
+// LoadKitchenQueue returns every order that has not been served yet, oldest first, leaving out scheduled orders until
+// they are due in the kitchen: catering orders on their day and pre-orders their prep time before pickup.
+// Orders still waiting in the offline queue are included so the kitchen can work on them. Callers with a staff role see
+// only their share of the queue.
+func LoadKitchenQueue(ctx context.Context) ([]Order, error) {
+	menu, now := LoadMenu(ctx), clock.Now()
+	due := func(orders []Order) []Order {
+		return slices.DeleteFunc(orders, func(order Order) bool { return kitchenReleaseAt(menu, order).After(now) })
+	}
+	pending := due(PendingOrders())
+	if IsOffline() {
+		return scopeOrders(ctx, pending), nil
+	}
+
+	orders, err := storeFor(ctx).Orders().ListOpen(ctx)
+	if storeFor(ctx).Unavailable(err) {
+		GoOffline(err)
+		return scopeOrders(ctx, pending), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return scopeOrders(ctx, append(due(orders), pending...)), nil
+}
+
+// AdvanceOrderStatus moves an order to the next kitchen status and returns the new status.
+// It fails if someone else has moved the order on since it was loaded, so a ticket is never skipped ahead,
+// and with a version other than 0 also if the order has changed in any other way since that version.
+func AdvanceOrderStatus(ctx context.Context, order Order, version int) (string, error) {
+	next, ok := orderStatusFlow[order.Status]
+	if !ok {
+		return order.Status, fmt.Errorf("order is already %s", order.Status)
+	}
+
+	_, err := changeOrder(ctx, order.ID, atOrderVersion(version, func(current Order) (OrderEvent, error) {
+		if current.Status != order.Status {
+			return OrderEvent{}, fmt.Errorf("order is already %s", current.Status)
+		}
+		if course, held := NextHeldCourse(current); held && next == StatusServed {
+			return OrderEvent{}, fmt.Errorf("course %d is still held; fire it before closing the order", course)
+		}
+		return OrderEvent{Type: EventStatusChanged, Status: next}, nil
+	}))
+	if err != nil {
+		return order.Status, err
+	}
+
+	if next == StatusReady && order.Type == OrderTakeaway {
+		announceReady(ctx, order)
+	}
+	return next, nil
+}
+
+// FindOrder loads a single order by its ID
+func FindOrder(ctx context.Context, id primitive.ObjectID) (Order, error) {
+	return storeFor(ctx).Orders().Find(ctx, id)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxUserAgent caps the user agent kept on an order
+const maxUserAgent = 200
+
+// trustProxy takes the client's address from X-Forwarded-For, from RMS_TRUST_PROXY, for an API behind a reverse
+// proxy. Otherwise the header could be set by anyone and is ignored.
+var trustProxy bool
+
+// RequestSource is where an API request came from, kept on the orders it places for fraud review and to debug
+// aggregator integrations
+type RequestSource struct {
+	IP        string `bson:"ip,omitempty" json:"ip,omitempty"`
+	UserAgent string `bson:"userAgent,omitempty" json:"userAgent,omitempty"`
+	Terminal  string `bson:"terminal,omitempty" json:"terminal,omitempty"` // The session's terminal, when it was signed in at one
+}
+
+type requestSourceContextKey struct{}
+
+// RequestSourceFrom returns where the request being handled came from, if it came through the API
+func RequestSourceFrom(ctx context.Context) (RequestSource, bool) {
+	source, ok := ctx.Value(requestSourceContextKey{}).(RequestSource)
+	return source, ok
+}
+
+// requestSource reads where the request came from. The terminal is filled in once its session is checked.
+func requestSource(r *http.Request) RequestSource {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); trustProxy && forwarded != "" {
+		client, _, _ := strings.Cut(forwarded, ",")
+		ip = strings.TrimSpace(client)
+	}
+	userAgent := strings.TrimSpace(r.UserAgent())
+	if utf8.RuneCountInString(userAgent) > maxUserAgent {
+		userAgent = string([]rune(userAgent)[:maxUserAgent])
+	}
+	return RequestSource{IP: ip, UserAgent: userAgent}
+}
+
+// OrderAuditFilter narrows the order audit. Device matches the device's name or id, UserAgent any part of the
+// user agent, ignoring case, and the rest exactly; empty fields match every order.
+type OrderAuditFilter struct {
+	Device    string
+	Terminal  string
+	IP        string
+	UserAgent string
+}
+
+// matches reports whether the order was placed from where the filter asks for
+func (filter OrderAuditFilter) matches(order Order) bool {
+	var source RequestSource
+	if order.Source != nil {
+		source = *order.Source
+	}
+	switch {
+	case filter.Device != "" && !strings.EqualFold(filter.Device, order.Device) && filter.Device != order.DeviceID.Hex():
+		return false
+	case filter.Terminal != "" && !strings.EqualFold(filter.Terminal, source.Terminal):
+		return false
+	case filter.IP != "" && filter.IP != source.IP:
+		return false
+	case filter.UserAgent != "" && !strings.Contains(strings.ToLower(source.UserAgent), strings.ToLower(filter.UserAgent)):
+		return false
+	}
+	return true
+}
+
+// OrderAuditEntry is an order with where it was placed from
+type OrderAuditEntry struct {
+	OrderID   primitive.ObjectID `json:"orderId"`
+	At        time.Time          `json:"at"`
+	Customer  string             `json:"customer"`
+	Type      string             `json:"type"`
+	Total     float64            `json:"total"`
+	DeviceID  primitive.ObjectID `json:"deviceId,omitzero"`
+	Device    string             `json:"device,omitempty"`
+	Terminal  string             `json:"terminal,omitempty"`
+	IP        string             `json:"ip,omitempty"`
+	UserAgent string             `json:"userAgent,omitempty"`
+}
+
+// OrderAudit lists the orders created in [from, to) that match the filter, oldest first, with the device, terminal,
+// address and user agent they were placed from. Orders taken at the command line have none.
+func OrderAudit(ctx context.Context, from, to time.Time, filter OrderAuditFilter) ([]OrderAuditEntry, error) {
+	orders, err := LoadOrdersBetween(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	entries := []OrderAuditEntry{}
+	for _, order := range orders {
+		if !filter.matches(order) {
+			continue
+		}
+		entry := OrderAuditEntry{
+			OrderID: order.ID, At: order.CreatedAt, Customer: order.CustomerName, Type: order.Type, Total: order.Total,
+			DeviceID: order.DeviceID, Device: order.Device,
+		}
+		if order.Source != nil {
+			entry.Terminal, entry.IP, entry.UserAgent = order.Source.Terminal, order.Source.IP, order.Source.UserAgent
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ShowOrderAudit prints the orders created in [from, to) that match the filter with where they were placed from
+func ShowOrderAudit(ctx context.Context, from, to time.Time, filter OrderAuditFilter) error {
+	entries, err := OrderAudit(ctx, from, to, filter)
+	if err != nil {
+		return err
+	}
+	auditListing := listing{
+		title:   fmt.Sprintf("Orders by source from %s to %s:", from.Format("02 Jan 2006"), to.AddDate(0, 0, -1).Format("02 Jan 2006")),
+		header:  []string{"Time", "Order", "Customer", "Device", "Terminal", "IP", "User agent"},
+		records: entries,
+	}
+	for _, entry := range entries {
+		auditListing.rows = append(auditListing.rows, []string{
+			entry.At.Format("02 Jan 15:04"), entry.OrderID.Hex(), entry.Customer, entry.Device, entry.Terminal, entry.IP, entry.UserAgent,
+		})
+		auditListing.compact = append(auditListing.compact, fmt.Sprintf("%s %s %s", entry.At.Format("02 Jan 15:04"), entry.Customer, entry.IP))
+	}
+	return printListing(os.Stdout, auditListing)
+}
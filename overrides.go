@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Actions staff can only take with a manager's override
+const (
+	OverrideVoid         = "void"         // Taking an item off an order after it was sent to the kitchen
+	OverrideDiscount     = "discount"     // A discount above what the caller's role may give
+	OverrideReopen       = "reopen"       // Reopening an order that was closed
+	OverrideCompensation = "compensation" // Putting a complaint right with more than the compensation limit
+	OverrideComp         = "comp"         // Giving items away, or any manager comp
+)
+
+// Wrong PINs or codes that may be entered in a row before they are locked, and for how long
+const (
+	maxPINFailures = 5
+	pinLockout     = 15 * time.Minute
+)
+
+var (
+	// ErrWrongPIN is returned when a manager's PIN does not match, or they are not a manager
+	ErrWrongPIN = errors.New("wrong manager or PIN")
+	// ErrTooManyAttempts is returned while a manager or key is locked out after too many wrong PINs or codes
+	ErrTooManyAttempts = errors.New("too many wrong attempts")
+)
+
+// OverrideError stops a sensitive action until a manager overrides it, with their PIN or a key that can approve
+type OverrideError struct {
+	Action string
+}
+
+func (e *OverrideError) Error() string {
+	return fmt.Sprintf("a %s needs a manager's override", e.Action)
+}
+
+// Override is a manager letting staff go ahead with a sensitive action. The PIN is checked against
+// RMS_MANAGER_PINS; a key with the manager:approve scope may name the manager without it.
+type Override struct {
+	Manager string `json:"manager"`
+	PIN     string `json:"pin"`
+}
+
+// managerPIN is a manager who can override, with the hash of their PIN
+type managerPIN struct {
+	Name string
+	Hash [32]byte
+}
+
+// managerPINs are the managers from RMS_MANAGER_PINS, by lowercased name
+var managerPINs = map[string]managerPIN{}
+
+// failureLock counts the wrong PINs or codes entered in a row for each manager or key, locking them out for a
+// while after too many
+type failureLock struct {
+	mu     sync.Mutex
+	count  map[string]int
+	locked map[string]time.Time // Until when
+}
+
+func newFailureLock() *failureLock {
+	return &failureLock{count: map[string]int{}, locked: map[string]time.Time{}}
+}
+
+// check returns an error while who is locked out
+func (l *failureLock) check(who, name string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := l.locked[who]; time.Now().Before(until) {
+		return fmt.Errorf("%w for %s, try again after %s", ErrTooManyAttempts, name, until.Format("15:04"))
+	}
+	return nil
+}
+
+// fail counts a wrong attempt, locking who out after too many in a row
+func (l *failureLock) fail(who string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.count[who]++; l.count[who] >= maxPINFailures {
+		l.locked[who], l.count[who] = time.Now().Add(pinLockout), 0
+	}
+}
+
+// succeed starts the count again after a right attempt
+func (l *failureLock) succeed(who string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.count, who)
+}
+
+// pinFailures locks out managers by lowercased name
+var pinFailures = newFailureLock()
+
+// overrideDiscount is the discount percentage above which a manager's override is needed, for callers whose role
+// has no limit of its own
+var overrideDiscount = 10.0
+
+// discountLimits are the largest discount percentage each staff role may give, from RMS_DISCOUNT_LIMITS. The
+// manager's limit also caps what a manager's override can approve.
+var discountLimits = map[string]float64{}
+
+// SetManagerPINs sets the managers who can override and their PINs from a spec like "Priya=4821,Arjun=730912",
+// and the discount staff can give without an override
+func SetManagerPINs(spec, discount string) error {
+	parsed := map[string]managerPIN{}
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		manager, pin, ok := strings.Cut(entry, "=")
+		manager, pin = strings.TrimSpace(manager), strings.TrimSpace(pin)
+		if _, err := strconv.Atoi(pin); !ok || manager == "" || err != nil || len(pin) < 4 || len(pin) > 8 {
+			return fmt.Errorf("invalid manager PIN for %q (want name=4 to 8 digits)", manager)
+		}
+		parsed[strings.ToLower(manager)] = managerPIN{Name: manager, Hash: sha256.Sum256([]byte(pin))}
+	}
+	managerPINs = parsed
+	overrideDiscount = 10
+	if discount != "" {
+		percent, err := strconv.ParseFloat(discount, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return fmt.Errorf("invalid override discount %q (want a percentage)", discount)
+		}
+		overrideDiscount = percent
+	}
+	return nil
+}
+
+// SetDiscountLimits sets the largest discount each role may give from a spec like "waiter=5,cashier=5,manager=25"
+func SetDiscountLimits(spec string) error {
+	parsed := map[string]float64{}
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		role, amount, ok := strings.Cut(entry, "=")
+		role = strings.ToLower(strings.TrimSpace(role))
+		percent, err := strconv.ParseFloat(strings.TrimSpace(amount), 64)
+		if !ok || err != nil || percent < 0 || percent > 100 {
+			return fmt.Errorf("invalid discount limit %q (want role=percent)", entry)
+		}
+		if !slices.Contains(staffRoles, role) {
+			return fmt.Errorf("unknown role %q in discount limits (want one of %s)", role, strings.Join(staffRoles, ", "))
+		}
+		parsed[role] = percent
+	}
+	discountLimits = parsed
+	return nil
+}
+
+// discountLimit returns the largest discount the caller may give without an override: their role's limit, or
+// RMS_OVERRIDE_DISCOUNT when it has none
+func discountLimit(ctx context.Context) float64 {
+	if record, ok := APIKeyFrom(ctx); ok && record.Role != "" {
+		if limit, ok := discountLimits[record.Role]; ok {
+			return limit
+		}
+	}
+	return overrideDiscount
+}
+
+// managerDiscountLimit returns the largest discount a manager's override can approve
+func managerDiscountLimit() float64 {
+	if limit, ok := discountLimits[RoleManager]; ok {
+		return limit
+	}
+	return 100
+}
+
+// approveOverride returns the manager overriding the action. Without a PIN the caller's key must be able to
+// approve and name the manager; without either it returns an *OverrideError.
+func approveOverride(ctx context.Context, action string, override Override) (string, error) {
+	manager := strings.TrimSpace(override.Manager)
+	if override.PIN == "" {
+		if record, ok := APIKeyFrom(ctx); ok && record.Allows(ScopeManagerApprove) && manager != "" {
+			return manager, nil
+		}
+		return "", &OverrideError{Action: action}
+	}
+	key := strings.ToLower(manager)
+	if err := pinFailures.check(key, manager); err != nil {
+		return "", err
+	}
+	want, found := managerPINs[key]
+	got := sha256.Sum256([]byte(strings.TrimSpace(override.PIN)))
+	if !found || subtle.ConstantTimeCompare(want.Hash[:], got[:]) != 1 {
+		if found {
+			pinFailures.fail(key)
+		}
+		return "", ErrWrongPIN
+	}
+	pinFailures.succeed(key)
+	return want.Name, nil
+}
+
+// VoidOrderItem takes quantity of a line off an order. Once the line has gone to the kitchen it needs a manager's
+// override, and who approved it is recorded with the reason in the order's history; its station is sent a void
+// ticket and the stock it used is written off as wasted.
+func VoidOrderItem(ctx context.Context, id primitive.ObjectID, version int, void ItemVoid, reason string, override Override) (Order, error) {
+	reason = strings.TrimSpace(reason)
+	switch {
+	case void.Quantity < 1:
+		return Order{}, fmt.Errorf("quantity must be at least 1")
+	case reason == "":
+		return Order{}, fmt.Errorf("a reason for the void is required")
+	}
+	if err := checkOrderUnlocked(ctx, id); err != nil {
+		return Order{}, err
+	}
+	var approvedBy string
+	var voided OrderLine
+	order, err := changeOrder(ctx, id, atOrderVersion(version, func(order Order) (OrderEvent, error) {
+		if order.Paid {
+			return OrderEvent{}, fmt.Errorf("order is already paid")
+		}
+		i := findLine(order.Items, void.Name, void.Course, void.Seat)
+		if i < 0 {
+			return OrderEvent{}, fmt.Errorf("no %s at seat %d", void.Name, void.Seat)
+		}
+		if order.Items[i].Quantity < void.Quantity {
+			return OrderEvent{}, fmt.Errorf("only %d x %s at seat %d", order.Items[i].Quantity, void.Name, void.Seat)
+		}
+		voided = order.Items[i]
+		voided.Quantity = void.Quantity
+		void.Sent, void.Value = !LineHeld(order, voided), roundPaise(voided.Amount())
+		if void.Sent && approvedBy == "" {
+			var err error
+			if approvedBy, err = approveOverride(ctx, OverrideVoid, override); err != nil {
+				return OrderEvent{}, err
+			}
+		}
+		return OrderEvent{Type: EventItemVoided, Void: &void, Reason: reason, By: changedBy(ctx), ApprovedBy: approvedBy}, nil
+	}))
+	if err != nil || !void.Sent {
+		return order, err
+	}
+	ticket := order
+	ticket.Items = []OrderLine{voided}
+	printTickets(ctx, ticket, "*** VOID ***", everyLine)
+	if err := writeOffVoid(ctx, order, voided); err != nil {
+		log.Printf("Error writing off the stock of a %s voided on order %s: %v", voided.Name, order.ID.Hex(), err)
+	}
+	return order, nil
+}
+
+// DiscountOrder takes a percentage off the order's bill, replacing any discount before it, for one of the
+// discountReasons codes; 0 takes the discount away. A discount above the limit of the caller's role needs a
+// manager's override, as does a manager comp, and none can go above the manager's limit.
+func DiscountOrder(ctx context.Context, id primitive.ObjectID, version int, percent float64, code, reason string, override Override) (Order, error) {
+	reason = strings.TrimSpace(reason)
+	if percent < 0 || percent > 100 {
+		return Order{}, fmt.Errorf("the discount must be between 0 and 100 percent")
+	}
+	if percent == 0 {
+		code = ""
+	} else if err := checkDiscountReason(code); err != nil {
+		return Order{}, err
+	}
+	if err := checkOrderUnlocked(ctx, id); err != nil {
+		return Order{}, err
+	}
+	if limit := managerDiscountLimit(); percent > limit {
+		return Order{}, fmt.Errorf("discounts are limited to %g%%, even with a manager's override", limit)
+	}
+	approvedBy, err := approveDiscount(ctx, percent, code, OverrideDiscount, override)
+	if err != nil {
+		return Order{}, err
+	}
+	return changeOrder(ctx, id, atOrderVersion(version, func(order Order) (OrderEvent, error) {
+		if order.AmountPaid > 0 {
+			return OrderEvent{}, fmt.Errorf("order has payments against it; discount it before it is paid")
+		}
+		return OrderEvent{
+			Type: EventDiscountGiven, Discount: percent, Reason: reason, ReasonCode: code, By: changedBy(ctx), ApprovedBy: approvedBy,
+		}, nil
+	}))
+}
+
+// DiscountOrderItem takes a percentage off quantity of a line for one of the discountReasons codes, splitting it off
+// the rest of the line; 100 percent comps it. A comp or a manager comp always needs a manager's override; a smaller
+// discount follows the same limits as DiscountOrder.
+func DiscountOrderItem(ctx context.Context, id primitive.ObjectID, version int, discount ItemDiscount, code, reason string, override Override) (Order, error) {
+	reason = strings.TrimSpace(reason)
+	switch {
+	case discount.Quantity < 1:
+		return Order{}, fmt.Errorf("quantity must be at least 1")
+	case discount.Percent <= 0 || discount.Percent > 100:
+		return Order{}, fmt.Errorf("the discount must be more than 0 and at most 100 percent")
+	}
+	if err := checkDiscountReason(code); err != nil {
+		return Order{}, err
+	}
+	if err := checkOrderUnlocked(ctx, id); err != nil {
+		return Order{}, err
+	}
+	action := OverrideDiscount
+	if discount.Percent == 100 {
+		action = OverrideComp
+	} else if limit := managerDiscountLimit(); discount.Percent > limit {
+		return Order{}, fmt.Errorf("discounts are limited to %g%%, even with a manager's override", limit)
+	}
+	approvedBy, err := approveDiscount(ctx, discount.Percent, code, action, override)
+	if err != nil {
+		return Order{}, err
+	}
+	return changeOrder(ctx, id, atOrderVersion(version, func(order Order) (OrderEvent, error) {
+		if order.AmountPaid > 0 {
+			return OrderEvent{}, fmt.Errorf("order has payments against it; discount it before it is paid")
+		}
+		i := findUndiscountedLine(order.Items, discount.Name, discount.Course, discount.Seat)
+		if i < 0 {
+			return OrderEvent{}, fmt.Errorf("no %s without a discount at seat %d", discount.Name, discount.Seat)
+		}
+		if order.Items[i].Quantity < discount.Quantity {
+			return OrderEvent{}, fmt.Errorf("only %d x %s at seat %d", order.Items[i].Quantity, discount.Name, discount.Seat)
+		}
+		return OrderEvent{
+			Type: EventItemDiscounted, ItemDiscount: &discount, Reason: reason, ReasonCode: code, By: changedBy(ctx), ApprovedBy: approvedBy,
+		}, nil
+	}))
+}
+
+// approveDiscount returns the manager overriding a discount, or "" when the caller may give it themselves: a
+// discount within their role's limit that is neither a comp nor a manager comp
+func approveDiscount(ctx context.Context, percent float64, code, action string, override Override) (string, error) {
+	limit := discountLimit(ctx)
+	if action != OverrideComp && code != DiscountManagerComp && percent <= limit {
+		return "", nil
+	}
+	if code == DiscountManagerComp {
+		action = OverrideComp
+	}
+	approvedBy, err := approveOverride(ctx, action, override)
+	if err != nil && action == OverrideDiscount {
+		return "", fmt.Errorf("%g%% is above the %g%% you may give: %w", percent, limit, err)
+	}
+	return approvedBy, err
+}
+
+// ReopenOrder reopens a served order, e.g. to add a forgotten item to the bill. It always needs a manager's override.
+func ReopenOrder(ctx context.Context, id primitive.ObjectID, reason string, override Override) (Order, error) {
+	if reason = strings.TrimSpace(reason); reason == "" {
+		return Order{}, fmt.Errorf("a reason for reopening the order is required")
+	}
+	if err := checkOrderUnlocked(ctx, id); err != nil {
+		return Order{}, err
+	}
+	if _, err := FindOrder(ctx, id); err != nil {
+		return Order{}, err
+	}
+	approvedBy, err := approveOverride(ctx, OverrideReopen, override)
+	if err != nil {
+		return Order{}, err
+	}
+	return changeOrder(ctx, id, func(order Order) (OrderEvent, error) {
+		if order.Status != StatusServed {
+			return OrderEvent{}, fmt.Errorf("order is still %s", order.Status)
+		}
+		return OrderEvent{Type: EventOrderReopened, Reason: reason, By: changedBy(ctx), ApprovedBy: approvedBy}, nil
+	})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"strings"
+	"time"
+)
+
+// Payment methods accepted at the till
+const (
+	PaymentCash = "cash"
+	PaymentCard = "card"
+	PaymentUPI  = "upi"
+)
+
+// paymentMethods lists the valid payment methods for validation and prompts
+var paymentMethods = []string{PaymentCash, PaymentCard, PaymentUPI}
+
+// Payment records money taken against an order
+type Payment struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrderID       primitive.ObjectID `bson:"orderId" json:"orderId"`
+	ReservationID primitive.ObjectID `bson:"reservationId,omitempty" json:"reservationId,omitzero"` // Set for a deposit, which has no order until the party is seated
+	BanquetID     primitive.ObjectID `bson:"banquetId,omitempty" json:"banquetId,omitzero"`         // Set for an advance towards a banquet booking
+	AccountID     primitive.ObjectID `bson:"accountId,omitempty" json:"accountId,omitzero"`         // Set for a charge to or settlement of a credit account
+	CompanyID     primitive.ObjectID `bson:"companyId,omitempty" json:"companyId,omitzero"`         // Set for a meal billed to an employee's company
+	Employee      string             `bson:"employee,omitempty" json:"employee,omitempty"`          // Whose meal was billed to the company
+	Method        string             `bson:"method" json:"method"`
+	Amount        float64            `bson:"amount" json:"amount"`                                   // In rupees, also for a payment in a foreign currency
+	Currency      string             `bson:"currency,omitempty" json:"currency,omitempty"`           // Foreign currency it was paid in, empty for rupees
+	ForeignAmount float64            `bson:"foreignAmount,omitempty" json:"foreignAmount,omitempty"` // What was paid in that currency
+	Rate          float64            `bson:"rate,omitempty" json:"rate,omitempty"`                   // Rupees for one unit of it at the till
+	Seat          int                `bson:"seat,omitempty" json:"seat,omitempty"`                   // Seat whose share of the bill this pays; 0 for the whole table
+	DrawerID      primitive.ObjectID `bson:"drawerId,omitempty" json:"drawerId,omitzero"`            // Drawer session a cash payment went into
+	Cashier       string             `bson:"cashier,omitempty" json:"cashier,omitempty"`             // Who took the payment
+	DeviceID      primitive.ObjectID `bson:"deviceId,omitempty" json:"deviceId,omitzero"`            // Device it was taken on, if it came from one
+	Device        string             `bson:"device,omitempty" json:"device,omitempty"`               // That device's name
+	ShiftID       primitive.ObjectID `bson:"shiftId,omitempty" json:"shiftId,omitzero"`              // Shift of the cashier who took it
+	Driver        string             `bson:"driver,omitempty" json:"driver,omitempty"`               // Delivery driver who took cash at the door, held until they settle
+	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// ValidatePayment checks the method and amount of a payment before it is recorded or queued
+func ValidatePayment(payment Payment) error {
+	if payment.OrderID.IsZero() && payment.ReservationID.IsZero() && payment.BanquetID.IsZero() && payment.AccountID.IsZero() {
+		return fmt.Errorf("payment must reference an order, a reservation, a banquet or an account")
+	}
+	if payment.Currency != "" && (payment.ForeignAmount <= 0 || payment.Rate <= 0) {
+		return fmt.Errorf("a payment in %s needs the amount paid in it and its rate", payment.Currency)
+	}
+	if payment.Amount <= 0 {
+		return fmt.Errorf("payment amount must be positive")
+	}
+	if payment.Seat < 0 {
+		return fmt.Errorf("payment seat must not be negative")
+	}
+	if payment.Driver != "" && (payment.Method != PaymentCash || payment.OrderID.IsZero()) {
+		return fmt.Errorf("a driver can only collect cash on delivery for an order")
+	}
+	for _, method := range paymentMethods {
+		if payment.Method == method {
+			return nil
+		}
+	}
+	return fmt.Errorf("payment method must be one of %s", strings.Join(paymentMethods, ", "))
+}
+
+// RecordPayment stores a payment and adds it to the order's amount paid,
+// queueing it locally instead when the database is unreachable
+func RecordPayment(ctx context.Context, payment Payment) (Payment, error) {
+	payment.Driver = strings.TrimSpace(payment.Driver)
+	if err := convertPayment(&payment); err != nil {
+		return Payment{}, err
+	}
+	if err := ValidatePayment(payment); err != nil {
+		return Payment{}, err
+	}
+	if payment.ID.IsZero() {
+		payment.ID = primitive.NewObjectID()
+	}
+	if payment.CreatedAt.IsZero() {
+		payment.CreatedAt = clock.Now()
+	}
+	paymentDevice(ctx, &payment)
+
+	if IsOffline() {
+		return payment, QueuePayment(payment)
+	}
+	// Cash on delivery stays with the driver until they settle, so it is not in the drawer, and nor is foreign cash,
+	// which is counted apart from the rupee float until it is exchanged
+	if payment.Method == PaymentCash && payment.DrawerID.IsZero() && payment.Driver == "" && payment.Currency == "" {
+		drawerID, err := openDrawerID(ctx)
+		if err != nil && !storeFor(ctx).Unavailable(err) {
+			return Payment{}, err
+		}
+		payment.DrawerID = drawerID
+	}
+	if err := assignShift(ctx, &payment); err != nil && !storeFor(ctx).Unavailable(err) {
+		return Payment{}, err
+	}
+	err := insertPayment(ctx, payment)
+	if storeFor(ctx).Unavailable(err) {
+		GoOffline(err)
+		return payment, QueuePayment(payment)
+	}
+	return payment, err
+}
+
+// paymentDevice records the device the request taking the payment came from, unless it already names one, e.g.
+// when replaying a payment queued offline
+func paymentDevice(ctx context.Context, payment *Payment) {
+	if payment.DeviceID.IsZero() {
+		payment.DeviceID, payment.Device = deviceIdentity(ctx)
+	}
+}
+
+// insertPayment writes the payment and records it against the order it pays for, and with the driver who took it
+func insertPayment(ctx context.Context, payment Payment) error {
+	paymentDevice(ctx, &payment)
+	if _, err := FindOrder(ctx, payment.OrderID); err != nil {
+		return err
+	}
+	if err := storeFor(ctx).Payments().Insert(ctx, payment); err != nil {
+		return err
+	}
+	order, err := changeOrder(ctx, payment.OrderID, func(Order) (OrderEvent, error) {
+		return OrderEvent{Type: EventPaid, At: payment.CreatedAt, Payment: &payment}, nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := sendSurvey(ctx, order); err != nil {
+		log.Println("Error sending a survey after the bill was settled:", err)
+	}
+	return collectCOD(ctx, payment)
+}
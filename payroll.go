@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrClockedIn is returned when clocking in an employee who has not clocked out
+var ErrClockedIn = errors.New("already clocked in")
+
+// TimeEntry is one stretch of an employee's work, from clocking in to clocking out
+type TimeEntry struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Open     bool               `bson:"open,omitempty" json:"open"`
+	Employee string             `bson:"employee" json:"employee"`
+	ClockIn  time.Time          `bson:"clockIn" json:"clockIn"`
+	ClockOut *time.Time         `bson:"clockOut,omitempty" json:"clockOut,omitempty"`
+}
+
+// PayRules are the hourly rates and overtime rule the payroll report uses, from the config
+type PayRules struct {
+	Rates              map[string]float64 // Hourly rate by employee
+	DefaultRate        float64            // For employees without a rate of their own
+	OvertimeAfter      time.Duration      // Work in a day beyond this is overtime
+	OvertimeMultiplier float64            // Overtime is paid at the hourly rate times this
+}
+
+// payRules holds the pay rules in effect. By default overtime starts after nine hours a day and is
+// paid at twice the rate, as under most state Shops and Establishments Acts.
+var payRules = PayRules{Rates: map[string]float64{}, OvertimeAfter: 9 * time.Hour, OvertimeMultiplier: 2}
+
+// SetPayRules reads the pay rules from the config. Rates are given like "Asha=150,Ravi=120,*=100",
+// where * is the rate for everyone else.
+func SetPayRules(cfg Config) error {
+	rules := PayRules{Rates: map[string]float64{}, OvertimeAfter: 9 * time.Hour, OvertimeMultiplier: 2}
+	for _, entry := range strings.Split(cfg.PayRates, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		employee, amount, ok := strings.Cut(entry, "=")
+		rate, err := strconv.ParseFloat(strings.TrimSpace(amount), 64)
+		if !ok || err != nil || rate < 0 {
+			return fmt.Errorf("invalid pay rate %q (want employee=hourly rate)", entry)
+		}
+		if employee = strings.TrimSpace(employee); employee == "*" {
+			rules.DefaultRate = rate
+		} else {
+			rules.Rates[employee] = rate
+		}
+	}
+	if cfg.OvertimeAfter != "" {
+		hours, err := strconv.ParseFloat(cfg.OvertimeAfter, 64)
+		if err != nil || hours <= 0 {
+			return fmt.Errorf("invalid overtime threshold %q (want hours per day)", cfg.OvertimeAfter)
+		}
+		rules.OvertimeAfter = time.Duration(hours * float64(time.Hour))
+	}
+	if cfg.OvertimeMultiplier != "" {
+		multiplier, err := strconv.ParseFloat(cfg.OvertimeMultiplier, 64)
+		if err != nil || multiplier < 1 {
+			return fmt.Errorf("invalid overtime multiplier %q (want 1 or more)", cfg.OvertimeMultiplier)
+		}
+		rules.OvertimeMultiplier = multiplier
+	}
+	payRules = rules
+	return nil
+}
+
+// hourlyRate returns the employee's rate, falling back to the default
+func (rules PayRules) hourlyRate(employee string) float64 {
+	if rate, ok := rules.Rates[employee]; ok {
+		return rate
+	}
+	return rules.DefaultRate
+}
+
+// ClockIn starts a time entry for the employee
+func ClockIn(ctx context.Context, employee string) (TimeEntry, error) {
+	employee = strings.TrimSpace(employee)
+	if employee == "" {
+		return TimeEntry{}, fmt.Errorf("employee is required")
+	}
+	entry := TimeEntry{ID: primitive.NewObjectID(), Open: true, Employee: employee, ClockIn: clock.Now()}
+	err := storeFor(ctx).TimeClock().ClockIn(ctx, entry)
+	if errors.Is(err, ErrDuplicate) {
+		return TimeEntry{}, fmt.Errorf("%s is %w", employee, ErrClockedIn)
+	}
+	return entry, err
+}
+
+// ClockOut ends the employee's open time entry. It returns ErrNotFound if they are not clocked in.
+func ClockOut(ctx context.Context, employee string) (TimeEntry, error) {
+	entry, err := storeFor(ctx).TimeClock().FindOpen(ctx, strings.TrimSpace(employee))
+	if err != nil {
+		return TimeEntry{}, err
+	}
+	now := clock.Now()
+	entry.Open, entry.ClockOut = false, &now
+	return entry, storeFor(ctx).TimeClock().ClockOut(ctx, entry)
+}
+
+// ListTimeEntries returns the time entries clocked in during [from, to), oldest first
+func ListTimeEntries(ctx context.Context, from, to time.Time) ([]TimeEntry, error) {
+	entries, err := storeFor(ctx).TimeClock().ListBetween(ctx, from, to)
+	if entries == nil {
+		entries = []TimeEntry{}
+	}
+	return entries, err
+}
+
+// PayrollLine is one employee's pay for the period
+type PayrollLine struct {
+	Employee      string  `json:"employee"`
+	Shifts        int     `json:"shifts"`
+	RegularHours  float64 `json:"regularHours"`
+	OvertimeHours float64 `json:"overtimeHours"`
+	Rate          float64 `json:"rate"`
+	RegularPay    float64 `json:"regularPay"`
+	OvertimePay   float64 `json:"overtimePay"`
+	Tips          float64 `json:"tips"`
+	Total         float64 `json:"total"`
+}
+
+// PayrollReport works out each employee's pay for the time entries clocked in during [from, to), by name.
+// Work beyond the overtime threshold on any one day is overtime. The till does not record tips, so the tip
+// pool collected over the period is passed in and shared out in proportion to the hours worked. Employees
+// still clocked in are not paid for their open entry until they clock out.
+func PayrollReport(ctx context.Context, from, to time.Time, tipPool float64) ([]PayrollLine, error) {
+	entries, err := storeFor(ctx).TimeClock().ListBetween(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	worked := map[string]map[string]time.Duration{} // Employee, then day
+	shifts := map[string]int{}
+	for _, entry := range entries {
+		if entry.ClockOut == nil {
+			continue
+		}
+		if worked[entry.Employee] == nil {
+			worked[entry.Employee] = map[string]time.Duration{}
+		}
+		worked[entry.Employee][entry.ClockIn.Format(time.DateOnly)] += entry.ClockOut.Sub(entry.ClockIn)
+		shifts[entry.Employee]++
+	}
+
+	report := []PayrollLine{}
+	var totalHours float64
+	for employee, days := range worked {
+		line := PayrollLine{Employee: employee, Shifts: shifts[employee], Rate: payRules.hourlyRate(employee)}
+		for _, duration := range days {
+			overtime := max(duration-payRules.OvertimeAfter, 0)
+			line.RegularHours += (duration - overtime).Hours()
+			line.OvertimeHours += overtime.Hours()
+		}
+		totalHours += line.RegularHours + line.OvertimeHours
+		report = append(report, line)
+	}
+	for i := range report {
+		line := &report[i]
+		if totalHours > 0 {
+			line.Tips = roundPaise(tipPool * (line.RegularHours + line.OvertimeHours) / totalHours)
+		}
+		line.RegularPay = roundPaise(line.RegularHours * line.Rate)
+		line.OvertimePay = roundPaise(line.OvertimeHours * line.Rate * payRules.OvertimeMultiplier)
+		line.Total = roundPaise(line.RegularPay + line.OvertimePay + line.Tips)
+		line.RegularHours, line.OvertimeHours = roundPaise(line.RegularHours), roundPaise(line.OvertimeHours)
+	}
+	slices.SortFunc(report, func(a, b PayrollLine) int { return strings.Compare(a.Employee, b.Employee) })
+	return report, nil
+}
+
+// WritePayrollCSV writes the payroll report with a header row
+func WritePayrollCSV(w io.Writer, report []PayrollLine) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"Employee", "Shifts", "Regular hours", "Overtime hours", "Hourly rate", "Regular pay", "Overtime pay", "Tips", "Total"})
+	for _, line := range report {
+		out.Write([]string{
+			line.Employee, fmt.Sprint(line.Shifts), fmt.Sprintf("%.2f", line.RegularHours), fmt.Sprintf("%.2f", line.OvertimeHours),
+			fmt.Sprintf("%.2f", line.Rate), fmt.Sprintf("%.2f", line.RegularPay), fmt.Sprintf("%.2f", line.OvertimePay),
+			fmt.Sprintf("%.2f", line.Tips), fmt.Sprintf("%.2f", line.Total),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Menu categories whose attach rate measures upselling
+const (
+	CategoryDesserts  = "desserts"
+	CategoryBeverages = "beverages"
+)
+
+// WaiterPerformance is one waiter's served orders over a period
+type WaiterPerformance struct {
+	Waiter         string  `json:"waiter"`
+	Checks         int     `json:"checks"`
+	Covers         int     `json:"covers"`
+	Sales          float64 `json:"sales"`
+	AverageCheck   float64 `json:"averageCheck"`
+	ItemsPerCover  float64 `json:"itemsPerCover"`
+	DessertAttach  float64 `json:"dessertAttach"`  // Percentage of checks with a dessert
+	BeverageAttach float64 `json:"beverageAttach"` // Percentage of checks with a drink
+}
+
+// orderCovers is the number of guests an order served: as given when it was placed, else the seats
+// that ordered, else one
+func orderCovers(order Order) int {
+	if order.Covers > 0 {
+		return order.Covers
+	}
+	var seats []int
+	for _, line := range order.Items {
+		if line.Seat != SharedSeat && !slices.Contains(seats, line.Seat) {
+			seats = append(seats, line.Seat)
+		}
+	}
+	return max(len(seats), 1)
+}
+
+// hasCategory reports whether any line of the order is a menu item in the category
+func hasCategory(order Order, menu []MenuItem, category string) bool {
+	return slices.ContainsFunc(order.Items, func(line OrderLine) bool {
+		item, found := FindMenuItem(menu, line.Name)
+		return found && strings.EqualFold(item.Category, category)
+	})
+}
+
+// WaiterPerformanceReport measures each waiter on the orders they served in [from, to), by name.
+// Orders that were not served, or were taken without a waiter, are left out.
+func WaiterPerformanceReport(ctx context.Context, from, to time.Time) ([]WaiterPerformance, error) {
+	orders, err := LoadOrdersBetween(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	menu := LoadMenu(ctx)
+	byWaiter := map[string]*WaiterPerformance{}
+	items := map[string]int{}
+	for _, order := range orders {
+		if order.Waiter == "" || order.Status != StatusServed || staffMeal(order) {
+			continue
+		}
+		row, ok := byWaiter[order.Waiter]
+		if !ok {
+			row = &WaiterPerformance{Waiter: order.Waiter}
+			byWaiter[order.Waiter] = row
+		}
+		row.Checks++
+		row.Covers += orderCovers(order)
+		row.Sales += order.Total
+		for _, line := range order.Items {
+			items[order.Waiter] += line.Quantity
+		}
+		if hasCategory(order, menu, CategoryDesserts) {
+			row.DessertAttach++
+		}
+		if hasCategory(order, menu, CategoryBeverages) {
+			row.BeverageAttach++
+		}
+	}
+
+	report := []WaiterPerformance{}
+	for waiter, row := range byWaiter {
+		row.Sales = roundPaise(row.Sales)
+		row.AverageCheck = roundPaise(row.Sales / float64(row.Checks))
+		row.ItemsPerCover = roundPaise(float64(items[waiter]) / float64(row.Covers))
+		row.DessertAttach = roundPaise(row.DessertAttach * 100 / float64(row.Checks))
+		row.BeverageAttach = roundPaise(row.BeverageAttach * 100 / float64(row.Checks))
+		report = append(report, *row)
+	}
+	slices.SortFunc(report, func(a, b WaiterPerformance) int { return strings.Compare(a.Waiter, b.Waiter) })
+	return report, nil
+}
+
+// WriteWaiterPerformanceCSV writes the report with a header row
+func WriteWaiterPerformanceCSV(w io.Writer, report []WaiterPerformance) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"Waiter", "Checks", "Covers", "Sales", "Average check", "Items per cover", "Dessert attach %", "Beverage attach %"})
+	for _, row := range report {
+		out.Write([]string{
+			row.Waiter, fmt.Sprint(row.Checks), fmt.Sprint(row.Covers), fmt.Sprintf("%.2f", row.Sales),
+			fmt.Sprintf("%.2f", row.AverageCheck), fmt.Sprintf("%.2f", row.ItemsPerCover),
+			fmt.Sprintf("%.2f", row.DessertAttach), fmt.Sprintf("%.2f", row.BeverageAttach),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+const (
+	// defaultPrepTime is how long an item without a target prep time is taken to need
+	defaultPrepTime = 15 * time.Minute
+	// prepMargin is added to a pre-order's prep time, so it is ready a little before the customer arrives
+	prepMargin = 10 * time.Minute
+	// maxPreOrderAhead is how far ahead a pickup can be booked
+	maxPreOrderAhead = 7 * 24 * time.Hour
+)
+
+// ErrPickupTime is returned for a pre-order that cannot be ready at the pickup time asked for
+var ErrPickupTime = errors.New("cannot take the order for that pickup time")
+
+// PrepLeadTime is how long before it is due the kitchen needs an order: its slowest item, as items are cooked
+// side by side, and a margin
+func PrepLeadTime(menu []MenuItem, lines []OrderLine) time.Duration {
+	var slowest time.Duration
+	for _, line := range lines {
+		prep := defaultPrepTime
+		if item, found := FindMenuItem(menu, line.Name); found && item.PrepMinutes > 0 {
+			prep = time.Duration(item.PrepMinutes) * time.Minute
+		}
+		slowest = max(slowest, prep)
+	}
+	return slowest + prepMargin
+}
+
+// kitchenReleaseAt is when a scheduled order reaches the kitchen queue: a catering order on its day, and a
+// pre-order its prep lead time before pickup. Orders that are not scheduled go straight to the kitchen.
+func kitchenReleaseAt(menu []MenuItem, order Order) time.Time {
+	if order.ScheduledFor == nil {
+		return order.CreatedAt
+	}
+	due := *order.ScheduledFor
+	if order.Type == OrderCatering {
+		return time.Date(due.Year(), due.Month(), due.Day(), 0, 0, 0, 0, due.Location())
+	}
+	return due.Add(-PrepLeadTime(menu, order.Items))
+}
+
+// checkPickup checks a pre-order's pickup time at t: it must be ahead, within maxPreOrderAhead and leave the kitchen
+// time to cook, and every item must be served at that time
+func checkPickup(menu []MenuItem, order Order, t time.Time) error {
+	pickup := *order.ScheduledFor
+	if order.Type != OrderTakeaway {
+		return fmt.Errorf("%w: only takeaway orders can be scheduled for pickup", ErrPickupTime)
+	}
+	if pickup.After(t.Add(maxPreOrderAhead)) {
+		return fmt.Errorf("%w: pickup can be booked at most %d days ahead", ErrPickupTime, int(maxPreOrderAhead.Hours()/24))
+	}
+	if ready := t.Add(PrepLeadTime(menu, order.Items) - prepMargin); pickup.Before(ready) {
+		return fmt.Errorf("%w: the order takes until %s to prepare", ErrPickupTime, ready.Format("15:04"))
+	}
+	return checkAvailability(menu, order.Items, pickup, order.IgnoreMenuHours || ignoreMenuHours)
+}
+
+// LoadPreOrders returns the scheduled orders not yet in the kitchen queue, soonest due first, as far as the
+// caller's role may see them
+func LoadPreOrders(ctx context.Context) ([]Order, error) {
+	orders, err := storeFor(ctx).Orders().ListOpen(ctx)
+	if err != nil {
+		return nil, err
+	}
+	menu, now := LoadMenu(ctx), clock.Now()
+	held := []Order{}
+	for _, order := range orders {
+		if kitchenReleaseAt(menu, order).After(now) {
+			held = append(held, order)
+		}
+	}
+	slices.SortFunc(held, func(a, b Order) int { return a.ScheduledFor.Compare(*b.ScheduledFor) })
+	return scopeOrders(ctx, held), nil
+}
+
+// ShowPreOrders prints the scheduled orders waiting to reach the kitchen
+func ShowPreOrders(ctx context.Context) error {
+	orders, err := LoadPreOrders(ctx)
+	if err != nil {
+		return err
+	}
+	menu := LoadMenu(ctx)
+	preOrderListing := listing{
+		title:   "Scheduled orders:",
+		header:  []string{"ID", "Customer", "Type", "Token", "Items", "Total", "Due", "To kitchen"},
+		records: orders,
+	}
+	for _, order := range orders {
+		token := "-"
+		if order.Token > 0 {
+			token = fmt.Sprint(order.Token)
+		}
+		release := kitchenReleaseAt(menu, order)
+		preOrderListing.rows = append(preOrderListing.rows, []string{
+			order.ID.Hex(), order.CustomerName, order.Type, token, fmt.Sprint(len(order.Items)), fmt.Sprintf("%.2f", order.Total),
+			order.ScheduledFor.Format("02 Jan 15:04"), release.Format("02 Jan 15:04"),
+		})
+		preOrderListing.compact = append(preOrderListing.compact, fmt.Sprintf("%s %s due %s, to kitchen %s", order.ID.Hex(),
+			order.CustomerName, order.ScheduledFor.Format("02 Jan 15:04"), release.Format("15:04")))
+	}
+	return printListing(os.Stdout, preOrderListing)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Dayparts prep times are broken down by, from the hour the kitchen started on the dish
+const (
+	DaypartBreakfast = "breakfast" // 05:00 to 11:00
+	DaypartLunch     = "lunch"     // 11:00 to 15:00
+	DaypartAfternoon = "afternoon" // 15:00 to 18:00
+	DaypartDinner    = "dinner"    // 18:00 to 05:00
+)
+
+var dayparts = []string{DaypartBreakfast, DaypartLunch, DaypartAfternoon, DaypartDinner}
+
+// daypartOf returns the daypart the time falls in
+func daypartOf(t time.Time) string {
+	switch hour := t.Hour(); {
+	case hour >= 5 && hour < 11:
+		return DaypartBreakfast
+	case hour >= 11 && hour < 15:
+		return DaypartLunch
+	case hour >= 15 && hour < 18:
+		return DaypartAfternoon
+	default:
+		return DaypartDinner
+	}
+}
+
+// PrepTimes are the preparation times, in minutes, of dishes the kitchen made
+type PrepTimes struct {
+	Count   int     `json:"count"`
+	Average float64 `json:"averageMinutes"`
+	P50     float64 `json:"p50Minutes"`
+	P90     float64 `json:"p90Minutes"`
+}
+
+// DaypartPrepTimes are the preparation times during one daypart
+type DaypartPrepTimes struct {
+	Daypart string `json:"daypart"`
+	PrepTimes
+}
+
+// ItemPrepTimes are one menu item's preparation times, overall and by daypart
+type ItemPrepTimes struct {
+	Item          string `json:"item"`
+	TargetMinutes int    `json:"targetMinutes,omitempty"` // The item's target prep time; 0 when none is set
+	PrepTimes
+	OverTarget float64            `json:"overTarget"` // Percentage of preparations that took longer than the target
+	Slow       bool               `json:"slow"`       // Most preparations took longer than the target
+	Dayparts   []DaypartPrepTimes `json:"dayparts"`
+}
+
+// PrepTimeSummary is how long the kitchen took to prepare each item, and each daypart across all items
+type PrepTimeSummary struct {
+	From     time.Time          `json:"from"`
+	To       time.Time          `json:"to"`
+	Items    []ItemPrepTimes    `json:"items"`
+	Dayparts []DaypartPrepTimes `json:"dayparts"`
+}
+
+// prepSample is how long one line took, and when the kitchen started on it
+type prepSample struct {
+	item    string
+	started time.Time
+	minutes float64
+}
+
+// statusChange is a status an order moved to, and when
+type statusChange struct {
+	status string
+	at     time.Time
+}
+
+// PrepTimeReport works out from the order events how long each menu item took to prepare for orders placed in
+// [from, to), by name. A line is timed from when the kitchen started on it (the order went to preparing, or its
+// course was fired or the line added while the order was already being prepared) until the order was next ready.
+// Lines never started or never ready, e.g. a course still held, are left out.
+func PrepTimeReport(ctx context.Context, from, to time.Time) (PrepTimeSummary, error) {
+	events, err := storeFor(ctx).Events().ListSince(ctx, from)
+	if err != nil {
+		return PrepTimeSummary{}, err
+	}
+	byOrder := map[primitive.ObjectID][]OrderEvent{}
+	for _, event := range events {
+		byOrder[event.OrderID] = append(byOrder[event.OrderID], event)
+	}
+	var samples []prepSample
+	for _, stream := range byOrder {
+		slices.SortFunc(stream, func(a, b OrderEvent) int { return a.Seq - b.Seq })
+		if stream[0].Type != EventOrderCreated || stream[0].At.Before(from) || !stream[0].At.Before(to) {
+			continue
+		}
+		samples = append(samples, orderPrepSamples(stream)...)
+	}
+
+	menu := LoadMenu(ctx)
+	report := PrepTimeSummary{From: from, To: to, Items: []ItemPrepTimes{}, Dayparts: []DaypartPrepTimes{}}
+	byItem := map[string][]prepSample{}
+	for _, sample := range samples {
+		byItem[sample.item] = append(byItem[sample.item], sample)
+	}
+	for name, itemSamples := range byItem {
+		row := ItemPrepTimes{Item: name, PrepTimes: prepTimes(itemSamples), Dayparts: daypartPrepTimes(itemSamples)}
+		if item, found := FindMenuItem(menu, name); found && item.PrepMinutes > 0 {
+			row.TargetMinutes = item.PrepMinutes
+			over := 0
+			for _, sample := range itemSamples {
+				if sample.minutes > float64(item.PrepMinutes) {
+					over++
+				}
+			}
+			row.OverTarget = roundPaise(float64(over) * 100 / float64(len(itemSamples)))
+			row.Slow = over*2 > len(itemSamples)
+		}
+		report.Items = append(report.Items, row)
+	}
+	slices.SortFunc(report.Items, func(a, b ItemPrepTimes) int { return strings.Compare(a.Item, b.Item) })
+	report.Dayparts = daypartPrepTimes(samples)
+	return report, nil
+}
+
+// orderPrepSamples times each line of one order from its events, in sequence
+func orderPrepSamples(stream []OrderEvent) []prepSample {
+	type added struct {
+		line OrderLine
+		at   time.Time
+	}
+	var lines []added
+	var changes []statusChange
+	fired := map[int]time.Time{FirstCourse: stream[0].At}
+	for _, event := range stream {
+		switch event.Type {
+		case EventOrderCreated:
+			for _, line := range event.Order.Items {
+				lines = append(lines, added{line, event.At})
+			}
+			for _, course := range event.Order.Courses {
+				if course.FiredAt != nil {
+					fired[course.Course] = *course.FiredAt
+				}
+			}
+		case EventItemAdded:
+			lines = append(lines, added{*event.Item, event.At})
+		case EventCourseFired:
+			fired[event.Course] = event.At
+		case EventStatusChanged:
+			changes = append(changes, statusChange{event.Status, event.At})
+		}
+	}
+
+	var samples []prepSample
+	for _, l := range lines {
+		firedAt, ok := fired[lineCourse(l.line)]
+		if !ok {
+			continue
+		}
+		started, ok := kitchenStarted(changes, latest(firedAt, l.at))
+		if !ok {
+			continue
+		}
+		for _, change := range changes {
+			if change.status == StatusReady && !change.at.Before(started) {
+				samples = append(samples, prepSample{item: l.line.Name, started: started, minutes: change.at.Sub(started).Minutes()})
+				break
+			}
+		}
+	}
+	return samples
+}
+
+// kitchenStarted returns when the kitchen started on something sent to it at the given time: then, if the order was
+// being prepared, else the next time the order went to preparing
+func kitchenStarted(changes []statusChange, sent time.Time) (time.Time, bool) {
+	status := StatusQueued
+	for _, change := range changes {
+		if !change.at.After(sent) {
+			status = change.status
+			continue
+		}
+		if status == StatusPreparing {
+			return sent, true
+		}
+		if change.status == StatusPreparing {
+			return change.at, true
+		}
+	}
+	return sent, status == StatusPreparing
+}
+
+// latest returns the later of two times
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// earliest returns the earlier of two times
+func earliest(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// prepTimes works out the average and percentiles of the samples
+func prepTimes(samples []prepSample) PrepTimes {
+	minutes := make([]float64, len(samples))
+	var total float64
+	for i, sample := range samples {
+		minutes[i] = sample.minutes
+		total += sample.minutes
+	}
+	slices.Sort(minutes)
+	times := PrepTimes{Count: len(minutes)}
+	if len(minutes) > 0 {
+		times.Average = roundPaise(total / float64(len(minutes)))
+		times.P50 = roundPaise(minutes[(len(minutes)-1)*50/100])
+		times.P90 = roundPaise(minutes[(len(minutes)-1)*90/100])
+	}
+	return times
+}
+
+// daypartPrepTimes breaks the samples down by daypart, leaving out dayparts without any
+func daypartPrepTimes(samples []prepSample) []DaypartPrepTimes {
+	breakdown := []DaypartPrepTimes{}
+	for _, daypart := range dayparts {
+		var in []prepSample
+		for _, sample := range samples {
+			if daypartOf(sample.started) == daypart {
+				in = append(in, sample)
+			}
+		}
+		if len(in) > 0 {
+			breakdown = append(breakdown, DaypartPrepTimes{Daypart: daypart, PrepTimes: prepTimes(in)})
+		}
+	}
+	return breakdown
+}
+
+// WritePrepTimesCSV writes a row per item and daypart, with the item's overall times in the rows for "all"
+func WritePrepTimesCSV(w io.Writer, report PrepTimeSummary) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"Item", "Daypart", "Count", "Average min", "p50 min", "p90 min", "Target min", "Over target %", "Slow"})
+	row := func(item, daypart string, times PrepTimes, target, over, slow string) {
+		out.Write([]string{
+			item, daypart, fmt.Sprint(times.Count), fmt.Sprintf("%.2f", times.Average), fmt.Sprintf("%.2f", times.P50),
+			fmt.Sprintf("%.2f", times.P90), target, over, slow,
+		})
+	}
+	for _, item := range report.Items {
+		target, over, slow := "", "", ""
+		if item.TargetMinutes > 0 {
+			target, over, slow = fmt.Sprint(item.TargetMinutes), fmt.Sprintf("%.2f", item.OverTarget), fmt.Sprint(item.Slow)
+		}
+		row(item.Item, "all", item.PrepTimes, target, over, slow)
+		for _, daypart := range item.Dayparts {
+			row(item.Item, daypart.Daypart, daypart.PrepTimes, "", "", "")
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// ShowPrepTimes lists each item's prep times for orders placed in [from, to), marking items that are usually
+// slower than their target
+func ShowPrepTimes(ctx context.Context, from, to time.Time) error {
+	report, err := PrepTimeReport(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	title := fmt.Sprintf("Prep times from %s to %s:", from.Format("02 Jan 2006"), to.AddDate(0, 0, -1).Format("02 Jan 2006"))
+	prepListing := listing{
+		title:   title,
+		header:  []string{"Item", "Count", "Average", "p50", "p90", "Target", "Over target", ""},
+		records: report.Items,
+	}
+	for _, item := range report.Items {
+		target, over, slow := "", "", ""
+		if item.TargetMinutes > 0 {
+			target, over = fmt.Sprintf("%d min", item.TargetMinutes), fmt.Sprintf("%.0f%%", item.OverTarget)
+		}
+		if item.Slow {
+			slow = "SLOW"
+		}
+		prepListing.rows = append(prepListing.rows, []string{
+			item.Item, fmt.Sprint(item.Count), fmt.Sprintf("%.1f min", item.Average), fmt.Sprintf("%.1f min", item.P50),
+			fmt.Sprintf("%.1f min", item.P90), target, over, slow,
+		})
+		prepListing.compact = append(prepListing.compact, strings.TrimSpace(fmt.Sprintf("%s %.1f min %s", item.Item, item.Average, slow)))
+	}
+	for _, daypart := range report.Dayparts {
+		prepListing.rows = append(prepListing.rows, []string{
+			"All items, " + daypart.Daypart, fmt.Sprint(daypart.Count), fmt.Sprintf("%.1f min", daypart.Average),
+			fmt.Sprintf("%.1f min", daypart.P50), fmt.Sprintf("%.1f min", daypart.P90), "", "", "",
+		})
+	}
+	return printListing(os.Stdout, prepListing)
+}
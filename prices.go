@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PriceChange records a menu item's price being set, so the price in effect at any time can be
+// looked up later, e.g. to settle a billing dispute over an old order. An item's first price has OldPrice 0.
+type PriceChange struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Item      string             `bson:"item" json:"item"`
+	OldPrice  float64            `bson:"oldPrice" json:"oldPrice"`
+	NewPrice  float64            `bson:"newPrice" json:"newPrice"`
+	ChangedBy string             `bson:"changedBy" json:"changedBy"`
+	At        time.Time          `bson:"at" json:"at"`
+}
+
+// PriceHistory is how one item's price evolved, oldest change first
+type PriceHistory struct {
+	Item         string        `json:"item"`
+	CurrentPrice float64       `json:"currentPrice"`
+	Changes      []PriceChange `json:"changes"`
+}
+
+// changedBy names who is making a change: the API key used, or "local" for the terminal and keyless API calls
+func changedBy(ctx context.Context) string {
+	by := "local"
+	if record, ok := APIKeyFrom(ctx); ok {
+		by = fmt.Sprintf("api key %s (%s)", record.Name, record.Prefix)
+	}
+	if device, ok := DeviceFrom(ctx); ok {
+		by += " on device " + device.Name
+	}
+	return by
+}
+
+// recordPriceChange logs a price being set on an item
+func recordPriceChange(ctx context.Context, item string, oldPrice, newPrice float64) error {
+	return storeFor(ctx).Menu().LogPriceChange(ctx, PriceChange{
+		ID: primitive.NewObjectID(), Item: item, OldPrice: oldPrice, NewPrice: newPrice, ChangedBy: changedBy(ctx), At: clock.Now(),
+	})
+}
+
+// LoadPriceHistory returns the price changes of every item on the menu, or only of the named item
+func LoadPriceHistory(ctx context.Context, name string) ([]PriceHistory, error) {
+	menu := loadHeadOfficeMenu(ctx)
+	if name != "" {
+		item, found := FindMenuItem(menu, name)
+		if !found {
+			return nil, ErrNotFound
+		}
+		menu = []MenuItem{item}
+	}
+
+	history := make([]PriceHistory, 0, len(menu))
+	for _, item := range menu {
+		changes, err := storeFor(ctx).Menu().ListPriceChanges(ctx, item.Name)
+		if err != nil {
+			return nil, err
+		}
+		if changes == nil {
+			changes = []PriceChange{}
+		}
+		history = append(history, PriceHistory{Item: item.Name, CurrentPrice: item.Price, Changes: changes})
+	}
+	return history, nil
+}
+
+// PriceAt returns the price the named item had at time t. Before its first recorded change that is the
+// price the change replaced; with no changes recorded at all it is the current price.
+func PriceAt(ctx context.Context, name string, t time.Time) (float64, error) {
+	history, err := LoadPriceHistory(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	price := history[0].CurrentPrice
+	for i, change := range history[0].Changes {
+		if change.At.After(t) {
+			if i == 0 && change.OldPrice > 0 {
+				price = change.OldPrice
+			}
+			break
+		}
+		price = change.NewPrice
+	}
+	return price, nil
+}
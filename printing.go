@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Statuses of a print job
+const (
+	PrintQueued  = "queued"
+	PrintPrinted = "printed"
+	PrintFailed  = "failed" // Every printer of the station was tried; the job waits for someone to retry it
+)
+
+const (
+	// spoolInterval is how often the background job sends print jobs to the printers
+	spoolInterval = 5 * time.Second
+	// maxPrinterAttempts is how many times a job is tried on a printer before it fails over to the next one
+	maxPrinterAttempts = 3
+	// printRetryDelay is how long a job waits after a failed attempt, times the attempts made on the printer
+	printRetryDelay = 10 * time.Second
+	// printLease is how long a terminal has a job to itself while it prints it, so two terminals do not both print it
+	printLease = time.Minute
+	// printTimeout bounds connecting to a printer and sending it a job
+	printTimeout = 5 * time.Second
+	// printJobsShown is how far back the print job view looks
+	printJobsShown = 24 * time.Hour
+)
+
+// printers are the addresses of each station's printers, the first one used until it fails, from RMS_PRINTERS
+var printers = map[string][]string{}
+
+// printAlertTo is the phone number told when a ticket cannot be printed on any of its station's printers, from
+// RMS_PRINT_ALERT_TO
+var printAlertTo string
+
+// SetPrinters sets each station's printers from a spec like "grill=10.0.0.5:9100|10.0.0.6:9100,pastry=10.0.0.7:9100",
+// backups after the main one. A station not in RMS_STATIONS prints every dish, so "kitchen=10.0.0.5:9100" alone sends
+// whole tickets to one printer.
+func SetPrinters(spec string) error {
+	parsed := map[string][]string{}
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		station, addresses, ok := strings.Cut(entry, "=")
+		station = strings.ToLower(strings.TrimSpace(station))
+		if !ok || station == "" || strings.TrimSpace(addresses) == "" {
+			return fmt.Errorf("invalid printers %q (want station=host:port|host:port)", entry)
+		}
+		for _, address := range strings.Split(addresses, "|") {
+			address = strings.TrimSpace(address)
+			if _, _, err := net.SplitHostPort(address); err != nil {
+				return fmt.Errorf("invalid printer address %q for %s (want host:port)", address, station)
+			}
+			parsed[station] = append(parsed[station], address)
+		}
+	}
+	printers = parsed
+	return nil
+}
+
+// Printer sends text to a printer
+type Printer interface {
+	Print(ctx context.Context, address, text string) error
+}
+
+// NetworkPrinter prints on receipt and kitchen printers listening for raw jobs on the network, usually on port 9100
+type NetworkPrinter struct{}
+
+func (NetworkPrinter) Print(ctx context.Context, address, text string) error {
+	dialer := net.Dialer{Timeout: printTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(printTimeout))
+	// Feed the paper past the cutter and cut it (ESC/POS)
+	if _, err := conn.Write([]byte(text + "\n\n\n\n\x1dV\x00")); err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// escposQR has a receipt printer draw data as a QR code, centred on its own line (ESC/POS GS ( k, model 2, error
+// correction M). Printers that do not know the commands skip them.
+func escposQR(data string) string {
+	store := len(data) + 3
+	return "\x1ba\x01" +
+		"\x1d(k\x04\x001A2\x00" + // Model 2
+		"\x1d(k\x03\x001C\x06" + // Modules 6 dots wide
+		"\x1d(k\x03\x001E1" + // Error correction M
+		"\x1d(k" + string([]byte{byte(store), byte(store >> 8)}) + "1P0" + data + // Store the data
+		"\x1d(k\x03\x001Q0" + // Print it
+		"\n\x1ba\x00"
+}
+
+// escposQRPattern matches what escposQR adds to a text
+var escposQRPattern = regexp.MustCompile(`(?s)\x1ba\x01\x1d\(k.*?\x1ba\x00`)
+
+// withoutPrinterCodes takes the QR codes out of text for a receipt printer, to show it on a screen
+func withoutPrinterCodes(text string) string {
+	return escposQRPattern.ReplaceAllString(text, "")
+}
+
+// printer is used for every print job
+var printer Printer = NetworkPrinter{}
+
+// PrintJob is a ticket waiting to be printed, or printed, at a kitchen station
+type PrintJob struct {
+	ID            primitive.ObjectID `bson:"_id" json:"id"`
+	Station       string             `bson:"station" json:"station"`
+	OrderID       primitive.ObjectID `bson:"orderId,omitempty" json:"orderId,omitempty"`
+	Place         string             `bson:"place" json:"place"` // Where the order is served, e.g. Table 4
+	Text          string             `bson:"text" json:"text"`
+	Status        string             `bson:"status" json:"status"`
+	Printer       int                `bson:"printer" json:"printer"`   // Which of the station's printers it is sent to, 0 for the main one
+	Attempts      int                `bson:"attempts" json:"attempts"` // Failed attempts on that printer
+	PrintedOn     string             `bson:"printedOn,omitempty" json:"printedOn,omitempty"`
+	LastError     string             `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	NextAttemptAt time.Time          `bson:"nextAttemptAt" json:"nextAttemptAt"`
+	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
+	PrintedAt     *time.Time         `bson:"printedAt,omitempty" json:"printedAt,omitempty"`
+	Version       int                `bson:"version" json:"version"`
+}
+
+// queueTickets queues a kitchen ticket for each station with printers, with the lines of the order it cooks that keep
+// returns true for. The heading, if any, is printed above the ticket. A station with nothing to cook gets no ticket.
+func queueTickets(ctx context.Context, order Order, heading string, keep func(OrderLine) bool) error {
+	if len(printers) == 0 {
+		return nil
+	}
+	menu := LoadMenu(ctx)
+	now := time.Now()
+	for station := range printers {
+		categories, cooksSome := stations[station]
+		ticket := order
+		ticket.Items = slices.DeleteFunc(slices.Clone(order.Items), func(line OrderLine) bool {
+			if !keep(line) || LineHeld(order, line) {
+				return true
+			}
+			item, _ := FindMenuItem(menu, line.Name)
+			return cooksSome && !slices.Contains(categories, strings.ToLower(item.Category))
+		})
+		if len(ticket.Items) == 0 {
+			continue
+		}
+		text := FormatKitchenTicket(ticket)
+		if heading != "" {
+			text = heading + "\n" + text
+		}
+		job := PrintJob{
+			ID: primitive.NewObjectID(), Station: station, OrderID: order.ID, Place: orderPlace(order), Text: text,
+			Status: PrintQueued, NextAttemptAt: now, CreatedAt: now,
+		}
+		if err := storeFor(ctx).PrintJobs().Add(ctx, job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printTickets queues tickets like queueTickets, logging rather than failing so a printer problem never holds up the
+// order
+func printTickets(ctx context.Context, order Order, heading string, keep func(OrderLine) bool) {
+	if err := queueTickets(ctx, order, heading, keep); err != nil {
+		log.Printf("Error queuing the tickets of order %s: %v", order.ID.Hex(), err)
+	}
+}
+
+// everyLine keeps every line of an order on its tickets
+func everyLine(OrderLine) bool { return true }
+
+// PrintOrderTickets queues the order's tickets on request: a scheduled order's once it is due in the kitchen, or
+// another's again, marked as a reprint, when its ticket went missing
+func PrintOrderTickets(ctx context.Context, id primitive.ObjectID) (Order, error) {
+	order, err := FindOrder(ctx, id)
+	if err != nil {
+		return Order{}, err
+	}
+	if len(printers) == 0 {
+		return Order{}, fmt.Errorf("no printers are set up in RMS_PRINTERS")
+	}
+	heading := "*** REPRINT ***"
+	if order.ScheduledFor != nil {
+		heading = ""
+	}
+	return order, queueTickets(ctx, order, heading, everyLine)
+}
+
+// SendPrintJobs sends the print jobs that are due to their station's printers, returning how many were printed. A job
+// that fails is tried again after a delay and moves on to the station's next printer after maxPrinterAttempts; once
+// none are left it is marked failed and printAlertTo is told, so a lost ticket does not go unnoticed.
+func SendPrintJobs(ctx context.Context, now time.Time) (int, error) {
+	due, err := storeFor(ctx).PrintJobs().ListDue(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	printed := 0
+	for _, job := range due {
+		// The lease keeps other terminals off the job while this one prints it
+		job.NextAttemptAt = now.Add(printLease)
+		err := storeFor(ctx).PrintJobs().Update(ctx, job)
+		if errors.Is(err, ErrVersionConflict) {
+			continue
+		}
+		if err != nil {
+			return printed, err
+		}
+		job.Version++
+		if sendPrintJob(ctx, &job, now) {
+			printed++
+		}
+		if err := storeFor(ctx).PrintJobs().Update(ctx, job); err != nil {
+			return printed, err
+		}
+	}
+	return printed, nil
+}
+
+// sendPrintJob makes one attempt at printing the job and records how it went, reporting whether it printed
+func sendPrintJob(ctx context.Context, job *PrintJob, now time.Time) bool {
+	addresses := printers[job.Station]
+	if job.Printer < len(addresses) {
+		address := addresses[job.Printer]
+		printCtx, cancel := context.WithTimeout(ctx, printTimeout)
+		err := printer.Print(printCtx, address, job.Text)
+		cancel()
+		if err == nil {
+			printedAt := now
+			job.Status, job.PrintedOn, job.PrintedAt, job.LastError = PrintPrinted, address, &printedAt, ""
+			return true
+		}
+		job.Attempts++
+		job.LastError = fmt.Sprintf("%s: %v", address, err)
+		log.Printf("Printing %s ticket for %s on %s failed (attempt %d): %v", job.Station, job.Place, address, job.Attempts, err)
+		if job.Attempts < maxPrinterAttempts {
+			job.NextAttemptAt = now.Add(time.Duration(job.Attempts) * printRetryDelay)
+			return false
+		}
+		job.Printer, job.Attempts = job.Printer+1, 0
+		if job.Printer < len(addresses) {
+			log.Printf("Sending %s ticket for %s to the backup printer %s", job.Station, job.Place, addresses[job.Printer])
+			job.NextAttemptAt = now
+			return false
+		}
+	} else {
+		job.LastError = fmt.Sprintf("%s has no printer left to try", job.Station)
+	}
+	job.Status = PrintFailed
+	log.Printf("Could not print the %s ticket for %s on any printer: %s", job.Station, job.Place, job.LastError)
+	if printAlertTo != "" {
+		Notify(ctx, Notification{
+			To:      printAlertTo,
+			Subject: fmt.Sprintf("Ticket not printed: %s", job.Station),
+			Message: fmt.Sprintf("The %s ticket for %s did not print (%s). Retry it or read it from the print jobs.", job.Station,
+				job.Place, job.LastError),
+		})
+	}
+	return false
+}
+
+// RetryPrintJob sends a failed job to its station's main printer again
+func RetryPrintJob(ctx context.Context, id primitive.ObjectID) (PrintJob, error) {
+	job, err := storeFor(ctx).PrintJobs().Find(ctx, id)
+	if err != nil {
+		return PrintJob{}, err
+	}
+	if job.Status != PrintFailed {
+		return PrintJob{}, fmt.Errorf("print job is %s, only failed jobs can be retried", job.Status)
+	}
+	job.Status, job.Printer, job.Attempts, job.NextAttemptAt = PrintQueued, 0, 0, time.Now()
+	if err := storeFor(ctx).PrintJobs().Update(ctx, job); err != nil {
+		return PrintJob{}, err
+	}
+	job.Version++
+	return job, nil
+}
+
+// ListPrintJobs returns the print jobs of the last day, newest first, only those with the status and at the station
+// when they are given
+func ListPrintJobs(ctx context.Context, status, station string) ([]PrintJob, error) {
+	jobs, err := storeFor(ctx).PrintJobs().ListSince(ctx, time.Now().Add(-printJobsShown))
+	if err != nil {
+		return nil, err
+	}
+	station = strings.ToLower(strings.TrimSpace(station))
+	jobs = slices.DeleteFunc(jobs, func(job PrintJob) bool {
+		return (status != "" && job.Status != status) || (station != "" && job.Station != station)
+	})
+	if jobs == nil {
+		jobs = []PrintJob{}
+	}
+	return jobs, nil
+}
+
+// ShowPrintJobs prints the print jobs of the last day
+func ShowPrintJobs(ctx context.Context, status, station string) error {
+	jobs, err := ListPrintJobs(ctx, status, station)
+	if err != nil {
+		return err
+	}
+	jobListing := listing{
+		title:   "Print jobs, last 24 hours:",
+		header:  []string{"ID", "Queued", "Station", "Order", "Status", "Printer", "Last error"},
+		records: jobs,
+	}
+	for _, job := range jobs {
+		printerUsed := job.PrintedOn
+		if printerUsed == "" {
+			printerUsed = fmt.Sprintf("#%d, %d attempts", job.Printer+1, job.Attempts)
+		}
+		jobListing.rows = append(jobListing.rows, []string{
+			job.ID.Hex(), job.CreatedAt.Local().Format("15:04:05"), job.Station, job.Place, job.Status, printerUsed, job.LastError,
+		})
+		jobListing.compact = append(jobListing.compact, fmt.Sprintf("%s %s %s %s: %s %s", job.ID.Hex(),
+			job.CreatedAt.Local().Format("15:04"), job.Station, job.Place, job.Status, job.LastError))
+	}
+	return printListing(os.Stdout, jobListing)
+}
+
+// StartPrintSpooler sends queued tickets to the printers in the background
+func StartPrintSpooler() {
+	go func() {
+		for {
+			err := forEachTenant(context.TODO(), func(ctx context.Context) error {
+				if IsOffline() || len(printers) == 0 {
+					return nil
+				}
+				_, err := SendPrintJobs(ctx, time.Now())
+				return err
+			})
+			if err != nil {
+				log.Println("Print spooler:", err)
+			}
+			time.Sleep(spoolInterval)
+		}
+	}()
+}
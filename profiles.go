@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Profiles an install runs under. Each has its own databases so a developer can never write to
+// the restaurant's real data by accident.
+const (
+	ProfileDev     = "dev"     // Sample data is added and everything is logged
+	ProfileStaging = "staging" // Like prod, on its own databases, for trying releases
+	ProfileProd    = "prod"    // The restaurant's real data: no sample data, and destructive commands need --force
+)
+
+// Log levels, set with RMS_LOG_LEVEL
+const (
+	LogDebug = "debug" // Also logs every HTTP request
+	LogInfo  = "info"
+)
+
+// logLevel is how much is logged
+var logLevel = LogInfo
+
+// seedSampleData is whether long-running commands add the sample menu, tables and customer
+var seedSampleData bool
+
+// profileDefaults are the settings each profile starts from; the environment overrides them
+type profileDefaults struct {
+	database string // Name of the MongoDB or Postgres database, and of the SQLite file
+	seed     bool
+	logLevel string
+}
+
+var profiles = map[string]profileDefaults{
+	ProfileDev:     {database: "restaurant_dev", seed: true, logLevel: LogDebug},
+	ProfileStaging: {database: "restaurant_staging", logLevel: LogInfo},
+	ProfileProd:    {database: "restaurant", logLevel: LogInfo},
+}
+
+// applyProfile checks the profile's settings and applies the seeding choice and log level
+func applyProfile(cfg Config) error {
+	seedSampleData = profiles[cfg.Profile].seed
+	if cfg.Seed != "" {
+		seed, err := strconv.ParseBool(cfg.Seed)
+		if err != nil {
+			return fmt.Errorf("RMS_SEED must be true or false")
+		}
+		seedSampleData = seed
+	}
+	if cfg.Profile == ProfileProd && seedSampleData {
+		return fmt.Errorf("sample data is never added in prod; unset RMS_SEED")
+	}
+	switch cfg.LogLevel {
+	case LogDebug, LogInfo:
+		logLevel = cfg.LogLevel
+		return nil
+	}
+	return fmt.Errorf("RMS_LOG_LEVEL must be %s or %s", LogDebug, LogInfo)
+}
+
+// confirmDestructive stops a command that rewrites or adds data in bulk from running against prod
+// unless --force was given
+func confirmDestructive(cfg Config, what string) error {
+	if cfg.Profile == ProfileProd && !cfg.Force {
+		return fmt.Errorf("%s changes the restaurant's real data; run it again with --force if you are sure", what)
+	}
+	return nil
+}
+
+// debugf logs only at the debug level
+func debugf(format string, args ...any) {
+	if logLevel == LogDebug {
+		log.Printf(format, args...)
+	}
+}
+
+// logRequests logs each HTTP request with its status and how long it took, at the debug level
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		debugf("%s %s %d %s", r.Method, r.URL.Path, recorder.status, time.Since(start).Round(time.Microsecond))
+	})
+}
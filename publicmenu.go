@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// publicMenuMaxAge is how long the public menu is served from memory, and browsers and CDNs may keep it, before
+// it is read from the database again. An item 86'd or going off at the end of its serving hours drops off within it.
+const publicMenuMaxAge = 30 * time.Second
+
+// publicMenuStale is how much longer a CDN may serve the menu it has while it fetches it again, or cannot
+const publicMenuStale = 5 * time.Minute
+
+// PublicMenuItem is an item on the menu customers see, without the recipe, tax codes and barcodes only staff need
+type PublicMenuItem struct {
+	Name          string     `json:"name"`
+	Price         float64    `json:"price"` // Per kg when sold by weight
+	SoldByWeight  bool       `json:"soldByWeight,omitempty"`
+	Allergens     []string   `json:"allergens,omitempty"`
+	Diets         []string   `json:"diets,omitempty"`
+	Nutrition     *Nutrition `json:"nutrition,omitempty"`
+	AgeRestricted bool       `json:"ageRestricted,omitempty"`
+	SoldOut       bool       `json:"soldOut,omitempty"` // 86'd for the rest of the day
+	TaxRate       float64    `json:"taxRate,omitempty"` // Tax percentage added to Price on the bill, when prices exclude tax
+}
+
+// PublicMenuCategory is the items of one category, in menu order
+type PublicMenuCategory struct {
+	Name  string           `json:"name"` // Empty for items without one
+	Items []PublicMenuItem `json:"items"`
+}
+
+// PublicMenu is what is served now, for QR menus on the tables and the restaurant's site
+type PublicMenu struct {
+	Categories []PublicMenuCategory `json:"categories"` // In the order their first item is on the menu
+	TaxesExtra bool                 `json:"taxesExtra"` // Prices exclude tax, added on the bill at each item's TaxRate
+	UpdatedAt  time.Time            `json:"updatedAt"`  // When it last changed
+}
+
+// publicMenuPage is the public menu as served, in JSON and HTML, with its validators
+type publicMenuPage struct {
+	json, html         []byte
+	jsonETag, htmlETag string
+	modified           time.Time
+	builtAt            time.Time
+}
+
+// storePublicMenu is one store's public menu. Requests for it wait while it is built, so a rush of customers
+// scanning the QR code reads the database once every publicMenuMaxAge.
+type storePublicMenu struct {
+	sync.Mutex
+	page *publicMenuPage
+}
+
+// publicMenus holds each store's public menu, one per tenant in SaaS mode. It is only locked to find the store's,
+// so a tenant whose database is slow holds up no one else's menu.
+var publicMenus = struct {
+	sync.Mutex
+	stores map[Store]*storePublicMenu
+}{stores: map[Store]*storePublicMenu{}}
+
+// loadPublicMenu returns the public menu from memory, building it first when there is none or it is older than
+// publicMenuMaxAge. When the database cannot be read the last menu built is served on.
+func loadPublicMenu(ctx context.Context) (*publicMenuPage, error) {
+	s := storeFor(ctx)
+	publicMenus.Lock()
+	menu := publicMenus.stores[s]
+	if menu == nil {
+		menu = &storePublicMenu{}
+		publicMenus.stores[s] = menu
+	}
+	publicMenus.Unlock()
+
+	menu.Lock()
+	defer menu.Unlock()
+	now := clock.Now()
+	if menu.page != nil && now.Sub(menu.page.builtAt) < publicMenuMaxAge {
+		return menu.page, nil
+	}
+	built, err := buildPublicMenu(ctx, now, menu.page)
+	if err != nil {
+		if menu.page == nil {
+			return nil, err
+		}
+		log.Println("Error reading the public menu, serving the last one:", err)
+		menu.page.builtAt = now
+		return menu.page, nil
+	}
+	menu.page = built
+	return built, nil
+}
+
+// buildPublicMenu reads the menu served at now and renders it. It keeps the last page's modification time when
+// nothing on it changed, so conditional requests still match.
+func buildPublicMenu(ctx context.Context, now time.Time, last *publicMenuPage) (*publicMenuPage, error) {
+	items, err := storeFor(ctx).Menu().List(ctx)
+	if err == nil && currentBranch != "" {
+		items, err = branchMenuItems(ctx, currentBranch, items)
+	}
+	if err != nil {
+		return nil, err
+	}
+	menu := PublicMenu{Categories: []PublicMenuCategory{}, TaxesExtra: pricesExcludeTax}
+	index := map[string]int{}
+	for _, item := range ServedMenu(items, now) {
+		i, seen := index[item.Category]
+		if !seen {
+			i = len(menu.Categories)
+			index[item.Category] = i
+			menu.Categories = append(menu.Categories, PublicMenuCategory{Name: item.Category})
+		}
+		public := PublicMenuItem{
+			Name: item.Name, Price: item.Price, SoldByWeight: item.SoldByWeight, Allergens: item.Allergens, Diets: item.Diets,
+			Nutrition: item.Nutrition, AgeRestricted: item.AgeRestricted, SoldOut: IsSoldOut(item, now),
+		}
+		if pricesExcludeTax {
+			public.TaxRate = itemTaxClass(item).Rate
+		}
+		menu.Categories[i].Items = append(menu.Categories[i].Items, public)
+	}
+
+	// The validators are taken from the items and the pricing mode alone, so the menu only looks changed when it has
+	content, err := json.Marshal(struct {
+		Categories []PublicMenuCategory
+		TaxesExtra bool
+	}{menu.Categories, menu.TaxesExtra})
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+	tag := hex.EncodeToString(sum[:8])
+	page := &publicMenuPage{jsonETag: `"` + tag + `"`, htmlETag: `"` + tag + `-html"`, modified: now.Truncate(time.Second), builtAt: now}
+	if last != nil && last.jsonETag == page.jsonETag {
+		page.modified = last.modified
+	}
+	menu.UpdatedAt = page.modified
+	if page.json, err = json.Marshal(menu); err != nil {
+		return nil, err
+	}
+	var html bytes.Buffer
+	if err := dashboardTemplates.ExecuteTemplate(&html, "menu.html", menu); err != nil {
+		return nil, err
+	}
+	page.html = html.Bytes()
+	return page, nil
+}
+
+// handlePublicMenu serves the menu customers see, as HTML at /menu and as JSON at /menu.json. Nothing about it is
+// private, so browsers and CDNs may cache it for publicMenuMaxAge, and a client sending back its ETag or
+// Last-Modified is told it has not changed.
+func handlePublicMenu(w http.ResponseWriter, r *http.Request) {
+	ctx, err := customerLinkContext(r)
+	var page *publicMenuPage
+	if err == nil {
+		page, err = loadPublicMenu(ctx)
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	body, etag, name := page.html, page.htmlETag, "menu.html"
+	if r.URL.Path == "/menu.json" {
+		body, etag, name = page.json, page.jsonETag, "menu.json"
+	}
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(publicMenuMaxAge.Seconds()))+
+		", stale-while-revalidate="+strconv.Itoa(int(publicMenuStale.Seconds()))+", stale-if-error="+strconv.Itoa(int(publicMenuStale.Seconds())))
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, name, page.modified, bytes.NewReader(body))
+}
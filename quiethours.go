@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// queueInterval is how often the background job sends the notifications held back by quiet hours
+const queueInterval = time.Minute
+
+// quietHours is when customers are not sent marketing, from RMS_QUIET_HOURS; nil when they can be sent it any time
+var quietHours *TimeWindow
+
+// SetQuietHours sets the quiet hours from a spec like "21:00-09:00"; empty turns them off
+func SetQuietHours(spec string) error {
+	quietHours = nil
+	if strings.TrimSpace(spec) == "" {
+		return nil
+	}
+	from, until, ok := strings.Cut(spec, "-")
+	if !ok {
+		return fmt.Errorf("invalid quiet hours %q (want HH:MM-HH:MM)", spec)
+	}
+	window := TimeWindow{From: strings.TrimSpace(from), Until: strings.TrimSpace(until)}
+	if err := window.Validate(); err != nil {
+		return fmt.Errorf("quiet hours: %w", err)
+	}
+	quietHours = &window
+	return nil
+}
+
+// quietUntil returns when the quiet hours t falls in end, or the zero time when t is outside them
+func quietUntil(t time.Time) time.Time {
+	if quietHours == nil || !quietHours.Contains(t) {
+		return time.Time{}
+	}
+	until, _ := parseClock(quietHours.Until)
+	end := time.Date(t.Year(), t.Month(), t.Day(), until/60, until%60, 0, 0, t.Location())
+	if !end.After(t) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
+
+// QueuedNotification is a notification held back by quiet hours until it may be sent
+type QueuedNotification struct {
+	ID           primitive.ObjectID `bson:"_id" json:"id"`
+	Notification Notification       `bson:"notification" json:"notification"`
+	SendAfter    time.Time          `bson:"sendAfter" json:"sendAfter"`
+	QueuedAt     time.Time          `bson:"queuedAt" json:"queuedAt"`
+}
+
+// holdNotification queues the notification until the quiet hours end
+func holdNotification(ctx context.Context, n Notification, until time.Time) error {
+	queued := QueuedNotification{ID: primitive.NewObjectID(), Notification: n, SendAfter: until, QueuedAt: clock.Now()}
+	return storeFor(ctx).NotificationQueue().Add(ctx, queued)
+}
+
+// SendQueuedNotifications sends the held back notifications that are due at now, returning how many went out.
+// Each is taken off the queue before it is sent, so it is sent once whichever terminal gets to it first, and the
+// customer's consent is checked again as it may have changed while it waited.
+func SendQueuedNotifications(ctx context.Context, now time.Time) (int, error) {
+	due, err := storeFor(ctx).NotificationQueue().ListDue(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, queued := range due {
+		err := storeFor(ctx).NotificationQueue().Remove(ctx, queued.ID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return sent, err
+		}
+		Notify(ctx, queued.Notification)
+		sent++
+	}
+	return sent, nil
+}
+
+// StartNotificationQueue sends the notifications held back by quiet hours in the background once they are due
+func StartNotificationQueue() {
+	go func() {
+		for {
+			err := forEachTenant(context.TODO(), func(ctx context.Context) error {
+				if IsOffline() {
+					return nil
+				}
+				_, err := SendQueuedNotifications(ctx, clock.Now())
+				return err
+			})
+			if err != nil {
+				log.Println("Queued notifications:", err)
+			}
+			time.Sleep(queueInterval)
+		}
+	}()
+}
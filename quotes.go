@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Quote statuses
+const (
+	QuoteDraft     = "draft"
+	QuoteSent      = "sent"
+	QuoteApproved  = "approved"
+	QuoteDeclined  = "declined"
+	QuoteConverted = "converted" // Turned into an order
+)
+
+// quoteValidity is how long a customer has to approve a quote once it is sent
+const quoteValidity = 14 * 24 * time.Hour
+
+// publicURL is where customers reach this server, from RMS_PUBLIC_URL; approval links point there
+var publicURL = "http://localhost:8080"
+
+// ErrQuoteStatus is returned when a quote is not in the state an action needs
+var ErrQuoteStatus = errors.New("quote cannot be changed")
+
+// Quote prices a large catering order for the customer to approve before it becomes an order
+type Quote struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CustomerName string             `bson:"customerName" json:"customerName"`
+	Email        string             `bson:"email,omitempty" json:"email,omitempty"`
+	Phone        string             `bson:"phone,omitempty" json:"phone,omitempty"`
+	Lines        []QuoteLine        `bson:"lines" json:"lines"`
+	Subtotal     float64            `bson:"subtotal" json:"subtotal"` // At menu prices
+	Discount     float64            `bson:"discount" json:"discount"` // Bulk discount off the subtotal
+	Total        float64            `bson:"total" json:"total"`
+	FulfillAt    time.Time          `bson:"fulfillAt" json:"fulfillAt"` // When the food is to be ready
+	Notes        string             `bson:"notes,omitempty" json:"notes,omitempty"`
+	Status       string             `bson:"status" json:"status"`
+	Token        string             `bson:"token" json:"-"` // Secret in the approval link
+	SentAt       *time.Time         `bson:"sentAt,omitempty" json:"sentAt,omitempty"`
+	ValidUntil   *time.Time         `bson:"validUntil,omitempty" json:"validUntil,omitempty"`
+	RespondedAt  *time.Time         `bson:"respondedAt,omitempty" json:"respondedAt,omitempty"`
+	OrderID      primitive.ObjectID `bson:"orderId,omitempty" json:"orderId,omitzero"`
+	CreatedAt    time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// QuoteLine is a menu item on a quote at its bulk price
+type QuoteLine struct {
+	Name      string  `bson:"name" json:"name"`
+	Quantity  int     `bson:"quantity" json:"quantity"` // Whole kilograms of an item sold by weight
+	MenuPrice float64 `bson:"menuPrice" json:"menuPrice"`
+	Discount  float64 `bson:"discount,omitempty" json:"discount,omitempty"` // Percentage off for the quantity
+	Price     float64 `bson:"price" json:"price"`                           // Per unit after the discount
+	Amount    float64 `bson:"amount" json:"amount"`
+}
+
+// BulkTier takes a percentage off an item ordered in at least a minimum quantity
+type BulkTier struct {
+	MinQuantity int
+	Percent     float64
+}
+
+// bulkTiers holds the bulk discounts in effect, smallest quantity first
+var bulkTiers = []BulkTier{{50, 5}, {100, 10}}
+
+// SetBulkPricing reads the bulk discounts from a spec like "50=5,100=10", meaning 5% off 50 or more
+// of an item and 10% off 100 or more
+func SetBulkPricing(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	var tiers []BulkTier
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		quantity, percent, ok := strings.Cut(entry, "=")
+		minimum, err := strconv.Atoi(strings.TrimSpace(quantity))
+		discount, err2 := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if !ok || err != nil || err2 != nil || minimum < 1 || discount < 0 || discount > 100 {
+			return fmt.Errorf("invalid bulk discount %q (want quantity=percent)", entry)
+		}
+		tiers = append(tiers, BulkTier{minimum, discount})
+	}
+	slices.SortFunc(tiers, func(a, b BulkTier) int { return a.MinQuantity - b.MinQuantity })
+	bulkTiers = tiers
+	return nil
+}
+
+// bulkDiscount returns the percentage off for ordering quantity of one item
+func bulkDiscount(quantity int) float64 {
+	var percent float64
+	for _, tier := range bulkTiers {
+		if quantity >= tier.MinQuantity {
+			percent = tier.Percent
+		}
+	}
+	return percent
+}
+
+// priceQuote prices each line from the menu with its bulk discount and totals the quote
+func priceQuote(quote *Quote, menu []MenuItem) error {
+	quote.Subtotal, quote.Discount, quote.Total = 0, 0, 0
+	for i, line := range quote.Lines {
+		item, found := FindMenuItem(menu, line.Name)
+		if !found {
+			return fmt.Errorf("item %s not found in menu", line.Name)
+		}
+		if line.Quantity < 1 {
+			return fmt.Errorf("quantity must be at least 1")
+		}
+		priced := QuoteLine{Name: item.Name, Quantity: line.Quantity, MenuPrice: item.Price, Discount: bulkDiscount(line.Quantity)}
+		priced.Price = roundPaise(item.Price * (1 - priced.Discount/100))
+		priced.Amount = roundPaise(priced.Price * float64(priced.Quantity))
+		quote.Lines[i] = priced
+		quote.Subtotal += item.Price * float64(line.Quantity)
+		quote.Total += priced.Amount
+	}
+	quote.Subtotal, quote.Total = roundPaise(quote.Subtotal), roundPaise(quote.Total)
+	quote.Discount = roundPaise(quote.Subtotal - quote.Total)
+	return nil
+}
+
+// CreateQuote prices a new quote from the menu and stores it as a draft
+func CreateQuote(ctx context.Context, quote Quote) (Quote, error) {
+	quote.CustomerName = strings.TrimSpace(quote.CustomerName)
+	if quote.CustomerName == "" {
+		return Quote{}, fmt.Errorf("customer name is required")
+	}
+	if len(quote.Lines) == 0 {
+		return Quote{}, fmt.Errorf("a quote needs at least one item")
+	}
+	if !quote.FulfillAt.After(clock.Now()) {
+		return Quote{}, fmt.Errorf("the fulfillment date must be in the future")
+	}
+	if err := priceQuote(&quote, LoadMenu(ctx)); err != nil {
+		return Quote{}, err
+	}
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return Quote{}, err
+	}
+	quote.ID, quote.Token, quote.Status, quote.CreatedAt = primitive.NewObjectID(), hex.EncodeToString(secret), QuoteDraft, clock.Now()
+	quote.SentAt, quote.ValidUntil, quote.RespondedAt, quote.OrderID = nil, nil, nil, primitive.NilObjectID
+	return quote, storeFor(ctx).Quotes().Insert(ctx, quote)
+}
+
+// FindQuote loads a quote by ID
+func FindQuote(ctx context.Context, id primitive.ObjectID) (Quote, error) {
+	return storeFor(ctx).Quotes().Find(ctx, id)
+}
+
+// FindQuoteByToken loads the quote an approval link is for
+func FindQuoteByToken(ctx context.Context, token string) (Quote, error) {
+	return storeFor(ctx).Quotes().FindByToken(ctx, token)
+}
+
+// ListQuotes returns the quotes created in [from, to), oldest first
+func ListQuotes(ctx context.Context, from, to time.Time) ([]Quote, error) {
+	quotes, err := storeFor(ctx).Quotes().ListBetween(ctx, from, to)
+	if quotes == nil {
+		quotes = []Quote{}
+	}
+	return quotes, err
+}
+
+// quoteLink is the page where the customer approves or declines the quote. In SaaS mode it names the tenant,
+// as the customer has no API key to tell which restaurant the quote is from.
+func quoteLink(ctx context.Context, quote Quote) string {
+	link := publicURL + "/quotes/" + quote.Token
+	if tenant, ok := TenantFrom(ctx); ok {
+		link += "?tenant=" + tenant.ID
+	}
+	return link
+}
+
+// SendQuote sends the customer a link to approve or decline the quote. A draft, or a sent quote that
+// has not been answered, can be (re)sent; the link is valid for two weeks from sending.
+func SendQuote(ctx context.Context, id primitive.ObjectID) (Quote, error) {
+	quote, err := FindQuote(ctx, id)
+	if err != nil {
+		return Quote{}, err
+	}
+	if quote.Status != QuoteDraft && quote.Status != QuoteSent {
+		return Quote{}, fmt.Errorf("%w: it is %s", ErrQuoteStatus, quote.Status)
+	}
+	to := quote.Email
+	if to == "" {
+		to = quote.Phone
+	}
+	if to == "" {
+		return Quote{}, fmt.Errorf("the quote has no email address or phone number to send it to")
+	}
+	now := clock.Now()
+	validUntil := now.Add(quoteValidity)
+	quote.Status, quote.SentAt, quote.ValidUntil = QuoteSent, &now, &validUntil
+	if err := storeFor(ctx).Quotes().Update(ctx, quote); err != nil {
+		return Quote{}, err
+	}
+	Notify(ctx, templateMessage(ctx, TemplateQuote, to, map[string]string{
+		"customerName": quote.CustomerName, "fulfillAt": quote.FulfillAt.Format("02 Jan 2006 15:04"),
+		"total": fmt.Sprintf("%.2f", quote.Total), "validUntil": validUntil.Format("02 Jan 2006"), "link": quoteLink(ctx, quote),
+	}))
+	return quote, nil
+}
+
+// RespondToQuote records the customer's answer from the approval link
+func RespondToQuote(ctx context.Context, token string, approve bool) (Quote, error) {
+	quote, err := FindQuoteByToken(ctx, token)
+	if err != nil {
+		return Quote{}, err
+	}
+	if quote.Status != QuoteSent {
+		return Quote{}, fmt.Errorf("%w: it is %s", ErrQuoteStatus, quote.Status)
+	}
+	now := clock.Now()
+	if quote.ValidUntil != nil && now.After(*quote.ValidUntil) {
+		return Quote{}, fmt.Errorf("%w: it expired on %s", ErrQuoteStatus, quote.ValidUntil.Format("02 Jan 2006"))
+	}
+	quote.Status, quote.RespondedAt = QuoteDeclined, &now
+	if approve {
+		quote.Status = QuoteApproved
+	}
+	return quote, storeFor(ctx).Quotes().Update(ctx, quote)
+}
+
+// ConvertQuote turns an approved quote into an order at the quoted prices, scheduled for the fulfillment
+// date. The kitchen sees it on that day.
+func ConvertQuote(ctx context.Context, id primitive.ObjectID) (Quote, error) {
+	if IsOffline() {
+		return Quote{}, fmt.Errorf("quotes can only be converted while the database is reachable")
+	}
+	quote, err := FindQuote(ctx, id)
+	if err != nil {
+		return Quote{}, err
+	}
+	if quote.Status != QuoteApproved {
+		return Quote{}, fmt.Errorf("%w: it is %s", ErrQuoteStatus, quote.Status)
+	}
+	menu := LoadMenu(ctx)
+	order := Order{CustomerName: quote.CustomerName, Type: OrderCatering, ScheduledFor: &quote.FulfillAt}
+	for _, line := range quote.Lines {
+		item, _ := FindMenuItem(menu, line.Name)
+		added := AddToCart(nil, item, line.Quantity)[0]
+		added.Name, added.Price = line.Name, line.Price
+		if item.SoldByWeight {
+			// A quote counts an item sold by weight in whole kilograms
+			added.Weight = 1
+		}
+		order.Items = addLine(order.Items, added)
+	}
+	if order.MenuVersion, err = storeFor(ctx).MenuVersions().Latest(ctx); err != nil {
+		return Quote{}, err
+	}
+	// Availability today says nothing about the fulfillment date, and the customer approved the items
+	order, err = submitOrderOnline(ctx, order)
+	if err != nil {
+		return Quote{}, err
+	}
+	quote.Status, quote.OrderID = QuoteConverted, order.ID
+	return quote, storeFor(ctx).Quotes().Update(ctx, quote)
+}
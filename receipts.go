@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// receiptWidth is how many characters a receipt printer prints on a line
+const receiptWidth = 32
+
+// ErrReprintNotAllowed is returned when the caller's role may not reprint receipts
+var ErrReprintNotAllowed = errors.New("your role may not reprint receipts")
+
+// reprintRoles are the staff roles that may reprint receipts, from RMS_REPRINT_ROLES. Callers without a role,
+// such as the owner's key or the command line, always may.
+var reprintRoles = []string{RoleCashier, RoleManager}
+
+// SetReprintRoles sets the staff roles that may reprint receipts from a spec like "cashier,manager"; an empty
+// spec leaves cashiers and managers
+func SetReprintRoles(spec string) error {
+	if strings.TrimSpace(spec) == "" {
+		reprintRoles = []string{RoleCashier, RoleManager}
+		return nil
+	}
+	var parsed []string
+	for _, role := range strings.Split(spec, ",") {
+		role = strings.ToLower(strings.TrimSpace(role))
+		if role == "" {
+			continue
+		}
+		if !slices.Contains(staffRoles, role) {
+			return fmt.Errorf("unknown role %q in reprint roles (want one of %s)", role, strings.Join(staffRoles, ", "))
+		}
+		parsed = append(parsed, role)
+	}
+	reprintRoles = parsed
+	return nil
+}
+
+// FormatReceipt lays a settled bill out for a receipt printer: the header, the lines with their prices, any
+// discount, charges and round-off, the total, how it was paid and the footer, with amounts in RMS_NUMBER_FORMAT. A
+// reprint is marked DUPLICATE at the top and bottom, so it cannot pass for the original. With a link, a QR code
+// for the digital bill is printed under the footer.
+func FormatReceipt(order Order, invoice *Invoice, payments []Payment, reprint int, at time.Time, header, footer, link string) string {
+	var b strings.Builder
+	rule := strings.Repeat("-", receiptWidth) + "\n"
+	amount := func(label string, value float64) {
+		// Long names are cut short so the amounts stay in one column
+		if runes := []rune(label); len(runes) > receiptWidth-10 {
+			label = string(runes[:receiptWidth-10])
+		}
+		fmt.Fprintf(&b, "%-*s %9s\n", receiptWidth-10, label, formatAmount(value))
+	}
+	if reprint > 0 {
+		b.WriteString("*** DUPLICATE ***\n")
+	}
+	if header != "" {
+		fmt.Fprintf(&b, "%s\n", header)
+	}
+	fmt.Fprintf(&b, "%s  %s\n", orderPlace(order), order.CreatedAt.Format("02 Jan 2006 15:04"))
+	if order.Number != "" {
+		fmt.Fprintf(&b, "Order %s\n", order.Number)
+	}
+	fmt.Fprintf(&b, "%s\n", order.CustomerName)
+	if order.DeliveryAddress != nil {
+		fmt.Fprintf(&b, "%s\n", order.DeliveryAddress)
+	}
+	if invoice != nil {
+		fmt.Fprintf(&b, "Tax invoice %s\n", invoice.Number)
+		if invoice.GSTIN != "" {
+			fmt.Fprintf(&b, "GSTIN %s\n", invoice.GSTIN)
+		}
+	}
+	b.WriteString(rule)
+	for _, line := range order.Items {
+		amount(fmt.Sprintf("%d x %s", line.Quantity, line.Name), line.Amount())
+		if detail := weightDetail(line); detail != "" {
+			fmt.Fprintf(&b, "  %s\n", detail)
+		}
+		if detail := discountDetail(line.Discount, line.DiscountReason); detail != "" {
+			fmt.Fprintf(&b, "  %s\n", detail)
+		}
+	}
+	b.WriteString(rule)
+	off := roundOff(order)
+	if order.Discount > 0 || len(order.Charges) > 0 || order.TaxOnTop || off != 0 {
+		amount("Subtotal", CartTotal(order.Items))
+	}
+	if order.Discount > 0 {
+		amount(discountDetail(order.Discount, order.DiscountReason), -roundPaise(CartTotal(order.Items)-linesTotal(order)))
+	}
+	for _, charge := range order.Charges {
+		amount(charge.Name, charge.Amount)
+	}
+	// Tax added to prices that excluded it is listed before the total it is part of
+	if order.TaxOnTop && invoice != nil {
+		for _, tax := range invoiceTaxes(*invoice) {
+			amount(tax.Label, tax.Amount)
+		}
+	} else if order.TaxOnTop {
+		amount("Taxes", taxOnTop(order))
+	}
+	if off != 0 {
+		amount(roundOffLabel, off)
+	}
+	amount("Total", order.Total)
+	for _, payment := range payments {
+		amount("Paid "+payment.Method, payment.Amount)
+	}
+	if invoice != nil && !order.TaxOnTop {
+		for _, tax := range invoiceTaxes(*invoice) {
+			amount("Incl. "+tax.Label, tax.Amount)
+		}
+	}
+	if footer != "" {
+		b.WriteString(rule)
+		fmt.Fprintf(&b, "%s\n", footer)
+	}
+	if link != "" {
+		b.WriteString(rule)
+		b.WriteString("Scan for your bill, feedback\nand offers\n")
+		b.WriteString(escposQR(link))
+		fmt.Fprintf(&b, "%s\n", link)
+	}
+	if reprint > 0 {
+		b.WriteString(rule)
+		b.WriteString("*** DUPLICATE ***\n")
+		fmt.Fprintf(&b, "Reprint %d, %s\n", reprint, at.Format("02 Jan 2006 15:04"))
+	}
+	return b.String()
+}
+
+// ReprintReceipt renders a settled order's receipt again, marked as a duplicate, and records who reprinted it and
+// why in the order's history. Only callers whose role is in RMS_REPRINT_ROLES may reprint.
+func ReprintReceipt(ctx context.Context, id primitive.ObjectID, reason string) (string, Order, error) {
+	if record, ok := APIKeyFrom(ctx); ok && record.Role != "" && !slices.Contains(reprintRoles, record.Role) {
+		return "", Order{}, ErrReprintNotAllowed
+	}
+	reason = strings.TrimSpace(reason)
+	order, err := changeOrder(ctx, id, func(order Order) (OrderEvent, error) {
+		if !order.Paid {
+			return OrderEvent{}, fmt.Errorf("order is not settled yet, so it has no receipt to reprint")
+		}
+		return OrderEvent{Type: EventReceiptReprinted, Reason: reason, By: changedBy(ctx)}, nil
+	})
+	if err != nil {
+		return "", Order{}, err
+	}
+
+	events, err := LoadOrderHistory(ctx, id)
+	if err != nil {
+		return "", Order{}, err
+	}
+	var payments []Payment
+	var reprintedAt time.Time
+	for _, event := range events {
+		switch event.Type {
+		case EventPaid:
+			payments = append(payments, *event.Payment)
+		case EventReceiptReprinted:
+			reprintedAt = event.At
+		}
+	}
+	var invoice *Invoice
+	if found, err := storeFor(ctx).Invoices().FindByOrder(ctx, id); err == nil {
+		invoice = &found
+	} else if !errors.Is(err, ErrNotFound) {
+		return "", Order{}, err
+	}
+	values := map[string]string{"customerName": order.CustomerName, "place": orderPlace(order), "number": order.Number, "total": fmt.Sprintf("%.2f", order.Total)}
+	_, header := renderTemplate(ctx, TemplateReceiptHeader, values)
+	_, footer := renderTemplate(ctx, TemplateReceiptFooter, values)
+	return FormatReceipt(order, invoice, payments, order.Reprints, reprintedAt, header, footer, billLink(ctx, order)), order, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// HourlySales is the number of orders and revenue taken in one hour of the day. The open items rung up, which were
+// priced by the cashier rather than the menu, are counted apart, before any discount.
+type HourlySales struct {
+	Hour        int     `json:"hour"`
+	Orders      int     `json:"orders"`
+	Revenue     float64 `json:"revenue"`
+	OpenItems   int     `json:"openItems"`
+	OpenRevenue float64 `json:"openRevenue"`
+}
+
+// LoadOrdersBetween returns every order created in [from, to)
+func LoadOrdersBetween(ctx context.Context, from, to time.Time) ([]Order, error) {
+	return storeFor(ctx).Orders().ListBetween(ctx, from, to)
+}
+
+// DailySales breaks down the orders placed on the given day by hour
+func DailySales(ctx context.Context, day time.Time) ([]HourlySales, error) {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	orders, err := LoadOrdersBetween(ctx, start, start.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	// Staff meals are not sales; they show in the staff meal report
+	orders = slices.DeleteFunc(orders, staffMeal)
+
+	sales := make([]HourlySales, 24)
+	for hour := range sales {
+		sales[hour].Hour = hour
+	}
+	for _, order := range orders {
+		hour := order.CreatedAt.In(day.Location()).Hour()
+		sales[hour].Orders++
+		sales[hour].Revenue += order.Total
+		for _, line := range order.Items {
+			if line.Open {
+				sales[hour].OpenItems += line.Quantity
+				sales[hour].OpenRevenue = roundPaise(sales[hour].OpenRevenue + line.Amount() + lineTaxOnTop(line))
+			}
+		}
+	}
+	return sales, nil
+}
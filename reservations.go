@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reservation statuses
+const (
+	ReservationBooked    = "booked"
+	ReservationSeated    = "seated"
+	ReservationNoShow    = "no-show"
+	ReservationCancelled = "cancelled"
+)
+
+// Deposit statuses
+const (
+	DepositHeld      = "held"       // Taken, waiting for the party to arrive
+	DepositApplied   = "applied"    // Counted towards the party's bill
+	DepositForfeited = "forfeited"  // Kept, in whole or part, after a no-show
+	DepositRefundDue = "refund-due" // To be handed back after a cancellation
+)
+
+// reservationLength is how long a reserved table is expected to be held for the party
+const reservationLength = 2 * time.Hour
+
+// Reservation books a table for a party, optionally with a deposit taken up front and dishes
+// ordered in advance for an event
+type Reservation struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CustomerName string             `bson:"customerName" json:"customerName"`
+	Phone        string             `bson:"phone,omitempty" json:"phone,omitempty"`
+	Table        int                `bson:"table,omitempty" json:"table,omitempty"`
+	PartySize    int                `bson:"partySize" json:"partySize"`
+	At           time.Time          `bson:"at" json:"at"`
+	Status       string             `bson:"status" json:"status"`
+	PreOrder     []OrderLine        `bson:"preOrder,omitempty" json:"preOrder,omitempty"` // Sent to the kitchen when the party is seated
+	// The deposit is a payment recorded against the reservation; it moves to the order when the party is seated
+	Deposit          float64            `bson:"deposit,omitempty" json:"deposit,omitempty"`
+	DepositPaymentID primitive.ObjectID `bson:"depositPaymentId,omitempty" json:"depositPaymentId,omitzero"`
+	DepositStatus    string             `bson:"depositStatus,omitempty" json:"depositStatus,omitempty"`
+	Forfeited        float64            `bson:"forfeited,omitempty" json:"forfeited,omitempty"`
+	RefundDue        float64            `bson:"refundDue,omitempty" json:"refundDue,omitempty"`
+	OrderID          primitive.ObjectID `bson:"orderId,omitempty" json:"orderId,omitzero"`
+	ApprovedBy       string             `bson:"approvedBy,omitempty" json:"approvedBy,omitempty"` // Manager who let a flagged customer book
+	CreatedAt        time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// DepositPolicy decides what happens to a deposit when the party does not turn up, from the config
+type DepositPolicy struct {
+	NoShowForfeit float64       // Percentage of the deposit kept on a no-show; the rest is refunded
+	NoShowGrace   time.Duration // How late a party may be before it can be marked a no-show
+}
+
+// depositPolicy holds the deposit policy in effect
+var depositPolicy = DepositPolicy{NoShowForfeit: 100, NoShowGrace: 15 * time.Minute}
+
+// SetDepositPolicy reads the deposit policy from the config
+func SetDepositPolicy(cfg Config) error {
+	policy := DepositPolicy{NoShowForfeit: 100, NoShowGrace: 15 * time.Minute}
+	if cfg.NoShowForfeit != "" {
+		percent, err := strconv.ParseFloat(cfg.NoShowForfeit, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return fmt.Errorf("invalid no-show forfeit %q (want a percentage)", cfg.NoShowForfeit)
+		}
+		policy.NoShowForfeit = percent
+	}
+	if cfg.NoShowGrace != "" {
+		minutes, err := strconv.Atoi(cfg.NoShowGrace)
+		if err != nil || minutes < 0 {
+			return fmt.Errorf("invalid no-show grace period %q (want minutes)", cfg.NoShowGrace)
+		}
+		policy.NoShowGrace = time.Duration(minutes) * time.Minute
+	}
+	depositPolicy = policy
+	return nil
+}
+
+// BookReservation stores a new reservation. It returns a *FlaggedError if the customer is flagged,
+// unless ApprovedBy names the manager who allowed it.
+func BookReservation(ctx context.Context, reservation Reservation) (Reservation, error) {
+	reservation.CustomerName = strings.TrimSpace(reservation.CustomerName)
+	if reservation.CustomerName == "" {
+		return Reservation{}, fmt.Errorf("customer name is required")
+	}
+	if reservation.PartySize < 1 {
+		return Reservation{}, fmt.Errorf("party size must be at least 1")
+	}
+	if reservation.At.IsZero() {
+		return Reservation{}, fmt.Errorf("reservation time is required")
+	}
+	for i := range reservation.PreOrder {
+		if err := cleanLine(&reservation.PreOrder[i]); err != nil {
+			return Reservation{}, err
+		}
+	}
+	reservation.ApprovedBy = strings.TrimSpace(reservation.ApprovedBy)
+	if err := checkFlag(ctx, reservation.CustomerName, reservation.ApprovedBy); err != nil {
+		return Reservation{}, err
+	}
+	if reservation.Table != 0 {
+		if err := checkTableFree(ctx, reservation.Table, reservation.At); err != nil {
+			return Reservation{}, err
+		}
+	}
+	reservation.ID = primitive.NewObjectID()
+	reservation.Status = ReservationBooked
+	reservation.CreatedAt = clock.Now()
+	reservation.Deposit, reservation.DepositStatus, reservation.DepositPaymentID = 0, "", primitive.NilObjectID
+	reservation.Forfeited, reservation.RefundDue, reservation.OrderID = 0, 0, primitive.NilObjectID
+	return reservation, storeFor(ctx).Reservations().Insert(ctx, reservation)
+}
+
+// FindReservation loads a reservation by ID
+func FindReservation(ctx context.Context, id primitive.ObjectID) (Reservation, error) {
+	return storeFor(ctx).Reservations().Find(ctx, id)
+}
+
+// ListReservations returns the reservations for [from, to), earliest first
+func ListReservations(ctx context.Context, from, to time.Time) ([]Reservation, error) {
+	reservations, err := storeFor(ctx).Reservations().ListBetween(ctx, from, to)
+	if reservations == nil {
+		reservations = []Reservation{}
+	}
+	return reservations, err
+}
+
+// TakeDeposit records a deposit or prepayment for a booked reservation as a payment. It needs the database,
+// as the reservation has to be updated with it.
+func TakeDeposit(ctx context.Context, id primitive.ObjectID, payment Payment) (Reservation, error) {
+	if IsOffline() {
+		return Reservation{}, fmt.Errorf("deposits can only be taken while the database is reachable")
+	}
+	reservation, err := FindReservation(ctx, id)
+	if err != nil {
+		return Reservation{}, err
+	}
+	if reservation.Status != ReservationBooked {
+		return Reservation{}, fmt.Errorf("reservation is %s", reservation.Status)
+	}
+	if !reservation.DepositPaymentID.IsZero() {
+		return Reservation{}, fmt.Errorf("a deposit of Rs %.2f was already taken", reservation.Deposit)
+	}
+	payment.ID, payment.OrderID, payment.ReservationID, payment.CreatedAt = primitive.NewObjectID(), primitive.NilObjectID, id, clock.Now()
+	if err := ValidatePayment(payment); err != nil {
+		return Reservation{}, err
+	}
+	if payment.Method == PaymentCash {
+		if payment.DrawerID, err = openDrawerID(ctx); err != nil {
+			return Reservation{}, err
+		}
+	}
+	if err := assignShift(ctx, &payment); err != nil {
+		return Reservation{}, err
+	}
+	paymentDevice(ctx, &payment)
+	if err := storeFor(ctx).Payments().Insert(ctx, payment); err != nil {
+		return Reservation{}, err
+	}
+	reservation.Deposit, reservation.DepositPaymentID, reservation.DepositStatus = payment.Amount, payment.ID, DepositHeld
+	return reservation, storeFor(ctx).Reservations().Update(ctx, reservation)
+}
+
+// SeatReservation marks the party as arrived. Dishes ordered in advance are sent to the kitchen as a new
+// order, or, when orderID is set, the party's existing order is used; either way the deposit is applied
+// to that order's bill.
+func SeatReservation(ctx context.Context, id, orderID primitive.ObjectID) (Reservation, error) {
+	reservation, err := FindReservation(ctx, id)
+	if err != nil {
+		return Reservation{}, err
+	}
+	if reservation.Status != ReservationBooked {
+		return Reservation{}, fmt.Errorf("reservation is %s", reservation.Status)
+	}
+	if orderID.IsZero() && len(reservation.PreOrder) > 0 {
+		order, err := SubmitOrder(ctx, Order{
+			CustomerName: reservation.CustomerName, Table: reservation.Table, Type: OrderDineIn,
+			Covers: reservation.PartySize, Items: reservation.PreOrder,
+		})
+		if err != nil {
+			return Reservation{}, err
+		}
+		orderID = order.ID
+	}
+	if reservation.DepositStatus == DepositHeld {
+		if orderID.IsZero() {
+			return Reservation{}, fmt.Errorf("an order is needed to apply the deposit to")
+		}
+		if err := applyDeposit(ctx, reservation, orderID); err != nil {
+			return Reservation{}, err
+		}
+		reservation.DepositStatus = DepositApplied
+	}
+	reservation.Status, reservation.OrderID = ReservationSeated, orderID
+	if reservation.Table != 0 {
+		if err := SetTableOccupied(ctx, reservation.Table, true); err != nil {
+			return Reservation{}, err
+		}
+	}
+	return reservation, storeFor(ctx).Reservations().Update(ctx, reservation)
+}
+
+// applyDeposit counts the reservation's deposit as paid towards the order. The payment itself was
+// already recorded when the deposit was taken, so only the order's history changes.
+func applyDeposit(ctx context.Context, reservation Reservation, orderID primitive.ObjectID) error {
+	payment, err := storeFor(ctx).Payments().Find(ctx, reservation.DepositPaymentID)
+	if err != nil {
+		return err
+	}
+	// The deposit was reconciled with the shift it was taken in, which does not settle this order
+	payment.OrderID, payment.ShiftID = orderID, primitive.NilObjectID
+	_, err = changeOrder(ctx, orderID, func(Order) (OrderEvent, error) {
+		return OrderEvent{Type: EventPaid, Payment: &payment}, nil
+	})
+	return err
+}
+
+// MarkNoShow records that the party did not turn up, once the grace period has passed, and forfeits
+// the deposit according to the policy
+func MarkNoShow(ctx context.Context, id primitive.ObjectID) (Reservation, error) {
+	reservation, err := FindReservation(ctx, id)
+	if err != nil {
+		return Reservation{}, err
+	}
+	if reservation.Status != ReservationBooked {
+		return Reservation{}, fmt.Errorf("reservation is %s", reservation.Status)
+	}
+	if deadline := reservation.At.Add(depositPolicy.NoShowGrace); clock.Now().Before(deadline) {
+		return Reservation{}, fmt.Errorf("the party can be marked a no-show from %s", deadline.Format("15:04"))
+	}
+	reservation.Status = ReservationNoShow
+	if reservation.DepositStatus == DepositHeld {
+		reservation.Forfeited = roundPaise(reservation.Deposit * depositPolicy.NoShowForfeit / 100)
+		reservation.RefundDue = roundPaise(reservation.Deposit - reservation.Forfeited)
+		reservation.DepositStatus = DepositForfeited
+	}
+	return reservation, storeFor(ctx).Reservations().Update(ctx, reservation)
+}
+
+// CancelReservation cancels a booking; any deposit taken is due back to the customer
+func CancelReservation(ctx context.Context, id primitive.ObjectID) (Reservation, error) {
+	reservation, err := FindReservation(ctx, id)
+	if err != nil {
+		return Reservation{}, err
+	}
+	if reservation.Status != ReservationBooked {
+		return Reservation{}, fmt.Errorf("reservation is %s", reservation.Status)
+	}
+	reservation.Status = ReservationCancelled
+	if reservation.DepositStatus == DepositHeld {
+		reservation.RefundDue, reservation.DepositStatus = reservation.Deposit, DepositRefundDue
+	}
+	return reservation, storeFor(ctx).Reservations().Update(ctx, reservation)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Refund is money handed back to a customer for an order, e.g. for a dish sent back
+type Refund struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	OrderID   primitive.ObjectID `bson:"orderId" json:"orderId"`
+	Branch    string             `bson:"branch,omitempty" json:"branch,omitempty"` // The order's branch
+	Amount    float64            `bson:"amount" json:"amount"`                     // GST included, as the customer paid it
+	Reason    string             `bson:"reason" json:"reason"`
+	By        string             `bson:"by" json:"by"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// RefundOrder records money handed back for an order. Together with the refunds before it, it cannot come to
+// more than was paid for the order.
+func RefundOrder(ctx context.Context, id primitive.ObjectID, amount float64, reason, by string) (Refund, error) {
+	reason, by = strings.TrimSpace(reason), strings.TrimSpace(by)
+	switch {
+	case amount <= 0:
+		return Refund{}, fmt.Errorf("the refund must be positive")
+	case reason == "":
+		return Refund{}, fmt.Errorf("a reason for the refund is required")
+	case by == "":
+		return Refund{}, fmt.Errorf("who gave the refund is required")
+	}
+	order, err := FindOrder(ctx, id)
+	if err != nil {
+		return Refund{}, err
+	}
+	earlier, err := storeFor(ctx).Refunds().ListForOrder(ctx, id)
+	if err != nil {
+		return Refund{}, err
+	}
+	refunded := 0.0
+	for _, refund := range earlier {
+		refunded += refund.Amount
+	}
+	if left := roundPaise(order.AmountPaid - refunded); amount > left {
+		return Refund{}, fmt.Errorf("only Rs %.2f of the order's payments is left to refund", left)
+	}
+	refund := Refund{
+		ID: primitive.NewObjectID(), OrderID: id, Branch: order.Branch, Amount: roundPaise(amount), Reason: reason, By: by,
+		CreatedAt: clock.Now(),
+	}
+	return refund, storeFor(ctx).Refunds().Add(ctx, refund)
+}
+
+// royaltyRates are the royalty percentages of net sales each franchised branch pays, from RMS_ROYALTY_RATES.
+// Branches not listed are run by the company and pay none.
+var royaltyRates = map[string]float64{}
+
+// royaltyExcluded are the menu categories royalties are not charged on, from RMS_ROYALTY_EXCLUDE
+var royaltyExcluded []string
+
+// SetRoyalties sets the royalty percentage of each franchised branch from a spec like "andheri=6,bandra=5.5"
+// and the categories excluded from royalties from a comma separated list like "alcohol,tobacco"
+func SetRoyalties(rates, excluded string) error {
+	parsed := map[string]float64{}
+	for _, entry := range strings.Split(rates, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		branch, amount, ok := strings.Cut(entry, "=")
+		percent, err := strconv.ParseFloat(strings.TrimSpace(amount), 64)
+		branch = strings.TrimSpace(branch)
+		if !ok || err != nil || percent <= 0 || percent > 100 || branch == "" {
+			return fmt.Errorf("invalid royalty rate %q (want branch=percent)", entry)
+		}
+		parsed[branch] = percent
+	}
+	royaltyRates, royaltyExcluded = parsed, nil
+	for _, category := range strings.Split(excluded, ",") {
+		if category = strings.ToLower(strings.TrimSpace(category)); category != "" {
+			royaltyExcluded = append(royaltyExcluded, category)
+		}
+	}
+	return nil
+}
+
+// RoyaltyStatement is what a franchised branch owes in royalties for a month. Sales are net of GST; refunds are
+// counted in the month they were given, less their GST and their share in excluded categories.
+type RoyaltyStatement struct {
+	Branch   string  `json:"branch"`
+	Month    string  `json:"month"` // e.g. 2024-05
+	Orders   int     `json:"orders"`
+	Sales    float64 `json:"sales"`    // Everything sold, without GST
+	Excluded float64 `json:"excluded"` // Sales in categories royalties are not charged on
+	Refunds  float64 `json:"refunds"`
+	NetSales float64 `json:"netSales"` // What the royalty is charged on
+	Rate     float64 `json:"rate"`     // Percentage of net sales
+	Royalty  float64 `json:"royalty"`
+}
+
+// royaltyBase splits an order's sales, without GST, into what royalties are charged on and what is excluded
+func royaltyBase(ctx context.Context, order Order, menu []MenuItem) (charged, excluded float64, err error) {
+	invoice, err := storeFor(ctx).Invoices().FindByOrder(ctx, order.ID)
+	if errors.Is(err, ErrNotFound) {
+		invoice, err = buildInvoice(order, menu), nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, line := range invoice.Lines {
+		item, _ := FindMenuItem(menu, line.Name)
+		if slices.Contains(royaltyExcluded, strings.ToLower(item.Category)) {
+			excluded += line.TaxableValue
+		} else {
+			charged += line.TaxableValue
+		}
+	}
+	return charged, excluded, nil
+}
+
+// RoyaltyReport works out the royalty each franchised branch owes for a month (YYYY-MM), by branch name
+func RoyaltyReport(ctx context.Context, month string) ([]RoyaltyStatement, error) {
+	from, to, err := monthRange(month)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := LoadOrdersBetween(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	refunds, err := storeFor(ctx).Refunds().ListBetween(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	menu := loadHeadOfficeMenu(ctx)
+	statements := map[string]*RoyaltyStatement{}
+	for branch, rate := range royaltyRates {
+		statements[branch] = &RoyaltyStatement{Branch: branch, Month: month, Rate: rate}
+	}
+	for _, order := range orders {
+		statement := statements[order.Branch]
+		if statement == nil || staffMeal(order) {
+			continue
+		}
+		charged, excluded, err := royaltyBase(ctx, order, menu)
+		if err != nil {
+			return nil, err
+		}
+		statement.Orders++
+		statement.Sales += charged + excluded
+		statement.Excluded += excluded
+	}
+	for _, refund := range refunds {
+		statement := statements[refund.Branch]
+		if statement == nil {
+			continue
+		}
+		order, err := FindOrder(ctx, refund.OrderID)
+		if err != nil {
+			return nil, err
+		}
+		// The refund takes off the royalty-bearing share of what the customer paid
+		if charged, _, err := royaltyBase(ctx, order, menu); err != nil {
+			return nil, err
+		} else if order.Total > 0 {
+			statement.Refunds += refund.Amount * charged / order.Total
+		}
+	}
+
+	report := []RoyaltyStatement{}
+	for _, statement := range statements {
+		statement.Sales, statement.Excluded, statement.Refunds = roundPaise(statement.Sales), roundPaise(statement.Excluded), roundPaise(statement.Refunds)
+		statement.NetSales = roundPaise(statement.Sales - statement.Excluded - statement.Refunds)
+		statement.Royalty = roundPaise(statement.NetSales * statement.Rate / 100)
+		report = append(report, *statement)
+	}
+	slices.SortFunc(report, func(a, b RoyaltyStatement) int { return strings.Compare(a.Branch, b.Branch) })
+	return report, nil
+}
+
+// ShowRoyalties prints the royalty each franchised branch owes for a month
+func ShowRoyalties(ctx context.Context, month string) error {
+	report, err := RoyaltyReport(ctx, month)
+	if err != nil {
+		return err
+	}
+	royaltyListing := listing{
+		title:   fmt.Sprintf("Royalties for %s:", month),
+		header:  []string{"Branch", "Orders", "Sales", "Excluded", "Refunds", "Net sales", "Rate", "Royalty"},
+		records: report,
+	}
+	if len(royaltyExcluded) > 0 {
+		royaltyListing.title = fmt.Sprintf("Royalties for %s, excluding %s:", month, strings.Join(royaltyExcluded, ", "))
+	}
+	for _, statement := range report {
+		royaltyListing.rows = append(royaltyListing.rows, []string{
+			statement.Branch, fmt.Sprint(statement.Orders), fmt.Sprintf("%.2f", statement.Sales), fmt.Sprintf("%.2f", statement.Excluded),
+			fmt.Sprintf("%.2f", statement.Refunds), fmt.Sprintf("%.2f", statement.NetSales), fmt.Sprintf("%g%%", statement.Rate),
+			fmt.Sprintf("%.2f", statement.Royalty),
+		})
+		royaltyListing.compact = append(royaltyListing.compact, fmt.Sprintf("%s Rs %.2f on Rs %.2f net sales",
+			statement.Branch, statement.Royalty, statement.NetSales))
+	}
+	return printListing(os.Stdout, royaltyListing)
+}
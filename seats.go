@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SharedSeat is the seat of lines the whole table shares, and of lines not put at any guest's seat
+const SharedSeat = 0
+
+// ItemMove moves some of a line to another guest's seat at the same table
+type ItemMove struct {
+	Name     string `bson:"name" json:"name"`
+	Course   int    `bson:"course,omitempty" json:"course,omitempty"`
+	FromSeat int    `bson:"fromSeat" json:"fromSeat"`
+	ToSeat   int    `bson:"toSeat" json:"toSeat"`
+	Quantity int    `bson:"quantity" json:"quantity"`
+}
+
+// ItemVoid is quantity of a line taken off an order. Sent and Value are filled in when it is voided.
+type ItemVoid struct {
+	Name     string  `bson:"name" json:"name"`
+	Course   int     `bson:"course,omitempty" json:"course,omitempty"`
+	Seat     int     `bson:"seat" json:"seat"`
+	Quantity int     `bson:"quantity" json:"quantity"`
+	Sent     bool    `bson:"sent,omitempty" json:"sent,omitempty"`   // The line had already gone to the kitchen
+	Value    float64 `bson:"value,omitempty" json:"value,omitempty"` // What came off the bill
+}
+
+// SeatBill is what one seat owes when a table splits the bill by seat
+type SeatBill struct {
+	Seat       int           `json:"seat"`
+	Items      []OrderLine   `json:"items"`
+	Charges    []OrderCharge `json:"charges,omitempty"` // The order's charges, which are on the shared bill
+	Total      float64       `json:"total"`
+	AmountPaid float64       `json:"amountPaid"`
+	Paid       bool          `json:"paid"`
+}
+
+// findLine returns the index of the line for the item, course and seat
+func findLine(lines []OrderLine, name string, course, seat int) int {
+	return slices.IndexFunc(lines, func(line OrderLine) bool {
+		return line.Name == name && lineCourse(line) == max(course, FirstCourse) && line.Seat == seat
+	})
+}
+
+// moveLine returns a copy of lines with the move applied, merging into a line already at the new seat
+func moveLine(lines []OrderLine, move ItemMove) []OrderLine {
+	lines = append([]OrderLine(nil), lines...)
+	i := findLine(lines, move.Name, move.Course, move.FromSeat)
+	if i < 0 {
+		return lines
+	}
+	moved := lines[i]
+	moved.Quantity, moved.Seat = move.Quantity, move.ToSeat
+	lines[i].Quantity -= move.Quantity
+	if lines[i].Quantity <= 0 {
+		lines = slices.Delete(lines, i, i+1)
+	}
+	return addLine(lines, moved)
+}
+
+// voidLine returns the lines with quantity of one taken off, dropping the line when none is left
+func voidLine(lines []OrderLine, void ItemVoid) []OrderLine {
+	lines = append([]OrderLine(nil), lines...)
+	i := findLine(lines, void.Name, void.Course, void.Seat)
+	if i < 0 {
+		return lines
+	}
+	if lines[i].Quantity -= void.Quantity; lines[i].Quantity <= 0 {
+		lines = slices.Delete(lines, i, i+1)
+	}
+	return lines
+}
+
+// MoveOrderItem moves quantity of a line to another seat, e.g. when a guest takes over a dish
+// someone else ordered. Prices and totals do not change, only who pays for the line. A version other
+// than 0 must be the order's current one.
+func MoveOrderItem(ctx context.Context, id primitive.ObjectID, version int, move ItemMove) (Order, error) {
+	if move.Quantity < 1 {
+		return Order{}, fmt.Errorf("quantity must be at least 1")
+	}
+	if move.FromSeat < 0 || move.ToSeat < 0 {
+		return Order{}, fmt.Errorf("seat must not be negative")
+	}
+	if move.FromSeat == move.ToSeat {
+		return Order{}, fmt.Errorf("the item is already at seat %d", move.ToSeat)
+	}
+	if err := checkOrderUnlocked(ctx, id); err != nil {
+		return Order{}, err
+	}
+	return changeOrder(ctx, id, atOrderVersion(version, func(order Order) (OrderEvent, error) {
+		if order.Paid {
+			return OrderEvent{}, fmt.Errorf("order is already paid")
+		}
+		i := findLine(order.Items, move.Name, move.Course, move.FromSeat)
+		if i < 0 {
+			return OrderEvent{}, fmt.Errorf("no %s at seat %d", move.Name, move.FromSeat)
+		}
+		if order.Items[i].Quantity < move.Quantity {
+			return OrderEvent{}, fmt.Errorf("only %d x %s at seat %d", order.Items[i].Quantity, move.Name, move.FromSeat)
+		}
+		return OrderEvent{Type: EventItemMoved, Move: &move}, nil
+	}))
+}
+
+// SplitBySeat divides the order's lines into a bill per seat, lowest seat first, with what each
+// seat has paid so far. Payments without a seat and the order's charges and round-off count towards the shared
+// bill, as does what rounding the discount on each seat's bill left over, so the bills add up to the order's total.
+// Tax added on top of prices that excluded it goes on the bill of the seat whose item it is on.
+func SplitBySeat(order Order, payments []Payment) []SeatBill {
+	var bills []SeatBill
+	billFor := func(seat int) *SeatBill {
+		i := slices.IndexFunc(bills, func(bill SeatBill) bool { return bill.Seat == seat })
+		if i < 0 {
+			bills = append(bills, SeatBill{Seat: seat, Items: []OrderLine{}})
+			i = len(bills) - 1
+		}
+		return &bills[i]
+	}
+	for _, line := range order.Items {
+		bill := billFor(line.Seat)
+		bill.Items = append(bill.Items, line)
+		bill.Total += line.Amount()
+	}
+	for _, payment := range payments {
+		billFor(payment.Seat).AmountPaid += payment.Amount
+	}
+	off := roundOff(order)
+	if order.Discount != 0 {
+		// The discount is worked out on the whole order, so what each seat's rounding left over is on the shared bill
+		discounted := linesTotal(order)
+		for i := range bills {
+			bills[i].Total = roundPaise(bills[i].Total * (1 - order.Discount/100))
+			discounted -= bills[i].Total
+		}
+		off = roundPaise(off + discounted)
+	}
+	charges := order.Charges
+	if off != 0 {
+		// The bill is rounded as a whole, so the round-off is on the shared bill
+		charges = append(slices.Clone(charges), OrderCharge{Name: roundOffLabel, Amount: off})
+	}
+	if len(charges) > 0 {
+		billFor(SharedSeat).Charges = charges
+	}
+	for i := range bills {
+		if order.TaxOnTop {
+			// Each seat pays the tax on its own items, and the shared bill that on the charges too
+			seat := Order{Items: bills[i].Items, Discount: order.Discount, TaxOnTop: true}
+			if bills[i].Seat == SharedSeat {
+				seat.Charges = order.Charges
+			}
+			bills[i].Total = roundPaise(bills[i].Total + taxOnTop(seat))
+		}
+		if len(bills[i].Charges) > 0 {
+			bills[i].Total = roundPaise(bills[i].Total + chargesTotal(bills[i].Charges))
+		}
+		bills[i].AmountPaid = roundPaise(bills[i].AmountPaid)
+		bills[i].Paid = bills[i].AmountPaid >= bills[i].Total
+	}
+	slices.SortFunc(bills, func(a, b SeatBill) int { return a.Seat - b.Seat })
+	return bills
+}
+
+// LoadSeatBills splits an order's bill by seat, using the payments recorded in its history
+func LoadSeatBills(ctx context.Context, id primitive.ObjectID) ([]SeatBill, error) {
+	events, err := LoadOrderHistory(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	order, err := ProjectOrder(events)
+	if err != nil {
+		return nil, err
+	}
+	var payments []Payment
+	for _, event := range events {
+		if event.Type == EventPaid {
+			payments = append(payments, *event.Payment)
+		}
+	}
+	return SplitBySeat(order, payments), nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ChargeService is the name of the service charge on dine-in bills
+const ChargeService = "Service charge"
+
+// serviceChargeRate is the percentage of the food added to dine-in bills as a service charge, from RMS_SERVICE_CHARGE
+var serviceChargeRate float64
+
+// SetServiceCharge sets the service charge on dine-in bills as a percentage of the food, e.g. "10"; empty charges none
+func SetServiceCharge(spec string) error {
+	serviceChargeRate = 0
+	if strings.TrimSpace(spec) == "" {
+		return nil
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(spec), "%"), 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return fmt.Errorf("invalid service charge %q (want a percentage of the food, e.g. 10)", spec)
+	}
+	serviceChargeRate = percent
+	return nil
+}
+
+// serviceCharges returns the service charge of a new dine-in order, none when RMS_SERVICE_CHARGE is unset
+func serviceCharges(order Order) []OrderCharge {
+	if serviceChargeRate == 0 || order.Type != OrderDineIn {
+		return nil
+	}
+	return []OrderCharge{{Name: ChargeService, Amount: roundPaise(linesTotal(order) * serviceChargeRate / 100), ServicePercent: serviceChargeRate}}
+}
+
+// isServiceCharge reports whether the charge is a service charge
+func isServiceCharge(charge OrderCharge) bool {
+	return charge.ServicePercent > 0
+}
+
+// syncServiceCharge works the order's service charge out again on its food after the discount, as items are added,
+// voided or discounted
+func syncServiceCharge(order *Order) {
+	if !slices.ContainsFunc(order.Charges, isServiceCharge) {
+		return
+	}
+	order.Charges = slices.Clone(order.Charges)
+	for i, charge := range order.Charges {
+		if isServiceCharge(charge) {
+			order.Charges[i].Amount = roundPaise(linesTotal(*order) * charge.ServicePercent / 100)
+		}
+	}
+}
+
+// ServiceChargeWaiver records a service charge taken off a bill at the customer's request
+type ServiceChargeWaiver struct {
+	Amount float64   `bson:"amount" json:"amount"`
+	Reason string    `bson:"reason" json:"reason"`
+	By     string    `bson:"by,omitempty" json:"by,omitempty"`
+	At     time.Time `bson:"at" json:"at"`
+}
+
+// WaiveServiceCharge takes the service charge off an order's bill at the customer's request. The reason is
+// required and kept on the order with who waived it; the charge cannot be waived once the bill has payments.
+func WaiveServiceCharge(ctx context.Context, id primitive.ObjectID, version int, reason string) (Order, error) {
+	if reason = strings.TrimSpace(reason); reason == "" {
+		return Order{}, fmt.Errorf("a reason for waiving the service charge is required")
+	}
+	if err := checkOrderUnlocked(ctx, id); err != nil {
+		return Order{}, err
+	}
+	return changeOrder(ctx, id, atOrderVersion(version, func(order Order) (OrderEvent, error) {
+		if !slices.ContainsFunc(order.Charges, isServiceCharge) {
+			return OrderEvent{}, fmt.Errorf("order has no service charge")
+		}
+		if order.AmountPaid > 0 {
+			return OrderEvent{}, fmt.Errorf("order has payments against it; waive the service charge before it is paid")
+		}
+		return OrderEvent{Type: EventServiceChargeWaived, Reason: reason, By: changedBy(ctx)}, nil
+	}))
+}
+
+// waiveServiceCharge takes the service charge off the order as the event records
+func waiveServiceCharge(order *Order, event OrderEvent) {
+	waiver := ServiceChargeWaiver{Reason: event.Reason, By: event.By, At: event.At}
+	for _, charge := range order.Charges {
+		if isServiceCharge(charge) {
+			waiver.Amount = roundPaise(waiver.Amount + charge.Amount)
+		}
+	}
+	order.ServiceChargeWaived = &waiver
+	order.Charges = slices.DeleteFunc(slices.Clone(order.Charges), isServiceCharge)
+	order.Total = orderTotal(*order)
+	order.Paid = paidInFull(*order)
+}
+
+// WaivedServiceCharge is one service charge waived, in the service charge report
+type WaivedServiceCharge struct {
+	OrderID primitive.ObjectID `json:"orderId"`
+	ServiceChargeWaiver
+}
+
+// ServiceChargeShare is one employee's share of the service charge collected, by the hours they worked
+type ServiceChargeShare struct {
+	Employee string  `json:"employee"`
+	Hours    float64 `json:"hours"`
+	Share    float64 `json:"share"`
+}
+
+// ServiceChargeReport is the service charge collected over a period, what was waived, and how it is shared out
+type ServiceChargeReport struct {
+	From          time.Time             `json:"from"`
+	To            time.Time             `json:"to"`
+	Orders        int                   `json:"orders"` // Paid orders with a service charge
+	Collected     float64               `json:"collected"`
+	Waived        []WaivedServiceCharge `json:"waived"`
+	WaivedTotal   float64               `json:"waivedTotal"`
+	Distribution  []ServiceChargeShare  `json:"distribution"`
+	Undistributed float64               `json:"undistributed"` // Left over when nobody clocked any hours, or from rounding
+}
+
+// BuildServiceChargeReport adds up the service charge on paid orders created in [from, to) and the charges waived
+// on them, and shares what was collected out among the staff in proportion to the hours they clocked over the
+// period, as tips are in the payroll report
+func BuildServiceChargeReport(ctx context.Context, from, to time.Time) (ServiceChargeReport, error) {
+	orders, err := LoadOrdersBetween(ctx, from, to)
+	if err != nil {
+		return ServiceChargeReport{}, err
+	}
+	report := ServiceChargeReport{From: from, To: to, Waived: []WaivedServiceCharge{}, Distribution: []ServiceChargeShare{}}
+	for _, order := range orders {
+		if order.ServiceChargeWaived != nil {
+			report.Waived = append(report.Waived, WaivedServiceCharge{OrderID: order.ID, ServiceChargeWaiver: *order.ServiceChargeWaived})
+			report.WaivedTotal = roundPaise(report.WaivedTotal + order.ServiceChargeWaived.Amount)
+		}
+		if !order.Paid || !slices.ContainsFunc(order.Charges, isServiceCharge) {
+			continue
+		}
+		report.Orders++
+		for _, charge := range order.Charges {
+			if isServiceCharge(charge) {
+				report.Collected = roundPaise(report.Collected + charge.Amount)
+			}
+		}
+	}
+
+	payroll, err := PayrollReport(ctx, from, to, report.Collected)
+	if err != nil {
+		return ServiceChargeReport{}, err
+	}
+	report.Undistributed = report.Collected
+	for _, line := range payroll {
+		report.Distribution = append(report.Distribution, ServiceChargeShare{
+			Employee: line.Employee, Hours: roundPaise(line.RegularHours + line.OvertimeHours), Share: line.Tips,
+		})
+		report.Undistributed = roundPaise(report.Undistributed - line.Tips)
+	}
+	return report, nil
+}
+
+// ShowServiceChargeReport prints the service charge collected and waived in [from, to) and each employee's share
+func ShowServiceChargeReport(ctx context.Context, from, to time.Time) error {
+	report, err := BuildServiceChargeReport(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	title := fmt.Sprintf("Service charge from %s to %s: Rs %.2f collected on %d orders, Rs %.2f waived", from.Format("02 Jan 2006"),
+		to.AddDate(0, 0, -1).Format("02 Jan 2006"), report.Collected, report.Orders, report.WaivedTotal)
+	serviceListing := listing{title: title, header: []string{"Employee", "Hours", "Amount", "Note"}, records: report}
+	for _, share := range report.Distribution {
+		serviceListing.rows = append(serviceListing.rows, []string{share.Employee, fmt.Sprintf("%.2f", share.Hours), fmt.Sprintf("Rs %.2f", share.Share), "share"})
+		serviceListing.compact = append(serviceListing.compact, fmt.Sprintf("%s Rs %.2f", share.Employee, share.Share))
+	}
+	if report.Undistributed != 0 {
+		serviceListing.rows = append(serviceListing.rows, []string{"", "", fmt.Sprintf("Rs %.2f", report.Undistributed), "undistributed"})
+	}
+	for _, waived := range report.Waived {
+		note := fmt.Sprintf("waived on order %s at %s: %s", waived.OrderID.Hex(), waived.At.Format("02 Jan 15:04"), waived.Reason)
+		serviceListing.rows = append(serviceListing.rows, []string{waived.By, "", fmt.Sprintf("Rs %.2f", waived.Amount), note})
+	}
+	return printListing(os.Stdout, serviceListing)
+}
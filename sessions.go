@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// sessionTTL is how long a session lasts before its key has to sign in again
+	sessionTTL = 12 * time.Hour
+	// sessionTouchInterval is how often a session's last use is written down, so every request is not a write
+	sessionTouchInterval = time.Minute
+	// sessionPrefix starts every session token, as apiKeyPrefix does keys
+	sessionPrefix = "rms_session_"
+	// sessionHeader carries the session token along with the API key
+	sessionHeader = "X-Session"
+	// maxTerminalName caps the name a terminal signs in with
+	maxTerminalName = 60
+	// unnamedTerminal is the terminal of a session started without naming one
+	unnamedTerminal = "unnamed"
+)
+
+// ErrSessionRequired is returned when a key that must sign in is used without a live session, or with one that
+// was revoked or has expired
+var ErrSessionRequired = errors.New("sign in at /api/sessions and send the session in the X-Session header")
+
+// cashierOneTerminal keeps each cashier's key signed in at one terminal at a time, from RMS_CASHIER_ONE_TERMINAL
+var cashierOneTerminal bool
+
+// Session is a key signed in at a terminal. Only a hash of its token is stored.
+type Session struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	KeyID      primitive.ObjectID `bson:"keyId" json:"keyId"`
+	Hash       string             `bson:"hash" json:"-"`
+	Terminal   string             `bson:"terminal" json:"terminal"`
+	DeviceID   primitive.ObjectID `bson:"deviceId,omitempty" json:"deviceId,omitzero"`    // The registered device it started on, which it can only be used from
+	TwoFactor  bool               `bson:"twoFactor,omitempty" json:"twoFactor,omitempty"` // Verified with a second factor
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	LastSeenAt time.Time          `bson:"lastSeenAt" json:"lastSeenAt"`
+	ExpiresAt  time.Time          `bson:"expiresAt" json:"expiresAt"`
+	RevokedAt  *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	RevokedBy  string             `bson:"revokedBy,omitempty" json:"revokedBy,omitempty"`
+}
+
+// Active reports whether the session can still be used
+func (s Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// SessionInfo is a session with the key and staff member it belongs to, for listing
+type SessionInfo struct {
+	Session
+	KeyName string `json:"keyName"`
+	Role    string `json:"role,omitempty"`
+	Staff   string `json:"staff,omitempty"`
+}
+
+// SignedInError stops a cashier signing in at a second terminal while RMS_CASHIER_ONE_TERMINAL is set
+type SignedInError struct {
+	Session Session
+}
+
+func (e *SignedInError) Error() string {
+	return fmt.Sprintf("already signed in at %s since %s; sign out there or have the session revoked",
+		e.Session.Terminal, e.Session.CreatedAt.Format("15:04"))
+}
+
+// StartSession signs the key in at a terminal, returning the session's token. An admin key with two-factor
+// authentication signs in through /api/2fa/verify instead.
+func StartSession(ctx context.Context, record APIKey, terminal string) (string, Session, error) {
+	enabled, err := twoFactorEnabled(ctx, record)
+	if err != nil {
+		return "", Session{}, err
+	}
+	if enabled {
+		return "", Session{}, fmt.Errorf("this key signs in with a code at /api/2fa/verify")
+	}
+	return startSession(ctx, record, terminal, false)
+}
+
+// startSession stores a new session for the key, on the device the request came from if any. A cashier already
+// signed in elsewhere gets a *SignedInError while RMS_CASHIER_ONE_TERMINAL is set.
+func startSession(ctx context.Context, record APIKey, terminal string, twoFactor bool) (string, Session, error) {
+	device, fromDevice := DeviceFrom(ctx)
+	if terminal = strings.TrimSpace(terminal); fromDevice {
+		terminal = device.Name
+	} else if terminal == "" {
+		terminal = unnamedTerminal
+	}
+	if utf8.RuneCountInString(terminal) > maxTerminalName {
+		return "", Session{}, fmt.Errorf("terminal names must be at most %d characters", maxTerminalName)
+	}
+	now := time.Now()
+	if cashierOneTerminal && record.Role == RoleCashier {
+		sessions, err := store.Sessions().ListForKey(ctx, record.ID)
+		if err != nil {
+			return "", Session{}, err
+		}
+		if i := slices.IndexFunc(sessions, func(s Session) bool { return s.Active(now) }); i >= 0 {
+			return "", Session{}, &SignedInError{Session: sessions[i]}
+		}
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", Session{}, err
+	}
+	token := sessionPrefix + secret
+	session := Session{
+		ID: primitive.NewObjectID(), KeyID: record.ID, Hash: hashAPIKey(token), Terminal: terminal, DeviceID: device.ID,
+		TwoFactor: twoFactor, CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(sessionTTL),
+	}
+	if err := store.Sessions().Insert(ctx, session); err != nil {
+		return "", Session{}, err
+	}
+	return token, session, nil
+}
+
+// checkSession finds the session a request is made in, if any, and checks the key may be used in it: the session
+// must be the key's, live and used from the device it started on, admins with two-factor authentication need a
+// verified session, and a cashier kept to one terminal needs a session at all
+func checkSession(ctx context.Context, record APIKey, token string) (*Session, error) {
+	var session *Session
+	if token != "" {
+		found, err := store.Sessions().FindByHash(ctx, hashAPIKey(token))
+		deviceID, _ := deviceIdentity(ctx)
+		if errors.Is(err, ErrNotFound) || (err == nil && (found.KeyID != record.ID || found.DeviceID != deviceID || !found.Active(time.Now()))) {
+			return nil, ErrSessionRequired
+		}
+		if err != nil {
+			return nil, err
+		}
+		if time.Since(found.LastSeenAt) > sessionTouchInterval {
+			found.LastSeenAt = time.Now()
+			if err := store.Sessions().Update(ctx, found); err != nil {
+				log.Println("Error recording session use:", err)
+			}
+		}
+		session = &found
+	}
+	if err := checkTwoFactor(ctx, record, session); err != nil {
+		return nil, err
+	}
+	if session == nil && cashierOneTerminal && record.Role == RoleCashier {
+		return nil, ErrSessionRequired
+	}
+	return session, nil
+}
+
+// ListSessions returns the live sessions of the tenant's keys ("" outside SaaS mode), or only of one key when
+// keyID is set, newest first
+func ListSessions(ctx context.Context, tenantID string, keyID primitive.ObjectID) ([]SessionInfo, error) {
+	keys, err := store.APIKeys().ListByTenant(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	sessions := []SessionInfo{}
+	for _, key := range keys {
+		if !keyID.IsZero() && key.ID != keyID {
+			continue
+		}
+		found, err := store.Sessions().ListForKey(ctx, key.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, session := range found {
+			if session.Active(now) {
+				sessions = append(sessions, SessionInfo{Session: session, KeyName: key.Name, Role: key.Role, Staff: key.Staff})
+			}
+		}
+	}
+	slices.SortFunc(sessions, func(a, b SessionInfo) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return sessions, nil
+}
+
+// findTenantSession loads a session of one of the tenant's keys
+func findTenantSession(ctx context.Context, tenantID string, id primitive.ObjectID) (Session, APIKey, error) {
+	session, err := store.Sessions().Find(ctx, id)
+	if err != nil {
+		return Session{}, APIKey{}, err
+	}
+	record, err := findTenantAPIKey(ctx, tenantID, session.KeyID)
+	if err != nil {
+		return Session{}, APIKey{}, err
+	}
+	return session, record, nil
+}
+
+// RevokeSession signs a session out, e.g. a terminal left signed in or a stolen tablet. With owner set only that
+// key's sessions can be revoked, others being treated as not found. Revoking one already revoked or expired
+// changes nothing.
+func RevokeSession(ctx context.Context, tenantID string, id, owner primitive.ObjectID) (Session, error) {
+	session, record, err := findTenantSession(ctx, tenantID, id)
+	if err != nil {
+		return Session{}, err
+	}
+	if !owner.IsZero() && session.KeyID != owner {
+		return Session{}, ErrNotFound
+	}
+	if !session.Active(time.Now()) {
+		return session, nil
+	}
+	now := time.Now()
+	session.RevokedAt, session.RevokedBy = &now, changedBy(ctx)
+	if err := store.Sessions().Update(ctx, session); err != nil {
+		return Session{}, err
+	}
+	log.Printf("Session %s of API key %s (%s) at %s revoked", session.ID.Hex(), record.Prefix, record.Name, session.Terminal)
+	return session, nil
+}
+
+// ShowSessions prints the live sessions, or only those of one key
+func ShowSessions(ctx context.Context, keyID primitive.ObjectID) error {
+	sessions, err := ListSessions(ctx, "", keyID)
+	if err != nil {
+		return err
+	}
+	sessionListing := listing{
+		title:   "Sessions:",
+		header:  []string{"Session", "Key", "Staff", "Terminal", "2FA", "Signed in", "Last seen", "Expires"},
+		records: sessions,
+	}
+	for _, session := range sessions {
+		staff, twoFactor := strings.TrimSpace(session.Role+" "+session.Staff), ""
+		if session.TwoFactor {
+			twoFactor = "yes"
+		}
+		sessionListing.rows = append(sessionListing.rows, []string{
+			session.ID.Hex(), session.KeyName, staff, session.Terminal, twoFactor, session.CreatedAt.Format("02 Jan 15:04"),
+			session.LastSeenAt.Format("02 Jan 15:04"), session.ExpiresAt.Format("02 Jan 15:04"),
+		})
+		sessionListing.compact = append(sessionListing.compact, fmt.Sprintf("%s %s at %s since %s", session.ID.Hex(), session.KeyName,
+			session.Terminal, session.CreatedAt.Format("15:04")))
+	}
+	return printListing(os.Stdout, sessionListing)
+}
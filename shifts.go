@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrShiftOpen is returned when opening a shift for a cashier who already has one open
+	ErrShiftOpen = errors.New("the cashier already has an open shift")
+	// ErrNoShift is returned when a payment names a cashier who has no open shift
+	ErrNoShift = errors.New("no open shift")
+)
+
+// Shift is a cashier's turn at the till. Payments they take are tied to it, and once it is closed
+// the orders those payments settled can no longer be changed.
+type Shift struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Open     bool               `bson:"open,omitempty" json:"open"`
+	Cashier  string             `bson:"cashier" json:"cashier"`
+	OpenedAt time.Time          `bson:"openedAt" json:"openedAt"`
+	ClosedAt *time.Time         `bson:"closedAt,omitempty" json:"closedAt,omitempty"`
+	Report   *ShiftReport       `bson:"report,omitempty" json:"report,omitempty"` // Reconciliation made at close
+}
+
+// ShiftReport reconciles the payments a cashier took during a shift, by payment method
+type ShiftReport struct {
+	Cashier  string        `bson:"cashier" json:"cashier"`
+	From     time.Time     `bson:"from" json:"from"`
+	To       time.Time     `bson:"to" json:"to"`
+	Payments int           `bson:"payments" json:"payments"`
+	Orders   int           `bson:"orders" json:"orders"`
+	ByMethod []MethodTotal `bson:"byMethod" json:"byMethod"`
+	Total    float64       `bson:"total" json:"total"`
+}
+
+// MethodTotal is what was taken with one payment method
+type MethodTotal struct {
+	Method string  `bson:"method" json:"method"`
+	Count  int     `bson:"count" json:"count"`
+	Amount float64 `bson:"amount" json:"amount"`
+}
+
+// OpenShift starts a shift for the cashier
+func OpenShift(ctx context.Context, cashier string) (Shift, error) {
+	cashier = strings.TrimSpace(cashier)
+	if cashier == "" {
+		return Shift{}, fmt.Errorf("cashier is required")
+	}
+	shift := Shift{ID: primitive.NewObjectID(), Open: true, Cashier: cashier, OpenedAt: clock.Now()}
+	err := storeFor(ctx).Shifts().Open(ctx, shift)
+	if errors.Is(err, ErrDuplicate) {
+		return Shift{}, ErrShiftOpen
+	}
+	return shift, err
+}
+
+// CloseShift ends an open shift and stores its reconciliation report
+func CloseShift(ctx context.Context, id primitive.ObjectID) (Shift, error) {
+	shift, err := storeFor(ctx).Shifts().Find(ctx, id)
+	if err != nil {
+		return Shift{}, err
+	}
+	if !shift.Open {
+		return Shift{}, fmt.Errorf("the shift was already closed")
+	}
+	now := clock.Now()
+	report, err := reconcileShift(ctx, shift, now)
+	if err != nil {
+		return Shift{}, err
+	}
+	shift.Open, shift.ClosedAt, shift.Report = false, &now, &report
+	err = storeFor(ctx).Shifts().Close(ctx, shift)
+	if errors.Is(err, ErrNotFound) {
+		return Shift{}, fmt.Errorf("the shift was already closed")
+	}
+	if err != nil {
+		return Shift{}, err
+	}
+	return shift, nil
+}
+
+// reconcileShift totals the payments taken in the shift up to time t
+func reconcileShift(ctx context.Context, shift Shift, t time.Time) (ShiftReport, error) {
+	payments, err := storeFor(ctx).Payments().ListBetween(ctx, shift.OpenedAt, t.Add(time.Nanosecond))
+	if err != nil {
+		return ShiftReport{}, err
+	}
+	report := ShiftReport{Cashier: shift.Cashier, From: shift.OpenedAt, To: t, ByMethod: []MethodTotal{}}
+	totals := map[string]*MethodTotal{}
+	orders := map[primitive.ObjectID]bool{}
+	for _, payment := range payments {
+		if payment.ShiftID != shift.ID {
+			continue
+		}
+		total, ok := totals[payment.Method]
+		if !ok {
+			total = &MethodTotal{Method: payment.Method}
+			totals[payment.Method] = total
+		}
+		total.Count++
+		total.Amount += payment.Amount
+		report.Payments++
+		report.Total += payment.Amount
+		if !payment.OrderID.IsZero() {
+			orders[payment.OrderID] = true
+		}
+	}
+	for _, method := range paymentMethods {
+		if total, ok := totals[method]; ok {
+			total.Amount = roundPaise(total.Amount)
+			report.ByMethod = append(report.ByMethod, *total)
+		}
+	}
+	report.Orders, report.Total = len(orders), roundPaise(report.Total)
+	return report, nil
+}
+
+// ShiftSoFar returns a shift with its report; for an open shift the report covers the payments taken so far
+func ShiftSoFar(ctx context.Context, id primitive.ObjectID) (Shift, error) {
+	shift, err := storeFor(ctx).Shifts().Find(ctx, id)
+	if err != nil || !shift.Open {
+		return shift, err
+	}
+	report, err := reconcileShift(ctx, shift, clock.Now())
+	shift.Report = &report
+	return shift, err
+}
+
+// ListShifts returns the shifts opened in [from, to), oldest first
+func ListShifts(ctx context.Context, from, to time.Time) ([]Shift, error) {
+	shifts, err := storeFor(ctx).Shifts().ListBetween(ctx, from, to)
+	if shifts == nil {
+		shifts = []Shift{}
+	}
+	return shifts, err
+}
+
+// assignShift ties a payment to the open shift of the cashier who took it. A cash payment with no cashier
+// named is taken by whoever holds the open cash drawer.
+func assignShift(ctx context.Context, payment *Payment) error {
+	if payment.Cashier == "" && payment.Method == PaymentCash && !payment.DrawerID.IsZero() {
+		session, err := storeFor(ctx).Drawers().FindOpen(ctx)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if session.ID == payment.DrawerID {
+			payment.Cashier = session.Cashier
+		}
+	}
+	if payment.Cashier == "" {
+		return nil
+	}
+	shift, err := storeFor(ctx).Shifts().FindOpen(ctx, payment.Cashier)
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("%s has %w", payment.Cashier, ErrNoShift)
+	}
+	if err != nil {
+		return err
+	}
+	payment.ShiftID = shift.ID
+	return nil
+}
+
+// checkOrderUnlocked refuses changes to an order once a payment on it belongs to a closed shift,
+// so a reconciled shift's figures cannot move afterwards
+func checkOrderUnlocked(ctx context.Context, id primitive.ObjectID) error {
+	events, err := storeFor(ctx).Events().ListForOrder(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		if event.Type != EventPaid || event.Payment.ShiftID.IsZero() {
+			continue
+		}
+		shift, err := storeFor(ctx).Shifts().Find(ctx, event.Payment.ShiftID)
+		if err != nil {
+			return err
+		}
+		if !shift.Open {
+			return fmt.Errorf("order was settled in %s's shift, which is closed", shift.Cashier)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SoldOut records an item being 86'd: taken off for the rest of the day because the kitchen ran out
+type SoldOut struct {
+	ID   primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Item string             `bson:"item" json:"item"`
+	At   time.Time          `bson:"at" json:"at"`
+}
+
+// SoldOutCount is how often an item was 86'd over a report's period
+type SoldOutCount struct {
+	Item  string    `json:"item"`
+	Count int       `json:"count"`
+	Last  time.Time `json:"last"`
+}
+
+// IsSoldOut reports whether the item has been 86'd on the day of t
+func IsSoldOut(item MenuItem, t time.Time) bool {
+	return item.SoldOutOn != "" && item.SoldOutOn == t.Format("2006-01-02")
+}
+
+// SetSoldOut 86's an item for the rest of today, or brings it back. Each 86 is logged for the report.
+func SetSoldOut(ctx context.Context, name string, soldOut bool) (MenuItem, error) {
+	now := clock.Now()
+	var changed bool
+	item, err := UpdateMenuItem(ctx, name, 0, func(item *MenuItem) {
+		changed = IsSoldOut(*item, now) != soldOut
+		item.SoldOutOn = ""
+		if soldOut {
+			item.SoldOutOn = now.Format("2006-01-02")
+		}
+	})
+	if err != nil || !changed {
+		return item, err
+	}
+	if soldOut {
+		log.Printf("%s 86'd for the rest of the day", item.Name)
+		err = storeFor(ctx).Menu().LogSoldOut(ctx, SoldOut{ID: primitive.NewObjectID(), Item: item.Name, At: now})
+	} else {
+		log.Printf("%s is back on", item.Name)
+	}
+	return item, err
+}
+
+// ToggleSoldOut 86's an item that is available and brings back one that is 86'd
+func ToggleSoldOut(ctx context.Context, item MenuItem) (MenuItem, error) {
+	return SetSoldOut(ctx, item.Name, !IsSoldOut(item, clock.Now()))
+}
+
+// SoldOutReport counts how often each item was 86'd in [from, to), most often first
+func SoldOutReport(ctx context.Context, from, to time.Time) ([]SoldOutCount, error) {
+	records, err := storeFor(ctx).Menu().ListSoldOut(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	counts := []SoldOutCount{}
+	index := map[string]int{}
+	for _, record := range records {
+		i, ok := index[record.Item]
+		if !ok {
+			i = len(counts)
+			index[record.Item] = i
+			counts = append(counts, SoldOutCount{Item: record.Item})
+		}
+		counts[i].Count++
+		if record.At.After(counts[i].Last) {
+			counts[i].Last = record.At
+		}
+	}
+	slices.SortStableFunc(counts, func(a, b SoldOutCount) int { return b.Count - a.Count })
+	return counts, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChargeStaffMealAllowance takes what the employee's monthly staff meal allowance covers off a staff meal
+const ChargeStaffMealAllowance = "Staff meal allowance"
+
+// staffMealAllowances are how many rupees of staff meals, at cost, each employee eats free a month, from
+// RMS_STAFF_MEAL_ALLOWANCE; defaultStaffMealAllowance is everyone else's
+var (
+	staffMealAllowances       = map[string]float64{}
+	defaultStaffMealAllowance float64
+)
+
+// SetStaffMealAllowances reads the monthly staff meal allowances from a spec like "Asha=2000,*=1500", where * is
+// the allowance for everyone else. With none, staff meals are billed at cost.
+func SetStaffMealAllowances(spec string) error {
+	allowances, fallback := map[string]float64{}, 0.0
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		employee, amount, ok := strings.Cut(entry, "=")
+		allowance, err := strconv.ParseFloat(strings.TrimSpace(amount), 64)
+		if !ok || err != nil || allowance < 0 {
+			return fmt.Errorf("invalid staff meal allowance %q (want employee=rupees a month)", entry)
+		}
+		if employee = strings.TrimSpace(employee); employee == "*" {
+			fallback = allowance
+		} else {
+			allowances[employee] = allowance
+		}
+	}
+	staffMealAllowances, defaultStaffMealAllowance = allowances, fallback
+	return nil
+}
+
+// staffMealAllowance is the employee's monthly allowance, falling back to the default
+func staffMealAllowance(employee string) float64 {
+	if allowance, ok := staffMealAllowances[employee]; ok {
+		return allowance
+	}
+	return defaultStaffMealAllowance
+}
+
+// staffMeal reports whether the order is an employee's own meal rather than a sale
+func staffMeal(order Order) bool {
+	return order.Type == OrderStaffMeal
+}
+
+// stockCosts are the last price paid for one unit of everything stocked at this branch, by stockKey
+func stockCosts(ctx context.Context) (map[string]float64, error) {
+	levels, err := storeFor(ctx).Stock().List(ctx, currentBranch)
+	if err != nil {
+		return nil, err
+	}
+	costs := map[string]float64{}
+	for _, level := range levels {
+		if len(level.Batches) > 0 {
+			costs[stockKey(level.Name, level.Unit)] = level.Batches[len(level.Batches)-1].UnitCost
+		}
+	}
+	return costs, nil
+}
+
+// itemCost is what one of the item costs to make: its recipe at the last price paid for each ingredient, or for
+// an item without a recipe what was last paid for it by the piece. Stock never bought counts as nothing.
+func itemCost(costs map[string]float64, item MenuItem) float64 {
+	if len(item.Recipe) == 0 {
+		return roundPaise(costs[stockKey(strings.ToLower(item.Name), "piece")])
+	}
+	var cost float64
+	for _, ingredient := range item.Recipe {
+		cost += ingredient.Quantity * costs[stockKey(ingredient.Ingredient, ingredient.Unit)]
+	}
+	return roundPaise(cost)
+}
+
+// costLines prices the menu items among the lines at what they cost to make; open items keep the price given
+func costLines(menu []MenuItem, costs map[string]float64, lines []OrderLine) {
+	for i, line := range lines {
+		if item, found := FindMenuItem(menu, line.Name); found && !line.Open {
+			lines[i].Price = itemCost(costs, item)
+		}
+	}
+}
+
+// staffMealAllowanceUsed is how much of the employee's allowance their staff meals earlier in the month have used
+func staffMealAllowanceUsed(ctx context.Context, order Order) (float64, error) {
+	at := order.CreatedAt
+	orders, err := LoadOrdersBetween(ctx, time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, at.Location()), at)
+	if err != nil {
+		return 0, err
+	}
+	var used float64
+	for _, meal := range orders {
+		if staffMeal(meal) && meal.CustomerName == order.CustomerName && meal.ID != order.ID {
+			used -= allowanceCharge(meal)
+		}
+	}
+	return roundPaise(used), nil
+}
+
+// allowanceCharge is the (negative) allowance taken off a staff meal, 0 when none was
+func allowanceCharge(order Order) float64 {
+	var amount float64
+	for _, charge := range order.Charges {
+		if charge.Name == ChargeStaffMealAllowance {
+			amount += charge.Amount
+		}
+	}
+	return amount
+}
+
+// priceStaffMeal bills a staff meal at cost, taking off what is left of the employee's allowance for the month.
+// Items added to the meal later are billed at cost in full.
+func priceStaffMeal(ctx context.Context, menu []MenuItem, order *Order) error {
+	if IsOffline() {
+		return fmt.Errorf("staff meals are priced from the stock costs and cannot be taken while offline")
+	}
+	costs, err := stockCosts(ctx)
+	if err != nil {
+		return err
+	}
+	costLines(menu, costs, order.Items)
+	used, err := staffMealAllowanceUsed(ctx, *order)
+	if err != nil {
+		return err
+	}
+	if covered := min(staffMealAllowance(order.CustomerName)-used, CartTotal(order.Items)); covered > 0 {
+		order.Charges = append(order.Charges, OrderCharge{Name: ChargeStaffMealAllowance, Amount: -roundPaise(covered)})
+	}
+	return nil
+}
+
+// StaffMealSummary is an employee's staff meals in a month: what they cost, what the allowance covered and what
+// was billed to them
+type StaffMealSummary struct {
+	Employee  string  `json:"employee"`
+	Meals     int     `json:"meals"`
+	Items     int     `json:"items"`
+	Cost      float64 `json:"cost"`
+	Allowance float64 `json:"allowance"` // The employee's monthly allowance
+	Covered   float64 `json:"covered"`   // How much of it was used
+	Billed    float64 `json:"billed"`
+}
+
+// StaffMealReport is the staff meals eaten in a month, by employee
+type StaffMealReport struct {
+	Month     string             `json:"month"`
+	Employees []StaffMealSummary `json:"employees"`
+	Cost      float64            `json:"cost"`
+	Covered   float64            `json:"covered"`
+	Billed    float64            `json:"billed"`
+}
+
+// BuildStaffMealReport totals the staff meals in the month (YYYY-MM) by employee
+func BuildStaffMealReport(ctx context.Context, month string) (StaffMealReport, error) {
+	from, to, err := monthRange(month)
+	if err != nil {
+		return StaffMealReport{}, err
+	}
+	orders, err := LoadOrdersBetween(ctx, from, to)
+	if err != nil {
+		return StaffMealReport{}, err
+	}
+	report := StaffMealReport{Month: month, Employees: []StaffMealSummary{}}
+	byEmployee := map[string]*StaffMealSummary{}
+	for _, order := range orders {
+		if !staffMeal(order) {
+			continue
+		}
+		summary, ok := byEmployee[order.CustomerName]
+		if !ok {
+			summary = &StaffMealSummary{Employee: order.CustomerName, Allowance: staffMealAllowance(order.CustomerName)}
+			byEmployee[order.CustomerName] = summary
+		}
+		summary.Meals++
+		for _, line := range order.Items {
+			summary.Items += line.Quantity
+		}
+		summary.Cost = roundPaise(summary.Cost + CartTotal(order.Items))
+		summary.Covered = roundPaise(summary.Covered - allowanceCharge(order))
+		summary.Billed = roundPaise(summary.Billed + order.Total)
+	}
+	for _, summary := range byEmployee {
+		report.Employees = append(report.Employees, *summary)
+		report.Cost = roundPaise(report.Cost + summary.Cost)
+		report.Covered = roundPaise(report.Covered + summary.Covered)
+		report.Billed = roundPaise(report.Billed + summary.Billed)
+	}
+	slices.SortFunc(report.Employees, func(a, b StaffMealSummary) int { return strings.Compare(a.Employee, b.Employee) })
+	return report, nil
+}
+
+// ShowStaffMealReport prints the staff meals eaten in the month by employee
+func ShowStaffMealReport(ctx context.Context, month string) error {
+	report, err := BuildStaffMealReport(ctx, month)
+	if err != nil {
+		return err
+	}
+	mealListing := listing{
+		title:   fmt.Sprintf("Staff meals in %s:", month),
+		header:  []string{"Employee", "Meals", "Items", "Cost", "Allowance", "Covered", "Billed"},
+		records: report,
+	}
+	for _, summary := range report.Employees {
+		billed := fmt.Sprintf("Rs %.2f", summary.Billed)
+		mealListing.rows = append(mealListing.rows, []string{
+			summary.Employee, fmt.Sprint(summary.Meals), fmt.Sprint(summary.Items), fmt.Sprintf("Rs %.2f", summary.Cost),
+			fmt.Sprintf("Rs %.2f", summary.Allowance), fmt.Sprintf("Rs %.2f", summary.Covered), billed,
+		})
+		mealListing.compact = append(mealListing.compact, fmt.Sprintf("%s %d meals %s", summary.Employee, summary.Meals, billed))
+	}
+	mealListing.rows = append(mealListing.rows, []string{
+		"Total", "", "", fmt.Sprintf("Rs %.2f", report.Cost), "", fmt.Sprintf("Rs %.2f", report.Covered), fmt.Sprintf("Rs %.2f", report.Billed),
+	})
+	return printListing(os.Stdout, mealListing)
+}
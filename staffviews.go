@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Staff roles an API key can be issued for. A key with a role sees only that role's share of the orders; a key
+// without one sees them all.
+const (
+	RoleWaiter  = "waiter"  // The key's staff is the waiter's name, as orders record it
+	RoleChef    = "chef"    // The key's staff is the chef's kitchen station
+	RoleCashier = "cashier" // Sees the bills still to be settled
+	RoleManager = "manager" // The key's staff is the manager's name; sees every order
+)
+
+var staffRoles = []string{RoleWaiter, RoleChef, RoleCashier, RoleManager}
+
+// unsettledBillWindow is how far back bills are looked for to settle; older unpaid orders are put on a credit
+// account or written off rather than settled at the till
+const unsettledBillWindow = 48 * time.Hour
+
+// stations are the menu categories each kitchen station cooks, from RMS_STATIONS
+var stations = map[string][]string{}
+
+// SetStations sets the categories each kitchen station cooks from a spec like "grill=mains|sides,pastry=desserts"
+func SetStations(spec string) error {
+	parsed := map[string][]string{}
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		station, categories, ok := strings.Cut(entry, "=")
+		station = strings.ToLower(strings.TrimSpace(station))
+		if !ok || station == "" || strings.TrimSpace(categories) == "" {
+			return fmt.Errorf("invalid station %q (want station=category|category)", entry)
+		}
+		for _, category := range strings.Split(categories, "|") {
+			parsed[station] = append(parsed[station], strings.ToLower(strings.TrimSpace(category)))
+		}
+	}
+	stations = parsed
+	return nil
+}
+
+// validateStaffRole checks the role a key is issued for and who or which station it is for
+func validateStaffRole(record *APIKey) error {
+	record.Role, record.Staff = strings.ToLower(strings.TrimSpace(record.Role)), strings.TrimSpace(record.Staff)
+	switch record.Role {
+	case "", RoleCashier:
+		record.Staff = ""
+	case RoleWaiter, RoleManager:
+		if record.Staff == "" {
+			return fmt.Errorf("a %s's key needs the %s's name", record.Role, record.Role)
+		}
+	case RoleChef:
+		record.Staff = strings.ToLower(record.Staff)
+		if _, ok := stations[record.Staff]; !ok {
+			return fmt.Errorf("%q is not a kitchen station in RMS_STATIONS", record.Staff)
+		}
+	default:
+		return fmt.Errorf("unknown role %q (want one of %s)", record.Role, strings.Join(staffRoles, ", "))
+	}
+	return nil
+}
+
+// scopeOrders leaves the orders the caller's role may see: a waiter's own orders, the lines a chef's station
+// cooks, or the bills a cashier has to settle. Callers without a role see every order.
+func scopeOrders(ctx context.Context, orders []Order) []Order {
+	record, ok := APIKeyFrom(ctx)
+	if !ok || record.Role == "" {
+		return orders
+	}
+	var menu []MenuItem
+	if record.Role == RoleChef {
+		menu = LoadMenu(ctx)
+	}
+	scoped := []Order{}
+	for _, order := range orders {
+		switch record.Role {
+		case RoleWaiter:
+			if !strings.EqualFold(order.Waiter, record.Staff) {
+				continue
+			}
+		case RoleCashier:
+			if order.Paid {
+				continue
+			}
+		case RoleChef:
+			order.Items = slices.DeleteFunc(slices.Clone(order.Items), func(line OrderLine) bool {
+				item, _ := FindMenuItem(menu, line.Name)
+				return !slices.Contains(stations[record.Staff], strings.ToLower(item.Category))
+			})
+			if len(order.Items) == 0 {
+				continue
+			}
+		}
+		scoped = append(scoped, order)
+	}
+	return scoped
+}
+
+// LoadUnsettledBills returns the orders of the last two days not yet paid in full, oldest first, as far as the
+// caller's role may see them
+func LoadUnsettledBills(ctx context.Context) ([]Order, error) {
+	now := clock.Now()
+	orders, err := LoadOrdersBetween(ctx, now.Add(-unsettledBillWindow), now)
+	if err != nil {
+		return nil, err
+	}
+	orders = slices.DeleteFunc(orders, func(order Order) bool { return order.Paid })
+	return scopeOrders(ctx, orders), nil
+}
+
+// LoadMyTables returns the tables, or for a waiter only those with a bill of theirs still to be settled
+func LoadMyTables(ctx context.Context) ([]Table, error) {
+	tables, err := LoadTables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	record, ok := APIKeyFrom(ctx)
+	if !ok || record.Role != RoleWaiter {
+		return tables, nil
+	}
+	orders, err := LoadUnsettledBills(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(tables, func(table Table) bool {
+		return !slices.ContainsFunc(orders, func(order Order) bool { return order.Table == table.Number })
+	}), nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// standingOrderAhead is how long before it is due a standing order is placed, as a pre-order the kitchen
+	// picks up at its prep lead time
+	standingOrderAhead = 2 * time.Hour
+	// standingOrderInterval is how often standing orders are checked for runs coming due
+	standingOrderInterval = time.Minute
+)
+
+// ErrStandingOrderPaused is returned when skipping a run of a standing order that is paused anyway
+var ErrStandingOrderPaused = errors.New("the standing order is paused")
+
+// dayNames are the short names standing order days are given by, Monday first
+var dayNames = map[string]time.Weekday{
+	"mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday,
+	"sat": time.Saturday, "sun": time.Sunday,
+}
+
+// StandingOrder is a takeaway order placed again and again, e.g. an office's lunch every weekday at 13:00.
+// Each run is placed standingOrderAhead before it is due, at the menu's prices then, and the customer is told.
+type StandingOrder struct {
+	ID           primitive.ObjectID `bson:"_id" json:"id"`
+	CustomerName string             `bson:"customerName" json:"customerName"`
+	Items        []OrderLine        `bson:"items" json:"items"`
+	Notes        string             `bson:"notes,omitempty" json:"notes,omitempty"`
+	Days         []string           `bson:"days" json:"days"` // Short day names it runs on, e.g. mon, Monday first
+	At           string             `bson:"at" json:"at"`     // HH:MM it is to be ready for pickup
+	NextRunAt    time.Time          `bson:"nextRunAt" json:"nextRunAt"`
+	Skips        []string           `bson:"skips,omitempty" json:"skips,omitempty"` // Dates (YYYY-MM-DD) of coming runs not to place
+	Paused       bool               `bson:"paused,omitempty" json:"paused,omitempty"`
+	LastOrderID  primitive.ObjectID `bson:"lastOrderId,omitempty" json:"lastOrderId,omitzero"`
+	LastError    string             `bson:"lastError,omitempty" json:"lastError,omitempty"` // Why the last run could not be placed
+	CreatedBy    string             `bson:"createdBy" json:"createdBy"`
+	CreatedAt    time.Time          `bson:"createdAt" json:"createdAt"`
+	Version      int                `bson:"version" json:"version"`
+}
+
+// parseDays turns "mon,wed,fri", "weekdays" or "daily" into short day names, Monday first
+func parseDays(spec string) ([]string, error) {
+	var days []string
+	for _, day := range strings.Split(strings.ToLower(spec), ",") {
+		switch day = strings.TrimSpace(day); day {
+		case "":
+		case "daily":
+			days = append(days, "mon", "tue", "wed", "thu", "fri", "sat", "sun")
+		case "weekdays":
+			days = append(days, "mon", "tue", "wed", "thu", "fri")
+		default:
+			short := day
+			if len(short) > 3 {
+				short = short[:3]
+			}
+			if _, ok := dayNames[short]; !ok {
+				return nil, fmt.Errorf("unknown day %q (want e.g. mon,wed,fri, weekdays or daily)", day)
+			}
+			days = append(days, short)
+		}
+	}
+	if len(days) == 0 {
+		return nil, fmt.Errorf("give the days the order runs on")
+	}
+	// Sunday sorts last, as in weekdays
+	slices.SortFunc(days, func(a, b string) int { return (int(dayNames[a])+6)%7 - (int(dayNames[b])+6)%7 })
+	return slices.Compact(days), nil
+}
+
+// nextRun returns the first time after t the standing order is due
+func (s StandingOrder) nextRun(t time.Time) time.Time {
+	minutes, _ := parseClock(s.At)
+	for day := 0; day <= 7; day++ {
+		date := t.AddDate(0, 0, day)
+		run := time.Date(date.Year(), date.Month(), date.Day(), minutes/60, minutes%60, 0, 0, time.Local)
+		if run.After(t) && slices.Contains(s.Days, strings.ToLower(run.Weekday().String()[:3])) {
+			return run
+		}
+	}
+	return time.Time{}
+}
+
+// CreateStandingOrder sets up a standing order; its first run is the next one due
+func CreateStandingOrder(ctx context.Context, standing StandingOrder) (StandingOrder, error) {
+	standing.CustomerName = strings.TrimSpace(standing.CustomerName)
+	if standing.CustomerName == "" {
+		return StandingOrder{}, fmt.Errorf("customer name is required")
+	}
+	if len(standing.Items) == 0 {
+		return StandingOrder{}, fmt.Errorf("a standing order needs at least one item")
+	}
+	standing.At = strings.TrimSpace(standing.At)
+	if _, err := parseClock(standing.At); err != nil {
+		return StandingOrder{}, err
+	}
+	days, err := parseDays(strings.Join(standing.Days, ","))
+	if err != nil {
+		return StandingOrder{}, err
+	}
+	standing.Days = days
+	order := Order{Items: standing.Items, Notes: standing.Notes}
+	if err := cleanOrderInstructions(&order); err != nil {
+		return StandingOrder{}, err
+	}
+	now := clock.Now()
+	standing.Items, standing.Notes = order.Items, order.Notes
+	standing.ID, standing.CreatedBy, standing.CreatedAt, standing.Version = primitive.NewObjectID(), changedBy(ctx), now, 0
+	standing.Skips, standing.Paused, standing.LastOrderID, standing.LastError = nil, false, primitive.NilObjectID, ""
+	standing.NextRunAt = standing.nextRun(now)
+	if err := storeFor(ctx).StandingOrders().Insert(ctx, standing); err != nil {
+		return StandingOrder{}, err
+	}
+	return standing, nil
+}
+
+// ListStandingOrders returns every standing order, oldest first
+func ListStandingOrders(ctx context.Context) ([]StandingOrder, error) {
+	standing, err := storeFor(ctx).StandingOrders().List(ctx)
+	if standing == nil {
+		standing = []StandingOrder{}
+	}
+	return standing, err
+}
+
+// FindStandingOrder loads a standing order by its ID
+func FindStandingOrder(ctx context.Context, id primitive.ObjectID) (StandingOrder, error) {
+	return storeFor(ctx).StandingOrders().Find(ctx, id)
+}
+
+// SkipStandingOrder leaves out the run on a date, e.g. a holiday, without pausing the others
+func SkipStandingOrder(ctx context.Context, id primitive.ObjectID, date string) (StandingOrder, error) {
+	day, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(date), time.Local)
+	if err != nil {
+		return StandingOrder{}, fmt.Errorf("invalid date %q (want YYYY-MM-DD)", date)
+	}
+	return changeStandingOrder(ctx, id, func(standing *StandingOrder) error {
+		if standing.Paused {
+			return ErrStandingOrderPaused
+		}
+		run := standing.nextRun(day.Add(-time.Nanosecond))
+		if run.IsZero() || run.Format("2006-01-02") != day.Format("2006-01-02") {
+			return fmt.Errorf("the order does not run on %s", day.Format("Monday 2 January"))
+		}
+		if run.Before(standing.NextRunAt) {
+			return fmt.Errorf("the run on %s has already been placed", day.Format("Monday 2 January"))
+		}
+		if !slices.Contains(standing.Skips, day.Format("2006-01-02")) {
+			standing.Skips = append(standing.Skips, day.Format("2006-01-02"))
+			slices.Sort(standing.Skips)
+		}
+		return nil
+	})
+}
+
+// PauseStandingOrder stops placing a standing order's runs until it is resumed
+func PauseStandingOrder(ctx context.Context, id primitive.ObjectID) (StandingOrder, error) {
+	return changeStandingOrder(ctx, id, func(standing *StandingOrder) error {
+		standing.Paused = true
+		return nil
+	})
+}
+
+// ResumeStandingOrder places a paused standing order's runs again from the next one due; the runs missed while it
+// was paused are not placed
+func ResumeStandingOrder(ctx context.Context, id primitive.ObjectID) (StandingOrder, error) {
+	return changeStandingOrder(ctx, id, func(standing *StandingOrder) error {
+		// The next run is not placed while paused, so it stays unless its time has passed
+		if now := clock.Now(); standing.Paused && standing.NextRunAt.Before(now) {
+			standing.NextRunAt = standing.nextRun(now)
+		}
+		standing.Paused = false
+		return nil
+	})
+}
+
+// changeStandingOrder applies change to the stored standing order, retrying if the scheduler placed a run meanwhile
+func changeStandingOrder(ctx context.Context, id primitive.ObjectID, change func(*StandingOrder) error) (StandingOrder, error) {
+	for {
+		standing, err := FindStandingOrder(ctx, id)
+		if err != nil {
+			return StandingOrder{}, err
+		}
+		if err := change(&standing); err != nil {
+			return StandingOrder{}, err
+		}
+		err = storeFor(ctx).StandingOrders().Update(ctx, standing)
+		if errors.Is(err, ErrVersionConflict) {
+			continue
+		}
+		if err != nil {
+			return StandingOrder{}, err
+		}
+		standing.Version++
+		return standing, nil
+	}
+}
+
+// PlaceStandingOrders places the runs of standing orders due within standingOrderAhead of now, returning the
+// orders placed. Each run is claimed by moving the standing order on before it is placed, so two servers never
+// place it twice; runs missed while the server was down are not placed late.
+func PlaceStandingOrders(ctx context.Context, now time.Time) ([]Order, error) {
+	standing, err := storeFor(ctx).StandingOrders().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	placed := []Order{}
+	menu := LoadMenu(ctx)
+	for _, s := range standing {
+		if s.Paused || s.NextRunAt.IsZero() || s.NextRunAt.After(now.Add(standingOrderAhead)) {
+			continue
+		}
+		due := s.NextRunAt
+		date := due.Format("2006-01-02")
+		skipped := slices.Contains(s.Skips, date)
+		s.Skips = slices.DeleteFunc(s.Skips, func(skip string) bool { return skip <= date })
+		// Runs missed while the server was down are passed over
+		s.NextRunAt = s.nextRun(due)
+		if s.NextRunAt.Before(now) {
+			s.NextRunAt = s.nextRun(now)
+		}
+		if err := storeFor(ctx).StandingOrders().Update(ctx, s); errors.Is(err, ErrVersionConflict) {
+			continue
+		} else if err != nil {
+			return placed, err
+		}
+		s.Version++
+		if skipped {
+			continue
+		}
+		if due.Before(now) {
+			log.Printf("Standing order %s for %s due %s was missed while the server was down", s.ID.Hex(), s.CustomerName, due.Format("02 Jan 15:04"))
+			continue
+		}
+
+		order, err := placeStandingRun(ctx, menu, s, due)
+		s.LastError = ""
+		if err != nil {
+			log.Printf("Standing order %s for %s due %s: %v", s.ID.Hex(), s.CustomerName, due.Format("02 Jan 15:04"), err)
+			s.LastError = fmt.Sprintf("%s: %v", due.Format("02 Jan 15:04"), err)
+		} else {
+			s.LastOrderID = order.ID
+			placed = append(placed, order)
+		}
+		if err := storeFor(ctx).StandingOrders().Update(ctx, s); err != nil && !errors.Is(err, ErrVersionConflict) {
+			return placed, err
+		}
+	}
+	return placed, nil
+}
+
+// placeStandingRun places one run of a standing order as a pre-order at today's prices and tells the customer
+func placeStandingRun(ctx context.Context, menu []MenuItem, standing StandingOrder, due time.Time) (Order, error) {
+	order := Order{CustomerName: standing.CustomerName, Type: OrderTakeaway, Notes: standing.Notes, ScheduledFor: &due}
+	for _, line := range standing.Items {
+		if line.Open {
+			// Open items keep the price agreed for them
+			order.Items = addLine(order.Items, line)
+			continue
+		}
+		item, found := FindMenuItem(menu, line.Name)
+		if !found {
+			return Order{}, fmt.Errorf("%s is no longer on the menu", line.Name)
+		}
+		added := AddToCart(nil, item, line.Quantity)[0]
+		added.Course, added.Seat, added.Note, added.Flags, added.Weight = line.Course, line.Seat, line.Note, line.Flags, line.Weight
+		order.Items = addLine(order.Items, added)
+	}
+	order, err := SubmitOrder(ctx, order)
+	if err != nil {
+		return Order{}, err
+	}
+	Notify(ctx, templateMessage(ctx, TemplateStandingOrder, customerPhone(ctx, order.CustomerName), map[string]string{
+		"customerName": order.CustomerName, "due": due.Format("Monday 15:04"), "number": order.Number, "token": fmt.Sprint(order.Token),
+		"total": fmt.Sprintf("%.2f", order.Total),
+	}))
+	return order, nil
+}
+
+// StartStandingOrders places standing orders in the background as their runs come due
+func StartStandingOrders() {
+	go func() {
+		for {
+			err := forEachTenant(context.TODO(), func(ctx context.Context) error {
+				if IsOffline() {
+					return nil
+				}
+				_, err := PlaceStandingOrders(ctx, clock.Now())
+				return err
+			})
+			if err != nil {
+				log.Println("Standing orders:", err)
+			}
+			time.Sleep(standingOrderInterval)
+		}
+	}()
+}
+
+// ShowStandingOrders prints the standing orders and when each runs next
+func ShowStandingOrders(ctx context.Context) error {
+	standing, err := ListStandingOrders(ctx)
+	if err != nil {
+		return err
+	}
+	standingListing := listing{
+		title:   "Standing orders:",
+		header:  []string{"ID", "Customer", "Days", "At", "Items", "Next run", "Skipping", "Status"},
+		records: standing,
+	}
+	for _, s := range standing {
+		status, next := "active", s.NextRunAt.Format("Mon 02 Jan 15:04")
+		if s.Paused {
+			status, next = "paused", "-"
+		}
+		if s.LastError != "" {
+			status += ", last run failed"
+		}
+		standingListing.rows = append(standingListing.rows, []string{
+			s.ID.Hex(), s.CustomerName, strings.Join(s.Days, ","), s.At, fmt.Sprint(len(s.Items)), next, strings.Join(s.Skips, ", "), status,
+		})
+		standingListing.compact = append(standingListing.compact, fmt.Sprintf("%s %s %s at %s, next %s (%s)", s.ID.Hex(), s.CustomerName,
+			strings.Join(s.Days, ","), s.At, next, status))
+	}
+	return printListing(os.Stdout, standingListing)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// stockCheckInterval is how often the background job looks for stock running low
+const stockCheckInterval = 15 * time.Minute
+
+// stockVelocityDays is how many days of sales the rate stock is used up at is worked out from
+const stockVelocityDays = 7
+
+// StockLevel is how much of an ingredient, or of a menu item bought in ready to serve, was on hand when last
+// counted, the batches it was in and those received since, and the level at which more should be ordered.
+// Sales since the count are taken off through the menu's recipes.
+type StockLevel struct {
+	Branch    string       `bson:"branch,omitempty" json:"branch,omitempty"`
+	Name      string       `bson:"name" json:"name"`
+	Unit      string       `bson:"unit" json:"unit"`       // The unit the recipes use, or piece for a menu item
+	Counted   float64      `bson:"counted" json:"counted"` // On hand at CountedAt, by a count or after expired stock was written off
+	CountedAt time.Time    `bson:"countedAt" json:"countedAt"`
+	Batches   []StockBatch `bson:"batches,omitempty" json:"batches,omitempty"`     // What was counted, then what was received since, in the order it came in
+	ReorderAt float64      `bson:"reorderAt" json:"reorderAt"`                     // 0 sends no alerts
+	AlertedAt *time.Time   `bson:"alertedAt,omitempty" json:"alertedAt,omitempty"` // When staff were told it is low; cleared once it is not
+	Version   int          `bson:"version" json:"version"`                         // Bumped by every change, to catch conflicting edits
+}
+
+// StockStatus is where a stock level stands now
+type StockStatus struct {
+	StockLevel
+	Left       []StockBatch `json:"left"`                 // What is left of each batch, expired ones included
+	OnHand     float64      `json:"onHand"`               // What is left that has not expired
+	Expired    float64      `json:"expired"`              // What is left that has expired and is still to be written off
+	DailyUsage float64      `json:"dailyUsage"`           // Average used a day over the last week
+	StockOutAt *time.Time   `json:"stockOutAt,omitempty"` // When it runs out at that rate; nil when it is not being used
+	Low        bool         `json:"low"`                  // At or below the reorder level
+}
+
+// StockChange is a stock count or a new reorder level; what is left out stays as it was
+type StockChange struct {
+	Unit      string   `json:"unit"`
+	Counted   *float64 `json:"counted"`
+	ExpiresOn string   `json:"expiresOn"` // Use-by day (YYYY-MM-DD) of what was counted, if it perishes
+	UnitCost  *float64 `json:"unitCost"`  // What one unit of what was counted cost; the last cost paid when nil
+	ReorderAt *float64 `json:"reorderAt"`
+}
+
+// SetStock records a stock count or the reorder level of an ingredient or menu item. A count replaces the batches
+// with a single one of what was counted. The first time, the unit and count are required.
+func SetStock(ctx context.Context, name string, change StockChange) (StockLevel, error) {
+	name, unit := strings.ToLower(strings.TrimSpace(name)), strings.ToLower(strings.TrimSpace(change.Unit))
+	if name == "" {
+		return StockLevel{}, fmt.Errorf("a stock name is required")
+	}
+	level, err := storeFor(ctx).Stock().Find(ctx, currentBranch, name)
+	if errors.Is(err, ErrNotFound) {
+		if unit == "" || change.Counted == nil {
+			return StockLevel{}, fmt.Errorf("%s is not stocked yet: give its unit and count", name)
+		}
+		level, err = StockLevel{Branch: currentBranch, Name: name}, nil
+	}
+	if err != nil {
+		return StockLevel{}, err
+	}
+	if unit != "" {
+		level.Unit = unit
+	}
+	if change.Counted != nil {
+		batch, err := newStockBatch(*change.Counted, change.UnitCost, change.ExpiresOn, level, clock.Now())
+		if err != nil {
+			return StockLevel{}, err
+		}
+		level.Counted, level.CountedAt, level.Batches = batch.Quantity, batch.ReceivedAt, []StockBatch{batch}
+	}
+	if change.ReorderAt != nil {
+		if *change.ReorderAt < 0 {
+			return StockLevel{}, fmt.Errorf("the reorder level must not be negative")
+		}
+		level.ReorderAt = *change.ReorderAt
+	}
+	if err := storeFor(ctx).Stock().Save(ctx, level); err != nil {
+		return StockLevel{}, err
+	}
+	level.Version++
+	return level, nil
+}
+
+// stockKey is what usage is tallied by, so an ingredient counted in one unit is not taken off in another
+func stockKey(name, unit string) string {
+	return name + "\x00" + unit
+}
+
+// stockUse is some stock used by an order
+type stockUse struct {
+	at       time.Time
+	quantity float64
+}
+
+// stockUses lists what the orders used, in the order they were placed: each recipe's ingredients, or the item
+// itself, by the piece, when it has no recipe. Lines voided after they went to the kitchen were used all the same.
+func stockUses(menu []MenuItem, orders []Order) map[string][]stockUse {
+	uses := map[string][]stockUse{}
+	for _, order := range orders {
+		for _, line := range slices.Concat(order.Items, order.Wasted) {
+			item, found := FindMenuItem(menu, line.Name)
+			if !found || len(item.Recipe) == 0 {
+				key := stockKey(strings.ToLower(line.Name), "piece")
+				uses[key] = append(uses[key], stockUse{order.CreatedAt, float64(line.Quantity)})
+				continue
+			}
+			for _, ingredient := range item.Recipe {
+				key := stockKey(ingredient.Ingredient, ingredient.Unit)
+				uses[key] = append(uses[key], stockUse{order.CreatedAt, ingredient.Quantity * float64(line.Quantity)})
+			}
+		}
+	}
+	return uses
+}
+
+// StockStatuses works out what is on hand of everything stocked at this branch, how fast it is
+// being used and when it runs out
+func StockStatuses(ctx context.Context, now time.Time) ([]StockStatus, error) {
+	levels, err := storeFor(ctx).Stock().List(ctx, currentBranch)
+	if err != nil || len(levels) == 0 {
+		return []StockStatus{}, err
+	}
+	since := now.AddDate(0, 0, -stockVelocityDays)
+	for _, level := range levels {
+		since = earliest(since, level.CountedAt)
+	}
+	orders, err := LoadOrdersBetween(ctx, since, now)
+	if err != nil {
+		return nil, err
+	}
+	// Only this branch's sales use up its stock
+	orders = slices.DeleteFunc(orders, func(order Order) bool { return order.Branch != currentBranch })
+	uses := stockUses(LoadMenu(ctx), orders)
+
+	weekAgo := now.AddDate(0, 0, -stockVelocityDays)
+	statuses := []StockStatus{}
+	for _, level := range levels {
+		status := StockStatus{StockLevel: level, Left: consumeBatches(level, uses[stockKey(level.Name, level.Unit)], now)}
+		for _, batch := range status.Left {
+			if batchExpired(batch, now) {
+				status.Expired += batch.Quantity
+			} else {
+				status.OnHand += batch.Quantity
+			}
+		}
+		status.OnHand, status.Expired = roundPaise(status.OnHand), roundPaise(status.Expired)
+		var recent float64
+		for _, use := range uses[stockKey(level.Name, level.Unit)] {
+			if !use.at.Before(weekAgo) {
+				recent += use.quantity
+			}
+		}
+		status.DailyUsage = roundPaise(recent / stockVelocityDays)
+		status.Low = level.ReorderAt > 0 && status.OnHand <= level.ReorderAt
+		if status.DailyUsage > 0 {
+			runsOut := now.Add(time.Duration(status.OnHand / status.DailyUsage * float64(24*time.Hour)))
+			status.StockOutAt = &runsOut
+		}
+		// Stock that all perishes runs out when the last of it expires, if that comes first
+		if expiresAt, perishes := stockExpiresAt(status.Left, now); perishes && (status.StockOutAt == nil || expiresAt.Before(*status.StockOutAt)) {
+			status.StockOutAt = &expiresAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// StockAlerts returns the stock at or below its reorder level, whatever runs out first at the top
+func StockAlerts(ctx context.Context, now time.Time) ([]StockStatus, error) {
+	statuses, err := StockStatuses(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	alerts := slices.DeleteFunc(statuses, func(status StockStatus) bool { return !status.Low })
+	slices.SortStableFunc(alerts, func(a, b StockStatus) int {
+		switch {
+		case a.StockOutAt == nil && b.StockOutAt == nil:
+			return 0
+		case a.StockOutAt == nil:
+			return 1
+		case b.StockOutAt == nil:
+			return -1
+		}
+		return a.StockOutAt.Compare(*b.StockOutAt)
+	})
+	return alerts, nil
+}
+
+// stockAlertTo is the phone number low stock alerts are sent to, from RMS_STOCK_ALERT_TO
+var stockAlertTo string
+
+// CheckStock writes off expired stock and warns about stock about to expire, then sends an alert for each stock
+// level that has fallen to its reorder level since the last check, and re-arms the alert of each that was
+// restocked. It returns the levels alerted on as low.
+func CheckStock(ctx context.Context, now time.Time) ([]StockStatus, error) {
+	statuses, err := StockStatuses(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := settleExpiry(ctx, statuses, now); err != nil {
+		return nil, err
+	}
+	var alerted []StockStatus
+	for _, status := range statuses {
+		level := status.StockLevel
+		switch {
+		case status.Low && level.AlertedAt == nil:
+			level.AlertedAt = &now
+			alerted = append(alerted, status)
+		case !status.Low && level.AlertedAt != nil:
+			level.AlertedAt = nil
+		default:
+			continue
+		}
+		if err := storeFor(ctx).Stock().SetAlerted(ctx, level.Branch, level.Name, level.AlertedAt); err != nil {
+			return alerted, err
+		}
+		if level.AlertedAt != nil {
+			Notify(ctx, Notification{
+				To:      stockAlertTo,
+				Name:    "Kitchen",
+				Subject: fmt.Sprintf("Low stock: %s", level.Name),
+				Message: fmt.Sprintf("%s is down to %g %s (reorder at %g); %s.", level.Name, status.OnHand, level.Unit,
+					level.ReorderAt, stockOutText(status.StockOutAt)),
+			})
+		}
+	}
+	return alerted, nil
+}
+
+// stockOutText describes when stock runs out, e.g. "runs out around Tue 14:00"
+func stockOutText(at *time.Time) string {
+	if at == nil {
+		return "not used lately"
+	}
+	return "runs out around " + at.Format("Mon 15:04")
+}
+
+// StartStockAlerts checks stock levels in the background
+func StartStockAlerts() {
+	go func() {
+		for {
+			err := forEachTenant(context.TODO(), func(ctx context.Context) error {
+				if IsOffline() {
+					return nil
+				}
+				_, err := CheckStock(ctx, clock.Now())
+				return err
+			})
+			if err != nil {
+				log.Println("Stock alerts:", err)
+			}
+			time.Sleep(stockCheckInterval)
+		}
+	}()
+}
+
+// ShowStock prints the stock levels, or only those at or below their reorder level
+func ShowStock(ctx context.Context, alertsOnly bool) error {
+	statuses, err := StockStatuses(ctx, clock.Now())
+	title := "Stock:"
+	if alertsOnly {
+		statuses, err = StockAlerts(ctx, clock.Now())
+		title = "Stock at or below its reorder level:"
+	}
+	if err != nil {
+		return err
+	}
+	stockListing := listing{
+		title:   title,
+		header:  []string{"Name", "On hand", "Reorder at", "Used a day", "Runs out", ""},
+		records: statuses,
+	}
+	for _, status := range statuses {
+		runsOut, low := "", ""
+		if status.StockOutAt != nil {
+			runsOut = status.StockOutAt.Format("Mon 02 Jan 15:04")
+		}
+		if status.Low {
+			low = "LOW"
+		}
+		onHand := fmt.Sprintf("%g %s", status.OnHand, status.Unit)
+		if status.Expired > 0 {
+			onHand += fmt.Sprintf(" (%g expired)", status.Expired)
+		}
+		stockListing.rows = append(stockListing.rows, []string{
+			status.Name, onHand, fmt.Sprintf("%g", status.ReorderAt),
+			fmt.Sprintf("%g", status.DailyUsage), runsOut, low,
+		})
+		stockListing.compact = append(stockListing.compact, strings.TrimSpace(fmt.Sprintf("%s %g %s, %s %s",
+			status.Name, status.OnHand, status.Unit, stockOutText(status.StockOutAt), low)))
+	}
+	return printListing(os.Stdout, stockListing)
+}
@@ -0,0 +1,636 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Errors shared by every storage backend
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrDuplicate = errors.New("already exists")
+	// ErrVersionConflict means the record was changed since the version the caller read
+	ErrVersionConflict = errors.New("was changed by someone else, reload and try again")
+)
+
+// Store is the persistence layer. MongoDB, SQLite and Postgres each implement it, and the rest of
+// the application only talks to the repositories it hands out.
+type Store interface {
+	Menu() MenuRepository
+	MenuVersions() MenuVersionRepository
+	Customers() CustomerRepository
+	Orders() OrderRepository
+	Tables() TableRepository
+	Payments() PaymentRepository
+	Counters() CounterRepository
+	SyncConflicts() SyncConflictRepository
+	Events() EventRepository
+	Tenants() TenantRepository
+	APIKeys() APIKeyRepository
+	Invoices() InvoiceRepository
+	Drawers() DrawerRepository
+	Shifts() ShiftRepository
+	TimeClock() TimeClockRepository
+	Reservations() ReservationRepository
+	Banquets() BanquetRepository
+	Quotes() QuoteRepository
+	CreditAccounts() CreditAccountRepository
+	Companies() CompanyRepository
+	Idempotency() IdempotencyRepository
+	Coupons() CouponRepository
+	Sittings() SittingRepository
+	Stock() StockRepository
+	Transfers() TransferRepository
+	BranchMenus() BranchMenuRepository
+	Refunds() RefundRepository
+	TwoFactor() TwoFactorRepository
+	Sessions() SessionRepository
+	Devices() DeviceRepository
+	ParkedCarts() ParkedCartRepository
+	StandingOrders() StandingOrderRepository
+	Containers() ContainerRepository
+	DeliveryZones() DeliveryZoneRepository
+	Drivers() DriverRepository
+	Complaints() ComplaintRepository
+	Surveys() SurveyRepository
+	NotificationQueue() NotificationQueueRepository
+	Templates() TemplateRepository
+	PrintJobs() PrintJobRepository
+	FXExchanges() FXExchangeRepository
+	LogBook() LogBookRepository
+	Budgets() BudgetRepository
+	Changes() ChangeRepository
+
+	// Ping checks that the database is reachable
+	Ping(ctx context.Context) error
+	// Migrate brings the schema (tables, indexes) up to date; it is safe to run on every start
+	Migrate(ctx context.Context) error
+	// Unavailable reports whether err means the database could not be reached, as opposed to rejecting the request
+	Unavailable(err error) bool
+	Close(ctx context.Context) error
+}
+
+// MenuRepository stores the items on the menu
+type MenuRepository interface {
+	// List returns the menu in the order items were added
+	List(ctx context.Context) ([]MenuItem, error)
+	// Add stores the item at version 1, returning ErrDuplicate if an item with the same name exists
+	Add(ctx context.Context, item MenuItem) error
+	// Update replaces the stored item with the same name, moving it to the next version. It returns
+	// ErrVersionConflict if the stored item is no longer at item.Version.
+	Update(ctx context.Context, item MenuItem) error
+	Delete(ctx context.Context, name string) error
+	LogSoldOut(ctx context.Context, record SoldOut) error
+	// ListSoldOut returns the items 86'd in [from, to), oldest first
+	ListSoldOut(ctx context.Context, from, to time.Time) ([]SoldOut, error)
+	LogPriceChange(ctx context.Context, change PriceChange) error
+	// ListPriceChanges returns the item's price changes, oldest first
+	ListPriceChanges(ctx context.Context, item string) ([]PriceChange, error)
+}
+
+// MenuVersionRepository stores the draft menu and every published version of the menu
+type MenuVersionRepository interface {
+	// LoadDraft returns ErrNotFound when nobody is editing a draft
+	LoadDraft(ctx context.Context) ([]MenuItem, error)
+	SaveDraft(ctx context.Context, items []MenuItem) error
+	DeleteDraft(ctx context.Context) error
+	// Publish stores the version and makes its items the live menu in one step, so no one sees a
+	// half-updated menu. It returns ErrDuplicate if the version number is already taken.
+	Publish(ctx context.Context, version MenuVersion) error
+	Find(ctx context.Context, version int) (MenuVersion, error)
+	// List returns every version, newest first
+	List(ctx context.Context) ([]MenuVersion, error)
+	// Latest returns the newest version number, or 0 if no menu has been published
+	Latest(ctx context.Context) (int, error)
+}
+
+// CustomerRepository stores customers, who are looked up by name
+type CustomerRepository interface {
+	// Add stores a new customer at version 1; every change after that moves it to the next version
+	Add(ctx context.Context, customer Customer) error
+	FindByName(ctx context.Context, name string) (Customer, error)
+	// FindByPhone returns the first customer added with the phone number, or ErrNotFound
+	FindByPhone(ctx context.Context, phone string) (Customer, error)
+	List(ctx context.Context) ([]Customer, error)
+	// Search matches the query against name and phone, ignoring case
+	Search(ctx context.Context, query string, limit int) ([]Customer, error)
+	// AppendOrderedItems adds items to the customer's ordered items, creating the customer if needed
+	AppendOrderedItems(ctx context.Context, name string, items []string) error
+	SetTotal(ctx context.Context, name string, total float64) error
+	// SetPhone changes the customer's phone number, returning ErrNotFound if there is no such customer
+	SetPhone(ctx context.Context, name, phone string) error
+	// SetDietary replaces the customer's allergies and diets, creating the customer if needed. With a
+	// version other than 0 the customer must exist and still be at that version, or it returns
+	// ErrNotFound or ErrVersionConflict.
+	SetDietary(ctx context.Context, name string, version int, allergies, diets []string) error
+	// SetOccasions replaces the customer's birthday and anniversary like SetDietary does their allergies
+	SetOccasions(ctx context.Context, name string, version int, birthday, anniversary string) error
+	// SetFlag replaces the customer's flag, or clears it when flag is nil, like SetDietary
+	SetFlag(ctx context.Context, name string, version int, flag *CustomerFlag) error
+	// SetAddresses replaces the customer's address book like SetDietary does their allergies
+	SetAddresses(ctx context.Context, name string, version int, addresses []SavedAddress) error
+	// SetCommunication replaces what the customer agreed to be sent like SetDietary does their allergies
+	SetCommunication(ctx context.Context, name string, version int, prefs *CommunicationPrefs) error
+	// SetLegacy replaces the customer's history from the old POS like SetDietary does their allergies
+	SetLegacy(ctx context.Context, name string, version int, legacy *LegacyHistory) error
+}
+
+// OrderRepository stores the current state of each order, as projected from its events
+type OrderRepository interface {
+	// Save stores the order unless a copy with the same or a later version is already stored
+	Save(ctx context.Context, order Order) error
+	Find(ctx context.Context, id primitive.ObjectID) (Order, error)
+	// ListOpen returns orders that have not been served, oldest first
+	ListOpen(ctx context.Context) ([]Order, error)
+	// ListBetween returns orders created in [from, to), oldest first
+	ListBetween(ctx context.Context, from, to time.Time) ([]Order, error)
+	// ListTakeaway returns takeaway orders created since the given time in one of the statuses, by token
+	ListTakeaway(ctx context.Context, since time.Time, statuses []string) ([]Order, error)
+	// TokenInUse reports whether an order created in [from, to) has the token
+	TokenInUse(ctx context.Context, from, to time.Time, token int) (bool, error)
+	// Archive moves up to limit orders placed before the given time that were served and paid, with their events,
+	// to the archive, returning how many it moved. Find, ListBetween and the events' ListForOrder also read the
+	// archive; the other lists only cover live orders.
+	Archive(ctx context.Context, before time.Time, limit int) (int, error)
+	// ListUpdatedAfter returns up to limit orders last changed after the given change and before until, by when
+	// they changed and then ID. A change is the time it happened and the order's ID, which breaks ties.
+	ListUpdatedAfter(ctx context.Context, after time.Time, afterID primitive.ObjectID, until time.Time, limit int) ([]Order, error)
+}
+
+// TableRepository stores the dining tables
+type TableRepository interface {
+	// Seed adds the tables that do not exist yet, leaving existing ones untouched
+	Seed(ctx context.Context, tables []Table) error
+	List(ctx context.Context) ([]Table, error)
+	SetOccupied(ctx context.Context, number int, occupied bool) error
+}
+
+// PaymentRepository stores payments taken against orders
+type PaymentRepository interface {
+	// Insert returns ErrDuplicate if a payment with the same ID exists
+	Insert(ctx context.Context, payment Payment) error
+	Find(ctx context.Context, id primitive.ObjectID) (Payment, error)
+	// ListBetween returns payments taken in [from, to), oldest first
+	ListBetween(ctx context.Context, from, to time.Time) ([]Payment, error)
+	// ListAfter returns up to limit payments taken after the given payment and before until, oldest first, like
+	// OrderRepository.ListUpdatedAfter
+	ListAfter(ctx context.Context, after time.Time, afterID primitive.ObjectID, until time.Time, limit int) ([]Payment, error)
+}
+
+// CounterRepository hands out sequence numbers, such as takeaway tokens
+type CounterRepository interface {
+	// Next atomically increments the named counter, starting from 1, and returns the new value
+	Next(ctx context.Context, name string) (int, error)
+}
+
+// SyncConflictRepository stores conflicts found while replaying offline writes
+type SyncConflictRepository interface {
+	Insert(ctx context.Context, conflict SyncConflict) error
+}
+
+// EventRepository is the append-only log of order events
+type EventRepository interface {
+	// Append returns ErrDuplicate if the order already has an event with the same Seq
+	Append(ctx context.Context, event OrderEvent) error
+	// ListForOrder returns the order's events in sequence, including those archived with it
+	ListForOrder(ctx context.Context, orderID primitive.ObjectID) ([]OrderEvent, error)
+	// ListSince returns events of live orders recorded at or after the given time, oldest first
+	ListSince(ctx context.Context, since time.Time) ([]OrderEvent, error)
+	// ListUnpublished returns up to limit events not yet sent to the message broker, oldest first
+	ListUnpublished(ctx context.Context, limit int) ([]OrderEvent, error)
+	MarkPublished(ctx context.Context, id primitive.ObjectID, at time.Time) error
+}
+
+// TenantRepository stores the tenants of the hosted service
+type TenantRepository interface {
+	// Create returns ErrDuplicate if the ID is taken
+	Create(ctx context.Context, tenant Tenant) error
+	Find(ctx context.Context, id string) (Tenant, error)
+	List(ctx context.Context) ([]Tenant, error)
+}
+
+// APIKeyRepository stores API keys, looked up by the hash of the key, and a log of their use
+type APIKeyRepository interface {
+	Create(ctx context.Context, key APIKey) error
+	Find(ctx context.Context, id primitive.ObjectID) (APIKey, error)
+	FindByHash(ctx context.Context, hash string) (APIKey, error)
+	// ListByTenant returns the tenant's keys, oldest first
+	ListByTenant(ctx context.Context, tenantID string) ([]APIKey, error)
+	Update(ctx context.Context, key APIKey) error
+	LogUsage(ctx context.Context, usage APIKeyUsage) error
+	// ListUsage returns up to limit of the key's most recent requests, newest first
+	ListUsage(ctx context.Context, keyID primitive.ObjectID, limit int) ([]APIKeyUsage, error)
+}
+
+// InvoiceRepository stores tax invoices, numbered per financial year
+type InvoiceRepository interface {
+	// Insert returns ErrDuplicate if the number is taken or the order already has an invoice
+	Insert(ctx context.Context, invoice Invoice) error
+	Find(ctx context.Context, number string) (Invoice, error)
+	FindByOrder(ctx context.Context, orderID primitive.ObjectID) (Invoice, error)
+	// LastSeq returns the highest number issued in the financial year, or 0 if there is none
+	LastSeq(ctx context.Context, financialYear string) (int, error)
+	// ListBetween returns invoices issued in [from, to), oldest first
+	ListBetween(ctx context.Context, from, to time.Time) ([]Invoice, error)
+}
+
+// DrawerRepository stores cash drawer sessions; at most one is open at a time
+type DrawerRepository interface {
+	// Open returns ErrDuplicate if a session is already open
+	Open(ctx context.Context, session DrawerSession) error
+	// FindOpen returns ErrNotFound when the drawer is closed
+	FindOpen(ctx context.Context) (DrawerSession, error)
+	// AddMovement returns ErrNotFound unless the session is open
+	AddMovement(ctx context.Context, id primitive.ObjectID, movement CashMovement) error
+	// Close stores the closed session, returning ErrNotFound unless it was still open
+	Close(ctx context.Context, session DrawerSession) error
+	// ListBetween returns sessions opened in [from, to), oldest first
+	ListBetween(ctx context.Context, from, to time.Time) ([]DrawerSession, error)
+}
+
+// ShiftRepository stores cashier shifts; each cashier has at most one open at a time
+type ShiftRepository interface {
+	// Open returns ErrDuplicate if the cashier already has an open shift
+	Open(ctx context.Context, shift Shift) error
+	Find(ctx context.Context, id primitive.ObjectID) (Shift, error)
+	// FindOpen returns ErrNotFound when the cashier has no open shift
+	FindOpen(ctx context.Context, cashier string) (Shift, error)
+	// Close stores the closed shift, returning ErrNotFound unless it was still open
+	Close(ctx context.Context, shift Shift) error
+	// ListBetween returns shifts opened in [from, to), oldest first
+	ListBetween(ctx context.Context, from, to time.Time) ([]Shift, error)
+}
+
+// SittingRepository stores when parties were seated at and left each table; each table has at most one open sitting
+type SittingRepository interface {
+	// Open returns ErrDuplicate if the table already has an open sitting
+	Open(ctx context.Context, sitting TableSitting) error
+	// FindOpen returns ErrNotFound when nobody is seated at the table
+	FindOpen(ctx context.Context, table int) (TableSitting, error)
+	// Close stores the closed sitting, returning ErrNotFound unless it was still open
+	Close(ctx context.Context, sitting TableSitting) error
+	// ListBetween returns sittings that started in [from, to), oldest first
+	ListBetween(ctx context.Context, from, to time.Time) ([]TableSitting, error)
+}
+
+// StockRepository stores the stock counted of each ingredient and menu item, by branch and name, and the stock
+// written off as expired
+type StockRepository interface {
+	// Save stores the level, moving it to the next version. It returns ErrVersionConflict if the stored level is
+	// no longer at level.Version, where 0 means a level not stored yet.
+	Save(ctx context.Context, level StockLevel) error
+	Find(ctx context.Context, branch, name string) (StockLevel, error)
+	// List returns every level of the branch by name
+	List(ctx context.Context, branch string) ([]StockLevel, error)
+	// SetAlerted changes only when staff were last alerted about the level, so a count taken meanwhile is kept.
+	// It returns ErrNotFound if nothing by that name is stocked at the branch.
+	SetAlerted(ctx context.Context, branch, name string, at *time.Time) error
+	AddWriteOff(ctx context.Context, writeOff StockWriteOff) error
+	// ListWriteOffs returns the branch's write-offs made in [from, to), oldest first
+	ListWriteOffs(ctx context.Context, branch string, from, to time.Time) ([]StockWriteOff, error)
+}
+
+// TransferRepository stores the stock sent between branches
+type TransferRepository interface {
+	Insert(ctx context.Context, transfer StockTransfer) error
+	Find(ctx context.Context, id primitive.ObjectID) (StockTransfer, error)
+	// Update stores the transfer, moving it to the next version. It returns ErrVersionConflict if the stored
+	// transfer is no longer at transfer.Version.
+	Update(ctx context.Context, transfer StockTransfer) error
+	// ListForBranch returns the transfers to and from the branch, newest first
+	ListForBranch(ctx context.Context, branch string) ([]StockTransfer, error)
+}
+
+// BranchMenuRepository stores each branch's menu overrides and synced menu, by branch
+type BranchMenuRepository interface {
+	Find(ctx context.Context, branch string) (BranchMenu, error)
+	// Save stores the menu, moving it to the next version. It returns ErrVersionConflict if the stored menu is
+	// no longer at menu.Version, where 0 means a menu not stored yet.
+	Save(ctx context.Context, menu BranchMenu) error
+}
+
+// RefundRepository stores money handed back for orders
+type RefundRepository interface {
+	Add(ctx context.Context, refund Refund) error
+	ListForOrder(ctx context.Context, orderID primitive.ObjectID) ([]Refund, error)
+	// ListBetween returns refunds given in [from, to), oldest first
+	ListBetween(ctx context.Context, from, to time.Time) ([]Refund, error)
+}
+
+// TwoFactorRepository stores admin keys' authenticator enrollments, by key
+type TwoFactorRepository interface {
+	Find(ctx context.Context, keyID primitive.ObjectID) (TwoFactor, error)
+	// Save stores the enrollment, moving it to the next version. It returns ErrVersionConflict if the stored one
+	// is no longer at twoFactor.Version, where 0 means one not stored yet.
+	Save(ctx context.Context, twoFactor TwoFactor) error
+	// Delete removes the key's enrollment; removing one that does not exist is not an error
+	Delete(ctx context.Context, keyID primitive.ObjectID) error
+}
+
+// SessionRepository stores keys' sessions at terminals
+type SessionRepository interface {
+	Insert(ctx context.Context, session Session) error
+	Find(ctx context.Context, id primitive.ObjectID) (Session, error)
+	FindByHash(ctx context.Context, hash string) (Session, error)
+	// ListForKey returns the key's sessions, revoked and expired ones too, newest first
+	ListForKey(ctx context.Context, keyID primitive.ObjectID) ([]Session, error)
+	// Update stores the session's last use or revocation, returning ErrNotFound if it does not exist
+	Update(ctx context.Context, session Session) error
+}
+
+// DeviceRepository stores the POS terminals and kiosks registered to call the API
+type DeviceRepository interface {
+	// Create returns ErrDuplicate if the tenant already has a device with the name
+	Create(ctx context.Context, device Device) error
+	Find(ctx context.Context, id primitive.ObjectID) (Device, error)
+	FindByHash(ctx context.Context, hash string) (Device, error)
+	// ListByTenant returns the tenant's devices, oldest first
+	ListByTenant(ctx context.Context, tenantID string) ([]Device, error)
+	Update(ctx context.Context, device Device) error
+}
+
+// ParkedCartRepository stores orders put aside while they are being taken
+type ParkedCartRepository interface {
+	// Park stores the cart, first forgetting every cart that expired before it was parked. It returns ErrDuplicate
+	// if the cart's table already has one.
+	Park(ctx context.Context, cart ParkedCart) error
+	// List returns the carts not expired at now, oldest first
+	List(ctx context.Context, now time.Time) ([]ParkedCart, error)
+	// Take removes the cart and returns it, or ErrNotFound if it was taken already
+	Take(ctx context.Context, id primitive.ObjectID) (ParkedCart, error)
+}
+
+// StandingOrderRepository stores orders placed again on a schedule
+type StandingOrderRepository interface {
+	Insert(ctx context.Context, standing StandingOrder) error
+	Find(ctx context.Context, id primitive.ObjectID) (StandingOrder, error)
+	// List returns every standing order, oldest first
+	List(ctx context.Context) ([]StandingOrder, error)
+	// Update stores the standing order, moving it to the next version. It returns ErrVersionConflict if the stored
+	// one is no longer at standing.Version.
+	Update(ctx context.Context, standing StandingOrder) error
+}
+
+// ContainerRepository stores the reusable containers lent out with orders, by order
+type ContainerRepository interface {
+	// Insert stores the loan, returning ErrDuplicate if the order already has one
+	Insert(ctx context.Context, loan ContainerLoan) error
+	Find(ctx context.Context, orderID primitive.ObjectID) (ContainerLoan, error)
+	// Update stores the loan, moving it to the next version. It returns ErrVersionConflict if the stored one is no
+	// longer at loan.Version.
+	Update(ctx context.Context, loan ContainerLoan) error
+	// ListOutstanding returns the loans with containers not yet returned, oldest first
+	ListOutstanding(ctx context.Context) ([]ContainerLoan, error)
+}
+
+// DeliveryZoneRepository stores the areas a restaurant delivers to, by name
+type DeliveryZoneRepository interface {
+	// Save adds the zone or replaces the one with its name
+	Save(ctx context.Context, zone DeliveryZone) error
+	// List returns the zones by name
+	List(ctx context.Context) ([]DeliveryZone, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// DriverRepository stores the cash on delivery drivers collect and their settlements handing it in
+type DriverRepository interface {
+	// AddCollection returns ErrDuplicate if the payment was already collected
+	AddCollection(ctx context.Context, collection CODCollection) error
+	// ListUnsettled returns the collections no settlement has taken, oldest first
+	ListUnsettled(ctx context.Context) ([]CODCollection, error)
+	// Claim ties the driver's unsettled collections taken up to upTo to the settlement and returns them. A collection
+	// is only ever claimed once.
+	Claim(ctx context.Context, driver string, settlementID primitive.ObjectID, upTo time.Time) ([]CODCollection, error)
+	// Release puts back the collections claimed by a settlement that was not stored
+	Release(ctx context.Context, settlementID primitive.ObjectID) error
+	AddSettlement(ctx context.Context, settlement DriverSettlement) error
+	// ListSettlements returns settlements made in [from, to), oldest first
+	ListSettlements(ctx context.Context, from, to time.Time) ([]DriverSettlement, error)
+}
+
+// ComplaintRepository stores customers' complaints about orders
+type ComplaintRepository interface {
+	Insert(ctx context.Context, complaint Complaint) error
+	Find(ctx context.Context, id primitive.ObjectID) (Complaint, error)
+	// Update stores the complaint, moving it to the next version. It returns ErrVersionConflict if the stored one is
+	// no longer at complaint.Version.
+	Update(ctx context.Context, complaint Complaint) error
+	// ListBetween returns complaints raised in [from, to), oldest first
+	ListBetween(ctx context.Context, from, to time.Time) ([]Complaint, error)
+}
+
+// SurveyRepository stores the surveys sent to customers after their bill, one per order
+type SurveyRepository interface {
+	// Insert returns ErrDuplicate if the order was already surveyed
+	Insert(ctx context.Context, survey Survey) error
+	FindByToken(ctx context.Context, token string) (Survey, error)
+	FindByOrder(ctx context.Context, id primitive.ObjectID) (Survey, error)
+	// Answer stores the survey's answer, returning ErrNotFound unless it was still unanswered
+	Answer(ctx context.Context, survey Survey) error
+	// ListBetween returns surveys sent in [from, to), oldest first
+	ListBetween(ctx context.Context, from, to time.Time) ([]Survey, error)
+}
+
+// CouponRepository stores the coupons sent to customers
+type CouponRepository interface {
+	// Add returns ErrDuplicate if the code is taken or the customer already has a coupon for the occasion that year
+	Add(ctx context.Context, coupon Coupon) error
+	FindByCode(ctx context.Context, code string) (Coupon, error)
+}
+
+// NotificationQueueRepository stores the notifications held back until they may be sent
+type NotificationQueueRepository interface {
+	Add(ctx context.Context, queued QueuedNotification) error
+	// ListDue returns the notifications to send at or before now, oldest first
+	ListDue(ctx context.Context, now time.Time) ([]QueuedNotification, error)
+	// Remove takes the notification off the queue, returning ErrNotFound if it was already taken
+	Remove(ctx context.Context, id primitive.ObjectID) error
+}
+
+// TemplateRepository stores every version of the message templates
+type TemplateRepository interface {
+	// Insert returns ErrDuplicate if the template already has the version
+	Insert(ctx context.Context, tmpl MessageTemplate) error
+	// Latest returns the template's newest version, or ErrNotFound if it was never saved
+	Latest(ctx context.Context, name string) (MessageTemplate, error)
+	Find(ctx context.Context, name string, version int) (MessageTemplate, error)
+	// List returns the template's versions, newest first
+	List(ctx context.Context, name string) ([]MessageTemplate, error)
+}
+
+// PrintJobRepository stores the tickets sent to the kitchen printers
+type PrintJobRepository interface {
+	Add(ctx context.Context, job PrintJob) error
+	Find(ctx context.Context, id primitive.ObjectID) (PrintJob, error)
+	// Update stores the job if it is still at job.Version, returning ErrVersionConflict otherwise
+	Update(ctx context.Context, job PrintJob) error
+	// ListDue returns the queued jobs whose next attempt is at or before now, oldest first
+	ListDue(ctx context.Context, now time.Time) ([]PrintJob, error)
+	// ListSince returns the jobs queued at or after since, newest first
+	ListSince(ctx context.Context, since time.Time) ([]PrintJob, error)
+}
+
+// FXExchangeRepository stores the foreign cash changed into rupees
+type FXExchangeRepository interface {
+	Add(ctx context.Context, exchange FXExchange) error
+	// ListBetween returns the exchanges made in [from, to), oldest first
+	ListBetween(ctx context.Context, from, to time.Time) ([]FXExchange, error)
+}
+
+// LogBookRepository stores the managers' log book
+type LogBookRepository interface {
+	Insert(ctx context.Context, entry LogEntry) error
+	Find(ctx context.Context, id primitive.ObjectID) (LogEntry, error)
+	// Update stores the entry, moving it to the next version. It returns ErrVersionConflict if the stored one is no
+	// longer at entry.Version.
+	Update(ctx context.Context, entry LogEntry) error
+	// ListBetween returns the entries for the business dates in [from, to), YYYY-MM-DD, in the order they were written
+	ListBetween(ctx context.Context, from, to string) ([]LogEntry, error)
+	// ListOpen returns the follow-ups not yet done, oldest first
+	ListOpen(ctx context.Context) ([]LogEntry, error)
+}
+
+// BudgetRepository stores the monthly revenue and food cost targets, by month
+type BudgetRepository interface {
+	// Save adds the month's targets or replaces them
+	Save(ctx context.Context, budget Budget) error
+	// Find returns ErrNotFound if the month has no targets
+	Find(ctx context.Context, month string) (Budget, error)
+	// List returns every month's targets, latest first
+	List(ctx context.Context) ([]Budget, error)
+}
+
+// ChangeRepository follows orders and payments as they are stored, for the live feed
+type ChangeRepository interface {
+	// Watch sends each order or payment stored after the resume token to changes until ctx ends, when it returns
+	// nil, or it fails. An empty token starts from now; a token it cannot resume from is ErrResumeToken.
+	Watch(ctx context.Context, token string, changes chan<- Change) error
+}
+
+// TimeClockRepository stores employees' clock-in and clock-out times; each employee has at most one open entry
+type TimeClockRepository interface {
+	// ClockIn returns ErrDuplicate if the employee is already clocked in
+	ClockIn(ctx context.Context, entry TimeEntry) error
+	// FindOpen returns ErrNotFound when the employee is not clocked in
+	FindOpen(ctx context.Context, employee string) (TimeEntry, error)
+	// ClockOut stores the closed entry, returning ErrNotFound unless it was still open
+	ClockOut(ctx context.Context, entry TimeEntry) error
+	// ListBetween returns entries clocked in during [from, to), oldest first
+	ListBetween(ctx context.Context, from, to time.Time) ([]TimeEntry, error)
+}
+
+// ReservationRepository stores table reservations
+type ReservationRepository interface {
+	Insert(ctx context.Context, reservation Reservation) error
+	Find(ctx context.Context, id primitive.ObjectID) (Reservation, error)
+	// Update returns ErrNotFound if the reservation does not exist
+	Update(ctx context.Context, reservation Reservation) error
+	// ListBetween returns reservations for [from, to), earliest first
+	ListBetween(ctx context.Context, from, to time.Time) ([]Reservation, error)
+}
+
+// BanquetRepository stores banquet bookings
+type BanquetRepository interface {
+	Insert(ctx context.Context, banquet Banquet) error
+	Find(ctx context.Context, id primitive.ObjectID) (Banquet, error)
+	// Update returns ErrNotFound if the banquet does not exist
+	Update(ctx context.Context, banquet Banquet) error
+	// ListOverlapping returns banquets taking place at some point in [from, to), earliest first
+	ListOverlapping(ctx context.Context, from, to time.Time) ([]Banquet, error)
+}
+
+// QuoteRepository stores catering quotes
+type QuoteRepository interface {
+	Insert(ctx context.Context, quote Quote) error
+	Find(ctx context.Context, id primitive.ObjectID) (Quote, error)
+	FindByToken(ctx context.Context, token string) (Quote, error)
+	// Update returns ErrNotFound if the quote does not exist
+	Update(ctx context.Context, quote Quote) error
+	// ListBetween returns quotes created in [from, to), oldest first
+	ListBetween(ctx context.Context, from, to time.Time) ([]Quote, error)
+}
+
+// CreditAccountRepository stores customers' credit accounts and the ledger of entries posted to them
+type CreditAccountRepository interface {
+	// Insert returns ErrDuplicate if the customer already has an account
+	Insert(ctx context.Context, account CreditAccount) error
+	Find(ctx context.Context, id primitive.ObjectID) (CreditAccount, error)
+	// List returns every account by customer name
+	List(ctx context.Context) ([]CreditAccount, error)
+	// Update stores the account's details, leaving the balance as it is. It returns ErrNotFound if the account
+	// does not exist.
+	Update(ctx context.Context, account CreditAccount) error
+	// Post adds the entry's amount to the balance and appends the entry to the ledger in one step, returning
+	// the account as it now stands. A charge that would take the balance over the credit limit changes
+	// nothing and returns ErrCreditLimit.
+	Post(ctx context.Context, entry AccountEntry) (CreditAccount, error)
+	// ListEntries returns the entries posted to the account, oldest first
+	ListEntries(ctx context.Context, id primitive.ObjectID) ([]AccountEntry, error)
+}
+
+// CompanyRepository stores company accounts and their monthly invoices
+type CompanyRepository interface {
+	// Insert returns ErrDuplicate if a company with the same name exists
+	Insert(ctx context.Context, company Company) error
+	Find(ctx context.Context, id primitive.ObjectID) (Company, error)
+	// List returns every company by name
+	List(ctx context.Context) ([]Company, error)
+	// Update returns ErrNotFound if the company does not exist, or ErrDuplicate if another has its name
+	Update(ctx context.Context, company Company) error
+	// InsertInvoice returns ErrDuplicate if the number is taken or the company's month is already invoiced
+	InsertInvoice(ctx context.Context, invoice CompanyInvoice) error
+	FindInvoice(ctx context.Context, companyID primitive.ObjectID, month string) (CompanyInvoice, error)
+	// LastInvoiceSeq returns the highest number issued in the financial year, or 0 if there is none
+	LastInvoiceSeq(ctx context.Context, financialYear string) (int, error)
+	// ListInvoices returns the company's invoices, oldest month first
+	ListInvoices(ctx context.Context, companyID primitive.ObjectID) ([]CompanyInvoice, error)
+}
+
+// IdempotencyRepository remembers requests made with an Idempotency-Key and their responses
+type IdempotencyRepository interface {
+	// Begin claims the key for a request, first forgetting every record created before expiredBefore.
+	// It returns ErrDuplicate if the key is still claimed.
+	Begin(ctx context.Context, record IdempotencyRecord, expiredBefore time.Time) error
+	Find(ctx context.Context, key string) (IdempotencyRecord, error)
+	// Complete stores the response for a claimed key
+	Complete(ctx context.Context, record IdempotencyRecord) error
+	// Delete releases a key so the request can be tried again
+	Delete(ctx context.Context, key string) error
+}
+
+// Storage backends
+const (
+	BackendMongo    = "mongo"
+	BackendSQLite   = "sqlite"
+	BackendPostgres = "postgres"
+)
+
+// store is the persistence layer chosen at startup
+var store Store
+
+// OpenStore connects to the backend selected in the config. For MongoDB an unreachable
+// server is reported alongside a usable store, so the app can start in offline mode.
+func OpenStore(cfg Config) (Store, error) {
+	switch cfg.Backend {
+	case BackendMongo:
+		return OpenMongoStore(cfg.MongoURI, cfg.MongoDatabase)
+	case BackendSQLite:
+		return OpenSQLStore("sqlite", cfg.SQLitePath)
+	case BackendPostgres:
+		return OpenSQLStore("pgx", cfg.PostgresURL)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q (want %s, %s or %s)", cfg.Backend, BackendMongo, BackendSQLite, BackendPostgres)
+}
+
+// storeFor returns the store holding the restaurant data that calls made with ctx should use:
+// the tenant's own store in SaaS mode, otherwise the one store opened at startup
+func storeFor(ctx context.Context) Store {
+	if s, ok := tenantStore(ctx); ok {
+		return s
+	}
+	return store
+}
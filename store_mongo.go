@@ -0,0 +1,2017 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoStore keeps each kind of document in its own collection of one database
+type mongoStore struct {
+	client *mongo.Client
+	db     *mongo.Database
+}
+
+// OpenMongoStore connects to MongoDB. If the server does not answer a ping the store is still
+// returned together with the error, so it can be used to reconnect later.
+func OpenMongoStore(uri, database string) (Store, error) {
+	clientOptions := options.Client().ApplyURI(uri).SetServerSelectionTimeout(5 * time.Second)
+	client, err := mongo.Connect(context.TODO(), clientOptions)
+	if err != nil {
+		return nil, err
+	}
+	s := &mongoStore{client: client, db: client.Database(database)}
+
+	// Check the connection
+	if err := client.Ping(context.TODO(), nil); err != nil {
+		return s, err
+	}
+	notify("Connected to MongoDB!")
+	return s, nil
+}
+
+func (s *mongoStore) Menu() MenuRepository {
+	return mongoMenu{s.db.Collection("menu"), s.db.Collection("soldOut"), s.db.Collection("priceChanges")}
+}
+func (s *mongoStore) MenuVersions() MenuVersionRepository { return mongoMenuVersions{s.db} }
+func (s *mongoStore) Customers() CustomerRepository {
+	return mongoCustomers{s.db.Collection("customers")}
+}
+func (s *mongoStore) Orders() OrderRepository {
+	return mongoOrders{s.db.Collection("orders"), s.db.Collection("ordersArchive"), s.Events().(mongoEvents)}
+}
+func (s *mongoStore) Tables() TableRepository     { return mongoTables{s.db.Collection("tables")} }
+func (s *mongoStore) Payments() PaymentRepository { return mongoPayments{s.db.Collection("payments")} }
+func (s *mongoStore) Counters() CounterRepository { return mongoCounters{s.db.Collection("counters")} }
+func (s *mongoStore) Events() EventRepository {
+	return mongoEvents{s.db.Collection("orderEvents"), s.db.Collection("orderEventsArchive")}
+}
+func (s *mongoStore) Tenants() TenantRepository { return mongoTenants{s.db.Collection("tenants")} }
+func (s *mongoStore) APIKeys() APIKeyRepository {
+	return mongoAPIKeys{s.db.Collection("apiKeys"), s.db.Collection("apiKeyUsage")}
+}
+func (s *mongoStore) Invoices() InvoiceRepository { return mongoInvoices{s.db.Collection("invoices")} }
+func (s *mongoStore) Drawers() DrawerRepository {
+	return mongoDrawers{s.db.Collection("drawerSessions")}
+}
+func (s *mongoStore) Shifts() ShiftRepository { return mongoShifts{s.db.Collection("shifts")} }
+func (s *mongoStore) TimeClock() TimeClockRepository {
+	return mongoTimeClock{s.db.Collection("timeEntries")}
+}
+func (s *mongoStore) Reservations() ReservationRepository {
+	return mongoReservations{s.db.Collection("reservations")}
+}
+func (s *mongoStore) Banquets() BanquetRepository { return mongoBanquets{s.db.Collection("banquets")} }
+func (s *mongoStore) Quotes() QuoteRepository     { return mongoQuotes{s.db.Collection("quotes")} }
+func (s *mongoStore) Companies() CompanyRepository {
+	return mongoCompanies{s.db.Collection("companies"), s.db.Collection("companyInvoices")}
+}
+func (s *mongoStore) Idempotency() IdempotencyRepository {
+	return mongoIdempotency{s.db.Collection("idempotencyKeys")}
+}
+func (s *mongoStore) Coupons() CouponRepository { return mongoCoupons{s.db.Collection("coupons")} }
+func (s *mongoStore) Sittings() SittingRepository {
+	return mongoSittings{s.db.Collection("tableSittings")}
+}
+func (s *mongoStore) Stock() StockRepository {
+	return mongoStock{s.db.Collection("stockLevels"), s.db.Collection("stockWriteOffs")}
+}
+
+func (s *mongoStore) Transfers() TransferRepository {
+	return mongoTransfers{s.db.Collection("stockTransfers")}
+}
+
+func (s *mongoStore) BranchMenus() BranchMenuRepository {
+	return mongoBranchMenus{s.db.Collection("branchMenus")}
+}
+
+func (s *mongoStore) Refunds() RefundRepository {
+	return mongoRefunds{s.db.Collection("refunds")}
+}
+func (s *mongoStore) TwoFactor() TwoFactorRepository {
+	return mongoTwoFactor{s.db.Collection("twoFactor")}
+}
+func (s *mongoStore) Sessions() SessionRepository {
+	return mongoSessions{s.db.Collection("sessions")}
+}
+func (s *mongoStore) Devices() DeviceRepository {
+	return mongoDevices{s.db.Collection("devices")}
+}
+func (s *mongoStore) ParkedCarts() ParkedCartRepository {
+	return mongoParkedCarts{s.db.Collection("parkedCarts")}
+}
+func (s *mongoStore) StandingOrders() StandingOrderRepository {
+	return mongoStandingOrders{s.db.Collection("standingOrders")}
+}
+func (s *mongoStore) Containers() ContainerRepository {
+	return mongoContainers{s.db.Collection("containerLoans")}
+}
+func (s *mongoStore) DeliveryZones() DeliveryZoneRepository {
+	return mongoDeliveryZones{s.db.Collection("deliveryZones")}
+}
+func (s *mongoStore) Drivers() DriverRepository {
+	return mongoDrivers{collections: s.db.Collection("codCollections"), settlements: s.db.Collection("driverSettlements")}
+}
+func (s *mongoStore) Complaints() ComplaintRepository {
+	return mongoComplaints{s.db.Collection("complaints")}
+}
+func (s *mongoStore) Surveys() SurveyRepository {
+	return mongoSurveys{s.db.Collection("surveys")}
+}
+func (s *mongoStore) NotificationQueue() NotificationQueueRepository {
+	return mongoNotificationQueue{s.db.Collection("notificationQueue")}
+}
+func (s *mongoStore) Templates() TemplateRepository {
+	return mongoTemplates{s.db.Collection("messageTemplates")}
+}
+func (s *mongoStore) PrintJobs() PrintJobRepository {
+	return mongoPrintJobs{s.db.Collection("printJobs")}
+}
+func (s *mongoStore) FXExchanges() FXExchangeRepository {
+	return mongoFXExchanges{s.db.Collection("fxExchanges")}
+}
+func (s *mongoStore) LogBook() LogBookRepository {
+	return mongoLogBook{s.db.Collection("logBook")}
+}
+func (s *mongoStore) Budgets() BudgetRepository {
+	return mongoBudgets{s.db.Collection("budgets")}
+}
+func (s *mongoStore) Changes() ChangeRepository { return mongoChanges{s.db} }
+func (s *mongoStore) CreditAccounts() CreditAccountRepository {
+	return mongoCreditAccounts{s.db.Collection("creditAccounts"), s.db.Collection("accountEntries")}
+}
+func (s *mongoStore) SyncConflicts() SyncConflictRepository {
+	return mongoSyncConflicts{s.db.Collection("syncConflicts")}
+}
+
+func (s *mongoStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx, nil)
+}
+
+func (s *mongoStore) Unavailable(err error) bool {
+	return err != nil && (mongo.IsNetworkError(err) || mongo.IsTimeout(err))
+}
+
+func (s *mongoStore) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
+
+// Migrate creates the indexes the queries rely on. MongoDB creates collections on first write.
+func (s *mongoStore) Migrate(ctx context.Context) error {
+	indexes := map[string][]mongo.IndexModel{
+		"menu":      {{Keys: bson.D{{Key: "name", Value: 1}}}},
+		"customers": {{Keys: bson.D{{Key: "name", Value: 1}}}, {Keys: bson.D{{Key: "phone", Value: 1}}}},
+		"orders": {
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: 1}}},
+			{Keys: bson.D{{Key: "createdAt", Value: 1}}},
+			{Keys: bson.D{{Key: "type", Value: 1}, {Key: "createdAt", Value: 1}}},
+			{Keys: bson.D{{Key: "updatedAt", Value: 1}, {Key: "_id", Value: 1}}},
+			// Lets the collection be sharded on a hashed _id, spreading new orders across shards
+			{Keys: bson.D{{Key: "_id", Value: "hashed"}}},
+		},
+		"ordersArchive":      {{Keys: bson.D{{Key: "createdAt", Value: 1}}}},
+		"orderEventsArchive": {{Keys: bson.D{{Key: "orderId", Value: 1}, {Key: "seq", Value: 1}}}},
+		"tables":             {{Keys: bson.D{{Key: "number", Value: 1}}, Options: options.Index().SetUnique(true)}},
+		"payments": {
+			{Keys: bson.D{{Key: "orderId", Value: 1}}},
+			{Keys: bson.D{{Key: "createdAt", Value: 1}}},
+			{Keys: bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}},
+			{Keys: bson.D{{Key: "_id", Value: "hashed"}}},
+		},
+		"orderEvents": {
+			{Keys: bson.D{{Key: "orderId", Value: 1}, {Key: "seq", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "at", Value: 1}}},
+			{Keys: bson.D{{Key: "publishedAt", Value: 1}, {Key: "at", Value: 1}}},
+		},
+		"apiKeys": {
+			{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "tenantId", Value: 1}}},
+		},
+		"devices": {
+			{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"messageTemplates": {{Keys: bson.D{{Key: "name", Value: 1}, {Key: "version", Value: -1}}, Options: options.Index().SetUnique(true)}},
+		"printJobs": {
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}}},
+			{Keys: bson.D{{Key: "createdAt", Value: -1}}},
+		},
+		"logBook": {
+			{Keys: bson.D{{Key: "date", Value: 1}, {Key: "createdAt", Value: 1}}},
+			{Keys: bson.D{{Key: "kind", Value: 1}, {Key: "doneAt", Value: 1}}},
+		},
+		"fxExchanges":       {{Keys: bson.D{{Key: "createdAt", Value: 1}}}},
+		"notificationQueue": {{Keys: bson.D{{Key: "sendAfter", Value: 1}}}},
+		"surveys": {
+			{Keys: bson.D{{Key: "token", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "sentAt", Value: 1}}},
+		},
+		"complaints":        {{Keys: bson.D{{Key: "createdAt", Value: 1}}}},
+		"codCollections":    {{Keys: bson.D{{Key: "settlementId", Value: 1}, {Key: "driver", Value: 1}, {Key: "collectedAt", Value: 1}}}},
+		"driverSettlements": {{Keys: bson.D{{Key: "settledAt", Value: 1}}}},
+		"deliveryZones":     {{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)}},
+		"budgets":           {{Keys: bson.D{{Key: "month", Value: 1}}, Options: options.Index().SetUnique(true)}},
+		"containerLoans":    {{Keys: bson.D{{Key: "outstanding", Value: 1}, {Key: "lentAt", Value: 1}}}},
+		"parkedCarts": {
+			{Keys: bson.D{{Key: "table", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"table": bson.M{"$gt": 0}})},
+			{Keys: bson.D{{Key: "expiresAt", Value: 1}}},
+		},
+		"apiKeyUsage":  {{Keys: bson.D{{Key: "keyId", Value: 1}, {Key: "at", Value: -1}}}},
+		"soldOut":      {{Keys: bson.D{{Key: "at", Value: 1}}}},
+		"priceChanges": {{Keys: bson.D{{Key: "item", Value: 1}, {Key: "at", Value: 1}}}},
+		"menuVersions": {{Keys: bson.D{{Key: "publishedAt", Value: -1}}}},
+		"invoices": {
+			{Keys: bson.D{{Key: "number", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "financialYear", Value: 1}, {Key: "seq", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "orderId", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "issuedAt", Value: 1}}},
+		},
+		"drawerSessions": {
+			// Only one session may be open
+			{Keys: bson.D{{Key: "open", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"open": true})},
+			{Keys: bson.D{{Key: "openedAt", Value: 1}}},
+		},
+		"shifts": {
+			// Each cashier may have only one open shift
+			{Keys: bson.D{{Key: "cashier", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"open": true})},
+			{Keys: bson.D{{Key: "openedAt", Value: 1}}},
+		},
+		"timeEntries": {
+			// Each employee may be clocked in only once
+			{Keys: bson.D{{Key: "employee", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"open": true})},
+			{Keys: bson.D{{Key: "clockIn", Value: 1}}},
+		},
+		"reservations": {{Keys: bson.D{{Key: "at", Value: 1}}}},
+		"banquets":     {{Keys: bson.D{{Key: "start", Value: 1}}}},
+		"quotes": {
+			{Keys: bson.D{{Key: "token", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "createdAt", Value: 1}}},
+		},
+		"creditAccounts": {{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)}},
+		"accountEntries": {{Keys: bson.D{{Key: "accountId", Value: 1}, {Key: "createdAt", Value: 1}}}},
+		"companies":      {{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)}},
+		// MongoDB also drops expired keys on its own, a minute or so after they expire
+		"idempotencyKeys": {{Keys: bson.D{{Key: "createdAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(idempotencyTTL.Seconds()))}},
+		"coupons": {
+			{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "customerName", Value: 1}, {Key: "occasion", Value: 1}, {Key: "year", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"tableSittings": {
+			// Each table may have only one party seated at it
+			{Keys: bson.D{{Key: "table", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"open": true})},
+			{Keys: bson.D{{Key: "seatedAt", Value: 1}}},
+		},
+		"stockLevels":    {{Keys: bson.D{{Key: "branch", Value: 1}, {Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)}},
+		"stockWriteOffs": {{Keys: bson.D{{Key: "branch", Value: 1}, {Key: "writtenOffAt", Value: 1}}}},
+		"refunds":        {{Keys: bson.D{{Key: "orderId", Value: 1}}}, {Keys: bson.D{{Key: "createdAt", Value: 1}}}},
+		"sessions": {
+			{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "keyId", Value: 1}}},
+		},
+		"stockTransfers": {
+			{Keys: bson.D{{Key: "from", Value: 1}, {Key: "createdAt", Value: -1}}},
+			{Keys: bson.D{{Key: "to", Value: 1}, {Key: "createdAt", Value: -1}}},
+		},
+		"companyInvoices": {
+			{Keys: bson.D{{Key: "number", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "financialYear", Value: 1}, {Key: "seq", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "companyId", Value: 1}, {Key: "month", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+	}
+	// Stock was unique by name alone before it was kept per branch
+	if _, err := s.db.Collection("stockLevels").Indexes().DropOne(ctx, "name_1"); err != nil {
+		var commandErr mongo.CommandError
+		if !errors.As(err, &commandErr) || (commandErr.Name != "IndexNotFound" && commandErr.Name != "NamespaceNotFound") {
+			return fmt.Errorf("dropping the stock name index: %w", err)
+		}
+	}
+	// Orders stored before they kept a watermark were last changed, as far as exports know, when they were placed
+	backfill := mongo.Pipeline{{{Key: "$set", Value: bson.M{"updatedAt": "$createdAt"}}}}
+	if _, err := s.db.Collection("orders").UpdateMany(ctx, bson.M{"updatedAt": bson.M{"$exists": false}}, backfill); err != nil {
+		return fmt.Errorf("stamping orders with when they changed: %w", err)
+	}
+	for collection, models := range indexes {
+		if _, err := s.db.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("creating indexes on %s: %w", collection, err)
+		}
+	}
+	return nil
+}
+
+// notFound translates the driver's no-documents error to the shared ErrNotFound
+func notFound(err error) error {
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+// duplicate translates duplicate key errors to the shared ErrDuplicate
+func duplicate(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// findAll runs a query and decodes every result
+func findAll[T any](ctx context.Context, collection *mongo.Collection, filter any, opts ...*options.FindOptions) ([]T, error) {
+	cursor, err := collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []T
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+type mongoMenu struct{ collection, soldOut, priceChanges *mongo.Collection }
+
+func (m mongoMenu) List(ctx context.Context) ([]MenuItem, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	return findAll[MenuItem](ctx, m.collection, bson.D{}, opts)
+}
+
+func (m mongoMenu) Add(ctx context.Context, item MenuItem) error {
+	count, err := m.collection.CountDocuments(ctx, bson.M{"name": item.Name})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrDuplicate
+	}
+	item.Version = 1
+	_, err = m.collection.InsertOne(ctx, item)
+	return err
+}
+
+func (m mongoMenu) Update(ctx context.Context, item MenuItem) error {
+	filter := atVersion(bson.M{"name": item.Name}, item.Version)
+	item.Version++
+	result, err := m.collection.ReplaceOne(ctx, filter, item)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return versionMissed(ctx, m.collection, bson.M{"name": item.Name})
+	}
+	return nil
+}
+
+// atVersion narrows filter to documents at version. Documents stored before versions existed have no
+// version field and count as version 0.
+func atVersion(filter bson.M, version int) bson.M {
+	if version == 0 {
+		filter["$or"] = bson.A{bson.M{"version": 0}, bson.M{"version": bson.M{"$exists": false}}}
+	} else {
+		filter["version"] = version
+	}
+	return filter
+}
+
+// versionMissed explains why an update filtered with atVersion matched nothing: ErrVersionConflict if
+// the document is there at another version, ErrNotFound if it is not there at all
+func versionMissed(ctx context.Context, collection *mongo.Collection, filter bson.M) error {
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrVersionConflict
+	}
+	return ErrNotFound
+}
+
+func (m mongoMenu) Delete(ctx context.Context, name string) error {
+	result, err := m.collection.DeleteOne(ctx, bson.M{"name": name})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m mongoMenu) LogSoldOut(ctx context.Context, record SoldOut) error {
+	_, err := m.soldOut.InsertOne(ctx, record)
+	return err
+}
+
+func (m mongoMenu) ListSoldOut(ctx context.Context, from, to time.Time) ([]SoldOut, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: 1}})
+	return findAll[SoldOut](ctx, m.soldOut, bson.M{"at": bson.M{"$gte": from, "$lt": to}}, opts)
+}
+
+func (m mongoMenu) LogPriceChange(ctx context.Context, change PriceChange) error {
+	_, err := m.priceChanges.InsertOne(ctx, change)
+	return err
+}
+
+func (m mongoMenu) ListPriceChanges(ctx context.Context, item string) ([]PriceChange, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: 1}})
+	return findAll[PriceChange](ctx, m.priceChanges, bson.M{"item": item}, opts)
+}
+
+// mongoMenuVersions keeps versions in menuVersions and the draft as a single document in menuDraft
+type mongoMenuVersions struct{ db *mongo.Database }
+
+// menuDraftDoc is how the draft is stored
+type menuDraftDoc struct {
+	ID    string     `bson:"_id"`
+	Items []MenuItem `bson:"items"`
+}
+
+func (m mongoMenuVersions) LoadDraft(ctx context.Context) ([]MenuItem, error) {
+	var draft menuDraftDoc
+	err := m.db.Collection("menuDraft").FindOne(ctx, bson.M{"_id": "draft"}).Decode(&draft)
+	return draft.Items, notFound(err)
+}
+
+func (m mongoMenuVersions) SaveDraft(ctx context.Context, items []MenuItem) error {
+	_, err := m.db.Collection("menuDraft").ReplaceOne(ctx, bson.M{"_id": "draft"}, menuDraftDoc{ID: "draft", Items: items}, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m mongoMenuVersions) DeleteDraft(ctx context.Context) error {
+	_, err := m.db.Collection("menuDraft").DeleteOne(ctx, bson.M{"_id": "draft"})
+	return err
+}
+
+// Publish builds the new menu in a staging collection and renames it over the live one, which
+// readers see as a single switch without needing a replica set for transactions
+func (m mongoMenuVersions) Publish(ctx context.Context, version MenuVersion) error {
+	if _, err := m.db.Collection("menuVersions").InsertOne(ctx, version); err != nil {
+		return duplicate(err)
+	}
+
+	staging := m.db.Collection("menuStaging")
+	if err := staging.Drop(ctx); err != nil {
+		return err
+	}
+	if len(version.Items) > 0 {
+		docs := make([]any, len(version.Items))
+		for i, item := range version.Items {
+			docs[i] = item
+		}
+		if _, err := staging.InsertMany(ctx, docs); err != nil {
+			return err
+		}
+	}
+	if _, err := staging.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "name", Value: 1}}}); err != nil {
+		return err
+	}
+	rename := bson.D{
+		{Key: "renameCollection", Value: m.db.Name() + ".menuStaging"},
+		{Key: "to", Value: m.db.Name() + ".menu"},
+		{Key: "dropTarget", Value: true},
+	}
+	return m.db.Client().Database("admin").RunCommand(ctx, rename).Err()
+}
+
+func (m mongoMenuVersions) Find(ctx context.Context, version int) (MenuVersion, error) {
+	var found MenuVersion
+	err := m.db.Collection("menuVersions").FindOne(ctx, bson.M{"_id": version}).Decode(&found)
+	return found, notFound(err)
+}
+
+func (m mongoMenuVersions) List(ctx context.Context) ([]MenuVersion, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	return findAll[MenuVersion](ctx, m.db.Collection("menuVersions"), bson.D{}, opts)
+}
+
+func (m mongoMenuVersions) Latest(ctx context.Context) (int, error) {
+	var latest MenuVersion
+	opts := options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}}).SetProjection(bson.M{"_id": 1})
+	err := m.db.Collection("menuVersions").FindOne(ctx, bson.D{}, opts).Decode(&latest)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return latest.Version, err
+}
+
+type mongoCustomers struct{ collection *mongo.Collection }
+
+func (m mongoCustomers) Add(ctx context.Context, customer Customer) error {
+	customer.Version = 1
+	_, err := m.collection.InsertOne(ctx, customer)
+	return err
+}
+
+func (m mongoCustomers) FindByName(ctx context.Context, name string) (Customer, error) {
+	var customer Customer
+	err := m.collection.FindOne(ctx, bson.M{"name": name}).Decode(&customer)
+	return customer, notFound(err)
+}
+
+func (m mongoCustomers) FindByPhone(ctx context.Context, phone string) (Customer, error) {
+	var customer Customer
+	opts := options.FindOne().SetSort(bson.D{{Key: "_id", Value: 1}})
+	err := m.collection.FindOne(ctx, bson.M{"phone": phone}, opts).Decode(&customer)
+	return customer, notFound(err)
+}
+
+func (m mongoCustomers) List(ctx context.Context) ([]Customer, error) {
+	return findAll[Customer](ctx, m.collection, bson.D{})
+}
+
+func (m mongoCustomers) Search(ctx context.Context, query string, limit int) ([]Customer, error) {
+	filter := bson.M{}
+	if query = strings.TrimSpace(query); query != "" {
+		pattern := bson.M{"$regex": regexp.QuoteMeta(query), "$options": "i"}
+		filter = bson.M{"$or": bson.A{bson.M{"name": pattern}, bson.M{"phone": pattern}}}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}}).SetLimit(int64(limit))
+	return findAll[Customer](ctx, m.collection, filter, opts)
+}
+
+func (m mongoCustomers) AppendOrderedItems(ctx context.Context, name string, items []string) error {
+	update := bson.M{
+		"$push":        bson.M{"orderedItems": bson.M{"$each": items}},
+		"$setOnInsert": bson.M{"phone": "", "totalAmount": 0},
+		"$inc":         bson.M{"version": 1},
+	}
+	_, err := m.collection.UpdateOne(ctx, bson.M{"name": name}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (m mongoCustomers) SetTotal(ctx context.Context, name string, total float64) error {
+	update := bson.M{"$set": bson.M{"totalAmount": total}, "$inc": bson.M{"version": 1}}
+	_, err := m.collection.UpdateOne(ctx, bson.M{"name": name}, update)
+	return err
+}
+
+func (m mongoCustomers) SetPhone(ctx context.Context, name, phone string) error {
+	update := bson.M{"$set": bson.M{"phone": phone}, "$inc": bson.M{"version": 1}}
+	result, err := m.collection.UpdateOne(ctx, bson.M{"name": name}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m mongoCustomers) SetDietary(ctx context.Context, name string, version int, allergies, diets []string) error {
+	return m.set(ctx, name, version, bson.M{"allergies": allergies, "diets": diets})
+}
+
+func (m mongoCustomers) SetOccasions(ctx context.Context, name string, version int, birthday, anniversary string) error {
+	return m.set(ctx, name, version, bson.M{"birthday": birthday, "anniversary": anniversary})
+}
+
+func (m mongoCustomers) SetFlag(ctx context.Context, name string, version int, flag *CustomerFlag) error {
+	return m.set(ctx, name, version, bson.M{"flag": flag})
+}
+
+func (m mongoCustomers) SetAddresses(ctx context.Context, name string, version int, addresses []SavedAddress) error {
+	return m.set(ctx, name, version, bson.M{"addresses": addresses})
+}
+
+func (m mongoCustomers) SetCommunication(ctx context.Context, name string, version int, prefs *CommunicationPrefs) error {
+	return m.set(ctx, name, version, bson.M{"communication": prefs})
+}
+
+func (m mongoCustomers) SetLegacy(ctx context.Context, name string, version int, legacy *LegacyHistory) error {
+	return m.set(ctx, name, version, bson.M{"legacy": legacy})
+}
+
+// set changes fields of the customer, creating them if needed when version is 0. With another version
+// the customer must exist at that version.
+func (m mongoCustomers) set(ctx context.Context, name string, version int, fields bson.M) error {
+	update := bson.M{
+		"$set":         fields,
+		"$setOnInsert": bson.M{"phone": "", "orderedItems": bson.A{}, "totalAmount": 0},
+		"$inc":         bson.M{"version": 1},
+	}
+	if version == 0 {
+		_, err := m.collection.UpdateOne(ctx, bson.M{"name": name}, update, options.Update().SetUpsert(true))
+		return err
+	}
+	result, err := m.collection.UpdateOne(ctx, atVersion(bson.M{"name": name}, version), update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return versionMissed(ctx, m.collection, bson.M{"name": name})
+	}
+	return nil
+}
+
+type mongoOrders struct {
+	collection, archive *mongo.Collection
+	events              mongoEvents
+}
+
+func (m mongoOrders) Save(ctx context.Context, order Order) error {
+	// Orders stored before versions existed have no version field and are always replaced
+	filter := bson.M{"_id": order.ID, "$or": bson.A{
+		bson.M{"version": bson.M{"$lt": order.Version}},
+		bson.M{"version": bson.M{"$exists": false}},
+	}}
+	_, err := m.collection.ReplaceOne(ctx, filter, order, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// The upsert collided with a newer copy of the order, which wins
+		return nil
+	}
+	return err
+}
+
+func (m mongoOrders) Find(ctx context.Context, id primitive.ObjectID) (Order, error) {
+	var order Order
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		err = m.archive.FindOne(ctx, bson.M{"_id": id}).Decode(&order)
+	}
+	return order, notFound(err)
+}
+
+func (m mongoOrders) ListOpen(ctx context.Context) ([]Order, error) {
+	filter := bson.M{"status": bson.M{"$ne": StatusServed}}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	return findAll[Order](ctx, m.collection, filter, opts)
+}
+
+func (m mongoOrders) ListBetween(ctx context.Context, from, to time.Time) ([]Order, error) {
+	filter := bson.M{"createdAt": bson.M{"$gte": from, "$lt": to}}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	orders, err := findAll[Order](ctx, m.collection, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	archived, err := findAll[Order](ctx, m.archive, filter, opts)
+	if err != nil || len(archived) == 0 {
+		return orders, err
+	}
+	// An archived order that was changed again is live once more, and the live copy is the one that counts
+	live := map[primitive.ObjectID]bool{}
+	for _, order := range orders {
+		live[order.ID] = true
+	}
+	for _, order := range archived {
+		if !live[order.ID] {
+			orders = append(orders, order)
+		}
+	}
+	slices.SortStableFunc(orders, func(a, b Order) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return orders, nil
+}
+
+func (m mongoOrders) Archive(ctx context.Context, before time.Time, limit int) (int, error) {
+	filter := bson.M{"status": StatusServed, "paid": true, "createdAt": bson.M{"$lt": before}}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}).SetLimit(int64(limit))
+	orders, err := findAll[bson.Raw](ctx, m.collection, filter, opts)
+	if err != nil || len(orders) == 0 {
+		return 0, err
+	}
+	ids := make(bson.A, len(orders))
+	copies := make([]mongo.WriteModel, len(orders))
+	for i, order := range orders {
+		ids[i] = order.Lookup("_id")
+		// An order archived before and changed since replaces its archived copy
+		copies[i] = mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": ids[i]}).SetReplacement(order).SetUpsert(true)
+	}
+	// Copies go in before the originals are deleted, and copies left by an earlier run that stopped halfway are
+	// skipped, so the move can be run again at any point
+	if _, err := m.archive.BulkWrite(ctx, copies); err != nil {
+		return 0, err
+	}
+	events, err := findAll[bson.Raw](ctx, m.events.collection, bson.M{"orderId": bson.M{"$in": ids}})
+	if err != nil {
+		return 0, err
+	}
+	if len(events) > 0 {
+		eventDocs := make([]any, len(events))
+		for i, event := range events {
+			eventDocs[i] = event
+		}
+		if err := insertMissing(ctx, m.events.archive, eventDocs); err != nil {
+			return 0, err
+		}
+		if _, err := m.events.collection.DeleteMany(ctx, bson.M{"orderId": bson.M{"$in": ids}}); err != nil {
+			return 0, err
+		}
+	}
+	result, err := m.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return 0, err
+	}
+	return int(result.DeletedCount), nil
+}
+
+// insertMissing inserts the documents, skipping any whose _id is already in the collection
+func insertMissing(ctx context.Context, collection *mongo.Collection, docs []any) error {
+	_, err := collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			if !mongo.IsDuplicateKeyError(writeErr) {
+				return err
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+func (m mongoOrders) ListUpdatedAfter(ctx context.Context, after time.Time, afterID primitive.ObjectID, until time.Time, limit int) ([]Order, error) {
+	filter := bson.M{
+		"$or":       bson.A{bson.M{"updatedAt": bson.M{"$gt": after}}, bson.M{"updatedAt": after, "_id": bson.M{"$gt": afterID}}},
+		"updatedAt": bson.M{"$lt": until},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "updatedAt", Value: 1}, {Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	return findAll[Order](ctx, m.collection, filter, opts)
+}
+
+func (m mongoOrders) ListTakeaway(ctx context.Context, since time.Time, statuses []string) ([]Order, error) {
+	filter := bson.M{
+		"type":      OrderTakeaway,
+		"status":    bson.M{"$in": statuses},
+		"createdAt": bson.M{"$gte": since},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "token", Value: 1}})
+	return findAll[Order](ctx, m.collection, filter, opts)
+}
+
+func (m mongoOrders) TokenInUse(ctx context.Context, from, to time.Time, token int) (bool, error) {
+	filter := bson.M{"token": token, "createdAt": bson.M{"$gte": from, "$lt": to}}
+	count, err := m.collection.CountDocuments(ctx, filter)
+	return count > 0, err
+}
+
+type mongoTables struct{ collection *mongo.Collection }
+
+func (m mongoTables) Seed(ctx context.Context, tables []Table) error {
+	for _, table := range tables {
+		filter := bson.M{"number": table.Number}
+		update := bson.M{"$setOnInsert": table}
+		if _, err := m.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m mongoTables) List(ctx context.Context) ([]Table, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "number", Value: 1}})
+	return findAll[Table](ctx, m.collection, bson.D{}, opts)
+}
+
+func (m mongoTables) SetOccupied(ctx context.Context, number int, occupied bool) error {
+	result, err := m.collection.UpdateOne(ctx, bson.M{"number": number}, bson.M{"$set": bson.M{"occupied": occupied}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type mongoPayments struct{ collection *mongo.Collection }
+
+func (m mongoPayments) Insert(ctx context.Context, payment Payment) error {
+	_, err := m.collection.InsertOne(ctx, payment)
+	return duplicate(err)
+}
+
+func (m mongoPayments) Find(ctx context.Context, id primitive.ObjectID) (Payment, error) {
+	var payment Payment
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&payment)
+	return payment, notFound(err)
+}
+
+func (m mongoPayments) ListBetween(ctx context.Context, from, to time.Time) ([]Payment, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	return findAll[Payment](ctx, m.collection, bson.M{"createdAt": bson.M{"$gte": from, "$lt": to}}, opts)
+}
+
+func (m mongoPayments) ListAfter(ctx context.Context, after time.Time, afterID primitive.ObjectID, until time.Time, limit int) ([]Payment, error) {
+	filter := bson.M{
+		"$or":       bson.A{bson.M{"createdAt": bson.M{"$gt": after}}, bson.M{"createdAt": after, "_id": bson.M{"$gt": afterID}}},
+		"createdAt": bson.M{"$lt": until},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	return findAll[Payment](ctx, m.collection, filter, opts)
+}
+
+type mongoCounters struct{ collection *mongo.Collection }
+
+func (m mongoCounters) Next(ctx context.Context, name string) (int, error) {
+	update := bson.M{"$inc": bson.M{"seq": 1}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter struct {
+		Seq int `bson:"seq"`
+	}
+	if err := m.collection.FindOneAndUpdate(ctx, bson.M{"_id": name}, update, opts).Decode(&counter); err != nil {
+		return 0, err
+	}
+	return counter.Seq, nil
+}
+
+type mongoSyncConflicts struct{ collection *mongo.Collection }
+
+func (m mongoSyncConflicts) Insert(ctx context.Context, conflict SyncConflict) error {
+	_, err := m.collection.InsertOne(ctx, conflict)
+	return err
+}
+
+type mongoEvents struct{ collection, archive *mongo.Collection }
+
+func (m mongoEvents) Append(ctx context.Context, event OrderEvent) error {
+	_, err := m.collection.InsertOne(ctx, event)
+	return duplicate(err)
+}
+
+func (m mongoEvents) ListForOrder(ctx context.Context, orderID primitive.ObjectID) ([]OrderEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+	events, err := findAll[OrderEvent](ctx, m.collection, bson.M{"orderId": orderID}, opts)
+	if err != nil || len(events) > 0 && events[0].Seq == 1 {
+		return events, err
+	}
+	// The order's stream starts in the archive
+	archived, err := findAll[OrderEvent](ctx, m.archive, bson.M{"orderId": orderID}, opts)
+	return append(archived, events...), err
+}
+
+func (m mongoEvents) ListSince(ctx context.Context, since time.Time) ([]OrderEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: 1}, {Key: "_id", Value: 1}})
+	return findAll[OrderEvent](ctx, m.collection, bson.M{"at": bson.M{"$gte": since}}, opts)
+}
+
+func (m mongoEvents) ListUnpublished(ctx context.Context, limit int) ([]OrderEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: 1}, {Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	return findAll[OrderEvent](ctx, m.collection, bson.M{"publishedAt": nil}, opts)
+}
+
+func (m mongoEvents) MarkPublished(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"publishedAt": at}})
+	return err
+}
+
+type mongoTenants struct{ collection *mongo.Collection }
+
+func (m mongoTenants) Create(ctx context.Context, tenant Tenant) error {
+	_, err := m.collection.InsertOne(ctx, tenant)
+	return duplicate(err)
+}
+
+func (m mongoTenants) Find(ctx context.Context, id string) (Tenant, error) {
+	var tenant Tenant
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&tenant)
+	return tenant, notFound(err)
+}
+
+func (m mongoTenants) List(ctx context.Context) ([]Tenant, error) {
+	return findAll[Tenant](ctx, m.collection, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+}
+
+type mongoAPIKeys struct{ collection, usage *mongo.Collection }
+
+func (m mongoAPIKeys) Create(ctx context.Context, key APIKey) error {
+	_, err := m.collection.InsertOne(ctx, key)
+	return duplicate(err)
+}
+
+func (m mongoAPIKeys) FindByHash(ctx context.Context, hash string) (APIKey, error) {
+	var key APIKey
+	err := m.collection.FindOne(ctx, bson.M{"hash": hash}).Decode(&key)
+	return key, notFound(err)
+}
+
+func (m mongoAPIKeys) Find(ctx context.Context, id primitive.ObjectID) (APIKey, error) {
+	var key APIKey
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&key)
+	return key, notFound(err)
+}
+
+func (m mongoAPIKeys) ListByTenant(ctx context.Context, tenantID string) ([]APIKey, error) {
+	filter := bson.M{"tenantId": tenantID}
+	if tenantID == "" {
+		filter = bson.M{"tenantId": bson.M{"$exists": false}}
+	}
+	return findAll[APIKey](ctx, m.collection, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+}
+
+func (m mongoAPIKeys) Update(ctx context.Context, key APIKey) error {
+	result, err := m.collection.ReplaceOne(ctx, bson.M{"_id": key.ID}, key)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m mongoAPIKeys) LogUsage(ctx context.Context, usage APIKeyUsage) error {
+	_, err := m.usage.InsertOne(ctx, usage)
+	return err
+}
+
+func (m mongoAPIKeys) ListUsage(ctx context.Context, keyID primitive.ObjectID, limit int) ([]APIKeyUsage, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: -1}}).SetLimit(int64(limit))
+	return findAll[APIKeyUsage](ctx, m.usage, bson.M{"keyId": keyID}, opts)
+}
+
+type mongoInvoices struct{ collection *mongo.Collection }
+
+func (m mongoInvoices) Insert(ctx context.Context, invoice Invoice) error {
+	_, err := m.collection.InsertOne(ctx, invoice)
+	return duplicate(err)
+}
+
+func (m mongoInvoices) Find(ctx context.Context, number string) (Invoice, error) {
+	var invoice Invoice
+	err := m.collection.FindOne(ctx, bson.M{"number": number}).Decode(&invoice)
+	return invoice, notFound(err)
+}
+
+func (m mongoInvoices) FindByOrder(ctx context.Context, orderID primitive.ObjectID) (Invoice, error) {
+	var invoice Invoice
+	err := m.collection.FindOne(ctx, bson.M{"orderId": orderID}).Decode(&invoice)
+	return invoice, notFound(err)
+}
+
+func (m mongoInvoices) LastSeq(ctx context.Context, financialYear string) (int, error) {
+	var last Invoice
+	opts := options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}}).SetProjection(bson.M{"seq": 1})
+	err := m.collection.FindOne(ctx, bson.M{"financialYear": financialYear}, opts).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return last.Seq, err
+}
+
+func (m mongoInvoices) ListBetween(ctx context.Context, from, to time.Time) ([]Invoice, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "issuedAt", Value: 1}})
+	return findAll[Invoice](ctx, m.collection, bson.M{"issuedAt": bson.M{"$gte": from, "$lt": to}}, opts)
+}
+
+type mongoDrawers struct{ collection *mongo.Collection }
+
+func (m mongoDrawers) Open(ctx context.Context, session DrawerSession) error {
+	_, err := m.collection.InsertOne(ctx, session)
+	return duplicate(err)
+}
+
+func (m mongoDrawers) FindOpen(ctx context.Context) (DrawerSession, error) {
+	var session DrawerSession
+	err := m.collection.FindOne(ctx, bson.M{"open": true}).Decode(&session)
+	return session, notFound(err)
+}
+
+func (m mongoDrawers) AddMovement(ctx context.Context, id primitive.ObjectID, movement CashMovement) error {
+	result, err := m.collection.UpdateOne(ctx, bson.M{"_id": id, "open": true}, bson.M{"$push": bson.M{"movements": movement}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m mongoDrawers) Close(ctx context.Context, session DrawerSession) error {
+	result, err := m.collection.ReplaceOne(ctx, bson.M{"_id": session.ID, "open": true}, session)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m mongoDrawers) ListBetween(ctx context.Context, from, to time.Time) ([]DrawerSession, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "openedAt", Value: 1}})
+	return findAll[DrawerSession](ctx, m.collection, bson.M{"openedAt": bson.M{"$gte": from, "$lt": to}}, opts)
+}
+
+type mongoShifts struct{ collection *mongo.Collection }
+
+func (m mongoShifts) Open(ctx context.Context, shift Shift) error {
+	_, err := m.collection.InsertOne(ctx, shift)
+	return duplicate(err)
+}
+
+func (m mongoShifts) Find(ctx context.Context, id primitive.ObjectID) (Shift, error) {
+	var shift Shift
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&shift)
+	return shift, notFound(err)
+}
+
+func (m mongoShifts) FindOpen(ctx context.Context, cashier string) (Shift, error) {
+	var shift Shift
+	err := m.collection.FindOne(ctx, bson.M{"cashier": cashier, "open": true}).Decode(&shift)
+	return shift, notFound(err)
+}
+
+func (m mongoShifts) Close(ctx context.Context, shift Shift) error {
+	result, err := m.collection.ReplaceOne(ctx, bson.M{"_id": shift.ID, "open": true}, shift)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m mongoShifts) ListBetween(ctx context.Context, from, to time.Time) ([]Shift, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "openedAt", Value: 1}})
+	return findAll[Shift](ctx, m.collection, bson.M{"openedAt": bson.M{"$gte": from, "$lt": to}}, opts)
+}
+
+type mongoTimeClock struct{ collection *mongo.Collection }
+
+func (m mongoTimeClock) ClockIn(ctx context.Context, entry TimeEntry) error {
+	_, err := m.collection.InsertOne(ctx, entry)
+	return duplicate(err)
+}
+
+func (m mongoTimeClock) FindOpen(ctx context.Context, employee string) (TimeEntry, error) {
+	var entry TimeEntry
+	err := m.collection.FindOne(ctx, bson.M{"employee": employee, "open": true}).Decode(&entry)
+	return entry, notFound(err)
+}
+
+func (m mongoTimeClock) ClockOut(ctx context.Context, entry TimeEntry) error {
+	result, err := m.collection.ReplaceOne(ctx, bson.M{"_id": entry.ID, "open": true}, entry)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m mongoTimeClock) ListBetween(ctx context.Context, from, to time.Time) ([]TimeEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "clockIn", Value: 1}})
+	return findAll[TimeEntry](ctx, m.collection, bson.M{"clockIn": bson.M{"$gte": from, "$lt": to}}, opts)
+}
+
+type mongoReservations struct{ collection *mongo.Collection }
+
+func (m mongoReservations) Insert(ctx context.Context, reservation Reservation) error {
+	_, err := m.collection.InsertOne(ctx, reservation)
+	return err
+}
+
+func (m mongoReservations) Find(ctx context.Context, id primitive.ObjectID) (Reservation, error) {
+	var reservation Reservation
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&reservation)
+	return reservation, notFound(err)
+}
+
+func (m mongoReservations) Update(ctx context.Context, reservation Reservation) error {
+	result, err := m.collection.ReplaceOne(ctx, bson.M{"_id": reservation.ID}, reservation)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m mongoReservations) ListBetween(ctx context.Context, from, to time.Time) ([]Reservation, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: 1}})
+	return findAll[Reservation](ctx, m.collection, bson.M{"at": bson.M{"$gte": from, "$lt": to}}, opts)
+}
+
+type mongoBanquets struct{ collection *mongo.Collection }
+
+func (m mongoBanquets) Insert(ctx context.Context, banquet Banquet) error {
+	_, err := m.collection.InsertOne(ctx, banquet)
+	return err
+}
+
+func (m mongoBanquets) Find(ctx context.Context, id primitive.ObjectID) (Banquet, error) {
+	var banquet Banquet
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&banquet)
+	return banquet, notFound(err)
+}
+
+func (m mongoBanquets) Update(ctx context.Context, banquet Banquet) error {
+	result, err := m.collection.ReplaceOne(ctx, bson.M{"_id": banquet.ID}, banquet)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m mongoBanquets) ListOverlapping(ctx context.Context, from, to time.Time) ([]Banquet, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "start", Value: 1}})
+	return findAll[Banquet](ctx, m.collection, bson.M{"start": bson.M{"$lt": to}, "end": bson.M{"$gt": from}}, opts)
+}
+
+type mongoQuotes struct{ collection *mongo.Collection }
+
+func (m mongoQuotes) Insert(ctx context.Context, quote Quote) error {
+	_, err := m.collection.InsertOne(ctx, quote)
+	return err
+}
+
+func (m mongoQuotes) Find(ctx context.Context, id primitive.ObjectID) (Quote, error) {
+	var quote Quote
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&quote)
+	return quote, notFound(err)
+}
+
+func (m mongoQuotes) FindByToken(ctx context.Context, token string) (Quote, error) {
+	var quote Quote
+	err := m.collection.FindOne(ctx, bson.M{"token": token}).Decode(&quote)
+	return quote, notFound(err)
+}
+
+func (m mongoQuotes) Update(ctx context.Context, quote Quote) error {
+	result, err := m.collection.ReplaceOne(ctx, bson.M{"_id": quote.ID}, quote)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m mongoQuotes) ListBetween(ctx context.Context, from, to time.Time) ([]Quote, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	return findAll[Quote](ctx, m.collection, bson.M{"createdAt": bson.M{"$gte": from, "$lt": to}}, opts)
+}
+
+type mongoCreditAccounts struct{ accounts, entries *mongo.Collection }
+
+func (m mongoCreditAccounts) Insert(ctx context.Context, account CreditAccount) error {
+	_, err := m.accounts.InsertOne(ctx, account)
+	return duplicate(err)
+}
+
+func (m mongoCreditAccounts) Find(ctx context.Context, id primitive.ObjectID) (CreditAccount, error) {
+	var account CreditAccount
+	err := m.accounts.FindOne(ctx, bson.M{"_id": id}).Decode(&account)
+	return account, notFound(err)
+}
+
+func (m mongoCreditAccounts) List(ctx context.Context) ([]CreditAccount, error) {
+	return findAll[CreditAccount](ctx, m.accounts, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+}
+
+func (m mongoCreditAccounts) Update(ctx context.Context, account CreditAccount) error {
+	result, err := m.accounts.UpdateOne(ctx, bson.M{"_id": account.ID}, bson.M{"$set": bson.M{
+		"name": account.Name, "phone": account.Phone, "creditLimit": account.CreditLimit,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m mongoCreditAccounts) Post(ctx context.Context, entry AccountEntry) (CreditAccount, error) {
+	filter := bson.M{"_id": entry.AccountID}
+	if entry.Amount > 0 {
+		filter["$expr"] = bson.M{"$lte": bson.A{bson.M{"$add": bson.A{"$balance", entry.Amount}}, "$creditLimit"}}
+	}
+	var account CreditAccount
+	err := m.accounts.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"balance": entry.Amount}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&account)
+	if err == mongo.ErrNoDocuments {
+		// Either there is no such account or the charge is over its limit
+		if _, err := m.Find(ctx, entry.AccountID); err != nil {
+			return CreditAccount{}, err
+		}
+		return CreditAccount{}, ErrCreditLimit
+	}
+	if err != nil {
+		return CreditAccount{}, err
+	}
+	account.Balance = roundPaise(account.Balance)
+	entry.Balance = account.Balance
+	if _, err := m.entries.InsertOne(ctx, entry); err != nil {
+		return CreditAccount{}, err
+	}
+	return account, nil
+}
+
+func (m mongoCreditAccounts) ListEntries(ctx context.Context, id primitive.ObjectID) ([]AccountEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	return findAll[AccountEntry](ctx, m.entries, bson.M{"accountId": id}, opts)
+}
+
+type mongoCompanies struct{ companies, invoices *mongo.Collection }
+
+func (m mongoCompanies) Insert(ctx context.Context, company Company) error {
+	_, err := m.companies.InsertOne(ctx, company)
+	return duplicate(err)
+}
+
+func (m mongoCompanies) Find(ctx context.Context, id primitive.ObjectID) (Company, error) {
+	var company Company
+	err := m.companies.FindOne(ctx, bson.M{"_id": id}).Decode(&company)
+	return company, notFound(err)
+}
+
+func (m mongoCompanies) List(ctx context.Context) ([]Company, error) {
+	return findAll[Company](ctx, m.companies, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+}
+
+func (m mongoCompanies) Update(ctx context.Context, company Company) error {
+	result, err := m.companies.ReplaceOne(ctx, bson.M{"_id": company.ID}, company)
+	if err != nil {
+		return duplicate(err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m mongoCompanies) InsertInvoice(ctx context.Context, invoice CompanyInvoice) error {
+	_, err := m.invoices.InsertOne(ctx, invoice)
+	return duplicate(err)
+}
+
+func (m mongoCompanies) FindInvoice(ctx context.Context, companyID primitive.ObjectID, month string) (CompanyInvoice, error) {
+	var invoice CompanyInvoice
+	err := m.invoices.FindOne(ctx, bson.M{"companyId": companyID, "month": month}).Decode(&invoice)
+	return invoice, notFound(err)
+}
+
+func (m mongoCompanies) LastInvoiceSeq(ctx context.Context, financialYear string) (int, error) {
+	var last CompanyInvoice
+	opts := options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}}).SetProjection(bson.M{"seq": 1})
+	err := m.invoices.FindOne(ctx, bson.M{"financialYear": financialYear}, opts).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return last.Seq, err
+}
+
+func (m mongoCompanies) ListInvoices(ctx context.Context, companyID primitive.ObjectID) ([]CompanyInvoice, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "month", Value: 1}})
+	return findAll[CompanyInvoice](ctx, m.invoices, bson.M{"companyId": companyID}, opts)
+}
+
+type mongoIdempotency struct{ collection *mongo.Collection }
+
+func (m mongoIdempotency) Begin(ctx context.Context, record IdempotencyRecord, expiredBefore time.Time) error {
+	if _, err := m.collection.DeleteMany(ctx, bson.M{"createdAt": bson.M{"$lt": expiredBefore}}); err != nil {
+		return err
+	}
+	_, err := m.collection.InsertOne(ctx, record)
+	return duplicate(err)
+}
+
+func (m mongoIdempotency) Find(ctx context.Context, key string) (IdempotencyRecord, error) {
+	var record IdempotencyRecord
+	err := m.collection.FindOne(ctx, bson.M{"_id": key}).Decode(&record)
+	return record, notFound(err)
+}
+
+func (m mongoIdempotency) Complete(ctx context.Context, record IdempotencyRecord) error {
+	result, err := m.collection.ReplaceOne(ctx, bson.M{"_id": record.Key}, record)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m mongoIdempotency) Delete(ctx context.Context, key string) error {
+	_, err := m.collection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
+
+type mongoCoupons struct{ collection *mongo.Collection }
+
+func (m mongoCoupons) Add(ctx context.Context, coupon Coupon) error {
+	_, err := m.collection.InsertOne(ctx, coupon)
+	return duplicate(err)
+}
+
+func (m mongoCoupons) FindByCode(ctx context.Context, code string) (Coupon, error) {
+	var coupon Coupon
+	err := m.collection.FindOne(ctx, bson.M{"code": code}).Decode(&coupon)
+	return coupon, notFound(err)
+}
+
+type mongoSittings struct{ collection *mongo.Collection }
+
+func (m mongoSittings) Open(ctx context.Context, sitting TableSitting) error {
+	_, err := m.collection.InsertOne(ctx, sitting)
+	return duplicate(err)
+}
+
+func (m mongoSittings) FindOpen(ctx context.Context, table int) (TableSitting, error) {
+	var sitting TableSitting
+	err := m.collection.FindOne(ctx, bson.M{"table": table, "open": true}).Decode(&sitting)
+	return sitting, notFound(err)
+}
+
+func (m mongoSittings) Close(ctx context.Context, sitting TableSitting) error {
+	result, err := m.collection.ReplaceOne(ctx, bson.M{"_id": sitting.ID, "open": true}, sitting)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m mongoSittings) ListBetween(ctx context.Context, from, to time.Time) ([]TableSitting, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "seatedAt", Value: 1}})
+	return findAll[TableSitting](ctx, m.collection, bson.M{"seatedAt": bson.M{"$gte": from, "$lt": to}}, opts)
+}
+
+type mongoStock struct{ collection, writeOffs *mongo.Collection }
+
+// atBranch narrows filter to the branch's documents. The main branch is stored without a branch field, so
+// stock from before there were branches is the main branch's.
+func atBranch(filter bson.M, branch string) bson.M {
+	if branch == "" {
+		filter["branch"] = nil
+	} else {
+		filter["branch"] = branch
+	}
+	return filter
+}
+
+func (m mongoStock) Save(ctx context.Context, level StockLevel) error {
+	filter := atVersion(atBranch(bson.M{"name": level.Name}, level.Branch), level.Version)
+	level.Version++
+	// A new level is inserted; the unique branch and name index turns a level stored meanwhile into a conflict
+	result, err := m.collection.ReplaceOne(ctx, filter, level, options.Replace().SetUpsert(filter["version"] == nil))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 && result.UpsertedCount == 0 {
+		return versionMissed(ctx, m.collection, atBranch(bson.M{"name": level.Name}, level.Branch))
+	}
+	return nil
+}
+
+func (m mongoStock) Find(ctx context.Context, branch, name string) (StockLevel, error) {
+	var level StockLevel
+	err := m.collection.FindOne(ctx, atBranch(bson.M{"name": name}, branch)).Decode(&level)
+	return level, notFound(err)
+}
+
+func (m mongoStock) List(ctx context.Context, branch string) ([]StockLevel, error) {
+	return findAll[StockLevel](ctx, m.collection, atBranch(bson.M{}, branch), options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+}
+
+func (m mongoStock) SetAlerted(ctx context.Context, branch, name string, at *time.Time) error {
+	update := bson.M{"$unset": bson.M{"alertedAt": ""}}
+	if at != nil {
+		update = bson.M{"$set": bson.M{"alertedAt": *at}}
+	}
+	result, err := m.collection.UpdateOne(ctx, atBranch(bson.M{"name": name}, branch), update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m mongoStock) AddWriteOff(ctx context.Context, writeOff StockWriteOff) error {
+	_, err := m.writeOffs.InsertOne(ctx, writeOff)
+	return err
+}
+
+func (m mongoStock) ListWriteOffs(ctx context.Context, branch string, from, to time.Time) ([]StockWriteOff, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "writtenOffAt", Value: 1}})
+	return findAll[StockWriteOff](ctx, m.writeOffs, atBranch(bson.M{"writtenOffAt": bson.M{"$gte": from, "$lt": to}}, branch), opts)
+}
+
+type mongoTransfers struct{ collection *mongo.Collection }
+
+func (m mongoTransfers) Insert(ctx context.Context, transfer StockTransfer) error {
+	_, err := m.collection.InsertOne(ctx, transfer)
+	return err
+}
+
+func (m mongoTransfers) Find(ctx context.Context, id primitive.ObjectID) (StockTransfer, error) {
+	var transfer StockTransfer
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&transfer)
+	return transfer, notFound(err)
+}
+
+func (m mongoTransfers) Update(ctx context.Context, transfer StockTransfer) error {
+	filter := atVersion(bson.M{"_id": transfer.ID}, transfer.Version)
+	transfer.Version++
+	result, err := m.collection.ReplaceOne(ctx, filter, transfer)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return versionMissed(ctx, m.collection, bson.M{"_id": transfer.ID})
+	}
+	return nil
+}
+
+func (m mongoTransfers) ListForBranch(ctx context.Context, branch string) ([]StockTransfer, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	return findAll[StockTransfer](ctx, m.collection, bson.M{"$or": bson.A{bson.M{"from": branch}, bson.M{"to": branch}}}, opts)
+}
+
+type mongoBranchMenus struct{ collection *mongo.Collection }
+
+func (m mongoBranchMenus) Find(ctx context.Context, branch string) (BranchMenu, error) {
+	var menu BranchMenu
+	err := m.collection.FindOne(ctx, bson.M{"_id": branch}).Decode(&menu)
+	return menu, notFound(err)
+}
+
+func (m mongoBranchMenus) Save(ctx context.Context, menu BranchMenu) error {
+	filter := atVersion(bson.M{"_id": menu.Branch}, menu.Version)
+	menu.Version++
+	// A new menu is inserted; one stored meanwhile has the same _id, which turns into a conflict
+	result, err := m.collection.ReplaceOne(ctx, filter, menu, options.Replace().SetUpsert(filter["version"] == nil))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 && result.UpsertedCount == 0 {
+		return versionMissed(ctx, m.collection, bson.M{"_id": menu.Branch})
+	}
+	return nil
+}
+
+type mongoRefunds struct{ collection *mongo.Collection }
+
+func (m mongoRefunds) Add(ctx context.Context, refund Refund) error {
+	_, err := m.collection.InsertOne(ctx, refund)
+	return err
+}
+
+func (m mongoRefunds) ListForOrder(ctx context.Context, orderID primitive.ObjectID) ([]Refund, error) {
+	return findAll[Refund](ctx, m.collection, bson.M{"orderId": orderID}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+}
+
+func (m mongoRefunds) ListBetween(ctx context.Context, from, to time.Time) ([]Refund, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	return findAll[Refund](ctx, m.collection, bson.M{"createdAt": bson.M{"$gte": from, "$lt": to}}, opts)
+}
+
+type mongoTwoFactor struct{ collection *mongo.Collection }
+
+func (m mongoTwoFactor) Find(ctx context.Context, keyID primitive.ObjectID) (TwoFactor, error) {
+	var twoFactor TwoFactor
+	err := m.collection.FindOne(ctx, bson.M{"_id": keyID}).Decode(&twoFactor)
+	return twoFactor, notFound(err)
+}
+
+func (m mongoTwoFactor) Save(ctx context.Context, twoFactor TwoFactor) error {
+	filter := atVersion(bson.M{"_id": twoFactor.KeyID}, twoFactor.Version)
+	twoFactor.Version++
+	// A new enrollment is inserted; one stored meanwhile has the same _id, which turns into a conflict
+	result, err := m.collection.ReplaceOne(ctx, filter, twoFactor, options.Replace().SetUpsert(filter["version"] == nil))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 && result.UpsertedCount == 0 {
+		return versionMissed(ctx, m.collection, bson.M{"_id": twoFactor.KeyID})
+	}
+	return nil
+}
+
+func (m mongoTwoFactor) Delete(ctx context.Context, keyID primitive.ObjectID) error {
+	_, err := m.collection.DeleteOne(ctx, bson.M{"_id": keyID})
+	return err
+}
+
+type mongoSessions struct{ collection *mongo.Collection }
+
+func (m mongoSessions) Insert(ctx context.Context, session Session) error {
+	_, err := m.collection.InsertOne(ctx, session)
+	return err
+}
+
+func (m mongoSessions) Find(ctx context.Context, id primitive.ObjectID) (Session, error) {
+	var session Session
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&session)
+	return session, notFound(err)
+}
+
+func (m mongoSessions) FindByHash(ctx context.Context, hash string) (Session, error) {
+	var session Session
+	err := m.collection.FindOne(ctx, bson.M{"hash": hash}).Decode(&session)
+	return session, notFound(err)
+}
+
+func (m mongoSessions) ListForKey(ctx context.Context, keyID primitive.ObjectID) ([]Session, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	return findAll[Session](ctx, m.collection, bson.M{"keyId": keyID}, opts)
+}
+
+func (m mongoSessions) Update(ctx context.Context, session Session) error {
+	result, err := m.collection.UpdateOne(ctx, bson.M{"_id": session.ID}, bson.M{"$set": bson.M{
+		"lastSeenAt": session.LastSeenAt, "expiresAt": session.ExpiresAt, "revokedAt": session.RevokedAt, "revokedBy": session.RevokedBy,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type mongoDevices struct{ collection *mongo.Collection }
+
+func (m mongoDevices) Create(ctx context.Context, device Device) error {
+	_, err := m.collection.InsertOne(ctx, device)
+	return duplicate(err)
+}
+
+func (m mongoDevices) Find(ctx context.Context, id primitive.ObjectID) (Device, error) {
+	var device Device
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&device)
+	return device, notFound(err)
+}
+
+func (m mongoDevices) FindByHash(ctx context.Context, hash string) (Device, error) {
+	var device Device
+	err := m.collection.FindOne(ctx, bson.M{"hash": hash}).Decode(&device)
+	return device, notFound(err)
+}
+
+func (m mongoDevices) ListByTenant(ctx context.Context, tenantID string) ([]Device, error) {
+	filter := bson.M{"tenantId": tenantID}
+	if tenantID == "" {
+		filter = bson.M{"tenantId": bson.M{"$exists": false}}
+	}
+	return findAll[Device](ctx, m.collection, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+}
+
+func (m mongoDevices) Update(ctx context.Context, device Device) error {
+	result, err := m.collection.ReplaceOne(ctx, bson.M{"_id": device.ID}, device)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type mongoParkedCarts struct{ collection *mongo.Collection }
+
+func (m mongoParkedCarts) Park(ctx context.Context, cart ParkedCart) error {
+	if _, err := m.collection.DeleteMany(ctx, bson.M{"expiresAt": bson.M{"$lt": cart.ParkedAt}}); err != nil {
+		return err
+	}
+	_, err := m.collection.InsertOne(ctx, cart)
+	return duplicate(err)
+}
+
+func (m mongoParkedCarts) List(ctx context.Context, now time.Time) ([]ParkedCart, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	return findAll[ParkedCart](ctx, m.collection, bson.M{"expiresAt": bson.M{"$gte": now}}, opts)
+}
+
+func (m mongoParkedCarts) Take(ctx context.Context, id primitive.ObjectID) (ParkedCart, error) {
+	var cart ParkedCart
+	err := m.collection.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&cart)
+	return cart, notFound(err)
+}
+
+type mongoStandingOrders struct{ collection *mongo.Collection }
+
+func (m mongoStandingOrders) Insert(ctx context.Context, standing StandingOrder) error {
+	_, err := m.collection.InsertOne(ctx, standing)
+	return err
+}
+
+func (m mongoStandingOrders) Find(ctx context.Context, id primitive.ObjectID) (StandingOrder, error) {
+	var standing StandingOrder
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&standing)
+	return standing, notFound(err)
+}
+
+func (m mongoStandingOrders) List(ctx context.Context) ([]StandingOrder, error) {
+	return findAll[StandingOrder](ctx, m.collection, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+}
+
+func (m mongoStandingOrders) Update(ctx context.Context, standing StandingOrder) error {
+	filter := atVersion(bson.M{"_id": standing.ID}, standing.Version)
+	standing.Version++
+	result, err := m.collection.ReplaceOne(ctx, filter, standing)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return versionMissed(ctx, m.collection, bson.M{"_id": standing.ID})
+	}
+	return nil
+}
+
+type mongoContainers struct{ collection *mongo.Collection }
+
+func (m mongoContainers) Insert(ctx context.Context, loan ContainerLoan) error {
+	_, err := m.collection.InsertOne(ctx, loan)
+	return duplicate(err)
+}
+
+func (m mongoContainers) Find(ctx context.Context, orderID primitive.ObjectID) (ContainerLoan, error) {
+	var loan ContainerLoan
+	err := m.collection.FindOne(ctx, bson.M{"_id": orderID}).Decode(&loan)
+	return loan, notFound(err)
+}
+
+func (m mongoContainers) Update(ctx context.Context, loan ContainerLoan) error {
+	filter := atVersion(bson.M{"_id": loan.ID}, loan.Version)
+	loan.Version++
+	result, err := m.collection.ReplaceOne(ctx, filter, loan)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return versionMissed(ctx, m.collection, bson.M{"_id": loan.ID})
+	}
+	return nil
+}
+
+func (m mongoContainers) ListOutstanding(ctx context.Context) ([]ContainerLoan, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "lentAt", Value: 1}})
+	return findAll[ContainerLoan](ctx, m.collection, bson.M{"outstanding": bson.M{"$gt": 0}}, opts)
+}
+
+type mongoDeliveryZones struct{ collection *mongo.Collection }
+
+func (m mongoDeliveryZones) Save(ctx context.Context, zone DeliveryZone) error {
+	_, err := m.collection.ReplaceOne(ctx, bson.M{"name": zone.Name}, zone, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m mongoDeliveryZones) List(ctx context.Context) ([]DeliveryZone, error) {
+	return findAll[DeliveryZone](ctx, m.collection, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+}
+
+func (m mongoDeliveryZones) Delete(ctx context.Context, name string) error {
+	result, err := m.collection.DeleteOne(ctx, bson.M{"name": name})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type mongoDrivers struct{ collections, settlements *mongo.Collection }
+
+func (m mongoDrivers) AddCollection(ctx context.Context, collection CODCollection) error {
+	_, err := m.collections.InsertOne(ctx, collection)
+	return duplicate(err)
+}
+
+func (m mongoDrivers) ListUnsettled(ctx context.Context) ([]CODCollection, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "collectedAt", Value: 1}})
+	return findAll[CODCollection](ctx, m.collections, bson.M{"settlementId": bson.M{"$exists": false}}, opts)
+}
+
+func (m mongoDrivers) Claim(ctx context.Context, driver string, settlementID primitive.ObjectID, upTo time.Time) ([]CODCollection, error) {
+	filter := bson.M{"settlementId": bson.M{"$exists": false}, "driver": driver, "collectedAt": bson.M{"$lte": upTo}}
+	if _, err := m.collections.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"settlementId": settlementID}}); err != nil {
+		return nil, err
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "collectedAt", Value: 1}})
+	return findAll[CODCollection](ctx, m.collections, bson.M{"settlementId": settlementID}, opts)
+}
+
+func (m mongoDrivers) Release(ctx context.Context, settlementID primitive.ObjectID) error {
+	_, err := m.collections.UpdateMany(ctx, bson.M{"settlementId": settlementID}, bson.M{"$unset": bson.M{"settlementId": ""}})
+	return err
+}
+
+func (m mongoDrivers) AddSettlement(ctx context.Context, settlement DriverSettlement) error {
+	_, err := m.settlements.InsertOne(ctx, settlement)
+	return err
+}
+
+func (m mongoDrivers) ListSettlements(ctx context.Context, from, to time.Time) ([]DriverSettlement, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "settledAt", Value: 1}})
+	return findAll[DriverSettlement](ctx, m.settlements, bson.M{"settledAt": bson.M{"$gte": from, "$lt": to}}, opts)
+}
+
+type mongoComplaints struct{ collection *mongo.Collection }
+
+func (m mongoComplaints) Insert(ctx context.Context, complaint Complaint) error {
+	_, err := m.collection.InsertOne(ctx, complaint)
+	return err
+}
+
+func (m mongoComplaints) Find(ctx context.Context, id primitive.ObjectID) (Complaint, error) {
+	var complaint Complaint
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&complaint)
+	return complaint, notFound(err)
+}
+
+func (m mongoComplaints) Update(ctx context.Context, complaint Complaint) error {
+	filter := atVersion(bson.M{"_id": complaint.ID}, complaint.Version)
+	complaint.Version++
+	result, err := m.collection.ReplaceOne(ctx, filter, complaint)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return versionMissed(ctx, m.collection, bson.M{"_id": complaint.ID})
+	}
+	return nil
+}
+
+func (m mongoComplaints) ListBetween(ctx context.Context, from, to time.Time) ([]Complaint, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	return findAll[Complaint](ctx, m.collection, bson.M{"createdAt": bson.M{"$gte": from, "$lt": to}}, opts)
+}
+
+type mongoSurveys struct{ collection *mongo.Collection }
+
+func (m mongoSurveys) Insert(ctx context.Context, survey Survey) error {
+	_, err := m.collection.InsertOne(ctx, survey)
+	return duplicate(err)
+}
+
+func (m mongoSurveys) FindByToken(ctx context.Context, token string) (Survey, error) {
+	var survey Survey
+	err := m.collection.FindOne(ctx, bson.M{"token": token}).Decode(&survey)
+	return survey, notFound(err)
+}
+
+func (m mongoSurveys) FindByOrder(ctx context.Context, id primitive.ObjectID) (Survey, error) {
+	var survey Survey
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&survey)
+	return survey, notFound(err)
+}
+
+func (m mongoSurveys) Answer(ctx context.Context, survey Survey) error {
+	result, err := m.collection.ReplaceOne(ctx, bson.M{"_id": survey.ID, "answeredAt": bson.M{"$exists": false}}, survey)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m mongoSurveys) ListBetween(ctx context.Context, from, to time.Time) ([]Survey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "sentAt", Value: 1}})
+	return findAll[Survey](ctx, m.collection, bson.M{"sentAt": bson.M{"$gte": from, "$lt": to}}, opts)
+}
+
+type mongoNotificationQueue struct{ collection *mongo.Collection }
+
+func (m mongoNotificationQueue) Add(ctx context.Context, queued QueuedNotification) error {
+	_, err := m.collection.InsertOne(ctx, queued)
+	return err
+}
+
+func (m mongoNotificationQueue) ListDue(ctx context.Context, now time.Time) ([]QueuedNotification, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "sendAfter", Value: 1}})
+	return findAll[QueuedNotification](ctx, m.collection, bson.M{"sendAfter": bson.M{"$lte": now}}, opts)
+}
+
+func (m mongoNotificationQueue) Remove(ctx context.Context, id primitive.ObjectID) error {
+	result, err := m.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type mongoTemplates struct{ collection *mongo.Collection }
+
+func (m mongoTemplates) Insert(ctx context.Context, tmpl MessageTemplate) error {
+	_, err := m.collection.InsertOne(ctx, tmpl)
+	return duplicate(err)
+}
+
+func (m mongoTemplates) Latest(ctx context.Context, name string) (MessageTemplate, error) {
+	var found MessageTemplate
+	opts := options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}})
+	err := m.collection.FindOne(ctx, bson.M{"name": name}, opts).Decode(&found)
+	return found, notFound(err)
+}
+
+func (m mongoTemplates) Find(ctx context.Context, name string, version int) (MessageTemplate, error) {
+	var found MessageTemplate
+	err := m.collection.FindOne(ctx, bson.M{"name": name, "version": version}).Decode(&found)
+	return found, notFound(err)
+}
+
+func (m mongoTemplates) List(ctx context.Context, name string) ([]MessageTemplate, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}})
+	return findAll[MessageTemplate](ctx, m.collection, bson.M{"name": name}, opts)
+}
+
+type mongoPrintJobs struct{ collection *mongo.Collection }
+
+func (m mongoPrintJobs) Add(ctx context.Context, job PrintJob) error {
+	job.Version = 1
+	_, err := m.collection.InsertOne(ctx, job)
+	return err
+}
+
+func (m mongoPrintJobs) Find(ctx context.Context, id primitive.ObjectID) (PrintJob, error) {
+	var job PrintJob
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	return job, notFound(err)
+}
+
+func (m mongoPrintJobs) Update(ctx context.Context, job PrintJob) error {
+	filter := atVersion(bson.M{"_id": job.ID}, job.Version)
+	job.Version++
+	result, err := m.collection.ReplaceOne(ctx, filter, job)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return versionMissed(ctx, m.collection, bson.M{"_id": job.ID})
+	}
+	return nil
+}
+
+func (m mongoPrintJobs) ListDue(ctx context.Context, now time.Time) ([]PrintJob, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	return findAll[PrintJob](ctx, m.collection, bson.M{"status": PrintQueued, "nextAttemptAt": bson.M{"$lte": now}}, opts)
+}
+
+func (m mongoPrintJobs) ListSince(ctx context.Context, since time.Time) ([]PrintJob, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	return findAll[PrintJob](ctx, m.collection, bson.M{"createdAt": bson.M{"$gte": since}}, opts)
+}
+
+type mongoFXExchanges struct{ collection *mongo.Collection }
+
+func (m mongoFXExchanges) Add(ctx context.Context, exchange FXExchange) error {
+	_, err := m.collection.InsertOne(ctx, exchange)
+	return err
+}
+
+func (m mongoFXExchanges) ListBetween(ctx context.Context, from, to time.Time) ([]FXExchange, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	return findAll[FXExchange](ctx, m.collection, bson.M{"createdAt": bson.M{"$gte": from, "$lt": to}}, opts)
+}
+
+type mongoLogBook struct{ collection *mongo.Collection }
+
+func (m mongoLogBook) Insert(ctx context.Context, entry LogEntry) error {
+	_, err := m.collection.InsertOne(ctx, entry)
+	return err
+}
+
+func (m mongoLogBook) Find(ctx context.Context, id primitive.ObjectID) (LogEntry, error) {
+	var entry LogEntry
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&entry)
+	return entry, notFound(err)
+}
+
+func (m mongoLogBook) Update(ctx context.Context, entry LogEntry) error {
+	filter := atVersion(bson.M{"_id": entry.ID}, entry.Version)
+	entry.Version++
+	result, err := m.collection.ReplaceOne(ctx, filter, entry)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return versionMissed(ctx, m.collection, bson.M{"_id": entry.ID})
+	}
+	return nil
+}
+
+func (m mongoLogBook) ListBetween(ctx context.Context, from, to string) ([]LogEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "createdAt", Value: 1}})
+	return findAll[LogEntry](ctx, m.collection, bson.M{"date": bson.M{"$gte": from, "$lt": to}}, opts)
+}
+
+func (m mongoLogBook) ListOpen(ctx context.Context) ([]LogEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	return findAll[LogEntry](ctx, m.collection, bson.M{"kind": LogFollowUp, "doneAt": nil}, opts)
+}
+
+type mongoBudgets struct{ collection *mongo.Collection }
+
+func (m mongoBudgets) Save(ctx context.Context, budget Budget) error {
+	_, err := m.collection.ReplaceOne(ctx, bson.M{"month": budget.Month}, budget, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m mongoBudgets) Find(ctx context.Context, month string) (Budget, error) {
+	var budget Budget
+	err := m.collection.FindOne(ctx, bson.M{"month": month}).Decode(&budget)
+	return budget, notFound(err)
+}
+
+func (m mongoBudgets) List(ctx context.Context) ([]Budget, error) {
+	return findAll[Budget](ctx, m.collection, bson.M{}, options.Find().SetSort(bson.D{{Key: "month", Value: -1}}))
+}
+
+// mongoChanges follows the orders and payments collections with a change stream. MongoDB only offers change
+// streams on replica sets and sharded clusters; a single replica set member is enough.
+type mongoChanges struct{ db *mongo.Database }
+
+// mongoChangeEvent is the part of a change stream event the feed uses
+type mongoChangeEvent struct {
+	Namespace struct {
+		Collection string `bson:"coll"`
+	} `bson:"ns"`
+	FullDocument bson.Raw `bson:"fullDocument"`
+}
+
+func (m mongoChanges) Watch(ctx context.Context, token string, changes chan<- Change) error {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"ns.coll":       bson.M{"$in": bson.A{"orders", "payments"}},
+		"operationType": bson.M{"$in": bson.A{"insert", "update", "replace"}},
+	}}}}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if token != "" {
+		opts.SetResumeAfter(bson.M{"_data": token})
+	}
+	stream, err := m.db.Watch(ctx, pipeline, opts)
+	if err != nil {
+		return resumeFailed(err, token)
+	}
+	defer stream.Close(context.Background())
+	for stream.Next(ctx) {
+		var event mongoChangeEvent
+		if err := stream.Decode(&event); err != nil {
+			return err
+		}
+		// An update to a document deleted before it could be looked up has nothing to send
+		if event.FullDocument == nil {
+			continue
+		}
+		resume, _ := stream.ResumeToken().Lookup("_data").StringValueOK()
+		change := Change{Token: resume, Kind: ChangeOrder}
+		if event.Namespace.Collection == "payments" {
+			change.Kind, change.Payment = ChangePayment, &Payment{}
+			err = bson.Unmarshal(event.FullDocument, change.Payment)
+		} else {
+			change.Order = &Order{}
+			err = bson.Unmarshal(event.FullDocument, change.Order)
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case changes <- change:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return resumeFailed(stream.Err(), token)
+}
+
+// resumeFailed translates the errors MongoDB gives for a resume token it cannot use to ErrResumeToken
+func resumeFailed(err error, token string) error {
+	// InvalidResumeToken, ChangeStreamFatalError and ChangeStreamHistoryLost
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && (serverErr.HasErrorCode(260) || serverErr.HasErrorCode(280) || serverErr.HasErrorCode(286)) {
+		return fmt.Errorf("%w: %q: %v", ErrResumeToken, token, err)
+	}
+	return err
+}
@@ -0,0 +1,2357 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the "pgx" driver
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqlStore keeps each document as JSON in a doc column, next to the columns that are queried
+// or indexed. New fields on the models therefore need no schema change; only new query
+// columns do, through a migration.
+type sqlStore struct {
+	db       *sql.DB
+	postgres bool
+	schema   string // Postgres schema holding the tables, created by Migrate; empty for the default
+}
+
+// sqlMigration is one schema change, applied once and recorded in schema_migrations
+type sqlMigration struct {
+	version    int
+	statements []string
+}
+
+// sqlMigrations is the schema history shared by SQLite and Postgres. Append new versions; never edit old ones.
+var sqlMigrations = []sqlMigration{
+	{1, []string{
+		`CREATE TABLE menu_items (name TEXT PRIMARY KEY, position BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE TABLE customers (id TEXT PRIMARY KEY, name TEXT NOT NULL, phone TEXT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX customers_name ON customers (name)`,
+		`CREATE TABLE orders (id TEXT PRIMARY KEY, status TEXT NOT NULL, type TEXT NOT NULL, token INTEGER NOT NULL, created_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX orders_status ON orders (status, created_at)`,
+		`CREATE INDEX orders_created_at ON orders (created_at)`,
+		`CREATE TABLE dining_tables (number INTEGER PRIMARY KEY, doc TEXT NOT NULL)`,
+		`CREATE TABLE payments (id TEXT PRIMARY KEY, order_id TEXT NOT NULL, created_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX payments_order ON payments (order_id)`,
+		`CREATE TABLE counters (name TEXT PRIMARY KEY, value BIGINT NOT NULL)`,
+		`CREATE TABLE sync_conflicts (id TEXT PRIMARY KEY, created_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+	}},
+	{2, []string{
+		`ALTER TABLE orders ADD COLUMN version INTEGER NOT NULL DEFAULT 0`,
+		`CREATE TABLE order_events (id TEXT PRIMARY KEY, order_id TEXT NOT NULL, seq INTEGER NOT NULL, at BIGINT NOT NULL, doc TEXT NOT NULL, UNIQUE (order_id, seq))`,
+		`CREATE INDEX order_events_at ON order_events (at)`,
+	}},
+	{3, []string{
+		`ALTER TABLE order_events ADD COLUMN published_at BIGINT`,
+		`CREATE INDEX order_events_unpublished ON order_events (published_at, at)`,
+	}},
+	{4, []string{
+		`CREATE TABLE tenants (id TEXT PRIMARY KEY, doc TEXT NOT NULL)`,
+		`CREATE TABLE api_keys (id TEXT PRIMARY KEY, tenant_id TEXT NOT NULL, hash TEXT NOT NULL UNIQUE, doc TEXT NOT NULL)`,
+	}},
+	{5, []string{
+		`CREATE INDEX api_keys_tenant ON api_keys (tenant_id)`,
+		`CREATE TABLE api_key_usage (id TEXT PRIMARY KEY, key_id TEXT NOT NULL, at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX api_key_usage_key ON api_key_usage (key_id, at)`,
+	}},
+	{6, []string{
+		`CREATE TABLE sold_out (id TEXT PRIMARY KEY, at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX sold_out_at ON sold_out (at)`,
+	}},
+	{7, []string{
+		`CREATE TABLE price_changes (id TEXT PRIMARY KEY, item TEXT NOT NULL, at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX price_changes_item ON price_changes (item, at)`,
+	}},
+	{8, []string{
+		`CREATE TABLE menu_versions (version INTEGER PRIMARY KEY, published_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE TABLE menu_draft (id INTEGER PRIMARY KEY, doc TEXT NOT NULL)`,
+	}},
+	{9, []string{
+		`CREATE TABLE invoices (id TEXT PRIMARY KEY, number TEXT NOT NULL UNIQUE, financial_year TEXT NOT NULL, seq INTEGER NOT NULL, order_id TEXT NOT NULL UNIQUE, issued_at BIGINT NOT NULL, doc TEXT NOT NULL, UNIQUE (financial_year, seq))`,
+		`CREATE INDEX invoices_issued_at ON invoices (issued_at)`,
+	}},
+	{10, []string{
+		`CREATE INDEX payments_created_at ON payments (created_at)`,
+	}},
+	{11, []string{
+		// open_key is 1 while a session is open and NULL once closed, so only one can be open
+		`CREATE TABLE drawer_sessions (id TEXT PRIMARY KEY, open_key INTEGER UNIQUE, opened_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX drawer_sessions_opened_at ON drawer_sessions (opened_at)`,
+	}},
+	{12, []string{
+		// open_cashier is the cashier while the shift is open and NULL once closed, so each cashier has one open at most
+		`CREATE TABLE shifts (id TEXT PRIMARY KEY, open_cashier TEXT UNIQUE, opened_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX shifts_opened_at ON shifts (opened_at)`,
+	}},
+	{13, []string{
+		// open_employee is the employee while they are clocked in and NULL once they clock out
+		`CREATE TABLE time_entries (id TEXT PRIMARY KEY, open_employee TEXT UNIQUE, clock_in BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX time_entries_clock_in ON time_entries (clock_in)`,
+	}},
+	{14, []string{
+		`CREATE TABLE reservations (id TEXT PRIMARY KEY, at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX reservations_at ON reservations (at)`,
+	}},
+	{15, []string{
+		`CREATE TABLE banquets (id TEXT PRIMARY KEY, start_at BIGINT NOT NULL, end_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX banquets_start_at ON banquets (start_at)`,
+	}},
+	{16, []string{
+		`CREATE TABLE quotes (id TEXT PRIMARY KEY, token TEXT NOT NULL UNIQUE, created_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX quotes_created_at ON quotes (created_at)`,
+	}},
+	{17, []string{
+		`CREATE TABLE credit_accounts (id TEXT PRIMARY KEY, name TEXT NOT NULL UNIQUE, doc TEXT NOT NULL)`,
+		`CREATE TABLE account_entries (id TEXT PRIMARY KEY, account_id TEXT NOT NULL, created_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX account_entries_account_id ON account_entries (account_id, created_at)`,
+	}},
+	{18, []string{
+		`CREATE TABLE companies (id TEXT PRIMARY KEY, name TEXT NOT NULL UNIQUE, doc TEXT NOT NULL)`,
+		`CREATE TABLE company_invoices (id TEXT PRIMARY KEY, number TEXT NOT NULL UNIQUE, financial_year TEXT NOT NULL, seq INTEGER NOT NULL, company_id TEXT NOT NULL, month TEXT NOT NULL, doc TEXT NOT NULL, UNIQUE (financial_year, seq), UNIQUE (company_id, month))`,
+	}},
+	{19, []string{
+		`CREATE TABLE idempotency_keys (idempotency_key TEXT PRIMARY KEY, created_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX idempotency_keys_created_at ON idempotency_keys (created_at)`,
+	}},
+	{20, []string{
+		`CREATE INDEX customers_phone ON customers (phone)`,
+	}},
+	{21, []string{
+		`CREATE TABLE coupons (id TEXT PRIMARY KEY, code TEXT NOT NULL UNIQUE, customer_name TEXT NOT NULL, occasion TEXT NOT NULL, year INTEGER NOT NULL, doc TEXT NOT NULL, UNIQUE (customer_name, occasion, year))`,
+	}},
+	{22, []string{
+		// open_table is the table number while the party is seated and NULL once they leave
+		`CREATE TABLE table_sittings (id TEXT PRIMARY KEY, open_table INTEGER UNIQUE, seated_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX table_sittings_seated_at ON table_sittings (seated_at)`,
+	}},
+	{23, []string{
+		`CREATE TABLE stock_levels (name TEXT PRIMARY KEY, doc TEXT NOT NULL)`,
+	}},
+	{24, []string{
+		`CREATE TABLE stock_write_offs (id TEXT PRIMARY KEY, written_off_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX stock_write_offs_written_off_at ON stock_write_offs (written_off_at)`,
+	}},
+	{25, []string{
+		// Stock is kept per branch; what was stocked before belongs to the main, unnamed, branch
+		`CREATE TABLE branch_stock (branch TEXT NOT NULL, name TEXT NOT NULL, doc TEXT NOT NULL, PRIMARY KEY (branch, name))`,
+		`INSERT INTO branch_stock (branch, name, doc) SELECT '', name, doc FROM stock_levels`,
+		`DROP TABLE stock_levels`,
+		`ALTER TABLE stock_write_offs ADD COLUMN branch TEXT NOT NULL DEFAULT ''`,
+		`CREATE INDEX stock_write_offs_branch ON stock_write_offs (branch, written_off_at)`,
+		`CREATE TABLE stock_transfers (id TEXT PRIMARY KEY, from_branch TEXT NOT NULL, to_branch TEXT NOT NULL, created_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX stock_transfers_created_at ON stock_transfers (created_at)`,
+	}},
+	{26, []string{
+		`CREATE TABLE branch_menus (branch TEXT PRIMARY KEY, doc TEXT NOT NULL)`,
+	}},
+	{27, []string{
+		`CREATE TABLE refunds (id TEXT PRIMARY KEY, order_id TEXT NOT NULL, created_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX refunds_order_id ON refunds (order_id)`,
+		`CREATE INDEX refunds_created_at ON refunds (created_at)`,
+	}},
+	{28, []string{
+		`CREATE TABLE two_factor (key_id TEXT PRIMARY KEY, doc TEXT NOT NULL)`,
+		`CREATE TABLE sessions (id TEXT PRIMARY KEY, key_id TEXT NOT NULL, hash TEXT NOT NULL UNIQUE, expires_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX sessions_key_id ON sessions (key_id)`,
+	}},
+	{29, []string{
+		`CREATE TABLE devices (id TEXT PRIMARY KEY, tenant_id TEXT NOT NULL, name TEXT NOT NULL, hash TEXT NOT NULL UNIQUE, doc TEXT NOT NULL, UNIQUE (tenant_id, name))`,
+	}},
+	{30, []string{
+		// table_key is NULL for carts without a table, so only tables are kept to one parked cart
+		`CREATE TABLE parked_carts (id TEXT PRIMARY KEY, table_key INTEGER UNIQUE, expires_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+	}},
+	{31, []string{
+		`CREATE TABLE standing_orders (id TEXT PRIMARY KEY, doc TEXT NOT NULL)`,
+	}},
+	{32, []string{
+		`CREATE TABLE container_loans (id TEXT PRIMARY KEY, outstanding INTEGER NOT NULL, lent_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+	}},
+	{33, []string{
+		`CREATE TABLE delivery_zones (name TEXT PRIMARY KEY, doc TEXT NOT NULL)`,
+	}},
+	{34, []string{
+		`CREATE TABLE cod_collections (id TEXT PRIMARY KEY, driver TEXT NOT NULL, settlement_id TEXT NOT NULL, collected_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX cod_collections_unsettled ON cod_collections (settlement_id, driver, collected_at)`,
+		`CREATE TABLE driver_settlements (id TEXT PRIMARY KEY, settled_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX driver_settlements_settled_at ON driver_settlements (settled_at)`,
+	}},
+	{35, []string{
+		`CREATE TABLE complaints (id TEXT PRIMARY KEY, created_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX complaints_created_at ON complaints (created_at)`,
+	}},
+	{36, []string{
+		`CREATE TABLE surveys (id TEXT PRIMARY KEY, token TEXT NOT NULL UNIQUE, answered_at BIGINT NOT NULL, sent_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX surveys_sent_at ON surveys (sent_at)`,
+	}},
+	{37, []string{
+		`CREATE TABLE notification_queue (id TEXT PRIMARY KEY, send_after BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX notification_queue_send_after ON notification_queue (send_after)`,
+	}},
+	{38, []string{
+		`CREATE TABLE message_templates (name TEXT NOT NULL, version INTEGER NOT NULL, doc TEXT NOT NULL, PRIMARY KEY (name, version))`,
+	}},
+	{39, []string{
+		`CREATE TABLE print_jobs (id TEXT PRIMARY KEY, status TEXT NOT NULL, next_attempt_at BIGINT NOT NULL, created_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX print_jobs_due ON print_jobs (status, next_attempt_at)`,
+		`CREATE INDEX print_jobs_created_at ON print_jobs (created_at)`,
+	}},
+	{40, []string{
+		`CREATE TABLE fx_exchanges (id TEXT PRIMARY KEY, created_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX fx_exchanges_created_at ON fx_exchanges (created_at)`,
+	}},
+	{41, []string{
+		// Orders stored before this were last changed, as far as exports know, when they were placed
+		`ALTER TABLE orders ADD COLUMN updated_at BIGINT NOT NULL DEFAULT 0`,
+		`UPDATE orders SET updated_at = created_at`,
+		`CREATE INDEX orders_updated_at ON orders (updated_at, id)`,
+		`CREATE INDEX payments_created_at_id ON payments (created_at, id)`,
+	}},
+	{42, []string{
+		// Served and paid orders past RMS_ARCHIVE_AFTER, and their events, moved out of the live tables
+		`CREATE TABLE orders_archive (id TEXT PRIMARY KEY, created_at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX orders_archive_created_at ON orders_archive (created_at)`,
+		`CREATE TABLE order_events_archive (id TEXT PRIMARY KEY, order_id TEXT NOT NULL, seq INTEGER NOT NULL, at BIGINT NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX order_events_archive_order ON order_events_archive (order_id, seq)`,
+	}},
+	{43, []string{
+		`CREATE TABLE log_book (id TEXT PRIMARY KEY, date TEXT NOT NULL, created_at BIGINT NOT NULL, pending INTEGER NOT NULL, doc TEXT NOT NULL)`,
+		`CREATE INDEX log_book_date ON log_book (date, created_at)`,
+		`CREATE INDEX log_book_pending ON log_book (pending, created_at)`,
+	}},
+	{44, []string{
+		`CREATE TABLE budgets (month TEXT PRIMARY KEY, doc TEXT NOT NULL)`,
+	}},
+}
+
+// OpenSQLStore connects to SQLite ("sqlite" driver, dsn is a file path) or Postgres ("pgx" driver, dsn is a URL)
+func OpenSQLStore(driverName, dsn string) (Store, error) {
+	return openSQLStore(driverName, dsn, "")
+}
+
+func openSQLStore(driverName, dsn, schema string) (Store, error) {
+	s := &sqlStore{postgres: driverName == "pgx", schema: schema}
+	source := dsn
+	if !s.postgres {
+		// Wait on locks instead of failing, and take the write lock when a transaction starts
+		source += "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	}
+	db, err := sql.Open(driverName, source)
+	if err != nil {
+		return nil, err
+	}
+	if !s.postgres {
+		// SQLite allows one writer at a time; a single connection keeps transactions from tripping over each other
+		db.SetMaxOpenConns(1)
+	}
+	s.db = db
+
+	if err := db.PingContext(context.TODO()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if s.postgres {
+		notify("Connected to Postgres!")
+	} else {
+		notify("Opened SQLite database", dsn)
+	}
+	return s, nil
+}
+
+func (s *sqlStore) Menu() MenuRepository                  { return sqlMenu{s} }
+func (s *sqlStore) MenuVersions() MenuVersionRepository   { return sqlMenuVersions{s} }
+func (s *sqlStore) Customers() CustomerRepository         { return sqlCustomers{s} }
+func (s *sqlStore) Orders() OrderRepository               { return sqlOrders{s} }
+func (s *sqlStore) Tables() TableRepository               { return sqlTables{s} }
+func (s *sqlStore) Payments() PaymentRepository           { return sqlPayments{s} }
+func (s *sqlStore) Counters() CounterRepository           { return sqlCounters{s} }
+func (s *sqlStore) SyncConflicts() SyncConflictRepository { return sqlSyncConflicts{s} }
+func (s *sqlStore) Events() EventRepository               { return sqlEvents{s} }
+func (s *sqlStore) Tenants() TenantRepository             { return sqlTenants{s} }
+func (s *sqlStore) APIKeys() APIKeyRepository             { return sqlAPIKeys{s} }
+func (s *sqlStore) Invoices() InvoiceRepository           { return sqlInvoices{s} }
+func (s *sqlStore) Drawers() DrawerRepository             { return sqlDrawers{s} }
+func (s *sqlStore) Shifts() ShiftRepository               { return sqlShifts{s} }
+func (s *sqlStore) TimeClock() TimeClockRepository        { return sqlTimeClock{s} }
+func (s *sqlStore) Reservations() ReservationRepository   { return sqlReservations{s} }
+func (s *sqlStore) Banquets() BanquetRepository           { return sqlBanquets{s} }
+func (s *sqlStore) Quotes() QuoteRepository               { return sqlQuotes{s} }
+func (s *sqlStore) CreditAccounts() CreditAccountRepository {
+	return sqlCreditAccounts{s}
+}
+func (s *sqlStore) Companies() CompanyRepository { return sqlCompanies{s} }
+func (s *sqlStore) Idempotency() IdempotencyRepository {
+	return sqlIdempotency{s}
+}
+func (s *sqlStore) Coupons() CouponRepository   { return sqlCoupons{s} }
+func (s *sqlStore) Sittings() SittingRepository { return sqlSittings{s} }
+func (s *sqlStore) Stock() StockRepository      { return sqlStock{s} }
+func (s *sqlStore) Transfers() TransferRepository {
+	return sqlTransfers{s}
+}
+func (s *sqlStore) BranchMenus() BranchMenuRepository {
+	return sqlBranchMenus{s}
+}
+func (s *sqlStore) Refunds() RefundRepository                      { return sqlRefunds{s} }
+func (s *sqlStore) TwoFactor() TwoFactorRepository                 { return sqlTwoFactor{s} }
+func (s *sqlStore) Sessions() SessionRepository                    { return sqlSessions{s} }
+func (s *sqlStore) Devices() DeviceRepository                      { return sqlDevices{s} }
+func (s *sqlStore) ParkedCarts() ParkedCartRepository              { return sqlParkedCarts{s} }
+func (s *sqlStore) StandingOrders() StandingOrderRepository        { return sqlStandingOrders{s} }
+func (s *sqlStore) Containers() ContainerRepository                { return sqlContainers{s} }
+func (s *sqlStore) DeliveryZones() DeliveryZoneRepository          { return sqlDeliveryZones{s} }
+func (s *sqlStore) Drivers() DriverRepository                      { return sqlDrivers{s} }
+func (s *sqlStore) Complaints() ComplaintRepository                { return sqlComplaints{s} }
+func (s *sqlStore) Surveys() SurveyRepository                      { return sqlSurveys{s} }
+func (s *sqlStore) NotificationQueue() NotificationQueueRepository { return sqlNotificationQueue{s} }
+func (s *sqlStore) Templates() TemplateRepository                  { return sqlTemplates{s} }
+func (s *sqlStore) PrintJobs() PrintJobRepository                  { return sqlPrintJobs{s} }
+func (s *sqlStore) FXExchanges() FXExchangeRepository              { return sqlFXExchanges{s} }
+func (s *sqlStore) LogBook() LogBookRepository                     { return sqlLogBook{s} }
+func (s *sqlStore) Budgets() BudgetRepository                      { return sqlBudgets{s} }
+func (s *sqlStore) Changes() ChangeRepository                      { return sqlChanges{s} }
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlStore) Unavailable(err error) bool {
+	var netErr net.Error
+	return err != nil && (errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr))
+}
+
+func (s *sqlStore) Close(ctx context.Context) error {
+	return s.db.Close()
+}
+
+// Migrate applies every migration that has not run yet, each in its own transaction
+func (s *sqlStore) Migrate(ctx context.Context) error {
+	if s.schema != "" {
+		if _, err := s.db.ExecContext(ctx, `CREATE SCHEMA IF NOT EXISTS `+s.schema); err != nil {
+			return err
+		}
+	}
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, applied_at BIGINT NOT NULL)`)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range sqlMigrations {
+		var applied int
+		err := s.db.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`), migration.version).Scan(&applied)
+		if err != nil {
+			return err
+		}
+		if applied > 0 {
+			continue
+		}
+
+		err = s.inTx(ctx, func(tx *sql.Tx) error {
+			for _, statement := range migration.statements {
+				if _, err := tx.ExecContext(ctx, statement); err != nil {
+					return fmt.Errorf("migration %d: %w", migration.version, err)
+				}
+			}
+			_, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`), migration.version, time.Now().UnixNano())
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebind turns ? placeholders into Postgres's $1, $2...
+func (s *sqlStore) rebind(query string) string {
+	if !s.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// forUpdate locks the selected rows until the transaction ends. SQLite already holds the write lock.
+func (s *sqlStore) forUpdate() string {
+	if s.postgres {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+// inTx runs fn in a transaction, committing if it returns nil
+func (s *sqlStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// queryDocs decodes the doc column of every row the query returns
+func queryDocs[T any](ctx context.Context, s *sqlStore, q queryer, query string, args ...any) ([]T, error) {
+	rows, err := q.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []T
+	for rows.Next() {
+		var doc string
+		if err := rows.Scan(&doc); err != nil {
+			return nil, err
+		}
+		var v T
+		if err := json.Unmarshal([]byte(doc), &v); err != nil {
+			return nil, err
+		}
+		results = append(results, v)
+	}
+	return results, rows.Err()
+}
+
+// queryDoc decodes the doc column of the first row, returning ErrNotFound if there is none
+func queryDoc[T any](ctx context.Context, s *sqlStore, q queryer, query string, args ...any) (T, error) {
+	var v T
+	var doc string
+	err := q.QueryRowContext(ctx, s.rebind(query), args...).Scan(&doc)
+	if err == sql.ErrNoRows {
+		return v, ErrNotFound
+	}
+	if err != nil {
+		return v, err
+	}
+	return v, json.Unmarshal([]byte(doc), &v)
+}
+
+// marshalDoc encodes a model for the doc column
+func marshalDoc(v any) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// isUniqueViolation reports whether err is a primary key or unique constraint failure
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505"
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	}
+	return false
+}
+
+// expectRow returns ErrNotFound when an update or delete touched no rows
+func expectRow(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type sqlMenu struct{ s *sqlStore }
+
+func (m sqlMenu) List(ctx context.Context) ([]MenuItem, error) {
+	return queryDocs[MenuItem](ctx, m.s, m.s.db, `SELECT doc FROM menu_items ORDER BY position`)
+}
+
+func (m sqlMenu) Add(ctx context.Context, item MenuItem) error {
+	item.Version = 1
+	doc, err := marshalDoc(item)
+	if err != nil {
+		return err
+	}
+	_, err = m.s.db.ExecContext(ctx, m.s.rebind(`INSERT INTO menu_items (name, position, doc) VALUES (?, (SELECT COALESCE(MAX(position), 0) + 1 FROM menu_items), ?)`), item.Name, doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (m sqlMenu) Update(ctx context.Context, item MenuItem) error {
+	return m.s.inTx(ctx, func(tx *sql.Tx) error {
+		stored, err := queryDoc[MenuItem](ctx, m.s, tx, `SELECT doc FROM menu_items WHERE name = ?`+m.s.forUpdate(), item.Name)
+		if err != nil {
+			return err
+		}
+		if stored.Version != item.Version {
+			return ErrVersionConflict
+		}
+		item.Version++
+		doc, err := marshalDoc(item)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, m.s.rebind(`UPDATE menu_items SET doc = ? WHERE name = ?`), doc, item.Name)
+		return err
+	})
+}
+
+func (m sqlMenu) Delete(ctx context.Context, name string) error {
+	return expectRow(m.s.db.ExecContext(ctx, m.s.rebind(`DELETE FROM menu_items WHERE name = ?`), name))
+}
+
+func (m sqlMenu) LogSoldOut(ctx context.Context, record SoldOut) error {
+	doc, err := marshalDoc(record)
+	if err != nil {
+		return err
+	}
+	_, err = m.s.db.ExecContext(ctx, m.s.rebind(`INSERT INTO sold_out (id, at, doc) VALUES (?, ?, ?)`), record.ID.Hex(), record.At.UnixNano(), doc)
+	return err
+}
+
+func (m sqlMenu) ListSoldOut(ctx context.Context, from, to time.Time) ([]SoldOut, error) {
+	return queryDocs[SoldOut](ctx, m.s, m.s.db, `SELECT doc FROM sold_out WHERE at >= ? AND at < ? ORDER BY at`, from.UnixNano(), to.UnixNano())
+}
+
+func (m sqlMenu) LogPriceChange(ctx context.Context, change PriceChange) error {
+	doc, err := marshalDoc(change)
+	if err != nil {
+		return err
+	}
+	_, err = m.s.db.ExecContext(ctx, m.s.rebind(`INSERT INTO price_changes (id, item, at, doc) VALUES (?, ?, ?, ?)`),
+		change.ID.Hex(), change.Item, change.At.UnixNano(), doc)
+	return err
+}
+
+func (m sqlMenu) ListPriceChanges(ctx context.Context, item string) ([]PriceChange, error) {
+	return queryDocs[PriceChange](ctx, m.s, m.s.db, `SELECT doc FROM price_changes WHERE item = ? ORDER BY at`, item)
+}
+
+type sqlMenuVersions struct{ s *sqlStore }
+
+func (v sqlMenuVersions) LoadDraft(ctx context.Context) ([]MenuItem, error) {
+	return queryDoc[[]MenuItem](ctx, v.s, v.s.db, `SELECT doc FROM menu_draft WHERE id = 1`)
+}
+
+func (v sqlMenuVersions) SaveDraft(ctx context.Context, items []MenuItem) error {
+	doc, err := marshalDoc(items)
+	if err != nil {
+		return err
+	}
+	_, err = v.s.db.ExecContext(ctx, v.s.rebind(`INSERT INTO menu_draft (id, doc) VALUES (1, ?) ON CONFLICT (id) DO UPDATE SET doc = excluded.doc`), doc)
+	return err
+}
+
+func (v sqlMenuVersions) DeleteDraft(ctx context.Context) error {
+	_, err := v.s.db.ExecContext(ctx, `DELETE FROM menu_draft`)
+	return err
+}
+
+func (v sqlMenuVersions) Publish(ctx context.Context, version MenuVersion) error {
+	doc, err := marshalDoc(version)
+	if err != nil {
+		return err
+	}
+	return v.s.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, v.s.rebind(`INSERT INTO menu_versions (version, published_at, doc) VALUES (?, ?, ?)`),
+			version.Version, version.PublishedAt.UnixNano(), doc)
+		if isUniqueViolation(err) {
+			return ErrDuplicate
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM menu_items`); err != nil {
+			return err
+		}
+		for i, item := range version.Items {
+			doc, err := marshalDoc(item)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, v.s.rebind(`INSERT INTO menu_items (name, position, doc) VALUES (?, ?, ?)`), item.Name, i+1, doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (v sqlMenuVersions) Find(ctx context.Context, version int) (MenuVersion, error) {
+	return queryDoc[MenuVersion](ctx, v.s, v.s.db, `SELECT doc FROM menu_versions WHERE version = ?`, version)
+}
+
+func (v sqlMenuVersions) List(ctx context.Context) ([]MenuVersion, error) {
+	return queryDocs[MenuVersion](ctx, v.s, v.s.db, `SELECT doc FROM menu_versions ORDER BY version DESC`)
+}
+
+func (v sqlMenuVersions) Latest(ctx context.Context) (int, error) {
+	var latest int
+	err := v.s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM menu_versions`).Scan(&latest)
+	return latest, err
+}
+
+type sqlCustomers struct{ s *sqlStore }
+
+func (c sqlCustomers) Add(ctx context.Context, customer Customer) error {
+	customer.Version = 1
+	doc, err := marshalDoc(customer)
+	if err != nil {
+		return err
+	}
+	_, err = c.s.db.ExecContext(ctx, c.s.rebind(`INSERT INTO customers (id, name, phone, doc) VALUES (?, ?, ?, ?)`),
+		primitive.NewObjectID().Hex(), customer.Name, customer.Phone, doc)
+	return err
+}
+
+func (c sqlCustomers) FindByName(ctx context.Context, name string) (Customer, error) {
+	return queryDoc[Customer](ctx, c.s, c.s.db, `SELECT doc FROM customers WHERE name = ? ORDER BY id LIMIT 1`, name)
+}
+
+func (c sqlCustomers) FindByPhone(ctx context.Context, phone string) (Customer, error) {
+	return queryDoc[Customer](ctx, c.s, c.s.db, `SELECT doc FROM customers WHERE phone = ? ORDER BY id LIMIT 1`, phone)
+}
+
+func (c sqlCustomers) List(ctx context.Context) ([]Customer, error) {
+	return queryDocs[Customer](ctx, c.s, c.s.db, `SELECT doc FROM customers ORDER BY id`)
+}
+
+func (c sqlCustomers) Search(ctx context.Context, query string, limit int) ([]Customer, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	return queryDocs[Customer](ctx, c.s, c.s.db,
+		`SELECT doc FROM customers WHERE LOWER(name) LIKE ? ESCAPE '\' OR LOWER(phone) LIKE ? ESCAPE '\' ORDER BY name LIMIT ?`,
+		pattern, pattern, limit)
+}
+
+// update loads the first customer with the name, applies change and saves it at the next version.
+// A version other than 0 must match the stored one.
+func (c sqlCustomers) update(ctx context.Context, name string, version int, change func(*Customer)) error {
+	return c.s.inTx(ctx, func(tx *sql.Tx) error {
+		var id, doc string
+		err := tx.QueryRowContext(ctx, c.s.rebind(`SELECT id, doc FROM customers WHERE name = ? ORDER BY id LIMIT 1`+c.s.forUpdate()), name).Scan(&id, &doc)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		var customer Customer
+		if err := json.Unmarshal([]byte(doc), &customer); err != nil {
+			return err
+		}
+		if version != 0 && customer.Version != version {
+			return ErrVersionConflict
+		}
+		change(&customer)
+		customer.Version++
+		if doc, err = marshalDoc(customer); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, c.s.rebind(`UPDATE customers SET phone = ?, doc = ? WHERE id = ?`), customer.Phone, doc, id)
+		return err
+	})
+}
+
+func (c sqlCustomers) AppendOrderedItems(ctx context.Context, name string, items []string) error {
+	err := c.update(ctx, name, 0, func(customer *Customer) {
+		customer.OrderedItems = append(customer.OrderedItems, items...)
+	})
+	if err == ErrNotFound {
+		return c.Add(ctx, Customer{Name: name, OrderedItems: items})
+	}
+	return err
+}
+
+func (c sqlCustomers) SetTotal(ctx context.Context, name string, total float64) error {
+	err := c.update(ctx, name, 0, func(customer *Customer) {
+		customer.TotalAmount = total
+	})
+	if err == ErrNotFound {
+		// Matches MongoDB, where updating a missing customer is not an error
+		return nil
+	}
+	return err
+}
+
+func (c sqlCustomers) SetPhone(ctx context.Context, name, phone string) error {
+	return c.update(ctx, name, 0, func(customer *Customer) {
+		customer.Phone = phone
+	})
+}
+
+func (c sqlCustomers) SetDietary(ctx context.Context, name string, version int, allergies, diets []string) error {
+	err := c.update(ctx, name, version, func(customer *Customer) {
+		customer.Allergies, customer.Diets = allergies, diets
+	})
+	if err == ErrNotFound && version == 0 {
+		return c.Add(ctx, Customer{Name: name, OrderedItems: []string{}, Allergies: allergies, Diets: diets})
+	}
+	return err
+}
+
+func (c sqlCustomers) SetOccasions(ctx context.Context, name string, version int, birthday, anniversary string) error {
+	err := c.update(ctx, name, version, func(customer *Customer) {
+		customer.Birthday, customer.Anniversary = birthday, anniversary
+	})
+	if err == ErrNotFound && version == 0 {
+		return c.Add(ctx, Customer{Name: name, OrderedItems: []string{}, Birthday: birthday, Anniversary: anniversary})
+	}
+	return err
+}
+
+func (c sqlCustomers) SetFlag(ctx context.Context, name string, version int, flag *CustomerFlag) error {
+	err := c.update(ctx, name, version, func(customer *Customer) {
+		customer.Flag = flag
+	})
+	if err == ErrNotFound && version == 0 {
+		return c.Add(ctx, Customer{Name: name, OrderedItems: []string{}, Flag: flag})
+	}
+	return err
+}
+
+func (c sqlCustomers) SetAddresses(ctx context.Context, name string, version int, addresses []SavedAddress) error {
+	err := c.update(ctx, name, version, func(customer *Customer) {
+		customer.Addresses = addresses
+	})
+	if err == ErrNotFound && version == 0 {
+		return c.Add(ctx, Customer{Name: name, OrderedItems: []string{}, Addresses: addresses})
+	}
+	return err
+}
+
+func (c sqlCustomers) SetCommunication(ctx context.Context, name string, version int, prefs *CommunicationPrefs) error {
+	err := c.update(ctx, name, version, func(customer *Customer) {
+		customer.Communication = prefs
+	})
+	if err == ErrNotFound && version == 0 {
+		return c.Add(ctx, Customer{Name: name, OrderedItems: []string{}, Communication: prefs})
+	}
+	return err
+}
+
+func (c sqlCustomers) SetLegacy(ctx context.Context, name string, version int, legacy *LegacyHistory) error {
+	err := c.update(ctx, name, version, func(customer *Customer) {
+		customer.Legacy = legacy
+	})
+	if err == ErrNotFound && version == 0 {
+		return c.Add(ctx, Customer{Name: name, OrderedItems: []string{}, Legacy: legacy})
+	}
+	return err
+}
+
+type sqlOrders struct{ s *sqlStore }
+
+func (o sqlOrders) Save(ctx context.Context, order Order) error {
+	doc, err := marshalDoc(order)
+	if err != nil {
+		return err
+	}
+	updatedAt := order.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = order.CreatedAt
+	}
+	_, err = o.s.db.ExecContext(ctx, o.s.rebind(`INSERT INTO orders (id, status, type, token, created_at, updated_at, version, doc) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET status = excluded.status, type = excluded.type, token = excluded.token, updated_at = excluded.updated_at,
+			version = excluded.version, doc = excluded.doc
+		WHERE orders.version < excluded.version`),
+		order.ID.Hex(), order.Status, order.Type, order.Token, order.CreatedAt.UnixNano(), updatedAt.UnixNano(), order.Version, doc)
+	return err
+}
+
+func (o sqlOrders) Find(ctx context.Context, id primitive.ObjectID) (Order, error) {
+	order, err := queryDoc[Order](ctx, o.s, o.s.db, `SELECT doc FROM orders WHERE id = ?`, id.Hex())
+	if err == ErrNotFound {
+		return queryDoc[Order](ctx, o.s, o.s.db, `SELECT doc FROM orders_archive WHERE id = ?`, id.Hex())
+	}
+	return order, err
+}
+
+func (o sqlOrders) ListOpen(ctx context.Context) ([]Order, error) {
+	return queryDocs[Order](ctx, o.s, o.s.db, `SELECT doc FROM orders WHERE status <> ? ORDER BY created_at`, StatusServed)
+}
+
+func (o sqlOrders) ListBetween(ctx context.Context, from, to time.Time) ([]Order, error) {
+	// An archived order that was changed again is live once more, and the live copy is the one that counts
+	return queryDocs[Order](ctx, o.s, o.s.db, `SELECT doc FROM (
+			SELECT doc, created_at FROM orders WHERE created_at >= ? AND created_at < ?
+			UNION ALL
+			SELECT doc, created_at FROM orders_archive a WHERE created_at >= ? AND created_at < ? AND NOT EXISTS (SELECT 1 FROM orders o WHERE o.id = a.id)
+		) AS placed ORDER BY created_at`,
+		from.UnixNano(), to.UnixNano(), from.UnixNano(), to.UnixNano())
+}
+
+func (o sqlOrders) Archive(ctx context.Context, before time.Time, limit int) (int, error) {
+	// Whether an order is paid is only in its document, so settled orders are picked out here. The served orders
+	// are paged through by created_at and id, so unpaid ones are stepped past rather than read again every batch.
+	var settled []Order
+	afterAt, afterID := int64(math.MinInt64), ""
+	for len(settled) < limit {
+		served, err := queryDocs[Order](ctx, o.s, o.s.db, `SELECT doc FROM orders WHERE status = ? AND created_at < ?
+			AND (created_at > ? OR (created_at = ? AND id > ?)) ORDER BY created_at, id LIMIT ?`,
+			StatusServed, before.UnixNano(), afterAt, afterAt, afterID, limit)
+		if err != nil {
+			return 0, err
+		}
+		if len(served) == 0 {
+			break
+		}
+		for _, order := range served {
+			if order.Paid && len(settled) < limit {
+				settled = append(settled, order)
+			}
+		}
+		last := served[len(served)-1]
+		afterAt, afterID = last.CreatedAt.UnixNano(), last.ID.Hex()
+	}
+	moved := 0
+	err := o.s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, order := range settled {
+			id := order.ID.Hex()
+			steps := []struct {
+				query string
+				args  []any
+			}{
+				// An order archived before and changed since replaces its archived copy
+				{`INSERT INTO orders_archive (id, created_at, doc) SELECT id, created_at, doc FROM orders WHERE id = ?
+					ON CONFLICT (id) DO UPDATE SET doc = excluded.doc`, []any{id}},
+				{`INSERT INTO order_events_archive (id, order_id, seq, at, doc) SELECT id, order_id, seq, at, doc FROM order_events WHERE order_id = ?
+					ON CONFLICT (id) DO NOTHING`, []any{id}},
+				{`DELETE FROM order_events WHERE order_id = ?`, []any{id}},
+				{`DELETE FROM orders WHERE id = ?`, []any{id}},
+			}
+			for _, step := range steps {
+				if _, err := tx.ExecContext(ctx, o.s.rebind(step.query), step.args...); err != nil {
+					return err
+				}
+			}
+			moved++
+		}
+		return nil
+	})
+	return moved, err
+}
+
+func (o sqlOrders) ListUpdatedAfter(ctx context.Context, after time.Time, afterID primitive.ObjectID, until time.Time, limit int) ([]Order, error) {
+	return queryDocs[Order](ctx, o.s, o.s.db, `SELECT doc FROM orders WHERE (updated_at > ? OR (updated_at = ? AND id > ?)) AND updated_at < ?
+		ORDER BY updated_at, id LIMIT ?`, after.UnixNano(), after.UnixNano(), afterID.Hex(), until.UnixNano(), limit)
+}
+
+func (o sqlOrders) ListTakeaway(ctx context.Context, since time.Time, statuses []string) ([]Order, error) {
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+	args := []any{OrderTakeaway, since.UnixNano()}
+	for _, status := range statuses {
+		args = append(args, status)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(statuses)), ", ")
+	return queryDocs[Order](ctx, o.s, o.s.db,
+		`SELECT doc FROM orders WHERE type = ? AND created_at >= ? AND status IN (`+placeholders+`) ORDER BY token`, args...)
+}
+
+func (o sqlOrders) TokenInUse(ctx context.Context, from, to time.Time, token int) (bool, error) {
+	var count int
+	err := o.s.db.QueryRowContext(ctx, o.s.rebind(`SELECT COUNT(*) FROM orders WHERE token = ? AND created_at >= ? AND created_at < ?`),
+		token, from.UnixNano(), to.UnixNano()).Scan(&count)
+	return count > 0, err
+}
+
+type sqlTables struct{ s *sqlStore }
+
+func (t sqlTables) Seed(ctx context.Context, tables []Table) error {
+	for _, table := range tables {
+		doc, err := marshalDoc(table)
+		if err != nil {
+			return err
+		}
+		_, err = t.s.db.ExecContext(ctx, t.s.rebind(`INSERT INTO dining_tables (number, doc) VALUES (?, ?) ON CONFLICT (number) DO NOTHING`), table.Number, doc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t sqlTables) List(ctx context.Context) ([]Table, error) {
+	return queryDocs[Table](ctx, t.s, t.s.db, `SELECT doc FROM dining_tables ORDER BY number`)
+}
+
+func (t sqlTables) SetOccupied(ctx context.Context, number int, occupied bool) error {
+	return t.s.inTx(ctx, func(tx *sql.Tx) error {
+		table, err := queryDoc[Table](ctx, t.s, tx, `SELECT doc FROM dining_tables WHERE number = ?`+t.s.forUpdate(), number)
+		if err != nil {
+			return err
+		}
+		table.Occupied = occupied
+		doc, err := marshalDoc(table)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, t.s.rebind(`UPDATE dining_tables SET doc = ? WHERE number = ?`), doc, number)
+		return err
+	})
+}
+
+type sqlPayments struct{ s *sqlStore }
+
+func (p sqlPayments) Insert(ctx context.Context, payment Payment) error {
+	doc, err := marshalDoc(payment)
+	if err != nil {
+		return err
+	}
+	_, err = p.s.db.ExecContext(ctx, p.s.rebind(`INSERT INTO payments (id, order_id, created_at, doc) VALUES (?, ?, ?, ?)`),
+		payment.ID.Hex(), payment.OrderID.Hex(), payment.CreatedAt.UnixNano(), doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (p sqlPayments) Find(ctx context.Context, id primitive.ObjectID) (Payment, error) {
+	return queryDoc[Payment](ctx, p.s, p.s.db, `SELECT doc FROM payments WHERE id = ?`, id.Hex())
+}
+
+func (p sqlPayments) ListBetween(ctx context.Context, from, to time.Time) ([]Payment, error) {
+	return queryDocs[Payment](ctx, p.s, p.s.db, `SELECT doc FROM payments WHERE created_at >= ? AND created_at < ? ORDER BY created_at`, from.UnixNano(), to.UnixNano())
+}
+
+func (p sqlPayments) ListAfter(ctx context.Context, after time.Time, afterID primitive.ObjectID, until time.Time, limit int) ([]Payment, error) {
+	return queryDocs[Payment](ctx, p.s, p.s.db, `SELECT doc FROM payments WHERE (created_at > ? OR (created_at = ? AND id > ?)) AND created_at < ?
+		ORDER BY created_at, id LIMIT ?`, after.UnixNano(), after.UnixNano(), afterID.Hex(), until.UnixNano(), limit)
+}
+
+type sqlCounters struct{ s *sqlStore }
+
+func (c sqlCounters) Next(ctx context.Context, name string) (int, error) {
+	var value int
+	err := c.s.db.QueryRowContext(ctx, c.s.rebind(`INSERT INTO counters (name, value) VALUES (?, 1)
+		ON CONFLICT (name) DO UPDATE SET value = counters.value + 1 RETURNING value`), name).Scan(&value)
+	return value, err
+}
+
+type sqlSyncConflicts struct{ s *sqlStore }
+
+func (c sqlSyncConflicts) Insert(ctx context.Context, conflict SyncConflict) error {
+	doc, err := marshalDoc(conflict)
+	if err != nil {
+		return err
+	}
+	_, err = c.s.db.ExecContext(ctx, c.s.rebind(`INSERT INTO sync_conflicts (id, created_at, doc) VALUES (?, ?, ?)`),
+		primitive.NewObjectID().Hex(), conflict.SyncedAt.UnixNano(), doc)
+	return err
+}
+
+type sqlEvents struct{ s *sqlStore }
+
+func (e sqlEvents) Append(ctx context.Context, event OrderEvent) error {
+	doc, err := marshalDoc(event)
+	if err != nil {
+		return err
+	}
+	_, err = e.s.db.ExecContext(ctx, e.s.rebind(`INSERT INTO order_events (id, order_id, seq, at, doc) VALUES (?, ?, ?, ?, ?)`),
+		event.ID.Hex(), event.OrderID.Hex(), event.Seq, event.At.UnixNano(), doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (e sqlEvents) ListForOrder(ctx context.Context, orderID primitive.ObjectID) ([]OrderEvent, error) {
+	events, err := queryDocs[OrderEvent](ctx, e.s, e.s.db, `SELECT doc FROM order_events WHERE order_id = ? ORDER BY seq`, orderID.Hex())
+	if err != nil || len(events) > 0 && events[0].Seq == 1 {
+		return events, err
+	}
+	// The order's stream starts in the archive
+	archived, err := queryDocs[OrderEvent](ctx, e.s, e.s.db, `SELECT doc FROM order_events_archive WHERE order_id = ? ORDER BY seq`, orderID.Hex())
+	return append(archived, events...), err
+}
+
+func (e sqlEvents) ListSince(ctx context.Context, since time.Time) ([]OrderEvent, error) {
+	return queryDocs[OrderEvent](ctx, e.s, e.s.db, `SELECT doc FROM order_events WHERE at >= ? ORDER BY at, id`, since.UnixNano())
+}
+
+func (e sqlEvents) ListUnpublished(ctx context.Context, limit int) ([]OrderEvent, error) {
+	return queryDocs[OrderEvent](ctx, e.s, e.s.db, `SELECT doc FROM order_events WHERE published_at IS NULL ORDER BY at, id LIMIT ?`, limit)
+}
+
+// MarkPublished only sets the column; the doc keeps the event exactly as it was appended
+func (e sqlEvents) MarkPublished(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	return expectRow(e.s.db.ExecContext(ctx, e.s.rebind(`UPDATE order_events SET published_at = ? WHERE id = ?`), at.UnixNano(), id.Hex()))
+}
+
+type sqlTenants struct{ s *sqlStore }
+
+func (t sqlTenants) Create(ctx context.Context, tenant Tenant) error {
+	doc, err := marshalDoc(tenant)
+	if err != nil {
+		return err
+	}
+	_, err = t.s.db.ExecContext(ctx, t.s.rebind(`INSERT INTO tenants (id, doc) VALUES (?, ?)`), tenant.ID, doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (t sqlTenants) Find(ctx context.Context, id string) (Tenant, error) {
+	return queryDoc[Tenant](ctx, t.s, t.s.db, `SELECT doc FROM tenants WHERE id = ?`, id)
+}
+
+func (t sqlTenants) List(ctx context.Context) ([]Tenant, error) {
+	return queryDocs[Tenant](ctx, t.s, t.s.db, `SELECT doc FROM tenants ORDER BY id`)
+}
+
+type sqlAPIKeys struct{ s *sqlStore }
+
+func (k sqlAPIKeys) Create(ctx context.Context, key APIKey) error {
+	// The hash is not part of the JSON doc, so it only lives in its column
+	doc, err := marshalDoc(key)
+	if err != nil {
+		return err
+	}
+	_, err = k.s.db.ExecContext(ctx, k.s.rebind(`INSERT INTO api_keys (id, tenant_id, hash, doc) VALUES (?, ?, ?, ?)`),
+		key.ID.Hex(), key.TenantID, key.Hash, doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (k sqlAPIKeys) Find(ctx context.Context, id primitive.ObjectID) (APIKey, error) {
+	return k.queryKey(ctx, `SELECT hash, doc FROM api_keys WHERE id = ?`, id.Hex())
+}
+
+func (k sqlAPIKeys) FindByHash(ctx context.Context, hash string) (APIKey, error) {
+	return k.queryKey(ctx, `SELECT hash, doc FROM api_keys WHERE hash = ?`, hash)
+}
+
+// queryKey decodes a key, putting back the hash that is kept out of the doc
+func (k sqlAPIKeys) queryKey(ctx context.Context, query string, args ...any) (APIKey, error) {
+	var key APIKey
+	var hash, doc string
+	err := k.s.db.QueryRowContext(ctx, k.s.rebind(query), args...).Scan(&hash, &doc)
+	if err == sql.ErrNoRows {
+		return key, ErrNotFound
+	}
+	if err != nil {
+		return key, err
+	}
+	if err := json.Unmarshal([]byte(doc), &key); err != nil {
+		return key, err
+	}
+	key.Hash = hash
+	return key, nil
+}
+
+func (k sqlAPIKeys) ListByTenant(ctx context.Context, tenantID string) ([]APIKey, error) {
+	// The hash is left empty; nothing that lists keys needs it
+	return queryDocs[APIKey](ctx, k.s, k.s.db, `SELECT doc FROM api_keys WHERE tenant_id = ? ORDER BY id`, tenantID)
+}
+
+func (k sqlAPIKeys) Update(ctx context.Context, key APIKey) error {
+	doc, err := marshalDoc(key)
+	if err != nil {
+		return err
+	}
+	return expectRow(k.s.db.ExecContext(ctx, k.s.rebind(`UPDATE api_keys SET hash = ?, doc = ? WHERE id = ?`), key.Hash, doc, key.ID.Hex()))
+}
+
+func (k sqlAPIKeys) LogUsage(ctx context.Context, usage APIKeyUsage) error {
+	doc, err := marshalDoc(usage)
+	if err != nil {
+		return err
+	}
+	_, err = k.s.db.ExecContext(ctx, k.s.rebind(`INSERT INTO api_key_usage (id, key_id, at, doc) VALUES (?, ?, ?, ?)`),
+		usage.ID.Hex(), usage.KeyID.Hex(), usage.At.UnixNano(), doc)
+	return err
+}
+
+func (k sqlAPIKeys) ListUsage(ctx context.Context, keyID primitive.ObjectID, limit int) ([]APIKeyUsage, error) {
+	return queryDocs[APIKeyUsage](ctx, k.s, k.s.db, `SELECT doc FROM api_key_usage WHERE key_id = ? ORDER BY at DESC LIMIT ?`, keyID.Hex(), limit)
+}
+
+type sqlInvoices struct{ s *sqlStore }
+
+func (i sqlInvoices) Insert(ctx context.Context, invoice Invoice) error {
+	doc, err := marshalDoc(invoice)
+	if err != nil {
+		return err
+	}
+	_, err = i.s.db.ExecContext(ctx, i.s.rebind(`INSERT INTO invoices (id, number, financial_year, seq, order_id, issued_at, doc) VALUES (?, ?, ?, ?, ?, ?, ?)`),
+		invoice.ID.Hex(), invoice.Number, invoice.FinancialYear, invoice.Seq, invoice.OrderID.Hex(), invoice.IssuedAt.UnixNano(), doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (i sqlInvoices) Find(ctx context.Context, number string) (Invoice, error) {
+	return queryDoc[Invoice](ctx, i.s, i.s.db, `SELECT doc FROM invoices WHERE number = ?`, number)
+}
+
+func (i sqlInvoices) FindByOrder(ctx context.Context, orderID primitive.ObjectID) (Invoice, error) {
+	return queryDoc[Invoice](ctx, i.s, i.s.db, `SELECT doc FROM invoices WHERE order_id = ?`, orderID.Hex())
+}
+
+func (i sqlInvoices) LastSeq(ctx context.Context, financialYear string) (int, error) {
+	var last int
+	err := i.s.db.QueryRowContext(ctx, i.s.rebind(`SELECT COALESCE(MAX(seq), 0) FROM invoices WHERE financial_year = ?`), financialYear).Scan(&last)
+	return last, err
+}
+
+func (i sqlInvoices) ListBetween(ctx context.Context, from, to time.Time) ([]Invoice, error) {
+	return queryDocs[Invoice](ctx, i.s, i.s.db, `SELECT doc FROM invoices WHERE issued_at >= ? AND issued_at < ? ORDER BY issued_at`, from.UnixNano(), to.UnixNano())
+}
+
+type sqlDrawers struct{ s *sqlStore }
+
+func (d sqlDrawers) Open(ctx context.Context, session DrawerSession) error {
+	doc, err := marshalDoc(session)
+	if err != nil {
+		return err
+	}
+	_, err = d.s.db.ExecContext(ctx, d.s.rebind(`INSERT INTO drawer_sessions (id, open_key, opened_at, doc) VALUES (?, 1, ?, ?)`),
+		session.ID.Hex(), session.OpenedAt.UnixNano(), doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (d sqlDrawers) FindOpen(ctx context.Context) (DrawerSession, error) {
+	return queryDoc[DrawerSession](ctx, d.s, d.s.db, `SELECT doc FROM drawer_sessions WHERE open_key = 1`)
+}
+
+func (d sqlDrawers) AddMovement(ctx context.Context, id primitive.ObjectID, movement CashMovement) error {
+	return d.s.inTx(ctx, func(tx *sql.Tx) error {
+		session, err := queryDoc[DrawerSession](ctx, d.s, tx, `SELECT doc FROM drawer_sessions WHERE id = ? AND open_key = 1`+d.s.forUpdate(), id.Hex())
+		if err != nil {
+			return err
+		}
+		session.Movements = append(session.Movements, movement)
+		doc, err := marshalDoc(session)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, d.s.rebind(`UPDATE drawer_sessions SET doc = ? WHERE id = ?`), doc, id.Hex())
+		return err
+	})
+}
+
+func (d sqlDrawers) Close(ctx context.Context, session DrawerSession) error {
+	doc, err := marshalDoc(session)
+	if err != nil {
+		return err
+	}
+	result, err := d.s.db.ExecContext(ctx, d.s.rebind(`UPDATE drawer_sessions SET open_key = NULL, doc = ? WHERE id = ? AND open_key = 1`), doc, session.ID.Hex())
+	return expectRow(result, err)
+}
+
+func (d sqlDrawers) ListBetween(ctx context.Context, from, to time.Time) ([]DrawerSession, error) {
+	return queryDocs[DrawerSession](ctx, d.s, d.s.db, `SELECT doc FROM drawer_sessions WHERE opened_at >= ? AND opened_at < ? ORDER BY opened_at`, from.UnixNano(), to.UnixNano())
+}
+
+type sqlShifts struct{ s *sqlStore }
+
+func (h sqlShifts) Open(ctx context.Context, shift Shift) error {
+	doc, err := marshalDoc(shift)
+	if err != nil {
+		return err
+	}
+	_, err = h.s.db.ExecContext(ctx, h.s.rebind(`INSERT INTO shifts (id, open_cashier, opened_at, doc) VALUES (?, ?, ?, ?)`),
+		shift.ID.Hex(), shift.Cashier, shift.OpenedAt.UnixNano(), doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (h sqlShifts) Find(ctx context.Context, id primitive.ObjectID) (Shift, error) {
+	return queryDoc[Shift](ctx, h.s, h.s.db, `SELECT doc FROM shifts WHERE id = ?`, id.Hex())
+}
+
+func (h sqlShifts) FindOpen(ctx context.Context, cashier string) (Shift, error) {
+	return queryDoc[Shift](ctx, h.s, h.s.db, `SELECT doc FROM shifts WHERE open_cashier = ?`, cashier)
+}
+
+func (h sqlShifts) Close(ctx context.Context, shift Shift) error {
+	doc, err := marshalDoc(shift)
+	if err != nil {
+		return err
+	}
+	result, err := h.s.db.ExecContext(ctx, h.s.rebind(`UPDATE shifts SET open_cashier = NULL, doc = ? WHERE id = ? AND open_cashier IS NOT NULL`), doc, shift.ID.Hex())
+	return expectRow(result, err)
+}
+
+func (h sqlShifts) ListBetween(ctx context.Context, from, to time.Time) ([]Shift, error) {
+	return queryDocs[Shift](ctx, h.s, h.s.db, `SELECT doc FROM shifts WHERE opened_at >= ? AND opened_at < ? ORDER BY opened_at`, from.UnixNano(), to.UnixNano())
+}
+
+type sqlTimeClock struct{ s *sqlStore }
+
+func (c sqlTimeClock) ClockIn(ctx context.Context, entry TimeEntry) error {
+	doc, err := marshalDoc(entry)
+	if err != nil {
+		return err
+	}
+	_, err = c.s.db.ExecContext(ctx, c.s.rebind(`INSERT INTO time_entries (id, open_employee, clock_in, doc) VALUES (?, ?, ?, ?)`),
+		entry.ID.Hex(), entry.Employee, entry.ClockIn.UnixNano(), doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (c sqlTimeClock) FindOpen(ctx context.Context, employee string) (TimeEntry, error) {
+	return queryDoc[TimeEntry](ctx, c.s, c.s.db, `SELECT doc FROM time_entries WHERE open_employee = ?`, employee)
+}
+
+func (c sqlTimeClock) ClockOut(ctx context.Context, entry TimeEntry) error {
+	doc, err := marshalDoc(entry)
+	if err != nil {
+		return err
+	}
+	result, err := c.s.db.ExecContext(ctx, c.s.rebind(`UPDATE time_entries SET open_employee = NULL, doc = ? WHERE id = ? AND open_employee IS NOT NULL`), doc, entry.ID.Hex())
+	return expectRow(result, err)
+}
+
+func (c sqlTimeClock) ListBetween(ctx context.Context, from, to time.Time) ([]TimeEntry, error) {
+	return queryDocs[TimeEntry](ctx, c.s, c.s.db, `SELECT doc FROM time_entries WHERE clock_in >= ? AND clock_in < ? ORDER BY clock_in`, from.UnixNano(), to.UnixNano())
+}
+
+type sqlReservations struct{ s *sqlStore }
+
+func (r sqlReservations) Insert(ctx context.Context, reservation Reservation) error {
+	doc, err := marshalDoc(reservation)
+	if err != nil {
+		return err
+	}
+	_, err = r.s.db.ExecContext(ctx, r.s.rebind(`INSERT INTO reservations (id, at, doc) VALUES (?, ?, ?)`),
+		reservation.ID.Hex(), reservation.At.UnixNano(), doc)
+	return err
+}
+
+func (r sqlReservations) Find(ctx context.Context, id primitive.ObjectID) (Reservation, error) {
+	return queryDoc[Reservation](ctx, r.s, r.s.db, `SELECT doc FROM reservations WHERE id = ?`, id.Hex())
+}
+
+func (r sqlReservations) Update(ctx context.Context, reservation Reservation) error {
+	doc, err := marshalDoc(reservation)
+	if err != nil {
+		return err
+	}
+	result, err := r.s.db.ExecContext(ctx, r.s.rebind(`UPDATE reservations SET at = ?, doc = ? WHERE id = ?`),
+		reservation.At.UnixNano(), doc, reservation.ID.Hex())
+	return expectRow(result, err)
+}
+
+func (r sqlReservations) ListBetween(ctx context.Context, from, to time.Time) ([]Reservation, error) {
+	return queryDocs[Reservation](ctx, r.s, r.s.db, `SELECT doc FROM reservations WHERE at >= ? AND at < ? ORDER BY at`, from.UnixNano(), to.UnixNano())
+}
+
+type sqlBanquets struct{ s *sqlStore }
+
+func (b sqlBanquets) Insert(ctx context.Context, banquet Banquet) error {
+	doc, err := marshalDoc(banquet)
+	if err != nil {
+		return err
+	}
+	_, err = b.s.db.ExecContext(ctx, b.s.rebind(`INSERT INTO banquets (id, start_at, end_at, doc) VALUES (?, ?, ?, ?)`),
+		banquet.ID.Hex(), banquet.Start.UnixNano(), banquet.End.UnixNano(), doc)
+	return err
+}
+
+func (b sqlBanquets) Find(ctx context.Context, id primitive.ObjectID) (Banquet, error) {
+	return queryDoc[Banquet](ctx, b.s, b.s.db, `SELECT doc FROM banquets WHERE id = ?`, id.Hex())
+}
+
+func (b sqlBanquets) Update(ctx context.Context, banquet Banquet) error {
+	doc, err := marshalDoc(banquet)
+	if err != nil {
+		return err
+	}
+	result, err := b.s.db.ExecContext(ctx, b.s.rebind(`UPDATE banquets SET start_at = ?, end_at = ?, doc = ? WHERE id = ?`),
+		banquet.Start.UnixNano(), banquet.End.UnixNano(), doc, banquet.ID.Hex())
+	return expectRow(result, err)
+}
+
+func (b sqlBanquets) ListOverlapping(ctx context.Context, from, to time.Time) ([]Banquet, error) {
+	return queryDocs[Banquet](ctx, b.s, b.s.db, `SELECT doc FROM banquets WHERE start_at < ? AND end_at > ? ORDER BY start_at`, to.UnixNano(), from.UnixNano())
+}
+
+type sqlQuotes struct{ s *sqlStore }
+
+func (q sqlQuotes) Insert(ctx context.Context, quote Quote) error {
+	// The token is not part of the JSON doc, so it only lives in its column
+	doc, err := marshalDoc(quote)
+	if err != nil {
+		return err
+	}
+	_, err = q.s.db.ExecContext(ctx, q.s.rebind(`INSERT INTO quotes (id, token, created_at, doc) VALUES (?, ?, ?, ?)`),
+		quote.ID.Hex(), quote.Token, quote.CreatedAt.UnixNano(), doc)
+	return err
+}
+
+func (q sqlQuotes) Find(ctx context.Context, id primitive.ObjectID) (Quote, error) {
+	return q.queryQuote(ctx, `SELECT token, doc FROM quotes WHERE id = ?`, id.Hex())
+}
+
+func (q sqlQuotes) FindByToken(ctx context.Context, token string) (Quote, error) {
+	return q.queryQuote(ctx, `SELECT token, doc FROM quotes WHERE token = ?`, token)
+}
+
+// queryQuote decodes a quote, putting back the token that is kept out of the doc
+func (q sqlQuotes) queryQuote(ctx context.Context, query string, args ...any) (Quote, error) {
+	var quote Quote
+	var token, doc string
+	err := q.s.db.QueryRowContext(ctx, q.s.rebind(query), args...).Scan(&token, &doc)
+	if err == sql.ErrNoRows {
+		return quote, ErrNotFound
+	}
+	if err != nil {
+		return quote, err
+	}
+	if err := json.Unmarshal([]byte(doc), &quote); err != nil {
+		return quote, err
+	}
+	quote.Token = token
+	return quote, nil
+}
+
+func (q sqlQuotes) Update(ctx context.Context, quote Quote) error {
+	doc, err := marshalDoc(quote)
+	if err != nil {
+		return err
+	}
+	result, err := q.s.db.ExecContext(ctx, q.s.rebind(`UPDATE quotes SET doc = ? WHERE id = ?`), doc, quote.ID.Hex())
+	return expectRow(result, err)
+}
+
+func (q sqlQuotes) ListBetween(ctx context.Context, from, to time.Time) ([]Quote, error) {
+	// The token is left empty; the list is for staff, who do not need the customer's link
+	return queryDocs[Quote](ctx, q.s, q.s.db, `SELECT doc FROM quotes WHERE created_at >= ? AND created_at < ? ORDER BY created_at`, from.UnixNano(), to.UnixNano())
+}
+
+type sqlCreditAccounts struct{ s *sqlStore }
+
+func (a sqlCreditAccounts) Insert(ctx context.Context, account CreditAccount) error {
+	doc, err := marshalDoc(account)
+	if err != nil {
+		return err
+	}
+	_, err = a.s.db.ExecContext(ctx, a.s.rebind(`INSERT INTO credit_accounts (id, name, doc) VALUES (?, ?, ?)`), account.ID.Hex(), account.Name, doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (a sqlCreditAccounts) Find(ctx context.Context, id primitive.ObjectID) (CreditAccount, error) {
+	return queryDoc[CreditAccount](ctx, a.s, a.s.db, `SELECT doc FROM credit_accounts WHERE id = ?`, id.Hex())
+}
+
+func (a sqlCreditAccounts) List(ctx context.Context) ([]CreditAccount, error) {
+	return queryDocs[CreditAccount](ctx, a.s, a.s.db, `SELECT doc FROM credit_accounts ORDER BY name`)
+}
+
+func (a sqlCreditAccounts) Update(ctx context.Context, account CreditAccount) error {
+	return a.s.inTx(ctx, func(tx *sql.Tx) error {
+		stored, err := queryDoc[CreditAccount](ctx, a.s, tx, `SELECT doc FROM credit_accounts WHERE id = ?`+a.s.forUpdate(), account.ID.Hex())
+		if err != nil {
+			return err
+		}
+		account.Balance = stored.Balance
+		doc, err := marshalDoc(account)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, a.s.rebind(`UPDATE credit_accounts SET name = ?, doc = ? WHERE id = ?`), account.Name, doc, account.ID.Hex())
+		return err
+	})
+}
+
+func (a sqlCreditAccounts) Post(ctx context.Context, entry AccountEntry) (CreditAccount, error) {
+	var account CreditAccount
+	err := a.s.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		account, err = queryDoc[CreditAccount](ctx, a.s, tx, `SELECT doc FROM credit_accounts WHERE id = ?`+a.s.forUpdate(), entry.AccountID.Hex())
+		if err != nil {
+			return err
+		}
+		balance := roundPaise(account.Balance + entry.Amount)
+		if entry.Amount > 0 && balance > account.CreditLimit {
+			return ErrCreditLimit
+		}
+		account.Balance, entry.Balance = balance, balance
+		doc, err := marshalDoc(account)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, a.s.rebind(`UPDATE credit_accounts SET doc = ? WHERE id = ?`), doc, account.ID.Hex()); err != nil {
+			return err
+		}
+		if doc, err = marshalDoc(entry); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, a.s.rebind(`INSERT INTO account_entries (id, account_id, created_at, doc) VALUES (?, ?, ?, ?)`),
+			entry.ID.Hex(), entry.AccountID.Hex(), entry.CreatedAt.UnixNano(), doc)
+		return err
+	})
+	if err != nil {
+		return CreditAccount{}, err
+	}
+	return account, nil
+}
+
+func (a sqlCreditAccounts) ListEntries(ctx context.Context, id primitive.ObjectID) ([]AccountEntry, error) {
+	return queryDocs[AccountEntry](ctx, a.s, a.s.db, `SELECT doc FROM account_entries WHERE account_id = ? ORDER BY created_at`, id.Hex())
+}
+
+type sqlCompanies struct{ s *sqlStore }
+
+func (c sqlCompanies) Insert(ctx context.Context, company Company) error {
+	doc, err := marshalDoc(company)
+	if err != nil {
+		return err
+	}
+	_, err = c.s.db.ExecContext(ctx, c.s.rebind(`INSERT INTO companies (id, name, doc) VALUES (?, ?, ?)`), company.ID.Hex(), company.Name, doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (c sqlCompanies) Find(ctx context.Context, id primitive.ObjectID) (Company, error) {
+	return queryDoc[Company](ctx, c.s, c.s.db, `SELECT doc FROM companies WHERE id = ?`, id.Hex())
+}
+
+func (c sqlCompanies) List(ctx context.Context) ([]Company, error) {
+	return queryDocs[Company](ctx, c.s, c.s.db, `SELECT doc FROM companies ORDER BY name`)
+}
+
+func (c sqlCompanies) Update(ctx context.Context, company Company) error {
+	doc, err := marshalDoc(company)
+	if err != nil {
+		return err
+	}
+	result, err := c.s.db.ExecContext(ctx, c.s.rebind(`UPDATE companies SET name = ?, doc = ? WHERE id = ?`), company.Name, doc, company.ID.Hex())
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return expectRow(result, err)
+}
+
+func (c sqlCompanies) InsertInvoice(ctx context.Context, invoice CompanyInvoice) error {
+	doc, err := marshalDoc(invoice)
+	if err != nil {
+		return err
+	}
+	_, err = c.s.db.ExecContext(ctx, c.s.rebind(`INSERT INTO company_invoices (id, number, financial_year, seq, company_id, month, doc) VALUES (?, ?, ?, ?, ?, ?, ?)`),
+		invoice.ID.Hex(), invoice.Number, invoice.FinancialYear, invoice.Seq, invoice.CompanyID.Hex(), invoice.Month, doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (c sqlCompanies) FindInvoice(ctx context.Context, companyID primitive.ObjectID, month string) (CompanyInvoice, error) {
+	return queryDoc[CompanyInvoice](ctx, c.s, c.s.db, `SELECT doc FROM company_invoices WHERE company_id = ? AND month = ?`, companyID.Hex(), month)
+}
+
+func (c sqlCompanies) LastInvoiceSeq(ctx context.Context, financialYear string) (int, error) {
+	var last int
+	err := c.s.db.QueryRowContext(ctx, c.s.rebind(`SELECT COALESCE(MAX(seq), 0) FROM company_invoices WHERE financial_year = ?`), financialYear).Scan(&last)
+	return last, err
+}
+
+func (c sqlCompanies) ListInvoices(ctx context.Context, companyID primitive.ObjectID) ([]CompanyInvoice, error) {
+	return queryDocs[CompanyInvoice](ctx, c.s, c.s.db, `SELECT doc FROM company_invoices WHERE company_id = ? ORDER BY month`, companyID.Hex())
+}
+
+type sqlIdempotency struct{ s *sqlStore }
+
+func (i sqlIdempotency) Begin(ctx context.Context, record IdempotencyRecord, expiredBefore time.Time) error {
+	doc, err := marshalDoc(record)
+	if err != nil {
+		return err
+	}
+	return i.s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, i.s.rebind(`DELETE FROM idempotency_keys WHERE created_at < ?`), expiredBefore.UnixNano()); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, i.s.rebind(`INSERT INTO idempotency_keys (idempotency_key, created_at, doc) VALUES (?, ?, ?)`),
+			record.Key, record.CreatedAt.UnixNano(), doc)
+		if isUniqueViolation(err) {
+			return ErrDuplicate
+		}
+		return err
+	})
+}
+
+func (i sqlIdempotency) Find(ctx context.Context, key string) (IdempotencyRecord, error) {
+	return queryDoc[IdempotencyRecord](ctx, i.s, i.s.db, `SELECT doc FROM idempotency_keys WHERE idempotency_key = ?`, key)
+}
+
+func (i sqlIdempotency) Complete(ctx context.Context, record IdempotencyRecord) error {
+	doc, err := marshalDoc(record)
+	if err != nil {
+		return err
+	}
+	return expectRow(i.s.db.ExecContext(ctx, i.s.rebind(`UPDATE idempotency_keys SET doc = ? WHERE idempotency_key = ?`), doc, record.Key))
+}
+
+func (i sqlIdempotency) Delete(ctx context.Context, key string) error {
+	_, err := i.s.db.ExecContext(ctx, i.s.rebind(`DELETE FROM idempotency_keys WHERE idempotency_key = ?`), key)
+	return err
+}
+
+type sqlCoupons struct{ s *sqlStore }
+
+func (c sqlCoupons) Add(ctx context.Context, coupon Coupon) error {
+	doc, err := marshalDoc(coupon)
+	if err != nil {
+		return err
+	}
+	_, err = c.s.db.ExecContext(ctx, c.s.rebind(`INSERT INTO coupons (id, code, customer_name, occasion, year, doc) VALUES (?, ?, ?, ?, ?, ?)`),
+		coupon.ID.Hex(), coupon.Code, coupon.CustomerName, coupon.Occasion, coupon.Year, doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (c sqlCoupons) FindByCode(ctx context.Context, code string) (Coupon, error) {
+	return queryDoc[Coupon](ctx, c.s, c.s.db, `SELECT doc FROM coupons WHERE code = ?`, code)
+}
+
+type sqlSittings struct{ s *sqlStore }
+
+func (t sqlSittings) Open(ctx context.Context, sitting TableSitting) error {
+	doc, err := marshalDoc(sitting)
+	if err != nil {
+		return err
+	}
+	_, err = t.s.db.ExecContext(ctx, t.s.rebind(`INSERT INTO table_sittings (id, open_table, seated_at, doc) VALUES (?, ?, ?, ?)`),
+		sitting.ID.Hex(), sitting.Table, sitting.SeatedAt.UnixNano(), doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (t sqlSittings) FindOpen(ctx context.Context, table int) (TableSitting, error) {
+	return queryDoc[TableSitting](ctx, t.s, t.s.db, `SELECT doc FROM table_sittings WHERE open_table = ?`, table)
+}
+
+func (t sqlSittings) Close(ctx context.Context, sitting TableSitting) error {
+	doc, err := marshalDoc(sitting)
+	if err != nil {
+		return err
+	}
+	result, err := t.s.db.ExecContext(ctx, t.s.rebind(`UPDATE table_sittings SET open_table = NULL, doc = ? WHERE id = ? AND open_table IS NOT NULL`), doc, sitting.ID.Hex())
+	return expectRow(result, err)
+}
+
+func (t sqlSittings) ListBetween(ctx context.Context, from, to time.Time) ([]TableSitting, error) {
+	return queryDocs[TableSitting](ctx, t.s, t.s.db, `SELECT doc FROM table_sittings WHERE seated_at >= ? AND seated_at < ? ORDER BY seated_at`, from.UnixNano(), to.UnixNano())
+}
+
+type sqlStock struct{ s *sqlStore }
+
+func (k sqlStock) Save(ctx context.Context, level StockLevel) error {
+	return k.s.inTx(ctx, func(tx *sql.Tx) error {
+		stored, err := queryDoc[StockLevel](ctx, k.s, tx, `SELECT doc FROM branch_stock WHERE branch = ? AND name = ?`+k.s.forUpdate(), level.Branch, level.Name)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		if stored.Version != level.Version {
+			return ErrVersionConflict
+		}
+		level.Version++
+		doc, err := marshalDoc(level)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, k.s.rebind(`INSERT INTO branch_stock (branch, name, doc) VALUES (?, ?, ?) ON CONFLICT (branch, name) DO UPDATE SET doc = excluded.doc`),
+			level.Branch, level.Name, doc)
+		return err
+	})
+}
+
+func (k sqlStock) Find(ctx context.Context, branch, name string) (StockLevel, error) {
+	return queryDoc[StockLevel](ctx, k.s, k.s.db, `SELECT doc FROM branch_stock WHERE branch = ? AND name = ?`, branch, name)
+}
+
+func (k sqlStock) List(ctx context.Context, branch string) ([]StockLevel, error) {
+	return queryDocs[StockLevel](ctx, k.s, k.s.db, `SELECT doc FROM branch_stock WHERE branch = ? ORDER BY name`, branch)
+}
+
+func (k sqlStock) SetAlerted(ctx context.Context, branch, name string, at *time.Time) error {
+	return k.s.inTx(ctx, func(tx *sql.Tx) error {
+		level, err := queryDoc[StockLevel](ctx, k.s, tx, `SELECT doc FROM branch_stock WHERE branch = ? AND name = ?`+k.s.forUpdate(), branch, name)
+		if err != nil {
+			return err
+		}
+		level.AlertedAt = at
+		doc, err := marshalDoc(level)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, k.s.rebind(`UPDATE branch_stock SET doc = ? WHERE branch = ? AND name = ?`), doc, branch, name)
+		return err
+	})
+}
+
+func (k sqlStock) AddWriteOff(ctx context.Context, writeOff StockWriteOff) error {
+	doc, err := marshalDoc(writeOff)
+	if err != nil {
+		return err
+	}
+	_, err = k.s.db.ExecContext(ctx, k.s.rebind(`INSERT INTO stock_write_offs (id, branch, written_off_at, doc) VALUES (?, ?, ?, ?)`),
+		writeOff.ID.Hex(), writeOff.Branch, writeOff.WrittenOffAt.UnixNano(), doc)
+	return err
+}
+
+func (k sqlStock) ListWriteOffs(ctx context.Context, branch string, from, to time.Time) ([]StockWriteOff, error) {
+	return queryDocs[StockWriteOff](ctx, k.s, k.s.db, `SELECT doc FROM stock_write_offs WHERE branch = ? AND written_off_at >= ? AND written_off_at < ? ORDER BY written_off_at`,
+		branch, from.UnixNano(), to.UnixNano())
+}
+
+type sqlTransfers struct{ s *sqlStore }
+
+func (t sqlTransfers) Insert(ctx context.Context, transfer StockTransfer) error {
+	doc, err := marshalDoc(transfer)
+	if err != nil {
+		return err
+	}
+	_, err = t.s.db.ExecContext(ctx, t.s.rebind(`INSERT INTO stock_transfers (id, from_branch, to_branch, created_at, doc) VALUES (?, ?, ?, ?, ?)`),
+		transfer.ID.Hex(), transfer.From, transfer.To, transfer.CreatedAt.UnixNano(), doc)
+	return err
+}
+
+func (t sqlTransfers) Find(ctx context.Context, id primitive.ObjectID) (StockTransfer, error) {
+	return queryDoc[StockTransfer](ctx, t.s, t.s.db, `SELECT doc FROM stock_transfers WHERE id = ?`, id.Hex())
+}
+
+func (t sqlTransfers) Update(ctx context.Context, transfer StockTransfer) error {
+	return t.s.inTx(ctx, func(tx *sql.Tx) error {
+		stored, err := queryDoc[StockTransfer](ctx, t.s, tx, `SELECT doc FROM stock_transfers WHERE id = ?`+t.s.forUpdate(), transfer.ID.Hex())
+		if err != nil {
+			return err
+		}
+		if stored.Version != transfer.Version {
+			return ErrVersionConflict
+		}
+		transfer.Version++
+		doc, err := marshalDoc(transfer)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, t.s.rebind(`UPDATE stock_transfers SET doc = ? WHERE id = ?`), doc, transfer.ID.Hex())
+		return err
+	})
+}
+
+func (t sqlTransfers) ListForBranch(ctx context.Context, branch string) ([]StockTransfer, error) {
+	return queryDocs[StockTransfer](ctx, t.s, t.s.db, `SELECT doc FROM stock_transfers WHERE from_branch = ? OR to_branch = ? ORDER BY created_at DESC`, branch, branch)
+}
+
+type sqlBranchMenus struct{ s *sqlStore }
+
+func (b sqlBranchMenus) Find(ctx context.Context, branch string) (BranchMenu, error) {
+	return queryDoc[BranchMenu](ctx, b.s, b.s.db, `SELECT doc FROM branch_menus WHERE branch = ?`, branch)
+}
+
+func (b sqlBranchMenus) Save(ctx context.Context, menu BranchMenu) error {
+	return b.s.inTx(ctx, func(tx *sql.Tx) error {
+		stored, err := queryDoc[BranchMenu](ctx, b.s, tx, `SELECT doc FROM branch_menus WHERE branch = ?`+b.s.forUpdate(), menu.Branch)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		if stored.Version != menu.Version {
+			return ErrVersionConflict
+		}
+		menu.Version++
+		doc, err := marshalDoc(menu)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, b.s.rebind(`INSERT INTO branch_menus (branch, doc) VALUES (?, ?) ON CONFLICT (branch) DO UPDATE SET doc = excluded.doc`), menu.Branch, doc)
+		return err
+	})
+}
+
+type sqlRefunds struct{ s *sqlStore }
+
+func (f sqlRefunds) Add(ctx context.Context, refund Refund) error {
+	doc, err := marshalDoc(refund)
+	if err != nil {
+		return err
+	}
+	_, err = f.s.db.ExecContext(ctx, f.s.rebind(`INSERT INTO refunds (id, order_id, created_at, doc) VALUES (?, ?, ?, ?)`),
+		refund.ID.Hex(), refund.OrderID.Hex(), refund.CreatedAt.UnixNano(), doc)
+	return err
+}
+
+func (f sqlRefunds) ListForOrder(ctx context.Context, orderID primitive.ObjectID) ([]Refund, error) {
+	return queryDocs[Refund](ctx, f.s, f.s.db, `SELECT doc FROM refunds WHERE order_id = ? ORDER BY created_at`, orderID.Hex())
+}
+
+func (f sqlRefunds) ListBetween(ctx context.Context, from, to time.Time) ([]Refund, error) {
+	return queryDocs[Refund](ctx, f.s, f.s.db, `SELECT doc FROM refunds WHERE created_at >= ? AND created_at < ? ORDER BY created_at`, from.UnixNano(), to.UnixNano())
+}
+
+type sqlTwoFactor struct{ s *sqlStore }
+
+func (t sqlTwoFactor) Find(ctx context.Context, keyID primitive.ObjectID) (TwoFactor, error) {
+	return queryDoc[TwoFactor](ctx, t.s, t.s.db, `SELECT doc FROM two_factor WHERE key_id = ?`, keyID.Hex())
+}
+
+func (t sqlTwoFactor) Save(ctx context.Context, twoFactor TwoFactor) error {
+	return t.s.inTx(ctx, func(tx *sql.Tx) error {
+		stored, err := queryDoc[TwoFactor](ctx, t.s, tx, `SELECT doc FROM two_factor WHERE key_id = ?`+t.s.forUpdate(), twoFactor.KeyID.Hex())
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		if stored.Version != twoFactor.Version {
+			return ErrVersionConflict
+		}
+		twoFactor.Version++
+		doc, err := marshalDoc(twoFactor)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, t.s.rebind(`INSERT INTO two_factor (key_id, doc) VALUES (?, ?) ON CONFLICT (key_id) DO UPDATE SET doc = excluded.doc`), twoFactor.KeyID.Hex(), doc)
+		return err
+	})
+}
+
+func (t sqlTwoFactor) Delete(ctx context.Context, keyID primitive.ObjectID) error {
+	_, err := t.s.db.ExecContext(ctx, t.s.rebind(`DELETE FROM two_factor WHERE key_id = ?`), keyID.Hex())
+	return err
+}
+
+type sqlSessions struct{ s *sqlStore }
+
+func (e sqlSessions) Insert(ctx context.Context, session Session) error {
+	// The hash is not part of the JSON doc, so it only lives in its column
+	doc, err := marshalDoc(session)
+	if err != nil {
+		return err
+	}
+	_, err = e.s.db.ExecContext(ctx, e.s.rebind(`INSERT INTO sessions (id, key_id, hash, expires_at, doc) VALUES (?, ?, ?, ?, ?)`),
+		session.ID.Hex(), session.KeyID.Hex(), session.Hash, session.ExpiresAt.UnixNano(), doc)
+	return err
+}
+
+func (e sqlSessions) Find(ctx context.Context, id primitive.ObjectID) (Session, error) {
+	return queryDoc[Session](ctx, e.s, e.s.db, `SELECT doc FROM sessions WHERE id = ?`, id.Hex())
+}
+
+func (e sqlSessions) FindByHash(ctx context.Context, hash string) (Session, error) {
+	session, err := queryDoc[Session](ctx, e.s, e.s.db, `SELECT doc FROM sessions WHERE hash = ?`, hash)
+	session.Hash = hash
+	return session, err
+}
+
+func (e sqlSessions) ListForKey(ctx context.Context, keyID primitive.ObjectID) ([]Session, error) {
+	// Object ids start with their creation time, so ordering by id is ordering by age
+	return queryDocs[Session](ctx, e.s, e.s.db, `SELECT doc FROM sessions WHERE key_id = ? ORDER BY id DESC`, keyID.Hex())
+}
+
+func (e sqlSessions) Update(ctx context.Context, session Session) error {
+	doc, err := marshalDoc(session)
+	if err != nil {
+		return err
+	}
+	return expectRow(e.s.db.ExecContext(ctx, e.s.rebind(`UPDATE sessions SET expires_at = ?, doc = ? WHERE id = ?`),
+		session.ExpiresAt.UnixNano(), doc, session.ID.Hex()))
+}
+
+type sqlDevices struct{ s *sqlStore }
+
+func (d sqlDevices) Create(ctx context.Context, device Device) error {
+	// The hash is not part of the JSON doc, so it only lives in its column
+	doc, err := marshalDoc(device)
+	if err != nil {
+		return err
+	}
+	_, err = d.s.db.ExecContext(ctx, d.s.rebind(`INSERT INTO devices (id, tenant_id, name, hash, doc) VALUES (?, ?, ?, ?, ?)`),
+		device.ID.Hex(), device.TenantID, device.Name, device.Hash, doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (d sqlDevices) Find(ctx context.Context, id primitive.ObjectID) (Device, error) {
+	return d.queryDevice(ctx, `SELECT hash, doc FROM devices WHERE id = ?`, id.Hex())
+}
+
+func (d sqlDevices) FindByHash(ctx context.Context, hash string) (Device, error) {
+	return d.queryDevice(ctx, `SELECT hash, doc FROM devices WHERE hash = ?`, hash)
+}
+
+// queryDevice decodes a device, putting back the hash that is kept out of the doc
+func (d sqlDevices) queryDevice(ctx context.Context, query string, args ...any) (Device, error) {
+	var device Device
+	var hash, doc string
+	err := d.s.db.QueryRowContext(ctx, d.s.rebind(query), args...).Scan(&hash, &doc)
+	if err == sql.ErrNoRows {
+		return device, ErrNotFound
+	}
+	if err != nil {
+		return device, err
+	}
+	if err := json.Unmarshal([]byte(doc), &device); err != nil {
+		return device, err
+	}
+	device.Hash = hash
+	return device, nil
+}
+
+func (d sqlDevices) ListByTenant(ctx context.Context, tenantID string) ([]Device, error) {
+	// The hash is left empty; nothing that lists devices needs it
+	return queryDocs[Device](ctx, d.s, d.s.db, `SELECT doc FROM devices WHERE tenant_id = ? ORDER BY id`, tenantID)
+}
+
+func (d sqlDevices) Update(ctx context.Context, device Device) error {
+	doc, err := marshalDoc(device)
+	if err != nil {
+		return err
+	}
+	return expectRow(d.s.db.ExecContext(ctx, d.s.rebind(`UPDATE devices SET doc = ? WHERE id = ?`), doc, device.ID.Hex()))
+}
+
+type sqlParkedCarts struct{ s *sqlStore }
+
+func (c sqlParkedCarts) Park(ctx context.Context, cart ParkedCart) error {
+	doc, err := marshalDoc(cart)
+	if err != nil {
+		return err
+	}
+	var tableKey any
+	if cart.Table > 0 {
+		tableKey = cart.Table
+	}
+	return c.s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, c.s.rebind(`DELETE FROM parked_carts WHERE expires_at < ?`), cart.ParkedAt.UnixNano()); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, c.s.rebind(`INSERT INTO parked_carts (id, table_key, expires_at, doc) VALUES (?, ?, ?, ?)`),
+			cart.ID.Hex(), tableKey, cart.ExpiresAt.UnixNano(), doc)
+		if isUniqueViolation(err) {
+			return ErrDuplicate
+		}
+		return err
+	})
+}
+
+func (c sqlParkedCarts) List(ctx context.Context, now time.Time) ([]ParkedCart, error) {
+	return queryDocs[ParkedCart](ctx, c.s, c.s.db, `SELECT doc FROM parked_carts WHERE expires_at >= ? ORDER BY id`, now.UnixNano())
+}
+
+func (c sqlParkedCarts) Take(ctx context.Context, id primitive.ObjectID) (ParkedCart, error) {
+	var cart ParkedCart
+	err := c.s.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		if cart, err = queryDoc[ParkedCart](ctx, c.s, tx, `SELECT doc FROM parked_carts WHERE id = ?`+c.s.forUpdate(), id.Hex()); err != nil {
+			return err
+		}
+		return expectRow(tx.ExecContext(ctx, c.s.rebind(`DELETE FROM parked_carts WHERE id = ?`), id.Hex()))
+	})
+	return cart, err
+}
+
+type sqlStandingOrders struct{ s *sqlStore }
+
+func (o sqlStandingOrders) Insert(ctx context.Context, standing StandingOrder) error {
+	doc, err := marshalDoc(standing)
+	if err != nil {
+		return err
+	}
+	_, err = o.s.db.ExecContext(ctx, o.s.rebind(`INSERT INTO standing_orders (id, doc) VALUES (?, ?)`), standing.ID.Hex(), doc)
+	return err
+}
+
+func (o sqlStandingOrders) Find(ctx context.Context, id primitive.ObjectID) (StandingOrder, error) {
+	return queryDoc[StandingOrder](ctx, o.s, o.s.db, `SELECT doc FROM standing_orders WHERE id = ?`, id.Hex())
+}
+
+func (o sqlStandingOrders) List(ctx context.Context) ([]StandingOrder, error) {
+	return queryDocs[StandingOrder](ctx, o.s, o.s.db, `SELECT doc FROM standing_orders ORDER BY id`)
+}
+
+func (o sqlStandingOrders) Update(ctx context.Context, standing StandingOrder) error {
+	return o.s.inTx(ctx, func(tx *sql.Tx) error {
+		stored, err := queryDoc[StandingOrder](ctx, o.s, tx, `SELECT doc FROM standing_orders WHERE id = ?`+o.s.forUpdate(), standing.ID.Hex())
+		if err != nil {
+			return err
+		}
+		if stored.Version != standing.Version {
+			return ErrVersionConflict
+		}
+		standing.Version++
+		doc, err := marshalDoc(standing)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, o.s.rebind(`UPDATE standing_orders SET doc = ? WHERE id = ?`), doc, standing.ID.Hex())
+		return err
+	})
+}
+
+type sqlContainers struct{ s *sqlStore }
+
+func (c sqlContainers) Insert(ctx context.Context, loan ContainerLoan) error {
+	doc, err := marshalDoc(loan)
+	if err != nil {
+		return err
+	}
+	_, err = c.s.db.ExecContext(ctx, c.s.rebind(`INSERT INTO container_loans (id, outstanding, lent_at, doc) VALUES (?, ?, ?, ?)`),
+		loan.ID.Hex(), loan.Outstanding, loan.LentAt.UnixNano(), doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (c sqlContainers) Find(ctx context.Context, orderID primitive.ObjectID) (ContainerLoan, error) {
+	return queryDoc[ContainerLoan](ctx, c.s, c.s.db, `SELECT doc FROM container_loans WHERE id = ?`, orderID.Hex())
+}
+
+func (c sqlContainers) Update(ctx context.Context, loan ContainerLoan) error {
+	return c.s.inTx(ctx, func(tx *sql.Tx) error {
+		stored, err := queryDoc[ContainerLoan](ctx, c.s, tx, `SELECT doc FROM container_loans WHERE id = ?`+c.s.forUpdate(), loan.ID.Hex())
+		if err != nil {
+			return err
+		}
+		if stored.Version != loan.Version {
+			return ErrVersionConflict
+		}
+		loan.Version++
+		doc, err := marshalDoc(loan)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, c.s.rebind(`UPDATE container_loans SET outstanding = ?, doc = ? WHERE id = ?`), loan.Outstanding, doc, loan.ID.Hex())
+		return err
+	})
+}
+
+func (c sqlContainers) ListOutstanding(ctx context.Context) ([]ContainerLoan, error) {
+	return queryDocs[ContainerLoan](ctx, c.s, c.s.db, `SELECT doc FROM container_loans WHERE outstanding > 0 ORDER BY lent_at`)
+}
+
+type sqlDeliveryZones struct{ s *sqlStore }
+
+func (z sqlDeliveryZones) Save(ctx context.Context, zone DeliveryZone) error {
+	doc, err := marshalDoc(zone)
+	if err != nil {
+		return err
+	}
+	_, err = z.s.db.ExecContext(ctx, z.s.rebind(`INSERT INTO delivery_zones (name, doc) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET doc = excluded.doc`),
+		zone.Name, doc)
+	return err
+}
+
+func (z sqlDeliveryZones) List(ctx context.Context) ([]DeliveryZone, error) {
+	return queryDocs[DeliveryZone](ctx, z.s, z.s.db, `SELECT doc FROM delivery_zones ORDER BY name`)
+}
+
+func (z sqlDeliveryZones) Delete(ctx context.Context, name string) error {
+	return expectRow(z.s.db.ExecContext(ctx, z.s.rebind(`DELETE FROM delivery_zones WHERE name = ?`), name))
+}
+
+type sqlDrivers struct{ s *sqlStore }
+
+func (d sqlDrivers) AddCollection(ctx context.Context, collection CODCollection) error {
+	doc, err := marshalDoc(collection)
+	if err != nil {
+		return err
+	}
+	_, err = d.s.db.ExecContext(ctx, d.s.rebind(`INSERT INTO cod_collections (id, driver, settlement_id, collected_at, doc) VALUES (?, ?, '', ?, ?)`),
+		collection.ID.Hex(), collection.Driver, collection.CollectedAt.UnixNano(), doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (d sqlDrivers) ListUnsettled(ctx context.Context) ([]CODCollection, error) {
+	return queryDocs[CODCollection](ctx, d.s, d.s.db, `SELECT doc FROM cod_collections WHERE settlement_id = '' ORDER BY collected_at`)
+}
+
+func (d sqlDrivers) Claim(ctx context.Context, driver string, settlementID primitive.ObjectID, upTo time.Time) ([]CODCollection, error) {
+	var claimed []CODCollection
+	err := d.s.inTx(ctx, func(tx *sql.Tx) error {
+		collections, err := queryDocs[CODCollection](ctx, d.s, tx, `SELECT doc FROM cod_collections WHERE settlement_id = '' AND driver = ? AND collected_at <= ?
+			ORDER BY collected_at`+d.s.forUpdate(), driver, upTo.UnixNano())
+		if err != nil {
+			return err
+		}
+		for i := range collections {
+			collections[i].SettlementID = settlementID
+			doc, err := marshalDoc(collections[i])
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, d.s.rebind(`UPDATE cod_collections SET settlement_id = ?, doc = ? WHERE id = ?`),
+				settlementID.Hex(), doc, collections[i].ID.Hex()); err != nil {
+				return err
+			}
+		}
+		claimed = collections
+		return nil
+	})
+	return claimed, err
+}
+
+func (d sqlDrivers) Release(ctx context.Context, settlementID primitive.ObjectID) error {
+	return d.s.inTx(ctx, func(tx *sql.Tx) error {
+		collections, err := queryDocs[CODCollection](ctx, d.s, tx, `SELECT doc FROM cod_collections WHERE settlement_id = ?`+d.s.forUpdate(), settlementID.Hex())
+		if err != nil {
+			return err
+		}
+		for _, collection := range collections {
+			collection.SettlementID = primitive.NilObjectID
+			doc, err := marshalDoc(collection)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, d.s.rebind(`UPDATE cod_collections SET settlement_id = '', doc = ? WHERE id = ?`), doc, collection.ID.Hex()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (d sqlDrivers) AddSettlement(ctx context.Context, settlement DriverSettlement) error {
+	doc, err := marshalDoc(settlement)
+	if err != nil {
+		return err
+	}
+	_, err = d.s.db.ExecContext(ctx, d.s.rebind(`INSERT INTO driver_settlements (id, settled_at, doc) VALUES (?, ?, ?)`),
+		settlement.ID.Hex(), settlement.SettledAt.UnixNano(), doc)
+	return err
+}
+
+func (d sqlDrivers) ListSettlements(ctx context.Context, from, to time.Time) ([]DriverSettlement, error) {
+	return queryDocs[DriverSettlement](ctx, d.s, d.s.db, `SELECT doc FROM driver_settlements WHERE settled_at >= ? AND settled_at < ? ORDER BY settled_at`,
+		from.UnixNano(), to.UnixNano())
+}
+
+type sqlComplaints struct{ s *sqlStore }
+
+func (c sqlComplaints) Insert(ctx context.Context, complaint Complaint) error {
+	doc, err := marshalDoc(complaint)
+	if err != nil {
+		return err
+	}
+	_, err = c.s.db.ExecContext(ctx, c.s.rebind(`INSERT INTO complaints (id, created_at, doc) VALUES (?, ?, ?)`),
+		complaint.ID.Hex(), complaint.CreatedAt.UnixNano(), doc)
+	return err
+}
+
+func (c sqlComplaints) Find(ctx context.Context, id primitive.ObjectID) (Complaint, error) {
+	return queryDoc[Complaint](ctx, c.s, c.s.db, `SELECT doc FROM complaints WHERE id = ?`, id.Hex())
+}
+
+func (c sqlComplaints) Update(ctx context.Context, complaint Complaint) error {
+	return c.s.inTx(ctx, func(tx *sql.Tx) error {
+		stored, err := queryDoc[Complaint](ctx, c.s, tx, `SELECT doc FROM complaints WHERE id = ?`+c.s.forUpdate(), complaint.ID.Hex())
+		if err != nil {
+			return err
+		}
+		if stored.Version != complaint.Version {
+			return ErrVersionConflict
+		}
+		complaint.Version++
+		doc, err := marshalDoc(complaint)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, c.s.rebind(`UPDATE complaints SET doc = ? WHERE id = ?`), doc, complaint.ID.Hex())
+		return err
+	})
+}
+
+func (c sqlComplaints) ListBetween(ctx context.Context, from, to time.Time) ([]Complaint, error) {
+	return queryDocs[Complaint](ctx, c.s, c.s.db, `SELECT doc FROM complaints WHERE created_at >= ? AND created_at < ? ORDER BY created_at`,
+		from.UnixNano(), to.UnixNano())
+}
+
+type sqlSurveys struct{ s *sqlStore }
+
+func (v sqlSurveys) Insert(ctx context.Context, survey Survey) error {
+	// The token is not part of the JSON doc, so it only lives in its column
+	doc, err := marshalDoc(survey)
+	if err != nil {
+		return err
+	}
+	_, err = v.s.db.ExecContext(ctx, v.s.rebind(`INSERT INTO surveys (id, token, answered_at, sent_at, doc) VALUES (?, ?, 0, ?, ?)`),
+		survey.ID.Hex(), survey.Token, survey.SentAt.UnixNano(), doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (v sqlSurveys) FindByToken(ctx context.Context, token string) (Survey, error) {
+	survey, err := queryDoc[Survey](ctx, v.s, v.s.db, `SELECT doc FROM surveys WHERE token = ?`, token)
+	survey.Token = token
+	return survey, err
+}
+
+func (v sqlSurveys) FindByOrder(ctx context.Context, id primitive.ObjectID) (Survey, error) {
+	var token string
+	err := v.s.db.QueryRowContext(ctx, v.s.rebind(`SELECT token FROM surveys WHERE id = ?`), id.Hex()).Scan(&token)
+	if err == sql.ErrNoRows {
+		return Survey{}, ErrNotFound
+	}
+	if err != nil {
+		return Survey{}, err
+	}
+	return v.FindByToken(ctx, token)
+}
+
+func (v sqlSurveys) Answer(ctx context.Context, survey Survey) error {
+	doc, err := marshalDoc(survey)
+	if err != nil {
+		return err
+	}
+	result, err := v.s.db.ExecContext(ctx, v.s.rebind(`UPDATE surveys SET answered_at = ?, doc = ? WHERE id = ? AND answered_at = 0`),
+		survey.AnsweredAt.UnixNano(), doc, survey.ID.Hex())
+	return expectRow(result, err)
+}
+
+func (v sqlSurveys) ListBetween(ctx context.Context, from, to time.Time) ([]Survey, error) {
+	return queryDocs[Survey](ctx, v.s, v.s.db, `SELECT doc FROM surveys WHERE sent_at >= ? AND sent_at < ? ORDER BY sent_at`,
+		from.UnixNano(), to.UnixNano())
+}
+
+type sqlNotificationQueue struct{ s *sqlStore }
+
+func (q sqlNotificationQueue) Add(ctx context.Context, queued QueuedNotification) error {
+	doc, err := marshalDoc(queued)
+	if err != nil {
+		return err
+	}
+	_, err = q.s.db.ExecContext(ctx, q.s.rebind(`INSERT INTO notification_queue (id, send_after, doc) VALUES (?, ?, ?)`),
+		queued.ID.Hex(), queued.SendAfter.UnixNano(), doc)
+	return err
+}
+
+func (q sqlNotificationQueue) ListDue(ctx context.Context, now time.Time) ([]QueuedNotification, error) {
+	return queryDocs[QueuedNotification](ctx, q.s, q.s.db, `SELECT doc FROM notification_queue WHERE send_after <= ? ORDER BY send_after`, now.UnixNano())
+}
+
+func (q sqlNotificationQueue) Remove(ctx context.Context, id primitive.ObjectID) error {
+	result, err := q.s.db.ExecContext(ctx, q.s.rebind(`DELETE FROM notification_queue WHERE id = ?`), id.Hex())
+	return expectRow(result, err)
+}
+
+type sqlTemplates struct{ s *sqlStore }
+
+func (t sqlTemplates) Insert(ctx context.Context, tmpl MessageTemplate) error {
+	doc, err := marshalDoc(tmpl)
+	if err != nil {
+		return err
+	}
+	_, err = t.s.db.ExecContext(ctx, t.s.rebind(`INSERT INTO message_templates (name, version, doc) VALUES (?, ?, ?)`),
+		tmpl.Name, tmpl.Version, doc)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (t sqlTemplates) Latest(ctx context.Context, name string) (MessageTemplate, error) {
+	return queryDoc[MessageTemplate](ctx, t.s, t.s.db, `SELECT doc FROM message_templates WHERE name = ? ORDER BY version DESC LIMIT 1`, name)
+}
+
+func (t sqlTemplates) Find(ctx context.Context, name string, version int) (MessageTemplate, error) {
+	return queryDoc[MessageTemplate](ctx, t.s, t.s.db, `SELECT doc FROM message_templates WHERE name = ? AND version = ?`, name, version)
+}
+
+func (t sqlTemplates) List(ctx context.Context, name string) ([]MessageTemplate, error) {
+	return queryDocs[MessageTemplate](ctx, t.s, t.s.db, `SELECT doc FROM message_templates WHERE name = ? ORDER BY version DESC`, name)
+}
+
+type sqlPrintJobs struct{ s *sqlStore }
+
+func (p sqlPrintJobs) Add(ctx context.Context, job PrintJob) error {
+	job.Version = 1
+	doc, err := marshalDoc(job)
+	if err != nil {
+		return err
+	}
+	_, err = p.s.db.ExecContext(ctx, p.s.rebind(`INSERT INTO print_jobs (id, status, next_attempt_at, created_at, doc) VALUES (?, ?, ?, ?, ?)`),
+		job.ID.Hex(), job.Status, job.NextAttemptAt.UnixNano(), job.CreatedAt.UnixNano(), doc)
+	return err
+}
+
+func (p sqlPrintJobs) Find(ctx context.Context, id primitive.ObjectID) (PrintJob, error) {
+	return queryDoc[PrintJob](ctx, p.s, p.s.db, `SELECT doc FROM print_jobs WHERE id = ?`, id.Hex())
+}
+
+func (p sqlPrintJobs) Update(ctx context.Context, job PrintJob) error {
+	return p.s.inTx(ctx, func(tx *sql.Tx) error {
+		stored, err := queryDoc[PrintJob](ctx, p.s, tx, `SELECT doc FROM print_jobs WHERE id = ?`+p.s.forUpdate(), job.ID.Hex())
+		if err != nil {
+			return err
+		}
+		if stored.Version != job.Version {
+			return ErrVersionConflict
+		}
+		job.Version++
+		doc, err := marshalDoc(job)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, p.s.rebind(`UPDATE print_jobs SET status = ?, next_attempt_at = ?, doc = ? WHERE id = ?`),
+			job.Status, job.NextAttemptAt.UnixNano(), doc, job.ID.Hex())
+		return err
+	})
+}
+
+func (p sqlPrintJobs) ListDue(ctx context.Context, now time.Time) ([]PrintJob, error) {
+	return queryDocs[PrintJob](ctx, p.s, p.s.db, `SELECT doc FROM print_jobs WHERE status = ? AND next_attempt_at <= ? ORDER BY created_at`,
+		PrintQueued, now.UnixNano())
+}
+
+func (p sqlPrintJobs) ListSince(ctx context.Context, since time.Time) ([]PrintJob, error) {
+	return queryDocs[PrintJob](ctx, p.s, p.s.db, `SELECT doc FROM print_jobs WHERE created_at >= ? ORDER BY created_at DESC`, since.UnixNano())
+}
+
+type sqlFXExchanges struct{ s *sqlStore }
+
+func (f sqlFXExchanges) Add(ctx context.Context, exchange FXExchange) error {
+	doc, err := marshalDoc(exchange)
+	if err != nil {
+		return err
+	}
+	_, err = f.s.db.ExecContext(ctx, f.s.rebind(`INSERT INTO fx_exchanges (id, created_at, doc) VALUES (?, ?, ?)`),
+		exchange.ID.Hex(), exchange.CreatedAt.UnixNano(), doc)
+	return err
+}
+
+func (f sqlFXExchanges) ListBetween(ctx context.Context, from, to time.Time) ([]FXExchange, error) {
+	return queryDocs[FXExchange](ctx, f.s, f.s.db, `SELECT doc FROM fx_exchanges WHERE created_at >= ? AND created_at < ? ORDER BY created_at`,
+		from.UnixNano(), to.UnixNano())
+}
+
+type sqlLogBook struct{ s *sqlStore }
+
+func (l sqlLogBook) Insert(ctx context.Context, entry LogEntry) error {
+	doc, err := marshalDoc(entry)
+	if err != nil {
+		return err
+	}
+	_, err = l.s.db.ExecContext(ctx, l.s.rebind(`INSERT INTO log_book (id, date, created_at, pending, doc) VALUES (?, ?, ?, ?, ?)`),
+		entry.ID.Hex(), entry.Date, entry.CreatedAt.UnixNano(), logPending(entry), doc)
+	return err
+}
+
+func (l sqlLogBook) Find(ctx context.Context, id primitive.ObjectID) (LogEntry, error) {
+	return queryDoc[LogEntry](ctx, l.s, l.s.db, `SELECT doc FROM log_book WHERE id = ?`, id.Hex())
+}
+
+func (l sqlLogBook) Update(ctx context.Context, entry LogEntry) error {
+	return l.s.inTx(ctx, func(tx *sql.Tx) error {
+		stored, err := queryDoc[LogEntry](ctx, l.s, tx, `SELECT doc FROM log_book WHERE id = ?`+l.s.forUpdate(), entry.ID.Hex())
+		if err != nil {
+			return err
+		}
+		if stored.Version != entry.Version {
+			return ErrVersionConflict
+		}
+		entry.Version++
+		doc, err := marshalDoc(entry)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, l.s.rebind(`UPDATE log_book SET pending = ?, doc = ? WHERE id = ?`), logPending(entry), doc, entry.ID.Hex())
+		return err
+	})
+}
+
+func (l sqlLogBook) ListBetween(ctx context.Context, from, to string) ([]LogEntry, error) {
+	return queryDocs[LogEntry](ctx, l.s, l.s.db, `SELECT doc FROM log_book WHERE date >= ? AND date < ? ORDER BY date, created_at`, from, to)
+}
+
+func (l sqlLogBook) ListOpen(ctx context.Context) ([]LogEntry, error) {
+	return queryDocs[LogEntry](ctx, l.s, l.s.db, `SELECT doc FROM log_book WHERE pending = 1 ORDER BY created_at`)
+}
+
+// logPending is 1 for a follow-up not yet done, which the pending column finds quickly, and 0 otherwise
+func logPending(entry LogEntry) int {
+	if entry.Open() {
+		return 1
+	}
+	return 0
+}
+
+type sqlBudgets struct{ s *sqlStore }
+
+func (b sqlBudgets) Save(ctx context.Context, budget Budget) error {
+	doc, err := marshalDoc(budget)
+	if err != nil {
+		return err
+	}
+	_, err = b.s.db.ExecContext(ctx, b.s.rebind(`INSERT INTO budgets (month, doc) VALUES (?, ?) ON CONFLICT (month) DO UPDATE SET doc = excluded.doc`),
+		budget.Month, doc)
+	return err
+}
+
+func (b sqlBudgets) Find(ctx context.Context, month string) (Budget, error) {
+	return queryDoc[Budget](ctx, b.s, b.s.db, `SELECT doc FROM budgets WHERE month = ?`, month)
+}
+
+func (b sqlBudgets) List(ctx context.Context) ([]Budget, error) {
+	return queryDocs[Budget](ctx, b.s, b.s.db, `SELECT doc FROM budgets ORDER BY month DESC`)
+}
+
+// sqlChanges polls for changes, as SQLite and Postgres have no change streams
+type sqlChanges struct{ s *sqlStore }
+
+func (c sqlChanges) Watch(ctx context.Context, token string, changes chan<- Change) error {
+	return pollChanges(ctx, sqlOrders{c.s}, sqlPayments{c.s}, token, changes)
+}
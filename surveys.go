@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// surveyValidity is how long a customer has to answer a survey once it is sent
+	surveyValidity = 7 * 24 * time.Hour
+	// maxSurveyComment caps what a customer writes in a survey
+	maxSurveyComment = 1000
+)
+
+// ErrSurveyClosed is returned for a survey that has been answered or has expired
+var ErrSurveyClosed = errors.New("survey cannot be answered")
+
+// sendSurveys is whether customers are sent a survey link once their bill is settled, from RMS_SURVEYS
+var sendSurveys = true
+
+// Survey asks a customer how likely they are to recommend the restaurant, from 0 to 10, after a settled bill
+type Survey struct {
+	ID           primitive.ObjectID `bson:"_id" json:"orderId"` // The order's; each order is surveyed once
+	Token        string             `bson:"token" json:"-"`     // Secret in the survey link
+	CustomerName string             `bson:"customerName" json:"customerName"`
+	Branch       string             `bson:"branch,omitempty" json:"branch,omitempty"`
+	SentAt       time.Time          `bson:"sentAt" json:"sentAt"`
+	ExpiresAt    time.Time          `bson:"expiresAt" json:"expiresAt"`
+	Score        *int               `bson:"score,omitempty" json:"score,omitempty"`
+	Comment      string             `bson:"comment,omitempty" json:"comment,omitempty"`
+	AnsweredAt   *time.Time         `bson:"answeredAt,omitempty" json:"answeredAt,omitempty"`
+}
+
+// FindSurveyByToken loads the survey a link is for
+func FindSurveyByToken(ctx context.Context, token string) (Survey, error) {
+	return storeFor(ctx).Surveys().FindByToken(ctx, token)
+}
+
+// surveyLink is the page where the customer answers the survey. In SaaS mode it names the tenant, like a quote's.
+func surveyLink(ctx context.Context, survey Survey) string {
+	link := publicURL + "/surveys/" + survey.Token
+	if tenant, ok := TenantFrom(ctx); ok {
+		link += "?tenant=" + tenant.ID
+	}
+	return link
+}
+
+// sendSurvey sends the customer of a settled order a link to the survey, once per order. Customers without a
+// phone number, or who did not agree to marketing on it, are not surveyed.
+func sendSurvey(ctx context.Context, order Order) error {
+	if !sendSurveys || !order.Paid || !order.RemakeOf.IsZero() {
+		return nil
+	}
+	customer, err := storeFor(ctx).Customers().FindByName(ctx, order.CustomerName)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if customer.Phone == "" || !customer.Communication.allows(MessageMarketing, phoneChannel) {
+		return nil
+	}
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	now := clock.Now()
+	survey := Survey{
+		ID: order.ID, Token: hex.EncodeToString(secret), CustomerName: order.CustomerName, Branch: order.Branch,
+		SentAt: now, ExpiresAt: now.Add(surveyValidity),
+	}
+	if err := storeFor(ctx).Surveys().Insert(ctx, survey); err != nil {
+		if errors.Is(err, ErrDuplicate) {
+			return nil
+		}
+		return err
+	}
+	invitation := templateMessage(ctx, TemplateSurvey, customer.Phone, map[string]string{
+		"customerName": order.CustomerName, "link": surveyLink(ctx, survey),
+	})
+	invitation.Kind = MessageMarketing
+	Notify(ctx, invitation)
+	return nil
+}
+
+// AnswerSurvey records the customer's score from 0 to 10 and comment from the survey link. A survey is answered
+// once, before it expires.
+func AnswerSurvey(ctx context.Context, token string, score int, comment string) (Survey, error) {
+	comment = strings.TrimSpace(comment)
+	if score < 0 || score > 10 {
+		return Survey{}, fmt.Errorf("score must be from 0 to 10")
+	}
+	if utf8.RuneCountInString(comment) > maxSurveyComment {
+		return Survey{}, fmt.Errorf("the comment must be at most %d characters", maxSurveyComment)
+	}
+	survey, err := FindSurveyByToken(ctx, token)
+	if err != nil {
+		return Survey{}, err
+	}
+	now := clock.Now()
+	switch {
+	case survey.AnsweredAt != nil:
+		return Survey{}, fmt.Errorf("%w: it was already answered", ErrSurveyClosed)
+	case now.After(survey.ExpiresAt):
+		return Survey{}, fmt.Errorf("%w: it expired on %s", ErrSurveyClosed, survey.ExpiresAt.Format("02 Jan 2006"))
+	}
+	survey.Score, survey.Comment, survey.AnsweredAt = &score, comment, &now
+	if err := storeFor(ctx).Surveys().Answer(ctx, survey); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return Survey{}, fmt.Errorf("%w: it was already answered", ErrSurveyClosed)
+		}
+		return Survey{}, err
+	}
+	return survey, nil
+}
+
+// NPSScore is the net promoter score of the surveys sent in one month at one branch
+type NPSScore struct {
+	Month      string  `json:"month"`            // YYYY-MM
+	Branch     string  `json:"branch,omitempty"` // Empty for the main one
+	Sent       int     `json:"sent"`
+	Answered   int     `json:"answered"`
+	Promoters  int     `json:"promoters"`  // Scored 9 or 10
+	Passives   int     `json:"passives"`   // Scored 7 or 8
+	Detractors int     `json:"detractors"` // Scored 0 to 6
+	NPS        float64 `json:"nps"`        // Percent promoters minus percent detractors, from -100 to 100
+}
+
+// NPSTrend works out the net promoter score by month and branch for the surveys sent in the months from and to
+// (YYYY-MM), oldest month first
+func NPSTrend(ctx context.Context, fromMonth, toMonth string) ([]NPSScore, error) {
+	from, _, err := monthRange(fromMonth)
+	if err != nil {
+		return nil, err
+	}
+	_, to, err := monthRange(toMonth)
+	if err != nil {
+		return nil, err
+	}
+	if !from.Before(to) {
+		return nil, fmt.Errorf("%s is after %s", fromMonth, toMonth)
+	}
+	surveys, err := storeFor(ctx).Surveys().ListBetween(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	trend := []NPSScore{}
+	for _, survey := range surveys {
+		month := survey.SentAt.Local().Format("2006-01")
+		i := slices.IndexFunc(trend, func(s NPSScore) bool { return s.Month == month && s.Branch == survey.Branch })
+		if i < 0 {
+			trend = append(trend, NPSScore{Month: month, Branch: survey.Branch})
+			i = len(trend) - 1
+		}
+		trend[i].Sent++
+		if survey.Score == nil {
+			continue
+		}
+		trend[i].Answered++
+		switch score := *survey.Score; {
+		case score >= 9:
+			trend[i].Promoters++
+		case score >= 7:
+			trend[i].Passives++
+		default:
+			trend[i].Detractors++
+		}
+	}
+	for i, score := range trend {
+		if score.Answered > 0 {
+			trend[i].NPS = math.Round(float64(score.Promoters-score.Detractors)*1000/float64(score.Answered)) / 10
+		}
+	}
+	slices.SortFunc(trend, func(a, b NPSScore) int {
+		if c := strings.Compare(a.Month, b.Month); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Branch, b.Branch)
+	})
+	return trend, nil
+}
+
+// ShowNPSTrend prints the net promoter score by month and branch
+func ShowNPSTrend(ctx context.Context, fromMonth, toMonth string) error {
+	trend, err := NPSTrend(ctx, fromMonth, toMonth)
+	if err != nil {
+		return err
+	}
+	npsListing := listing{
+		title:   fmt.Sprintf("Net promoter score, %s to %s:", fromMonth, toMonth),
+		header:  []string{"Month", "Branch", "Sent", "Answered", "Promoters", "Passives", "Detractors", "NPS"},
+		records: trend,
+	}
+	for _, s := range trend {
+		branch := s.Branch
+		if branch == "" {
+			branch = mainBranch
+		}
+		npsListing.rows = append(npsListing.rows, []string{
+			s.Month, branch, fmt.Sprint(s.Sent), fmt.Sprint(s.Answered), fmt.Sprint(s.Promoters), fmt.Sprint(s.Passives),
+			fmt.Sprint(s.Detractors), fmt.Sprintf("%.1f", s.NPS),
+		})
+		npsListing.compact = append(npsListing.compact, fmt.Sprintf("%s %s: NPS %.1f from %d of %d answered", s.Month, branch,
+			s.NPS, s.Answered, s.Sent))
+	}
+	return printListing(os.Stdout, npsListing)
+}
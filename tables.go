@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Table represents a dining table in the restaurant
+type Table struct {
+	Number   int  `bson:"number" json:"number"`
+	Seats    int  `bson:"seats" json:"seats"`
+	Occupied bool `bson:"occupied" json:"occupied"`
+}
+
+// TableSitting is one party's time at a table, from being seated until the table was freed
+type TableSitting struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Open      bool               `bson:"open,omitempty" json:"open"`
+	Table     int                `bson:"table" json:"table"`
+	Seats     int                `bson:"seats" json:"seats"` // The table's seats at the time
+	SeatedAt  time.Time          `bson:"seatedAt" json:"seatedAt"`
+	VacatedAt *time.Time         `bson:"vacatedAt,omitempty" json:"vacatedAt,omitempty"`
+}
+
+// AddTables adds the predefined dining tables, skipping any that already exist
+func AddTables(ctx context.Context) {
+	tables := []Table{
+		{Number: 1, Seats: 2},
+		{Number: 2, Seats: 2},
+		{Number: 3, Seats: 4},
+		{Number: 4, Seats: 4},
+		{Number: 5, Seats: 4},
+		{Number: 6, Seats: 6},
+		{Number: 7, Seats: 6},
+		{Number: 8, Seats: 8},
+	}
+
+	if err := storeFor(ctx).Tables().Seed(ctx, tables); err != nil {
+		log.Fatal("Error adding table:", err)
+	}
+	notify("Tables added to the database!")
+}
+
+// LoadTables returns every table ordered by table number
+func LoadTables(ctx context.Context) ([]Table, error) {
+	return storeFor(ctx).Tables().List(ctx)
+}
+
+// SetTableOccupied marks a table as occupied or free, recording when the party was seated and left for the
+// turnover report
+func SetTableOccupied(ctx context.Context, number int, occupied bool) error {
+	err := storeFor(ctx).Tables().SetOccupied(ctx, number, occupied)
+	if err == ErrNotFound {
+		return fmt.Errorf("no table number %d", number)
+	}
+	if err != nil {
+		return err
+	}
+	if occupied {
+		return seatTable(ctx, number, clock.Now())
+	}
+	return vacateTable(ctx, number, clock.Now())
+}
+
+// seatTable opens a sitting at the table, unless a party is already seated there
+func seatTable(ctx context.Context, number int, at time.Time) error {
+	tables, err := LoadTables(ctx)
+	if err != nil {
+		return err
+	}
+	sitting := TableSitting{ID: primitive.NewObjectID(), Open: true, Table: number, SeatedAt: at}
+	for _, table := range tables {
+		if table.Number == number {
+			sitting.Seats = table.Seats
+		}
+	}
+	err = storeFor(ctx).Sittings().Open(ctx, sitting)
+	if errors.Is(err, ErrDuplicate) {
+		return nil
+	}
+	return err
+}
+
+// vacateTable closes the sitting at the table. A table seated before sittings were recorded has none to close.
+func vacateTable(ctx context.Context, number int, at time.Time) error {
+	sitting, err := storeFor(ctx).Sittings().FindOpen(ctx, number)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	sitting.Open, sitting.VacatedAt = false, &at
+	err = storeFor(ctx).Sittings().Close(ctx, sitting)
+	if errors.Is(err, ErrNotFound) {
+		// Another terminal freed the table first
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TaxGST is the tax of a class under GST, split equally into central and state GST on the bill
+const TaxGST = "GST"
+
+// taxClassPattern is what a tax class or a tax is called, e.g. liquor or VAT
+var taxClassPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9 _-]{0,23}$`)
+
+// TaxClass is a kind of item taxed its own way: food under GST at 5%, liquor under the state's VAT, bottled
+// drinks under GST at 18%
+type TaxClass struct {
+	Name string  `json:"name"`
+	Tax  string  `json:"tax"`  // GST, or the name of a tax outside it printed as is, e.g. VAT
+	Rate float64 `json:"rate"` // Percentage included in menu prices, or added to them when they exclude tax
+}
+
+// Pricing modes, from RMS_PRICES: whether menu prices include tax, which the bill then breaks out of them, or
+// exclude it, and the bill adds it on top
+const (
+	PricesInclusive = "inclusive"
+	PricesExclusive = "exclusive"
+)
+
+// pricesExcludeTax is set when menu prices are before tax, from RMS_PRICES=exclusive
+var pricesExcludeTax bool
+
+// SetPrices sets whether menu prices include tax, "inclusive" or empty, or exclude it, "exclusive". Orders keep
+// the mode they were placed in.
+func SetPrices(mode string) error {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", PricesInclusive:
+		pricesExcludeTax = false
+	case PricesExclusive:
+		pricesExcludeTax = true
+	default:
+		return fmt.Errorf("invalid pricing mode %q (want %s or %s)", mode, PricesInclusive, PricesExclusive)
+	}
+	return nil
+}
+
+// taxClasses are the tax classes items can be put in, from RMS_TAX_CLASSES
+var taxClasses = map[string]TaxClass{}
+
+// categoryTaxClasses puts every item of a menu category in a tax class, from RMS_CATEGORY_TAX_CLASSES
+var categoryTaxClasses = map[string]string{}
+
+// SetTaxClasses sets the tax classes from a spec like "liquor=VAT:20,bottled=GST:18"; an empty spec leaves only the
+// default, GST at RMS_GST_RATE
+func SetTaxClasses(spec string) error {
+	classes := map[string]TaxClass{}
+	for _, part := range strings.Split(spec, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		name, tax, found := strings.Cut(part, "=")
+		tax, rate, hasRate := strings.Cut(tax, ":")
+		name, tax = strings.ToLower(strings.TrimSpace(name)), strings.ToUpper(strings.TrimSpace(tax))
+		percent, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+		if !found || !hasRate || err != nil || percent < 0 || percent > 100 || !taxClassPattern.MatchString(name) || !taxClassPattern.MatchString(tax) {
+			return fmt.Errorf("invalid tax class %q (want class=tax:percentage, e.g. liquor=VAT:20)", strings.TrimSpace(part))
+		}
+		classes[name] = TaxClass{Name: name, Tax: tax, Rate: percent}
+	}
+	taxClasses = classes
+	return nil
+}
+
+// SetCategoryTaxClasses puts menu categories in tax classes from a spec like "bar=liquor,drinks=bottled". The
+// classes must be set first.
+func SetCategoryTaxClasses(spec string) error {
+	categories := map[string]string{}
+	for _, part := range strings.Split(spec, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		category, class, found := strings.Cut(part, "=")
+		category, class = strings.ToLower(strings.TrimSpace(category)), strings.ToLower(strings.TrimSpace(class))
+		if !found || category == "" {
+			return fmt.Errorf("invalid category tax class %q (want category=class, e.g. bar=liquor)", strings.TrimSpace(part))
+		}
+		if _, ok := taxClasses[class]; !ok {
+			return fmt.Errorf("unknown tax class %q for category %s (want one of RMS_TAX_CLASSES)", class, category)
+		}
+		categories[category] = class
+	}
+	categoryTaxClasses = categories
+	return nil
+}
+
+// checkTaxClass returns an error unless class is empty or one of the tax classes
+func checkTaxClass(class string) error {
+	if _, ok := taxClasses[class]; class != "" && !ok {
+		names := []string{}
+		for name := range taxClasses {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return fmt.Errorf("unknown tax class %q (want one of %s)", class, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// itemTaxClass is how an item is taxed: its own tax class, else its own GST rate, else its category's class, else
+// GST at the default rate. A class taken out of RMS_TAX_CLASSES falls back the same way.
+func itemTaxClass(item MenuItem) TaxClass {
+	if class, ok := taxClasses[item.TaxClass]; ok && item.TaxClass != "" {
+		return class
+	}
+	if item.GSTRate != nil {
+		return TaxClass{Tax: TaxGST, Rate: *item.GSTRate}
+	}
+	if class, ok := taxClasses[categoryTaxClasses[strings.ToLower(item.Category)]]; ok {
+		return class
+	}
+	return TaxClass{Tax: TaxGST, Rate: gst.Rate}
+}
+
+// taxOnTop is the tax added to an order placed while menu prices excluded it: each line's at the rate it was
+// ordered at, on its price after the order's discount, and the charges' at the default rate, worked out as its
+// invoice breaks it out so the two agree
+func taxOnTop(order Order) float64 {
+	if !order.TaxOnTop {
+		return 0
+	}
+	var tax float64
+	for _, line := range order.Items {
+		if order.Discount != 0 {
+			line.Price = roundPaise(line.Price * (1 - order.Discount/100))
+		}
+		tax += lineTaxOnTop(line)
+	}
+	for _, charge := range order.Charges {
+		if !charge.Refundable {
+			tax += roundPaise(charge.Amount * gst.Rate / 100)
+		}
+	}
+	return roundPaise(tax)
+}
+
+// lineTaxOnTop is the tax added to a line's amount at the rate it was ordered at, 0 when prices included tax
+func lineTaxOnTop(line OrderLine) float64 {
+	return roundPaise(roundPaise(line.Amount()) * line.TaxRate / 100)
+}
+
+// TaxLine is one tax on a bill under its statutory label, added up over the lines it is charged on
+type TaxLine struct {
+	Label        string  `bson:"label" json:"label"` // e.g. CGST 2.5% or VAT 20%
+	Tax          string  `bson:"tax" json:"tax"`     // CGST, SGST or a tax outside GST, e.g. VAT
+	Rate         float64 `bson:"rate" json:"rate"`
+	TaxableValue float64 `bson:"taxableValue" json:"taxableValue"`
+	Amount       float64 `bson:"amount" json:"amount"`
+}
+
+// lineTaxes are the tax lines one invoice line adds to the bill
+func lineTaxes(line InvoiceLine) []TaxLine {
+	if line.Tax != "" {
+		return []TaxLine{{Tax: line.Tax, Rate: line.Rate, TaxableValue: line.TaxableValue, Amount: line.TaxAmount}}
+	}
+	return []TaxLine{
+		{Tax: "CGST", Rate: line.Rate / 2, TaxableValue: line.TaxableValue, Amount: line.CGST},
+		{Tax: "SGST", Rate: line.Rate / 2, TaxableValue: line.TaxableValue, Amount: line.SGST},
+	}
+}
+
+// addTaxLines adds share of the taxes to the bill's tax lines, merging those of the same tax and rate. GST comes
+// first, by rate, then the taxes outside it.
+func addTaxLines(bill []TaxLine, taxes []TaxLine, share float64) []TaxLine {
+	for _, tax := range taxes {
+		tax.Label = tax.Tax + " " + strconv.FormatFloat(tax.Rate, 'f', -1, 64) + "%"
+		i := slices.IndexFunc(bill, func(t TaxLine) bool { return t.Label == tax.Label })
+		if i < 0 {
+			bill = append(bill, TaxLine{Label: tax.Label, Tax: tax.Tax, Rate: tax.Rate})
+			i = len(bill) - 1
+		}
+		bill[i].TaxableValue = roundPaise(bill[i].TaxableValue + tax.TaxableValue*share)
+		bill[i].Amount = roundPaise(bill[i].Amount + tax.Amount*share)
+	}
+	slices.SortStableFunc(bill, func(a, b TaxLine) int {
+		if other := cmp.Compare(outsideGST(a), outsideGST(b)); other != 0 {
+			return other
+		}
+		if a.Rate != b.Rate {
+			return cmp.Compare(a.Rate, b.Rate)
+		}
+		return strings.Compare(a.Tax, b.Tax)
+	})
+	return bill
+}
+
+// outsideGST is 1 for a tax other than CGST and SGST, to sort it after them
+func outsideGST(tax TaxLine) int {
+	if tax.Tax == "CGST" || tax.Tax == "SGST" {
+		return 0
+	}
+	return 1
+}
+
+// invoiceTaxes returns the invoice's tax lines, working them out from its lines for invoices issued before they
+// were kept
+func invoiceTaxes(invoice Invoice) []TaxLine {
+	if len(invoice.Taxes) > 0 {
+		return invoice.Taxes
+	}
+	var taxes []TaxLine
+	for _, line := range invoice.Lines {
+		taxes = addTaxLines(taxes, lineTaxes(line), 1)
+	}
+	return taxes
+}
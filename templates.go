@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Messages and receipt text whose wording can be changed without a redeploy
+const (
+	TemplateOrderReady      = "order-ready"
+	TemplateTokenChanged    = "token-changed"
+	TemplateStandingOrder   = "standing-order"
+	TemplateQuote           = "quote"
+	TemplateOccasionOffer   = "occasion-offer"
+	TemplateComplaintCoupon = "complaint-coupon"
+	TemplateSurvey          = "survey"
+	TemplateReceiptHeader   = "receipt-header"
+	TemplateReceiptFooter   = "receipt-footer"
+)
+
+// maxTemplateLength caps a template's subject and body
+const maxTemplateLength = 2000
+
+// placeholderPattern matches a placeholder such as {{customerName}}
+var placeholderPattern = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// MessageTemplate is one version of the wording of a notification or of the text printed on receipts. Every edit
+// is kept as a new version and the latest is used; placeholders like {{customerName}} are filled in when it is.
+type MessageTemplate struct {
+	ID             primitive.ObjectID `bson:"_id" json:"-"`
+	Name           string             `bson:"name" json:"name"`
+	Version        int                `bson:"version" json:"version"` // 0 for the built-in wording, never saved
+	Subject        string             `bson:"subject,omitempty" json:"subject,omitempty"`
+	Body           string             `bson:"body" json:"body"`
+	Note           string             `bson:"note,omitempty" json:"note,omitempty"`
+	RolledBackFrom int                `bson:"rolledBackFrom,omitempty" json:"rolledBackFrom,omitempty"` // Version whose wording was restored
+	UpdatedBy      string             `bson:"updatedBy,omitempty" json:"updatedBy,omitempty"`
+	UpdatedAt      time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// templateDefault is a template's built-in wording and the placeholders it can use
+type templateDefault struct {
+	Description  string
+	Placeholders []string
+	Subject      string
+	Body         string
+}
+
+// defaultTemplates is the wording used until a template is edited
+var defaultTemplates = map[string]templateDefault{
+	TemplateOrderReady: {
+		Description:  "Sent when a takeaway token is ready to collect",
+		Placeholders: []string{"customerName", "number", "token", "total"},
+		Subject:      "Order ready",
+		Body:         "Order {{number}}, token {{token}}, is ready for pickup. Enjoy your meal!",
+	},
+	TemplateTokenChanged: {
+		Description:  "Sent when an order taken offline had to be given another token",
+		Placeholders: []string{"customerName", "oldToken", "token"},
+		Subject:      "Token changed",
+		Body:         "Your takeaway token {{oldToken}} has changed to {{token}}.",
+	},
+	TemplateStandingOrder: {
+		Description:  "Sent when a standing order is placed",
+		Placeholders: []string{"customerName", "due", "number", "token", "total"},
+		Subject:      "Standing order placed",
+		Body:         "Your standing order for {{due}} is placed: order {{number}}, token {{token}}, Rs {{total}}.",
+	},
+	TemplateQuote: {
+		Description:  "Sent with a catering quote to approve",
+		Placeholders: []string{"customerName", "fulfillAt", "total", "validUntil", "link"},
+		Subject:      "Your catering quote",
+		Body:         "Your quote for {{fulfillAt}} comes to Rs {{total}}. Please approve or decline it by {{validUntil}}: {{link}}",
+	},
+	TemplateOccasionOffer: {
+		Description:  "Sent with a coupon ahead of a customer's birthday or anniversary",
+		Placeholders: []string{"customerName", "greeting", "occasion", "code", "percent", "validUntil"},
+		Subject:      "{{greeting}}, {{customerName}}!",
+		Body:         "Celebrate with us: show code {{code}} for {{percent}}% off your bill, valid until {{validUntil}}.",
+	},
+	TemplateComplaintCoupon: {
+		Description:  "Sent with a coupon that makes up for a complaint",
+		Placeholders: []string{"customerName", "code", "percent", "validUntil"},
+		Subject:      "We are sorry",
+		Body:         "Sorry about your last order. Show code {{code}} for {{percent}}% off your next bill, valid until {{validUntil}}.",
+	},
+	TemplateSurvey: {
+		Description:  "Sent with the survey link once a bill is settled",
+		Placeholders: []string{"customerName", "link"},
+		Subject:      "How was your visit?",
+		Body:         "Thank you for visiting us! How likely are you to recommend us to a friend? Tell us here: {{link}}",
+	},
+	TemplateReceiptHeader: {
+		Description:  "Printed at the top of receipts; empty prints nothing",
+		Placeholders: []string{"customerName", "place", "number", "total"},
+	},
+	TemplateReceiptFooter: {
+		Description:  "Printed at the bottom of receipts; empty prints nothing",
+		Placeholders: []string{"customerName", "place", "number", "total"},
+	},
+}
+
+// TemplateView is a template's wording in use, with what it is for and the placeholders it can use
+type TemplateView struct {
+	MessageTemplate
+	Description  string   `json:"description"`
+	Placeholders []string `json:"placeholders"`
+}
+
+// templateNames lists the templates in a fixed order
+func templateNames() []string {
+	names := make([]string, 0, len(defaultTemplates))
+	for name := range defaultTemplates {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// FindTemplate returns the wording of a template in use: its latest version, or the built-in one if it was never
+// edited
+func FindTemplate(ctx context.Context, name string) (TemplateView, error) {
+	builtIn, ok := defaultTemplates[name]
+	if !ok {
+		return TemplateView{}, fmt.Errorf("template %q %w", name, ErrNotFound)
+	}
+	view := TemplateView{
+		MessageTemplate: MessageTemplate{Name: name, Subject: builtIn.Subject, Body: builtIn.Body},
+		Description:     builtIn.Description,
+		Placeholders:    builtIn.Placeholders,
+	}
+	latest, err := storeFor(ctx).Templates().Latest(ctx, name)
+	if errors.Is(err, ErrNotFound) {
+		return view, nil
+	}
+	if err != nil {
+		return TemplateView{}, err
+	}
+	view.MessageTemplate = latest
+	return view, nil
+}
+
+// ListTemplates returns every template's wording in use
+func ListTemplates(ctx context.Context) ([]TemplateView, error) {
+	views := []TemplateView{}
+	for _, name := range templateNames() {
+		view, err := FindTemplate(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// ListTemplateVersions returns every saved version of a template, newest first
+func ListTemplateVersions(ctx context.Context, name string) ([]MessageTemplate, error) {
+	if _, ok := defaultTemplates[name]; !ok {
+		return nil, fmt.Errorf("template %q %w", name, ErrNotFound)
+	}
+	versions, err := storeFor(ctx).Templates().List(ctx, name)
+	if versions == nil {
+		versions = []MessageTemplate{}
+	}
+	return versions, err
+}
+
+// SaveTemplate rewords a template as a new version, which is used from then on. With a version other than 0 the
+// template must still be at the version the wording was edited from.
+func SaveTemplate(ctx context.Context, name string, version int, subject, body, note string) (MessageTemplate, error) {
+	builtIn, ok := defaultTemplates[name]
+	if !ok {
+		return MessageTemplate{}, fmt.Errorf("template %q %w", name, ErrNotFound)
+	}
+	subject, body = strings.TrimSpace(subject), strings.TrimSpace(body)
+	if builtIn.Subject == "" && subject != "" {
+		return MessageTemplate{}, fmt.Errorf("%s has no subject", name)
+	}
+	if builtIn.Subject != "" && (subject == "" || body == "") {
+		return MessageTemplate{}, fmt.Errorf("subject and body are required")
+	}
+	for _, text := range []string{subject, body} {
+		if utf8.RuneCountInString(text) > maxTemplateLength {
+			return MessageTemplate{}, fmt.Errorf("subject and body must be at most %d characters", maxTemplateLength)
+		}
+		for _, match := range placeholderPattern.FindAllStringSubmatch(text, -1) {
+			if !slices.Contains(builtIn.Placeholders, match[1]) {
+				return MessageTemplate{}, fmt.Errorf("unknown placeholder %s in %s (want one of %s)", match[0], name,
+					strings.Join(builtIn.Placeholders, ", "))
+			}
+		}
+	}
+	return saveTemplateVersion(ctx, version, MessageTemplate{Name: name, Subject: subject, Body: body, Note: strings.TrimSpace(note)})
+}
+
+// RollbackTemplate saves the wording of an earlier version again, as a new version
+func RollbackTemplate(ctx context.Context, name string, to int) (MessageTemplate, error) {
+	old, err := storeFor(ctx).Templates().Find(ctx, name, to)
+	if err != nil {
+		return MessageTemplate{}, err
+	}
+	return saveTemplateVersion(ctx, 0, MessageTemplate{
+		Name: name, Subject: old.Subject, Body: old.Body, Note: fmt.Sprintf("rollback to version %d", to), RolledBackFrom: to,
+	})
+}
+
+// saveTemplateVersion stores tmpl as the template's next version
+func saveTemplateVersion(ctx context.Context, version int, tmpl MessageTemplate) (MessageTemplate, error) {
+	latest, err := storeFor(ctx).Templates().Latest(ctx, tmpl.Name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return MessageTemplate{}, err
+	}
+	if version != 0 && latest.Version != version {
+		return MessageTemplate{}, fmt.Errorf("template %s %w", tmpl.Name, ErrVersionConflict)
+	}
+	tmpl.ID, tmpl.Version = primitive.NewObjectID(), latest.Version+1
+	tmpl.UpdatedBy, tmpl.UpdatedAt = changedBy(ctx), clock.Now()
+	err = storeFor(ctx).Templates().Insert(ctx, tmpl)
+	if errors.Is(err, ErrDuplicate) {
+		return MessageTemplate{}, fmt.Errorf("template %s %w", tmpl.Name, ErrVersionConflict)
+	}
+	if err != nil {
+		return MessageTemplate{}, err
+	}
+	log.Printf("Template %s version %d saved by %s", tmpl.Name, tmpl.Version, tmpl.UpdatedBy)
+	return tmpl, nil
+}
+
+// renderTemplate fills a template's placeholders in with values, returning its subject and body. The built-in
+// wording is used if the saved one cannot be loaded, so a message is never lost to the database.
+func renderTemplate(ctx context.Context, name string, values map[string]string) (string, string) {
+	builtIn := defaultTemplates[name]
+	subject, body := builtIn.Subject, builtIn.Body
+	if !IsOffline() {
+		latest, err := storeFor(ctx).Templates().Latest(ctx, name)
+		switch {
+		case err == nil:
+			subject, body = latest.Subject, latest.Body
+		case !errors.Is(err, ErrNotFound):
+			log.Printf("Error loading template %s, using the built-in one: %v", name, err)
+		}
+	}
+	fill := func(text string) string {
+		return placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+			return values[placeholderPattern.FindStringSubmatch(placeholder)[1]]
+		})
+	}
+	return fill(subject), fill(body)
+}
+
+// templateMessage is a notification to the customer named in values, worded by a template
+func templateMessage(ctx context.Context, name, to string, values map[string]string) Notification {
+	subject, body := renderTemplate(ctx, name, values)
+	return Notification{To: to, Name: values["customerName"], Subject: subject, Message: body}
+}
+
+// ShowTemplates prints the wording of every template in use
+func ShowTemplates(ctx context.Context) error {
+	views, err := ListTemplates(ctx)
+	if err != nil {
+		return err
+	}
+	templateListing := listing{
+		title:   "Message templates:",
+		header:  []string{"Template", "Version", "Subject", "Body"},
+		records: views,
+	}
+	for _, view := range views {
+		version := "built-in"
+		if view.Version > 0 {
+			version = fmt.Sprint(view.Version)
+		}
+		templateListing.rows = append(templateListing.rows, []string{view.Name, version, view.Subject, view.Body})
+		templateListing.compact = append(templateListing.compact, fmt.Sprintf("%s (%s): %s", view.Name, version,
+			strings.TrimSpace(view.Subject+" "+view.Body)))
+	}
+	return printListing(os.Stdout, templateListing)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tenant is a business using the hosted (SaaS) service. Each tenant's restaurant data lives in
+// a database of its own; the shared store only holds tenants and their API keys.
+type Tenant struct {
+	ID        string    `bson:"_id" json:"id"` // Slug, also used to name the tenant's database
+	Name      string    `bson:"name" json:"name"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// tenantIDPattern keeps tenant IDs safe to use in database, schema and file names
+var tenantIDPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{2,31}$`)
+
+// tenants opens and caches each tenant's store. It is nil unless the server runs in SaaS mode.
+var tenants *tenantRegistry
+
+type tenantRegistry struct {
+	cfg    Config
+	mu     sync.Mutex
+	stores map[string]Store
+}
+
+// EnableTenants switches to SaaS mode, where restaurant data is only reachable through a tenant
+func EnableTenants(cfg Config) {
+	tenants = &tenantRegistry{cfg: cfg, stores: make(map[string]Store)}
+}
+
+// open returns the tenant's store, connecting and migrating it the first time it is used
+func (r *tenantRegistry) open(ctx context.Context, id string) (Store, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.stores[id]; ok {
+		return s, nil
+	}
+	s, err := OpenTenantStore(r.cfg, id)
+	if err != nil {
+		if s != nil {
+			s.Close(ctx)
+		}
+		return nil, err
+	}
+	if err := s.Migrate(ctx); err != nil {
+		s.Close(ctx)
+		return nil, err
+	}
+	r.stores[id] = s
+	return s, nil
+}
+
+// Close disconnects every tenant store that was opened
+func (r *tenantRegistry) Close(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, s := range r.stores {
+		s.Close(ctx)
+		delete(r.stores, id)
+	}
+}
+
+// OpenTenantStore connects to the tenant's own database: a database per tenant on MongoDB,
+// a file per tenant on SQLite and a schema per tenant on Postgres
+func OpenTenantStore(cfg Config, id string) (Store, error) {
+	if !tenantIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("invalid tenant id %q", id)
+	}
+	switch cfg.Backend {
+	case BackendMongo:
+		return OpenMongoStore(cfg.MongoURI, cfg.MongoDatabase+"_"+id)
+	case BackendSQLite:
+		ext := filepath.Ext(cfg.SQLitePath)
+		return OpenSQLStore("sqlite", strings.TrimSuffix(cfg.SQLitePath, ext)+"_"+id+ext)
+	case BackendPostgres:
+		return openPostgresSchema(cfg.PostgresURL, "tenant_"+id)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+}
+
+// openPostgresSchema connects with the schema first on the search path, so every query stays inside it
+func openPostgresSchema(dsn, schema string) (Store, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return openSQLStore("pgx", u.String(), schema)
+}
+
+// tenantScope is what a request acting for a tenant carries in its context
+type tenantScope struct {
+	tenant Tenant
+	store  Store
+}
+
+type tenantScopeKey struct{}
+
+// WithTenant returns a context whose restaurant data calls go to the tenant's store
+func WithTenant(ctx context.Context, tenant Tenant) (context.Context, error) {
+	s, err := tenants.open(ctx, tenant.ID)
+	if err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, tenantScopeKey{}, tenantScope{tenant: tenant, store: s}), nil
+}
+
+// TenantFrom returns the tenant a context acts for, if any
+func TenantFrom(ctx context.Context) (Tenant, bool) {
+	scope, ok := ctx.Value(tenantScopeKey{}).(tenantScope)
+	return scope.tenant, ok
+}
+
+// tenantStore returns the tenant's store from the context. In SaaS mode there is deliberately no
+// fallback: restaurant data reached without a tenant is a bug that could leak another tenant's data.
+func tenantStore(ctx context.Context) (Store, bool) {
+	if scope, ok := ctx.Value(tenantScopeKey{}).(tenantScope); ok {
+		return scope.store, true
+	}
+	if tenants != nil {
+		panic("restaurant data accessed without a tenant in SaaS mode")
+	}
+	return nil, false
+}
+
+// SignupTenant creates a tenant with its own empty database and issues its first API key, which has every scope
+func SignupTenant(ctx context.Context, id, name string) (Tenant, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Tenant{}, "", fmt.Errorf("name is required")
+	}
+	if id == "" {
+		id = tenantSlug(name)
+	}
+	if !tenantIDPattern.MatchString(id) {
+		return Tenant{}, "", fmt.Errorf("id must be 3-32 lowercase letters, digits or underscores, starting with a letter")
+	}
+
+	tenant := Tenant{ID: id, Name: name, CreatedAt: time.Now()}
+	if err := store.Tenants().Create(ctx, tenant); err != nil {
+		return Tenant{}, "", err
+	}
+	if _, err := tenants.open(ctx, id); err != nil {
+		return Tenant{}, "", err
+	}
+	key, _, err := IssueAPIKey(ctx, APIKey{TenantID: id, Name: "default", Scopes: []string{ScopeAll}})
+	if err != nil {
+		return Tenant{}, "", err
+	}
+	log.Printf("Tenant %s (%s) signed up", id, name)
+	return tenant, key, nil
+}
+
+// tenantSlug turns a business name into a tenant ID, e.g. "Raghav's Diner" into raghav_s_diner
+func tenantSlug(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "_"):
+			b.WriteByte('_')
+		}
+	}
+	slug := strings.TrimRight(b.String(), "_")
+	if len(slug) > 32 {
+		slug = strings.TrimRight(slug[:32], "_")
+	}
+	return slug
+}
+
+// forEachTenant runs fn for every tenant in SaaS mode, or once for the single restaurant otherwise
+func forEachTenant(ctx context.Context, fn func(ctx context.Context) error) error {
+	if tenants == nil {
+		return fn(ctx)
+	}
+	list, err := store.Tenants().List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, tenant := range list {
+		tenantCtx, err := WithTenant(ctx, tenant)
+		if err != nil {
+			return fmt.Errorf("tenant %s: %w", tenant.ID, err)
+		}
+		if err := fn(tenantCtx); err != nil {
+			return fmt.Errorf("tenant %s: %w", tenant.ID, err)
+		}
+	}
+	return nil
+}
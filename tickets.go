@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Flags a waiter can put on an order line so the kitchen cannot miss it
+const (
+	LineFlagAllergy = "ALLERGY" // The guest has an allergy the dish must be made safe for
+	LineFlagRush    = "RUSH"    // The dish is needed before anything else
+)
+
+// lineFlags are the known flags, in the order they are shown
+var lineFlags = []string{LineFlagAllergy, LineFlagRush}
+
+// cleanLineFlags checks the flags, ignoring case and repeats, and returns them in a fixed order so lines with
+// the same flags merge
+func cleanLineFlags(flags []string) ([]string, error) {
+	var cleaned []string
+	for _, flag := range flags {
+		flag = strings.ToUpper(strings.TrimSpace(flag))
+		if !slices.Contains(lineFlags, flag) {
+			return nil, fmt.Errorf("unknown flag %q (want %s)", flag, strings.Join(lineFlags, " or "))
+		}
+		if !slices.Contains(cleaned, flag) {
+			cleaned = append(cleaned, flag)
+		}
+	}
+	slices.SortFunc(cleaned, func(a, b string) int { return slices.Index(lineFlags, a) - slices.Index(lineFlags, b) })
+	return cleaned, nil
+}
+
+// toggleLineFlag returns the flags with the flag added, or removed if it was set
+func toggleLineFlag(flags []string, flag string) []string {
+	if i := slices.Index(flags, flag); i >= 0 {
+		return slices.Delete(slices.Clone(flags), i, i+1)
+	}
+	flags, _ = cleanLineFlags(append(slices.Clone(flags), flag))
+	return flags
+}
+
+// orderFlagged reports whether any line of the order has the flag
+func orderFlagged(order Order, flag string) bool {
+	return slices.ContainsFunc(order.Items, func(line OrderLine) bool { return slices.Contains(line.Flags, flag) })
+}
+
+// lineFlagPrefix is how a line's flags are printed in front of it, e.g. "!! ALLERGY !! "
+func lineFlagPrefix(line OrderLine) string {
+	if len(line.Flags) == 0 {
+		return ""
+	}
+	return "!! " + strings.Join(line.Flags, " ") + " !! "
+}
+
+// orderPlace is where the order is served, as printed at the top of its tickets and receipts
+func orderPlace(order Order) string {
+	if order.Token > 0 {
+		return fmt.Sprintf("Takeaway #%d", order.Token)
+	}
+	if order.Table > 0 {
+		return fmt.Sprintf("Table %d", order.Table)
+	}
+	if order.Type == OrderDelivery {
+		return "Delivery"
+	}
+	if order.Type == OrderStaffMeal {
+		return "Staff meal"
+	}
+	return "Counter"
+}
+
+// FormatKitchenTicket lays the order out for a kitchen printer: plain text, a line per dish, with flagged lines and
+// the order's notes standing out. Held courses are left off until they are fired.
+func FormatKitchenTicket(order Order) string {
+	var b strings.Builder
+	if orderFlagged(order, LineFlagRush) {
+		b.WriteString("*** RUSH ***\n")
+	}
+	fmt.Fprintf(&b, "%s  %s\n", orderPlace(order), order.CreatedAt.Format("15:04"))
+	if order.Number != "" {
+		fmt.Fprintf(&b, "Order %s\n", order.Number)
+	}
+	fmt.Fprintf(&b, "%s\n", order.CustomerName)
+	if order.ScheduledFor != nil && order.Type == OrderTakeaway {
+		fmt.Fprintf(&b, "PICKUP AT %s\n", order.ScheduledFor.Format("15:04"))
+	}
+	if order.DeliveryAddress != nil {
+		fmt.Fprintf(&b, "DELIVER TO %s\n", order.DeliveryAddress)
+	}
+	b.WriteString(strings.Repeat("-", 32) + "\n")
+	for _, line := range order.Items {
+		if LineHeld(order, line) {
+			continue
+		}
+		fmt.Fprintf(&b, "%s%d x %s\n", lineFlagPrefix(line), line.Quantity, line.Name)
+		if line.Weight > 0 {
+			fmt.Fprintf(&b, "    %.3f kg each\n", line.Weight)
+		}
+		if line.Seat > SharedSeat {
+			fmt.Fprintf(&b, "    seat %d\n", line.Seat)
+		}
+		if line.Note != "" {
+			fmt.Fprintf(&b, "    > %s\n", line.Note)
+		}
+	}
+	if order.Notes != "" {
+		b.WriteString(strings.Repeat("-", 32) + "\n")
+		fmt.Fprintf(&b, "NOTES: %s\n", order.Notes)
+	}
+	return b.String()
+}
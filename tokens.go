@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// NowServing is what the pickup screen shows: tokens still cooking and tokens ready to collect
+type NowServing struct {
+	Preparing []int `json:"preparing"`
+	Ready     []int `json:"ready"`
+}
+
+// NextToken returns the next takeaway token for the day of t. Counters are keyed by date,
+// so numbering starts again from 1 every day.
+func NextToken(ctx context.Context, t time.Time) (int, error) {
+	return storeFor(ctx).Counters().Next(ctx, "token:"+t.Format("2006-01-02"))
+}
+
+// LoadNowServing lists today's takeaway tokens that are being prepared or waiting for pickup
+func LoadNowServing(ctx context.Context) (NowServing, error) {
+	now := clock.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	statuses := []string{StatusQueued, StatusPreparing, StatusReady}
+	orders, err := storeFor(ctx).Orders().ListTakeaway(ctx, start, statuses)
+	if err != nil {
+		return NowServing{}, err
+	}
+
+	menu := LoadMenu(ctx)
+	serving := NowServing{Preparing: []int{}, Ready: []int{}}
+	for _, order := range orders {
+		if kitchenReleaseAt(menu, order).After(now) {
+			// A pre-order is not being prepared until it reaches the kitchen
+			continue
+		}
+		if order.Status == StatusReady {
+			serving.Ready = append(serving.Ready, order.Token)
+		} else {
+			serving.Preparing = append(serving.Preparing, order.Token)
+		}
+	}
+	return serving, nil
+}
+
+// announceReady tells the customer their takeaway token is ready to collect
+func announceReady(ctx context.Context, order Order) {
+	Notify(ctx, templateMessage(ctx, TemplateOrderReady, customerPhone(ctx, order.CustomerName), map[string]string{
+		"customerName": order.CustomerName, "number": order.Number, "token": fmt.Sprint(order.Token), "total": fmt.Sprintf("%.2f", order.Total),
+	}))
+}
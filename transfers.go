@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// currentBranch is the branch this server runs at, from RMS_BRANCH. Branches of a chain share one database; each
+// keeps its own stock and stamps its own orders. It is empty for a single restaurant.
+var currentBranch string
+
+// Stock transfer statuses, in the order a transfer moves through them
+const (
+	TransferRequested  = "requested"  // Raised by the sending branch, nothing has moved yet
+	TransferDispatched = "dispatched" // Taken out of the sending branch's stock and on its way
+	TransferReceived   = "received"   // Added to the receiving branch's stock
+	TransferCancelled  = "cancelled"  // Called off before it was dispatched
+)
+
+// TransferLine is one item sent from one branch to another
+type TransferLine struct {
+	Name       string       `bson:"name" json:"name"`
+	Unit       string       `bson:"unit" json:"unit"`
+	Quantity   float64      `bson:"quantity" json:"quantity"`                         // Asked for
+	Dispatched []StockBatch `bson:"dispatched,omitempty" json:"dispatched,omitempty"` // The batches taken out of the sending branch's stock
+	Received   *float64     `bson:"received,omitempty" json:"received,omitempty"`     // What arrived; less than dispatched means some was lost on the way
+}
+
+// StockTransfer moves stock between two branches in two confirmed steps: the sending branch dispatches it, taking
+// it out of its stock, and the receiving branch receives it, adding what arrived to its stock. Who did each step
+// and when is kept for the audit trail.
+type StockTransfer struct {
+	ID           primitive.ObjectID `bson:"_id" json:"id"`
+	From         string             `bson:"from" json:"from"`
+	To           string             `bson:"to" json:"to"`
+	Lines        []TransferLine     `bson:"lines" json:"lines"`
+	Status       string             `bson:"status" json:"status"`
+	RequestedBy  string             `bson:"requestedBy" json:"requestedBy"`
+	CreatedAt    time.Time          `bson:"createdAt" json:"createdAt"`
+	DispatchedBy string             `bson:"dispatchedBy,omitempty" json:"dispatchedBy,omitempty"`
+	DispatchedAt *time.Time         `bson:"dispatchedAt,omitempty" json:"dispatchedAt,omitempty"`
+	ReceivedBy   string             `bson:"receivedBy,omitempty" json:"receivedBy,omitempty"`
+	ReceivedAt   *time.Time         `bson:"receivedAt,omitempty" json:"receivedAt,omitempty"`
+	CancelledBy  string             `bson:"cancelledBy,omitempty" json:"cancelledBy,omitempty"`
+	Note         string             `bson:"note,omitempty" json:"note,omitempty"` // e.g. why less arrived than was sent
+	Version      int                `bson:"version" json:"version"`               // Bumped by every step, so a step cannot be taken twice
+}
+
+// mainBranch is what the main, unnamed, branch is called when stock is sent to it
+const mainBranch = "main"
+
+// RequestTransfer raises a transfer of stock from this branch to another; nothing moves until it is dispatched.
+// The quantities are given by stock name.
+func RequestTransfer(ctx context.Context, to string, quantities map[string]float64, by string) (StockTransfer, error) {
+	to, by = strings.TrimSpace(to), strings.TrimSpace(by)
+	if strings.EqualFold(to, mainBranch) {
+		to = ""
+	}
+	switch {
+	case to == currentBranch:
+		return StockTransfer{}, fmt.Errorf("stock cannot be sent to the branch it is at")
+	case by == "":
+		return StockTransfer{}, fmt.Errorf("who is asking for the transfer is required")
+	case len(quantities) == 0:
+		return StockTransfer{}, fmt.Errorf("a transfer needs at least one item")
+	}
+	transfer := StockTransfer{
+		ID: primitive.NewObjectID(), From: currentBranch, To: to, Status: TransferRequested, RequestedBy: by, CreatedAt: clock.Now(),
+	}
+	for name, quantity := range quantities {
+		level, err := storeFor(ctx).Stock().Find(ctx, currentBranch, strings.ToLower(strings.TrimSpace(name)))
+		if errors.Is(err, ErrNotFound) {
+			return StockTransfer{}, fmt.Errorf("%s is not stocked at this branch", name)
+		}
+		if err != nil {
+			return StockTransfer{}, err
+		}
+		if quantity <= 0 {
+			return StockTransfer{}, fmt.Errorf("%s: the quantity must be positive", level.Name)
+		}
+		transfer.Lines = append(transfer.Lines, TransferLine{Name: level.Name, Unit: level.Unit, Quantity: quantity})
+	}
+	if err := storeFor(ctx).Transfers().Insert(ctx, transfer); err != nil {
+		return StockTransfer{}, err
+	}
+	return transfer, nil
+}
+
+// findTransfer looks up a transfer this branch takes part in, refusing it unless it is in the status given
+func findTransfer(ctx context.Context, id primitive.ObjectID, status string) (StockTransfer, error) {
+	transfer, err := storeFor(ctx).Transfers().Find(ctx, id)
+	if err != nil {
+		return StockTransfer{}, err
+	}
+	if transfer.From != currentBranch && transfer.To != currentBranch {
+		return StockTransfer{}, ErrNotFound
+	}
+	if transfer.Status != status {
+		return StockTransfer{}, fmt.Errorf("the transfer is %s, not %s", transfer.Status, status)
+	}
+	return transfer, nil
+}
+
+// DispatchTransfer confirms the stock left the sending branch, taking it out of that branch's stock first in,
+// first out. It fails, moving nothing, if the branch does not have enough of every item.
+func DispatchTransfer(ctx context.Context, id primitive.ObjectID, by string) (StockTransfer, error) {
+	by = strings.TrimSpace(by)
+	if by == "" {
+		return StockTransfer{}, fmt.Errorf("who dispatched the transfer is required")
+	}
+	transfer, err := findTransfer(ctx, id, TransferRequested)
+	if err != nil {
+		return StockTransfer{}, err
+	}
+	if transfer.From != currentBranch {
+		return StockTransfer{}, fmt.Errorf("only %s can dispatch the transfer", branchName(transfer.From))
+	}
+	now := clock.Now()
+	statuses, err := StockStatuses(ctx, now)
+	if err != nil {
+		return StockTransfer{}, err
+	}
+	levels := map[string]StockStatus{}
+	for _, status := range statuses {
+		levels[status.Name] = status
+	}
+	for _, line := range transfer.Lines {
+		if status, ok := levels[line.Name]; !ok || status.OnHand < line.Quantity {
+			return StockTransfer{}, fmt.Errorf("%s: only %g %s on hand", line.Name, status.OnHand, line.Unit)
+		}
+	}
+
+	// Claiming the transfer first means two people dispatching it at once cannot both take the stock
+	for i, line := range transfer.Lines {
+		transfer.Lines[i].Dispatched = takeBatches(levels[line.Name].Left, line.Quantity, now)
+	}
+	transfer.Status, transfer.DispatchedBy, transfer.DispatchedAt = TransferDispatched, by, &now
+	if err := storeFor(ctx).Transfers().Update(ctx, transfer); err != nil {
+		return StockTransfer{}, err
+	}
+	transfer.Version++
+	for _, line := range transfer.Lines {
+		status := levels[line.Name]
+		level := status.StockLevel
+		level.Batches = removeBatches(status.Left, line.Dispatched)
+		level.Counted, level.CountedAt = roundPaise(status.OnHand-line.Quantity), now
+		if err := storeFor(ctx).Stock().Save(ctx, level); err != nil {
+			return transfer, fmt.Errorf("taking %s out of stock: %w", line.Name, err)
+		}
+	}
+	return transfer, nil
+}
+
+// takeBatches takes the quantity from the batches that have not expired, first in first out
+func takeBatches(batches []StockBatch, quantity float64, now time.Time) []StockBatch {
+	var taken []StockBatch
+	for _, batch := range batches {
+		if quantity <= 0 {
+			break
+		}
+		if batchExpired(batch, now) {
+			continue
+		}
+		batch.Quantity = roundPaise(min(batch.Quantity, quantity))
+		quantity -= batch.Quantity
+		taken = append(taken, batch)
+	}
+	return taken
+}
+
+// removeBatches takes what was taken off the batches it came from, dropping any used up
+func removeBatches(batches, taken []StockBatch) []StockBatch {
+	left := []StockBatch{}
+	for _, batch := range batches {
+		for _, t := range taken {
+			if t.ReceivedAt.Equal(batch.ReceivedAt) && t.ExpiresOn == batch.ExpiresOn {
+				batch.Quantity = roundPaise(batch.Quantity - t.Quantity)
+			}
+		}
+		if batch.Quantity > 0 {
+			left = append(left, batch)
+		}
+	}
+	return left
+}
+
+// ReceiveTransfer confirms the stock arrived at the receiving branch and adds it to that branch's stock, keeping
+// each batch's use-by day and cost. Received gives what arrived of an item when it is less than was dispatched; the
+// rest is taken as lost on the way, and the note should say why.
+func ReceiveTransfer(ctx context.Context, id primitive.ObjectID, by string, received map[string]float64, note string) (StockTransfer, error) {
+	by, note = strings.TrimSpace(by), strings.TrimSpace(note)
+	if by == "" {
+		return StockTransfer{}, fmt.Errorf("who received the transfer is required")
+	}
+	transfer, err := findTransfer(ctx, id, TransferDispatched)
+	if err != nil {
+		return StockTransfer{}, err
+	}
+	if transfer.To != currentBranch {
+		return StockTransfer{}, fmt.Errorf("only %s can receive the transfer", branchName(transfer.To))
+	}
+	levels := map[string]StockLevel{}
+	for i, line := range transfer.Lines {
+		dispatched := 0.0
+		for _, batch := range line.Dispatched {
+			dispatched += batch.Quantity
+		}
+		arrived := roundPaise(dispatched)
+		if quantity, ok := received[line.Name]; ok {
+			if quantity < 0 || quantity > arrived {
+				return StockTransfer{}, fmt.Errorf("%s: between 0 and the %g %s dispatched can be received", line.Name, arrived, line.Unit)
+			}
+			arrived = quantity
+		}
+		transfer.Lines[i].Received = &arrived
+
+		level, err := storeFor(ctx).Stock().Find(ctx, currentBranch, line.Name)
+		if errors.Is(err, ErrNotFound) {
+			level, err = StockLevel{Branch: currentBranch, Name: line.Name, Unit: line.Unit, CountedAt: clock.Now()}, nil
+		}
+		if err != nil {
+			return StockTransfer{}, err
+		}
+		if level.Unit != line.Unit {
+			return StockTransfer{}, fmt.Errorf("%s is counted in %s here, not %s", line.Name, level.Unit, line.Unit)
+		}
+		levels[line.Name] = level
+	}
+	for name := range received {
+		if _, ok := levels[name]; !ok {
+			return StockTransfer{}, fmt.Errorf("%s is not in the transfer", name)
+		}
+	}
+
+	now := clock.Now()
+	transfer.Status, transfer.ReceivedBy, transfer.ReceivedAt, transfer.Note = TransferReceived, by, &now, note
+	if err := storeFor(ctx).Transfers().Update(ctx, transfer); err != nil {
+		return StockTransfer{}, err
+	}
+	transfer.Version++
+	for _, line := range transfer.Lines {
+		level := levels[line.Name]
+		levelBatches := levelBatches(level)
+		for _, batch := range takeBatches(line.Dispatched, *line.Received, time.Time{}) {
+			batch.ReceivedAt, batch.Warned = now, false
+			levelBatches = append(levelBatches, batch)
+		}
+		level.Batches = levelBatches
+		if err := storeFor(ctx).Stock().Save(ctx, level); err != nil {
+			return transfer, fmt.Errorf("adding %s to stock: %w", line.Name, err)
+		}
+	}
+	return transfer, nil
+}
+
+// CancelTransfer calls off a transfer that has not been dispatched yet; either branch can
+func CancelTransfer(ctx context.Context, id primitive.ObjectID, by string) (StockTransfer, error) {
+	by = strings.TrimSpace(by)
+	if by == "" {
+		return StockTransfer{}, fmt.Errorf("who cancelled the transfer is required")
+	}
+	transfer, err := findTransfer(ctx, id, TransferRequested)
+	if err != nil {
+		return StockTransfer{}, err
+	}
+	transfer.Status, transfer.CancelledBy = TransferCancelled, by
+	if err := storeFor(ctx).Transfers().Update(ctx, transfer); err != nil {
+		return StockTransfer{}, err
+	}
+	transfer.Version++
+	return transfer, nil
+}
+
+// branchName is how a branch is shown; the unnamed branch is the main one
+func branchName(branch string) string {
+	if branch == "" {
+		return "the main branch"
+	}
+	return branch
+}
+
+// ShowTransfers prints the transfers to and from this branch, newest first
+func ShowTransfers(ctx context.Context) error {
+	transfers, err := storeFor(ctx).Transfers().ListForBranch(ctx, currentBranch)
+	if err != nil {
+		return err
+	}
+	transferListing := listing{
+		title:   fmt.Sprintf("Stock transfers of %s:", branchName(currentBranch)),
+		header:  []string{"ID", "From", "To", "Items", "Status", "Requested", "By"},
+		records: transfers,
+	}
+	for _, transfer := range transfers {
+		var items []string
+		for _, line := range transfer.Lines {
+			item := fmt.Sprintf("%g %s %s", line.Quantity, line.Unit, line.Name)
+			if line.Received != nil {
+				item += fmt.Sprintf(" (%g arrived)", *line.Received)
+			}
+			items = append(items, item)
+		}
+		transferListing.rows = append(transferListing.rows, []string{
+			transfer.ID.Hex(), branchName(transfer.From), branchName(transfer.To), strings.Join(items, ", "), transfer.Status,
+			transfer.CreatedAt.Format("02 Jan 15:04"), transfer.RequestedBy,
+		})
+		transferListing.compact = append(transferListing.compact, fmt.Sprintf("%s %s -> %s %s: %s",
+			transfer.ID.Hex(), branchName(transfer.From), branchName(transfer.To), transfer.Status, strings.Join(items, ", ")))
+	}
+	return printListing(os.Stdout, transferListing)
+}
@@ -0,0 +1,802 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// refreshInterval is how often the table map and kitchen queue are reloaded
+const refreshInterval = 2 * time.Second
+
+// tablesPerRow is how many tables are drawn side by side in the table map
+const tablesPerRow = 4
+
+// pane identifies one of the four dashboard panes
+type pane int
+
+const (
+	menuPane pane = iota
+	cartPane
+	tablesPane
+	kitchenPane
+	paneCount
+)
+
+var (
+	paneStyle          = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(lipgloss.Color("240")).Padding(0, 1)
+	focusedPaneStyle   = paneStyle.BorderForeground(lipgloss.Color("205"))
+	titleStyle         = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("205"))
+	selectedStyle      = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("229")).Background(lipgloss.Color("57"))
+	freeTableStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	occupiedTableStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("203"))
+	helpStyle          = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	soldOutStyle       = lipgloss.NewStyle().Strikethrough(true).Foreground(lipgloss.Color("241"))
+	statusStyle        = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+	lineFlagStyle      = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("231")).Background(lipgloss.Color("160"))
+)
+
+// tickMsg triggers a periodic refresh of the live panes
+type tickMsg time.Time
+
+// liveDataMsg carries the freshly loaded menu, tables and kitchen queue
+type liveDataMsg struct {
+	menu   []MenuItem
+	tables []Table
+	queue  []Order
+	err    error
+}
+
+// orderSubmittedMsg reports the result of sending the cart to the kitchen
+type orderSubmittedMsg struct {
+	order Order
+	err   error
+}
+
+// statusUpdatedMsg reports the result of a kitchen status or table change
+type statusUpdatedMsg struct {
+	message string
+	err     error
+}
+
+// cartParkedMsg reports the result of parking the cart or recalling a parked one
+type cartParkedMsg struct {
+	cart     ParkedCart
+	recalled bool
+	err      error
+}
+
+// checkedInMsg reports the result of looking up a customer by phone number
+type checkedInMsg struct {
+	checkIn CheckIn
+	phone   string
+	err     error
+}
+
+// tuiModel is the Bubble Tea model for the point-of-sale dashboard
+type tuiModel struct {
+	focus  pane
+	menu   []MenuItem
+	cart   []OrderLine
+	tables []Table
+	queue  []Order
+
+	menuCursor  int
+	cartCursor  int
+	tableCursor int
+	queueCursor int
+
+	customerName string
+	table        int // table the cart will be served at, 0 for none
+	takeaway     bool
+	editingName  bool
+	nameInput    string
+	editingPhone bool
+	phoneInput   string
+	editingNote  bool
+	noteInput    string
+	noteLine     int    // Cart line the note being typed is for, or -1 for the whole order
+	orderNotes   string // Instructions for the whole order, sent with it
+	// checkInPhone is an unknown number checked in with; the name typed next adds the customer with it
+	checkInPhone string
+	favorites    []Favorite    // The checked-in customer's most ordered items, added with the keys 1 to 5
+	flag         *CustomerFlag // Set when the checked-in customer has been flagged by staff
+	// allergyConfirm is set after an order was stopped by an allergy, so pressing s again sends it anyway
+	allergyConfirm bool
+	// idConfirm is set after an order was stopped for age-restricted items, so pressing s again confirms the guest's
+	// ID was checked; idChecked keeps that for the cart until it is sent
+	idConfirm bool
+	idChecked bool
+
+	status string
+	width  int
+}
+
+// RunTUI starts the full-screen point-of-sale dashboard for the given customer
+func RunTUI(customerName string) error {
+	model := tuiModel{
+		menu:         ServedMenu(LoadMenu(context.TODO()), clock.Now()),
+		customerName: customerName,
+	}
+	_, err := tea.NewProgram(model, tea.WithAltScreen()).Run()
+	return err
+}
+
+func (m tuiModel) Init() tea.Cmd {
+	return tea.Batch(loadLiveData, tick())
+}
+
+// tick schedules the next refresh of the live panes
+func tick() tea.Cmd {
+	return tea.Tick(refreshInterval, func(t time.Time) tea.Msg { return tickMsg(t) })
+}
+
+// loadLiveData reads the tables and kitchen queue from the database
+func loadLiveData() tea.Msg {
+	tables, err := LoadTables(context.TODO())
+	if err != nil {
+		return liveDataMsg{err: err}
+	}
+	queue, err := LoadKitchenQueue(context.TODO())
+	// The menu follows the clock, e.g. breakfast items disappear when breakfast ends.
+	// 86'd items stay listed so they can be brought back.
+	menu := ServedMenu(LoadMenu(context.TODO()), clock.Now())
+	return liveDataMsg{menu: menu, tables: tables, queue: queue, err: err}
+}
+
+// submitCart sends the cart to the kitchen in the background
+func submitCart(order Order) tea.Cmd {
+	return func() tea.Msg {
+		order, err := SubmitOrder(context.TODO(), order)
+		if err == nil && order.Table > 0 {
+			err = SetTableOccupied(context.TODO(), order.Table, true)
+		}
+		return orderSubmittedMsg{order: order, err: err}
+	}
+}
+
+// parkCart puts the cart aside in the background so another customer can be served
+func parkCart(order Order) tea.Cmd {
+	return func() tea.Msg {
+		cart, err := ParkCart(context.TODO(), order)
+		return cartParkedMsg{cart: cart, err: err}
+	}
+}
+
+// recallCart takes back the cart parked for the table or customer in the background
+func recallCart(table int, customerName string) tea.Cmd {
+	return func() tea.Msg {
+		cart, err := RecallCart(context.TODO(), table, customerName)
+		return cartParkedMsg{cart: cart, recalled: true, err: err}
+	}
+}
+
+// checkIn looks up the customer with the phone number in the background, adding them if name is given
+func checkIn(phone, name string) tea.Cmd {
+	return func() tea.Msg {
+		checkIn, err := CheckInCustomer(context.TODO(), phone, name)
+		return checkedInMsg{checkIn: checkIn, phone: phone, err: err}
+	}
+}
+
+// advanceOrder moves a kitchen ticket to its next status in the background
+func advanceOrder(order Order) tea.Cmd {
+	return func() tea.Msg {
+		next, err := AdvanceOrderStatus(context.TODO(), order, 0)
+		return statusUpdatedMsg{message: fmt.Sprintf("Order for %s is now %s", order.CustomerName, next), err: err}
+	}
+}
+
+// fireNextCourse sends an order's next held course to the kitchen in the background
+func fireNextCourse(order Order) tea.Cmd {
+	return func() tea.Msg {
+		course, err := FireNextCourse(context.TODO(), order)
+		return statusUpdatedMsg{message: fmt.Sprintf("Course %d fired for %s", course, order.CustomerName), err: err}
+	}
+}
+
+// toggleSoldOut 86's a menu item for the rest of the day, or brings it back, in the background
+func toggleSoldOut(item MenuItem) tea.Cmd {
+	return func() tea.Msg {
+		item, err := ToggleSoldOut(context.TODO(), item)
+		message := item.Name + " is back on"
+		if IsSoldOut(item, clock.Now()) {
+			message = item.Name + " 86'd for the rest of the day"
+		}
+		return statusUpdatedMsg{message: message, err: err}
+	}
+}
+
+// toggleTable flips a table between free and occupied in the background
+func toggleTable(table Table) tea.Cmd {
+	return func() tea.Msg {
+		err := SetTableOccupied(context.TODO(), table.Number, !table.Occupied)
+		state := "occupied"
+		if table.Occupied {
+			state = "free"
+		}
+		return statusUpdatedMsg{message: fmt.Sprintf("Table %d is now %s", table.Number, state), err: err}
+	}
+}
+
+func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		return m, nil
+
+	case tickMsg:
+		return m, tea.Batch(loadLiveData, tick())
+
+	case liveDataMsg:
+		if msg.err != nil {
+			m.status = "Error refreshing: " + msg.err.Error()
+			return m, nil
+		}
+		m.menu, m.tables, m.queue = msg.menu, msg.tables, msg.queue
+		m.menuCursor = clampCursor(m.menuCursor, len(m.menu))
+		m.tableCursor = clampCursor(m.tableCursor, len(m.tables))
+		m.queueCursor = clampCursor(m.queueCursor, len(m.queue))
+		return m, nil
+
+	case orderSubmittedMsg:
+		var allergyErr *AllergyError
+		if errors.As(msg.err, &allergyErr) {
+			m.status = "Allergy warning: " + strings.Join(allergyErr.Conflicts, "; ") + " - press s again to send anyway"
+			m.allergyConfirm = true
+			return m, nil
+		}
+		var ageErr *AgeCheckError
+		if errors.As(msg.err, &ageErr) {
+			m.status = "ID check needed for " + strings.Join(ageErr.Items, ", ") + " - check the guest's ID, then press s again"
+			m.idConfirm = true
+			return m, nil
+		}
+		if msg.err != nil {
+			m.status = "Error sending order: " + msg.err.Error()
+			return m, nil
+		}
+		m.status = fmt.Sprintf("Order for %s sent to the kitchen (Rs %.2f)", msg.order.CustomerName, msg.order.Total)
+		if msg.order.Token > 0 {
+			m.status += fmt.Sprintf(" - token %d", msg.order.Token)
+		}
+		m.cart, m.cartCursor, m.table, m.takeaway, m.orderNotes, m.idChecked = nil, 0, 0, false, "", false
+		return m, loadLiveData
+
+	case cartParkedMsg:
+		if errors.Is(msg.err, ErrNotFound) {
+			m.status = "No cart is parked for that table or customer"
+			return m, nil
+		}
+		if msg.err != nil {
+			m.status = "Error: " + msg.err.Error()
+			return m, nil
+		}
+		if !msg.recalled {
+			m.status = fmt.Sprintf("Cart for %s parked until %s", msg.cart.CustomerName, msg.cart.ExpiresAt.Format("15:04"))
+			m.cart, m.cartCursor, m.table, m.takeaway, m.orderNotes = nil, 0, 0, false, ""
+			return m, nil
+		}
+		m.customerName, m.cart, m.cartCursor = msg.cart.CustomerName, msg.cart.Items, 0
+		m.table, m.takeaway, m.orderNotes = msg.cart.Table, msg.cart.Type == OrderTakeaway, msg.cart.Notes
+		m.status = fmt.Sprintf("Recalled the cart for %s parked at %s", msg.cart.CustomerName, msg.cart.ParkedAt.Format("15:04"))
+		return m, nil
+
+	case checkedInMsg:
+		if errors.Is(msg.err, ErrNotFound) {
+			m.status = "No customer has the number " + msg.phone + " - enter their name to add them"
+			m.editingName, m.nameInput, m.checkInPhone = true, "", msg.phone
+			return m, nil
+		}
+		if msg.err != nil {
+			m.status = "Error checking in: " + msg.err.Error()
+			return m, nil
+		}
+		// A check-in starts a fresh order for the customer
+		m.customerName, m.favorites, m.flag = msg.checkIn.Customer.Name, msg.checkIn.Favorites, msg.checkIn.Customer.Flag
+		m.cart, m.cartCursor, m.table, m.takeaway, m.orderNotes = nil, 0, 0, false, ""
+		m.status = "Welcome back, " + m.customerName
+		if msg.checkIn.New {
+			m.status = "Added " + m.customerName
+		}
+		if m.flag != nil {
+			m.status += " - flagged: " + m.flag.Reason
+		}
+		return m, nil
+
+	case statusUpdatedMsg:
+		if msg.err != nil {
+			m.status = "Error: " + msg.err.Error()
+		} else {
+			m.status = msg.message
+		}
+		return m, loadLiveData
+
+	case tea.KeyMsg:
+		if m.editingName {
+			return m.updateNameInput(msg)
+		}
+		if m.editingPhone {
+			return m.updatePhoneInput(msg)
+		}
+		if m.editingNote {
+			return m.updateNoteInput(msg)
+		}
+		return m.updateKeys(msg)
+	}
+	return m, nil
+}
+
+// updateNameInput handles typing while the customer name is being edited, or asked for to add a
+// customer checking in with a new number
+func (m tuiModel) updateNameInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		name := strings.TrimSpace(m.nameInput)
+		m.editingName = false
+		if m.checkInPhone != "" {
+			phone := m.checkInPhone
+			m.checkInPhone = ""
+			if name != "" {
+				return m, checkIn(phone, name)
+			}
+			break
+		}
+		if name != "" {
+			m.customerName, m.favorites, m.flag = name, nil, nil
+		}
+	case tea.KeyEsc:
+		m.editingName, m.checkInPhone = false, ""
+	case tea.KeyBackspace:
+		if runes := []rune(m.nameInput); len(runes) > 0 {
+			m.nameInput = string(runes[:len(runes)-1])
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.nameInput += string(msg.Runes)
+	}
+	return m, nil
+}
+
+// updatePhoneInput handles typing the phone number of a customer checking in
+func (m tuiModel) updatePhoneInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.editingPhone = false
+		if phone := normalizePhone(m.phoneInput); phone != "" {
+			m.status = "Looking up " + phone + "..."
+			return m, checkIn(phone, "")
+		}
+	case tea.KeyEsc:
+		m.editingPhone = false
+	case tea.KeyBackspace:
+		if runes := []rune(m.phoneInput); len(runes) > 0 {
+			m.phoneInput = string(runes[:len(runes)-1])
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.phoneInput += string(msg.Runes)
+	}
+	return m, nil
+}
+
+// updateNoteInput handles typing a note for the order or for the selected cart line; an empty note clears it
+func (m tuiModel) updateNoteInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.editingNote = false
+		note, err := cleanNote(m.noteInput)
+		if err != nil {
+			m.status = "Error: " + err.Error()
+			break
+		}
+		if m.noteLine < 0 {
+			m.orderNotes = note
+		} else if m.noteLine < len(m.cart) {
+			m.cart[m.noteLine].Note = note
+		}
+	case tea.KeyEsc:
+		m.editingNote = false
+	case tea.KeyBackspace:
+		if runes := []rune(m.noteInput); len(runes) > 0 {
+			m.noteInput = string(runes[:len(runes)-1])
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.noteInput += string(msg.Runes)
+	}
+	return m, nil
+}
+
+// updateKeys handles navigation and actions for the focused pane
+func (m tuiModel) updateKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	// Only the very next key can confirm an allergy warning
+	confirmAllergy, confirmID := m.allergyConfirm, m.idConfirm
+	m.allergyConfirm, m.idConfirm = false, false
+
+	switch msg.String() {
+	case "ctrl+c", "q":
+		return m, tea.Quit
+	case "tab":
+		m.focus = (m.focus + 1) % paneCount
+		return m, nil
+	case "shift+tab":
+		m.focus = (m.focus + paneCount - 1) % paneCount
+		return m, nil
+	case "n":
+		m.editingName, m.nameInput = true, ""
+		return m, nil
+	case "p":
+		m.editingPhone, m.phoneInput = true, ""
+		return m, nil
+	case "o":
+		m.editingNote, m.noteInput, m.noteLine = true, m.orderNotes, -1
+		return m, nil
+	case "1", "2", "3", "4", "5":
+		// One key reorders a favorite of the checked-in customer
+		if i := int(msg.String()[0] - '1'); i < len(m.favorites) {
+			item := m.favorites[i].Item
+			if item.SoldByWeight {
+				m.status = item.Name + " is sold by weight; weigh it and order it at the counter"
+				return m, nil
+			}
+			m.cart = AddToCart(m.cart, item, 1)
+			m.status = "Added " + item.Name
+			return m, nil
+		}
+	case "s":
+		if len(m.cart) == 0 {
+			m.status = "The cart is empty"
+			return m, nil
+		}
+		m.status = "Sending order..."
+		order := Order{CustomerName: m.customerName, Table: m.table, Type: OrderDineIn, Items: m.cart, Notes: m.orderNotes, AllergyOverride: confirmAllergy}
+		m.idChecked = m.idChecked || confirmID
+		if m.idChecked {
+			order.IDVerified = &IDCheck{}
+		}
+		if m.takeaway {
+			order.Type = OrderTakeaway
+		}
+		return m, submitCart(order)
+	case "P":
+		if len(m.cart) == 0 {
+			m.status = "The cart is empty"
+			return m, nil
+		}
+		order := Order{CustomerName: m.customerName, Table: m.table, Type: OrderDineIn, Items: m.cart, Notes: m.orderNotes}
+		if m.takeaway {
+			order.Type = OrderTakeaway
+		}
+		return m, parkCart(order)
+	case "R":
+		// The cart's table picks the parked cart, or else the customer's name
+		if len(m.cart) > 0 {
+			m.status = "Send or park the cart first"
+			return m, nil
+		}
+		return m, recallCart(m.table, m.customerName)
+	case "t":
+		m.takeaway = !m.takeaway
+		if m.takeaway {
+			m.table = 0
+			m.status = "Cart is a takeaway order"
+		} else {
+			m.status = "Cart is a dine-in order"
+		}
+		return m, nil
+	}
+
+	switch m.focus {
+	case menuPane:
+		switch msg.String() {
+		case "up", "k":
+			m.menuCursor = clampCursor(m.menuCursor-1, len(m.menu))
+		case "down", "j":
+			m.menuCursor = clampCursor(m.menuCursor+1, len(m.menu))
+		case "enter", " ", "+":
+			if len(m.menu) > 0 {
+				item := m.menu[m.menuCursor]
+				if IsSoldOut(item, clock.Now()) {
+					m.status = item.Name + " is 86'd"
+					break
+				}
+				if item.SoldByWeight {
+					m.status = item.Name + " is sold by weight; weigh it and order it at the counter"
+					break
+				}
+				m.cart = AddToCart(m.cart, item, 1)
+				m.status = "Added " + item.Name
+			}
+		case "8":
+			if len(m.menu) > 0 {
+				return m, toggleSoldOut(m.menu[m.menuCursor])
+			}
+		}
+
+	case cartPane:
+		switch msg.String() {
+		case "up", "k":
+			m.cartCursor = clampCursor(m.cartCursor-1, len(m.cart))
+		case "down", "j":
+			m.cartCursor = clampCursor(m.cartCursor+1, len(m.cart))
+		case "+", "=":
+			if len(m.cart) > 0 {
+				m.cart[m.cartCursor].Quantity++
+			}
+		case "-":
+			if len(m.cart) > 0 {
+				m.cart[m.cartCursor].Quantity--
+				if m.cart[m.cartCursor].Quantity == 0 {
+					m.cart = append(m.cart[:m.cartCursor], m.cart[m.cartCursor+1:]...)
+				}
+			}
+		case "a", "r":
+			// Flags make a line stand out on the kitchen ticket
+			if len(m.cart) > 0 {
+				flag := LineFlagAllergy
+				if msg.String() == "r" {
+					flag = LineFlagRush
+				}
+				m.cart[m.cartCursor].Flags = toggleLineFlag(m.cart[m.cartCursor].Flags, flag)
+			}
+		case "e":
+			if len(m.cart) > 0 {
+				m.editingNote, m.noteInput, m.noteLine = true, m.cart[m.cartCursor].Note, m.cartCursor
+			}
+		case "x", "delete", "backspace":
+			if len(m.cart) > 0 {
+				m.cart = append(m.cart[:m.cartCursor], m.cart[m.cartCursor+1:]...)
+			}
+		case "h":
+			// Holding moves the line to the second course, e.g. desserts, which is fired later from the kitchen pane
+			if len(m.cart) > 0 {
+				line := m.cart[m.cartCursor]
+				line.Course = 2
+				if lineCourse(m.cart[m.cartCursor]) > FirstCourse {
+					line.Course = 0
+				}
+				m.cart = addLine(append(m.cart[:m.cartCursor], m.cart[m.cartCursor+1:]...), line)
+				m.cartCursor = findLine(m.cart, line.Name, line.Course, line.Seat)
+			}
+		case "<", ">":
+			// Seats let a large party split the bill; seat 0 is shared by the table
+			if len(m.cart) > 0 {
+				line := m.cart[m.cartCursor]
+				if msg.String() == ">" {
+					line.Seat++
+				} else if line.Seat > SharedSeat {
+					line.Seat--
+				}
+				m.cart = addLine(append(m.cart[:m.cartCursor], m.cart[m.cartCursor+1:]...), line)
+				m.cartCursor = findLine(m.cart, line.Name, line.Course, line.Seat)
+			}
+		}
+		m.cartCursor = clampCursor(m.cartCursor, len(m.cart))
+
+	case tablesPane:
+		switch msg.String() {
+		case "left", "h":
+			m.tableCursor = clampCursor(m.tableCursor-1, len(m.tables))
+		case "right", "l":
+			m.tableCursor = clampCursor(m.tableCursor+1, len(m.tables))
+		case "up", "k":
+			m.tableCursor = clampCursor(m.tableCursor-tablesPerRow, len(m.tables))
+		case "down", "j":
+			m.tableCursor = clampCursor(m.tableCursor+tablesPerRow, len(m.tables))
+		case "enter", " ":
+			if len(m.tables) > 0 {
+				m.table, m.takeaway = m.tables[m.tableCursor].Number, false
+				m.status = fmt.Sprintf("Cart will be served at table %d", m.table)
+			}
+		case "f":
+			if len(m.tables) > 0 {
+				return m, toggleTable(m.tables[m.tableCursor])
+			}
+		}
+
+	case kitchenPane:
+		switch msg.String() {
+		case "up", "k":
+			m.queueCursor = clampCursor(m.queueCursor-1, len(m.queue))
+		case "down", "j":
+			m.queueCursor = clampCursor(m.queueCursor+1, len(m.queue))
+		case "enter", " ":
+			if len(m.queue) > 0 {
+				return m, advanceOrder(m.queue[m.queueCursor])
+			}
+		case "f":
+			if len(m.queue) > 0 {
+				return m, fireNextCourse(m.queue[m.queueCursor])
+			}
+		}
+	}
+	return m, nil
+}
+
+// clampCursor keeps a list cursor within [0, length)
+func clampCursor(cursor, length int) int {
+	if cursor >= length {
+		cursor = length - 1
+	}
+	if cursor < 0 {
+		cursor = 0
+	}
+	return cursor
+}
+
+func (m tuiModel) View() string {
+	paneWidth := 40
+	if m.width > 0 {
+		paneWidth = max(m.width/2-4, 30)
+	}
+
+	top := lipgloss.JoinHorizontal(lipgloss.Top,
+		m.renderPane(menuPane, paneWidth, "Menu", m.menuView()),
+		m.renderPane(cartPane, paneWidth, "Cart", m.cartView()),
+	)
+	bottom := lipgloss.JoinHorizontal(lipgloss.Top,
+		m.renderPane(tablesPane, paneWidth, "Tables", m.tablesView()),
+		m.renderPane(kitchenPane, paneWidth, "Kitchen queue", m.kitchenView()),
+	)
+
+	footer := helpStyle.Render("tab: switch pane • ↑/↓: move • enter: select • 8: 86 item • +/-: quantity • x: remove • h: hold/unhold • </>: seat • f: free/occupy table, fire held course • t: takeaway • s: send order • P/R: park/recall cart • a/r: allergy/rush flag • e: item note • o: order note • n: customer • p: check in by phone • 1-5: add favorite • q: quit")
+	if m.editingName {
+		footer = "Customer name: " + m.nameInput + "█  (enter to confirm, esc to cancel)"
+	}
+	if m.editingPhone {
+		footer = "Phone number: " + m.phoneInput + "█  (enter to check in, esc to cancel)"
+	}
+	if m.editingNote {
+		footer = "Note: " + m.noteInput + "█  (enter to save, esc to cancel)"
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, top, bottom, statusStyle.Render(m.status), footer)
+}
+
+// renderPane draws a bordered pane, highlighting it when focused
+func (m tuiModel) renderPane(p pane, width int, title, body string) string {
+	style := paneStyle
+	if m.focus == p {
+		style = focusedPaneStyle
+	}
+	return style.Width(width).Render(titleStyle.Render(title) + "\n" + body)
+}
+
+func (m tuiModel) menuView() string {
+	var b strings.Builder
+	for i, item := range m.menu {
+		line := fmt.Sprintf("%2d. %-16s Rs %8.2f", i+1, item.Name, item.Price)
+		if IsSoldOut(item, clock.Now()) {
+			line = soldOutStyle.Render(line + " 86")
+		}
+		if m.focus == menuPane && i == m.menuCursor {
+			line = selectedStyle.Render(line)
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+func (m tuiModel) cartView() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Customer: %s\n", m.customerName)
+	if m.flag != nil {
+		b.WriteString(occupiedTableStyle.Render(fmt.Sprintf("Flagged by %s: %s", m.flag.FlaggedBy, m.flag.Reason)) + "\n")
+	}
+	if len(m.favorites) > 0 {
+		var favorites []string
+		for i, favorite := range m.favorites {
+			favorites = append(favorites, fmt.Sprintf("%d %s (%d)", i+1, favorite.Item.Name, favorite.Count))
+		}
+		b.WriteString(helpStyle.Render("Favorites: "+strings.Join(favorites, ", ")) + "\n")
+	}
+	if m.takeaway {
+		b.WriteString("Takeaway\n")
+	} else if m.table > 0 {
+		fmt.Fprintf(&b, "Table: %d\n", m.table)
+	} else {
+		b.WriteString("Table: counter\n")
+	}
+	if m.orderNotes != "" {
+		b.WriteString("Notes: " + m.orderNotes + "\n")
+	}
+	b.WriteString("\n")
+	if len(m.cart) == 0 {
+		b.WriteString(helpStyle.Render("Empty - add items from the menu") + "\n")
+	}
+	for i, line := range m.cart {
+		text := fmt.Sprintf("%-16s x%-3d Rs %8.2f", line.Name, line.Quantity, line.Amount())
+		if line.Seat > SharedSeat {
+			text += fmt.Sprintf(" seat %d", line.Seat)
+		}
+		if lineCourse(line) > FirstCourse {
+			text += " held"
+		}
+		if m.focus == cartPane && i == m.cartCursor {
+			text = selectedStyle.Render(text)
+		}
+		if len(line.Flags) > 0 {
+			text += " " + lineFlagStyle.Render(strings.Join(line.Flags, " "))
+		}
+		b.WriteString(text + "\n")
+		if line.Note != "" {
+			b.WriteString(helpStyle.Render("  "+line.Note) + "\n")
+		}
+	}
+	fmt.Fprintf(&b, "\nTotal: Rs %.2f", CartTotal(m.cart))
+	if calories := CartCalories(m.cart); calories > 0 {
+		fmt.Fprintf(&b, ", %d kcal", calories)
+	}
+	return b.String()
+}
+
+func (m tuiModel) tablesView() string {
+	var rows []string
+	var row []string
+	for i, table := range m.tables {
+		style := freeTableStyle
+		if table.Occupied {
+			style = occupiedTableStyle
+		}
+		cell := fmt.Sprintf("T%-2d %dp", table.Number, table.Seats)
+		if m.focus == tablesPane && i == m.tableCursor {
+			cell = selectedStyle.Render(cell)
+		} else {
+			cell = style.Render(cell)
+		}
+		row = append(row, cell)
+		if len(row) == tablesPerRow || i == len(m.tables)-1 {
+			rows = append(rows, strings.Join(row, "  "))
+			row = nil
+		}
+	}
+	if len(rows) == 0 {
+		return helpStyle.Render("No tables configured")
+	}
+	return strings.Join(rows, "\n\n") + "\n\n" + freeTableStyle.Render("free") + "  " + occupiedTableStyle.Render("occupied")
+}
+
+func (m tuiModel) kitchenView() string {
+	if len(m.queue) == 0 {
+		return helpStyle.Render("No open orders")
+	}
+	var b strings.Builder
+	for i, order := range m.queue {
+		where := "counter"
+		if order.Token > 0 {
+			where = fmt.Sprintf("takeaway #%d", order.Token)
+		} else if order.Table > 0 {
+			where = fmt.Sprintf("table %d", order.Table)
+		}
+		header := fmt.Sprintf("%s  %-10s %s (%s)", order.CreatedAt.Format("15:04"), order.Status, order.CustomerName, where)
+		if m.focus == kitchenPane && i == m.queueCursor {
+			header = selectedStyle.Render(header)
+		}
+		if orderFlagged(order, LineFlagRush) {
+			header = lineFlagStyle.Render(LineFlagRush) + " " + header
+		}
+		b.WriteString(header + "\n")
+		if order.Notes != "" {
+			b.WriteString("  Notes: " + order.Notes + "\n")
+		}
+		for _, line := range order.Items {
+			text := fmt.Sprintf("    %d x %s", line.Quantity, line.Name)
+			if line.Weight > 0 {
+				text += fmt.Sprintf(" (%.3f kg each)", line.Weight)
+			}
+			if line.Note != "" {
+				text += " - " + line.Note
+			}
+			if LineHeld(order, line) {
+				text = helpStyle.Render(fmt.Sprintf("%s (held, course %d)", text, lineCourse(line)))
+			}
+			if len(line.Flags) > 0 {
+				text += " " + lineFlagStyle.Render(strings.Join(line.Flags, " "))
+			}
+			b.WriteString(text + "\n")
+		}
+	}
+	return b.String()
+}
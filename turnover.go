@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// TableTurnover is how one table was used over a period
+type TableTurnover struct {
+	Table          int     `json:"table"`
+	Seats          int     `json:"seats"`
+	Sittings       int     `json:"sittings"`
+	AverageMinutes float64 `json:"averageMinutes"` // From seating a party to freeing the table, for parties that have left
+	Revenue        float64 `json:"revenue"`
+}
+
+// HourlyOccupancy is how full the tables were during one hour of the day, over every day of a period
+type HourlyOccupancy struct {
+	Hour      int     `json:"hour"`
+	Occupancy float64 `json:"occupancy"` // Percentage of the seat time that was taken
+	Revenue   float64 `json:"revenue"`
+	RevPASH   float64 `json:"revPASH"` // Revenue per available seat hour
+}
+
+// TurnoverReport is how quickly tables turned over and how much they earned in [From, To). Only the hours of the
+// day in which a table was taken or an order placed count as available, so closed hours do not drag the rates down.
+type TurnoverReport struct {
+	From           time.Time         `json:"from"`
+	To             time.Time         `json:"to"`
+	Sittings       int               `json:"sittings"`
+	AverageMinutes float64           `json:"averageMinutes"`
+	Occupancy      float64           `json:"occupancy"`
+	RevPASH        float64           `json:"revPASH"`
+	Tables         []TableTurnover   `json:"tables"`
+	Hours          []HourlyOccupancy `json:"hours"`
+}
+
+// TableTurnoverReport works out table turnover, occupancy by hour of the day and revenue per available seat hour
+// for parties seated and dine-in orders placed in [from, to). A party still seated counts as occupying its table
+// until now.
+func TableTurnoverReport(ctx context.Context, from, to time.Time) (TurnoverReport, error) {
+	tables, err := LoadTables(ctx)
+	if err != nil {
+		return TurnoverReport{}, err
+	}
+	sittings, err := storeFor(ctx).Sittings().ListBetween(ctx, from, to)
+	if err != nil {
+		return TurnoverReport{}, err
+	}
+	orders, err := LoadOrdersBetween(ctx, from, to)
+	if err != nil {
+		return TurnoverReport{}, err
+	}
+
+	report := TurnoverReport{From: from, To: to, Tables: []TableTurnover{}, Hours: []HourlyOccupancy{}}
+	byTable := map[int]*TableTurnover{}
+	seats := 0
+	for _, table := range tables {
+		byTable[table.Number] = &TableTurnover{Table: table.Number, Seats: table.Seats}
+		seats += table.Seats
+	}
+	rowFor := func(number int) *TableTurnover {
+		if byTable[number] == nil {
+			// A table removed since
+			byTable[number] = &TableTurnover{Table: number}
+		}
+		return byTable[number]
+	}
+
+	var seatMinutes, revenue [24]float64
+	var turnMinutes float64
+	var turns int
+	closesAt := earliest(clock.Now(), to)
+	for _, sitting := range sittings {
+		row := rowFor(sitting.Table)
+		row.Sittings++
+		report.Sittings++
+		end := closesAt
+		if sitting.VacatedAt != nil {
+			end = earliest(*sitting.VacatedAt, to)
+			minutes := sitting.VacatedAt.Sub(sitting.SeatedAt).Minutes()
+			row.AverageMinutes += minutes
+			turnMinutes += minutes
+			turns++
+		}
+		for start := sitting.SeatedAt; start.Before(end); {
+			next := time.Date(start.Year(), start.Month(), start.Day(), start.Hour()+1, 0, 0, 0, start.Location())
+			seatMinutes[start.Hour()] += earliest(next, end).Sub(start).Minutes() * float64(sitting.Seats)
+			start = next
+		}
+	}
+	for _, order := range orders {
+		if order.Table == 0 || order.Type == OrderTakeaway || staffMeal(order) {
+			continue
+		}
+		rowFor(order.Table).Revenue += order.Total
+		revenue[order.CreatedAt.Hour()] += order.Total
+	}
+
+	for _, row := range byTable {
+		if closed := row.Sittings - openSittings(sittings, row.Table); closed > 0 {
+			row.AverageMinutes = roundPaise(row.AverageMinutes / float64(closed))
+		}
+		row.Revenue = roundPaise(row.Revenue)
+		report.Tables = append(report.Tables, *row)
+	}
+	slices.SortFunc(report.Tables, func(a, b TableTurnover) int { return a.Table - b.Table })
+	if turns > 0 {
+		report.AverageMinutes = roundPaise(turnMinutes / float64(turns))
+	}
+
+	days := max(to.Sub(from).Hours()/24, 1)
+	var totalSeatMinutes, totalRevenue float64
+	for hour := range 24 {
+		if seatMinutes[hour] == 0 && revenue[hour] == 0 {
+			continue
+		}
+		row := HourlyOccupancy{Hour: hour, Revenue: roundPaise(revenue[hour])}
+		if seats > 0 {
+			row.Occupancy = roundPaise(seatMinutes[hour] * 100 / (float64(seats) * 60 * days))
+			row.RevPASH = roundPaise(revenue[hour] / (float64(seats) * days))
+		}
+		report.Hours = append(report.Hours, row)
+		totalSeatMinutes += seatMinutes[hour]
+		totalRevenue += revenue[hour]
+	}
+	if seats > 0 && len(report.Hours) > 0 {
+		available := float64(seats) * days * float64(len(report.Hours))
+		report.Occupancy = roundPaise(totalSeatMinutes * 100 / (available * 60))
+		report.RevPASH = roundPaise(totalRevenue / available)
+	}
+	return report, nil
+}
+
+// openSittings counts the sittings at the table whose party has not left yet
+func openSittings(sittings []TableSitting, table int) int {
+	count := 0
+	for _, sitting := range sittings {
+		if sitting.Table == table && sitting.VacatedAt == nil {
+			count++
+		}
+	}
+	return count
+}
+
+// ShowTableTurnover prints the turnover of each table and the occupancy of each hour in [from, to)
+func ShowTableTurnover(ctx context.Context, from, to time.Time) error {
+	report, err := TableTurnoverReport(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		printResult("", report)
+		return nil
+	}
+	fmt.Printf("%d sittings from %s to %s, %.0f minutes on average; %.1f%% occupancy, RevPASH Rs %.2f\n",
+		report.Sittings, from.Format("02 Jan 2006"), to.AddDate(0, 0, -1).Format("02 Jan 2006"),
+		report.AverageMinutes, report.Occupancy, report.RevPASH)
+	var tableRows [][]string
+	for _, table := range report.Tables {
+		tableRows = append(tableRows, []string{
+			fmt.Sprint(table.Table), fmt.Sprint(table.Seats), fmt.Sprint(table.Sittings),
+			fmt.Sprintf("%.0f min", table.AverageMinutes), fmt.Sprintf("Rs %.2f", table.Revenue),
+		})
+	}
+	if err := writeTable(os.Stdout, []string{"Table", "Seats", "Sittings", "Average stay", "Revenue"}, tableRows); err != nil {
+		return err
+	}
+	var hourRows [][]string
+	for _, hour := range report.Hours {
+		hourRows = append(hourRows, []string{
+			fmt.Sprintf("%02d:00", hour.Hour), fmt.Sprintf("%.1f%%", hour.Occupancy),
+			fmt.Sprintf("Rs %.2f", hour.Revenue), fmt.Sprintf("Rs %.2f", hour.RevPASH),
+		})
+	}
+	return writeTable(os.Stdout, []string{"Hour", "Occupancy", "Revenue", "RevPASH"}, hourRows)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TOTP codes as authenticator apps make them (RFC 6238): six digits from HMAC-SHA1, a new one every 30 seconds.
+// A code from the step before or after is accepted too, for clocks a little apart.
+const (
+	totpStep   = 30 * time.Second
+	totpDigits = 6
+	totpSkew   = 1
+)
+
+// backupCodeCount is how many one-off codes an admin gets for when they do not have their phone
+const backupCodeCount = 10
+
+var (
+	// ErrWrongCode is returned when a TOTP or backup code does not match, or was used before
+	ErrWrongCode = errors.New("wrong or already used code")
+	// ErrTwoFactorRequired is returned when an admin key is used without a verified session
+	ErrTwoFactorRequired = errors.New("two-factor verification required")
+)
+
+// requireTwoFactor makes admin keys enroll in two-factor authentication before they can be used, from RMS_REQUIRE_2FA
+var requireTwoFactor bool
+
+// codeFailures locks out keys by id after too many wrong codes
+var codeFailures = newFailureLock()
+
+// TwoFactor is an admin key's authenticator enrollment. It only protects the key once a first code confirms it.
+type TwoFactor struct {
+	KeyID       primitive.ObjectID `bson:"_id" json:"keyId"`
+	Secret      string             `bson:"secret" json:"secret"`           // Base32, as entered in the authenticator app
+	BackupCodes []string           `bson:"backupCodes" json:"backupCodes"` // Hashes of the backup codes not used yet
+	EnabledAt   *time.Time         `bson:"enabledAt,omitempty" json:"enabledAt,omitempty"`
+	LastStep    int64              `bson:"lastStep" json:"lastStep"` // Time step of the last code accepted, so no code works twice
+	Version     int                `bson:"version" json:"version"`
+}
+
+// TwoFactorEnrollment is what an admin needs to set up their authenticator app, shown only once
+type TwoFactorEnrollment struct {
+	Secret      string   `json:"secret"`
+	URL         string   `json:"url"` // otpauth:// link, for a QR code
+	BackupCodes []string `json:"backupCodes"`
+}
+
+// isAdminKey reports whether the key can manage keys, which makes it an admin's
+func isAdminKey(record APIKey) bool {
+	return record.Allows(ScopeKeysManage)
+}
+
+// totpCode returns the code for a time step
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// randomHex returns n random bytes as hex
+func randomHex(n int) (string, error) {
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// EnrollTwoFactor starts two-factor authentication for an admin key, returning the secret for their
+// authenticator app and their backup codes. Enrolling again before confirming starts over; once confirmed, it
+// must be turned off first.
+func EnrollTwoFactor(ctx context.Context, record APIKey) (TwoFactorEnrollment, error) {
+	if !isAdminKey(record) {
+		return TwoFactorEnrollment{}, fmt.Errorf("two-factor authentication is for admin keys, with the %s scope", ScopeKeysManage)
+	}
+	existing, err := store.TwoFactor().Find(ctx, record.ID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return TwoFactorEnrollment{}, err
+	}
+	if existing.EnabledAt != nil {
+		return TwoFactorEnrollment{}, fmt.Errorf("two-factor authentication is already on for this key; turn it off first")
+	}
+
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+	enrollment := TwoFactorEnrollment{Secret: base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)}
+	twoFactor := TwoFactor{KeyID: record.ID, Secret: enrollment.Secret, Version: existing.Version}
+	for range backupCodeCount {
+		code, err := randomHex(5)
+		if err != nil {
+			return TwoFactorEnrollment{}, err
+		}
+		code = code[:5] + "-" + code[5:]
+		enrollment.BackupCodes = append(enrollment.BackupCodes, code)
+		twoFactor.BackupCodes = append(twoFactor.BackupCodes, hashAPIKey(code))
+	}
+	label := url.PathEscape("RMS:" + record.Name)
+	enrollment.URL = fmt.Sprintf("otpauth://totp/%s?secret=%s&issuer=RMS&digits=%d&period=%d", label, enrollment.Secret, totpDigits, int(totpStep.Seconds()))
+	return enrollment, store.TwoFactor().Save(ctx, twoFactor)
+}
+
+// checkCode accepts a current TOTP code, or with allowBackup one of the backup codes, using it up
+func checkCode(twoFactor *TwoFactor, code string, allowBackup bool) bool {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(twoFactor.Secret)
+	if err != nil {
+		return false
+	}
+	now := time.Now().Unix() / int64(totpStep.Seconds())
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step > twoFactor.LastStep && hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			twoFactor.LastStep = step
+			return true
+		}
+	}
+	if i := slices.Index(twoFactor.BackupCodes, hashAPIKey(strings.ToLower(code))); allowBackup && i >= 0 {
+		twoFactor.BackupCodes = slices.Delete(twoFactor.BackupCodes, i, i+1)
+		return true
+	}
+	return false
+}
+
+// useCode checks a code against the key's enrollment, returning it with the code used up for the caller to store.
+// Wrong codes count towards locking the key out for a while.
+func useCode(ctx context.Context, record APIKey, code string, allowBackup bool) (TwoFactor, error) {
+	if err := codeFailures.check(record.ID.Hex(), "this key"); err != nil {
+		return TwoFactor{}, err
+	}
+	twoFactor, err := store.TwoFactor().Find(ctx, record.ID)
+	if errors.Is(err, ErrNotFound) {
+		return TwoFactor{}, fmt.Errorf("this key is not enrolled in two-factor authentication")
+	}
+	if err != nil {
+		return TwoFactor{}, err
+	}
+	if !checkCode(&twoFactor, code, allowBackup) {
+		codeFailures.fail(record.ID.Hex())
+		return TwoFactor{}, ErrWrongCode
+	}
+	codeFailures.succeed(record.ID.Hex())
+	return twoFactor, nil
+}
+
+// ConfirmTwoFactor turns two-factor authentication on for the key with a first code from the authenticator app
+func ConfirmTwoFactor(ctx context.Context, record APIKey, code string) (TwoFactor, error) {
+	twoFactor, err := useCode(ctx, record, code, false)
+	if err != nil {
+		return TwoFactor{}, err
+	}
+	if twoFactor.EnabledAt == nil {
+		now := time.Now()
+		twoFactor.EnabledAt = &now
+	}
+	if err := store.TwoFactor().Save(ctx, twoFactor); err != nil {
+		return TwoFactor{}, err
+	}
+	log.Printf("Two-factor authentication turned on for API key %s (%s)", record.Prefix, record.Name)
+	twoFactor.Version++
+	return twoFactor, nil
+}
+
+// VerifyTwoFactor checks a code or backup code for the key and starts a session at the terminal, returning its
+// token. The token is sent in the X-Session header along with the key.
+func VerifyTwoFactor(ctx context.Context, record APIKey, code, terminal string) (string, Session, error) {
+	twoFactor, err := useCode(ctx, record, code, true)
+	if err != nil {
+		return "", Session{}, err
+	}
+	if twoFactor.EnabledAt == nil {
+		return "", Session{}, fmt.Errorf("confirm two-factor authentication with a first code before verifying")
+	}
+	if err := store.TwoFactor().Save(ctx, twoFactor); err != nil {
+		return "", Session{}, err
+	}
+	return startSession(ctx, record, terminal, true)
+}
+
+// DisableTwoFactor turns two-factor authentication off for the key, with a current code or a backup code
+func DisableTwoFactor(ctx context.Context, record APIKey, code string) error {
+	if _, err := useCode(ctx, record, code, true); err != nil {
+		return err
+	}
+	return ResetTwoFactor(ctx, record.ID)
+}
+
+// ResetTwoFactor removes a key's enrollment, e.g. when an admin has lost both their phone and backup codes
+func ResetTwoFactor(ctx context.Context, id primitive.ObjectID) error {
+	if err := store.TwoFactor().Delete(ctx, id); err != nil {
+		return err
+	}
+	log.Printf("Two-factor authentication turned off for API key %s", id.Hex())
+	return nil
+}
+
+// twoFactorEnabled reports whether the key is an admin's with two-factor authentication turned on
+func twoFactorEnabled(ctx context.Context, record APIKey) (bool, error) {
+	if !isAdminKey(record) {
+		return false, nil
+	}
+	twoFactor, err := store.TwoFactor().Find(ctx, record.ID)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil && twoFactor.EnabledAt != nil, err
+}
+
+// checkTwoFactor lets an admin key through only in a session verified by a second factor, once the key is enrolled.
+// With RMS_REQUIRE_2FA an admin key that is not enrolled can do nothing but enroll.
+func checkTwoFactor(ctx context.Context, record APIKey, session *Session) error {
+	enabled, err := twoFactorEnabled(ctx, record)
+	switch {
+	case err != nil:
+		return err
+	case enabled && (session == nil || !session.TwoFactor):
+		return ErrTwoFactorRequired
+	case !enabled && isAdminKey(record) && requireTwoFactor:
+		return fmt.Errorf("admin keys must enroll in two-factor authentication first, at /api/2fa/enroll")
+	}
+	return nil
+}
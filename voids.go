@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// wasteLine returns wasted with the quantity of the line being voided from lines added to it
+func wasteLine(wasted, lines []OrderLine, void ItemVoid) []OrderLine {
+	i := findLine(lines, void.Name, void.Course, void.Seat)
+	if i < 0 {
+		return wasted
+	}
+	line := lines[i]
+	line.Quantity = min(void.Quantity, line.Quantity)
+	return append(append([]OrderLine(nil), wasted...), line)
+}
+
+// writeOffVoid writes off the stock used for a line voided after it went to the kitchen, at the last price paid
+// for it: each of its recipe's ingredients, or the item itself by the piece
+func writeOffVoid(ctx context.Context, order Order, line OrderLine) error {
+	costs, err := stockCosts(ctx)
+	if err != nil {
+		return err
+	}
+	used := []RecipeIngredient{{Ingredient: strings.ToLower(line.Name), Unit: "piece", Quantity: 1}}
+	if item, found := FindMenuItem(LoadMenu(ctx), line.Name); found && len(item.Recipe) > 0 {
+		used = item.Recipe
+	}
+	now := clock.Now()
+	for _, ingredient := range used {
+		quantity := ingredient.Quantity * float64(line.Quantity)
+		value := roundPaise(quantity * costs[stockKey(ingredient.Ingredient, ingredient.Unit)])
+		err := storeFor(ctx).Stock().AddWriteOff(ctx, StockWriteOff{
+			ID: primitive.NewObjectID(), Branch: order.Branch, Name: ingredient.Ingredient, Unit: ingredient.Unit,
+			Quantity: quantity, Value: value, WrittenOffAt: now, Reason: WriteOffVoided, OrderID: order.ID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VoidEntry is quantity of a line voided off an order
+type VoidEntry struct {
+	OrderID    primitive.ObjectID `json:"orderId"`
+	At         time.Time          `json:"at"`
+	Item       string             `json:"item"`
+	Seat       int                `json:"seat,omitempty"`
+	Quantity   int                `json:"quantity"`
+	Reason     string             `json:"reason"`
+	By         string             `json:"by,omitempty"`
+	ApprovedBy string             `json:"approvedBy,omitempty"`
+	Value      float64            `json:"value"`
+}
+
+// VoidReport lists the lines voided over a period: those voided after they went to the kitchen, which needed a
+// manager's override and wasted what was made, apart from those removed before they were sent
+type VoidReport struct {
+	From         time.Time   `json:"from"`
+	To           time.Time   `json:"to"`
+	Voids        []VoidEntry `json:"voids"`
+	VoidValue    float64     `json:"voidValue"`
+	Removals     []VoidEntry `json:"removals"`
+	RemovalValue float64     `json:"removalValue"`
+}
+
+// BuildVoidReport lists the lines voided in [from, to), oldest first
+func BuildVoidReport(ctx context.Context, from, to time.Time) (VoidReport, error) {
+	events, err := storeFor(ctx).Events().ListSince(ctx, from)
+	if err != nil {
+		return VoidReport{}, err
+	}
+	report := VoidReport{From: from, To: to, Voids: []VoidEntry{}, Removals: []VoidEntry{}}
+	for _, event := range events {
+		if event.Type != EventItemVoided || !event.At.Before(to) {
+			continue
+		}
+		entry := VoidEntry{
+			OrderID: event.OrderID, At: event.At, Item: event.Void.Name, Seat: event.Void.Seat, Quantity: event.Void.Quantity,
+			Reason: event.Reason, By: event.By, ApprovedBy: event.ApprovedBy, Value: event.Void.Value,
+		}
+		if event.Void.Sent {
+			report.Voids = append(report.Voids, entry)
+			report.VoidValue = roundPaise(report.VoidValue + entry.Value)
+		} else {
+			report.Removals = append(report.Removals, entry)
+			report.RemovalValue = roundPaise(report.RemovalValue + entry.Value)
+		}
+	}
+	return report, nil
+}
+
+// ShowVoidReport prints the lines voided in [from, to), those voided after they were sent first
+func ShowVoidReport(ctx context.Context, from, to time.Time) error {
+	report, err := BuildVoidReport(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	title := fmt.Sprintf("Voids from %s to %s:", from.Format("02 Jan 2006"), to.AddDate(0, 0, -1).Format("02 Jan 2006"))
+	voidListing := listing{
+		title:   title,
+		header:  []string{"Kind", "Time", "Order", "Item", "Reason", "By", "Approved by", "Value"},
+		records: report,
+	}
+	add := func(kind string, entries []VoidEntry, total float64) {
+		for _, entry := range entries {
+			item := fmt.Sprintf("%d x %s", entry.Quantity, entry.Item)
+			value := fmt.Sprintf("Rs %.2f", entry.Value)
+			voidListing.rows = append(voidListing.rows, []string{
+				kind, entry.At.Format("02 Jan 15:04"), entry.OrderID.Hex(), item, entry.Reason, entry.By, entry.ApprovedBy, value,
+			})
+			voidListing.compact = append(voidListing.compact, fmt.Sprintf("%s %s %s %s", kind, entry.At.Format("02 Jan 15:04"), item, value))
+		}
+		voidListing.rows = append(voidListing.rows, []string{kind, "Total", "", fmt.Sprintf("%d voided", len(entries)), "", "", "", fmt.Sprintf("Rs %.2f", total)})
+	}
+	add("After sending", report.Voids, report.VoidValue)
+	add("Before sending", report.Removals, report.RemovalValue)
+	return printListing(os.Stdout, voidListing)
+}
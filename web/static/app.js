@@ -0,0 +1,387 @@
+// Manager dashboard: every panel reads from and writes to the JSON API.
+const ordersRefreshMs = 5000;
+
+// In SaaS mode the API needs the tenant's key; it is asked for once and kept in the browser.
+const apiKeyStorage = "rmsApiKey";
+// Keys that must sign in (admins with two-factor authentication, cashiers kept to one terminal) also need a
+// session, kept for the tab.
+const sessionStorageKey = "rmsSession";
+const terminalName = "dashboard";
+
+async function api(method, path, body) {
+  const options = { method, headers: {} };
+  if (body !== undefined) {
+    options.headers["Content-Type"] = "application/json";
+    options.body = JSON.stringify(body);
+  }
+  const key = localStorage.getItem(apiKeyStorage);
+  if (key) {
+    options.headers["Authorization"] = "Bearer " + key;
+  }
+  const session = sessionStorage.getItem(sessionStorageKey);
+  if (session) {
+    options.headers["X-Session"] = session;
+  }
+  const response = await fetch(path, options);
+  const denied = response.status === 401 ? await response.clone().json() : {};
+  if (denied.twoFactor) {
+    const code = prompt("Code from your authenticator app, or a backup code:");
+    if (code) {
+      const verified = await api("POST", "/api/2fa/verify", { code: code.trim(), terminal: terminalName });
+      sessionStorage.setItem(sessionStorageKey, verified.token);
+      return api(method, path, body);
+    }
+  }
+  if (denied.session) {
+    sessionStorage.removeItem(sessionStorageKey);
+    const started = await api("POST", "/api/sessions", { terminal: terminalName });
+    sessionStorage.setItem(sessionStorageKey, started.token);
+    return api(method, path, body);
+  }
+  if (response.status === 401) {
+    const entered = prompt("API key for this restaurant:");
+    if (entered) {
+      localStorage.setItem(apiKeyStorage, entered.trim());
+      return api(method, path, body);
+    }
+  }
+  if (response.status === 204) {
+    return null;
+  }
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(data.error || response.statusText);
+  }
+  return data;
+}
+
+function showMessage(text) {
+  const message = document.getElementById("message");
+  message.textContent = text;
+  message.style.display = "block";
+  setTimeout(() => { message.style.display = "none"; }, 3000);
+}
+
+function cell(row, text) {
+  const td = document.createElement("td");
+  td.textContent = text;
+  row.appendChild(td);
+  return td;
+}
+
+async function loadOrders() {
+  const board = document.getElementById("orders-board");
+  const orders = await api("GET", "/api/orders");
+  board.replaceChildren();
+  if (orders.length === 0) {
+    board.textContent = "No open orders";
+    return;
+  }
+  for (const order of orders) {
+    const ticket = document.createElement("div");
+    ticket.className = "ticket " + order.status;
+    const where = order.table ? "Table " + order.table : "Counter";
+    const title = document.createElement("strong");
+    title.textContent = order.customerName + " · " + where;
+    if (order.items.some((line) => (line.flags || []).includes("RUSH"))) {
+      ticket.classList.add("rush");
+    }
+    ticket.appendChild(title);
+    if (order.notes) {
+      const notes = document.createElement("p");
+      notes.className = "notes";
+      notes.textContent = order.notes;
+      ticket.appendChild(notes);
+    }
+
+    // Lines in a course that has not been fired yet are held back from the kitchen
+    const held = new Set((order.courses || []).filter((c) => !c.firedAt).map((c) => c.course));
+    const items = document.createElement("ul");
+    for (const line of order.items) {
+      const li = document.createElement("li");
+      li.textContent = line.quantity + " x " + line.name;
+      if (held.has(Math.max(line.course || 1, 1))) {
+        li.className = "held";
+        li.textContent += " (held)";
+      }
+      for (const flag of line.flags || []) {
+        const badge = document.createElement("span");
+        badge.className = "flag " + flag.toLowerCase();
+        badge.textContent = flag;
+        li.prepend(badge);
+      }
+      if (line.note) {
+        const note = document.createElement("span");
+        note.className = "notes";
+        note.textContent = " - " + line.note;
+        li.appendChild(note);
+      }
+      items.appendChild(li);
+    }
+    ticket.appendChild(items);
+
+    for (const course of held) {
+      const fire = document.createElement("button");
+      fire.textContent = "Fire course " + course;
+      fire.onclick = async () => {
+        try {
+          await api("POST", "/api/orders/" + order.id + "/courses/" + course + "/fire");
+          loadOrders();
+        } catch (err) {
+          showMessage(err.message);
+        }
+      };
+      ticket.appendChild(fire);
+    }
+
+    const advance = document.createElement("button");
+    advance.textContent = order.status + " →";
+    advance.onclick = async () => {
+      try {
+        await api("POST", "/api/orders/" + order.id + "/advance");
+        loadOrders();
+      } catch (err) {
+        showMessage(err.message);
+      }
+    };
+    ticket.appendChild(advance);
+    board.appendChild(ticket);
+  }
+}
+
+// The 86 board lists every item; one click takes it off for the rest of the day or brings it back.
+async function loadSoldOut() {
+  const today = new Date().toLocaleDateString("en-CA");
+  const menu = await api("GET", "/api/menu?all=true");
+  const board = document.getElementById("sold-out-board");
+  board.replaceChildren();
+  for (const item of menu) {
+    const soldOut = item.soldOutOn === today;
+    const toggle = document.createElement("button");
+    toggle.className = "toggle" + (soldOut ? " sold-out" : "");
+    toggle.textContent = item.name;
+    toggle.title = soldOut ? "86'd - click to bring back" : "Click to 86 for the rest of the day";
+    toggle.onclick = async () => {
+      try {
+        await api(soldOut ? "DELETE" : "POST", "/api/menu/" + encodeURIComponent(item.name) + "/sold-out");
+        loadSoldOut();
+      } catch (err) {
+        showMessage(err.message);
+      }
+    };
+    board.appendChild(toggle);
+  }
+
+  const report = await api("GET", "/api/reports/sold-out");
+  const rows = document.getElementById("sold-out-rows");
+  rows.replaceChildren();
+  for (const entry of report) {
+    const row = document.createElement("tr");
+    cell(row, entry.item);
+    cell(row, entry.count);
+    cell(row, new Date(entry.last).toLocaleString());
+    rows.appendChild(row);
+  }
+}
+
+// The drawer panel shows either the open session or the form to open one, and the recent sessions
+async function loadDrawer() {
+  let session = null;
+  try {
+    session = await api("GET", "/api/drawer");
+  } catch (err) {
+    // 404: the drawer is closed
+  }
+  const status = document.getElementById("drawer-status");
+  document.getElementById("drawer-open-form").hidden = session !== null;
+  document.getElementById("drawer-movement-form").hidden = session === null;
+  document.getElementById("drawer-close-form").hidden = session === null;
+  if (session) {
+    status.textContent = session.cashier + " since " + new Date(session.openedAt).toLocaleTimeString() +
+      " · float Rs " + session.openingFloat.toFixed(2) + " · cash sales Rs " + session.cashSales.toFixed(2) +
+      " · expected Rs " + session.expected.toFixed(2);
+  } else {
+    status.textContent = "The drawer is closed";
+  }
+
+  const sessions = await api("GET", "/api/reports/drawer-sessions");
+  const rows = document.getElementById("drawer-rows");
+  rows.replaceChildren();
+  for (const past of sessions.reverse()) {
+    if (past.open) {
+      continue;
+    }
+    const row = document.createElement("tr");
+    if (past.flagged) {
+      row.className = "flagged";
+    }
+    cell(row, past.cashier);
+    cell(row, new Date(past.openedAt).toLocaleString());
+    cell(row, past.expected.toFixed(2));
+    cell(row, past.counted.toFixed(2));
+    cell(row, past.variance.toFixed(2));
+    rows.appendChild(row);
+  }
+}
+
+async function loadToday() {
+  const summary = await api("GET", "/api/reports/dashboard");
+  document.getElementById("today-summary").textContent =
+    summary.orders + " orders · Rs " + summary.revenue.toFixed(2) + " · " + summary.openOrders + " open";
+  const list = document.getElementById("today-top-items");
+  list.replaceChildren();
+  for (const item of summary.topItems) {
+    const entry = document.createElement("li");
+    entry.textContent = item.name + " × " + item.quantity;
+    list.appendChild(entry);
+  }
+}
+
+async function loadSales() {
+  const date = document.getElementById("sales-date").value;
+  const sales = await api("GET", "/api/reports/daily-sales" + (date ? "?date=" + date : ""));
+  const chart = document.getElementById("sales-chart");
+  const peak = Math.max(1, ...sales.map((hour) => hour.revenue));
+  let orders = 0;
+  let revenue = 0;
+  chart.replaceChildren();
+  for (const hour of sales) {
+    orders += hour.orders;
+    revenue += hour.revenue;
+    const bar = document.createElement("div");
+    bar.className = "bar";
+    bar.style.height = (100 * hour.revenue / peak) + "%";
+    bar.title = hour.hour + ":00 · " + hour.orders + " orders · Rs " + hour.revenue.toFixed(2);
+    if (hour.hour % 3 === 0) {
+      const label = document.createElement("span");
+      label.textContent = hour.hour;
+      bar.appendChild(label);
+    }
+    chart.appendChild(bar);
+  }
+  document.getElementById("sales-summary").textContent =
+    orders + " orders · Rs " + revenue.toFixed(2);
+}
+
+async function loadMenu() {
+  const rows = document.getElementById("menu-rows");
+  const menu = await api("GET", "/api/menu?all=true");
+  rows.replaceChildren();
+  for (const item of menu) {
+    const row = document.createElement("tr");
+    cell(row, item.name);
+    const priceCell = cell(row, "");
+    const price = document.createElement("input");
+    price.type = "number";
+    price.step = "0.01";
+    price.value = item.price.toFixed(2);
+    price.onchange = async () => {
+      try {
+        await api("PUT", "/api/menu/" + encodeURIComponent(item.name), { price: parseFloat(price.value) });
+        showMessage("Updated " + item.name);
+      } catch (err) {
+        showMessage(err.message);
+        price.value = item.price.toFixed(2);
+      }
+    };
+    priceCell.appendChild(price);
+
+    const removeCell = cell(row, "");
+    const remove = document.createElement("button");
+    remove.textContent = "Remove";
+    remove.onclick = async () => {
+      if (!confirm("Remove " + item.name + " from the menu?")) {
+        return;
+      }
+      try {
+        await api("DELETE", "/api/menu/" + encodeURIComponent(item.name));
+        loadMenu();
+      } catch (err) {
+        showMessage(err.message);
+      }
+    };
+    removeCell.appendChild(remove);
+    rows.appendChild(row);
+  }
+}
+
+async function searchCustomers() {
+  const query = document.getElementById("customer-search").value;
+  const customers = await api("GET", "/api/customers?q=" + encodeURIComponent(query));
+  const rows = document.getElementById("customer-rows");
+  rows.replaceChildren();
+  for (const customer of customers) {
+    const row = document.createElement("tr");
+    cell(row, customer.name);
+    cell(row, customer.phone);
+    cell(row, (customer.orderedItems || []).length);
+    cell(row, customer.totalAmount.toFixed(2));
+    rows.appendChild(row);
+  }
+}
+
+document.getElementById("menu-form").onsubmit = async (event) => {
+  event.preventDefault();
+  const form = event.target;
+  try {
+    await api("POST", "/api/menu", { name: form.name.value, price: parseFloat(form.price.value) });
+    form.reset();
+    loadMenu();
+  } catch (err) {
+    showMessage(err.message);
+  }
+};
+
+document.getElementById("drawer-open-form").onsubmit = async (event) => {
+  event.preventDefault();
+  const form = event.target;
+  try {
+    await api("POST", "/api/drawer/open", { cashier: form.cashier.value, openingFloat: parseFloat(form.openingFloat.value) });
+    form.reset();
+    loadDrawer();
+  } catch (err) {
+    showMessage(err.message);
+  }
+};
+
+document.getElementById("drawer-movement-form").onsubmit = async (event) => {
+  event.preventDefault();
+  const form = event.target;
+  try {
+    await api("POST", "/api/drawer/movements", { type: form.type.value, amount: parseFloat(form.amount.value), reason: form.reason.value });
+    form.reset();
+    loadDrawer();
+  } catch (err) {
+    showMessage(err.message);
+  }
+};
+
+document.getElementById("drawer-close-form").onsubmit = async (event) => {
+  event.preventDefault();
+  const form = event.target;
+  try {
+    const session = await api("POST", "/api/drawer/close", { counted: parseFloat(form.counted.value) });
+    form.reset();
+    showMessage(session.flagged ? "Drawer closed with a variance of Rs " + session.variance.toFixed(2) : "Drawer closed");
+    loadDrawer();
+  } catch (err) {
+    showMessage(err.message);
+  }
+};
+
+let searchTimer;
+document.getElementById("customer-search").oninput = () => {
+  clearTimeout(searchTimer);
+  searchTimer = setTimeout(searchCustomers, 250);
+};
+document.getElementById("sales-date").onchange = loadSales;
+
+loadToday();
+loadOrders();
+loadSoldOut();
+loadDrawer();
+loadSales();
+loadMenu();
+searchCustomers();
+setInterval(loadOrders, ordersRefreshMs);
+setInterval(loadToday, ordersRefreshMs);
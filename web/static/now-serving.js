@@ -0,0 +1,38 @@
+// Pickup display: polls the now-serving endpoint and highlights tokens that just became ready.
+const refreshMs = 3000;
+let readyBefore = null;
+
+// A screen for a hosted (SaaS) restaurant is opened as /now-serving?key=<API key>
+const apiKey = new URLSearchParams(location.search).get("key");
+const headers = apiKey ? { Authorization: "Bearer " + apiKey } : {};
+
+function render(listId, tokens, highlight) {
+  const list = document.getElementById(listId);
+  list.replaceChildren();
+  for (const token of tokens) {
+    const li = document.createElement("li");
+    li.textContent = token;
+    if (highlight && readyBefore !== null && !readyBefore.has(token)) {
+      li.className = "new";
+    }
+    list.appendChild(li);
+  }
+}
+
+async function refresh() {
+  try {
+    const response = await fetch("/api/now-serving", { headers });
+    if (!response.ok) {
+      return;
+    }
+    const serving = await response.json();
+    render("preparing", serving.preparing, false);
+    render("ready", serving.ready, true);
+    readyBefore = new Set(serving.ready);
+  } catch (err) {
+    // Keep showing the last known tokens until the server is reachable again
+  }
+}
+
+refresh();
+setInterval(refresh, refreshMs);